@@ -3,13 +3,16 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"reflect"
 	"sync"
+	"time"
 )
 
 // Agent represents a Claude Code session.
 type Agent struct {
 	cfg               *config
-	proc              *process
+	proc              cliTransport // Replaced by AutoRestart between runs; a.mu guards the swap
 	bridge            *bridge
 	hookChain         *hookChain
 	postToolUseChain  *postToolUseChain
@@ -17,11 +20,58 @@ type Agent struct {
 	subagentStopChain *subagentStopChain
 	promptSubmitChain *promptSubmitChain
 	auditor           *auditor
+	scrub             *scrubber // Redacts secrets from errors and audit events
+	runValues         runValues // RunValue values of the latest run, for Stop hooks
 	sessionID         string
 	totalTurns        int     // Cumulative turns across all Run() calls
 	totalCost         float64 // Cumulative cost across all Run() calls
 	stopReason        StopReason
-	pendingToolCalls  map[string]*ToolCall // Tool calls awaiting results
+	stopCause         string                    // Why the last run was cut short, with its stopReason
+	cancelRun         context.CancelCauseFunc   // Ends the run in progress (nil between runs)
+	cancelled         *CancelledError           // Why the latest run was cut short (nil = it was not)
+	pendingToolCalls  map[string]*ToolCall      // Tool calls awaiting results
+	toolLimiter       *toolLimiter              // Concurrency limits for custom tools
+	toolTimings       map[string]toolTiming     // Custom tool timings keyed by tool use ID
+	updatedInputs     map[string]map[string]any // PreToolUse input rewrites keyed by tool use ID
+	runChanges        *changeTracker            // File changes for the current run
+	runDenials        *denialTracker            // PreToolUse denials for the current run
+	lastRunChanges    []FileChange              // File changes from the most recent completed run
+	runID             string                    // ID of the run in progress (empty between runs)
+	contextUsage      *contextTracker           // Context window usage as of the latest result
+	labels            map[string]string         // Labels attached to audit and stop events; replaced, never mutated
+	runLabels         map[string]string         // Labels of the run in progress, over labels (nil between runs)
+	runDefaults       []RunOption               // Applied before each call's RunOptions; replaced, never mutated
+	controls          *controlWaiters           // SendControl requests awaiting responses
+	hookPool          *hookPool                 // Workers for AsyncHooks (nil = synchronous)
+	configWarnings    []string                  // Likely option mistakes found by config.validate
+	costs             *costEstimator            // Streaming cost estimates (nil = off)
+	thinking          *thinkingSink             // Where Thinking content is diverted (nil = off)
+	state             *stateFile                // Crash recovery state (nil = off)
+	activeSubagents   map[string]ActiveSubagent // Running configured subagents by Task tool_use ID
+	preserved         string                    // Summary from PreserveOnCompact awaiting the next prompt
+	pendingHints      []string                  // ReactOnToolResult hints awaiting the next prompt
+	runReactions      int                       // ReactOnToolResult firings in the current run
+	delivered         int                       // DeliveredSequence of the last message delivered
+	runSizes          runSizes                  // Prompt and output sizes of the current run
+	runCosts          costAttribution           // Estimated tokens of the current run's tool results
+	costRates         *ModelRates               // Prices for CostBreakdown (nil = unknown model)
+	countTokens       func(text string) int     // The TokenCounter bound to the model
+	resultErr         error                     // The current run's Result failed RequireNonEmptyResult or RefusalDetector
+	awaitingResult    bool                      // A prompt was sent and its Result has not been read
+	owedResults       int                       // Extra Results due for messages written during the run
+	initSeen          bool                      // OnInit hooks were called for the current CLI process
+	treePrimed        bool                      // A prompt was sent in this session, so PrimeWithFileTree is done
+	stats             Stats                     // Totals across completed runs
+	labeledStats      map[string]*LabeledStats  // Totals across completed runs by label set
+	streams           sync.WaitGroup            // Stream goroutines still forwarding messages
+	closing           chan struct{}             // Closed by Close to stop in-flight streams
+	closedStream      bool                      // A stream was cut short or refused by Close
+	idleTimer         *time.Timer               // Fires evictIdle (nil = no IdleTimeout)
+	idleSince         time.Time                 // When the last run ended, or New returned
+	evicted           *EvictedError             // Set when IdleTimeout closed the agent
+	startCtx          context.Context           // Context given to New; restarted processes run under it
+	restartMu         sync.Mutex                // Serializes AutoRestart restarts
+	restarts          []time.Time               // When AutoRestart restarted the CLI; restartMu guards it
 	mu                sync.Mutex
 	closed            bool
 }
@@ -49,30 +99,70 @@ type userContentItem struct {
 // before outputting anything (including init). The session ID is captured
 // lazily when the first message is sent.
 func New(ctx context.Context, opts ...Option) (*Agent, error) {
-	cfg := newConfig(opts...)
+	return newAgent(ctx, newConfig(opts...))
+}
 
-	// Check for schema errors (deferred from WithSchema option)
-	if cfg.schemaError != nil {
-		return nil, cfg.schemaError
+// newAgent starts an agent from a prepared configuration.
+//
+// The auditor is created first so that audit handlers see every start,
+// including a failed one: session.start_attempt is emitted before the CLI
+// is spawned, and session.start_failed before an error is returned.
+func newAgent(ctx context.Context, cfg *config) (*Agent, error) {
+	// Create auditor from config
+	aud := newAuditor(cfg.auditHandlers)
+	aud.setClock(cfg.auditClock)
+	scrub := newScrubber(cfg.scrubPatterns)
+	aud.setScrubber(scrub)
+	labels := copyLabels(cfg.labels)
+	aud.setLabels(labels)
+
+	var pool *hookPool
+	if cfg.asyncHooks != nil {
+		pool = newHookPool(cfg.asyncHooks)
+		aud.setPool(pool)
 	}
 
-	proc, err := startProcess(ctx, cfg)
+	// The worktree and scratch directory are passed to the CLI, so they are
+	// created first; a relative ScratchDirIn is inside the worktree
+	err := createWorktree(ctx, cfg)
+	if err == nil {
+		err = createScratch(cfg)
+	}
+	aud.emit("", "session.start_attempt", startAttemptData(cfg))
+
+	mcp := newManagedMCP(cfg, aud)
+	var proc cliTransport
+	var warnings []string
+	if err == nil {
+		proc, warnings, err = startAgent(ctx, cfg, mcp)
+	}
 	if err != nil {
+		_ = removeScratch(cfg) // Best effort cleanup
+		_ = removeFork(cfg)    // Best effort cleanup
+		discardWorktree(cfg)
+		err = scrub.scrubError(err)
+		aud.emit("", "session.start_failed", startFailedData(err))
+		if pool != nil {
+			pool.close() // Deliver the events above before the handlers are cleaned up
+		}
+		aud.flushBatches()
+		for _, cleanup := range cfg.auditCleanup {
+			_ = cleanup() // Best effort cleanup
+		}
 		return nil, err
 	}
 
-	bridge := newBridge(proc.reader())
+	controls := newControlWaiters()
+	bridge := newAgentBridge(cfg, aud, proc, controls)
 
 	// Create hook chains from config
-	chain := newHookChain(cfg.preToolUseHooks)
+	chain := newHookChain(cfg.sortedPreToolUseHooks())
+	chain.rewrite = scratchRedirect(cfg)
 	postChain := newPostToolUseChain(cfg.postToolUseHooks)
 	preCompact := newPreCompactChain(cfg.preCompactHooks)
 	subagentStop := newSubagentStopChain(cfg.subagentStopHooks)
 	promptSubmit := newPromptSubmitChain(cfg.userPromptSubmitHooks)
 
-	// Create auditor from config
-	aud := newAuditor(cfg.auditHandlers)
-
 	agent := &Agent{
 		cfg:               cfg,
 		proc:              proc,
@@ -83,109 +173,409 @@ func New(ctx context.Context, opts ...Option) (*Agent, error) {
 		subagentStopChain: subagentStop,
 		promptSubmitChain: promptSubmit,
 		auditor:           aud,
+		scrub:             scrub,
 		stopReason:        StopCompleted, // Default to completed
 		pendingToolCalls:  make(map[string]*ToolCall),
+		toolLimiter:       newToolLimiter(cfg.maxConcurrentTools, cfg.customTools),
+		toolTimings:       make(map[string]toolTiming),
+		updatedInputs:     make(map[string]map[string]any),
+		runChanges:        newChangeTracker(),
+		runDenials:        newDenialTracker(),
+		contextUsage:      newContextTracker(cfg),
+		labels:            labels,
+		runDefaults:       append([]RunOption(nil), cfg.runDefaults...),
+		controls:          controls,
+		hookPool:          pool,
+		configWarnings:    warnings,
+		costs:             newCostEstimator(cfg),
+		costRates:         breakdownRates(cfg),
+		countTokens:       cfg.countTokens(),
+		thinking:          newThinkingSink(cfg.thinking),
+		state:             newStateFile(cfg.stateDir, aud, labels),
+		startCtx:          ctx,
+		closing:           make(chan struct{}),
 	}
 
+	mcp.setAgent(agent)
+	agent.startIdleTimer()
+
 	// Emit session.start event (sessionID captured later)
 	agent.auditor.emit("", "session.start", nil)
+	for _, w := range warnings {
+		agent.auditor.emit("", "config.warning", map[string]any{"warning": w})
+	}
 
 	return agent, nil
 }
 
+// startAgent validates the configuration and starts the CLI transport. It
+// returns the warnings from validation, which are reported once the agent
+// exists.
+func startAgent(ctx context.Context, cfg *config, mcp *managedMCP) (cliTransport, []string, error) {
+	// Check for schema errors (deferred from WithSchema option)
+	if cfg.schemaError != nil {
+		return nil, nil, cfg.schemaError
+	}
+	if err := lintSchemas(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateFileTree(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	if err := normalizeModels(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateSubagents(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateManagedMCP(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateMCPTools(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateDenialNote(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateScrub(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateThinkingMode(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := validateRestartPolicy(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// Suspect but usable options are reported once the auditor exists
+	warnings := cfg.validate()
+	conflicts, err := checkConflicts(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings = append(warnings, conflicts...)
+
+	if cfg.replay == nil && cfg.cliPath == "" {
+		path, warning, err := lookupCLI(cfg.noCLICache)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.resolvedCLI = path
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	if cfg.replay == nil {
+		path := cfg.cliPath
+		if path == "" {
+			path = cfg.resolvedCLI
+		}
+		if err := verifyCLI(cfg, path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := validateCredentials(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	// Prepare a truncated transcript to resume (deferred from ForkFrom)
+	if err := prepareFork(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	proc, err := startTransport(ctx, cfg, mcp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return proc, warnings, nil
+}
+
+// newAgentBridge returns the bridge reading messages from proc.
+func newAgentBridge(cfg *config, aud *auditor, proc cliTransport, controls *controlWaiters) *bridge {
+	dec := NewDecoder(proc.reader(), DecoderMaxLineBytes(cfg.maxLineBytes))
+	dec.parser.skipInitLists = aud == nil && len(cfg.mcpStatusHooks) == 0 && len(cfg.initHooks) == 0 // Only session.init, OnMCPStatus, and OnInit read them
+	dec.parser.keepRaw = cfg.keepRaw
+	return newBridge(dec, controls.deliver)
+}
+
+// startAttemptData describes the CLI invocation New is about to make. MCP
+// server env and header values, which commonly hold credentials, are
+// redacted; APIKey and OAuthToken are passed in the environment and never
+// appear.
+func startAttemptData(cfg *config) map[string]any {
+	args := buildArgs(cfg)
+	for i := 1; i < len(args); i++ {
+		if args[i-1] == "--mcp-config" {
+			args[i] = scrubMCPConfig(args[i])
+		}
+	}
+	return map[string]any{
+		"cli_path": cfg.cliPath,
+		"work_dir": cfg.workDir,
+		"args":     args,
+	}
+}
+
+// startFailedData describes an error returned by New.
+func startFailedData(err error) map[string]any {
+	data := map[string]any{"error": err.Error()}
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		data["reason"] = startErr.Reason
+		if startErr.Cause != nil {
+			data["cause"] = startErr.Cause.Error()
+		}
+	}
+	var procErr *ProcessError
+	if errors.As(err, &procErr) && procErr.Stderr != "" {
+		data["stderr"] = procErr.Stderr
+	}
+	return data
+}
+
 // Stream sends a prompt and returns a channel of messages.
 // The channel closes when the result is received or an error occurs.
-// Call Err() after the channel closes to check for errors.
+// Call Err() after the channel closes to check for errors, or use
+// StreamRun for a handle that reports this run's own error.
+//
+// If Close is called while the stream is in flight, the stream ends with
+// an *Error wrapping ErrAgentClosed, unless the consumer has stopped
+// reading, and Err returns ErrAgentClosed. Streaming on a closed agent
+// returns a closed channel.
 func (a *Agent) Stream(ctx context.Context, prompt string, opts ...RunOption) <-chan Message {
+	return a.stream(ctx, promptSource{text: prompt}, opts).Messages()
+}
+
+// stream starts a run for a text prompt or a prompt reader.
+func (a *Agent) stream(ctx context.Context, src promptSource, opts []RunOption) *Run {
 	out := make(chan Message, 32)
+	run := newRun(out)
+	rc := a.runConfig(opts)
+
+	if err := a.checkPromptSize(src); err != nil {
+		return a.failStream(run, out, err)
+	}
+	if err := a.restartIfExited(); err != nil {
+		return a.failStream(run, out, err)
+	}
 
 	a.mu.Lock()
 
-	if a.closed {
+	if a.closed || a.evicted != nil {
+		a.closedStream = true
+		err := a.closedErrLocked()
 		a.mu.Unlock()
 		close(out)
-		return out
+		run.finish(err)
+		return run
 	}
 
-	// Call UserPromptSubmit hooks before sending
+	// Start a new run; every audit event until the run ends carries its ID
+	runID := run.RunID()
+	a.runID = runID
+	a.suspendIdleLocked()
+	a.cancelled = nil
+	a.auditor.setRunID(runID)
+	a.runLabels = rc.labels
+	a.auditor.setRunLabels(rc.labels)
+	values := rc.values
+	a.runValues = values
+	runTools := rc.tools
+
+	// Carry context preserved at the last compaction into this prompt
+	preserved := a.preserved
+	a.preserved = ""
+
+	// Hints that missed their turn are cleared once this prompt is sent
+	hints := a.pendingHints
+
 	sessionID := a.sessionID
 	turn := a.totalTurns + 1
 	a.mu.Unlock()
 
-	finalPrompt, metadata := a.callPromptSubmitHooks(prompt, sessionID, turn)
+	// Hooks and cost estimates need the text; otherwise a reader is
+	// escaped straight into the message
+	if src.body != nil && a.needsPromptText() {
+		text, err := readPrompt(src.body, a.cfg.maxPromptBytes)
+		if err != nil {
+			a.abandonRun(runID, preserved)
+			return a.failStream(run, out, err)
+		}
+		src = promptSource{text: text}
+	}
 
-	a.mu.Lock()
-	// Send prompt as JSON
-	msg := userMessage{
-		Type: "user",
-		Message: userContent{
-			Role: "user",
-			Content: []userContentItem{
-				{Type: "text", Text: finalPrompt},
-			},
-		},
-	}
-	data, err := json.Marshal(msg)
+	tree := a.fileTreeBlock(sessionID)
+	var originalPrompt, prompt, finalPrompt string
+	var metadata []any
+	var compression map[string]any
+	var data []byte
+	var bodyBytes, promptBytes int
+	var err error
+	if src.body == nil {
+		originalPrompt, prompt = src.text, src.text
+		// Fit the caller's prompt to its budget before the hooks see it
+		if a.cfg.promptBudget != nil {
+			prompt, compression, err = a.cfg.promptBudget.fit(ctx, prompt, a.cfg.countTokens())
+			if err != nil {
+				a.abandonRun(runID, preserved)
+				return a.failStream(run, out, err)
+			}
+		}
+		prompt = withReactionHints(hints, prompt)
+		if preserved != "" {
+			prompt = withPreservedContext(preserved, prompt)
+		}
+		prompt = tree + prompt
+		// Call UserPromptSubmit hooks before sending
+		finalPrompt, metadata = a.callPromptSubmitHooks(ctx, prompt, sessionID, runID, turn, values)
+		if a.cfg.schemaInPrompt() {
+			finalPrompt = withSchemaInstructions(finalPrompt, a.cfg.jsonSchema)
+		}
+		data = marshalUserMessage(finalPrompt)
+		promptBytes = len(finalPrompt)
+	} else {
+		prefix := withReactionHints(hints, "")
+		if preserved != "" {
+			prefix = withPreservedContext(preserved, prefix)
+		}
+		prefix = tree + prefix
+		data, bodyBytes, err = encodeUserMessage(prefix, src.body, a.cfg.maxPromptBytes)
+		promptBytes = len(prefix) + bodyBytes
+	}
 	if err != nil {
+		a.abandonRun(runID, preserved)
+		return a.failStream(run, out, err)
+	}
+
+	a.mu.Lock()
+	// Close may have run while the hooks were called
+	if a.closed {
+		a.closedStream = true
+		a.endRunLocked(runID)
+		err := a.closedErrLocked()
 		a.mu.Unlock()
 		close(out)
-		return out
+		run.finish(err)
+		return run
 	}
-	data = append(data, '\n')
 
 	if err := a.proc.write(data); err != nil {
+		a.endRunLocked(runID)
 		a.mu.Unlock()
 		close(out)
-		return out
+		run.finish(err)
+		return run
+	}
+
+	a.awaitingResult = true
+	a.treePrimed = true
+	a.pendingHints = a.pendingHints[len(hints):]
+	if len(a.pendingHints) == 0 {
+		a.pendingHints = nil
 	}
 
-	// Emit prompt event
-	a.auditor.emit(a.sessionID, "message.prompt", map[string]any{
+	// Start tracking file changes, denials, and reactions for this run
+	a.runReactions = 0
+	a.owedResults = 0
+	a.runChanges = newChangeTracker()
+	denials := newDenialTracker()
+	a.runDenials = denials
+	a.runSizes = runSizes{prompt: promptBytes}
+	a.runCosts = costAttribution{}
+	a.resultErr = nil
+	if a.costs != nil {
+		a.costs.startRun(finalPrompt)
+	}
+
+	// Emit prompt event; a streamed reader body is reported by size
+	promptEvent := map[string]any{
 		"prompt":          prompt,
 		"final_prompt":    finalPrompt,
 		"prompt_modified": finalPrompt != prompt,
 		"prompt_metadata": metadata,
-	})
+	}
+	if src.body != nil {
+		promptEvent["prompt_bytes"] = bodyBytes
+	}
+	if compression != nil {
+		promptEvent["prompt_compression"] = compression
+	}
+	a.auditor.emit(a.sessionID, "message.prompt", promptEvent)
 
+	// Cancel ends the run through its context
+	ctx, cancelRun := context.WithCancelCause(ctx)
+	a.cancelRun = cancelRun
+
+	// Close waits for this goroutine before tearing down the process
+	a.streams.Add(1)
 	a.mu.Unlock()
 
+	a.state.startRun(sessionID, runID, finalPrompt)
+
+	// A soft deadline also bounds the run at the deadline plus grace
+	ctx, cancel := withHardDeadline(ctx, rc)
+
+	// Tools report progress on out from their own goroutines
+	progress := newProgressSink(out, rc)
+	ctx = context.WithValue(ctx, progressSinkKey{}, progress)
+
 	// Forward messages until Result or context cancellation
 	go func() {
-		defer close(out)
+		// Done closes last, once the run has ended and out is closed
+		var runErr error
+		defer func() { run.finish(runErr) }()
+		defer a.streams.Done()
+		defer progress.close()
+		defer cancelRun(nil)
+		defer cancel()
+		defer func() {
+			a.abandonSubagents()
+			a.mu.Lock()
+			a.endRunLocked(runID)
+			a.mu.Unlock()
+		}()
+		deadline := newDeadlineWatch(rc)
+		defer deadline.stop()
+		beat := newHeartbeatWatch(a.cfg.heartbeat)
+		defer beat.stop()
+		outcome := "exited"
+		defer func() { a.deadlineOutcome(deadline, outcome) }()
+		var budgetErr *BudgetError
+		var answered []*Result // Results of turns that did not end the run
 		for {
 			select {
+			case <-deadline.warn():
+				a.warnDeadline(deadline)
+			case <-beat.beat():
+				a.heartbeat(beat, out, rc)
 			case msg, ok := <-a.bridge.recv():
 				if !ok {
+					runErr = a.exitError()
 					return
 				}
+				beat.seen(msg)
 
 				// Capture session ID from SystemInit (sent after first message with stream-json)
 				if init, isInit := msg.(*SystemInit); isInit {
-					a.mu.Lock()
-					if a.sessionID == "" {
-						a.sessionID = init.SessionID
-					}
-					sessionID := a.sessionID
-					a.mu.Unlock()
-					// Emit session.init event
-					a.auditor.emit(sessionID, "session.init", map[string]any{
-						"transcript_path": init.TranscriptPath,
-						"tools":           init.Tools,
-						"mcp_servers":     init.MCPServers,
-					})
+					a.handleInit(init)
 					// Don't send SystemInit to caller
 					continue
 				}
+				a.adoptSessionID(msg)
 
 				// Handle control requests internally
 				if ctrlReq, isCtrl := msg.(*ControlRequestMsg); isCtrl {
+					// OnControlRequest handlers may answer before the default handling
+					if a.handleRawControlRequest(ctx, ctrlReq) {
+						continue
+					}
 					req := &ControlRequest{
 						RequestID: ctrlReq.RequestID,
 						Type:      ctrlReq.Type,
-						Tool: &ToolCall{
-							Name:  ctrlReq.ToolName,
-							Input: ctrlReq.ToolInput,
-						},
+						ToolUseID: ctrlReq.ToolUseID,
+						Tool:      a.controlToolCall(ctrlReq, values, runTools),
 					}
 					// Ignore error - best effort response
 					_ = a.handleControlRequest(ctx, req)
@@ -204,61 +594,323 @@ func (a *Agent) Stream(ctx context.Context, prompt string, opts ...RunOption) <-
 					continue
 				}
 
+				// A message written during the turn, such as the SoftDeadline
+				// warning, is answered with a turn of its own; the last
+				// Result ends the run and accounts for the earlier ones
+				if result, isResult := msg.(*Result); isResult && a.takeOwedResult() {
+					answered = append(answered, result)
+					continue
+				}
+
+				// Tag the result with the run it completes
+				if result, isResult := msg.(*Result); isResult {
+					mergeTurns(result, answered)
+					result.RunID = runID
+					result.OriginalPrompt = originalPrompt
+					result.Prompt = finalPrompt
+					result.PromptMetadata = metadata
+					result.SoftDeadlineHit = deadline.fired()
+					result.Denials = denials.snapshot()
+					result.denialErr = a.denialError(denials)
+					result.hookErr = a.interruptError(denials, result)
+					result.checkErr = a.checkResult(result)
+					a.mu.Lock()
+					a.awaitingResult = false
+					switch {
+					case result.hookErr != nil:
+						a.resultErr = result.hookErr
+						a.stopReason = StopDenied // For Run and Stream alike
+					case result.checkErr != nil:
+						a.resultErr = result.checkErr
+					}
+					a.mu.Unlock()
+					run.setResult(result)
+					outcome = "completed"
+				}
+
 				// Track pending tool calls and call PostToolUse hooks
-				a.processMessageHooks(msg)
+				a.processMessageHooks(ctx, msg, values)
+				switch m := msg.(type) {
+				case *ToolUse, *ToolResult:
+					a.state.setPending(a.pendingToolUseIDs())
+				case *Result:
+					a.state.finishRun(m, a.pendingToolUseIDs())
+				}
 
 				// Emit message events based on type
 				a.emitMessageEvent(msg)
 
+				// Estimate the run's cost as messages arrive; reconcile at the end
+				if a.costs != nil {
+					if err := a.estimateCost(msg); err != nil {
+						budgetErr = err
+					}
+					if result, isResult := msg.(*Result); isResult {
+						result.budgetErr = budgetErr
+						a.auditor.emit(a.SessionID(), "cost.reconciled", a.costs.reconcile(result))
+					}
+				}
+
+				// Divert thinking content; the audit event above is unaffected
+				if th, isThinking := msg.(*Thinking); isThinking && a.thinking != nil {
+					a.divertThinking(th, runID)
+					if a.thinking.suppressed() {
+						continue
+					}
+				}
+
+				// Filtered messages are processed above but not delivered
+				if !rc.delivers(msg) {
+					continue
+				}
+
+				seq := a.numberDelivered(msg)
 				select {
 				case out <- msg:
 				case <-ctx.Done():
-					a.mu.Lock()
-					a.stopReason = StopInterrupted
-					a.mu.Unlock()
+					a.undeliver(seq)
+					runErr = a.streamCancelled(ctx)
+					outcome = deadline.stopOutcome(ctx)
+					return
+				case <-a.closing:
+					a.undeliver(seq)
+					runErr = a.streamClosed(out)
+					outcome = "closed"
 					return
 				}
 				// Stop after Result
-				if _, isResult := msg.(*Result); isResult {
+				if result, isResult := msg.(*Result); isResult {
+					runErr = result.checkErr
 					return
 				}
 			case <-ctx.Done():
-				a.mu.Lock()
-				a.stopReason = StopInterrupted
-				a.mu.Unlock()
+				cerr := a.streamCancelled(ctx)
 				a.auditor.emit(a.sessionID, "error", map[string]any{
-					"error": ctx.Err().Error(),
+					"error":       ctx.Err().Error(),
+					"stop_reason": string(cerr.Reason),
+					"cause":       cerr.Cause,
 				})
+				runErr = cerr
+				outcome = deadline.stopOutcome(ctx)
+				return
+			case <-a.closing:
+				runErr = a.streamClosed(out)
+				outcome = "closed"
 				return
 			}
 		}
 	}()
 
-	return out
+	return run
+}
+
+// abandonRun ends a run whose prompt could not be sent. Preserved context
+// is kept for the next prompt.
+func (a *Agent) abandonRun(runID, preserved string) {
+	a.mu.Lock()
+	if a.preserved == "" {
+		a.preserved = preserved
+	}
+	a.endRunLocked(runID)
+	a.mu.Unlock()
+}
+
+// failStream ends a run whose prompt could not be sent with an *Error.
+func (a *Agent) failStream(run *Run, out chan Message, err error) *Run {
+	a.auditor.emit(a.SessionID(), "error", map[string]any{
+		"error": err.Error(),
+	})
+	out <- &Error{Err: err}
+	close(out)
+	run.finish(err)
+	return run
+}
+
+// exitError returns the error of a run whose CLI output ended before its
+// Result: the output error, the CLI's classified or plain exit error, or a
+// *TaskError if it exited cleanly.
+func (a *Agent) exitError() error {
+	proc, bridge, sessionID := a.transport()
+	if err := bridge.error(); err != nil {
+		return err
+	}
+	if code, stderr, exited := proc.exitStatus(exitWait); exited && code != 0 {
+		stderr = a.scrub.scrub(stderr)
+		perr := &ProcessError{ExitCode: code, Stderr: stderr, scrub: a.scrub}
+		if err := classifyError(stderr, perr); err != nil {
+			return err
+		}
+		return perr
+	}
+	return &TaskError{SessionID: sessionID, Message: "no result received"}
+}
+
+// streamClosed ends a stream cut short by Close and returns the run's
+// error. The final *Error is dropped if the consumer is not keeping up; Err
+// still reports it.
+func (a *Agent) streamClosed(out chan<- Message) error {
+	a.mu.Lock()
+	a.stopReason = StopShutdown
+	a.stopCause = ""
+	a.closedStream = true
+	sessionID := a.sessionID
+	cerr := &CancelledError{Reason: StopShutdown, SessionID: sessionID, Err: ErrAgentClosed}
+	a.cancelled = cerr
+	a.mu.Unlock()
+	a.auditor.emit(sessionID, "error", map[string]any{
+		"error":       ErrAgentClosed.Error(),
+		"stop_reason": string(StopShutdown),
+	})
+	select {
+	case out <- &Error{Err: cerr}:
+	default:
+	}
+	return cerr
+}
+
+// controlToolCall builds the ToolCall for a control request. Parent context
+// missing from the request is taken from the matching tool_use, if seen.
+func (a *Agent) controlToolCall(req *ControlRequestMsg, values runValues, runTools *runToolPolicy) *ToolCall {
+	tc := &ToolCall{
+		Name:            req.ToolName,
+		Input:           req.ToolInput,
+		ID:              req.ToolUseID,
+		ParentToolUseID: req.ParentToolUseID,
+		SubagentType:    req.SubagentType,
+		values:          values,
+		runTools:        runTools,
+	}
+
+	a.mu.Lock()
+	if pending, ok := a.pendingToolCalls[req.ToolUseID]; ok && req.ToolUseID != "" {
+		if tc.ParentToolUseID == "" {
+			tc.ParentToolUseID = pending.ParentToolUseID
+		}
+		// Share the subagent type with PostToolUse hooks
+		if pending.SubagentType == "" {
+			pending.SubagentType = tc.SubagentType
+			pending.AgentKind = agentKindFor(pending.ParentToolUseID, pending.SubagentType)
+		}
+	}
+	a.mu.Unlock()
+
+	tc.AgentKind = agentKindFor(tc.ParentToolUseID, tc.SubagentType)
+	return tc
+}
+
+// endRunLocked clears the current run if it is still the given run.
+// Events emitted afterwards carry an empty RunID. Caller must hold a.mu.
+func (a *Agent) endRunLocked(runID string) {
+	if a.runID == runID {
+		a.runID = ""
+		a.cancelRun = nil
+		a.resumeIdleLocked()
+		a.auditor.setRunID("")
+		a.runLabels = nil
+		a.auditor.setRunLabels(nil)
+	}
+}
+
+// oweResult records that a message was written to the CLI during the run,
+// which the CLI answers with a turn and a Result of its own.
+func (a *Agent) oweResult() {
+	a.mu.Lock()
+	a.owedResults++
+	a.mu.Unlock()
+}
+
+// takeOwedResult reports whether a Result is still owed and, if so, counts
+// one as received.
+func (a *Agent) takeOwedResult() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.owedResults == 0 {
+		return false
+	}
+	a.owedResults--
+	return true
+}
+
+// mergeTurns adds the duration, turns, cost, and usage of the earlier
+// turns of a run to its final Result.
+func mergeTurns(result *Result, earlier []*Result) {
+	for _, r := range earlier {
+		result.DurationTotal += r.DurationTotal
+		result.DurationAPI += r.DurationAPI
+		result.NumTurns += r.NumTurns
+		result.CostUSD += r.CostUSD
+		result.Usage.InputTokens += r.Usage.InputTokens
+		result.Usage.OutputTokens += r.Usage.OutputTokens
+		result.Usage.CacheRead += r.Usage.CacheRead
+		result.Usage.CacheWrite += r.Usage.CacheWrite
+	}
 }
 
 // processMessageHooks handles lifecycle hook processing for messages.
 // It tracks pending tool calls and calls PostToolUse hooks when results arrive.
-func (a *Agent) processMessageHooks(msg Message) {
+// Hooks see the context and RunValue values of the run that received msg.
+func (a *Agent) processMessageHooks(ctx context.Context, msg Message, values runValues) {
 	switch m := msg.(type) {
+	case *Text:
+		a.mu.Lock()
+		a.runSizes.add(m)
+		a.mu.Unlock()
+
 	case *ToolUse:
 		// Track pending tool call for later PostToolUse hook
 		a.mu.Lock()
+		if _, dup := a.pendingToolCalls[m.ID]; dup {
+			a.mu.Unlock()
+			break // Already registered by an earlier copy of the message
+		}
 		a.pendingToolCalls[m.ID] = &ToolCall{
-			Name:  m.Name,
-			Input: m.Input,
+			Name:            m.Name,
+			Input:           m.Input,
+			ID:              m.ID,
+			ParentToolUseID: m.ParentToolUseID,
+			AgentKind:       agentKindFor(m.ParentToolUseID, ""),
+			ctx:             ctx,
+			values:          values,
 		}
 		a.mu.Unlock()
+		a.startSubagent(m)
 
 	case *ToolResult:
+		// A Task's result also ends its subagent
+		a.stopSubagent(m.ToolUseID)
+
+		// Counted before locking, as a TokenCounter may be slow
+		tokens := a.countTokens(contentText(m.Content))
+
 		// Find the pending tool call
 		a.mu.Lock()
 		tc, found := a.pendingToolCalls[m.ToolUseID]
 		if found {
+			a.runCosts.add(tc, a.pendingToolCalls, tokens)
 			delete(a.pendingToolCalls, m.ToolUseID)
 		}
+		timing, timed := a.toolTimings[m.ToolUseID]
+		if timed {
+			delete(a.toolTimings, m.ToolUseID)
+		}
+		updates := a.updatedInputs[m.ToolUseID]
+		delete(a.updatedInputs, m.ToolUseID)
+		a.runSizes.add(m)
+		var changed *FileChangeEvent
+		if found {
+			// Record file changes using the effective (post-hook) input
+			input := mergeInputs(tc.Input, updates)
+			a.runChanges.record(tc.Name, input, m.IsError)
+			if path, ok := changedPath(tc.Name, input); ok && len(a.cfg.fileChangedHooks) > 0 {
+				changed = &FileChangeEvent{Path: path, OriginalPath: a.runChanges.originalPath(path), Tool: tc.Name, ToolUseID: m.ToolUseID, IsError: m.IsError, RunID: a.runID}
+			}
+		}
 		a.mu.Unlock()
 
+		if changed != nil {
+			a.runHook(func() { a.callFileChangedHooks(*changed) })
+		}
+
 		if found {
 			// Build result context
 			resultCtx := &ToolResultContext{
@@ -266,26 +918,119 @@ func (a *Agent) processMessageHooks(msg Message) {
 				Content:   m.Content,
 				IsError:   m.IsError,
 				Duration:  m.Duration,
+				values:    values,
+			}
+			// Custom tools report SDK-measured timings rather than the CLI's
+			if timed {
+				resultCtx.Duration = timing.exec
+				resultCtx.QueueDuration = timing.queue
 			}
 
-			// Call PostToolUse hooks
-			a.postToolUseChain.evaluate(tc, resultCtx)
+			// Call PostToolUse hooks; with AsyncHooks they finish after the
+			// audit event, so they are not timed
+			var durations []time.Duration
+			if len(a.cfg.postToolUseHooks) > 0 {
+				if a.hookPool == nil {
+					durations = a.postToolUseChain.evaluateTimed(tc, resultCtx, a.timeHooks())
+				} else {
+					a.runHook(func() { a.postToolUseChain.evaluate(tc, resultCtx) })
+				}
+			}
 
 			// Emit audit event
 			a.auditor.emit(a.sessionID, "hook.post_tool_use", map[string]any{
-				"tool":        tc.Name,
-				"input":       tc.Input,
-				"is_error":    resultCtx.IsError,
-				"duration":    resultCtx.Duration.String(),
-				"tool_use_id": resultCtx.ToolUseID,
+				"hook_durations":     a.checkHookDurations("post_tool_use", tc, durations),
+				"tool":               tc.Name,
+				"input":              tc.Input,
+				"is_error":           resultCtx.IsError,
+				"duration":           resultCtx.Duration.String(),
+				"queue_duration":     resultCtx.QueueDuration.String(),
+				"tool_use_id":        resultCtx.ToolUseID,
+				"parent_tool_use_id": tc.ParentToolUseID,
+				"agent_kind":         string(tc.AgentKind),
+				"subagent_type":      tc.SubagentType,
 			})
+
+			// Reactions may send a hint for Claude to read in this turn
+			a.react(tc, resultCtx)
 		}
 
 	case *Result:
-		// Accumulate cost
+		// Accumulate cost and sizes and attach the run's file changes
 		a.mu.Lock()
 		a.totalCost += m.CostUSD
+		a.runSizes.finish(m, &a.stats)
+		a.addLabeledStats(m)
+		m.FileChanges = a.runChanges.snapshot()
+		m.PathRedirects = a.runChanges.redirectSnapshot()
+		m.CostBreakdown = a.runCosts.finish(a.costRates)
+		a.lastRunChanges = m.FileChanges
+		crossed := a.contextUsage.update(m.Usage, m.Turn)
+		usage := a.contextUsage.usage()
+		sessionID := a.sessionID
 		a.mu.Unlock()
+
+		a.callContextUsageHooks(sessionID, usage, crossed)
+
+		if len(m.FileChanges) > 0 {
+			a.auditor.emit(sessionID, "run.file_changes", map[string]any{
+				"files": m.FileChanges,
+			})
+		}
+		if m.duplicates > 0 {
+			a.auditor.emit(sessionID, "parse.duplicates_suppressed", map[string]any{
+				"count": m.duplicates,
+			})
+		}
+	}
+}
+
+// runHook calls fn on the AsyncHooks pool, or immediately if AsyncHooks
+// is not set.
+func (a *Agent) runHook(fn func()) {
+	if a.hookPool == nil {
+		fn()
+		return
+	}
+	a.hookPool.submit(fn)
+}
+
+// callFileChangedHooks calls each OnFileChanged hook, recovering panics so
+// that one hook cannot stop the others.
+func (a *Agent) callFileChangedHooks(e FileChangeEvent) {
+	for _, hook := range a.cfg.fileChangedHooks {
+		func() {
+			defer func() {
+				_ = recover()
+			}()
+			hook(e)
+		}()
+	}
+}
+
+// callContextUsageHooks calls the hooks whose thresholds were just crossed.
+func (a *Agent) callContextUsageHooks(sessionID string, usage ContextUsage, crossed []*contextUsageWatcher) {
+	for _, w := range crossed {
+		event := &ContextUsageEvent{
+			ContextUsage: usage,
+			SessionID:    sessionID,
+			Threshold:    w.threshold,
+		}
+
+		func() {
+			defer func() {
+				_ = recover()
+			}()
+			w.fn(event)
+		}()
+
+		a.auditor.emit(sessionID, "hook.context_usage", map[string]any{
+			"tokens":      usage.Tokens,
+			"window":      usage.Window,
+			"utilization": usage.Utilization,
+			"turn":        usage.Turn,
+			"threshold":   w.threshold,
+		})
 	}
 }
 
@@ -293,58 +1038,105 @@ func (a *Agent) processMessageHooks(msg Message) {
 func (a *Agent) emitMessageEvent(msg Message) {
 	switch m := msg.(type) {
 	case *Text:
-		a.auditor.emit(a.sessionID, "message.text", map[string]any{
+		a.auditor.emit(a.sessionID, "message.text", withLinkage(map[string]any{
 			"text": m.Text,
-		})
+		}, &m.MessageMeta))
 	case *Thinking:
-		a.auditor.emit(a.sessionID, "message.thinking", map[string]any{
-			"thinking": m.Thinking,
-		})
+		a.emitThinkingEvent(m)
 	case *ToolUse:
-		a.auditor.emit(a.sessionID, "message.tool_use", map[string]any{
+		a.auditor.emit(a.sessionID, "message.tool_use", withLinkage(map[string]any{
 			"id":    m.ID,
 			"name":  m.Name,
 			"input": m.Input,
-		})
+		}, &m.MessageMeta))
 	case *ToolResult:
-		a.auditor.emit(a.sessionID, "message.tool_result", map[string]any{
+		a.auditor.emit(a.sessionID, "message.tool_result", withLinkage(map[string]any{
 			"tool_use_id": m.ToolUseID,
 			"is_error":    m.IsError,
 			"duration":    m.Duration.String(),
-		})
+		}, &m.MessageMeta))
 	case *Result:
-		a.auditor.emit(a.sessionID, "message.result", map[string]any{
+		data := map[string]any{
 			"result_text":    m.ResultText,
 			"num_turns":      m.NumTurns,
 			"cost_usd":       m.CostUSD,
 			"duration_total": m.DurationTotal.String(),
 			"duration_api":   m.DurationAPI.String(),
 			"is_error":       m.IsError,
-		})
+			"model":          a.cfg.model,
+			"input_tokens":   m.Usage.InputTokens,
+			"output_tokens":  m.Usage.OutputTokens,
+			// Cache token counts use the CLI's telemetry names
+			"cache_read_tokens":     m.Usage.CacheRead,
+			"cache_creation_tokens": m.Usage.CacheWrite,
+			"denials":               m.Denials,
+			"prompt_bytes":          m.PromptBytes,
+			"response_bytes":        m.ResponseBytes,
+			"tool_output_bytes":     m.ToolOutputBytes,
+		}
+		if a.cfg.auditCostBreakdown {
+			data["cost_breakdown"] = m.CostBreakdown.auditData()
+		}
+		a.auditor.emit(a.sessionID, "message.result", withLinkage(data, &m.MessageMeta))
 	case *Error:
 		a.auditor.emit(a.sessionID, "error", map[string]any{
 			"error": m.Err.Error(),
 		})
+	case *ParseWarning:
+		a.auditor.emit(a.sessionID, "parse.warning", map[string]any{
+			"reason": m.Reason,
+			"bytes":  m.Bytes,
+		})
 	}
 }
 
 // Err returns any error that occurred during streaming.
-// Call this after the Stream() channel closes.
+// Call this after the Stream() channel closes. It returns ErrAgentClosed if
+// Close cut a stream short or a stream was started on a closed agent, or
+// an *EvictedError if IdleTimeout closed it. With RequireNonEmptyResult or
+// RefusalDetector, it returns an *EmptyResultError or *RefusalError if the
+// last run's Result was rejected; the Result is still delivered.
 func (a *Agent) Err() error {
-	return a.bridge.error()
+	if err := a.streamErr(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.resultErr
+}
+
+// streamErr returns the error Err reports other than a rejected Result.
+func (a *Agent) streamErr() error {
+	a.mu.Lock()
+	closed := a.closedStream
+	closedErr := a.closedErrLocked()
+	bridge := a.bridge
+	a.mu.Unlock()
+	if closed {
+		return closedErr
+	}
+	return bridge.error()
 }
 
 // Run sends a prompt and waits for the result.
 func (a *Agent) Run(ctx context.Context, prompt string, opts ...RunOption) (*Result, error) {
-	rc := newRunConfig(opts...)
+	return a.run(ctx, promptSource{text: prompt}, opts, nil)
+}
+
+// run sends a text prompt or a prompt reader and waits for the result. If
+// sink is not nil, it is called with each message the run delivers.
+func (a *Agent) run(ctx context.Context, src promptSource, opts []RunOption, sink func(Message)) (*Result, error) {
+	rc := a.runConfig(opts)
 
 	// Apply timeout if specified
 	runCtx := ctx
 	if rc.timeout > 0 {
 		var cancel context.CancelFunc
-		runCtx, cancel = context.WithTimeout(ctx, rc.timeout)
+		runCtx, cancel = context.WithTimeoutCause(ctx, rc.timeout, cancelCause("Timeout of "+rc.timeout.String()+" passed"))
 		defer cancel()
 	}
+	runCtx, cancel := withHardDeadline(runCtx, rc)
+	defer cancel()
 
 	// Pre-run check: have we already exceeded max turns?
 	maxTurns := a.effectiveMaxTurns(rc)
@@ -363,7 +1155,10 @@ func (a *Agent) Run(ctx context.Context, prompt string, opts ...RunOption) (*Res
 	a.mu.Unlock()
 
 	var result *Result
-	for msg := range a.Stream(runCtx, prompt) {
+	for msg := range a.stream(runCtx, src, opts).Messages() {
+		if sink != nil {
+			sink(msg)
+		}
 		switch m := msg.(type) {
 		case *Result:
 			result = m
@@ -372,18 +1167,66 @@ func (a *Agent) Run(ctx context.Context, prompt string, opts ...RunOption) (*Res
 			a.totalTurns += m.NumTurns
 			a.mu.Unlock()
 		case *Error:
-			a.mu.Lock()
-			a.stopReason = StopError
-			a.mu.Unlock()
+			// Close already recorded the run as interrupted
+			if !errors.Is(m.Err, ErrAgentClosed) {
+				a.mu.Lock()
+				a.stopReason = StopError
+				a.mu.Unlock()
+			}
 			return nil, m.Err
 		}
 	}
-	if err := a.Err(); err != nil {
+	if err := a.streamErr(); err != nil {
 		return nil, err
 	}
 	if result == nil {
+		// The run was cancelled, timed out, or cut off after its soft deadline
+		a.mu.Lock()
+		cancelled := a.cancelled
+		a.mu.Unlock()
+		if cancelled != nil {
+			return nil, cancelled
+		}
+		if runCtx.Err() == nil {
+			if err := a.classifyExit(); err != nil {
+				a.mu.Lock()
+				a.stopReason = StopError
+				a.mu.Unlock()
+				return nil, err
+			}
+		}
 		return nil, &TaskError{SessionID: a.sessionID, Message: "no result received"}
 	}
+	if result.budgetErr != nil {
+		a.mu.Lock()
+		a.stopReason = StopError
+		a.mu.Unlock()
+		return result, result.budgetErr
+	}
+	if result.denialErr != nil {
+		a.mu.Lock()
+		a.stopReason = StopError
+		a.mu.Unlock()
+		return result, result.denialErr
+	}
+	if result.IsError {
+		cause := &TaskError{SessionID: a.sessionID, Message: result.ResultText}
+		if err := classifyError(result.ResultText, cause); err != nil {
+			a.mu.Lock()
+			a.stopReason = StopError
+			a.mu.Unlock()
+			return result, err
+		}
+	}
+	if result.hookErr != nil {
+		return result, result.hookErr
+	}
+	if result.checkErr != nil {
+		a.mu.Lock()
+		a.stopReason = StopError
+		a.mu.Unlock()
+		return result, result.checkErr
+	}
 
 	// Post-run check: did this run push us over the limit?
 	a.mu.Lock()
@@ -411,12 +1254,42 @@ func (a *Agent) effectiveMaxTurns(rc *runConfig) int {
 	return a.cfg.maxTurns
 }
 
-// SessionID returns the session identifier.
+// LastRunChanges returns the files modified during the most recent completed
+// run, de-duplicated by effective path. It returns nil if no files were changed.
+func (a *Agent) LastRunChanges() []FileChange {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lastRunChanges == nil {
+		return nil
+	}
+	out := make([]FileChange, len(a.lastRunChanges))
+	copy(out, a.lastRunChanges)
+	return out
+}
+
+// ContextUsage returns the approximate context window utilization so far.
+func (a *Agent) ContextUsage() ContextUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.contextUsage.usage()
+}
+
+// SessionID returns the session identifier, or "" before the first
+// message of a session. If the CLI's output starts without an init
+// message, the session is given a synthetic ID, "unknown-" followed by a
+// random UUID, and a session.init_missing audit event is emitted. An init
+// message that arrives later replaces the synthetic ID, with a
+// session.id_changed audit event; messages and events before it carry the
+// synthetic ID.
 func (a *Agent) SessionID() string {
 	return a.sessionID
 }
 
-// Close terminates the agent and releases resources.
+// Close terminates the agent and releases resources. It is safe to call
+// while a Stream or Run is in flight: the stream ends with a
+// *CancelledError wrapping ErrAgentClosed, and Stop hooks see
+// StopShutdown. Close waits for the stream to stop, so it must not be
+// called from a hook.
 func (a *Agent) Close() error {
 	a.mu.Lock()
 
@@ -425,29 +1298,91 @@ func (a *Agent) Close() error {
 		return nil
 	}
 	a.closed = true
+	interrupted := a.runID != ""
+	close(a.closing)
+	if a.idleTimer != nil {
+		a.idleTimer.Stop()
+	}
+
+	a.mu.Unlock()
+
+	// Let in-flight streams finish their audit events first
+	a.streams.Wait()
 
 	// Capture session state for hooks
+	a.mu.Lock()
 	sessionID := a.sessionID
 	totalTurns := a.totalTurns
 	totalCost := a.totalCost
-	stopReason := a.stopReason
-
+	stopReason, stopCause := a.stopReason, a.stopCause
+	if interrupted {
+		stopReason, stopCause = StopShutdown, ""
+	}
+	switch stopReason {
+	case StopCancelled, StopTimeout, StopShutdown, StopIdle:
+	default:
+		stopCause = "" // From an earlier run
+	}
+	labels := a.labels
+	values := a.runValues
 	a.mu.Unlock()
 
 	// Call Stop hooks
-	a.callStopHooks(sessionID, stopReason, totalTurns, totalCost)
+	a.callStopHooks(sessionID, stopReason, stopCause, totalTurns, totalCost, labels, values)
 
 	// Emit session.end event
-	a.auditor.emit(sessionID, "session.end", map[string]any{
+	end := map[string]any{
 		"total_turns": totalTurns,
 		"total_cost":  totalCost,
 		"stop_reason": string(stopReason),
-	})
+	}
+	if stopCause != "" {
+		end["stop_cause"] = stopCause
+	}
+	a.auditor.emit(sessionID, "session.end", end)
+
+	// Deliver events still queued by AsyncHooks
+	if a.hookPool != nil {
+		if dropped := a.hookPool.close(); dropped > 0 {
+			a.auditor.emit(sessionID, "hooks.dropped", map[string]any{
+				"count": dropped,
+			})
+		}
+	}
 
 	a.bridge.close()
 	procErr := a.proc.close()
+	if procErr != nil {
+		procErr = a.scrub.scrubError(procErr)
+	}
+	_ = a.thinking.close() // Best effort; write errors were already reported
+	a.state.close()
+	if err := removeScratch(a.cfg); err != nil {
+		a.auditor.emit(sessionID, "scratch.remove_failed", map[string]any{
+			"path":  a.cfg.scratchPath,
+			"error": err.Error(),
+		})
+	}
+	if err := removeFork(a.cfg); err != nil {
+		a.auditor.emit(sessionID, "fork.remove_failed", map[string]any{
+			"path":  a.cfg.forkTranscript,
+			"error": err.Error(),
+		})
+	}
+	if reason, err := removeWorktree(a.cfg); err != nil {
+		a.auditor.emit(sessionID, "worktree.remove_failed", map[string]any{
+			"path":  a.cfg.worktreePath,
+			"error": err.Error(),
+		})
+	} else if reason != "" {
+		a.auditor.emit(sessionID, "worktree.preserved", map[string]any{
+			"path":   a.cfg.worktreePath,
+			"reason": reason,
+		})
+	}
 
-	// Call audit cleanup functions
+	// Deliver events AuditBatcher handlers hold, then clean up
+	a.auditor.flushBatches()
 	for _, cleanup := range a.cfg.auditCleanup {
 		_ = cleanup() // Best effort cleanup
 	}
@@ -456,7 +1391,7 @@ func (a *Agent) Close() error {
 }
 
 // callStopHooks calls all registered Stop hooks.
-func (a *Agent) callStopHooks(sessionID string, reason StopReason, numTurns int, costUSD float64) {
+func (a *Agent) callStopHooks(sessionID string, reason StopReason, cause string, numTurns int, costUSD float64, labels map[string]string, values runValues) {
 	if len(a.cfg.stopHooks) == 0 {
 		return
 	}
@@ -464,8 +1399,11 @@ func (a *Agent) callStopHooks(sessionID string, reason StopReason, numTurns int,
 	event := &StopEvent{
 		SessionID: sessionID,
 		Reason:    reason,
+		Cause:     cause,
 		NumTurns:  numTurns,
 		CostUSD:   costUSD,
+		Labels:    copyLabels(labels),
+		values:    values,
 	}
 
 	// Call each hook, recovering from panics
@@ -479,35 +1417,70 @@ func (a *Agent) callStopHooks(sessionID string, reason StopReason, numTurns int,
 	}
 
 	// Emit audit event
-	a.auditor.emit(sessionID, "hook.stop", map[string]any{
+	data := map[string]any{
 		"reason":    string(reason),
 		"num_turns": numTurns,
 		"cost_usd":  costUSD,
-	})
+	}
+	if cause != "" {
+		data["cause"] = cause
+	}
+	a.auditor.emit(sessionID, "hook.stop", data)
 }
 
 // RunWithSchema runs a prompt and unmarshals the structured response into ptr.
 // The agent must have been created with WithSchema or WithSchemaRaw option.
-// The ptr must be a pointer to the same type used in WithSchema.
+// The ptr must be a pointer to the same type used in WithSchema; otherwise a
+// *SchemaError is returned before the prompt is sent. A nil ptr, typed or
+// untyped, skips unmarshaling and only returns the Result.
+//
+// With StrictSchema(true), the response is first validated against the
+// schema, even when ptr is nil. A response that does not match returns the
+// Result with a *SchemaValidationError, and ptr is not modified.
 func (a *Agent) RunWithSchema(ctx context.Context, prompt string, ptr any, opts ...RunOption) (*Result, error) {
+	if err := checkSchemaTarget("RunWithSchema", ptr, a.cfg.schemaType); err != nil {
+		return nil, err
+	}
+
 	result, err := a.Run(ctx, prompt, opts...)
 	if err != nil {
 		return nil, err
 	}
 
+	// Without the --json-schema flag, the JSON may be fenced or explained
+	text := result.ResultText
+	if a.cfg.schemaInPrompt() {
+		text = extractJSON(text)
+	}
+
+	if a.cfg.strictSchema && a.cfg.jsonSchema != "" {
+		if err := validateResponse(a.schemaTypeName(ptr), a.cfg.jsonSchema, text); err != nil {
+			return result, err
+		}
+	}
+
 	// Unmarshal the result into the provided pointer
-	if ptr != nil && a.cfg.jsonSchema != "" {
-		if err := json.Unmarshal([]byte(result.ResultText), ptr); err != nil {
-			return result, &SchemaError{
-				Reason: "failed to unmarshal response",
-				Cause:  err,
-			}
+	if !isNilPointer(ptr) && a.cfg.jsonSchema != "" {
+		if err := json.Unmarshal([]byte(text), ptr); err != nil {
+			return result, newUnmarshalError(ptr, text, err)
 		}
 	}
 
 	return result, nil
 }
 
+// schemaTypeName names the response type in schema errors: the WithSchema
+// type, else the type ptr points to, else "schema".
+func (a *Agent) schemaTypeName(ptr any) string {
+	if a.cfg.schemaType != nil {
+		return rootPath(a.cfg.schemaType)
+	}
+	if t := reflect.TypeOf(ptr); t != nil {
+		return rootPath(t)
+	}
+	return "schema"
+}
+
 // RunStructured is a convenience function that creates a one-shot agent for
 // structured output. It generates a schema from ptr's type, sends the prompt,
 // unmarshals the response into ptr, and closes the agent.
@@ -522,9 +1495,20 @@ func (a *Agent) RunWithSchema(ctx context.Context, prompt string, ptr any, opts
 //	}
 //	var answer Answer
 //	result, err := agent.RunStructured(ctx, "What is 2+2?", &answer)
+//
+// A nil typed pointer such as (*Answer)(nil) still selects the schema, but
+// the response is only available as Result.ResultText.
 func RunStructured(ctx context.Context, prompt string, ptr any, opts ...Option) (*Result, error) {
-	// Add WithSchema to options
-	allOpts := append([]Option{WithSchema(ptr)}, opts...)
+	t := reflect.TypeOf(ptr)
+	if t == nil {
+		return nil, &SchemaError{Type: "nil", Reason: "RunStructured requires a pointer to the result type, such as &answer"}
+	}
+	if t.Kind() != reflect.Ptr {
+		return nil, &SchemaError{Type: t.String(), Reason: "RunStructured requires a pointer to the result type, such as &answer"}
+	}
+
+	// The schema comes from the element type, not the pointer
+	allOpts := append([]Option{withSchemaType(t)}, opts...)
 
 	a, err := New(ctx, allOpts...)
 	if err != nil {
@@ -538,7 +1522,7 @@ func RunStructured(ctx context.Context, prompt string, ptr any, opts ...Option)
 }
 
 // callPromptSubmitHooks runs UserPromptSubmit hooks and returns the final prompt.
-func (a *Agent) callPromptSubmitHooks(prompt, sessionID string, turn int) (string, []any) {
+func (a *Agent) callPromptSubmitHooks(ctx context.Context, prompt, sessionID, runID string, turn int, values runValues) (string, []any) {
 	if a.promptSubmitChain == nil || len(a.cfg.userPromptSubmitHooks) == 0 {
 		return prompt, nil
 	}
@@ -546,7 +1530,10 @@ func (a *Agent) callPromptSubmitHooks(prompt, sessionID string, turn int) (strin
 	event := &PromptSubmitEvent{
 		Prompt:    prompt,
 		SessionID: sessionID,
+		RunID:     runID,
 		Turn:      turn,
+		ctx:       ctx,
+		values:    values,
 	}
 
 	finalPrompt, metadata := a.promptSubmitChain.evaluate(event)
@@ -564,19 +1551,30 @@ func (a *Agent) callPromptSubmitHooks(prompt, sessionID string, turn int) (strin
 
 // handleCompactEvent processes a context compaction event.
 func (a *Agent) handleCompactEvent(compact *CompactMsg) {
-	if a.preCompactChain == nil || len(a.cfg.preCompactHooks) == 0 {
-		return
-	}
-
 	a.mu.Lock()
 	sessionID := a.sessionID
 	a.mu.Unlock()
 
+	// Archive hooks run before the summary is taken
+	a.callPreCompactHooks(sessionID, compact)
+
+	if a.cfg.preserveFn != nil {
+		a.preserveOnCompact(sessionID, compact.TranscriptPath)
+	}
+}
+
+// callPreCompactHooks calls the PreCompact hooks for a compaction event.
+func (a *Agent) callPreCompactHooks(sessionID string, compact *CompactMsg) {
+	if a.preCompactChain == nil || len(a.cfg.preCompactHooks) == 0 {
+		return
+	}
+
 	event := &PreCompactEvent{
 		SessionID:      sessionID,
 		Trigger:        compact.Trigger,
 		TranscriptPath: compact.TranscriptPath,
 		TokenCount:     compact.TokenCount,
+		ScratchDir:     a.cfg.scratchPath,
 	}
 
 	// Call hooks and collect results
@@ -589,10 +1587,14 @@ func (a *Agent) handleCompactEvent(compact *CompactMsg) {
 		"token_count":     compact.TokenCount,
 		"results":         results,
 	})
+
+	a.archiveOnCompact(sessionID, compact.TranscriptPath, results)
 }
 
 // handleSubagentStopEvent processes a subagent completion event.
 func (a *Agent) handleSubagentStopEvent(subagent *SubagentResultMsg) {
+	a.stopSubagent(subagent.ParentToolUseID)
+
 	if a.subagentStopChain == nil || len(a.cfg.subagentStopHooks) == 0 {
 		return
 	}
@@ -611,7 +1613,7 @@ func (a *Agent) handleSubagentStopEvent(subagent *SubagentResultMsg) {
 	}
 
 	// Call all hooks
-	a.subagentStopChain.evaluate(event)
+	a.runHook(func() { a.subagentStopChain.evaluate(event) })
 
 	// Emit audit event
 	a.auditor.emit(sessionID, "hook.subagent_stop", map[string]any{
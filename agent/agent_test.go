@@ -3,9 +3,14 @@ package agent
 import (
 	"context"
 	"errors"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestNewWithInvalidCLIPath(t *testing.T) {
@@ -133,8 +138,104 @@ sleep 10
 	_ = a.Close() // Intentionally close to test behavior
 
 	_, err = a.Run(ctx, "test")
-	if err == nil {
-		t.Error("Run() after Close() should return error")
+	if !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("Run() after Close() error = %v, want ErrAgentClosed", err)
+	}
+}
+
+func TestCloseDuringStream(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"close-test"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Working"}]}}'
+sleep 60
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var stops []StopReason
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), OnStop(func(e *StopEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		stops = append(stops, e.Reason)
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	stream := a.Stream(ctx, "long task")
+	closed := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		closed <- a.Close()
+	}()
+
+	// The consumer sees the stream end promptly, without waiting for the process
+	var last Message
+	timeout := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg, ok := <-stream:
+			if !ok {
+				done = true
+				break
+			}
+			last = msg
+		case <-timeout:
+			t.Fatal("stream did not end within 2s of Close()")
+		}
+	}
+
+	errMsg, ok := last.(*Error)
+	if !ok || !errors.Is(errMsg.Err, ErrAgentClosed) {
+		t.Errorf("last message = %#v, want *Error with ErrAgentClosed", last)
+	}
+	if err := a.Err(); !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("Err() = %v, want ErrAgentClosed", err)
+	}
+
+	<-closed
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stops) != 1 || stops[0] != StopShutdown {
+		t.Errorf("Stop hook reasons = %v, want [%s]", stops, StopShutdown)
+	}
+}
+
+func TestCloseDuringRun(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+sleep 60
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = a.Close()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Run(ctx, "long task")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAgentClosed) {
+			t.Errorf("Run() error = %v, want ErrAgentClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run() did not return within 2s of Close()")
 	}
 }
 
@@ -192,21 +293,9 @@ sleep 60
 }
 
 func TestStreamReturnsMessages(t *testing.T) {
-	// Create a fake CLI that outputs multiple messages (stream-json mode)
-	tmpDir := t.TempDir()
-	fakeClaude := filepath.Join(tmpDir, "claude")
-
-	script := `#!/bin/sh
-read line
-echo '{"type":"system","subtype":"init","session_id":"test-stream"}'
-echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello!"}]}}'
-echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":" World!"}]}}'
-echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
-`
-	mustWriteFile(t, fakeClaude, []byte(script), 0755)
-
+	// Two text messages and a result
 	ctx := context.Background()
-	a, err := New(ctx, CLIPath(fakeClaude))
+	a, err := New(ctx, replayFixture("stream_messages"))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -236,22 +325,9 @@ echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
 }
 
 func TestStreamAllMessageTypes(t *testing.T) {
-	// Create a fake CLI that outputs various message types (stream-json mode)
-	tmpDir := t.TempDir()
-	fakeClaude := filepath.Join(tmpDir, "claude")
-
-	script := `#!/bin/sh
-read line
-echo '{"type":"system","subtype":"init","session_id":"test-types"}'
-echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Thinking..."}]}}'
-echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Let me analyze","signature":"sig1"}]}}'
-echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tool-1","name":"Bash","input":{"command":"ls"}}]}}'
-echo '{"type":"result","result":"Complete","num_turns":1,"total_cost_usd":0.002}'
-`
-	mustWriteFile(t, fakeClaude, []byte(script), 0755)
-
+	// Text, thinking, a tool call, and a result
 	ctx := context.Background()
-	a, err := New(ctx, CLIPath(fakeClaude))
+	a, err := New(ctx, replayFixture("stream_all_types"))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -291,20 +367,80 @@ echo '{"type":"result","result":"Complete","num_turns":1,"total_cost_usd":0.002}
 	}
 }
 
-func TestErrReturnsNilOnSuccess(t *testing.T) {
-	// Create a fake CLI that completes successfully (stream-json mode)
-	tmpDir := t.TempDir()
-	fakeClaude := filepath.Join(tmpDir, "claude")
+func TestStreamOnlyMessages(t *testing.T) {
+	var mu sync.Mutex
+	var postTools []string
+	audited := map[string]bool{}
 
-	script := `#!/bin/sh
-read line
-echo '{"type":"system","subtype":"init","session_id":"test-err-nil"}'
-echo '{"type":"result","result":"OK","num_turns":1,"total_cost_usd":0.001}'
-`
-	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	ctx := context.Background()
+	a, err := New(ctx,
+		replayFixture("stream_filter"),
+		PostToolUse(func(tc *ToolCall, _ *ToolResultContext) HookResult {
+			mu.Lock()
+			defer mu.Unlock()
+			postTools = append(postTools, tc.Name)
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			audited[e.Type] = true
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var got []MessageType
+	for msg := range a.Stream(ctx, "list files", OnlyMessages(MessageText)) {
+		got = append(got, messageTypeOf(msg))
+	}
+
+	want := []MessageType{MessageText, MessageResult}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(postTools) != 1 || postTools[0] != "Bash" {
+		t.Errorf("PostToolUse saw %v, want [Bash] for the filtered tool call", postTools)
+	}
+	for _, typ := range []string{"message.thinking", "message.tool_use", "message.tool_result"} {
+		if !audited[typ] {
+			t.Errorf("no %s audit event for filtered message", typ)
+		}
+	}
+}
 
+func TestStreamExcludeMessagesKeepsResult(t *testing.T) {
 	ctx := context.Background()
-	a, err := New(ctx, CLIPath(fakeClaude))
+	a, err := New(ctx, replayFixture("stream_filter"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var got []MessageType
+	opts := []RunOption{
+		ExcludeMessages(MessageThinking, MessageToolUse, MessageToolResult),
+		ExcludeMessages(MessageResult), // ignored: the Result ends the stream
+	}
+	for msg := range a.Stream(ctx, "list files", opts...) {
+		got = append(got, messageTypeOf(msg))
+	}
+
+	want := []MessageType{MessageText, MessageResult}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestErrReturnsNilOnSuccess(t *testing.T) {
+	// A session that completes successfully
+	ctx := context.Background()
+	a, err := New(ctx, replayFixture("stream_ok"))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -320,19 +456,9 @@ echo '{"type":"result","result":"OK","num_turns":1,"total_cost_usd":0.001}'
 }
 
 func TestStreamContextCancellation(t *testing.T) {
-	// Create a fake CLI that waits forever (stream-json mode)
-	tmpDir := t.TempDir()
-	fakeClaude := filepath.Join(tmpDir, "claude")
-
-	script := `#!/bin/sh
-read line
-echo '{"type":"system","subtype":"init","session_id":"test-stream-cancel"}'
-sleep 60
-`
-	mustWriteFile(t, fakeClaude, []byte(script), 0755)
-
+	// A session that never answers
 	ctx := context.Background()
-	a, err := New(ctx, CLIPath(fakeClaude))
+	a, err := New(ctx, replayFixture("stream_hang"))
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
@@ -530,3 +656,310 @@ func TestEffectiveMaxTurns(t *testing.T) {
 		t.Errorf("effectiveMaxTurns(rc with 5) = %d, want 5", got)
 	}
 }
+
+func TestRunWithSchemaUnmarshalFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"schema-fail"}'
+echo '{"type":"result","result":"{\"value\":\"four\"}","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	type Answer struct {
+		Value int `json:"value"`
+	}
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), WithSchema(Answer{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var answer Answer
+	result, err := a.RunWithSchema(ctx, "What is 2+2?", &answer)
+
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("RunWithSchema() error = %v, want *SchemaError", err)
+	}
+	if result == nil {
+		t.Error("RunWithSchema() should return the result alongside the error")
+	}
+	if schemaErr.Path != "value" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "value")
+	}
+	if schemaErr.RawText != `{"value":"four"}` {
+		t.Errorf("RawText = %q, want %q", schemaErr.RawText, `{"value":"four"}`)
+	}
+}
+
+func TestRunWithSchemaRejectsMismatchedTarget(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	script := `#!/bin/sh
+read -r line
+printf '%s\n' "$line" > ` + wire + `
+echo '{"type":"result","result":"{\"value\":4}","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	type Answer struct {
+		Value int `json:"value"`
+	}
+	type Other struct {
+		Value int `json:"value"`
+	}
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), WithSchema(&Answer{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var other Other
+	_, err = a.RunWithSchema(ctx, "What is 2+2?", &other)
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || !strings.Contains(err.Error(), "does not match the WithSchema type") {
+		t.Fatalf("RunWithSchema() error = %v, want a type mismatch *SchemaError", err)
+	}
+	if _, statErr := os.Stat(wire); statErr == nil {
+		t.Error("prompt was sent despite the mismatched target")
+	}
+
+	// The same agent accepts the value type given to WithSchema as a pointer
+	var answer Answer
+	if _, err := a.RunWithSchema(ctx, "What is 2+2?", &answer); err != nil {
+		t.Fatalf("RunWithSchema() error = %v", err)
+	}
+	if answer.Value != 4 {
+		t.Errorf("answer.Value = %d, want 4", answer.Value)
+	}
+}
+
+func TestRunStructuredNilTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"result","result":"{\"value\":4}","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	type Answer struct {
+		Value int `json:"value"`
+	}
+	ctx := context.Background()
+
+	// Untyped nil has no type to derive a schema from
+	_, err := RunStructured(ctx, "What is 2+2?", nil, CLIPath(fakeClaude))
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || !strings.Contains(err.Error(), "RunStructured requires a pointer") {
+		t.Errorf("RunStructured(nil) error = %v, want *SchemaError naming RunStructured", err)
+	}
+
+	// A nil typed pointer selects the schema; the text is on the Result
+	result, err := RunStructured(ctx, "What is 2+2?", (*Answer)(nil), CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("RunStructured((*Answer)(nil)) error = %v", err)
+	}
+	if result.ResultText != `{"value":4}` {
+		t.Errorf("ResultText = %q, want the structured response", result.ResultText)
+	}
+}
+
+func TestToolCallCarriesParentContext(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// A subagent's Read call: the tool_use carries parent_tool_use_id and the
+	// control request carries the subagent type.
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"parent-test"}'
+printf '%s\n' '{"type":"assistant","parent_tool_use_id":"toolu_task","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_sub","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"toolu_sub","tool_name":"Read","tool_input":{"file_path":"/a"},"subagent_type":"Explore"}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_sub","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var pre, post ToolCall
+	var mu sync.Mutex
+	events := map[string]map[string]any{}
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			pre = *tc
+			return HookResult{Decision: Continue}
+		}),
+		PostToolUse(func(tc *ToolCall, _ *ToolResultContext) HookResult {
+			post = *tc
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if data, ok := e.Data.(map[string]any); ok {
+				events[e.Type] = data
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "explore"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	for name, tc := range map[string]ToolCall{"PreToolUse": pre, "PostToolUse": post} {
+		if tc.ID != "toolu_sub" || tc.ParentToolUseID != "toolu_task" ||
+			tc.AgentKind != AgentSubagent || tc.SubagentType != "Explore" {
+			t.Errorf("%s ToolCall = %+v, want subagent context", name, tc)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, typ := range []string{"hook.pre_tool_use", "hook.post_tool_use"} {
+		data := events[typ]
+		if data["tool_use_id"] != "toolu_sub" || data["parent_tool_use_id"] != "toolu_task" ||
+			data["agent_kind"] != "subagent" || data["subagent_type"] != "Explore" {
+			t.Errorf("%s data = %v, want subagent context", typ, data)
+		}
+	}
+}
+
+func TestRunSuppressesDuplicateAssistantContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	fixture, err := filepath.Abs("testdata/duplicate_assistant.jsonl")
+	if err != nil {
+		t.Fatalf("Abs() error = %v", err)
+	}
+	script := "#!/bin/sh\nread line\ncat '" + fixture + "'\n"
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var postCalls int
+	var suppressed any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PostToolUse(func(*ToolCall, *ToolResultContext) HookResult {
+			postCalls++
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "parse.duplicates_suppressed" {
+				suppressed = e.Data.(map[string]any)["count"]
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var texts []string
+	for msg := range a.Stream(ctx, "find go files") {
+		if text, ok := msg.(*Text); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	if err := a.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	if len(texts) != 2 || texts[0] != "I'll look for Go files." || texts[1] != "Found main.go." {
+		t.Errorf("texts = %q, want each text once", texts)
+	}
+	if postCalls != 1 {
+		t.Errorf("PostToolUse called %d times, want 1", postCalls)
+	}
+	if len(a.pendingToolCalls) != 0 {
+		t.Errorf("pendingToolCalls = %v, want empty", a.pendingToolCalls)
+	}
+	if suppressed != 6 {
+		t.Errorf("parse.duplicates_suppressed count = %v, want 6", suppressed)
+	}
+}
+
+func TestResultRecordsPrompt(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(envEchoCLI(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	prompt := strings.Repeat("large prompt ", 10000)
+	result, err := a.Run(ctx, prompt)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Prompt != prompt || result.OriginalPrompt != prompt {
+		t.Error("Prompt and OriginalPrompt should equal the prompt passed to Run")
+	}
+	if result.PromptMetadata != nil {
+		t.Errorf("PromptMetadata = %v, want nil without hooks", result.PromptMetadata)
+	}
+	// Large prompts are shared, not copied
+	if unsafe.StringData(result.Prompt) != unsafe.StringData(prompt) ||
+		unsafe.StringData(result.OriginalPrompt) != unsafe.StringData(prompt) {
+		t.Error("Result prompt fields copy the prompt instead of sharing it")
+	}
+}
+
+func TestResultRecordsModifiedPrompt(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(envEchoCLI(t)),
+		UserPromptSubmit(
+			func(e *PromptSubmitEvent) PromptSubmitResult {
+				return PromptSubmitResult{UpdatedPrompt: "[ctx] " + e.Prompt, Metadata: "injected"}
+			},
+			func(e *PromptSubmitEvent) PromptSubmitResult {
+				return PromptSubmitResult{} // No change, no metadata
+			},
+			func(e *PromptSubmitEvent) PromptSubmitResult {
+				return PromptSubmitResult{Metadata: map[string]any{"length": len(e.Prompt)}}
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var result *Result
+	for msg := range a.Stream(ctx, "hello") {
+		if r, ok := msg.(*Result); ok {
+			result = r
+		}
+	}
+	if result == nil {
+		t.Fatal("Stream() delivered no Result")
+	}
+	if result.OriginalPrompt != "hello" {
+		t.Errorf("OriginalPrompt = %q, want hello", result.OriginalPrompt)
+	}
+	if result.Prompt != "[ctx] hello" {
+		t.Errorf("Prompt = %q, want the hook-modified prompt", result.Prompt)
+	}
+	want := []any{"injected", map[string]any{"length": len("[ctx] hello")}}
+	if !reflect.DeepEqual(result.PromptMetadata, want) {
+		t.Errorf("PromptMetadata = %v, want %v", result.PromptMetadata, want)
+	}
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// AskTimeout is how long Ask and AskStructured wait for an answer unless
+// their options set a Timeout with DefaultRunOptions.
+const AskTimeout = 2 * time.Minute
+
+// askDefaults are the options of Ask and AskStructured, applied before the
+// caller's: no tools, default permission checks, and AskTimeout.
+func askDefaults(opts []Option) []Option {
+	defaults := []Option{
+		Tools(),
+		PermissionPrompt(PermissionDefault),
+		DefaultRunOptions(Timeout(AskTimeout)),
+	}
+	return append(defaults, opts...)
+}
+
+// Ask sends a single prompt to a new agent, closes it, and returns the
+// answer text. It is meant for scripts and small tools; create an agent
+// with New to send more than one prompt.
+//
+// The agent has no tools, uses PermissionDefault, and gives up after
+// AskTimeout. opts are applied after these defaults and override them, for
+// example Tools(ToolRead) or DefaultRunOptions(Timeout(time.Minute)).
+// Unlike Run, a Result with IsError set is returned as a *TaskError. An
+// error from closing the agent is ignored.
+//
+// Example:
+//
+//	answer, err := agent.Ask(ctx, "Name a prime number greater than 100.")
+func Ask(ctx context.Context, prompt string, opts ...Option) (string, error) {
+	a, err := New(ctx, askDefaults(opts)...)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = a.Close() // Ignore close error; the answer is already known
+	}()
+
+	result, err := a.Run(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	if result.IsError {
+		return "", &TaskError{SessionID: result.SessionID, Message: result.ResultText}
+	}
+	return result.ResultText, nil
+}
+
+// AskStructured is Ask for structured output: it sends the prompt with
+// RunStructured and the defaults of Ask, and unmarshals the answer into
+// ptr. A Result with IsError set is returned as a *TaskError, and ptr is
+// not modified.
+//
+// Example:
+//
+//	var answer struct {
+//	    Value int `json:"value" desc:"The numeric answer"`
+//	}
+//	err := agent.AskStructured(ctx, "What is 2+2?", &answer)
+func AskStructured(ctx context.Context, prompt string, ptr any, opts ...Option) error {
+	result, err := RunStructured(ctx, prompt, ptr, askDefaults(opts)...)
+	if result != nil && result.IsError {
+		return &TaskError{SessionID: result.SessionID, Message: result.ResultText}
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsk(t *testing.T) {
+	tmpDir := t.TempDir()
+	argsFile := filepath.Join(tmpDir, "args")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+for arg in "$@"; do printf '%s\n' "$arg"; done > `+argsFile+`
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"ask-test"}'
+printf '%s\n' '{"type":"result","result":"101","num_turns":1}'
+read line || exit 0
+`), 0755)
+
+	answer, err := Ask(context.Background(), "Name a prime number greater than 100.", CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if answer != "101" {
+		t.Errorf("Ask() = %q, want 101", answer)
+	}
+	// No tools by default
+	if args := string(mustReadFile(t, argsFile)); !strings.Contains(args, "--tools\n\n") {
+		t.Errorf("args = %q, want --tools with no tools", args)
+	}
+
+	if _, err := Ask(context.Background(), "hi", CLIPath(fakeClaude), Tools(ToolRead)); err != nil {
+		t.Fatalf("Ask() error = %v", err)
+	}
+	if args := string(mustReadFile(t, argsFile)); !strings.Contains(args, "--tools\nRead\n") {
+		t.Errorf("args = %q, want the tools given in the options", args)
+	}
+}
+
+func TestAskTimeoutOverride(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"ask-slow"}'
+while read line; do :; done
+`), 0755)
+
+	start := time.Now()
+	_, err := Ask(context.Background(), "hi", CLIPath(fakeClaude), DefaultRunOptions(Timeout(200*time.Millisecond)))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ask() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Ask() took %v, want the 200ms timeout", elapsed)
+	}
+}
+
+func TestAskIsError(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"ask-error"}'
+printf '%s\n' '{"type":"result","result":"Tool execution failed","is_error":true,"num_turns":1,"session_id":"ask-error"}'
+read line || exit 0
+`), 0755)
+
+	ctx := context.Background()
+	answer, err := Ask(ctx, "hi", CLIPath(fakeClaude))
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Message != "Tool execution failed" || answer != "" {
+		t.Errorf("Ask() = %q, %v; want a TaskError", answer, err)
+	}
+
+	var out struct {
+		Value int `json:"value"`
+	}
+	err = AskStructured(ctx, "hi", &out, CLIPath(fakeClaude))
+	if !errors.As(err, &taskErr) || taskErr.SessionID != "ask-error" {
+		t.Errorf("AskStructured() error = %v, want a TaskError for the session", err)
+	}
+}
+
+func TestAskStructured(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"ask-structured"}'
+printf '%s\n' '{"type":"result","result":"{\"value\":4}","num_turns":1}'
+read line || exit 0
+`), 0755)
+
+	var out struct {
+		Value int `json:"value"`
+	}
+	if err := AskStructured(context.Background(), "What is 2+2?", &out, CLIPath(fakeClaude)); err != nil {
+		t.Fatalf("AskStructured() error = %v", err)
+	}
+	if out.Value != 4 {
+		t.Errorf("Value = %d, want 4", out.Value)
+	}
+}
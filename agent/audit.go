@@ -1,7 +1,9 @@
 package agent
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"sync"
@@ -10,22 +12,44 @@ import (
 
 // AuditEvent represents an event that occurred during agent execution.
 // Events are emitted at key points: session start/end, messages, hooks, and errors.
+//
+// RunID groups the events of a single Run or Stream call. It is empty for
+// events emitted outside a run, such as session.start and session.end.
+//
+// Labels holds the agent's labels (see Labels and Agent.SetLabel) at the
+// time the event was emitted. Events of a Pipeline stage also carry the
+// pipeline_id and pipeline_stage labels. Handlers must not modify it.
+//
+// Seq numbers the agent's events from 1 in emission order. Unlike Time, it
+// totally orders events that share a timestamp. It is zero in events that
+// were not emitted by an agent, such as JSONL written by older versions.
 type AuditEvent struct {
-	Time      time.Time `json:"time"`
-	SessionID string    `json:"session_id"`
-	Type      string    `json:"type"`
-	Data      any       `json:"data,omitempty"`
+	Time      time.Time         `json:"time"`
+	Seq       uint64            `json:"seq,omitempty"`
+	SessionID string            `json:"session_id"`
+	RunID     string            `json:"run_id,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Type      string            `json:"type"`
+	Data      any               `json:"data,omitempty"`
 }
 
 // AuditHandler is a function that receives audit events.
-// Handlers are called synchronously. If a handler panics, the panic
-// is recovered and the event is skipped for that handler.
+// Handlers are called synchronously unless AsyncHooks is set. If a handler
+// panics, the panic is recovered and the event is skipped for that handler.
 type AuditHandler func(AuditEvent)
 
 // auditor manages audit handlers and event emission.
 type auditor struct {
 	handlers []AuditHandler
-	mu       sync.RWMutex
+	runID    string            // ID of the run in progress, attached to every event
+	labels   map[string]string // Agent labels, attached to every event; replaced, never mutated
+	run      map[string]string // Labels of the run in progress, merged over labels
+	merged   map[string]string // labels with run applied; what events carry
+	clock    func() time.Time  // Source of event timestamps
+	seq      uint64            // Sequence number of the last event
+	pool     *hookPool         // Runs handlers asynchronously (nil = synchronous)
+	scrub    *scrubber         // Redacts secrets from event data (nil = defaults)
+	mu       sync.Mutex
 }
 
 // newAuditor creates a new auditor with the given handlers.
@@ -33,7 +57,7 @@ func newAuditor(handlers []AuditHandler) *auditor {
 	if len(handlers) == 0 {
 		return nil
 	}
-	return &auditor{handlers: handlers}
+	return &auditor{handlers: handlers, clock: time.Now}
 }
 
 // emit sends an event to all handlers.
@@ -44,17 +68,34 @@ func (a *auditor) emit(sessionID, eventType string, data any) {
 		return
 	}
 
+	// Handlers never see secrets the scrubber recognizes
+	data = a.scrub.scrubData(data)
+
+	// Stamp the event under the lock so Seq and Time agree on the order
+	a.mu.Lock()
+	handlers := a.handlers
+	a.seq++
 	event := AuditEvent{
-		Time:      time.Now(),
+		Time:      a.clock(),
+		Seq:       a.seq,
 		SessionID: sessionID,
+		RunID:     a.runID,
+		Labels:    a.merged,
 		Type:      eventType,
 		Data:      data,
 	}
+	pool := a.pool
+	a.mu.Unlock()
 
-	a.mu.RLock()
-	handlers := a.handlers
-	a.mu.RUnlock()
+	if pool != nil {
+		pool.submit(func() { deliverAuditEvent(handlers, event) })
+		return
+	}
+	deliverAuditEvent(handlers, event)
+}
 
+// deliverAuditEvent calls each handler with the event.
+func deliverAuditEvent(handlers []AuditHandler, event AuditEvent) {
 	for _, h := range handlers {
 		func() {
 			defer func() {
@@ -66,6 +107,98 @@ func (a *auditor) emit(sessionID, eventType string, data any) {
 	}
 }
 
+// setPool moves handler calls onto the AsyncHooks worker pool. Events are
+// still stamped when emitted, so Seq and Time keep emission order.
+func (a *auditor) setPool(pool *hookPool) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.pool = pool
+	a.mu.Unlock()
+}
+
+// setRunID sets the run ID attached to subsequent events.
+// An empty ID marks events as outside any run.
+func (a *auditor) setRunID(runID string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.runID = runID
+	a.mu.Unlock()
+}
+
+// setClock sets the source of event timestamps. A nil clock is ignored.
+func (a *auditor) setClock(clock func() time.Time) {
+	if a == nil || clock == nil {
+		return
+	}
+	a.mu.Lock()
+	a.clock = clock
+	a.mu.Unlock()
+}
+
+// setScrubber sets the scrubber applied to event data.
+func (a *auditor) setScrubber(s *scrubber) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.scrub = s
+	a.mu.Unlock()
+}
+
+// setLabels sets the labels attached to subsequent events.
+// The map must not be modified afterwards.
+func (a *auditor) setLabels(labels map[string]string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.labels = labels
+	a.mergeLabelsLocked()
+	a.mu.Unlock()
+}
+
+// setRunLabels sets labels attached to events until the run ends, on top
+// of the agent labels. Nil clears them. The map must not be modified
+// afterwards.
+func (a *auditor) setRunLabels(labels map[string]string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.run = labels
+	a.mergeLabelsLocked()
+	a.mu.Unlock()
+}
+
+// mergeLabelsLocked recomputes the labels that events carry.
+func (a *auditor) mergeLabelsLocked() {
+	if len(a.run) == 0 {
+		a.merged = a.labels
+		return
+	}
+	merged := make(map[string]string, len(a.labels)+len(a.run))
+	for k, v := range a.labels {
+		merged[k] = v
+	}
+	for k, v := range a.run {
+		merged[k] = v
+	}
+	a.merged = merged
+}
+
+// newRunID returns a random (version 4) UUID identifying a run.
+func newRunID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:]) // crypto/rand.Read does not fail on supported platforms
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
 // AuditWriterHandler creates an AuditHandler that writes JSONL to the given writer.
 // Each event is written as a single JSON line.
 func AuditWriterHandler(w io.Writer) AuditHandler {
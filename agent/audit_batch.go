@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// batchAfterFunc schedules AuditBatcher's interval flushes. Tests replace
+// it with a fake clock.
+var batchAfterFunc = func(d time.Duration, f func()) interface{ Stop() bool } {
+	return time.AfterFunc(d, f)
+}
+
+// auditFlush marks the event an agent sends its AuditBatcher handlers
+// when it closes, asking them to deliver what they hold.
+type auditFlush struct{}
+
+// AuditBatcher returns an AuditHandler that collects events and passes
+// them to h in batches, for handlers such as remote collectors that cannot
+// afford a call per event. A batch is delivered when it holds maxBatch
+// events, flushInterval after its first event, when a session.end event
+// arrives, and when an agent the handler was given to with Audit closes,
+// so no event is lost at shutdown. A maxBatch or flushInterval of zero or
+// less turns that trigger off.
+//
+// Each batch is in Seq order, which keeps an agent's events in emission
+// order even when AsyncHooks delivers them out of order. Batches are
+// delivered one at a time, in the order they were collected. A panic in h
+// loses that batch only. No goroutine or timer outlives a flush: the
+// timer runs only while events wait.
+//
+// The handler may be shared by several agents. An agent flushes it on
+// Close only if it was passed to Audit as returned, not wrapped.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Audit(agent.AuditBatcher(func(events []agent.AuditEvent) {
+//	    collector.Send(events)
+//	}, 100, 5*time.Second)))
+func AuditBatcher(h func(events []AuditEvent), maxBatch int, flushInterval time.Duration) AuditHandler {
+	b := &auditBatcher{deliver: h, maxBatch: maxBatch, interval: flushInterval}
+	return func(e AuditEvent) {
+		if _, ok := e.Data.(auditFlush); ok {
+			b.flush(0)
+			return
+		}
+		b.add(e)
+	}
+}
+
+// auditBatcher implements AuditBatcher.
+type auditBatcher struct {
+	deliver  func([]AuditEvent)
+	maxBatch int
+	interval time.Duration
+
+	delivering sync.Mutex // Held while a batch is taken and delivered, so batches keep their order
+
+	mu      sync.Mutex
+	pending []AuditEvent
+	gen     uint64                   // Counts batches taken, so a stale timer does not flush the next
+	timer   interface{ Stop() bool } // Armed while events are pending
+}
+
+// add holds an event, delivering the batch if it is full or ends a
+// session.
+func (b *auditBatcher) add(e AuditEvent) {
+	b.mu.Lock()
+	b.pending = append(b.pending, e)
+	full := b.maxBatch > 0 && len(b.pending) >= b.maxBatch
+	if !full && b.timer == nil && b.interval > 0 {
+		gen := b.gen
+		b.timer = batchAfterFunc(b.interval, func() { b.flush(gen + 1) })
+	}
+	b.mu.Unlock()
+
+	if full || e.Type == "session.end" {
+		b.flush(0)
+	}
+}
+
+// flush delivers the pending events. A timer passes the generation of the
+// batch it was armed for, and flushes only that batch; 0 flushes any.
+func (b *auditBatcher) flush(gen uint64) {
+	b.delivering.Lock()
+	defer b.delivering.Unlock()
+
+	b.mu.Lock()
+	if gen != 0 && gen != b.gen+1 || len(b.pending) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	batch := b.pending
+	b.pending = nil
+	b.gen++
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	sort.SliceStable(batch, func(i, j int) bool {
+		return batch[i].Seq < batch[j].Seq
+	})
+	func() {
+		defer func() {
+			_ = recover() // Lose this batch, not the handler
+		}()
+		b.deliver(batch)
+	}()
+}
+
+// auditBatcherCode identifies the function literal of AuditBatcher
+// handlers.
+var auditBatcherCode = reflect.ValueOf(AuditBatcher(nil, 0, 0)).Pointer()
+
+// flushBatches asks the AuditBatcher handlers to deliver the events they
+// hold. It is called once an agent emits no more events.
+func (a *auditor) flushBatches() {
+	if a == nil {
+		return
+	}
+	for _, h := range a.handlers {
+		if reflect.ValueOf(h).Pointer() == auditBatcherCode {
+			h(AuditEvent{Type: "audit.flush", Data: auditFlush{}})
+		}
+	}
+}
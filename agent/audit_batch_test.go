@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBatchClock stands in for the timers AuditBatcher arms.
+type fakeBatchClock struct {
+	mu     sync.Mutex
+	now    time.Duration
+	timers []*fakeBatchTimer
+}
+
+type fakeBatchTimer struct {
+	clock   *fakeBatchClock
+	at      time.Duration
+	f       func()
+	stopped bool
+}
+
+func (t *fakeBatchTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	was := !t.stopped
+	t.stopped = true
+	return was
+}
+
+// useFakeBatchClock makes AuditBatcher use a fake clock until the test
+// ends.
+func useFakeBatchClock(t *testing.T) *fakeBatchClock {
+	c := &fakeBatchClock{}
+	saved := batchAfterFunc
+	batchAfterFunc = func(d time.Duration, f func()) interface{ Stop() bool } {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		timer := &fakeBatchTimer{clock: c, at: c.now + d, f: f}
+		c.timers = append(c.timers, timer)
+		return timer
+	}
+	t.Cleanup(func() { batchAfterFunc = saved })
+	return c
+}
+
+// advance moves the clock on, firing the timers that fall due.
+func (c *fakeBatchClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now += d
+	var due []func()
+	for _, timer := range c.timers {
+		if !timer.stopped && timer.at <= c.now {
+			timer.stopped = true
+			due = append(due, timer.f)
+		}
+	}
+	c.mu.Unlock()
+	for _, f := range due {
+		f()
+	}
+}
+
+// armed returns the number of timers that have neither fired nor been
+// stopped.
+func (c *fakeBatchClock) armed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, timer := range c.timers {
+		if !timer.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// batchLog records the batches an AuditBatcher delivers, as Seq numbers.
+type batchLog struct {
+	mu      sync.Mutex
+	batches [][]uint64
+}
+
+func (l *batchLog) handler(events []AuditEvent) {
+	seqs := make([]uint64, len(events))
+	for i, e := range events {
+		seqs[i] = e.Seq
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batches = append(l.batches, seqs)
+}
+
+func (l *batchLog) get() [][]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([][]uint64(nil), l.batches...)
+}
+
+func TestAuditBatcherSize(t *testing.T) {
+	clock := useFakeBatchClock(t)
+	var log batchLog
+	h := AuditBatcher(log.handler, 3, time.Minute)
+	for seq := uint64(1); seq <= 7; seq++ {
+		h(AuditEvent{Seq: seq, Type: "message.text"})
+	}
+	if got, want := log.get(), [][]uint64{{1, 2, 3}, {4, 5, 6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+
+	// The last event waits for the interval, and a full batch stopped the earlier timers
+	if n := clock.armed(); n != 1 {
+		t.Errorf("%d timers armed, want 1", n)
+	}
+	clock.advance(time.Minute)
+	if got, want := log.get(), [][]uint64{{1, 2, 3}, {4, 5, 6}, {7}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+	if n := clock.armed(); n != 0 {
+		t.Errorf("%d timers armed after the flush, want 0", n)
+	}
+}
+
+func TestAuditBatcherInterval(t *testing.T) {
+	clock := useFakeBatchClock(t)
+	var log batchLog
+	h := AuditBatcher(log.handler, 100, 5*time.Second)
+
+	// Delivered out of order, as by AsyncHooks
+	h(AuditEvent{Seq: 3})
+	clock.advance(4 * time.Second)
+	h(AuditEvent{Seq: 1})
+	h(AuditEvent{Seq: 2})
+	if got := log.get(); len(got) != 0 {
+		t.Fatalf("batches = %v before the interval, want none", got)
+	}
+	clock.advance(time.Second)
+	if got, want := log.get(), [][]uint64{{1, 2, 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v in Seq order", got, want)
+	}
+
+	// The interval runs from the next batch's first event
+	clock.advance(time.Hour)
+	h(AuditEvent{Seq: 4})
+	clock.advance(4 * time.Second)
+	if got := log.get(); len(got) != 1 {
+		t.Fatalf("batches = %v, want the second not yet delivered", got)
+	}
+	clock.advance(time.Second)
+	if got, want := log.get(), [][]uint64{{1, 2, 3}, {4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+
+	// A session.end event is delivered at once
+	h(AuditEvent{Seq: 5})
+	h(AuditEvent{Seq: 6, Type: "session.end"})
+	if got, want := log.get(), [][]uint64{{1, 2, 3}, {4}, {5, 6}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+	if n := clock.armed(); n != 0 {
+		t.Errorf("%d timers armed, want 0", n)
+	}
+}
+
+func TestAuditBatcherStaleTimer(t *testing.T) {
+	var log batchLog
+	b := &auditBatcher{deliver: log.handler, maxBatch: 2}
+	b.add(AuditEvent{Seq: 1})
+	b.add(AuditEvent{Seq: 2}) // Full: the first batch is taken
+	b.add(AuditEvent{Seq: 3})
+
+	// The first batch's timer firing late does not cut the second short
+	b.flush(1)
+	if got, want := log.get(), [][]uint64{{1, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+	b.flush(2)
+	if got, want := log.get(), [][]uint64{{1, 2}, {3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
+
+func TestAuditBatcherPanic(t *testing.T) {
+	useFakeBatchClock(t)
+	var log batchLog
+	calls := 0
+	h := AuditBatcher(func(events []AuditEvent) {
+		calls++
+		if calls == 1 {
+			panic("collector down")
+		}
+		log.handler(events)
+	}, 2, time.Minute)
+	for seq := uint64(1); seq <= 4; seq++ {
+		h(AuditEvent{Seq: seq})
+	}
+	if got, want := log.get(), [][]uint64{{3, 4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want only the batch after the panic", got)
+	}
+}
+
+func TestAuditBatcherClose(t *testing.T) {
+	clock := useFakeBatchClock(t)
+	var log batchLog
+	var mu sync.Mutex
+	var all []uint64
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(resultCLI(t, "ok")),
+		Audit(AuditBatcher(log.handler, 1000, time.Hour)),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			all = append(all, e.Seq)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := log.get(); len(got) != 0 {
+		t.Fatalf("batches = %v before Close, want none", got)
+	}
+	mustClose(t, a)
+
+	var delivered []uint64
+	for _, batch := range log.get() {
+		delivered = append(delivered, batch...)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(delivered, all) {
+		t.Errorf("batches delivered %v, want every event %v", delivered, all)
+	}
+	if n := clock.armed(); n != 0 {
+		t.Errorf("%d timers armed after Close, want 0", n)
+	}
+
+	// Close flushes what session.end left, and only batchers as given to Audit
+	var rest batchLog
+	batcher := AuditBatcher(rest.handler, 1000, 0)
+	wrapped := func(e AuditEvent) { t.Errorf("wrapped handler got %+v", e) }
+	aud := newAuditor([]AuditHandler{batcher, wrapped})
+	batcher(AuditEvent{Seq: 9, Type: "worktree.preserved"})
+	aud.flushBatches()
+	if got, want := rest.get(), [][]uint64{{9}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("batches = %v, want %v", got, want)
+	}
+}
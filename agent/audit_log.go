@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// AuditLog holds audit events read back from JSONL, such as a file written
+// by AuditToFile. Data values are decoded as JSON, so numbers are float64
+// and durations are strings like "1.5s".
+type AuditLog struct {
+	Events  []AuditEvent
+	Skipped int // Lines that were corrupt or truncated
+}
+
+// ToolCallRecord is a tool call reconstructed from an audit log.
+type ToolCallRecord struct {
+	SessionID string
+	RunID     string
+	ToolUseID string
+	Name      string
+	Input     map[string]any
+	Duration  time.Duration
+	IsError   bool
+	Completed bool // A result was recorded for the call
+}
+
+// ReadAuditLog decodes audit events from JSONL. Blank lines are ignored,
+// and lines that are not valid events are skipped and counted in Skipped,
+// so a log cut off mid-write can still be read. Logs written by older
+// versions, without Seq, RunID, or Labels, are accepted. The error is
+// non-nil only if reading from r fails.
+//
+// Example:
+//
+//	f, _ := os.Open("audit.jsonl")
+//	defer f.Close()
+//	log, err := agent.ReadAuditLog(f)
+//	if err != nil {
+//	    return err
+//	}
+//	fmt.Printf("$%.4f over %d turns\n", log.TotalCost(), log.TurnCount())
+func ReadAuditLog(r io.Reader) (*AuditLog, error) {
+	log := &AuditLog{}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var e AuditEvent
+			if json.Unmarshal(line, &e) != nil || e.Type == "" {
+				log.Skipped++
+			} else {
+				log.Events = append(log.Events, e)
+			}
+		}
+		if err != nil {
+			return log, nil
+		}
+	}
+}
+
+// Sessions returns the session IDs in the log, in order of first
+// appearance. Events without a session ID, such as session.start, are
+// not counted.
+func (l *AuditLog) Sessions() []string {
+	var sessions []string
+	seen := map[string]bool{}
+	for _, e := range l.Events {
+		if e.SessionID == "" || seen[e.SessionID] {
+			continue
+		}
+		seen[e.SessionID] = true
+		sessions = append(sessions, e.SessionID)
+	}
+	return sessions
+}
+
+// EventsOfType returns the events of type t, such as "message.result",
+// in log order.
+func (l *AuditLog) EventsOfType(t string) []AuditEvent {
+	var events []AuditEvent
+	for _, e := range l.Events {
+		if e.Type == t {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// ToolCalls returns the tool calls in the log, in the order they were
+// made. Each message.tool_use event is joined by tool use ID with its
+// hook.post_tool_use event, or its message.tool_result event in logs that
+// lack one, to fill in the duration and error status.
+func (l *AuditLog) ToolCalls() []ToolCallRecord {
+	var calls []*ToolCallRecord
+	byID := map[string]*ToolCallRecord{}
+
+	// record returns the call with the given ID, adding it if needed
+	record := func(e AuditEvent, id string) *ToolCallRecord {
+		if tc, ok := byID[id]; ok && id != "" {
+			return tc
+		}
+		tc := &ToolCallRecord{SessionID: e.SessionID, RunID: e.RunID, ToolUseID: id}
+		calls = append(calls, tc)
+		if id != "" {
+			byID[id] = tc
+		}
+		return tc
+	}
+
+	posted := map[string]bool{}
+	for _, e := range l.Events {
+		data, _ := e.Data.(map[string]any)
+		switch e.Type {
+		case "message.tool_use":
+			id, _ := data["id"].(string)
+			tc := record(e, id)
+			tc.Name, _ = data["name"].(string)
+			tc.Input, _ = data["input"].(map[string]any)
+
+		case "hook.post_tool_use":
+			id, _ := data["tool_use_id"].(string)
+			tc := record(e, id)
+			if tc.Name == "" {
+				tc.Name, _ = data["tool"].(string)
+			}
+			if tc.Input == nil {
+				tc.Input, _ = data["input"].(map[string]any)
+			}
+			tc.applyResult(data)
+			posted[id] = true
+
+		case "message.tool_result":
+			id, _ := data["tool_use_id"].(string)
+			if tc, ok := byID[id]; ok && !posted[id] {
+				tc.applyResult(data)
+			}
+		}
+	}
+
+	out := make([]ToolCallRecord, len(calls))
+	for i, tc := range calls {
+		out[i] = *tc
+	}
+	return out
+}
+
+// applyResult fills in the outcome of a call from result event data.
+func (tc *ToolCallRecord) applyResult(data map[string]any) {
+	tc.Completed = true
+	tc.IsError, _ = data["is_error"].(bool)
+	if s, ok := data["duration"].(string); ok {
+		tc.Duration, _ = time.ParseDuration(s)
+	}
+}
+
+// TotalCost returns the sum of cost_usd over the message.result events.
+func (l *AuditLog) TotalCost() float64 {
+	var total float64
+	for _, e := range l.EventsOfType("message.result") {
+		data, _ := e.Data.(map[string]any)
+		cost, _ := data["cost_usd"].(float64)
+		total += cost
+	}
+	return total
+}
+
+// TurnCount returns the sum of num_turns over the message.result events.
+func (l *AuditLog) TurnCount() int {
+	var turns int
+	for _, e := range l.EventsOfType("message.result") {
+		data, _ := e.Data.(map[string]any)
+		n, _ := data["num_turns"].(float64)
+		turns += int(n)
+	}
+	return turns
+}
@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func readAuditFixture(t *testing.T) *AuditLog {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", "audit_multirun.jsonl"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer mustClose(t, f)
+
+	log, err := ReadAuditLog(f)
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error = %v", err)
+	}
+	return log
+}
+
+func TestReadAuditLog(t *testing.T) {
+	log := readAuditFixture(t)
+
+	// The garbage line and the truncated last line are skipped; the blank line is ignored
+	if log.Skipped != 2 {
+		t.Errorf("Skipped = %d, want 2", log.Skipped)
+	}
+	if len(log.Events) != 20 {
+		t.Errorf("len(Events) = %d, want 20", len(log.Events))
+	}
+	if got, want := log.Sessions(), []string{"sess-old", "sess-a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sessions() = %v, want %v", got, want)
+	}
+	if n := len(log.EventsOfType("message.prompt")); n != 3 {
+		t.Errorf("EventsOfType(message.prompt) returned %d events, want 3", n)
+	}
+	if cost := log.TotalCost(); cost < 0.0385-1e-9 || cost > 0.0385+1e-9 {
+		t.Errorf("TotalCost() = %v, want 0.0385", cost)
+	}
+	if turns := log.TurnCount(); turns != 6 {
+		t.Errorf("TurnCount() = %d, want 6", turns)
+	}
+}
+
+func TestAuditLogToolCalls(t *testing.T) {
+	log := readAuditFixture(t)
+
+	want := []ToolCallRecord{
+		{
+			// Older logs have no hook.post_tool_use; the result comes from message.tool_result
+			SessionID: "sess-old", ToolUseID: "toolu_old", Name: "Glob",
+			Input:    map[string]any{"pattern": "*.go"},
+			Duration: 40 * time.Millisecond, Completed: true,
+		},
+		{
+			SessionID: "sess-a", RunID: "run-1", ToolUseID: "toolu_1", Name: "Read",
+			Input:    map[string]any{"file_path": "main.go"},
+			Duration: 15 * time.Millisecond, Completed: true,
+		},
+		{
+			SessionID: "sess-a", RunID: "run-2", ToolUseID: "toolu_2", Name: "Bash",
+			Input:    map[string]any{"command": "go test ./..."},
+			Duration: 2500 * time.Millisecond, IsError: true, Completed: true,
+		},
+		{
+			// No result was logged before the run ended
+			SessionID: "sess-a", RunID: "run-2", ToolUseID: "toolu_3", Name: "Read",
+			Input: map[string]any{"file_path": "main_test.go"},
+		},
+	}
+	if got := log.ToolCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToolCalls() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestReadAuditLogRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	aud := newAuditor([]AuditHandler{AuditWriterHandler(&buf)})
+	aud.emit("sess-1", "message.result", map[string]any{"num_turns": 2, "cost_usd": 0.5})
+
+	log, err := ReadAuditLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadAuditLog() error = %v", err)
+	}
+	if log.Skipped != 0 || len(log.Events) != 1 || log.Events[0].Seq != 1 {
+		t.Fatalf("ReadAuditLog() = %+v, want one event with Seq 1", log)
+	}
+	if log.TurnCount() != 2 || log.TotalCost() != 0.5 {
+		t.Errorf("TurnCount() = %d, TotalCost() = %v, want 2 and 0.5", log.TurnCount(), log.TotalCost())
+	}
+}
+
+func TestReadAuditLogReadError(t *testing.T) {
+	errBroken := errors.New("disk gone")
+	if _, err := ReadAuditLog(iotest.ErrReader(errBroken)); !errors.Is(err, errBroken) {
+		t.Errorf("ReadAuditLog() error = %v, want %v", err, errBroken)
+	}
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sharedAuditFiles holds the audit files open in this process by absolute
+// path.
+var sharedAuditFiles = struct {
+	sync.Mutex
+	files map[string]*sharedAuditFile
+}{files: make(map[string]*sharedAuditFile)}
+
+// sharedAuditFile is an audit file written by one or more handlers.
+type sharedAuditFile struct {
+	path string
+	mu   sync.Mutex // Serializes writes and close
+	f    *os.File   // nil once the last user has closed
+	refs int        // Open handlers; guarded by sharedAuditFiles
+}
+
+// SharedAuditFile returns an AuditHandler that writes JSONL to a file
+// shared by every handler for the same path in this process, and a cleanup
+// function that releases it. Each event is written as one whole line, so
+// lines from agents logging to the same file never interleave. The file is
+// created or appended to when the first handler opens it and closed when
+// the last one is cleaned up. Cleanup is idempotent, and the handler drops
+// events once it has been called.
+//
+// AuditToFile uses SharedAuditFile, so agents given the same path share
+// one file.
+//
+// Example:
+//
+//	handler, cleanup, err := agent.SharedAuditFile("audit.jsonl")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer cleanup()
+//	a, _ := agent.New(ctx, agent.Audit(handler))
+func SharedAuditFile(path string) (AuditHandler, func() error, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedAuditFiles.Lock()
+	defer sharedAuditFiles.Unlock()
+	s := sharedAuditFiles.files[abs]
+	if s == nil {
+		f, err := os.OpenFile(abs, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) // #nosec G302,G304 -- Path provided by caller; 0644 is intentional for log files
+		if err != nil {
+			return nil, nil, err
+		}
+		s = &sharedAuditFile{path: abs, f: f}
+		sharedAuditFiles.files[abs] = s
+	}
+	s.refs++
+
+	var once sync.Once
+	var closeErr error
+	released := false // Guarded by s.mu
+	handler := func(e AuditEvent) {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return // Best effort, as AuditWriterHandler
+		}
+		s.write(append(line, '\n'), &released)
+	}
+	cleanup := func() error {
+		once.Do(func() { closeErr = s.release(&released) })
+		return closeErr
+	}
+	return handler, cleanup, nil
+}
+
+// write writes one line unless the handler has been released.
+func (s *sharedAuditFile) write(line []byte, released *bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *released || s.f == nil {
+		return
+	}
+	_, _ = s.f.Write(line) // Best effort - ignore write errors
+}
+
+// release drops a handler's reference, closing the file with the last.
+func (s *sharedAuditFile) release(released *bool) error {
+	sharedAuditFiles.Lock()
+	defer sharedAuditFiles.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*released = true
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(sharedAuditFiles.files, s.path)
+	f := s.f
+	s.f = nil
+	return f.Close()
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSharedAuditFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+
+	first, closeFirst, err := SharedAuditFile(path)
+	if err != nil {
+		t.Fatalf("SharedAuditFile() error = %v", err)
+	}
+	// Another spelling of the same path shares the file
+	second, closeSecond, err := SharedAuditFile(filepath.Join(dir, "logs", "..", ".", "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("SharedAuditFile() error = %v", err)
+	}
+	if n := len(sharedAuditFiles.files); n != 1 {
+		t.Fatalf("%d shared files open, want 1", n)
+	}
+
+	first(AuditEvent{Type: "one"})
+	if err := closeFirst(); err != nil {
+		t.Fatalf("cleanup error = %v", err)
+	}
+	if err := closeFirst(); err != nil {
+		t.Errorf("second cleanup error = %v, want nil", err)
+	}
+	first(AuditEvent{Type: "dropped"})
+	second(AuditEvent{Type: "two"})
+	if err := closeSecond(); err != nil {
+		t.Fatalf("cleanup error = %v", err)
+	}
+	second(AuditEvent{Type: "dropped"})
+	if n := len(sharedAuditFiles.files); n != 0 {
+		t.Errorf("%d shared files open after the last cleanup, want 0", n)
+	}
+
+	events := readAuditLines(t, path)
+	if len(events) != 2 || events[0].Type != "one" || events[1].Type != "two" {
+		t.Errorf("events = %+v, want one and two", events)
+	}
+
+	// The next handler reopens the file for appending
+	third, closeThird, err := SharedAuditFile(path)
+	if err != nil {
+		t.Fatalf("SharedAuditFile() error = %v", err)
+	}
+	third(AuditEvent{Type: "three"})
+	mustCallCleanup(t, closeThird)
+	if events := readAuditLines(t, path); len(events) != 3 {
+		t.Errorf("%d events after reopening, want 3", len(events))
+	}
+}
+
+func TestAuditToFileSharedByAgents(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"shared-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"` + strings.Repeat("x", 4096) + `"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	const workers = 10
+	counts := make([]atomic.Int64, workers)
+	agents := make([]*Agent, workers)
+	ctx := context.Background()
+	for i := range agents {
+		i := i
+		a, err := New(ctx,
+			CLIPath(fakeClaude),
+			Labels(map[string]string{"worker": strconv.Itoa(i)}),
+			Audit(func(AuditEvent) { counts[i].Add(1) }),
+			AuditToFile(path),
+		)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		agents[i] = a
+	}
+
+	var wg sync.WaitGroup
+	for _, a := range agents {
+		wg.Add(1)
+		go func(a *Agent) {
+			defer wg.Done()
+			if _, err := a.Run(ctx, "log something"); err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			// Closing twice is harmless
+			mustClose(t, a)
+			mustClose(t, a)
+		}(a)
+	}
+	wg.Wait()
+
+	got := make(map[string]int64)
+	for _, e := range readAuditLines(t, path) {
+		got[e.Labels["worker"]]++
+	}
+	for i := range counts {
+		if want := counts[i].Load(); got[strconv.Itoa(i)] != want {
+			t.Errorf("worker %d wrote %d lines, want %d", i, got[strconv.Itoa(i)], want)
+		}
+	}
+	if n := len(sharedAuditFiles.files); n != 0 {
+		t.Errorf("%d shared files open after every agent closed, want 0", n)
+	}
+}
+
+// readAuditLines reads a JSONL audit file, failing on a line that does not
+// parse.
+func readAuditLines(t *testing.T, path string) []AuditEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mustClose(t, f)
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q does not parse: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// mustCallCleanup calls a cleanup function, failing the test on error.
+func mustCallCleanup(t *testing.T, cleanup func() error) {
+	t.Helper()
+	if err := cleanup(); err != nil {
+		t.Fatalf("cleanup error = %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// telemetryEventPrefix starts the name of every Claude Code telemetry event.
+const telemetryEventPrefix = "claude_code."
+
+// telemetryTimeFormat is the event.timestamp format used by Claude Code.
+const telemetryTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// TelemetryOption configures AuditToClaudeTelemetry.
+type TelemetryOption func(*telemetryConfig)
+
+// telemetryConfig holds the settings from AuditToClaudeTelemetry.
+type telemetryConfig struct {
+	generic bool // Map unmappable events to sdk_event instead of dropping them
+	prompts bool // Include prompt text in user_prompt events
+}
+
+// TelemetryGenericEvents writes audit events with no Claude Code
+// equivalent as "claude_code.sdk_event" events carrying the audit type in
+// sdk.event_type. By default they are dropped.
+func TelemetryGenericEvents() TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.generic = true
+	}
+}
+
+// TelemetryUserPrompts includes the prompt text in user_prompt events, as
+// OTEL_LOG_USER_PROMPTS=1 does for the CLI. By default only the prompt
+// length is written.
+func TelemetryUserPrompts() TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.prompts = true
+	}
+}
+
+// telemetryEvent is one line of Claude Code telemetry output.
+type telemetryEvent struct {
+	Name       string            `json:"name"`
+	Timestamp  string            `json:"timestamp"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// AuditToClaudeTelemetry writes audit events to w as Claude Code telemetry
+// events, one JSON object per line, so SDK sessions look the same as CLI
+// sessions in an existing telemetry pipeline. Events are mapped as follows:
+//
+//   - message.prompt becomes user_prompt
+//   - hook.pre_tool_use becomes tool_decision
+//   - hook.post_tool_use becomes tool_result
+//   - message.result becomes api_request, or api_error if the result is an error
+//   - error becomes api_error
+//
+// Attribute values are strings, as in the CLI's events. Other audit events
+// are dropped unless TelemetryGenericEvents is set.
+//
+// Example:
+//
+//	f, _ := os.Create("telemetry.jsonl")
+//	defer f.Close()
+//	a, _ := agent.New(ctx, agent.AuditToClaudeTelemetry(f))
+func AuditToClaudeTelemetry(w io.Writer, opts ...TelemetryOption) Option {
+	handler := ClaudeTelemetryHandler(w, opts...)
+	return func(c *config) {
+		c.auditHandlers = append(c.auditHandlers, handler)
+	}
+}
+
+// ClaudeTelemetryHandler creates an AuditHandler that writes Claude Code
+// telemetry events to w. See AuditToClaudeTelemetry.
+func ClaudeTelemetryHandler(w io.Writer, opts ...TelemetryOption) AuditHandler {
+	cfg := &telemetryConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(e AuditEvent) {
+		te, ok := cfg.mapEvent(e)
+		if !ok {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_ = enc.Encode(te) // Best effort - ignore write errors
+	}
+}
+
+// mapEvent converts an audit event to a telemetry event. ok is false if
+// the event is dropped.
+func (c *telemetryConfig) mapEvent(e AuditEvent) (telemetryEvent, bool) {
+	data, _ := e.Data.(map[string]any)
+	attrs := map[string]string{}
+
+	var name string
+	switch e.Type {
+	case "message.prompt":
+		name = "user_prompt"
+		prompt := telemetryString(data["final_prompt"])
+		if prompt == "" {
+			prompt = telemetryString(data["prompt"])
+		}
+		attrs["prompt_length"] = strconv.Itoa(len(prompt))
+		if c.prompts {
+			attrs["prompt"] = prompt
+		}
+
+	case "hook.pre_tool_use":
+		name = "tool_decision"
+		attrs["tool_name"] = telemetryString(data["tool"])
+		switch data["decision"] {
+		case "deny":
+			attrs["decision"], attrs["source"] = "reject", "hook"
+		case "allow":
+			attrs["decision"], attrs["source"] = "accept", "hook"
+		default:
+			attrs["decision"], attrs["source"] = "accept", "config"
+		}
+
+	case "hook.post_tool_use":
+		name = "tool_result"
+		attrs["tool_name"] = telemetryString(data["tool"])
+		failed, _ := data["is_error"].(bool)
+		attrs["success"] = strconv.FormatBool(!failed)
+		attrs["duration_ms"] = telemetryMillis(data["duration"])
+
+	case "message.result":
+		if failed, _ := data["is_error"].(bool); failed {
+			name = "api_error"
+			attrs["error"] = telemetryString(data["result_text"])
+		} else {
+			name = "api_request"
+			attrs["cost_usd"] = telemetryString(data["cost_usd"])
+			attrs["input_tokens"] = telemetryString(data["input_tokens"])
+			attrs["output_tokens"] = telemetryString(data["output_tokens"])
+			attrs["cache_read_tokens"] = telemetryString(data["cache_read_tokens"])
+			attrs["cache_creation_tokens"] = telemetryString(data["cache_creation_tokens"])
+		}
+		attrs["model"] = telemetryString(data["model"])
+		attrs["duration_ms"] = telemetryMillis(data["duration_api"])
+
+	case "error":
+		name = "api_error"
+		attrs["error"] = telemetryString(data["error"])
+
+	default:
+		if !c.generic {
+			return telemetryEvent{}, false
+		}
+		name = "sdk_event"
+		attrs["sdk.event_type"] = e.Type
+		if e.Data != nil {
+			if raw, err := json.Marshal(e.Data); err == nil {
+				attrs["sdk.data"] = string(raw)
+			}
+		}
+	}
+
+	timestamp := e.Time.UTC().Format(telemetryTimeFormat)
+	attrs["event.name"] = name
+	attrs["event.timestamp"] = timestamp
+	attrs["session.id"] = e.SessionID
+	return telemetryEvent{
+		Name:       telemetryEventPrefix + name,
+		Timestamp:  timestamp,
+		Attributes: attrs,
+	}, true
+}
+
+// telemetryString formats an audit data value as an attribute string.
+func telemetryString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(raw)
+	}
+}
+
+// telemetryMillis converts a duration string from audit data, such as
+// "1.5s", to whole milliseconds.
+func telemetryMillis(v any) string {
+	s, _ := v.(string)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return "0"
+	}
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
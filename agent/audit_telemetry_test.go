@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// telemetrySession replays a canned session through an auditor with a clock
+// that steps one second per event, writing Claude Code telemetry to buf.
+func telemetrySession(buf *bytes.Buffer, opts ...TelemetryOption) {
+	aud := newAuditor([]AuditHandler{ClaudeTelemetryHandler(buf, opts...)})
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	aud.setClock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+
+	const sess = "sess-telemetry"
+	aud.emit("", "session.start", map[string]any{"model": "claude-sonnet-4-5"})
+	aud.setRunID("run-1")
+	aud.emit(sess, "message.prompt", map[string]any{"prompt": "fix the bug", "final_prompt": "fix the bug in main.go"})
+	aud.emit(sess, "hook.pre_tool_use", map[string]any{"tool": "Read", "decision": "continue"})
+	aud.emit(sess, "hook.post_tool_use", map[string]any{"tool": "Read", "is_error": false, "duration": "15ms"})
+	aud.emit(sess, "hook.pre_tool_use", map[string]any{"tool": "Bash", "decision": "deny", "reason": "no shell"})
+	aud.emit(sess, "message.result", map[string]any{
+		"num_turns":             2,
+		"cost_usd":              0.0123,
+		"duration":              "2.5s",
+		"duration_api":          "1.75s",
+		"is_error":              false,
+		"model":                 "claude-sonnet-4-5",
+		"input_tokens":          1200,
+		"output_tokens":         340,
+		"cache_read_tokens":     800,
+		"cache_creation_tokens": 0,
+	})
+	aud.setRunID("run-2")
+	aud.emit(sess, "message.prompt", map[string]any{"prompt": "again", "final_prompt": "again"})
+	aud.emit(sess, "error", map[string]any{"error": "agent: API overloaded: try later"})
+	aud.setRunID("")
+	aud.emit(sess, "session.end", nil)
+}
+
+func TestClaudeTelemetryGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		opts   []TelemetryOption
+	}{
+		{name: "default", golden: "telemetry.golden.jsonl"},
+		{
+			name:   "generic events and prompts",
+			golden: "telemetry_generic.golden.jsonl",
+			opts:   []TelemetryOption{TelemetryGenericEvents(), TelemetryUserPrompts()},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			telemetrySession(&buf, tt.opts...)
+
+			golden := filepath.Join("testdata", tt.golden)
+			if *updateGolden {
+				if err := os.WriteFile(golden, buf.Bytes(), 0600); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+
+			if want := mustReadFile(t, golden); buf.String() != string(want) {
+				t.Errorf("telemetry output =\n%s\nwant (run with -update to refresh):\n%s", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestClaudeTelemetryDropsUnmappedEvents(t *testing.T) {
+	var buf bytes.Buffer
+	telemetrySession(&buf)
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e telemetryEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", line, err)
+		}
+		if e.Name == "claude_code.sdk_event" {
+			t.Errorf("unmapped event written without TelemetryGenericEvents: %s", line)
+		}
+		if _, ok := e.Attributes["prompt"]; ok {
+			t.Errorf("prompt text written without TelemetryUserPrompts: %s", line)
+		}
+	}
+}
+
+func TestAuditToClaudeTelemetry(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"telemetry-test"}'
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.25,"duration_api_ms":1500,"usage":{"input_tokens":100,"output_tokens":20}}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var buf bytes.Buffer
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Model("claude-haiku-4-5"),
+		AuditToClaudeTelemetry(&buf),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := a.Run(ctx, "go"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mustClose(t, a)
+
+	var request *telemetryEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e telemetryEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", line, err)
+		}
+		if e.Name == "claude_code.api_request" {
+			request = &e
+		}
+	}
+	if request == nil {
+		t.Fatalf("no api_request event in:\n%s", buf.String())
+	}
+
+	want := map[string]string{
+		"session.id":    "telemetry-test",
+		"model":         "claude-haiku-4-5",
+		"cost_usd":      "0.25",
+		"duration_ms":   "1500",
+		"input_tokens":  "100",
+		"output_tokens": "20",
+	}
+	for k, v := range want {
+		if got := request.Attributes[k]; got != v {
+			t.Errorf("api_request %s = %q, want %q", k, got, v)
+		}
+	}
+}
@@ -2,8 +2,12 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -324,3 +328,268 @@ func TestAuditToFileOption_InvalidPath(t *testing.T) {
 		t.Error("expected error for invalid path")
 	}
 }
+
+func TestNewRunIDFormat(t *testing.T) {
+	id := newRunID()
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !pattern.MatchString(id) {
+		t.Errorf("newRunID() = %q, want a version 4 UUID", id)
+	}
+	if newRunID() == id {
+		t.Error("newRunID() should return distinct IDs")
+	}
+}
+
+func TestAuditEventsCarryRunID(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Answers two prompts on the same process
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"run-id-test"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"one"}]}}'
+echo '{"type":"result","result":"first","num_turns":1}'
+read line
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"two"}]}}'
+echo '{"type":"result","result":"second","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var events []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), Audit(func(e AuditEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	first, err := a.Run(ctx, "first")
+	if err != nil {
+		t.Fatalf("first Run() error = %v", err)
+	}
+	second, err := a.Run(ctx, "second")
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if first.RunID == "" || second.RunID == "" {
+		t.Fatalf("Result.RunID should be set, got %q and %q", first.RunID, second.RunID)
+	}
+	if first.RunID == second.RunID {
+		t.Errorf("two runs share RunID %q", first.RunID)
+	}
+
+	// Every event from message.prompt through message.result belongs to one run
+	mu.Lock()
+	defer mu.Unlock()
+	var current string
+	runs := 0
+	for _, e := range events {
+		switch e.Type {
+		case "session.start", "session.end":
+			if e.RunID != "" {
+				t.Errorf("%s RunID = %q, want empty", e.Type, e.RunID)
+			}
+			continue
+		case "message.prompt":
+			current = e.RunID
+			runs++
+		}
+		if current == "" {
+			continue
+		}
+		if e.RunID != current {
+			t.Errorf("%s RunID = %q, want %q", e.Type, e.RunID, current)
+		}
+		if e.Type == "message.result" {
+			current = ""
+		}
+	}
+	if runs != 2 {
+		t.Errorf("saw %d message.prompt events, want 2", runs)
+	}
+}
+
+func TestAuditClockGolden(t *testing.T) {
+	var buf bytes.Buffer
+	aud := newAuditor([]AuditHandler{AuditWriterHandler(&buf)})
+
+	// Each event is one second after the previous one
+	now := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	aud.setClock(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+
+	aud.emit("", "session.start", nil)
+	aud.setRunID("run-1")
+	aud.emit("sess-golden", "message.prompt", map[string]any{"prompt": "hello"})
+	aud.emit("sess-golden", "message.result", map[string]any{"num_turns": 1, "cost_usd": 0.01})
+	aud.setRunID("")
+	aud.emit("sess-golden", "session.end", nil)
+
+	golden := filepath.Join("testdata", "audit.golden.jsonl")
+	if *updateGolden {
+		if err := os.WriteFile(golden, buf.Bytes(), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	if want := mustReadFile(t, golden); buf.String() != string(want) {
+		t.Errorf("audit output =\n%s\nwant (run with -update to refresh):\n%s", buf.String(), want)
+	}
+}
+
+func TestAuditSeqConcurrentBurst(t *testing.T) {
+	const goroutines, perGoroutine = 8, 200
+
+	var mu sync.Mutex
+	var events []AuditEvent
+	aud := newAuditor([]AuditHandler{func(e AuditEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}})
+
+	// Every event shares one timestamp, so only Seq can order them
+	fixed := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	aud.setClock(func() time.Time { return fixed })
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				aud.emit("sess-burst", "test.event", nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := goroutines * perGoroutine
+	if len(events) != total {
+		t.Fatalf("got %d events, want %d", len(events), total)
+	}
+
+	seen := make(map[uint64]bool, total)
+	for _, e := range events {
+		if e.Seq < 1 || e.Seq > uint64(total) || seen[e.Seq] {
+			t.Fatalf("Seq %d is out of range or duplicated", e.Seq)
+		}
+		seen[e.Seq] = true
+	}
+}
+
+func TestAuditEventDecodeWithoutSeq(t *testing.T) {
+	line := `{"time":"2024-01-15T10:30:00Z","session_id":"sess-old","type":"session.start"}`
+
+	var e AuditEvent
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if e.Seq != 0 || e.Type != "session.start" {
+		t.Errorf("decoded event = %+v, want Seq=0 Type=session.start", e)
+	}
+}
+
+func TestAuditClockOption(t *testing.T) {
+	fixed := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	cfg := newConfig(AuditClock(func() time.Time { return fixed }))
+
+	if cfg.auditClock == nil || !cfg.auditClock().Equal(fixed) {
+		t.Error("AuditClock should set the audit clock")
+	}
+}
+
+// openFDs counts the process's open file descriptors.
+func openFDs(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot count open file descriptors:", err)
+	}
+	return len(entries)
+}
+
+func TestNewAuditsStartFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantReason string
+	}{
+		{
+			name:       "bad CLI path",
+			opts:       []Option{CLIPath(filepath.Join(t.TempDir(), "missing-claude"))},
+			wantReason: "failed to start claude CLI",
+		},
+		{
+			name: "unreadable skills directory",
+			opts: []Option{
+				CLIPath("/bin/true"),
+				SkillsDir(filepath.Join(t.TempDir(), "missing-skills")),
+			},
+			wantReason: "failed to load skills from",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []AuditEvent
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			opts := append([]Option{
+				Audit(func(e AuditEvent) { events = append(events, e) }),
+				AuditToFile(path),
+			}, tt.opts...)
+
+			before := openFDs(t)
+			_, err := New(context.Background(), opts...)
+			if err == nil {
+				t.Fatal("New() error = nil, want start failure")
+			}
+			if after := openFDs(t); after != before {
+				t.Errorf("open file descriptors = %d after New, want %d", after, before)
+			}
+
+			if len(events) != 2 || events[0].Type != "session.start_attempt" || events[1].Type != "session.start_failed" {
+				t.Fatalf("events = %+v, want session.start_attempt and session.start_failed", events)
+			}
+			failed := events[1].Data.(map[string]any)
+			if failed["error"] != err.Error() || !strings.HasPrefix(failed["reason"].(string), tt.wantReason) {
+				t.Errorf("session.start_failed data = %v, want reason %q", failed, tt.wantReason)
+			}
+
+			// The file handler saw the same events before it was closed
+			if lines := bytes.Count(mustReadFile(t, path), []byte("\n")); lines != 2 {
+				t.Errorf("audit file has %d events, want 2", lines)
+			}
+		})
+	}
+}
+
+func TestStartAttemptRedactsMCPSecrets(t *testing.T) {
+	cfg := newConfig(
+		CLIPath("/usr/bin/claude"),
+		MCPServer("github", MCPHTTP("https://mcp.example.com"), MCPHeader("Authorization", "Bearer secret-token")),
+		APIKey("sk-secret"),
+	)
+
+	data := startAttemptData(cfg)
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if strings.Contains(string(encoded), "secret") {
+		t.Errorf("session.start_attempt data leaks a secret: %s", encoded)
+	}
+	if !strings.Contains(string(encoded), "https://mcp.example.com") || data["cli_path"] != "/usr/bin/claude" {
+		t.Errorf("session.start_attempt data = %s, want the MCP URL and CLI path", encoded)
+	}
+}
@@ -5,33 +5,39 @@ import (
 	"sync"
 )
 
-// bridge pumps messages from a parser to a channel.
+// bridge pumps messages from a decoder to a channel.
 type bridge struct {
-	parser    *parser
+	decoder   *Decoder
 	messages  chan Message
 	err       error
 	errMu     sync.RWMutex
 	done      chan struct{}
+	finished  chan struct{} // Closed when the pump stops reading
 	closeOnce sync.Once
 }
 
-// newBridge creates a new bridge that reads from the given reader.
-func newBridge(r io.Reader) *bridge {
+// newBridge creates a new bridge that reads messages with the given
+// decoder. Control responses are passed to onControl, if set, as soon as
+// they are read.
+func newBridge(d *Decoder, onControl func(*controlResponseMsg)) *bridge {
+	d.onControl = onControl
 	b := &bridge{
-		parser:   newParser(r),
+		decoder:  d,
 		messages: make(chan Message, 32),
 		done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
 	go b.pump()
 	return b
 }
 
-// pump reads messages from the parser and sends them to the channel.
+// pump reads messages from the decoder and sends them to the channel.
 func (b *bridge) pump() {
+	defer close(b.finished)
 	defer close(b.messages)
 
 	for {
-		msg, err := b.parser.next()
+		msg, err := b.decoder.Next()
 		if err != nil {
 			if err != io.EOF {
 				b.errMu.Lock()
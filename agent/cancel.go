@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"context"
+	"errors"
+)
+
+// cancelCause is the cause of a context the agent cancels, such as by
+// Agent.Cancel or a run's Timeout.
+type cancelCause string
+
+func (c cancelCause) Error() string { return string(c) }
+
+// Cancel cancels the run in progress, if any, with reason as its cause, and
+// reports whether there was one. The run ends as if its context had been
+// cancelled: Stream's channel closes, Run returns a *CancelledError with
+// Reason StopCancelled and Cause reason, and Stop hooks see the same.
+//
+// Example:
+//
+//	go func() {
+//	    <-cancelButton
+//	    a.Cancel("user clicked cancel")
+//	}()
+//	result, err := a.Run(ctx, prompt)
+//	var cancelled *agent.CancelledError
+//	if errors.As(err, &cancelled) {
+//	    log.Printf("run ended early (%s): %s", cancelled.Reason, cancelled.Cause)
+//	}
+func (a *Agent) Cancel(reason string) bool {
+	a.mu.Lock()
+	cancel := a.cancelRun
+	a.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel(cancelCause(reason))
+	return true
+}
+
+// streamCancelled records that ctx ended the run in progress and returns
+// the error Run reports for it.
+func (a *Agent) streamCancelled(ctx context.Context) *CancelledError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := ctx.Err()
+	cerr := &CancelledError{Reason: StopCancelled, SessionID: a.sessionID, Err: err}
+	if errors.Is(err, context.DeadlineExceeded) {
+		cerr.Reason = StopTimeout
+	}
+	if cause := context.Cause(ctx); cause != nil && cause != err {
+		cerr.Cause = cause.Error()
+		cerr.cause = cause
+	}
+	a.stopReason = cerr.Reason
+	a.stopCause = cerr.Cause
+	a.cancelled = cerr
+	return cerr
+}
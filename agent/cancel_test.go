@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sleepingCLI writes a fake CLI that starts a session and never finishes it.
+func sleepingCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"cancel-test"}'
+sleep 60
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+// stopRecorder records the Stop event and session.end audit event.
+type stopRecorder struct {
+	mu    sync.Mutex
+	stops []StopEvent
+	end   map[string]any
+}
+
+func (r *stopRecorder) options() []Option {
+	return []Option{
+		OnStop(func(e *StopEvent) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.stops = append(r.stops, *e)
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "session.end" {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				r.end = e.Data.(map[string]any)
+			}
+		}),
+	}
+}
+
+// check asserts a single Stop event and session.end with reason and cause.
+func (r *stopRecorder) check(t *testing.T, reason StopReason, cause string) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.stops) != 1 || r.stops[0].Reason != reason || r.stops[0].Cause != cause {
+		t.Errorf("Stop events = %+v, want one with %s and cause %q", r.stops, reason, cause)
+	}
+	wantCause := any(cause)
+	if cause == "" {
+		wantCause = nil
+	}
+	if r.end["stop_reason"] != string(reason) || r.end["stop_cause"] != wantCause {
+		t.Errorf("session.end = %v, want %s and cause %q", r.end, reason, cause)
+	}
+}
+
+// checkCancelled asserts err is a *CancelledError with reason and cause
+// that wraps target.
+func checkCancelled(t *testing.T, err error, reason StopReason, cause string, target error) {
+	t.Helper()
+	var cerr *CancelledError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Run() error = %v, want *CancelledError", err)
+	}
+	if cerr.Reason != reason || cerr.Cause != cause || cerr.SessionID != "cancel-test" {
+		t.Errorf("CancelledError = %+v, want %s with cause %q", cerr, reason, cause)
+	}
+	if !errors.Is(err, target) {
+		t.Errorf("Run() error = %v, want it to wrap %v", err, target)
+	}
+}
+
+func TestCancelWithReason(t *testing.T) {
+	var rec stopRecorder
+	ctx := context.Background()
+	a, err := New(ctx, append(rec.options(), CLIPath(sleepingCLI(t)))...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if a.Cancel("nothing running") {
+		t.Error("Cancel() = true with no run in progress")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond) // Let the session start
+		for !a.Cancel("user clicked cancel") {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	_, err = a.Run(ctx, "long task")
+	checkCancelled(t, err, StopCancelled, "user clicked cancel", context.Canceled)
+	if err.Error() != "agent: run cancelled: user clicked cancel" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	mustClose(t, a)
+	rec.check(t, StopCancelled, "user clicked cancel")
+}
+
+func TestCancelWithContextCause(t *testing.T) {
+	var rec stopRecorder
+	a, err := New(context.Background(), append(rec.options(), CLIPath(sleepingCLI(t)))...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	draining := errors.New("service draining")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(50*time.Millisecond, func() { cancel(draining) })
+	_, err = a.Run(ctx, "long task")
+	checkCancelled(t, err, StopCancelled, "service draining", draining)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want it to wrap context.Canceled", err)
+	}
+
+	mustClose(t, a)
+	rec.check(t, StopCancelled, "service draining")
+}
+
+func TestCancelTimeout(t *testing.T) {
+	var rec stopRecorder
+	ctx := context.Background()
+	a, err := New(ctx, append(rec.options(), CLIPath(sleepingCLI(t)))...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	_, err = a.Run(ctx, "long task", Timeout(50*time.Millisecond))
+	checkCancelled(t, err, StopTimeout, "Timeout of 50ms passed", context.DeadlineExceeded)
+
+	mustClose(t, a)
+	rec.check(t, StopTimeout, "Timeout of 50ms passed")
+}
+
+func TestCancelContextDeadline(t *testing.T) {
+	a, err := New(context.Background(), CLIPath(sleepingCLI(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = a.Run(ctx, "long task")
+	checkCancelled(t, err, StopTimeout, "", context.DeadlineExceeded)
+}
+
+func TestCancelShutdown(t *testing.T) {
+	var rec stopRecorder
+	ctx := context.Background()
+	a, err := New(ctx, append(rec.options(), CLIPath(sleepingCLI(t)))...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	closed := make(chan error, 1)
+	time.AfterFunc(100*time.Millisecond, func() { closed <- a.Close() })
+	_, err = a.Run(ctx, "long task")
+	checkCancelled(t, err, StopShutdown, "", ErrAgentClosed)
+	<-closed
+
+	rec.check(t, StopShutdown, "")
+}
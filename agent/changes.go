@@ -0,0 +1,142 @@
+package agent
+
+// fileMutationTools lists the tools that modify files on disk.
+var fileMutationTools = []string{ToolWrite, ToolEdit, ToolMultiEdit, ToolNotebookEdit}
+
+// isFileMutationTool checks if the tool name modifies files.
+func isFileMutationTool(name string) bool {
+	for _, t := range fileMutationTools {
+		if name == t {
+			return true
+		}
+	}
+	return false
+}
+
+// FileChange summarizes the mutating tool calls made against one file
+// during a single run.
+type FileChange struct {
+	// Path is the effective file path, after any PreToolUse rewrites
+	// such as RedirectPath.
+	Path string
+	// OriginalPath is the path Claude asked for when RedirectPath
+	// rewrote it to Path, or "" if it was not rewritten.
+	OriginalPath string
+	// Tools lists the distinct tools that touched the file, in first-use order.
+	Tools []string
+	// Count is the number of mutating tool calls against the file.
+	Count int
+	// Errors is the number of those calls whose tool result was an error.
+	Errors int
+}
+
+// FileChangeEvent reports a completed call to a tool that modifies a file.
+// It is delivered to OnFileChanged hooks when the tool's result arrives.
+type FileChangeEvent struct {
+	// Path is the effective file path, after any PreToolUse rewrites
+	// such as RedirectPath.
+	Path string
+	// OriginalPath is the path before a RedirectPath rewrite, or "".
+	OriginalPath string
+	Tool         string
+	ToolUseID    string
+	// IsError is true when the tool result reported an error, so the file
+	// may be unchanged or partly written.
+	IsError bool
+	RunID   string
+}
+
+// FileChangedHook is called after a tool that modifies a file completes.
+type FileChangedHook func(e FileChangeEvent)
+
+// changedPath returns the file a mutating tool call operates on.
+// It reports false for other tools and calls without a path.
+func changedPath(tool string, input map[string]any) (string, bool) {
+	if !isFileMutationTool(tool) {
+		return "", false
+	}
+	path, ok := extractPath(input)
+	if !ok {
+		path, ok = input["notebook_path"].(string)
+	}
+	return path, ok && path != ""
+}
+
+// changeTracker collects file changes for a run, de-duplicated by path,
+// and the RedirectPath rewrites made during it.
+type changeTracker struct {
+	changes   []FileChange
+	index     map[string]int
+	redirects []PathRedirect
+	originals map[string]string // Original path by redirected path
+}
+
+// newChangeTracker creates an empty tracker.
+func newChangeTracker() *changeTracker {
+	return &changeTracker{index: make(map[string]int), originals: make(map[string]string)}
+}
+
+// redirect records a RedirectPath rewrite. Repeats of a mapping are
+// recorded once.
+func (t *changeTracker) redirect(m PathRedirect) {
+	if original, seen := t.originals[m.Redirected]; seen && original == m.Original {
+		return
+	}
+	t.originals[m.Redirected] = m.Original
+	t.redirects = append(t.redirects, m)
+}
+
+// originalPath returns the path that was redirected to path, or "".
+func (t *changeTracker) originalPath(path string) string {
+	return t.originals[path]
+}
+
+// redirectSnapshot returns a copy of the recorded rewrites, or nil if
+// there are none.
+func (t *changeTracker) redirectSnapshot() []PathRedirect {
+	if t == nil || len(t.redirects) == 0 {
+		return nil
+	}
+	return append([]PathRedirect(nil), t.redirects...)
+}
+
+// record adds a mutating tool call to the summary.
+// Calls to non-mutating tools or without a path are ignored.
+func (t *changeTracker) record(tool string, input map[string]any, isError bool) {
+	path, ok := changedPath(tool, input)
+	if !ok {
+		return
+	}
+
+	i, seen := t.index[path]
+	if !seen {
+		i = len(t.changes)
+		t.index[path] = i
+		t.changes = append(t.changes, FileChange{Path: path, OriginalPath: t.originals[path]})
+	}
+
+	fc := &t.changes[i]
+	fc.Count++
+	if isError {
+		fc.Errors++
+	}
+	for _, name := range fc.Tools {
+		if name == tool {
+			return
+		}
+	}
+	fc.Tools = append(fc.Tools, tool)
+}
+
+// snapshot returns a copy of the collected changes, or nil if there are none.
+func (t *changeTracker) snapshot() []FileChange {
+	if t == nil || len(t.changes) == 0 {
+		return nil
+	}
+	out := make([]FileChange, len(t.changes))
+	for i, fc := range t.changes {
+		fc.Tools = append([]string(nil), fc.Tools...)
+		out[i] = fc
+	}
+	return out
+}
@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestChangeTrackerDeduplicatesByPath(t *testing.T) {
+	tr := newChangeTracker()
+	tr.record("Write", map[string]any{"file_path": "/a.go"}, false)
+	tr.record("Edit", map[string]any{"file_path": "/a.go"}, false)
+	tr.record("Edit", map[string]any{"file_path": "/a.go"}, true)
+	tr.record("NotebookEdit", map[string]any{"notebook_path": "/n.ipynb"}, false)
+
+	changes := tr.snapshot()
+	if len(changes) != 2 {
+		t.Fatalf("len(changes) = %d, want 2", len(changes))
+	}
+
+	a := changes[0]
+	if a.Path != "/a.go" || a.Count != 3 || a.Errors != 1 {
+		t.Errorf("changes[0] = %+v, want Path=/a.go Count=3 Errors=1", a)
+	}
+	if len(a.Tools) != 2 || a.Tools[0] != "Write" || a.Tools[1] != "Edit" {
+		t.Errorf("changes[0].Tools = %v, want [Write Edit]", a.Tools)
+	}
+
+	if changes[1].Path != "/n.ipynb" {
+		t.Errorf("changes[1].Path = %q, want %q", changes[1].Path, "/n.ipynb")
+	}
+}
+
+func TestChangeTrackerIgnoresNonMutatingTools(t *testing.T) {
+	tr := newChangeTracker()
+	tr.record("Read", map[string]any{"file_path": "/a.go"}, false)
+	tr.record("Bash", map[string]any{"command": "touch /a.go"}, false)
+	tr.record("Write", map[string]any{"content": "no path"}, false)
+
+	if changes := tr.snapshot(); changes != nil {
+		t.Errorf("snapshot() = %v, want nil", changes)
+	}
+}
+
+func TestRunReportsFileChanges(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Write a.go, fail an Edit on a.go, edit b.go with a path that a
+	// RedirectPath hook rewrites, and read c.go (not a change).
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"changes-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Write","input":{"file_path":"/work/a.go","content":"x"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"ok"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-2","name":"Edit","input":{"file_path":"/work/a.go"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-2","content":"no match","is_error":true}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-3","name":"Edit","input":{"file_path":"/tmp/b.go"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-3","tool_use_id":"tu-3","tool_name":"Edit","tool_input":{"file_path":"/tmp/b.go"}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-3","content":"ok"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-4","name":"Read","input":{"file_path":"/work/c.go"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-4","content":"package c"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var summary []FileChange
+	var redirect any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(RedirectPath("/tmp", "/sandbox/tmp")),
+		Audit(func(e AuditEvent) {
+			switch e.Type {
+			case "run.file_changes":
+				summary, _ = e.Data.(map[string]any)["files"].([]FileChange)
+			case "hook.pre_tool_use":
+				redirect = e.Data.(map[string]any)["redirect"]
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "edit some files")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(result.FileChanges) != 2 {
+		t.Fatalf("len(FileChanges) = %d, want 2: %+v", len(result.FileChanges), result.FileChanges)
+	}
+
+	a0 := result.FileChanges[0]
+	if a0.Path != "/work/a.go" || a0.Count != 2 || a0.Errors != 1 {
+		t.Errorf("FileChanges[0] = %+v, want Path=/work/a.go Count=2 Errors=1", a0)
+	}
+
+	b := result.FileChanges[1]
+	if b.Path != "/sandbox/tmp/b.go" {
+		t.Errorf("FileChanges[1].Path = %q, want redirected path %q", b.Path, "/sandbox/tmp/b.go")
+	}
+	if b.Errors != 0 {
+		t.Errorf("FileChanges[1].Errors = %d, want 0", b.Errors)
+	}
+	if a0.OriginalPath != "" || b.OriginalPath != "/tmp/b.go" {
+		t.Errorf("OriginalPath = %q and %q, want \"\" and /tmp/b.go", a0.OriginalPath, b.OriginalPath)
+	}
+	wantRedirects := []PathRedirect{{Original: "/tmp/b.go", Redirected: "/sandbox/tmp/b.go"}}
+	if !reflect.DeepEqual(result.PathRedirects, wantRedirects) {
+		t.Errorf("PathRedirects = %+v, want %+v", result.PathRedirects, wantRedirects)
+	}
+	wantAudit := map[string]any{"original": "/tmp/b.go", "redirected": "/sandbox/tmp/b.go"}
+	if !reflect.DeepEqual(redirect, wantAudit) {
+		t.Errorf("hook.pre_tool_use redirect = %v, want %v", redirect, wantAudit)
+	}
+
+	if last := a.LastRunChanges(); len(last) != 2 || last[1].Path != "/sandbox/tmp/b.go" {
+		t.Errorf("LastRunChanges() = %+v, want same as Result.FileChanges", last)
+	}
+	if len(summary) != 2 {
+		t.Errorf("run.file_changes event files = %d, want 2", len(summary))
+	}
+}
+
+// fileChangeCLI writes a fake CLI that makes a successful Write, a failed
+// Write, an Edit that RedirectPath rewrites, a Read, and a Write whose result
+// never arrives.
+func fileChangeCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"file-changed-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Write","input":{"file_path":"/work/a.go","content":"x"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"ok"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-2","name":"Write","input":{"file_path":"/readonly/b.go","content":"x"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-2","content":"permission denied","is_error":true}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-3","name":"Edit","input":{"file_path":"/tmp/c.go"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-3","tool_use_id":"tu-3","tool_name":"Edit","tool_input":{"file_path":"/tmp/c.go"}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-3","content":"ok"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-4","name":"Read","input":{"file_path":"/work/a.go"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-4","content":"x"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-5","name":"Write","input":{"file_path":"/work/d.go","content":"x"}}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestOnFileChanged(t *testing.T) {
+	var mu sync.Mutex
+	var events []FileChangeEvent
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fileChangeCLI(t)),
+		PreToolUse(RedirectPath("/tmp", "/sandbox/tmp")),
+		OnFileChanged(func(e FileChangeEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "edit some files")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// The Write to /work/d.go has no result, so it is not reported
+	want := []FileChangeEvent{
+		{Path: "/work/a.go", Tool: "Write", ToolUseID: "tu-1", RunID: result.RunID},
+		{Path: "/readonly/b.go", Tool: "Write", ToolUseID: "tu-2", IsError: true, RunID: result.RunID},
+		{Path: "/sandbox/tmp/c.go", OriginalPath: "/tmp/c.go", Tool: "Edit", ToolUseID: "tu-3", RunID: result.RunID},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events =\n%+v\nwant\n%+v", events, want)
+	}
+}
+
+func TestOnFileChangedAsyncRecoversPanics(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fileChangeCLI(t)),
+		AsyncHooks(1, 16),
+		OnFileChanged(
+			func(e FileChangeEvent) { panic("editor crashed") },
+			func(e FileChangeEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				paths = append(paths, e.Path)
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := a.Run(ctx, "edit some files"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mustClose(t, a) // Drains the hook pool
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"/work/a.go", "/readonly/b.go", "/tmp/c.go"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// NoCLICache makes New look for the claude CLI on PATH and in common
+// locations itself instead of reusing the path found by an earlier agent.
+// It has no effect with CLIPath.
+func NoCLICache() Option {
+	return func(c *config) {
+		c.noCLICache = true
+	}
+}
+
+// InvalidateCLICache forgets the CLI paths found so far, so that the next
+// New looks for the CLI again. Call it after upgrading the CLI, or in tests
+// that change PATH between agents.
+func InvalidateCLICache() {
+	cliCache.mu.Lock()
+	defer cliCache.mu.Unlock()
+	cliCache.entries = nil
+}
+
+// cliCache holds the CLI found for each set of search inputs. New looks
+// for the CLI only when no CLIPath is set, so all such agents in a process
+// start the same binary.
+var cliCache struct {
+	mu      sync.Mutex
+	entries map[string]*cliEntry // Keyed by cliSearchKey
+}
+
+// cliEntry is a resolved CLI and the binary's size and modification time
+// when it was found.
+type cliEntry struct {
+	once    sync.Once
+	path    string
+	err     error
+	size    int64
+	modTime time.Time
+}
+
+// cliSearchKey identifies the inputs findCLI searches with.
+func cliSearchKey() string {
+	home, _ := os.UserHomeDir()
+	return os.Getenv("PATH") + "\x00" + home
+}
+
+// lookupCLI returns the CLI path found by findCLI, from the cache unless
+// noCache is set. A CLI that was not found is looked for again next time.
+// warning is set if the binary has changed since it was first found.
+func lookupCLI(noCache bool) (path, warning string, err error) {
+	if noCache {
+		path, err = findCLI()
+		return path, "", err
+	}
+
+	key := cliSearchKey()
+	cliCache.mu.Lock()
+	if cliCache.entries == nil {
+		cliCache.entries = make(map[string]*cliEntry)
+	}
+	e := cliCache.entries[key]
+	if e == nil {
+		e = &cliEntry{}
+		cliCache.entries[key] = e
+	}
+	cliCache.mu.Unlock()
+
+	first := false
+	e.once.Do(func() {
+		first = true
+		e.path, e.err = findCLI()
+		if e.err != nil {
+			return
+		}
+		if info, err := os.Stat(e.path); err == nil {
+			e.size, e.modTime = info.Size(), info.ModTime()
+		}
+	})
+	if e.err != nil {
+		cliCache.mu.Lock()
+		if cliCache.entries[key] == e {
+			delete(cliCache.entries, key)
+		}
+		cliCache.mu.Unlock()
+		return "", "", e.err
+	}
+	if first {
+		return e.path, "", nil
+	}
+	return e.path, e.changed(), nil
+}
+
+// changed describes how the binary differs from when it was found, or
+// returns "" if it does not.
+func (e *cliEntry) changed() string {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return fmt.Sprintf("claude CLI %s cannot be read since it was first found: %v", e.path, err)
+	}
+	if info.Size() == e.size && info.ModTime().Equal(e.modTime) {
+		return ""
+	}
+	return fmt.Sprintf("claude CLI %s changed since it was first found (size %d to %d bytes, modified %s to %s); "+
+		"agents in this process may be running different versions; call InvalidateCLICache after an upgrade",
+		e.path, e.size, info.Size(), e.modTime.Format(time.RFC3339), info.ModTime().Format(time.RFC3339))
+}
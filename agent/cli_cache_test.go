@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pathEchoScript answers the first prompt with the path it was started as.
+const pathEchoScript = `#!/bin/sh
+read line
+printf '{"type":"result","result":"%s","num_turns":1}\n' "$0"
+read line || exit 0
+`
+
+// cliOnPath puts a fake claude in an empty PATH and HOME, so that New
+// finds only it, and clears the CLI cache before and after the test.
+func cliOnPath(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+	t.Setenv("HOME", t.TempDir())
+	fakeClaude := filepath.Join(dir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(pathEchoScript), 0755)
+	InvalidateCLICache()
+	t.Cleanup(InvalidateCLICache)
+	return fakeClaude
+}
+
+func TestCLICacheParallelNew(t *testing.T) {
+	fakeClaude := cliOnPath(t)
+
+	ctx := context.Background()
+	const n = 8
+	var wg sync.WaitGroup
+	results := make([]string, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a, err := New(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer func() { _ = a.Close() }()
+			result, err := a.Run(ctx, "where are you?")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if w := a.ConfigWarnings(); len(w) > 0 {
+				t.Errorf("agent %d ConfigWarnings() = %q, want none", i, w)
+			}
+			results[i] = result.ResultText
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < n; i++ {
+		if errs[i] != nil {
+			t.Errorf("agent %d error = %v", i, errs[i])
+		} else if results[i] != fakeClaude {
+			t.Errorf("agent %d ran %q, want %q", i, results[i], fakeClaude)
+		}
+	}
+	cliCache.mu.Lock()
+	defer cliCache.mu.Unlock()
+	if len(cliCache.entries) != 1 {
+		t.Errorf("cache has %d entries, want 1", len(cliCache.entries))
+	}
+}
+
+func TestCLICacheWarnsWhenBinaryChanges(t *testing.T) {
+	fakeClaude := cliOnPath(t)
+
+	var mu sync.Mutex
+	var audited []string
+	newAgent := func(opts ...Option) *Agent {
+		t.Helper()
+		opts = append(opts, Audit(func(e AuditEvent) {
+			if e.Type == "config.warning" {
+				mu.Lock()
+				audited = append(audited, e.Data.(map[string]any)["warning"].(string))
+				mu.Unlock()
+			}
+		}))
+		a, err := New(context.Background(), opts...)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		mustClose(t, a)
+		return a
+	}
+
+	if w := newAgent().ConfigWarnings(); len(w) != 0 {
+		t.Fatalf("first ConfigWarnings() = %q, want none", w)
+	}
+
+	// An upgrade in place changes the modification time
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(fakeClaude, later, later); err != nil {
+		t.Fatal(err)
+	}
+	w := newAgent().ConfigWarnings()
+	if len(w) != 1 || !strings.Contains(w[0], "claude CLI "+fakeClaude+" changed since it was first found") {
+		t.Errorf("ConfigWarnings() after change = %q, want a changed-binary warning", w)
+	}
+	mu.Lock()
+	if len(audited) != 1 || audited[0] != strings.Join(w, "") {
+		t.Errorf("config.warning events = %q, want %q", audited, w)
+	}
+	mu.Unlock()
+
+	if w := newAgent(NoCLICache()).ConfigWarnings(); len(w) != 0 {
+		t.Errorf("NoCLICache ConfigWarnings() = %q, want none", w)
+	}
+
+	InvalidateCLICache()
+	if w := newAgent().ConfigWarnings(); len(w) != 0 {
+		t.Errorf("ConfigWarnings() after InvalidateCLICache = %q, want none", w)
+	}
+}
+
+func TestCLICacheRetriesNotFound(t *testing.T) {
+	fakeClaude := cliOnPath(t)
+	if err := os.Remove(fakeClaude); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := findCLI(); err == nil {
+		t.Skip("a claude CLI is installed in a common location")
+	}
+
+	if _, _, err := lookupCLI(false); err == nil {
+		t.Fatal("lookupCLI() error = nil, want CLI not found")
+	}
+	mustWriteFile(t, fakeClaude, []byte(pathEchoScript), 0755)
+	path, _, err := lookupCLI(false)
+	if err != nil || path != fakeClaude {
+		t.Errorf("lookupCLI() = %q, %v, want %q once the CLI is installed", path, err, fakeClaude)
+	}
+}
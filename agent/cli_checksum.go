@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CLIChecksum pins the claude CLI to a SHA-256 digest, given in hex as
+// HashCLI returns it. Before starting the CLI, New hashes the binary it
+// resolved, from CLIPath or the search of PATH and common locations, and
+// fails with a *ChecksumMismatchError if the digest is not one of those
+// pinned. It adds to the digests from earlier calls and CLIChecksumFile,
+// so a fleet can allow the old and new binary during an upgrade. CLIVersion
+// verifies the binary the same way before running it.
+//
+// A binary is hashed again only when its size or modification time
+// changes, so pools of agents do not rehash it for every New. The check
+// does not stop a binary being replaced between the hash and the start;
+// pin a path only writable by the service's owner.
+//
+// Example:
+//
+//	a, err := agent.New(ctx,
+//	    agent.CLIPath("/opt/claude/bin/claude"),
+//	    agent.CLIChecksum("9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"),
+//	)
+//	var mismatch *agent.ChecksumMismatchError
+//	if errors.As(err, &mismatch) {
+//	    log.Fatalf("refusing to run %s: digest %s", mismatch.Path, mismatch.Actual)
+//	}
+func CLIChecksum(sha256hex string) Option {
+	return func(c *config) {
+		c.cliChecksums = append(c.cliChecksums, sha256hex)
+	}
+}
+
+// CLIChecksumFile pins the claude CLI to the SHA-256 digests listed in the
+// file at path, as for CLIChecksum. New reads the file each time. Each
+// line holds a digest in hex, optionally followed by whitespace and a
+// file name, so the output of sha256sum can be used as is. Blank lines
+// and lines starting with # are ignored. A file that cannot be read or
+// holds a malformed digest is a *ConfigError from New.
+func CLIChecksumFile(path string) Option {
+	return func(c *config) {
+		c.cliChecksumFile = path
+	}
+}
+
+// HashCLI returns the SHA-256 digest of the file at path in lowercase hex,
+// the form CLIChecksum expects. Use it in tooling that generates pins.
+func HashCLI(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }() // Read only
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cliChecksums returns the pinned digests, normalized to lowercase, or nil
+// if none are pinned.
+func cliChecksums(cfg *config) ([]string, error) {
+	var digests []string
+	for _, d := range cfg.cliChecksums {
+		if !isSHA256Hex(d) {
+			return nil, &ConfigError{Option: "CLIChecksum", Value: d, Reason: "not a SHA-256 digest in hex"}
+		}
+		digests = append(digests, strings.ToLower(d))
+	}
+	if cfg.cliChecksumFile == "" {
+		return digests, nil
+	}
+
+	data, err := os.ReadFile(cfg.cliChecksumFile)
+	if err != nil {
+		return nil, &ConfigError{Option: "CLIChecksumFile", Value: cfg.cliChecksumFile, Reason: err.Error()}
+	}
+	before := len(digests)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d := strings.Fields(line)[0]
+		if !isSHA256Hex(d) {
+			return nil, &ConfigError{
+				Option: "CLIChecksumFile",
+				Value:  cfg.cliChecksumFile,
+				Reason: fmt.Sprintf("line %d: not a SHA-256 digest in hex", i+1),
+			}
+		}
+		digests = append(digests, strings.ToLower(d))
+	}
+	if len(digests) == before {
+		return nil, &ConfigError{Option: "CLIChecksumFile", Value: cfg.cliChecksumFile, Reason: "no digests"}
+	}
+	return digests, nil
+}
+
+// isSHA256Hex reports whether s is 64 hex digits.
+func isSHA256Hex(s string) bool {
+	if len(s) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// verifyCLI checks the binary at path against the pinned digests, if any.
+func verifyCLI(cfg *config, path string) error {
+	digests, err := cliChecksums(cfg)
+	if err != nil || len(digests) == 0 {
+		return err
+	}
+	actual, err := cliDigest(path)
+	if err != nil {
+		return &StartError{Reason: "cannot hash claude CLI " + path, Cause: err}
+	}
+	for _, d := range digests {
+		if d == actual {
+			return nil
+		}
+	}
+	return &ChecksumMismatchError{Path: path, Expected: digests, Actual: actual}
+}
+
+// cliDigests caches the digest of each CLI binary hashed, keyed by path,
+// with the size and modification time it was hashed at.
+var cliDigests struct {
+	mu      sync.Mutex
+	entries map[string]cliDigestEntry
+}
+
+// cliDigestEntry is a binary's digest and the file's state when hashed.
+type cliDigestEntry struct {
+	digest  string
+	size    int64
+	modTime time.Time
+}
+
+// cliDigest returns the digest of the binary at path, hashing it unless
+// the cached digest was taken at the same size and modification time.
+func cliDigest(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	cliDigests.mu.Lock()
+	e, ok := cliDigests.entries[path]
+	cliDigests.mu.Unlock()
+	if ok && e.size == info.Size() && e.modTime.Equal(info.ModTime()) {
+		return e.digest, nil
+	}
+
+	digest, err := HashCLI(path)
+	if err != nil {
+		return "", err
+	}
+	cliDigests.mu.Lock()
+	if cliDigests.entries == nil {
+		cliDigests.entries = make(map[string]cliDigestEntry)
+	}
+	cliDigests.entries[path] = cliDigestEntry{digest: digest, size: info.Size(), modTime: info.ModTime()}
+	cliDigests.mu.Unlock()
+	return digest, nil
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checksumCLI writes a fake CLI that answers one prompt and returns its
+// path and digest.
+func checksumCLI(t *testing.T) (path, digest string) {
+	t.Helper()
+	path = filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, path, []byte(script), 0755)
+	sum := sha256.Sum256([]byte(script))
+	return path, hex.EncodeToString(sum[:])
+}
+
+func TestHashCLI(t *testing.T) {
+	path, digest := checksumCLI(t)
+	got, err := HashCLI(path)
+	if err != nil || got != digest {
+		t.Errorf("HashCLI() = %q, %v, want %q", got, err, digest)
+	}
+	if _, err := HashCLI(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("HashCLI() of a missing file succeeded")
+	}
+}
+
+func TestCLIChecksumMatch(t *testing.T) {
+	path, digest := checksumCLI(t)
+	ctx := context.Background()
+
+	other := strings.Repeat("0", 64)
+	a, err := New(ctx, CLIPath(path), CLIChecksum(other), CLIChecksum(strings.ToUpper(digest)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+
+	if _, err := CLIVersion(ctx, CLIPath(path), CLIChecksum(other)); !errors.As(err, new(*ChecksumMismatchError)) {
+		t.Errorf("CLIVersion() error = %v, want a *ChecksumMismatchError", err)
+	}
+}
+
+func TestCLIChecksumMismatch(t *testing.T) {
+	path, digest := checksumCLI(t)
+	pinned := strings.Repeat("ab", 32)
+
+	_, err := New(context.Background(), CLIPath(path), CLIChecksum(pinned))
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("New() error = %v, want a *ChecksumMismatchError", err)
+	}
+	if mismatch.Path != path || mismatch.Actual != digest || len(mismatch.Expected) != 1 || mismatch.Expected[0] != pinned {
+		t.Errorf("error = %+v, want the path, %s, and the pinned digest", mismatch, digest)
+	}
+}
+
+func TestCLIChecksumFile(t *testing.T) {
+	path, digest := checksumCLI(t)
+	dir := t.TempDir()
+
+	pins := filepath.Join(dir, "claude.sha256")
+	mustWriteFile(t, pins, []byte("# Allowed CLI builds\n"+strings.Repeat("1", 64)+"  claude-old\n\n"+digest+"  claude\n"), 0o644)
+	a, err := New(context.Background(), CLIPath(path), CLIChecksumFile(pins))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mustClose(t, a)
+
+	tests := map[string]string{
+		"malformed": "not-a-digest\n",
+		"empty":     "# No pins yet\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			mustWriteFile(t, file, []byte(content), 0o644)
+			var cerr *ConfigError
+			if _, err := New(context.Background(), CLIPath(path), CLIChecksumFile(file)); !errors.As(err, &cerr) || cerr.Option != "CLIChecksumFile" {
+				t.Errorf("New() error = %v, want a *ConfigError for CLIChecksumFile", err)
+			}
+		})
+	}
+
+	var cerr *ConfigError
+	if _, err := New(context.Background(), CLIPath(path), CLIChecksum("abc")); !errors.As(err, &cerr) || cerr.Option != "CLIChecksum" {
+		t.Errorf("New() error = %v, want a *ConfigError for CLIChecksum", err)
+	}
+}
+
+func TestCLIChecksumCache(t *testing.T) {
+	path, digest := checksumCLI(t)
+	cfg := newConfig(CLIPath(path), CLIChecksum(digest))
+	if err := verifyCLI(cfg, path); err != nil {
+		t.Fatalf("verifyCLI() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same size and modification time: the cached digest is used
+	tampered, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)-2] = '1' // "exit 0" becomes "exit 1"
+	mustWriteFile(t, path, tampered, 0755)
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCLI(cfg, path); err != nil {
+		t.Errorf("verifyCLI() error = %v, want the cached digest to match", err)
+	}
+
+	// A new modification time invalidates it
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	var mismatch *ChecksumMismatchError
+	if err := verifyCLI(cfg, path); !errors.As(err, &mismatch) || mismatch.Actual == digest {
+		t.Errorf("verifyCLI() error = %v, want a *ChecksumMismatchError with the new digest", err)
+	}
+}
@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// cliFeature describes a CLI flag that older CLI versions reject.
+type cliFeature struct {
+	option     string // Option that passes the flag
+	minVersion string // First CLI version known to accept the flag
+	fallback   string // Option that works without the flag, if any
+}
+
+// cliFeatures lists flags added to the CLI after its first release. Flags
+// not listed are still reported, without a version.
+var cliFeatures = map[string]cliFeature{
+	"--json-schema":     {option: "WithSchema", minVersion: "2.0.42", fallback: "SchemaFallbackPrompt(true)"},
+	"--setting-sources": {option: "SettingSources", minVersion: "2.0.0"},
+}
+
+// unknownFlagPattern matches the CLI's usage error for a flag it does not
+// know, such as "error: unknown option '--json-schema'".
+var unknownFlagPattern = regexp.MustCompile(`(?i)(?:unknown|unrecognized) (?:option|flag|argument):? ['"]?(--[a-z0-9-]+)`)
+
+// unsupportedFeatureError builds the error for CLI text rejecting a flag.
+func unsupportedFeatureError(text string, cause error) error {
+	m := unknownFlagPattern.FindStringSubmatch(text)
+	if m == nil {
+		return nil
+	}
+	feature := cliFeatures[m[1]]
+	return &UnsupportedFeatureError{
+		Flag:       m[1],
+		Option:     feature.option,
+		MinVersion: feature.minVersion,
+		Fallback:   feature.fallback,
+		Message:    text,
+		Cause:      cause,
+	}
+}
+
+// versionPattern matches the version in the CLI's --version output, such
+// as "2.0.42 (Claude Code)".
+var versionPattern = regexp.MustCompile(`\d+\.\d+\.\d+`)
+
+// CLIVersion returns the version of the CLI that New would start with
+// opts, such as "2.0.42": the CLIPath if set, otherwise the claude found
+// on PATH or in common locations. It runs the CLI with --version and the
+// agent's environment, and starts no session.
+func CLIVersion(ctx context.Context, opts ...Option) (string, error) {
+	cfg := newConfig(opts...)
+	path := cfg.cliPath
+	if path == "" {
+		var err error
+		path, _, err = lookupCLI(cfg.noCLICache)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := verifyCLI(cfg, path); err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, path, "--version") // #nosec G204 -- CLI path is configured by the application
+	cmd.Env = processEnv(cfg)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", &StartError{Reason: "claude --version failed", Cause: err}
+	}
+	version := versionPattern.FindString(string(out))
+	if version == "" {
+		return "", &StartError{Reason: fmt.Sprintf("unrecognized claude --version output %q", strings.TrimSpace(string(out)))}
+	}
+	return version, nil
+}
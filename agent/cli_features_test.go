@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestCLIVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		wantErr bool
+	}{
+		{"version", "#!/bin/sh\necho '2.0.42 (Claude Code)'\n", "2.0.42", false},
+		{"unrecognized", "#!/bin/sh\necho 'Claude Code'\n", "", true},
+		{"failed", "#!/bin/sh\nexit 1\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli := filepath.Join(t.TempDir(), "claude")
+			mustWriteFile(t, cli, []byte(tt.script), 0755)
+
+			got, err := CLIVersion(context.Background(), CLIPath(cli))
+			if got != tt.want {
+				t.Errorf("CLIVersion() = %q, want %q", got, tt.want)
+			}
+			var startErr *StartError
+			if tt.wantErr != (err != nil) || (err != nil && !errors.As(err, &startErr)) {
+				t.Errorf("CLIVersion() error = %v, want a *StartError: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
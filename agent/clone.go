@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"regexp"
+)
+
+// Clone creates a sibling agent with the same configuration and a fresh
+// CLI process, then applies extra options on top, for example a different
+// Model or Fork(a.SessionID()) to continue the conversation.
+//
+// The clone starts a new session: Resume, Fork, and ForkFrom are not
+// carried over. Labels and default run options are copied as they are now,
+// including those added with SetLabel and WithRunDefaults. Maps and slices are copied, so options applied to one
+// agent never affect the other, but hooks, custom tools, and audit handlers
+// are function values and are shared by reference. A file opened by
+// AuditToFile stays owned by the original and is closed with it, and a
+// RecordCLI recording is not carried over.
+//
+// Clone works on a closed agent; only its process is gone.
+func (a *Agent) Clone(ctx context.Context, extra ...Option) (*Agent, error) {
+	cfg := a.cfg.clone()
+	cfg.labels = a.Labels()
+	cfg.runDefaults = a.runDefaultOptions()
+	for _, opt := range extra {
+		opt(cfg)
+	}
+	return newAgent(ctx, cfg)
+}
+
+// clone returns a copy of the configuration for a new session. Maps and
+// slices are copied; function values are shared.
+func (c *config) clone() *config {
+	n := *c
+
+	n.preToolUseHooks = append([]PreToolUseHook(nil), c.preToolUseHooks...)
+	n.preToolUsePriorities = append([]int(nil), c.preToolUsePriorities...)
+	n.preToolUseRedirects = append([]*redirectRule(nil), c.preToolUseRedirects...)
+	n.pathHooks = pathHookArgs{
+		allow: append([]string(nil), c.pathHooks.allow...),
+		deny:  append([]string(nil), c.pathHooks.deny...),
+	}
+	n.profiles = append([]ProfileDescription(nil), c.profiles...)
+	n.tools = append([]string(nil), c.tools...)
+	n.allowedTools = append([]string(nil), c.allowedTools...)
+	n.disallowedTools = append([]string(nil), c.disallowedTools...)
+	n.env = copyStringMap(c.env)
+	n.addDirs = append([]string(nil), c.addDirs...)
+	n.settingSources = append([]string(nil), c.settingSources...)
+	n.contextWatchers = append([]contextUsageWatcher(nil), c.contextWatchers...)
+	n.labels = copyLabels(c.labels)
+	n.auditHandlers = append([]AuditHandler(nil), c.auditHandlers...)
+	n.postToolUseHooks = append([]PostToolUseHook(nil), c.postToolUseHooks...)
+	n.reactions = append([]ToolResultReaction(nil), c.reactions...)
+	n.stopHooks = append([]StopHook(nil), c.stopHooks...)
+	n.preCompactHooks = append([]PreCompactHook(nil), c.preCompactHooks...)
+	n.subagentStartHooks = append([]SubagentStartHook(nil), c.subagentStartHooks...)
+	n.subagentStopHooks = append([]SubagentStopHook(nil), c.subagentStopHooks...)
+	n.mcpStatusHooks = append([]MCPStatusHook(nil), c.mcpStatusHooks...)
+	n.initHooks = append([]InitHook(nil), c.initHooks...)
+	n.restartHooks = append([]RestartHook(nil), c.restartHooks...)
+	n.userPromptSubmitHooks = append([]UserPromptSubmitHook(nil), c.userPromptSubmitHooks...)
+	n.controlHandlers = append([]ControlRequestHandler(nil), c.controlHandlers...)
+	n.skillDirs = append([]string(nil), c.skillDirs...)
+	n.declaredTools = append([]string(nil), c.declaredTools...)
+	n.cliChecksums = append([]string(nil), c.cliChecksums...)
+	n.runDefaults = append([]RunOption(nil), c.runDefaults...)
+	n.scrubPatterns = append([]*regexp.Regexp(nil), c.scrubPatterns...)
+
+	// The original agent closes its audit files and owns its recording
+	// and wire log file
+	n.auditCleanup = nil
+	n.recordPath = ""
+	if c.debugWireOwned {
+		n.debugWire = nil
+		n.debugWireOwned = false
+	}
+
+	// A clone starts its own session
+	n.resume = ""
+	n.fork = false
+	n.forkFrom = nil
+	n.forkTranscript = ""
+
+	// A clone creates its own scratch directory
+	n.scratchPath = ""
+
+	// A clone creates its own worktree
+	n.worktreePath = ""
+	n.worktreeHead = ""
+
+	// A clone looks for the CLI again, through the cache
+	n.resolvedCLI = ""
+
+	if c.asyncHooks != nil {
+		async := *c.asyncHooks
+		n.asyncHooks = &async
+	}
+
+	if c.customTools != nil {
+		n.customTools = make(map[string]Tool, len(c.customTools))
+		for name, tool := range c.customTools {
+			n.customTools[name] = tool
+		}
+	}
+	if c.mcpServers != nil {
+		n.mcpServers = make(map[string]*MCPConfig, len(c.mcpServers))
+		for name, mcp := range c.mcpServers {
+			m := *mcp
+			m.Args = append([]string(nil), mcp.Args...)
+			m.Headers = copyStringMap(mcp.Headers)
+			m.Env = copyStringMap(mcp.Env)
+			m.AllowTools = append([]string(nil), mcp.AllowTools...)
+			m.DenyTools = append([]string(nil), mcp.DenyTools...)
+			n.mcpServers[name] = &m
+		}
+	}
+	if c.subagents != nil {
+		n.subagents = make(map[string]*SubagentConfig, len(c.subagents))
+		for name, sub := range c.subagents {
+			s := *sub
+			s.Tools = append([]string(nil), sub.Tools...)
+			n.subagents[name] = &s
+		}
+	}
+	if c.skills != nil {
+		n.skills = make(map[string]*SkillConfig, len(c.skills))
+		for name, skill := range c.skills {
+			s := *skill
+			n.skills[name] = &s
+		}
+	}
+
+	return &n
+}
+
+// copyStringMap returns a copy of m, preserving nil.
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// cloneTestCLI writes a fake CLI that reports its CLONE_MARK variable in
+// the result text.
+func cloneTestCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '{"type":"result","result":"%s","num_turns":1}\n' "$CLONE_MARK"
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestCloneAppliesOverridesWithoutAliasing(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(cloneTestCLI(t)),
+		Model("claude-sonnet-4-5"),
+		Env("CLONE_MARK", "original"),
+		AllowedTools("Read"),
+		Labels(map[string]string{"team": "core"}),
+		MCPServer("files", MCPCommand("mcp-files"), MCPArgs("--root", "/srv")),
+		PreToolUse(DenyCommands("rm -rf")),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+	if err := a.SetLabel("tenant", "acme"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+
+	c, err := a.Clone(ctx,
+		Model("claude-haiku-4-5"),
+		Env("CLONE_MARK", "clone"),
+		AllowedTools("Bash"),
+		PreToolUse(DenyCommands("sudo")),
+	)
+	if err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	defer mustClose(t, c)
+
+	// Mutate the clone's configuration directly as well
+	c.cfg.env["EXTRA"] = "1"
+	c.cfg.mcpServers["files"].Args[1] = "/tmp"
+
+	if a.cfg.model != "claude-sonnet-4-5" || c.cfg.model != "claude-haiku-4-5" {
+		t.Errorf("models = %q/%q, want the override on the clone only", a.cfg.model, c.cfg.model)
+	}
+	if a.cfg.env["CLONE_MARK"] != "original" || a.cfg.env["EXTRA"] != "" {
+		t.Errorf("original env = %v, want it untouched", a.cfg.env)
+	}
+	if a.cfg.allowedTools[0] != "Read" || c.cfg.allowedTools[0] != "Bash" {
+		t.Errorf("allowedTools = %v/%v, want the override on the clone only", a.cfg.allowedTools, c.cfg.allowedTools)
+	}
+	if len(a.cfg.preToolUseHooks) != 1 || len(c.cfg.preToolUseHooks) != 2 {
+		t.Errorf("PreToolUse hooks = %d/%d, want the extra hook on the clone only",
+			len(a.cfg.preToolUseHooks), len(c.cfg.preToolUseHooks))
+	}
+	if got := a.cfg.mcpServers["files"].Args[1]; got != "/srv" {
+		t.Errorf("original MCP args[1] = %q, want /srv", got)
+	}
+	if got := c.Labels(); got["team"] != "core" || got["tenant"] != "acme" {
+		t.Errorf("clone labels = %v, want team and tenant", got)
+	}
+
+	for agent, want := range map[*Agent]string{a: "original", c: "clone"} {
+		result, err := agent.Run(ctx, "mark")
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if result.ResultText != want {
+			t.Errorf("process saw CLONE_MARK=%q, want %q", result.ResultText, want)
+		}
+	}
+}
+
+func TestCloneClosedAgentStartsFreshSession(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(cloneTestCLI(t)), Env("CLONE_MARK", "x"), Resume("session-1"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	c, err := a.Clone(ctx)
+	if err != nil {
+		t.Fatalf("Clone() of closed agent error = %v", err)
+	}
+	defer mustClose(t, c)
+
+	if c.cfg.resume != "" {
+		t.Errorf("clone resume = %q, want a fresh session", c.cfg.resume)
+	}
+	result, err := c.Run(ctx, "mark")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.ResultText != "x" {
+		t.Errorf("process saw CLONE_MARK=%q, want x", result.ResultText)
+	}
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PreserveFunc summarizes a transcript that is about to be compacted. The
+// returned text is carried into the next prompt.
+type PreserveFunc func(transcriptPath string) (string, error)
+
+// Delimiters around preserved context in the next prompt.
+const (
+	preservedContextStart = "<preserved-context>\nPreserved context from before the conversation was compacted:\n"
+	preservedContextEnd   = "\n</preserved-context>\n\n"
+)
+
+// withPreservedContext prepends a preserved context block to prompt.
+func withPreservedContext(summary, prompt string) string {
+	return preservedContextStart + summary + preservedContextEnd + prompt
+}
+
+// preserveOnCompact runs the PreserveOnCompact callback and stores its
+// summary for the next prompt. Failures are reported as audit events and
+// never end the run.
+func (a *Agent) preserveOnCompact(sessionID, transcriptPath string) {
+	summary, err := callPreserve(a.cfg.preserveFn, transcriptPath)
+	if err != nil {
+		a.auditor.emit(sessionID, "compact.preserve_failed", map[string]any{
+			"transcript_path": transcriptPath,
+			"error":           err.Error(),
+		})
+		return
+	}
+
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return
+	}
+
+	// A later compaction's summary replaces an unused earlier one
+	a.mu.Lock()
+	a.preserved = summary
+	a.mu.Unlock()
+
+	a.auditor.emit(sessionID, "compact.preserved", map[string]any{
+		"transcript_path": transcriptPath,
+		"bytes":           len(summary),
+	})
+}
+
+// callPreserve calls fn, converting a panic into an error.
+func callPreserve(fn PreserveFunc, transcriptPath string) (summary string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("preserve callback panicked: %v", r)
+		}
+	}()
+	return fn(transcriptPath)
+}
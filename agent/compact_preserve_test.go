@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// compactCLI writes a fake CLI whose first run compacts the given
+// transcript and whose later runs record the prompts they receive.
+func compactCLI(t *testing.T, transcript, wire string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"compact-test"}'
+printf '%s\n' '{"type":"system","subtype":"compact","trigger":"auto","transcript_path":"` + transcript + `","token_count":95000}'
+printf '%s\n' '{"type":"result","result":"First","num_turns":1}'
+read -r line
+printf '%s\n' "$line" > ` + wire + `
+printf '%s\n' '{"type":"result","result":"Second","num_turns":1}'
+read -r line
+printf '%s\n' "$line" >> ` + wire + `
+printf '%s\n' '{"type":"result","result":"Third","num_turns":1}'
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestPreserveOnCompactInjectsSummary(t *testing.T) {
+	tmpDir := t.TempDir()
+	transcript := filepath.Join(tmpDir, "transcript.jsonl")
+	mustWriteFile(t, transcript, []byte(`{"type":"user","message":{"content":"Use library X because Y"}}`+"\n"), 0644)
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	var gotPath string
+	var mu sync.Mutex
+	var prompts []string
+	var audited []string
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(compactCLI(t, transcript, wire)),
+		PreserveOnCompact(func(path string) (string, error) {
+			gotPath = path
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", err
+			}
+			if !strings.Contains(string(data), "library X") {
+				return "", errors.New("unexpected transcript")
+			}
+			return "We chose library X because Y.", nil
+		}),
+		UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+			mu.Lock()
+			defer mu.Unlock()
+			prompts = append(prompts, e.Prompt)
+			return PromptSubmitResult{}
+		}),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			audited = append(audited, e.Type)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for _, p := range []string{"first", "second", "third"} {
+		if _, err := a.Run(ctx, p); err != nil {
+			t.Fatalf("Run(%q) error = %v", p, err)
+		}
+	}
+
+	if gotPath != transcript {
+		t.Errorf("callback path = %q, want %q", gotPath, transcript)
+	}
+
+	want := withPreservedContext("We chose library X because Y.", "second")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(prompts) != 3 {
+		t.Fatalf("UserPromptSubmit saw %d prompts, want 3", len(prompts))
+	}
+	if prompts[1] != want {
+		t.Errorf("second prompt = %q, want %q", prompts[1], want)
+	}
+	if prompts[2] != "third" {
+		t.Errorf("third prompt = %q; preserved context should be injected once", prompts[2])
+	}
+	if !strings.HasPrefix(want, "<preserved-context>\nPreserved context") {
+		t.Errorf("block is not delimited: %q", want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(mustReadFile(t, wire))), "\n")
+	if !strings.Contains(lines[0], `We chose library X because Y.`) || !strings.Contains(lines[0], `\u003c/preserved-context\u003e\n\nsecond`) {
+		t.Errorf("second prompt on the wire = %s, want the preserved block", lines[0])
+	}
+	if strings.Contains(lines[1], "preserved-context") {
+		t.Errorf("third prompt on the wire = %s, want no preserved block", lines[1])
+	}
+
+	if !containsString(audited, "compact.preserved") {
+		t.Errorf("audit events %v lack compact.preserved", audited)
+	}
+}
+
+func TestPreserveOnCompactFailureDoesNotBreakRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	var mu sync.Mutex
+	failures := map[string]any{}
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(compactCLI(t, filepath.Join(tmpDir, "missing.jsonl"), wire)),
+		PreserveOnCompact(func(path string) (string, error) {
+			_, err := os.ReadFile(path)
+			return "", err
+		}),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Type == "compact.preserve_failed" {
+				failures = e.Data.(map[string]any)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for _, p := range []string{"first", "second", "third"} {
+		if _, err := a.Run(ctx, p); err != nil {
+			t.Fatalf("Run(%q) error = %v", p, err)
+		}
+	}
+
+	if line := string(mustReadFile(t, wire)); strings.Contains(line, "preserved-context") {
+		t.Errorf("prompt on the wire = %s, want no preserved block", line)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if msg, _ := failures["error"].(string); !strings.Contains(msg, "missing.jsonl") {
+		t.Errorf("compact.preserve_failed data = %v, want the read error", failures)
+	}
+}
+
+func TestCallPreserveRecoversPanic(t *testing.T) {
+	_, err := callPreserve(func(string) (string, error) { panic("boom") }, "/t")
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("callPreserve() error = %v, want panic converted to error", err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+// Package conformance checks that the installed Claude CLI behaves the way
+// the SDK expects, so that a CLI update that renames a flag or changes a
+// message shape is found before production traffic finds it.
+//
+// Check runs a short battery of checks against the real CLI: its version,
+// the stream-json init handshake, a prompt and result round trip,
+// structured output, resuming the session just created, and the
+// permission mode flag. Each check that sends a prompt makes one small API
+// call. A failed check carries a message saying what broke and, where
+// known, what to do about it.
+//
+// Services can run Check at startup and refuse to start on an incompatible
+// CLI:
+//
+//	report, err := conformance.Check(ctx, agent.Model("haiku"))
+//	if err != nil {
+//	    log.Fatalf("claude CLI %s is not compatible: %v", report.CLIVersion, err)
+//	}
+//
+// Tests built with the acceptance tag can run the checks as subtests with
+// Run.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// Names of the checks, in the order they run.
+const (
+	CheckVersion          = "version"
+	CheckInit             = "init"
+	CheckRoundTrip        = "round_trip"
+	CheckStructuredOutput = "structured_output"
+	CheckResume           = "resume"
+	CheckPermissionMode   = "permission_mode"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+// Check outcomes.
+const (
+	StatusPass Status = "pass"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip" // Not run because a check it depends on failed
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string
+	Status   Status
+	Message  string // Why the check failed or was skipped; empty if it passed
+	Duration time.Duration
+}
+
+// Report is the outcome of Check.
+type Report struct {
+	CLIVersion string   // Empty if the version could not be detected
+	OK         bool     // No check failed
+	Results    []Result // In the order the checks ran
+	Duration   time.Duration
+}
+
+// Failed returns the checks that failed.
+func (r *Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Status == StatusFail {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Result returns the outcome of the named check.
+func (r *Report) Result(name string) (Result, bool) {
+	for _, res := range r.Results {
+		if res.Name == name {
+			return res, true
+		}
+	}
+	return Result{}, false
+}
+
+// Error lists the checks that failed.
+type Error struct {
+	Failed []Result
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Failed))
+	for i, res := range e.Failed {
+		msgs[i] = res.Name + ": " + res.Message
+	}
+	noun := "checks"
+	if len(e.Failed) == 1 {
+		noun = "check"
+	}
+	return fmt.Sprintf("conformance: %d %s failed: %s", len(e.Failed), noun, strings.Join(msgs, "; "))
+}
+
+// Prompts of the checks that make an API call.
+const (
+	prompt       = "Reply with only the word ok. Do not use any tools."
+	schemaPrompt = "Set reply to the word ok. Do not use any tools."
+)
+
+// Check runs the checks against the CLI that agent.New would start with
+// opts, such as agent.CLIPath or agent.Model. It always returns a report;
+// the error is an *Error if any check failed. Checks that need a session
+// are skipped if the init check fails.
+func Check(ctx context.Context, opts ...agent.Option) (*Report, error) {
+	start := time.Now()
+	c := &checker{opts: opts, report: &Report{}}
+
+	c.run(CheckVersion, "", func() string {
+		version, err := agent.CLIVersion(ctx, opts...)
+		if err != nil {
+			return "could not get the CLI version: " + err.Error()
+		}
+		c.report.CLIVersion = version
+		return ""
+	})
+	notRunnable := ""
+	if c.failed(CheckVersion) {
+		notRunnable = "the CLI could not be run"
+	}
+
+	// One run covers the handshake and the round trip
+	var sessionID string
+	var roundTrip string
+	c.run(CheckInit, notRunnable, func() string {
+		a, err := agent.New(ctx, c.with()...)
+		if err != nil {
+			return "could not start the CLI: " + err.Error()
+		}
+		defer func() { _ = a.Close() }()
+		result, err := a.Run(ctx, prompt)
+		sessionID = a.SessionID()
+		switch {
+		case sessionID == "" && err != nil:
+			return "no system init message before the run failed; the stream-json handshake may have changed: " + err.Error()
+		case sessionID == "":
+			return "no system init message with a session ID; the stream-json handshake may have changed"
+		case err != nil:
+			roundTrip = "the run failed: " + err.Error()
+		case result.IsError:
+			roundTrip = "the result reported an error: " + result.ResultText
+		case result.ResultText == "":
+			roundTrip = "the result had no text; the result message shape may have changed"
+		}
+		return ""
+	})
+	noSession := notRunnable
+	if noSession == "" && c.failed(CheckInit) {
+		noSession = "the init check failed"
+	}
+	c.run(CheckRoundTrip, noSession, func() string { return roundTrip })
+
+	c.run(CheckStructuredOutput, notRunnable, func() string {
+		var out struct {
+			Reply string `json:"reply" desc:"The word ok"`
+		}
+		a, err := agent.New(ctx, c.with(agent.WithSchema(out))...)
+		if err == nil {
+			defer func() { _ = a.Close() }()
+			_, err = a.RunWithSchema(ctx, schemaPrompt, &out)
+		}
+		if err != nil {
+			return rejected(err, "--json-schema", "structured output")
+		}
+		if out.Reply == "" {
+			return "the structured result had no reply field; the schema was not applied"
+		}
+		return ""
+	})
+
+	noResume := noSession
+	if noResume == "" && c.failed(CheckRoundTrip) {
+		noResume = "the round trip check failed"
+	}
+	c.run(CheckResume, noResume, func() string {
+		a, err := agent.New(ctx, c.with(agent.Resume(sessionID))...)
+		if err == nil {
+			defer func() { _ = a.Close() }()
+			_, err = a.Run(ctx, prompt)
+		}
+		if err != nil {
+			return rejected(err, "--resume", "resuming session "+sessionID)
+		}
+		return ""
+	})
+
+	c.run(CheckPermissionMode, notRunnable, func() string {
+		mode := agent.PermissionAcceptEdits
+		a, err := agent.New(ctx, c.with(agent.PermissionPrompt(mode))...)
+		if err == nil {
+			defer func() { _ = a.Close() }()
+			_, err = a.Run(ctx, prompt)
+		}
+		if err != nil {
+			return rejected(err, "--permission-mode", "permission mode "+string(mode))
+		}
+		return ""
+	})
+
+	c.report.Duration = time.Since(start)
+	failed := c.report.Failed()
+	c.report.OK = len(failed) == 0
+	if len(failed) > 0 {
+		return c.report, &Error{Failed: failed}
+	}
+	return c.report, nil
+}
+
+// checker runs checks and records their results.
+type checker struct {
+	opts   []agent.Option
+	report *Report
+}
+
+// run runs the named check, or skips it with the reason skip if that is
+// not empty. fn returns why the check failed, or "" if it passed.
+func (c *checker) run(name, skip string, fn func() string) {
+	if skip != "" {
+		c.report.Results = append(c.report.Results, Result{Name: name, Status: StatusSkip, Message: "skipped: " + skip})
+		return
+	}
+	start := time.Now()
+	res := Result{Name: name, Status: StatusPass}
+	if msg := fn(); msg != "" {
+		res.Status = StatusFail
+		res.Message = msg
+	}
+	res.Duration = time.Since(start)
+	c.report.Results = append(c.report.Results, res)
+}
+
+// failed reports whether the named check failed.
+func (c *checker) failed(name string) bool {
+	res, _ := c.report.Result(name)
+	return res.Status == StatusFail
+}
+
+// with returns the caller's options followed by extra.
+func (c *checker) with(extra ...agent.Option) []agent.Option {
+	return append(append([]agent.Option(nil), c.opts...), extra...)
+}
+
+// rejected describes a run that failed, naming the flag the check is about
+// when the CLI rejected it.
+func rejected(err error, flag, feature string) string {
+	var unsupported *agent.UnsupportedFeatureError
+	if errors.As(err, &unsupported) {
+		msg := fmt.Sprintf("CLI rejected %s; %s unsupported on this version", unsupported.Flag, feature)
+		if unsupported.MinVersion != "" {
+			msg += "; upgrade to CLI " + unsupported.MinVersion + " or later"
+		}
+		if unsupported.Fallback != "" {
+			msg += " or use " + unsupported.Fallback
+		}
+		return msg
+	}
+	return fmt.Sprintf("%s failed (flag %s): %v", feature, flag, err)
+}
@@ -0,0 +1,105 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// fakeCLI writes script to its own directory and returns its path.
+//
+//nolint:gosec // G306: Test scripts need executable permissions
+func fakeCLI(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// cliScript behaves like a conforming CLI. OLD_SCHEMA is replaced to make it
+// reject --json-schema like versions that predate it.
+const cliScript = `#!/bin/sh
+schema=
+for arg in "$@"; do
+  case "$arg" in
+  --version) echo '2.1.0 (Claude Code)'; exit 0 ;;
+  --json-schema) schema=1 ;;
+  esac
+done
+if [ -n "$schema" ] && [ -n "OLD_SCHEMA" ]; then
+  echo "error: unknown option '--json-schema'" >&2
+  exit 1
+fi
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"conformance-test"}'
+if [ -n "$schema" ]; then
+  printf '%s\n' '{"type":"result","result":"{\"reply\":\"ok\"}","num_turns":1}'
+else
+  printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+fi
+read line || exit 0
+`
+
+func statuses(report *Report) string {
+	var s []string
+	for _, res := range report.Results {
+		s = append(s, res.Name+"="+string(res.Status))
+	}
+	return strings.Join(s, ",")
+}
+
+func TestCheckPasses(t *testing.T) {
+	cli := fakeCLI(t, strings.Replace(cliScript, "OLD_SCHEMA", "", 1))
+	report, err := Check(context.Background(), agent.CLIPath(cli))
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !report.OK || report.CLIVersion != "2.1.0" {
+		t.Errorf("OK, CLIVersion = %v, %q; want true, 2.1.0", report.OK, report.CLIVersion)
+	}
+	want := "version=pass,init=pass,round_trip=pass,structured_output=pass,resume=pass,permission_mode=pass"
+	if got := statuses(report); got != want {
+		t.Errorf("results = %s, want %s", got, want)
+	}
+}
+
+func TestCheckUnsupportedSchema(t *testing.T) {
+	cli := fakeCLI(t, strings.Replace(cliScript, "OLD_SCHEMA", "old", 1))
+	report, err := Check(context.Background(), agent.CLIPath(cli))
+	var confErr *Error
+	if !errors.As(err, &confErr) || len(confErr.Failed) != 1 {
+		t.Fatalf("Check() error = %v, want an *Error with one failed check", err)
+	}
+	if report.OK {
+		t.Error("OK = true, want false")
+	}
+	res, _ := report.Result(CheckStructuredOutput)
+	if res.Status != StatusFail || !strings.Contains(res.Message, "CLI rejected --json-schema; structured output unsupported on this version") {
+		t.Errorf("structured_output = %+v, want a failure naming the flag", res)
+	}
+	if !strings.Contains(err.Error(), "structured_output: CLI rejected --json-schema") {
+		t.Errorf("Error() = %q, want the failed check", err.Error())
+	}
+}
+
+func TestCheckSkipsWithoutCLI(t *testing.T) {
+	cli := fakeCLI(t, "#!/bin/sh\nexit 1\n")
+	report, err := Check(context.Background(), agent.CLIPath(cli))
+	if err == nil {
+		t.Fatal("Check() error = nil, want an error")
+	}
+	want := "version=fail,init=skip,round_trip=skip,structured_output=skip,resume=skip,permission_mode=skip"
+	if got := statuses(report); got != want {
+		t.Errorf("results = %s, want %s", got, want)
+	}
+	if failed := report.Failed(); len(failed) != 1 || failed[0].Name != CheckVersion {
+		t.Errorf("Failed() = %+v, want only the version check", failed)
+	}
+}
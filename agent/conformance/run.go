@@ -0,0 +1,43 @@
+//go:build acceptance
+
+package conformance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// Timeout bounds all the checks that Run runs.
+const Timeout = 5 * time.Minute
+
+// Run runs the checks against the installed CLI and reports each as a
+// subtest of t, so that a CI job fails with the name of the check that
+// broke:
+//
+//	//go:build acceptance
+//
+//	func TestCLIConformance(t *testing.T) {
+//	    conformance.Run(t, agent.Model("haiku"))
+//	}
+func Run(t *testing.T, opts ...agent.Option) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	report, _ := Check(ctx, opts...)
+	t.Logf("claude CLI version %q", report.CLIVersion)
+	for _, res := range report.Results {
+		res := res
+		t.Run(res.Name, func(t *testing.T) {
+			switch res.Status {
+			case StatusFail:
+				t.Errorf("%s (CLI %s)", res.Message, report.CLIVersion)
+			case StatusSkip:
+				t.Skip(res.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import "strings"
+
+// defaultContextWindow is used for models missing from the window table.
+const defaultContextWindow = 200_000
+
+// contextWindows maps model name prefixes to context window sizes in tokens.
+// The longest matching prefix wins.
+var contextWindows = map[string]int{
+	"claude-opus-4":     200_000,
+	"claude-sonnet-4":   200_000,
+	"claude-haiku-4":    200_000,
+	"claude-3-7-sonnet": 200_000,
+	"claude-3-5-sonnet": 200_000,
+	"claude-3-5-haiku":  200_000,
+	"claude-3-opus":     200_000,
+	"claude-3-sonnet":   200_000,
+	"claude-3-haiku":    200_000,
+	"opus":              200_000,
+	"sonnet":            200_000,
+	"haiku":             200_000,
+}
+
+// extendedContextSuffix marks a model variant with a 1M token context window.
+const extendedContextSuffix = "[1m]"
+
+// contextWindowForModel returns the context window size for a model name.
+// Unknown models get defaultContextWindow.
+func contextWindowForModel(model string) int {
+	if strings.HasSuffix(model, extendedContextSuffix) {
+		return 1_000_000
+	}
+
+	best, window := "", defaultContextWindow
+	for prefix, size := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, window = prefix, size
+		}
+	}
+	return window
+}
+
+// ContextUsage describes approximate context window utilization.
+type ContextUsage struct {
+	// Tokens is the size of the context as of the latest result: its
+	// input, cache read, cache creation, and output tokens.
+	Tokens int
+	// Window is the context window size in tokens.
+	Window int
+	// Utilization is Tokens divided by Window.
+	Utilization float64
+	// Turn is the turn at which the usage was measured.
+	Turn int
+}
+
+// ContextUsageEvent is passed to OnContextUsage hooks when utilization
+// first crosses the hook's threshold.
+type ContextUsageEvent struct {
+	ContextUsage
+	// SessionID is the session identifier.
+	SessionID string
+	// Threshold is the utilization threshold that was crossed.
+	Threshold float64
+}
+
+// ContextUsageHook is called when context utilization crosses a threshold.
+type ContextUsageHook func(*ContextUsageEvent)
+
+// contextUsageWatcher fires a hook once when utilization crosses a threshold.
+type contextUsageWatcher struct {
+	threshold float64
+	fn        ContextUsageHook
+	fired     bool
+}
+
+// contextTracker tracks the size of the context and evaluates watchers.
+type contextTracker struct {
+	window   int
+	tokens   int
+	turn     int
+	watchers []*contextUsageWatcher
+}
+
+// newContextTracker creates a tracker for the configured model and watchers.
+// An explicit window (from ContextWindow) takes precedence over the table.
+func newContextTracker(cfg *config) *contextTracker {
+	window := cfg.contextWindow
+	if window <= 0 {
+		window = contextWindowForModel(cfg.model)
+	}
+	watchers := make([]*contextUsageWatcher, len(cfg.contextWatchers))
+	for i, w := range cfg.contextWatchers {
+		watchers[i] = &contextUsageWatcher{threshold: w.threshold, fn: w.fn}
+	}
+	return &contextTracker{window: window, watchers: watchers}
+}
+
+// usage returns the current usage snapshot.
+func (t *contextTracker) usage() ContextUsage {
+	return ContextUsage{
+		Tokens:      t.tokens,
+		Window:      t.window,
+		Utilization: float64(t.tokens) / float64(t.window),
+		Turn:        t.turn,
+	}
+}
+
+// update records a result's usage and returns the watchers whose
+// threshold was crossed for the first time. Each watcher is returned at
+// most once. Every request sends the whole conversation, so the latest
+// usage is the size of the context; summing results would count earlier
+// turns again.
+func (t *contextTracker) update(u Usage, turn int) []*contextUsageWatcher {
+	t.tokens = u.InputTokens + u.CacheRead + u.CacheWrite + u.OutputTokens
+	t.turn = turn
+
+	utilization := float64(t.tokens) / float64(t.window)
+	var crossed []*contextUsageWatcher
+	for _, w := range t.watchers {
+		if !w.fired && utilization >= w.threshold {
+			w.fired = true
+			crossed = append(crossed, w)
+		}
+	}
+	return crossed
+}
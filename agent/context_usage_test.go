@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestContextWindowForModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"claude-sonnet-4-5", 200_000},
+		{"claude-sonnet-4-5-20250929", 200_000},
+		{"claude-opus-4-1", 200_000},
+		{"claude-haiku-4-5", 200_000},
+		{"claude-3-5-haiku-latest", 200_000},
+		{"sonnet", 200_000},
+		{"claude-sonnet-4-5[1m]", 1_000_000},
+		{"unknown-model", defaultContextWindow},
+		{"", defaultContextWindow},
+	}
+
+	for _, tt := range tests {
+		if got := contextWindowForModel(tt.model); got != tt.want {
+			t.Errorf("contextWindowForModel(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestContextTrackerWindowOverride(t *testing.T) {
+	tr := newContextTracker(newConfig(Model("claude-sonnet-4-5"), ContextWindow(1000)))
+	if tr.window != 1000 {
+		t.Errorf("window = %d, want 1000", tr.window)
+	}
+}
+
+func TestContextTrackerFiresOnce(t *testing.T) {
+	var calls int
+	cfg := newConfig(
+		ContextWindow(1000),
+		OnContextUsage(0.5, func(*ContextUsageEvent) { calls++ }),
+	)
+	tr := newContextTracker(cfg)
+
+	if crossed := tr.update(Usage{InputTokens: 300, OutputTokens: 100}, 1); len(crossed) != 0 {
+		t.Errorf("crossed at 40%% = %d watchers, want 0", len(crossed))
+	}
+	if crossed := tr.update(Usage{InputTokens: 400, OutputTokens: 10}, 2); len(crossed) != 0 {
+		t.Errorf("crossed at 41%% = %d watchers, want 0; usage is not summed", len(crossed))
+	}
+	if crossed := tr.update(Usage{InputTokens: 100, CacheRead: 300, CacheWrite: 50, OutputTokens: 50}, 3); len(crossed) != 1 {
+		t.Errorf("crossed at 50%% = %d watchers, want 1", len(crossed))
+	}
+	if crossed := tr.update(Usage{InputTokens: 50, CacheRead: 800, OutputTokens: 50}, 4); len(crossed) != 0 {
+		t.Errorf("crossed again at 90%% = %d watchers, want 0", len(crossed))
+	}
+
+	u := tr.usage()
+	if u.Tokens != 900 || u.Window != 1000 || u.Turn != 4 {
+		t.Errorf("usage() = %+v, want Tokens=900 Window=1000 Turn=4", u)
+	}
+	if u.Utilization != 0.9 {
+		t.Errorf("Utilization = %v, want 0.9", u.Utilization)
+	}
+}
+
+func TestOnContextUsageAcrossRuns(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// The context grows by 300 tokens a run, of a 1000 token window: 30%,
+	// 60%, 90%. Earlier runs come back as cached input.
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"ctx-test"}'
+echo '{"type":"result","result":"one","num_turns":1,"usage":{"input_tokens":50,"cache_creation_input_tokens":200,"output_tokens":50}}'
+read line
+echo '{"type":"result","result":"two","num_turns":1,"usage":{"input_tokens":50,"cache_read_input_tokens":300,"cache_creation_input_tokens":200,"output_tokens":50}}'
+read line
+echo '{"type":"result","result":"three","num_turns":1,"usage":{"input_tokens":50,"cache_read_input_tokens":600,"cache_creation_input_tokens":200,"output_tokens":50}}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var events []*ContextUsageEvent
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		ContextWindow(1000),
+		OnContextUsage(0.5, func(e *ContextUsageEvent) {
+			events = append(events, e)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for i, prompt := range []string{"one", "two", "three"} {
+		if _, err := a.Run(ctx, prompt); err != nil {
+			t.Fatalf("Run(%d) error = %v", i, err)
+		}
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("hook called %d times, want 1", len(events))
+	}
+	e := events[0]
+	if e.Tokens != 600 || e.Window != 1000 || e.Turn != 2 {
+		t.Errorf("event = %+v, want Tokens=600 Window=1000 Turn=2", e.ContextUsage)
+	}
+	if e.SessionID != "ctx-test" || e.Threshold != 0.5 {
+		t.Errorf("event SessionID=%q Threshold=%v, want ctx-test 0.5", e.SessionID, e.Threshold)
+	}
+
+	if u := a.ContextUsage(); u.Tokens != 900 {
+		t.Errorf("ContextUsage().Tokens = %d, want 900", u.Tokens)
+	}
+}
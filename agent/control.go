@@ -10,6 +10,7 @@ import (
 type ControlRequest struct {
 	RequestID string
 	Type      string // e.g., "tool_use"
+	ToolUseID string // ID of the tool_use block, when provided by the CLI
 	Tool      *ToolCall
 }
 
@@ -19,48 +20,121 @@ type controlResponse struct {
 	Decision     string         `json:"decision"` // "allow" or "deny"
 	Reason       string         `json:"reason,omitempty"`
 	UpdatedInput map[string]any `json:"updated_input,omitempty"`
+
+	// AdditionalContext is added to the conversation with a denial, set by
+	// ExplainDenials
+	AdditionalContext string `json:"additional_context,omitempty"`
 }
 
 // handleControlRequest evaluates hooks and sends a response to the process.
 // For custom tools, it executes the tool in-process and returns the result.
 func (a *Agent) handleControlRequest(ctx context.Context, req *ControlRequest) error {
+	// A custom tool may still be running when AutoRestart replaces the
+	// process; its result goes to the process that asked for it, or nowhere
+	proc, _, sessionID := a.transport()
+
 	if req.Tool == nil {
 		// No tool call to evaluate - allow by default
-		return a.sendControlResponse(req.RequestID, Allow, "", nil)
+		return sendControlResponse(proc, req.RequestID, Allow, "", nil)
 	}
 
 	// Check if this is a custom tool
 	customTool := a.cfg.customTools[req.Tool.Name]
 
-	// Evaluate hook chain
-	result := a.hookChain.evaluate(req.Tool)
+	// Hooks and custom tools may report progress until the call is handled
+	progress := a.newProgressReporter(ctx, req)
+	if progress != nil {
+		ctx = context.WithValue(ctx, progressKey{}, progress)
+	}
+
+	// Evaluate hook chain; hooks see the Stream context via ToolCall.Context
+	req.Tool.ctx = ctx
+	req.Tool.workDir = a.cfg.workDir
+	req.Tool.resolveSymlinks = a.cfg.resolvePathSymlinks
+	req.Tool.audit = func(eventType string, data map[string]any) {
+		a.auditor.emit(sessionID, eventType, data)
+	}
+	// A ToolsRun restriction is decided before the hooks run
+	result := req.Tool.runTools.check(req.Tool.Name)
+	runToolsDenied := result.Decision == Deny
+	var durations []time.Duration
+	if !runToolsDenied {
+		result, durations = a.hookChain.evaluateTimed(req.Tool, a.timeHooks())
+	}
 
 	// Emit hook.pre_tool_use audit event
-	a.auditor.emit(a.sessionID, "hook.pre_tool_use", map[string]any{
-		"tool":        req.Tool.Name,
-		"input":       req.Tool.Input,
-		"decision":    result.Decision.String(),
-		"reason":      result.Reason,
-		"custom_tool": customTool != nil,
-	})
+	preToolUse := map[string]any{
+		"hook_durations":     a.checkHookDurations("pre_tool_use", req.Tool, durations),
+		"tool":               req.Tool.Name,
+		"input":              req.Tool.Input,
+		"decision":           result.Decision.String(),
+		"reason":             result.Reason,
+		"custom_tool":        customTool != nil,
+		"tool_use_id":        req.Tool.ID,
+		"parent_tool_use_id": req.Tool.ParentToolUseID,
+		"agent_kind":         string(req.Tool.AgentKind),
+		"subagent_type":      req.Tool.SubagentType,
+	}
+	req.Tool.runTools.auditData(preToolUse, runToolsDenied)
+	if rw := req.Tool.rewrite; rw != nil {
+		preToolUse["redirect"] = map[string]any{
+			"original":   rw.mapping.Original,
+			"redirected": rw.mapping.Redirected,
+		}
+		if result.Decision != Deny {
+			a.mu.Lock()
+			a.runChanges.redirect(rw.mapping)
+			a.mu.Unlock()
+		}
+	}
+	a.auditor.emit(sessionID, "hook.pre_tool_use", preToolUse)
+
+	// Remember input rewrites so results can be attributed to the effective input
+	if result.UpdatedInput != nil && req.ToolUseID != "" {
+		a.mu.Lock()
+		a.updatedInputs[req.ToolUseID] = result.UpdatedInput
+		a.mu.Unlock()
+	}
 
 	// If denied, send denial response
 	if result.Decision == Deny {
-		return a.sendControlResponse(
-			req.RequestID,
-			result.Decision,
-			result.Reason,
-			result.UpdatedInput,
-		)
+		progress.finish()
+		first := a.recordDenial(req.Tool, result.Reason)
+		return writeControlResponse(proc, controlResponse{
+			RequestID:         req.RequestID,
+			Decision:          "deny",
+			Reason:            result.Reason,
+			UpdatedInput:      result.UpdatedInput,
+			AdditionalContext: a.explainDenial(req.Tool, result.Reason, first),
+		})
 	}
 
-	// If this is a custom tool and allowed, execute it
+	// If this is a custom tool and allowed, execute it. The concurrency slot
+	// is reserved here, in message order, so that calls serialized by a limit
+	// still run in the order Claude issued them.
 	if customTool != nil {
-		return a.executeCustomTool(ctx, req, customTool, result.UpdatedInput)
+		slot := a.toolLimiter.reserve(req.Tool.Name)
+		queued := time.Now()
+		go func() {
+			if !slot.wait(ctx.Done()) {
+				progress.finish()
+				_ = sendCustomToolResult(proc, req.RequestID, ctx.Err().Error(), true)
+				return
+			}
+			defer slot.release()
+			// An error here is a failed write; the transport is gone, so
+			// there is no one left to tell
+			_ = a.executeCustomTool(ctx, proc, sessionID, req, customTool, result.UpdatedInput, time.Since(queued))
+		}()
+		return nil
 	}
 
+	// The CLI runs other tools; their progress is not reported
+	progress.finish()
+
 	// For non-custom tools, send allow response
-	return a.sendControlResponse(
+	return sendControlResponse(
+		proc,
 		req.RequestID,
 		result.Decision,
 		result.Reason,
@@ -68,8 +142,18 @@ func (a *Agent) handleControlRequest(ctx context.Context, req *ControlRequest) e
 	)
 }
 
-// executeCustomTool executes a custom tool and sends the result to the CLI.
-func (a *Agent) executeCustomTool(ctx context.Context, req *ControlRequest, tool Tool, updatedInput map[string]any) error {
+// toolTiming records how long a custom tool call queued and executed.
+type toolTiming struct {
+	queue time.Duration
+	exec  time.Duration
+}
+
+// executeCustomTool executes a custom tool and sends the result to proc,
+// the CLI process that asked for it. The queue duration is the time spent
+// waiting for a concurrency slot. A result that cannot be encoded as JSON
+// is reported to the CLI as a tool error, so the call does not hang; the
+// error returned is that of writing to proc.
+func (a *Agent) executeCustomTool(ctx context.Context, proc cliTransport, sessionID string, req *ControlRequest, tool Tool, updatedInput map[string]any, queue time.Duration) error {
 	// Use updated input if provided by hooks, otherwise use original
 	input := req.Tool.Input
 	if updatedInput != nil {
@@ -77,7 +161,7 @@ func (a *Agent) executeCustomTool(ctx context.Context, req *ControlRequest, tool
 	}
 
 	// Emit tool.custom.start audit event
-	a.auditor.emit(a.sessionID, "tool.custom.start", map[string]any{
+	a.auditor.emit(sessionID, "tool.custom.start", map[string]any{
 		"tool":       req.Tool.Name,
 		"input":      input,
 		"request_id": req.RequestID,
@@ -85,33 +169,50 @@ func (a *Agent) executeCustomTool(ctx context.Context, req *ControlRequest, tool
 
 	start := time.Now()
 
-	// Execute the custom tool
+	// Execute the custom tool; built-in tools such as QuestionTool find
+	// the agent and call through the context
+	ctx = context.WithValue(ctx, customToolKey{}, customToolCall{agent: a, toolUseID: req.Tool.ID})
 	result, err := tool.Execute(ctx, input)
 	duration := time.Since(start)
+	ProgressFromContext(ctx).finish() // Before the result, which the CLI echoes as a ToolResult
+
+	// Record timing so PostToolUse sees execution time, not queue time
+	if req.ToolUseID != "" {
+		a.mu.Lock()
+		a.toolTimings[req.ToolUseID] = toolTiming{queue: queue, exec: duration}
+		a.mu.Unlock()
+	}
+
+	var data []byte
+	if err == nil {
+		data, err = encodeCustomToolResult(req.RequestID, result, false)
+	}
 
 	if err != nil {
 		// Emit tool.custom.error audit event
-		a.auditor.emit(a.sessionID, "tool.custom.error", map[string]any{
-			"tool":     req.Tool.Name,
-			"input":    input,
-			"error":    err.Error(),
-			"duration": duration.String(),
+		a.auditor.emit(sessionID, "tool.custom.error", map[string]any{
+			"tool":           req.Tool.Name,
+			"input":          input,
+			"error":          err.Error(),
+			"duration":       duration.String(),
+			"queue_duration": queue.String(),
 		})
 
 		// Send error result back to CLI
-		return a.sendCustomToolResult(req.RequestID, err.Error(), true)
+		return sendCustomToolResult(proc, req.RequestID, err.Error(), true)
 	}
 
 	// Emit tool.custom.complete audit event
-	a.auditor.emit(a.sessionID, "tool.custom.complete", map[string]any{
-		"tool":     req.Tool.Name,
-		"input":    input,
-		"result":   result,
-		"duration": duration.String(),
+	a.auditor.emit(sessionID, "tool.custom.complete", map[string]any{
+		"tool":           req.Tool.Name,
+		"input":          input,
+		"result":         result,
+		"duration":       duration.String(),
+		"queue_duration": queue.String(),
 	})
 
 	// Send success result back to CLI
-	return a.sendCustomToolResult(req.RequestID, result, false)
+	return proc.write(data)
 }
 
 // customToolResponse is the JSON structure for returning custom tool results.
@@ -122,8 +223,17 @@ type customToolResponse struct {
 	IsError   bool   `json:"is_error,omitempty"`
 }
 
-// sendCustomToolResult sends a custom tool result to the CLI.
-func (a *Agent) sendCustomToolResult(requestID string, result any, isError bool) error {
+// sendCustomToolResult sends a custom tool result to proc.
+func sendCustomToolResult(proc cliTransport, requestID string, result any, isError bool) error {
+	data, err := encodeCustomToolResult(requestID, result, isError)
+	if err != nil {
+		return err
+	}
+	return proc.write(data)
+}
+
+// encodeCustomToolResult returns the line that sends a custom tool result.
+func encodeCustomToolResult(requestID string, result any, isError bool) ([]byte, error) {
 	resp := customToolResponse{
 		RequestID: requestID,
 		Decision:  "allow",
@@ -133,32 +243,33 @@ func (a *Agent) sendCustomToolResult(requestID string, result any, isError bool)
 
 	data, err := json.Marshal(resp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	data = append(data, '\n')
-
-	return a.proc.write(data)
+	return append(data, '\n'), nil
 }
 
-// sendControlResponse sends a control response to the process.
-func (a *Agent) sendControlResponse(requestID string, decision Decision, reason string, updatedInput map[string]any) error {
+// sendControlResponse sends a control response to proc.
+func sendControlResponse(proc cliTransport, requestID string, decision Decision, reason string, updatedInput map[string]any) error {
 	decisionStr := "allow"
 	if decision == Deny {
 		decisionStr = "deny"
 	}
 
-	resp := controlResponse{
+	return writeControlResponse(proc, controlResponse{
 		RequestID:    requestID,
 		Decision:     decisionStr,
 		Reason:       reason,
 		UpdatedInput: updatedInput,
-	}
+	})
+}
 
+// writeControlResponse sends resp to proc.
+func writeControlResponse(proc cliTransport, resp controlResponse) error {
 	data, err := json.Marshal(resp)
 	if err != nil {
 		return err
 	}
 	data = append(data, '\n')
 
-	return a.proc.write(data)
+	return proc.write(data)
 }
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultControlTimeout is how long SendControl waits for a response when
+// the context has no deadline.
+const DefaultControlTimeout = 30 * time.Second
+
+// RawControlRequest is a control request as received from the CLI.
+type RawControlRequest struct {
+	RequestID string
+	Subtype   string
+	Payload   json.RawMessage // The complete JSON line of the request
+}
+
+// RawControlResponse is a response to a control request, sent in place of
+// the SDK's default handling. It is written as a control_response message:
+//
+//	{"type":"control_response","response":{"subtype":"success","request_id":"...","response":{...}}}
+type RawControlResponse struct {
+	Subtype  string // "success" (default) or "error"
+	Response any    // Body of a success response
+	Error    string // Message of an error response
+}
+
+// ControlRequestHandler inspects a control request before the SDK handles it.
+// Returning (resp, true) sends resp instead of the default handling; returning
+// (nil, false) lets the request fall through to hooks and custom tools.
+type ControlRequestHandler func(ctx context.Context, req *RawControlRequest) (*RawControlResponse, bool)
+
+// controlResponseMsg is a control_response from the CLI answering a request
+// sent with SendControl. It is routed by the bridge and never reaches Stream.
+type controlResponseMsg struct {
+	RequestID string
+	Subtype   string
+	Response  json.RawMessage
+	Error     string
+}
+
+func (controlResponseMsg) message() {}
+
+// controlEnvelope is the JSON structure of control_request and
+// control_response messages.
+type controlEnvelope struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Request   json.RawMessage `json:"request,omitempty"`
+	Response  *controlBody    `json:"response,omitempty"`
+}
+
+// controlBody is the body of a control_response message.
+type controlBody struct {
+	Subtype   string          `json:"subtype"`
+	RequestID string          `json:"request_id"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// controlWaiters correlates responses with requests sent by SendControl.
+type controlWaiters struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[string]chan *controlResponseMsg
+}
+
+func newControlWaiters() *controlWaiters {
+	return &controlWaiters{pending: make(map[string]chan *controlResponseMsg)}
+}
+
+// register allocates a request ID and a channel for its response.
+func (w *controlWaiters) register() (string, chan *controlResponseMsg) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.next++
+	id := "sdk_ctrl_" + strconv.FormatUint(w.next, 10)
+	ch := make(chan *controlResponseMsg, 1)
+	w.pending[id] = ch
+	return id, ch
+}
+
+// cancel forgets a request that will not wait for its response.
+func (w *controlWaiters) cancel(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.pending, id)
+}
+
+// deliver hands a response to its waiting request. Responses for unknown
+// or abandoned requests are dropped.
+func (w *controlWaiters) deliver(resp *controlResponseMsg) {
+	w.mu.Lock()
+	ch, ok := w.pending[resp.RequestID]
+	delete(w.pending, resp.RequestID)
+	w.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+}
+
+// handleRawControlRequest offers a control request to the OnControlRequest
+// handlers. It reports whether a handler answered the request.
+func (a *Agent) handleRawControlRequest(ctx context.Context, msg *ControlRequestMsg) bool {
+	if len(a.cfg.controlHandlers) == 0 {
+		return false
+	}
+
+	req := &RawControlRequest{
+		RequestID: msg.RequestID,
+		Subtype:   msg.Type,
+		Payload:   msg.Raw,
+	}
+	for _, handler := range a.cfg.controlHandlers {
+		resp, ok := handler(ctx, req)
+		if !ok {
+			continue
+		}
+		if resp == nil {
+			resp = &RawControlResponse{}
+		}
+		err := a.sendRawControlResponse(msg.RequestID, resp)
+		a.auditor.emit(a.sessionID, "control.override", map[string]any{
+			"request_id":       msg.RequestID,
+			"subtype":          msg.Type,
+			"response_subtype": rawResponseSubtype(resp),
+			"sent":             err == nil,
+		})
+		return true
+	}
+	return false
+}
+
+// rawResponseSubtype returns the wire subtype of a raw response.
+func rawResponseSubtype(resp *RawControlResponse) string {
+	if resp.Subtype != "" {
+		return resp.Subtype
+	}
+	if resp.Error != "" {
+		return "error"
+	}
+	return "success"
+}
+
+// sendRawControlResponse writes a control_response for the given request.
+func (a *Agent) sendRawControlResponse(requestID string, resp *RawControlResponse) error {
+	body := &controlBody{
+		Subtype:   rawResponseSubtype(resp),
+		RequestID: requestID,
+		Error:     resp.Error,
+	}
+	if resp.Response != nil {
+		data, err := json.Marshal(resp.Response)
+		if err != nil {
+			return err
+		}
+		body.Response = data
+	}
+
+	data, err := json.Marshal(controlEnvelope{Type: "control_response", Response: body})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	// AutoRestart may replace the process between runs
+	proc, _, _ := a.transport()
+	return proc.write(data)
+}
+
+// SendControl sends a control request to the CLI and waits for its response.
+// The payload must encode to a JSON object (or be nil); its fields are sent
+// alongside the subtype:
+//
+//	{"type":"control_request","request_id":"sdk_ctrl_1","request":{"subtype":"...", ...}}
+//
+// Responses are matched to requests by ID, so concurrent calls are safe.
+// SendControl waits until ctx is done, or for DefaultControlTimeout if ctx
+// has no deadline. An error response from the CLI is returned as a
+// *ControlError.
+func (a *Agent) SendControl(ctx context.Context, subtype string, payload any) (json.RawMessage, error) {
+	request, err := controlRequestBody(subtype, payload)
+	if err != nil {
+		return nil, &ControlError{Subtype: subtype, Message: "invalid payload", Cause: err}
+	}
+
+	// AutoRestart may replace the process between runs; this request goes
+	// to the current one
+	a.mu.Lock()
+	closed := a.closed
+	sessionID := a.sessionID
+	proc, bridge := a.proc, a.bridge
+	a.mu.Unlock()
+	if closed {
+		return nil, &ControlError{Subtype: subtype, Message: "agent is closed"}
+	}
+
+	id, ch := a.controls.register()
+	data, err := json.Marshal(controlEnvelope{Type: "control_request", RequestID: id, Request: request})
+	if err != nil {
+		a.controls.cancel(id)
+		return nil, &ControlError{RequestID: id, Subtype: subtype, Message: "invalid payload", Cause: err}
+	}
+	data = append(data, '\n')
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultControlTimeout)
+		defer cancel()
+	}
+
+	a.auditor.emit(sessionID, "control.send", map[string]any{
+		"request_id": id,
+		"subtype":    subtype,
+	})
+
+	if err := proc.write(data); err != nil {
+		a.controls.cancel(id)
+		return nil, &ControlError{RequestID: id, Subtype: subtype, Message: "write failed", Cause: err}
+	}
+
+	select {
+	case resp := <-ch:
+		return controlResult(subtype, resp)
+	case <-bridge.finished:
+		a.controls.cancel(id)
+		select {
+		case resp := <-ch:
+			// The response arrived just before the process exited
+			return controlResult(subtype, resp)
+		default:
+		}
+		return nil, &ControlError{RequestID: id, Subtype: subtype, Message: "process exited before responding"}
+	case <-ctx.Done():
+		a.controls.cancel(id)
+		return nil, &ControlError{RequestID: id, Subtype: subtype, Message: "no response", Cause: ctx.Err()}
+	}
+}
+
+// controlResult converts a control response into SendControl's return values.
+func controlResult(subtype string, resp *controlResponseMsg) (json.RawMessage, error) {
+	if resp.Subtype == "error" {
+		return nil, &ControlError{RequestID: resp.RequestID, Subtype: subtype, Message: resp.Error}
+	}
+	return resp.Response, nil
+}
+
+// controlRequestBody builds the request object of an SDK control request.
+func controlRequestBody(subtype string, payload any) (json.RawMessage, error) {
+	fields := map[string]any{}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(data, []byte("null")) {
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			if err := dec.Decode(&fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fields["subtype"] = subtype
+	return json.Marshal(fields)
+}
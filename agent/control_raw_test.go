@@ -0,0 +1,298 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnControlRequestOverridesDefaultHandling(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	// One request the handler answers, then one it passes through to the
+	// default permission handling. Both responses are recorded verbatim.
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"raw-test"}'
+printf '%s\n' '{"type":"control_request","request_id":"req_9","request":{"subtype":"hook_callback","callback_id":"cb1"}}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"control","request_id":"req_10","tool_name":"Read","tool_input":{"file_path":"/a"}}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var seen []RawControlRequest
+	var hookTools []string
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		OnControlRequest(func(_ context.Context, req *RawControlRequest) (*RawControlResponse, bool) {
+			mu.Lock()
+			seen = append(seen, *req)
+			mu.Unlock()
+			if req.Subtype != "hook_callback" {
+				return nil, false
+			}
+			return &RawControlResponse{Response: map[string]any{"continue": true}}, true
+		}),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			hookTools = append(hookTools, tc.Name)
+			return HookResult{Decision: Continue}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "go"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	got := string(mustReadFile(t, wire))
+	want := `{"type":"control_response","response":{"subtype":"success","request_id":"req_9","response":{"continue":true}}}` + "\n" +
+		`{"request_id":"req_10","decision":"allow"}` + "\n"
+	if got != want {
+		t.Errorf("wire =\n%s\nwant\n%s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 2 {
+		t.Fatalf("handler saw %d requests, want 2", len(seen))
+	}
+	if seen[0].RequestID != "req_9" || seen[0].Subtype != "hook_callback" {
+		t.Errorf("first request = %+v, want req_9/hook_callback", seen[0])
+	}
+	wantPayload := `{"type":"control_request","request_id":"req_9","request":{"subtype":"hook_callback","callback_id":"cb1"}}`
+	if string(seen[0].Payload) != wantPayload {
+		t.Errorf("Payload = %s, want %s", seen[0].Payload, wantPayload)
+	}
+	if len(hookTools) != 1 || hookTools[0] != "Read" {
+		t.Errorf("PreToolUse saw %v, want only the fall-through Read request", hookTools)
+	}
+}
+
+func TestOnControlRequestErrorResponse(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"control_request","request_id":"req_1","request":{"subtype":"mcp_message"}}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		OnControlRequest(func(_ context.Context, _ *RawControlRequest) (*RawControlResponse, bool) {
+			return &RawControlResponse{Error: "unsupported"}, true
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "go"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	got := string(mustReadFile(t, wire))
+	want := `{"type":"control_response","response":{"subtype":"error","request_id":"req_1","error":"unsupported"}}` + "\n"
+	if got != want {
+		t.Errorf("wire = %s, want %s", got, want)
+	}
+}
+
+func TestSendControlWireFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+
+	script := `#!/bin/sh
+read line
+printf '%s\n' "$line" >> ` + wire + `
+printf '%s\n' '{"type":"control_response","response":{"subtype":"success","request_id":"sdk_ctrl_1","response":{"mode":"plan"}}}'
+read line
+printf '%s\n' "$line" >> ` + wire + `
+printf '%s\n' '{"type":"control_response","response":{"subtype":"error","request_id":"sdk_ctrl_2","error":"no such server"}}'
+read line
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	resp, err := a.SendControl(ctx, "set_permission_mode", map[string]any{"mode": "plan"})
+	if err != nil {
+		t.Fatalf("SendControl() error = %v", err)
+	}
+	if string(resp) != `{"mode":"plan"}` {
+		t.Errorf("response = %s, want {\"mode\":\"plan\"}", resp)
+	}
+
+	_, err = a.SendControl(ctx, "mcp_status", nil)
+	var ctrlErr *ControlError
+	if !errors.As(err, &ctrlErr) {
+		t.Fatalf("SendControl() error = %v, want *ControlError", err)
+	}
+	if ctrlErr.RequestID != "sdk_ctrl_2" || ctrlErr.Message != "no such server" {
+		t.Errorf("ControlError = %+v, want sdk_ctrl_2/no such server", ctrlErr)
+	}
+
+	got := string(mustReadFile(t, wire))
+	want := `{"type":"control_request","request_id":"sdk_ctrl_1","request":{"mode":"plan","subtype":"set_permission_mode"}}` + "\n" +
+		`{"type":"control_request","request_id":"sdk_ctrl_2","request":{"subtype":"mcp_status"}}` + "\n"
+	if got != want {
+		t.Errorf("wire =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSendControlInterleavedResponses(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Read three requests, then answer them in reverse order. Each response
+	// echoes the subtype of the request it answers.
+	script := `#!/bin/sh
+respond() {
+	id=$(printf '%s' "$1" | sed -n 's/.*"request_id":"\([^"]*\)".*/\1/p')
+	sub=$(printf '%s' "$1" | sed -n 's/.*"subtype":"\([^"]*\)".*/\1/p')
+	printf '{"type":"control_response","response":{"subtype":"success","request_id":"%s","response":{"echo":"%s"}}}\n' "$id" "$sub"
+}
+read l1
+read l2
+read l3
+respond "$l3"
+respond "$l2"
+respond "$l1"
+read line
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	subtypes := []string{"alpha", "beta", "gamma"}
+	results := make([]string, len(subtypes))
+	errs := make([]error, len(subtypes))
+	var wg sync.WaitGroup
+	for i, sub := range subtypes {
+		wg.Add(1)
+		go func(i int, sub string) {
+			defer wg.Done()
+			resp, err := a.SendControl(ctx, sub, nil)
+			results[i], errs[i] = string(resp), err
+		}(i, sub)
+	}
+	wg.Wait()
+
+	for i, sub := range subtypes {
+		if errs[i] != nil {
+			t.Errorf("SendControl(%s) error = %v", sub, errs[i])
+			continue
+		}
+		want := `{"echo":"` + sub + `"}`
+		if results[i] != want {
+			t.Errorf("SendControl(%s) = %s, want %s", sub, results[i], want)
+		}
+	}
+}
+
+func TestSendControlTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+read line
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	sendCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = a.SendControl(sendCtx, "interrupt", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SendControl() error = %v, want deadline exceeded", err)
+	}
+	if !strings.Contains(err.Error(), "sdk_ctrl_1") {
+		t.Errorf("error %q does not name the request", err)
+	}
+}
+
+func TestSendControlRejectsNonObjectPayload(t *testing.T) {
+	if _, err := controlRequestBody("x", []int{1}); err == nil {
+		t.Error("controlRequestBody([]int) error = nil, want error")
+	}
+	body, err := controlRequestBody("x", struct {
+		N int `json:"n"`
+	}{N: 12345678901})
+	if err != nil {
+		t.Fatalf("controlRequestBody() error = %v", err)
+	}
+	if string(body) != `{"n":12345678901,"subtype":"x"}` {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestSendControlDuringClose(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	mustWriteFile(t, fakeClaude, []byte("#!/bin/sh\nwhile read line; do :; done\n"), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Run with -race: the request and Close share the process
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.SendControl(ctx, "interrupt", nil)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	mustClose(t, a)
+
+	var cerr *ControlError
+	if err := <-done; !errors.As(err, &cerr) {
+		t.Errorf("SendControl() error = %v, want a *ControlError", err)
+	}
+}
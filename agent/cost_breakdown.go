@@ -0,0 +1,158 @@
+package agent
+
+import "sort"
+
+// CostBreakdown attributes a run's input tokens to the tools and subagents
+// whose results fed them, to show which tools cost the most. Every figure
+// is an estimate: tool result content is counted with the agent's
+// TokenCounter and priced at the input rate of the agent's model, once per
+// result, although a result is read again on each later turn of the run.
+// The figures do not add up to Result.Usage.
+type CostBreakdown struct {
+	// Tools holds the results of the main agent's tool calls, by tool
+	// name, largest first.
+	Tools []AttributedCost
+	// Subagents holds the result each subagent returned and the results of
+	// the tool calls it made, by subagent type, largest first.
+	Subagents []AttributedCost
+	// Priced is true when the rates of the agent's model are known, from
+	// CostEstimator's table or the built-in one. Otherwise, as for a model
+	// passed with StrictModels(false), EstimatedUSD is 0 throughout.
+	Priced bool
+}
+
+// AttributedCost is the estimated cost of the tool results attributed to
+// a tool or subagent.
+type AttributedCost struct {
+	Name            string  // Tool name or subagent type
+	Results         int     // Tool results counted
+	EstimatedTokens int     // Estimated tokens of their content
+	EstimatedUSD    float64 // EstimatedTokens at the model's input rate
+}
+
+// AuditCostBreakdown adds the run's CostBreakdown to message.result audit
+// events as "cost_breakdown". It is off by default, as it adds an entry
+// per tool and subagent used.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.Model("claude-sonnet-4-5"),
+//	    agent.AuditToFile("audit.jsonl"),
+//	    agent.AuditCostBreakdown(),
+//	)
+func AuditCostBreakdown() Option {
+	return func(c *config) {
+		c.auditCostBreakdown = true
+	}
+}
+
+// costAttribution accumulates the estimated tokens of a run's tool results.
+type costAttribution struct {
+	tools     map[string]*AttributedCost
+	subagents map[string]*AttributedCost
+}
+
+// add attributes tokens of the result of tc, a call from pending. A Task
+// call's result and the results of calls its subagent made go to the
+// subagent. a.mu must be held.
+func (c *costAttribution) add(tc *ToolCall, pending map[string]*ToolCall, tokens int) {
+	entries, name := &c.tools, tc.Name
+	switch {
+	case tc.Name == taskToolName:
+		entries, name = &c.subagents, subagentTypeOf(tc)
+	case tc.ParentToolUseID != "":
+		entries, name = &c.subagents, subagentTypeOf(pending[tc.ParentToolUseID])
+	}
+	if *entries == nil {
+		*entries = make(map[string]*AttributedCost)
+	}
+	e := (*entries)[name]
+	if e == nil {
+		e = &AttributedCost{Name: name}
+		(*entries)[name] = e
+	}
+	e.Results++
+	e.EstimatedTokens += tokens
+}
+
+// subagentTypeOf returns the subagent type a Task call asked for, or
+// "Task" when it is unknown.
+func subagentTypeOf(task *ToolCall) string {
+	if task != nil {
+		if name, _ := task.Input["subagent_type"].(string); name != "" {
+			return name
+		}
+	}
+	return taskToolName
+}
+
+// finish returns the breakdown priced at rates, or with tokens only if
+// rates is nil. a.mu must be held.
+func (c *costAttribution) finish(rates *ModelRates) CostBreakdown {
+	return CostBreakdown{
+		Tools:     attributedCosts(c.tools, rates),
+		Subagents: attributedCosts(c.subagents, rates),
+		Priced:    rates != nil,
+	}
+}
+
+// attributedCosts prices entries and sorts them, largest first.
+func attributedCosts(entries map[string]*AttributedCost, rates *ModelRates) []AttributedCost {
+	if len(entries) == 0 {
+		return nil
+	}
+	costs := make([]AttributedCost, 0, len(entries))
+	for _, e := range entries {
+		cost := *e
+		if rates != nil {
+			cost.EstimatedUSD = float64(cost.EstimatedTokens) * rates.InputPerMTok / 1e6
+		}
+		costs = append(costs, cost)
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].EstimatedTokens != costs[j].EstimatedTokens {
+			return costs[i].EstimatedTokens > costs[j].EstimatedTokens
+		}
+		return costs[i].Name < costs[j].Name
+	})
+	return costs
+}
+
+// breakdownRates returns the rates of the agent's model for CostBreakdown,
+// or nil if no rate table has them.
+func breakdownRates(cfg *config) *ModelRates {
+	tables := []map[string]ModelRates{defaultModelRates}
+	if cfg.costEstimator != nil {
+		tables = []map[string]ModelRates{cfg.costEstimator.rates, defaultModelRates}
+	}
+	rates, ok := lookupRates(cfg.model, tables...)
+	if !ok {
+		return nil
+	}
+	return &rates
+}
+
+// auditData returns the breakdown for the message.result audit event.
+func (b CostBreakdown) auditData() map[string]any {
+	entries := func(costs []AttributedCost) []map[string]any {
+		out := make([]map[string]any, 0, len(costs))
+		for _, c := range costs {
+			e := map[string]any{
+				"name":             c.Name,
+				"results":          c.Results,
+				"estimated_tokens": c.EstimatedTokens,
+			}
+			if b.Priced {
+				e["estimated_usd"] = c.EstimatedUSD
+			}
+			out = append(out, e)
+		}
+		return out
+	}
+	return map[string]any{
+		"tools":     entries(b.Tools),
+		"subagents": entries(b.Subagents),
+		"priced":    b.Priced,
+	}
+}
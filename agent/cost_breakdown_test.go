@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// breakdownCall is a tool call of a synthetic run, with its result and
+// the calls a subagent made before the result.
+type breakdownCall struct {
+	id, name string
+	input    map[string]any
+	result   string
+	nested   []breakdownCall
+}
+
+// breakdownScript writes a replay file for a run making calls, in order.
+func breakdownScript(t *testing.T, calls []breakdownCall) string {
+	t.Helper()
+	lines := []map[string]any{{"type": "system", "subtype": "init", "session_id": "breakdown"}}
+	var add func(calls []breakdownCall, parent string)
+	add = func(calls []breakdownCall, parent string) {
+		for _, c := range calls {
+			block := func(b map[string]any) map[string]any {
+				line := map[string]any{"type": "assistant", "message": map[string]any{"role": "assistant", "content": []any{b}}}
+				if parent != "" {
+					line["parent_tool_use_id"] = parent
+				}
+				return line
+			}
+			lines = append(lines, block(map[string]any{"type": "tool_use", "id": c.id, "name": c.name, "input": c.input}))
+			add(c.nested, c.id)
+			lines = append(lines, block(map[string]any{"type": "tool_result", "tool_use_id": c.id, "content": c.result}))
+		}
+	}
+	add(calls, "")
+	lines = append(lines, map[string]any{"type": "result", "result": "Done", "num_turns": 1})
+
+	var script strings.Builder
+	script.WriteString(`{"op":"in"}` + "\n")
+	enc := json.NewEncoder(&script)
+	for _, l := range lines {
+		data, err := json.Marshal(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(replayEntry{Op: replayOut, Line: string(data)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "script.jsonl")
+	mustWriteFile(t, path, []byte(script.String()), 0600)
+	return path
+}
+
+// breakdownResult replays script with opts and returns the Result's
+// CostBreakdown and the data of the message.result audit event.
+func breakdownResult(t *testing.T, script string, opts ...Option) (CostBreakdown, map[string]any) {
+	t.Helper()
+	var mu sync.Mutex
+	var data map[string]any
+	opts = append(opts, Replay(script), Audit(func(e AuditEvent) {
+		if e.Type == "message.result" {
+			mu.Lock()
+			defer mu.Unlock()
+			data = e.Data.(map[string]any)
+		}
+	}))
+	messages, err := replayRun(t, opts...)
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, msg := range messages {
+		if r, ok := msg.(*Result); ok {
+			return r.CostBreakdown, data
+		}
+	}
+	t.Fatal("no Result")
+	return CostBreakdown{}, nil
+}
+
+// byteCounter counts a token per byte, so content sizes are token counts.
+var byteCounter = TokenCounterFunc(func(text, model string) (int, error) { return len(text), nil })
+
+func TestCostBreakdown(t *testing.T) {
+	script := breakdownScript(t, []breakdownCall{
+		{id: "t1", name: ToolRead, result: strings.Repeat("x", 1000)},
+		{id: "t2", name: ToolBash, result: strings.Repeat("x", 200)},
+		{id: "t3", name: ToolRead, result: strings.Repeat("x", 500)},
+		{id: "t4", name: taskToolName, input: map[string]any{"subagent_type": "reviewer"}, result: strings.Repeat("x", 100), nested: []breakdownCall{
+			{id: "t5", name: ToolGrep, result: strings.Repeat("x", 300)},
+		}},
+		{id: "t6", name: taskToolName, result: strings.Repeat("x", 50)},
+	})
+
+	got, data := breakdownResult(t, script, Model("claude-sonnet-4-5"), WithTokenCounter(byteCounter))
+	usd := func(tokens int) float64 { return float64(tokens) * 3 / 1e6 } // Input rate of claude-sonnet-4
+	want := CostBreakdown{
+		Tools: []AttributedCost{
+			{Name: ToolRead, Results: 2, EstimatedTokens: 1500, EstimatedUSD: usd(1500)},
+			{Name: ToolBash, Results: 1, EstimatedTokens: 200, EstimatedUSD: usd(200)},
+		},
+		Subagents: []AttributedCost{
+			{Name: "reviewer", Results: 2, EstimatedTokens: 400, EstimatedUSD: usd(400)},
+			{Name: taskToolName, Results: 1, EstimatedTokens: 50, EstimatedUSD: usd(50)},
+		},
+		Priced: true,
+	}
+	if !breakdownEqual(got, want) {
+		t.Errorf("CostBreakdown =\n%+v\nwant\n%+v", got, want)
+	}
+	if _, ok := data["cost_breakdown"]; ok {
+		t.Error("message.result has cost_breakdown without AuditCostBreakdown")
+	}
+
+	// A CostEstimator rate table prices models the built-in table lacks
+	rates := map[string]ModelRates{"acme-": {InputPerMTok: 10, OutputPerMTok: 20}}
+	got, data = breakdownResult(t, script,
+		Model("acme-large"), StrictModels(false),
+		WithTokenCounter(byteCounter),
+		CostEstimator(rates),
+		AuditCostBreakdown(),
+	)
+	if !got.Priced || !approxEqual(got.Tools[0].EstimatedUSD, 1500*10/1e6) {
+		t.Errorf("CostBreakdown = %+v, want Read priced at the custom rate", got)
+	}
+	audited, ok := data["cost_breakdown"].(map[string]any)
+	if !ok || audited["priced"] != true {
+		t.Fatalf("message.result cost_breakdown = %v, want the breakdown", data["cost_breakdown"])
+	}
+	tools := audited["tools"].([]map[string]any)
+	if len(tools) != 2 || tools[0]["name"] != ToolRead || tools[0]["estimated_tokens"] != 1500 || !approxEqual(tools[0]["estimated_usd"].(float64), 1500*10/1e6) {
+		t.Errorf("audited tools = %v, want Read first with 1500 tokens", tools)
+	}
+}
+
+func TestCostBreakdownUnknownModel(t *testing.T) {
+	// A large result is counted once, in linear time
+	large := strings.Repeat("word ", 2<<20)
+	script := breakdownScript(t, []breakdownCall{
+		{id: "t1", name: ToolBash, result: large},
+		{id: "t2", name: ToolBash, result: "ok"},
+	})
+
+	got, data := breakdownResult(t, script, Model("acme-large"), StrictModels(false), AuditCostBreakdown())
+	tokens, _ := CountTokens(large, "")
+	ok, _ := CountTokens("ok", "")
+	want := CostBreakdown{Tools: []AttributedCost{{Name: ToolBash, Results: 2, EstimatedTokens: tokens + ok}}}
+	if !breakdownEqual(got, want) {
+		t.Errorf("CostBreakdown = %+v, want tokens only: %+v", got, want)
+	}
+	audited := data["cost_breakdown"].(map[string]any)
+	tools := audited["tools"].([]map[string]any)
+	if _, priced := tools[0]["estimated_usd"]; priced || audited["priced"] != false {
+		t.Errorf("audited breakdown = %v, want no USD", audited)
+	}
+}
+
+// breakdownEqual compares breakdowns, allowing for rounding in USD.
+func breakdownEqual(a, b CostBreakdown) bool {
+	if a.Priced != b.Priced || len(a.Tools) != len(b.Tools) || len(a.Subagents) != len(b.Subagents) {
+		return false
+	}
+	for _, pair := range [][2][]AttributedCost{{a.Tools, b.Tools}, {a.Subagents, b.Subagents}} {
+		for i := range pair[0] {
+			x, y := pair[0][i], pair[1][i]
+			if x.Name != y.Name || x.Results != y.Results || x.EstimatedTokens != y.EstimatedTokens || !approxEqual(x.EstimatedUSD, y.EstimatedUSD) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func approxEqual(a, b float64) bool { return math.Abs(a-b) < 1e-12 }
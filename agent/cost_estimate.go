@@ -0,0 +1,284 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// ModelRates holds a model's prices in USD per million tokens.
+type ModelRates struct {
+	InputPerMTok  float64
+	OutputPerMTok float64
+}
+
+// defaultModelRates maps model name prefixes to list prices. The longest
+// matching prefix wins, as for context windows.
+var defaultModelRates = map[string]ModelRates{
+	"claude-opus-4-5":   {InputPerMTok: 5, OutputPerMTok: 25},
+	"claude-opus-4":     {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-3-opus":     {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-sonnet-4":   {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-3-7-sonnet": {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-3-5-sonnet": {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-haiku-4-5":  {InputPerMTok: 1, OutputPerMTok: 5},
+	"claude-3-5-haiku":  {InputPerMTok: 0.8, OutputPerMTok: 4},
+	"claude-3-haiku":    {InputPerMTok: 0.25, OutputPerMTok: 1.25},
+}
+
+// fallbackModelRates prices models missing from every rate table.
+var fallbackModelRates = ModelRates{InputPerMTok: 3, OutputPerMTok: 15}
+
+// DefaultModelRates returns the built-in price table used by
+// CostEstimator, keyed by model name prefix. The returned map is a copy.
+func DefaultModelRates() map[string]ModelRates {
+	rates := make(map[string]ModelRates, len(defaultModelRates))
+	for k, v := range defaultModelRates {
+		rates[k] = v
+	}
+	return rates
+}
+
+// EstimatorOption configures CostEstimator.
+type EstimatorOption func(*estimatorConfig)
+
+// estimatorConfig holds the settings from CostEstimator.
+type estimatorConfig struct {
+	rates  map[string]ModelRates
+	count  func(text string) int // nil = the agent's TokenCounter
+	budget float64
+}
+
+// EstimateTokens replaces the agent's TokenCounter for CostEstimator.
+func EstimateTokens(fn func(text string) int) EstimatorOption {
+	return func(c *estimatorConfig) {
+		if fn != nil {
+			c.count = fn
+		}
+	}
+}
+
+// EstimatedBudget stops a run whose estimated cost exceeds usd before its
+// turn completes. The CLI is asked to interrupt the turn, and Run returns
+// the Result with a *BudgetError. Estimates are approximate, so set the
+// budget with some headroom.
+func EstimatedBudget(usd float64) EstimatorOption {
+	return func(c *estimatorConfig) {
+		c.budget = usd
+	}
+}
+
+// CostEstimator estimates the cost of each run while it streams, before
+// the authoritative Result.CostUSD arrives. The prompt and tool results
+// count as input tokens; text, thinking, and tool calls count as output
+// tokens. Each delivered message carries the run's estimate so far in
+// MessageMeta.EstimatedCostUSD, and Agent.EstimatedCost reports the
+// running total. When the Result arrives, a cost.reconciled audit event
+// compares the estimate with the actual cost.
+//
+// rates maps model name prefixes to prices and takes precedence over the
+// built-in table; pass nil to use the built-in table alone.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.CostEstimator(nil, agent.EstimatedBudget(2.00)),
+//	)
+func CostEstimator(rates map[string]ModelRates, opts ...EstimatorOption) Option {
+	ec := &estimatorConfig{
+		rates: make(map[string]ModelRates, len(rates)),
+	}
+	for k, v := range rates {
+		ec.rates[k] = v
+	}
+	for _, opt := range opts {
+		opt(ec)
+	}
+	return func(c *config) {
+		c.costEstimator = ec
+	}
+}
+
+// ratesForModel returns the prices for model from the configured table,
+// then the built-in table, using the longest matching prefix.
+func (ec *estimatorConfig) ratesForModel(model string) ModelRates {
+	if rates, ok := lookupRates(model, ec.rates, defaultModelRates); ok {
+		return rates
+	}
+	return fallbackModelRates
+}
+
+// lookupRates returns the prices for model from the first of tables with
+// a matching prefix, using the longest matching prefix.
+func lookupRates(model string, tables ...map[string]ModelRates) (ModelRates, bool) {
+	for _, table := range tables {
+		best, found := "", false
+		var rates ModelRates
+		for prefix, r := range table {
+			if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+				best, rates, found = prefix, r, true
+			}
+		}
+		if found {
+			return rates, true
+		}
+	}
+	return ModelRates{}, false
+}
+
+// costEstimator tracks estimated and actual spend for an agent.
+type costEstimator struct {
+	mu     sync.Mutex
+	rates  ModelRates
+	count  func(text string) int
+	budget float64
+
+	actual       float64 // Reported cost of completed runs
+	inputTokens  int     // Estimated input tokens in the current run
+	outputTokens int     // Estimated output tokens in the current run
+	exceeded     bool    // Current run passed the budget
+}
+
+// newCostEstimator returns nil when CostEstimator is not configured.
+func newCostEstimator(cfg *config) *costEstimator {
+	ec := cfg.costEstimator
+	if ec == nil {
+		return nil
+	}
+	count := ec.count
+	if count == nil {
+		count = cfg.countTokens()
+	}
+	return &costEstimator{
+		rates:  ec.ratesForModel(cfg.model),
+		count:  count,
+		budget: ec.budget,
+	}
+}
+
+// runCostLocked prices the current run's estimated tokens. Caller must
+// hold e.mu.
+func (e *costEstimator) runCostLocked() float64 {
+	return float64(e.inputTokens)*e.rates.InputPerMTok/1e6 +
+		float64(e.outputTokens)*e.rates.OutputPerMTok/1e6
+}
+
+// startRun resets the run estimate and counts the prompt.
+func (e *costEstimator) startRun(prompt string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.inputTokens = e.count(prompt)
+	e.outputTokens = 0
+	e.exceeded = false
+}
+
+// observe adds a message's tokens to the run estimate. It returns the
+// estimate and whether the message took it over the budget for the first
+// time.
+func (e *costEstimator) observe(msg Message) (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *Text:
+		e.outputTokens += e.count(m.Text)
+	case *Thinking:
+		e.outputTokens += e.count(m.Thinking)
+	case *ToolUse:
+		e.outputTokens += e.count(m.Name + jsonText(m.Input))
+	case *ToolResult:
+		e.inputTokens += e.count(contentText(m.Content))
+	}
+
+	cost := e.runCostLocked()
+	if e.budget > 0 && !e.exceeded && cost > e.budget {
+		e.exceeded = true
+		return cost, true
+	}
+	return cost, false
+}
+
+// reconcile replaces the run estimate with the reported cost and returns
+// the comparison for the cost.reconciled audit event.
+func (e *costEstimator) reconcile(result *Result) map[string]any {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	estimated := e.runCostLocked()
+	e.actual += result.CostUSD
+	data := map[string]any{
+		"estimated_usd":           estimated,
+		"actual_usd":              result.CostUSD,
+		"error_usd":               estimated - result.CostUSD,
+		"estimated_input_tokens":  e.inputTokens,
+		"estimated_output_tokens": e.outputTokens,
+		"actual_input_tokens":     result.Usage.InputTokens + result.Usage.CacheRead + result.Usage.CacheWrite,
+		"actual_output_tokens":    result.Usage.OutputTokens,
+	}
+	if result.CostUSD > 0 {
+		data["error_ratio"] = (estimated - result.CostUSD) / result.CostUSD
+	}
+	e.inputTokens, e.outputTokens = 0, 0
+	return data
+}
+
+// total returns the reported cost of completed runs plus the estimate for
+// the current run.
+func (e *costEstimator) total() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.actual + e.runCostLocked()
+}
+
+// jsonText renders v as JSON for token counting.
+func jsonText(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// contentText returns the text of a tool result's content.
+func contentText(content any) string {
+	if s, ok := content.(string); ok {
+		return s
+	}
+	return jsonText(content)
+}
+
+// EstimatedCost returns the agent's spend so far in USD: the reported cost
+// of completed runs plus the estimate for the run in progress. It returns
+// 0 unless CostEstimator is set.
+func (a *Agent) EstimatedCost() float64 {
+	if a.costs == nil {
+		return 0
+	}
+	return a.costs.total()
+}
+
+// estimateCost updates the run estimate for msg and stamps it on the
+// message. When the estimate first passes the budget, the turn is
+// interrupted and the returned error is attached to the run's Result.
+func (a *Agent) estimateCost(msg Message) *BudgetError {
+	cost, exceeded := a.costs.observe(msg)
+	if meta := messageMeta(msg); meta != nil {
+		meta.EstimatedCostUSD = cost
+	}
+	if !exceeded {
+		return nil
+	}
+
+	err := &BudgetError{EstimatedUSD: cost, BudgetUSD: a.costs.budget}
+	a.auditor.emit(a.SessionID(), "cost.budget_exceeded", map[string]any{
+		"estimated_usd": cost,
+		"budget_usd":    a.costs.budget,
+	})
+	// Interrupting waits for the CLI's answer, which the bridge delivers
+	// independently of this stream
+	go func() {
+		_, _ = a.SendControl(context.Background(), "interrupt", nil) // Best effort; the turn may already be ending
+	}()
+	return err
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRatesForModel(t *testing.T) {
+	ec := &estimatorConfig{rates: map[string]ModelRates{
+		"claude-sonnet-4-5": {InputPerMTok: 1, OutputPerMTok: 2},
+	}}
+	tests := []struct {
+		model string
+		want  ModelRates
+	}{
+		{"claude-sonnet-4-5", ModelRates{InputPerMTok: 1, OutputPerMTok: 2}},
+		{"claude-sonnet-4-5-20250929", ModelRates{InputPerMTok: 1, OutputPerMTok: 2}},
+		{"claude-opus-4-5", ModelRates{InputPerMTok: 5, OutputPerMTok: 25}},
+		{"claude-opus-4-1", ModelRates{InputPerMTok: 15, OutputPerMTok: 75}},
+		{"claude-future-9", fallbackModelRates},
+	}
+	for _, tt := range tests {
+		if got := ec.ratesForModel(tt.model); got != tt.want {
+			t.Errorf("ratesForModel(%q) = %+v, want %+v", tt.model, got, tt.want)
+		}
+	}
+}
+
+// costCLI writes a fake CLI that streams text, a tool call, and its result
+// before reporting a cost of $0.50.
+func costCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"cost-test"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Looking"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Read","input":{"file_path":"a.go"}}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"t1","content":"package a"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Done"}]}}'
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.5,"usage":{"input_tokens":100,"output_tokens":20}}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+// oneTokenEach counts every piece of text as a single token.
+func oneTokenEach(string) int { return 1 }
+
+// dollarRates prices input tokens at $1 and output tokens at $2 each.
+var dollarRates = map[string]ModelRates{"claude": {InputPerMTok: 1e6, OutputPerMTok: 2e6}}
+
+func TestCostEstimatorStreamsEstimates(t *testing.T) {
+	var mu sync.Mutex
+	var reconciled map[string]any
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(costCLI(t)),
+		CostEstimator(dollarRates, EstimateTokens(oneTokenEach)),
+		Audit(func(e AuditEvent) {
+			if e.Type == "cost.reconciled" {
+				mu.Lock()
+				defer mu.Unlock()
+				reconciled = e.Data.(map[string]any)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	// Prompt: $1 input. Text: +$2, tool call: +$2, tool result: +$1, text: +$2
+	want := []float64{3, 5, 6, 8, 8}
+	var got []float64
+	for msg := range a.Stream(ctx, "go") {
+		got = append(got, messageMeta(msg).EstimatedCostUSD)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("estimates = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("estimates = %v, want %v", got, want)
+			break
+		}
+	}
+	if total := a.EstimatedCost(); total != 0.5 {
+		t.Errorf("EstimatedCost() after the run = %v, want the reported 0.5", total)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reconciled["estimated_usd"] != 8.0 || reconciled["actual_usd"] != 0.5 || reconciled["error_usd"] != 7.5 {
+		t.Errorf("cost.reconciled data = %v, want estimate 8 against actual 0.5", reconciled)
+	}
+}
+
+func TestCostEstimatorBudgetInterruptsTurn(t *testing.T) {
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"budget-test"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"one"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"two"}]}}'
+read -r line
+printf '%s\n' "$line" > ` + wire + `
+echo '{"type":"result","subtype":"error_during_execution","is_error":true,"result":"interrupted","num_turns":1,"total_cost_usd":0.01}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		CostEstimator(dollarRates, EstimateTokens(oneTokenEach), EstimatedBudget(4)),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "go")
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("Run() error = %v, want *BudgetError", err)
+	}
+	if budgetErr.EstimatedUSD != 5 || budgetErr.BudgetUSD != 4 {
+		t.Errorf("BudgetError = %+v, want estimate 5 over budget 4", budgetErr)
+	}
+	if result == nil || result.ResultText != "interrupted" {
+		t.Errorf("Run() result = %+v, want the interrupted turn's result", result)
+	}
+	if line := string(mustReadFile(t, wire)); !strings.Contains(line, `"subtype":"interrupt"`) {
+		t.Errorf("control request on the wire = %s, want an interrupt", line)
+	}
+}
+
+func TestEstimatedCostWithoutEstimator(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(costCLI(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "go")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.EstimatedCostUSD != 0 || a.EstimatedCost() != 0 {
+		t.Errorf("estimates = %v/%v, want 0 without CostEstimator", result.EstimatedCostUSD, a.EstimatedCost())
+	}
+}
+
+func TestCostEstimatorTotalIncludesRunInProgress(t *testing.T) {
+	e := &costEstimator{rates: ModelRates{InputPerMTok: 1e6, OutputPerMTok: 2e6}, count: oneTokenEach}
+
+	e.startRun("first")
+	e.observe(&Text{Text: "a"})
+	e.reconcile(&Result{CostUSD: 0.25})
+
+	e.startRun("second")
+	e.observe(&ToolResult{Content: []any{map[string]any{"type": "text", "text": "b"}}})
+	if got := e.total(); got != 2.25 {
+		t.Errorf("total() = %v, want 0.25 reported plus 2 estimated", got)
+	}
+}
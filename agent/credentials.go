@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"os"
+	"strings"
+)
+
+// Environment variables the CLI reads credentials from.
+const (
+	envAPIKey     = "ANTHROPIC_API_KEY"
+	envAuthToken  = "ANTHROPIC_AUTH_TOKEN"
+	envOAuthToken = "CLAUDE_CODE_OAUTH_TOKEN"
+)
+
+// credentialVars lists the credential variables. An APIKey or OAuthToken
+// removes the others from the process environment.
+var credentialVars = []string{envAPIKey, envAuthToken, envOAuthToken}
+
+// validateCredentials rejects configurations that set both an API key and
+// an OAuth token.
+func validateCredentials(cfg *config) error {
+	if cfg.apiKey != "" && cfg.oauthToken != "" {
+		return &ConfigError{Option: "OAuthToken", Reason: "mutually exclusive with APIKey"}
+	}
+	return nil
+}
+
+// processEnv returns the environment for the CLI process, or nil to inherit
+// the parent's environment unchanged. Env values override inherited ones,
+// and an APIKey or OAuthToken overrides both and removes the other
+// credential variables so the CLI cannot fall back to them.
+func processEnv(cfg *config) []string {
+	if len(cfg.env) == 0 && cfg.apiKey == "" && cfg.oauthToken == "" {
+		return nil
+	}
+
+	overrides, drop := envOverrides(cfg)
+	env := make([]string, 0, len(os.Environ())+len(overrides))
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if drop[key] {
+			continue
+		}
+		if _, ok := overrides[key]; ok {
+			continue
+		}
+		env = append(env, kv)
+	}
+	for k, v := range overrides {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// envOverrides returns the variables the options set for the CLI process
+// and the inherited variables they remove.
+func envOverrides(cfg *config) (map[string]string, map[string]bool) {
+	overrides := make(map[string]string, len(cfg.env)+1)
+	for k, v := range cfg.env {
+		overrides[k] = v
+	}
+	var key, value string
+	switch {
+	case cfg.apiKey != "":
+		key, value = envAPIKey, cfg.apiKey
+	case cfg.oauthToken != "":
+		key, value = envOAuthToken, cfg.oauthToken
+	}
+	drop := make(map[string]bool)
+	if key != "" {
+		for _, name := range credentialVars {
+			if name != key {
+				drop[name] = true
+				delete(overrides, name)
+			}
+		}
+		overrides[key] = value
+	}
+	return overrides, drop
+}
+
+// redactedCredentials returns the credential variables the options set for
+// the CLI process, as "NAME=[redacted]". Inherited ones are not included.
+func redactedCredentials(cfg *config) []string {
+	overrides, _ := envOverrides(cfg)
+	var list []string
+	for _, name := range credentialVars {
+		if _, ok := overrides[name]; ok {
+			list = append(list, name+"=[redacted]")
+		}
+	}
+	return list
+}
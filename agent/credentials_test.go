@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// envEchoCLI writes a fake CLI that reports its credential variables in
+// the result text as "<api key>|<auth token>|<oauth token>".
+func envEchoCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '{"type":"result","result":"%s|%s|%s","num_turns":1}\n' "$ANTHROPIC_API_KEY" "$ANTHROPIC_AUTH_TOKEN" "$CLAUDE_CODE_OAUTH_TOKEN"
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestCredentialsInjectedIntoProcessOnly(t *testing.T) {
+	t.Setenv(envAPIKey, "parent-key")
+	t.Setenv(envAuthToken, "parent-bearer")
+	t.Setenv(envOAuthToken, "parent-token")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"inherited", nil, "parent-key|parent-bearer|parent-token"},
+		{"api key", []Option{APIKey("sk-customer")}, "sk-customer||"},
+		{"oauth token", []Option{OAuthToken("oat-customer")}, "||oat-customer"},
+		{"api key overrides Env", []Option{Env(envAPIKey, "from-env"), APIKey("sk-customer")}, "sk-customer||"},
+		{"api key removes Env token", []Option{Env(envOAuthToken, "from-env"), APIKey("sk-customer")}, "sk-customer||"},
+		{"api key removes Env auth token", []Option{Env(envAuthToken, "from-env"), APIKey("sk-customer")}, "sk-customer||"},
+		{"Env without credential options", []Option{Env(envAPIKey, "from-env")}, "from-env|parent-bearer|parent-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, err := New(ctx, append([]Option{CLIPath(envEchoCLI(t))}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer mustClose(t, a)
+
+			result, err := a.Run(ctx, "env")
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if result.ResultText != tt.want {
+				t.Errorf("process saw %q, want %q", result.ResultText, tt.want)
+			}
+		})
+	}
+
+	if got := os.Getenv(envAPIKey); got != "parent-key" {
+		t.Errorf("parent %s = %q; options must not modify the parent environment", envAPIKey, got)
+	}
+	if got := os.Getenv(envOAuthToken); got != "parent-token" {
+		t.Errorf("parent %s = %q; options must not modify the parent environment", envOAuthToken, got)
+	}
+}
+
+func TestCredentialsMutuallyExclusive(t *testing.T) {
+	_, err := New(context.Background(),
+		CLIPath(envEchoCLI(t)),
+		APIKey("sk-secret-value"),
+		OAuthToken("oat-secret-value"),
+	)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Option != "OAuthToken" {
+		t.Fatalf("New() error = %v, want an OAuthToken *ConfigError", err)
+	}
+	if !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("error = %q, want mutual exclusion reason", err)
+	}
+	if strings.Contains(err.Error(), "secret-value") {
+		t.Errorf("error %q leaks a credential", err)
+	}
+}
+
+func TestCredentialsNotInStartError(t *testing.T) {
+	_, err := New(context.Background(),
+		CLIPath(filepath.Join(t.TempDir(), "missing")),
+		APIKey("sk-secret-value"),
+	)
+	if err == nil {
+		t.Fatal("New() error = nil, want start failure")
+	}
+	if strings.Contains(err.Error(), "sk-secret-value") {
+		t.Errorf("error %q leaks the API key", err)
+	}
+}
+
+func TestCredentialsRedactedInDescribe(t *testing.T) {
+	t.Setenv(envAuthToken, "parent-bearer") // Inherited variables are not listed
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"none", nil, nil},
+		{"api key", []Option{APIKey("sk-secret-value")}, []string{"ANTHROPIC_API_KEY=[redacted]"}},
+		{"oauth token", []Option{OAuthToken("oat-secret-value")}, []string{"CLAUDE_CODE_OAUTH_TOKEN=[redacted]"}},
+		{"Env", []Option{Env(envAuthToken, "secret-value")}, []string{"ANTHROPIC_AUTH_TOKEN=[redacted]"}},
+		{"api key removes Env token", []Option{Env(envOAuthToken, "secret-value"), APIKey("sk-secret-value")}, []string{"ANTHROPIC_API_KEY=[redacted]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Describe(tt.opts...)
+			if !reflect.DeepEqual(d.Credentials, tt.want) {
+				t.Errorf("Credentials = %q, want %q", d.Credentials, tt.want)
+			}
+			s := d.String()
+			if strings.Contains(s, "secret-value") || strings.Contains(s, "parent-bearer") {
+				t.Errorf("Describe() leaks a credential:\n%s", s)
+			}
+			for _, c := range tt.want {
+				if !strings.Contains(s, "credentials:      "+c+"\n") {
+					t.Errorf("Describe() =\n%s\nwant a credentials line with %s", s, c)
+				}
+			}
+		})
+	}
+}
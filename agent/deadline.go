@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultSoftDeadlineGrace is how long a run may continue after its soft
+// deadline before it is cut off, unless SoftDeadlineGrace sets otherwise.
+const DefaultSoftDeadlineGrace = 30 * time.Second
+
+// softDeadlineMessage is sent to Claude when the soft deadline passes.
+const softDeadlineMessage = "Time is nearly up. Stop starting new work, summarize your progress so far, " +
+	"including anything left unfinished, and end your turn."
+
+// deadlineWatch tracks a run's soft deadline.
+type deadlineWatch struct {
+	soft  time.Duration
+	grace time.Duration
+	start time.Time
+	timer *time.Timer
+	hit   bool
+}
+
+// newDeadlineWatch starts the soft deadline timer, or returns nil if the
+// run has no soft deadline.
+func newDeadlineWatch(rc *runConfig) *deadlineWatch {
+	if rc.softDeadline <= 0 {
+		return nil
+	}
+	return &deadlineWatch{
+		soft:  rc.softDeadline,
+		grace: rc.deadlineGrace(),
+		start: time.Now(),
+		timer: time.NewTimer(rc.softDeadline),
+	}
+}
+
+// warn returns the channel that fires at the soft deadline. It is nil
+// (never ready) without a deadline or once the deadline has fired.
+func (w *deadlineWatch) warn() <-chan time.Time {
+	if w == nil || w.hit {
+		return nil
+	}
+	return w.timer.C
+}
+
+// fired reports whether the soft deadline passed during the run.
+func (w *deadlineWatch) fired() bool {
+	return w != nil && w.hit
+}
+
+// stop releases the timer.
+func (w *deadlineWatch) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+// deadlineGrace returns the configured grace period or the default.
+func (rc *runConfig) deadlineGrace() time.Duration {
+	if rc.softDeadlineGrace > 0 {
+		return rc.softDeadlineGrace
+	}
+	return DefaultSoftDeadlineGrace
+}
+
+// withHardDeadline bounds ctx by the soft deadline plus grace, if the run
+// has a soft deadline.
+func withHardDeadline(ctx context.Context, rc *runConfig) (context.Context, context.CancelFunc) {
+	if rc.softDeadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	cause := "SoftDeadline of " + rc.softDeadline.String() + " and grace of " + rc.deadlineGrace().String() + " passed"
+	return context.WithTimeoutCause(ctx, rc.softDeadline+rc.deadlineGrace(), cancelCause(cause))
+}
+
+// warnDeadline asks Claude to wrap up by sending a follow-up message. The
+// CLI answers it with a turn of its own once the current turn ends, and
+// that turn's Result ends the run.
+func (a *Agent) warnDeadline(w *deadlineWatch) {
+	w.hit = true
+
+	proc, _, _ := a.transport()
+	err := proc.write(marshalUserMessage(softDeadlineMessage))
+	if err == nil {
+		a.oweResult()
+	}
+
+	event := map[string]any{
+		"elapsed":  time.Since(w.start).String(),
+		"deadline": w.soft.String(),
+		"grace":    w.grace.String(),
+		"message":  softDeadlineMessage,
+	}
+	if err != nil {
+		event["error"] = err.Error()
+	}
+	a.auditor.emit(a.SessionID(), "run.soft_deadline", event)
+}
+
+// stopOutcome names why a run's context ended: "cutoff" when a deadline
+// passed after the soft deadline warning, otherwise "interrupted".
+func (w *deadlineWatch) stopOutcome(ctx context.Context) string {
+	if w.fired() && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "cutoff"
+	}
+	return "interrupted"
+}
+
+// deadlineOutcome records how a run that passed its soft deadline ended:
+// "completed", "cutoff", "interrupted", or "exited" if the CLI stopped
+// without a result.
+func (a *Agent) deadlineOutcome(w *deadlineWatch, outcome string) {
+	if !w.fired() {
+		return
+	}
+	a.auditor.emit(a.SessionID(), "run.deadline_outcome", map[string]any{
+		"outcome": outcome,
+		"elapsed": time.Since(w.start).String(),
+	})
+}
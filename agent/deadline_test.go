@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowCLI writes a fake CLI that waits for a follow-up message after the
+// prompt, records it to wire, then runs tail. Without a follow-up it
+// blocks until killed.
+func slowCLI(t *testing.T, wire, tail string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read -r line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"deadline-test"}'
+read -r line
+printf '%s\n' "$line" > ` + wire + `
+` + tail + `
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+// deadlineAudit collects the data of soft deadline audit events.
+type deadlineAudit struct {
+	mu     sync.Mutex
+	events map[string]map[string]any
+}
+
+func (d *deadlineAudit) record(e AuditEvent) {
+	if !strings.HasPrefix(e.Type, "run.") {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.events == nil {
+		d.events = make(map[string]map[string]any)
+	}
+	d.events[e.Type], _ = e.Data.(map[string]any)
+}
+
+func (d *deadlineAudit) get(typ string) map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.events[typ]
+}
+
+func TestSoftDeadlineAsksClaudeToWrapUp(t *testing.T) {
+	const soft = 200 * time.Millisecond
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	var audit deadlineAudit
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(slowCLI(t, wire, `printf '%s\n' '{"type":"result","result":"Partial","num_turns":1}'
+printf '%s\n' '{"type":"result","result":"Summary","num_turns":1}'`)),
+		Audit(audit.record),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	start := time.Now()
+	result, err := a.Run(ctx, "long task", SoftDeadline(soft))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < soft {
+		t.Errorf("Run() returned after %v, before the soft deadline", elapsed)
+	}
+	if !result.SoftDeadlineHit {
+		t.Error("Result.SoftDeadlineHit = false, want true")
+	}
+
+	line := string(mustReadFile(t, wire))
+	if !strings.Contains(line, `"type":"user"`) || !strings.Contains(line, "Time is nearly up") {
+		t.Errorf("follow-up on the wire = %s, want the wrap-up message", line)
+	}
+
+	if ev := audit.get("run.soft_deadline"); ev == nil || ev["deadline"] != soft.String() {
+		t.Errorf("run.soft_deadline data = %v, want deadline %v", ev, soft)
+	}
+	if ev := audit.get("run.deadline_outcome"); ev == nil || ev["outcome"] != "completed" {
+		t.Errorf("run.deadline_outcome data = %v, want completed", ev)
+	}
+}
+
+func TestSoftDeadlineNextRunGetsItsOwnResult(t *testing.T) {
+	// The CLI ends the interrupted turn, then answers the warning with a
+	// turn of its own, then answers the next prompt
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	tail := `printf '%s\n' '{"type":"result","result":"Partial","num_turns":2,"total_cost_usd":0.01}'
+printf '%s\n' '{"type":"result","result":"Summary","num_turns":1,"total_cost_usd":0.02}'
+read -r line
+printf '%s\n' '{"type":"result","result":"Next","num_turns":1}'`
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(slowCLI(t, wire, tail)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "long task", SoftDeadline(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.ResultText != "Summary" {
+		t.Errorf("ResultText = %q, want the reply to the warning", result.ResultText)
+	}
+	if result.NumTurns != 3 || math.Abs(result.CostUSD-0.03) > 1e-9 {
+		t.Errorf("NumTurns, CostUSD = %d, %v, want both turns counted: 3, 0.03", result.NumTurns, result.CostUSD)
+	}
+
+	next, err := a.Run(ctx, "next task")
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if next.ResultText != "Next" || next.SoftDeadlineHit {
+		t.Errorf("second Run() = %q, SoftDeadlineHit %v, want its own result", next.ResultText, next.SoftDeadlineHit)
+	}
+}
+
+func TestSoftDeadlineHardCutoff(t *testing.T) {
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	var audit deadlineAudit
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(slowCLI(t, wire, "sleep 60")),
+		Audit(audit.record),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	start := time.Now()
+	_, err = a.Run(ctx, "long task",
+		SoftDeadline(100*time.Millisecond),
+		SoftDeadlineGrace(200*time.Millisecond),
+	)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Run() returned after %v, want the cutoff at 300ms", elapsed)
+	}
+
+	if !strings.Contains(string(mustReadFile(t, wire)), "Time is nearly up") {
+		t.Error("wrap-up message was not sent before the cutoff")
+	}
+	if ev := audit.get("run.deadline_outcome"); ev == nil || ev["outcome"] != "cutoff" {
+		t.Errorf("run.deadline_outcome data = %v, want cutoff", ev)
+	}
+}
+
+func TestSoftDeadlineNotReached(t *testing.T) {
+	var audit deadlineAudit
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(envEchoCLI(t)), Audit(audit.record))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "quick task", SoftDeadline(time.Minute))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.SoftDeadlineHit {
+		t.Error("Result.SoftDeadlineHit = true for a run that finished early")
+	}
+	if ev := audit.get("run.soft_deadline"); ev != nil {
+		t.Errorf("run.soft_deadline emitted for a run that finished early: %v", ev)
+	}
+	if ev := audit.get("run.deadline_outcome"); ev != nil {
+		t.Errorf("run.deadline_outcome emitted without a warning: %v", ev)
+	}
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of the lines a wire log holds while its writer catches up. Lines
+// beyond either limit are dropped and counted.
+const (
+	wireLogLines = 1024
+	wireLogBytes = 8 << 20
+)
+
+// wireLogFlushTimeout bounds how long closing the agent waits for a slow
+// writer to take the remaining lines.
+const wireLogFlushTimeout = 5 * time.Second
+
+// DebugWire writes every line exchanged with the CLI to w, for debugging
+// and support bundles. Lines the agent writes to the CLI's stdin are
+// prefixed with "in" and lines the CLI writes to stdout with "out",
+// followed by the time since the CLI started and the line's length in
+// bytes:
+//
+//	in 0.000412s 97B {"type":"user","message":{...}}
+//	out 0.183207s 61B {"type":"system","subtype":"init",...}
+//
+// Stdout lines are logged as read, before parsing, so lines the parser
+// rejects or discards are included. The Scrub patterns and the default
+// credential patterns are applied to each line, and thinking content is
+// masked as ThinkingPolicy requires.
+//
+// Lines are written by a separate goroutine, so a slow writer never blocks
+// the session. If the writer falls behind by more than 1024 lines or 8 MiB,
+// further lines are dropped, and a line such as
+//
+//	dropped 0.912000s 12 lines
+//
+// records how many. Writes are made from one goroutine at a time; write
+// errors are ignored.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.DebugWire(os.Stderr))
+func DebugWire(w io.Writer) Option {
+	return func(c *config) {
+		c.debugWire = w
+	}
+}
+
+// DebugWireFile writes the lines exchanged with the CLI to a file, as
+// DebugWire does. The file is created or appended to, and closed when the
+// agent is closed.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.DebugWireFile("wire.log"))
+func DebugWireFile(path string) Option {
+	return func(c *config) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304 -- Path provided by caller
+		if err != nil {
+			// Deferred until New(), like AuditToFile
+			c.schemaError = &StartError{
+				Reason: "failed to open wire log",
+				Cause:  err,
+			}
+			return
+		}
+		c.debugWire = f
+		c.debugWireOwned = true
+		c.auditCleanup = append(c.auditCleanup, f.Close)
+	}
+}
+
+// wireLine is a line waiting to be written to the wire log.
+type wireLine struct {
+	dir  string // "in" or "out"
+	at   time.Duration
+	line []byte
+}
+
+// wireLog writes lines to a writer from its own goroutine, dropping lines
+// when the writer falls behind.
+type wireLog struct {
+	w        io.Writer
+	scrub    *scrubber
+	mode     ThinkingMode // Thinking content is masked before scrubbing
+	start    time.Time
+	maxLines int
+	maxBytes int64
+
+	mu     sync.Mutex
+	closed bool
+	lines  chan wireLine
+	done   chan struct{}
+
+	queued  atomic.Int64 // Bytes in lines
+	dropped atomic.Int64 // Lines dropped since the last note
+}
+
+// newWireLog starts a wire log writing to w.
+func newWireLog(w io.Writer, scrub *scrubber, mode ThinkingMode, maxLines int, maxBytes int64) *wireLog {
+	l := &wireLog{
+		w:        w,
+		scrub:    scrub,
+		mode:     mode,
+		start:    time.Now(),
+		maxLines: maxLines,
+		maxBytes: maxBytes,
+		lines:    make(chan wireLine, maxLines),
+		done:     make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// log queues a copy of line, or counts it as dropped if the queue is full.
+func (l *wireLog) log(dir string, line []byte) {
+	at := time.Since(l.start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	// A line larger than the byte limit is still taken when nothing is queued
+	size := int64(len(line))
+	if queued := l.queued.Load(); queued > 0 && queued+size > l.maxBytes {
+		l.dropped.Add(1)
+		return
+	}
+	select {
+	case l.lines <- wireLine{dir: dir, at: at, line: append([]byte(nil), line...)}:
+		l.queued.Add(size)
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// run writes queued lines until the log is closed.
+func (l *wireLog) run() {
+	defer close(l.done)
+	var buf []byte
+	for e := range l.lines {
+		l.queued.Add(-int64(len(e.line)))
+		buf = l.appendDropped(buf[:0], e.at)
+		buf = append(buf, e.dir...)
+		buf = appendWireTime(buf, e.at)
+		buf = strconv.AppendInt(buf, int64(len(e.line)), 10)
+		buf = append(buf, "B "...)
+		buf = append(buf, l.scrub.scrub(string(maskThinking(e.line, l.mode)))...)
+		buf = append(buf, '\n')
+		_, _ = l.w.Write(buf) // Best effort - the log must not fail the run
+	}
+	if buf = l.appendDropped(buf[:0], time.Since(l.start)); len(buf) > 0 {
+		_, _ = l.w.Write(buf)
+	}
+}
+
+// appendDropped appends a note of the lines dropped since the last note.
+func (l *wireLog) appendDropped(buf []byte, at time.Duration) []byte {
+	n := l.dropped.Swap(0)
+	if n == 0 {
+		return buf
+	}
+	buf = append(buf, "dropped"...)
+	buf = appendWireTime(buf, at)
+	buf = strconv.AppendInt(buf, n, 10)
+	return append(buf, " lines\n"...)
+}
+
+// appendWireTime appends " <seconds>s ".
+func appendWireTime(buf []byte, at time.Duration) []byte {
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, at.Seconds(), 'f', 6, 64)
+	return append(buf, "s "...)
+}
+
+// close stops taking lines and waits, up to wireLogFlushTimeout, for the
+// queued lines to be written.
+func (l *wireLog) close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	close(l.lines)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(wireLogFlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-l.done:
+	case <-timer.C:
+	}
+}
+
+// wireTransport logs the lines passing through a transport.
+type wireTransport struct {
+	cliTransport
+	log *wireLog
+	out io.Reader
+
+	mu      sync.Mutex
+	partial []byte // Stdout read since the last newline
+}
+
+// newWireTransport wraps t, logging its lines to w.
+func newWireTransport(t cliTransport, w io.Writer, scrub *scrubber, mode ThinkingMode) *wireTransport {
+	wt := &wireTransport{
+		cliTransport: t,
+		log:          newWireLog(w, scrub, mode, wireLogLines, wireLogBytes),
+	}
+	wt.out = io.TeeReader(t.reader(), wireStdout{wt})
+	return wt
+}
+
+// wireStdout receives stdout as the parser reads it.
+type wireStdout struct{ wt *wireTransport }
+
+func (w wireStdout) Write(p []byte) (int, error) {
+	w.wt.mu.Lock()
+	defer w.wt.mu.Unlock()
+	w.wt.partial = append(w.wt.partial, p...)
+	for {
+		i := bytes.IndexByte(w.wt.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.wt.log.log(replayOut, w.wt.partial[:i])
+		w.wt.partial = w.wt.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// write logs each stdin line before passing it on.
+func (wt *wireTransport) write(data []byte) error {
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'}) {
+		wt.log.log(replayIn, line)
+	}
+	return wt.cliTransport.write(data)
+}
+
+// reader returns stdout, logging it as it is read.
+func (wt *wireTransport) reader() io.Reader {
+	return wt.out
+}
+
+// close closes the transport, logs any unterminated last line, and
+// flushes the log.
+func (wt *wireTransport) close() error {
+	err := wt.cliTransport.close()
+	wt.mu.Lock()
+	if len(wt.partial) > 0 {
+		wt.log.log(replayOut, wt.partial)
+		wt.partial = nil
+	}
+	wt.mu.Unlock()
+	wt.log.close()
+	return err
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// wireLinePattern matches a line of the wire log.
+var wireLinePattern = regexp.MustCompile(`^(in|out) \d+\.\d{6}s (\d+)B (.*)$`)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDebugWire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	script := `{"op":"in"}
+{"op":"out","line":"{\"type\":\"system\",\"subtype\":\"init\",\"session_id\":\"wire\"}"}
+{"op":"out","line":"{\"type\":\"assistant\",\"message\":{\"role\":\"assistant\",\"content\":[{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"Bash\",\"input\":{\"command\":\"ANTHROPIC_API_KEY=sk-test-secret env\"}}]}}"}
+{"op":"out","line":"{\"type\":\"control\",\"request_id\":\"req-1\",\"tool_use_id\":\"toolu_1\",\"tool_name\":\"Bash\",\"tool_input\":{}}"}
+{"op":"in"}
+{"op":"out","line":"` + strings.Repeat("x", 300) + `"}
+{"op":"out","line":"{\"type\":\"result\",\"result\":\"Done\",\"num_turns\":1}"}
+`
+	mustWriteFile(t, path, []byte(script), 0600)
+
+	var log syncBuffer
+	messages, err := replayRun(t, Replay(path), DebugWire(&log), MaxLineBytes(200))
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if len(messages) == 0 {
+		t.Fatal("no messages")
+	}
+
+	type entry struct {
+		dir  string
+		size int
+		line string
+	}
+	var entries []entry
+	for _, line := range strings.Split(strings.TrimSuffix(log.String(), "\n"), "\n") {
+		m := wireLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("wire log line %q has no direction, time, and length", line)
+		}
+		size, _ := strconv.Atoi(m[2])
+		entries = append(entries, entry{m[1], size, m[3]})
+	}
+
+	// Stdin and stdout lines are interleaved in the order they were exchanged
+	want := []struct{ dir, contains string }{
+		{"in", `"text":"go"`},
+		{"out", `"subtype":"init"`},
+		{"out", `"tool_use"`},
+		{"out", `"request_id":"req-1"`},
+		{"in", `"request_id":"req-1"`},
+		{"out", "xxxx"}, // Discarded by the parser, but logged
+		{"out", `"type":"result"`},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("wire log has %d lines, want %d:\n%s", len(entries), len(want), log.String())
+	}
+	for i, w := range want {
+		if entries[i].dir != w.dir || !strings.Contains(entries[i].line, w.contains) {
+			t.Errorf("line %d = %s %q, want %s containing %q", i, entries[i].dir, entries[i].line, w.dir, w.contains)
+		}
+	}
+	if entries[5].size != 300 {
+		t.Errorf("oversized line length = %d, want 300", entries[5].size)
+	}
+
+	// Secrets are scrubbed
+	if strings.Contains(log.String(), "sk-test-secret") {
+		t.Errorf("wire log contains the secret:\n%s", log.String())
+	}
+	if !strings.Contains(entries[2].line, "ANTHROPIC_API_KEY="+redacted) {
+		t.Errorf("tool use line = %q, want the key redacted", entries[2].line)
+	}
+}
+
+// blockingWriter blocks writes until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	buf     syncBuffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestWireLogDropsWhenBehind(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	l := newWireLog(w, nil, ThinkingFull, 4, 1<<20)
+
+	// The writer goroutine holds one line; the queue holds four more
+	for i := 0; i < 20; i++ {
+		l.log(replayOut, []byte(`{"n":`+strconv.Itoa(i)+`}`))
+	}
+	close(w.release)
+	l.close()
+
+	lines := strings.Split(strings.TrimSuffix(w.buf.String(), "\n"), "\n")
+	var logged, dropped int
+	for _, line := range lines {
+		if strings.HasPrefix(line, "dropped ") {
+			n, _ := strconv.Atoi(strings.Fields(line)[2])
+			dropped += n
+			continue
+		}
+		logged++
+	}
+	if logged < 4 || logged > 5 || logged+dropped != 20 {
+		t.Errorf("logged %d and dropped %d lines, want 4 or 5 logged of 20:\n%s", logged, dropped, w.buf.String())
+	}
+
+	// Lines after close are ignored
+	l.log(replayIn, []byte("late"))
+}
+
+func TestWireLogByteLimit(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	l := newWireLog(w, nil, ThinkingFull, 100, 10)
+
+	// The first line is taken although it exceeds the limit; with it queued
+	// or being written, one of the others is over the limit
+	for _, c := range "abc" {
+		l.log(replayOut, []byte(strings.Repeat(string(c), 50)))
+	}
+	close(w.release)
+	l.close()
+
+	got := w.buf.String()
+	if !strings.Contains(got, strings.Repeat("a", 50)) || !strings.Contains(got, "lines\n") {
+		t.Errorf("wire log = %q, want the first line and a dropped note", got)
+	}
+}
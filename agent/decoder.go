@@ -0,0 +1,103 @@
+package agent
+
+import "io"
+
+// Decoder reads typed messages from the output of
+// `claude --output-format stream-json`, for programs that run the CLI
+// themselves. It is the parser an Agent uses: messages carry the same
+// MessageMeta session, turn, and sequence numbers, repeated assistant
+// content is dropped, and a line over the DecoderMaxLineBytes limit is
+// reported as a ParseWarning instead of ending the stream. Output written
+// with CRLF line endings or a leading UTF-8 byte order mark, as by some
+// Windows shells, decodes as it would without them.
+//
+// A Decoder only parses. Hooks, audit events, custom tools, and other
+// features that answer or observe the CLI need an Agent.
+//
+// Example:
+//
+//	cmd := exec.Command("claude", "-p", "--output-format", "stream-json", "--verbose", prompt)
+//	stdout, _ := cmd.StdoutPipe()
+//	_ = cmd.Start()
+//	dec := agent.NewDecoder(stdout)
+//	for {
+//	    msg, err := dec.Next()
+//	    if err == io.EOF {
+//	        break
+//	    }
+//	    if err != nil {
+//	        log.Fatal(err)
+//	    }
+//	    if text, ok := msg.(*agent.Text); ok {
+//	        fmt.Print(text.Text)
+//	    }
+//	}
+type Decoder struct {
+	parser *parser
+
+	onControl func(*controlResponseMsg) // Receives control responses, which are not Messages
+}
+
+// DecoderOption configures a Decoder.
+type DecoderOption func(*Decoder)
+
+// DecoderMaxLineBytes limits the length of a line, as MaxLineBytes does for
+// an Agent. A value of 0 means unlimited (default).
+func DecoderMaxLineBytes(n int) DecoderOption {
+	return func(d *Decoder) {
+		d.parser.maxLineBytes = n
+	}
+}
+
+// NewDecoder returns a Decoder that reads from r.
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{parser: newParser(r)}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Next returns the next message. It returns io.EOF at the end of the
+// input, and an error for a line that is not valid JSON or a read error.
+// A Decoder cannot continue after an error.
+func (d *Decoder) Next() (Message, error) {
+	for {
+		msg, err := d.parser.next()
+		if err != nil {
+			return nil, err
+		}
+		// Control responses answer the Agent's requests; a standalone
+		// decoder made none
+		if resp, ok := msg.(*controlResponseMsg); ok {
+			if d.onControl != nil {
+				d.onControl(resp)
+			}
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// DecodeAll decodes every message in r. On error it returns the messages
+// decoded before it.
+//
+// Example:
+//
+//	f, _ := os.Open("session.jsonl")
+//	defer f.Close()
+//	messages, err := agent.DecodeAll(f)
+func DecodeAll(r io.Reader, opts ...DecoderOption) ([]Message, error) {
+	d := NewDecoder(r, opts...)
+	var messages []Message
+	for {
+		msg, err := d.Next()
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+}
@@ -0,0 +1,211 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoderMatchesAgent(t *testing.T) {
+	fixture := filepath.Join("testdata", "cli_stream.jsonl")
+	decoded, err := DecodeAll(bytes.NewReader(mustReadFile(t, fixture)))
+	if err != nil {
+		t.Fatalf("DecodeAll() error = %v", err)
+	}
+
+	abs, err := filepath.Abs(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := "#!/bin/sh\nread line\ncat '" + abs + "'\n"
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+	var streamed []Message
+	for msg := range a.Stream(ctx, "fix the parser") {
+		streamed = append(streamed, msg)
+	}
+	if err := a.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	// Stream delivers the public message types, and adds run details to
+	// the Result; the rest must match
+	var public []Message
+	for _, msg := range decoded {
+		if messageTypeOf(msg) != "" {
+			public = append(public, msg)
+		}
+	}
+	for _, msgs := range [][]Message{decoded, streamed} {
+		for _, msg := range msgs {
+			if meta := messageMeta(msg); meta != nil {
+				meta.Timestamp = time.Time{}
+				meta.DeliveredSequence = 0 // Only the agent delivers
+			}
+			if result, ok := msg.(*Result); ok {
+				result.RunID, result.OriginalPrompt, result.Prompt = "", "", ""
+				result.Denials = nil
+				result.CostBreakdown = CostBreakdown{}
+				result.PromptBytes, result.ResponseBytes, result.ToolOutputBytes = 0, 0, 0
+				result.PromptTokens, result.CompletionTokens = 0, 0
+			}
+		}
+	}
+	if len(public) != len(streamed) {
+		t.Fatalf("decoded %d public messages, the agent streamed %d", len(public), len(streamed))
+	}
+	for i := range public {
+		if !reflect.DeepEqual(public[i], streamed[i]) {
+			t.Errorf("message %d: decoded %#v, streamed %#v", i, public[i], streamed[i])
+		}
+	}
+
+	want := []string{
+		"*agent.SystemInit", "*agent.Thinking", "*agent.Text", "*agent.ToolUse", "*agent.ToolResult",
+		"*agent.Text", "*agent.ToolUse", "*agent.ToolResult", "*agent.CompactMsg", "*agent.Text", "*agent.Result",
+	}
+	var got []string
+	for _, msg := range decoded {
+		got = append(got, fmt.Sprintf("%T", msg))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("message types = %v, want %v", got, want)
+	}
+	last := messageMeta(decoded[len(decoded)-1])
+	if last.SessionID != "4f1c2a9e-stream" || last.Sequence != len(decoded) {
+		t.Errorf("last MessageMeta = %+v, want the session and sequence %d", last, len(decoded))
+	}
+}
+
+func TestDecoderNext(t *testing.T) {
+	input := `{"type":"system","subtype":"init","session_id":"s1"}` + "\n" +
+		`{"type":"control_response","response":{"subtype":"success","request_id":"r1"}}` + "\n" +
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"` + strings.Repeat("x", 100) + `"}]}}` + "\n" +
+		`{"type":"result","result":"Done","num_turns":1}` + "\n"
+	dec := NewDecoder(strings.NewReader(input), DecoderMaxLineBytes(80))
+
+	var types []string
+	for {
+		msg, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		types = append(types, fmt.Sprintf("%T", msg))
+	}
+	// The control response is skipped and the long line reported
+	want := []string{"*agent.SystemInit", "*agent.ParseWarning", "*agent.Result"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("message types = %v, want %v", types, want)
+	}
+}
+
+func TestDecodeAllError(t *testing.T) {
+	input := `{"type":"system","subtype":"init","session_id":"s1"}` + "\n{not json\n"
+	messages, err := DecodeAll(strings.NewReader(input))
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &syntaxErr) || len(messages) != 1 {
+		t.Errorf("DecodeAll() = %d messages, %v; want the init and an error", len(messages), err)
+	}
+}
+
+func TestDecoderLineEndings(t *testing.T) {
+	clean := mustReadFile(t, filepath.Join("testdata", "cli_stream.jsonl"))
+	crlf := bytes.ReplaceAll(clean, []byte("\n"), []byte("\r\n"))
+	lines := bytes.SplitAfter(clean, []byte("\n"))
+	var mixed []byte
+	for i, line := range lines {
+		if i%2 == 0 {
+			line = bytes.ReplaceAll(line, []byte("\n"), []byte("\r\n"))
+		}
+		mixed = append(mixed, line...)
+	}
+	mixed = append(mixed, "\r\n\r\n"...) // Blank CRLF lines
+
+	decode := func(input []byte) []Message {
+		t.Helper()
+		messages, err := DecodeAll(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("DecodeAll() error = %v", err)
+		}
+		// Internal message types embed MessageMeta too
+		for _, msg := range messages {
+			if meta := reflect.ValueOf(msg).Elem().FieldByName("MessageMeta"); meta.IsValid() {
+				meta.FieldByName("Timestamp").Set(reflect.ValueOf(time.Time{}))
+			}
+		}
+		return messages
+	}
+	want := decode(clean)
+	bom := "\xEF\xBB\xBF"
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"BOM", append([]byte(bom), clean...)},
+		{"CRLF", crlf},
+		{"BOM and CRLF", append([]byte(bom), crlf...)},
+		{"mixed", append([]byte(bom), mixed...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decode(tt.input); !reflect.DeepEqual(got, want) {
+				t.Errorf("DecodeAll() = %d messages, differing from the clean stream's %d", len(got), len(want))
+			}
+		})
+	}
+
+	// Only a leading BOM is stripped, and text content is left alone
+	input := `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"a\r\nb` + bom + `"}]}}` + "\r\n" +
+		bom + `{"type":"result","result":"Done"}` + "\r\n"
+	messages, err := DecodeAll(strings.NewReader(input))
+	if err == nil || len(messages) != 1 {
+		t.Fatalf("DecodeAll() = %d messages, %v; want the text and an error for the second BOM", len(messages), err)
+	}
+	if text := messages[0].(*Text).Text; text != "a\r\nb"+bom {
+		t.Errorf("Text = %q, want the content unchanged", text)
+	}
+}
+
+func TestAgentToleratesBOMAndCRLF(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '\357\273\277{"type":"system","subtype":"init","session_id":"crlf-test"}\r\n'
+printf '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hi"}]}}\r\n'
+printf '{"type":"result","result":"Done","num_turns":1}\r\n'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.ResultText != "Done" || a.SessionID() != "crlf-test" {
+		t.Errorf("ResultText = %q, SessionID() = %q, want Done and crlf-test", result.ResultText, a.SessionID())
+	}
+}
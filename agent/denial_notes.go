@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DenialNote describes a denied tool call for ExplainDenials.
+type DenialNote struct {
+	Tool    string // Name of the denied tool, e.g. "Bash"
+	Subject string // The command, file path, or URL of the call; empty if the tool has none
+	Reason  string // HookResult.Reason of the denial
+}
+
+// String returns the default note, for example "The command 'curl
+// example.com' was blocked by policy: network access is not allowed."
+func (n DenialNote) String() string {
+	var b strings.Builder
+	switch {
+	case n.Subject == "":
+		fmt.Fprintf(&b, "The %s call was blocked by policy", n.Tool)
+	case n.Tool == ToolBash:
+		fmt.Fprintf(&b, "The command '%s' was blocked by policy", n.Subject)
+	default:
+		fmt.Fprintf(&b, "The %s call on '%s' was blocked by policy", n.Tool, n.Subject)
+	}
+	if n.Reason != "" {
+		b.WriteString(": ")
+		b.WriteString(strings.TrimSuffix(n.Reason, "."))
+	}
+	b.WriteString(".")
+	return b.String()
+}
+
+// maxNoteSubject is the length, in runes, a note's subject is cut to.
+const maxNoteSubject = 80
+
+// ExplainDenials adds a note to each PreToolUse denial sent to the CLI, in
+// the additional_context field of the response, so that the denial is
+// recorded in the conversation. A session resumed later, in another
+// process, then knows which calls the policy blocked instead of retrying
+// them. The note is made from HookResult.Reason, for example "The command
+// 'curl example.com' was blocked by policy: network access is not
+// allowed.", or with DenialNoteTemplate.
+//
+// Each tool and reason is explained once per run; later denials with the
+// same tool and reason send only the reason. Each note is reported in a
+// hook.denial_explained audit event.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PreToolUse(agent.DenyCommands("curl", "wget")),
+//	    agent.ExplainDenials(true),
+//	)
+func ExplainDenials(explain bool) Option {
+	return func(c *config) {
+		c.explainDenials = explain
+	}
+}
+
+// DenialNoteTemplate sets the text of the notes ExplainDenials sends. tmpl
+// is a text/template executed with a DenialNote; New returns a
+// *ConfigError if it does not parse. A note that fails to execute falls
+// back to the default.
+//
+// Example:
+//
+//	agent.DenialNoteTemplate(`Policy blocked {{.Tool}} ({{.Subject}}): {{.Reason}}. Do not retry.`)
+func DenialNoteTemplate(tmpl string) Option {
+	return func(c *config) {
+		c.denialNoteText = tmpl
+		c.denialNote, c.denialNoteErr = template.New("denial").Parse(tmpl)
+	}
+}
+
+// validateDenialNote reports a DenialNoteTemplate that does not parse.
+func validateDenialNote(cfg *config) error {
+	if cfg.denialNoteErr == nil {
+		return nil
+	}
+	return &ConfigError{
+		Option: "DenialNoteTemplate",
+		Value:  cfg.denialNoteText,
+		Reason: cfg.denialNoteErr.Error(),
+	}
+}
+
+// newDenialNote describes a denied tool call.
+func newDenialNote(tc *ToolCall, reason string) DenialNote {
+	var subject string
+	switch {
+	case tc.Name == ToolBash:
+		subject, _ = tc.Input["command"].(string)
+	case tc.Name == ToolWebFetch:
+		subject, _ = tc.Input["url"].(string)
+	default:
+		subject, _ = extractPath(tc.Input)
+	}
+	if r := []rune(subject); len(r) > maxNoteSubject {
+		subject = string(r[:maxNoteSubject]) + "..."
+	}
+	return DenialNote{Tool: tc.Name, Subject: subject, Reason: reason}
+}
+
+// explainDenial returns the note to send with a denial, or "" if
+// ExplainDenials is off or the run already explained the tool and reason.
+func (a *Agent) explainDenial(tc *ToolCall, reason string, first bool) string {
+	if !a.cfg.explainDenials || !first {
+		return ""
+	}
+	note := newDenialNote(tc, reason)
+	text := note.String()
+	data := map[string]any{
+		"tool":        tc.Name,
+		"reason":      reason,
+		"tool_use_id": tc.ID,
+	}
+	if a.cfg.denialNote != nil {
+		var b strings.Builder
+		if err := a.cfg.denialNote.Execute(&b, note); err != nil {
+			data["template_error"] = err.Error()
+		} else {
+			text = b.String()
+		}
+	}
+	data["note"] = text
+	a.auditor.emit(a.SessionID(), "hook.denial_explained", data)
+	return text
+}
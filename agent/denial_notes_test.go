@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// denialNoteScript is denied twice for the same tool and reason in each of
+// two runs, and once for another reason, recording the responses.
+const denialNoteScript = `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"note-test"}'
+echo '{"type":"control","request_id":"req_1","tool_name":"Bash","tool_input":{"command":"rm -rf build"}}'
+read -r response
+printf '%s\n' "$response" >> WIRE
+echo '{"type":"control","request_id":"req_2","tool_name":"Bash","tool_input":{"command":"rm -r build"}}'
+read -r response
+printf '%s\n' "$response" >> WIRE
+echo '{"type":"control","request_id":"req_3","tool_name":"Write","tool_input":{"file_path":"/a"}}'
+read -r response
+printf '%s\n' "$response" >> WIRE
+echo '{"type":"result","result":"Done","num_turns":1}'
+read -r line
+echo '{"type":"control","request_id":"req_4","tool_name":"Bash","tool_input":{"command":"rm -rf build"}}'
+read -r response
+printf '%s\n' "$response" >> WIRE
+echo '{"type":"result","result":"Done","num_turns":1}'
+read -r line || exit 0
+`
+
+func TestExplainDenials(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(strings.ReplaceAll(denialNoteScript, "WIRE", wire)), 0755)
+
+	var mu sync.Mutex
+	var notes []string
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(denyRemovals),
+		ExplainDenials(true),
+		Audit(func(e AuditEvent) {
+			if e.Type == "hook.denial_explained" {
+				mu.Lock()
+				notes = append(notes, e.Data.(map[string]any)["note"].(string))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Run(ctx, "clean up"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+
+	// The repeated denial in the first run is not explained again; the
+	// second run explains it afresh
+	rmNote := "The command 'rm -rf build' was blocked by policy: removal not allowed."
+	writeNote := "The Write call on '/a' was blocked by policy: read-only."
+	want := `{"request_id":"req_1","decision":"deny","reason":"removal not allowed","additional_context":"` + rmNote + `"}
+{"request_id":"req_2","decision":"deny","reason":"removal not allowed"}
+{"request_id":"req_3","decision":"deny","reason":"read-only","additional_context":"` + writeNote + `"}
+{"request_id":"req_4","decision":"deny","reason":"removal not allowed","additional_context":"` + rmNote + `"}
+`
+	if got := string(mustReadFile(t, wire)); got != want {
+		t.Errorf("wire =\n%s\nwant\n%s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if wantNotes := []string{rmNote, writeNote, rmNote}; strings.Join(notes, "|") != strings.Join(wantNotes, "|") {
+		t.Errorf("hook.denial_explained notes = %q, want %q", notes, wantNotes)
+	}
+}
+
+func TestDenialNoteTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(strings.ReplaceAll(denialNoteScript, "WIRE", wire)), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(denyRemovals),
+		ExplainDenials(true),
+		DenialNoteTemplate("{{.Tool}} denied ({{.Reason}}); do not retry {{.Subject}}"),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "clean up"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	first := strings.SplitN(string(mustReadFile(t, wire)), "\n", 2)[0]
+	if !strings.Contains(first, `"additional_context":"Bash denied (removal not allowed); do not retry rm -rf build"`) {
+		t.Errorf("first response = %s, want the templated note", first)
+	}
+
+	_, err = New(ctx, CLIPath(fakeClaude), DenialNoteTemplate("{{.Tool"))
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Option != "DenialNoteTemplate" {
+		t.Errorf("New() error = %v, want a ConfigError for DenialNoteTemplate", err)
+	}
+}
+
+func TestDenialNoteString(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		note DenialNote
+		want string
+	}{
+		{DenialNote{Tool: "Bash", Subject: "curl example.com", Reason: "network access is not allowed"},
+			"The command 'curl example.com' was blocked by policy: network access is not allowed."},
+		{DenialNote{Tool: "Read", Subject: "/etc/passwd", Reason: "path is in denied list."},
+			"The Read call on '/etc/passwd' was blocked by policy: path is in denied list."},
+		{DenialNote{Tool: "WebSearch"}, "The WebSearch call was blocked by policy."},
+	}
+	for _, tt := range tests {
+		if got := tt.note.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+
+	note := newDenialNote(&ToolCall{Name: "Bash", Input: map[string]any{"command": long}}, "no")
+	if note.Subject != strings.Repeat("x", maxNoteSubject)+"..." {
+		t.Errorf("Subject = %q, want it cut to %d runes", note.Subject, maxNoteSubject)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import "context"
+
+// DenialRecord counts the tool calls that PreToolUse hooks denied during a
+// single run for one tool and reason.
+type DenialRecord struct {
+	Tool   string
+	Reason string
+	Count  int
+}
+
+// MaxDenialsPerRun stops a run once PreToolUse hooks have denied more than
+// n of its tool calls. Repeated denials usually mean Claude is retrying
+// variants of a call the policy will never allow. The CLI is asked to
+// interrupt the turn, and Run returns the Result with a
+// *PolicyThrashError. A value of 0 means unlimited (default).
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PreToolUse(agent.DenyCommands("rm -rf")),
+//	    agent.MaxDenialsPerRun(5),
+//	)
+func MaxDenialsPerRun(n int) Option {
+	return func(c *config) {
+		c.maxDenials = n
+	}
+}
+
+// denialTracker counts a run's PreToolUse denials, grouped by tool and
+// reason. It is used only by the run's stream goroutine.
+type denialTracker struct {
+	records []DenialRecord
+	index   map[DenialRecord]int // Keyed by Tool and Reason, Count zero
+	total   int
+	last    DenialRecord // Tool and Reason of the latest denial
+	tripped bool         // MaxDenialsPerRun was exceeded
+}
+
+// newDenialTracker creates an empty tracker.
+func newDenialTracker() *denialTracker {
+	return &denialTracker{index: make(map[DenialRecord]int)}
+}
+
+// record counts a denial and returns the run's total and whether it is the
+// first with its tool and reason.
+func (t *denialTracker) record(tool, reason string) (int, bool) {
+	key := DenialRecord{Tool: tool, Reason: reason}
+	i, seen := t.index[key]
+	if !seen {
+		i = len(t.records)
+		t.index[key] = i
+		t.records = append(t.records, key)
+	}
+	t.records[i].Count++
+	t.total++
+	t.last = key
+	return t.total, !seen
+}
+
+// snapshot returns a copy of the records, or nil if there are none.
+func (t *denialTracker) snapshot() []DenialRecord {
+	if t == nil || len(t.records) == 0 {
+		return nil
+	}
+	return append([]DenialRecord(nil), t.records...)
+}
+
+// recordDenial counts a denied tool call against the current run and
+// reports whether it is the run's first with its tool and reason. When the
+// run first exceeds MaxDenialsPerRun, it emits a policy.thrash audit event
+// and asks the CLI to interrupt the turn.
+func (a *Agent) recordDenial(tc *ToolCall, reason string) bool {
+	a.mu.Lock()
+	t := a.runDenials
+	sessionID := a.sessionID
+	a.mu.Unlock()
+
+	total, first := t.record(tc.Name, reason)
+	if a.cfg.maxDenials <= 0 || total <= a.cfg.maxDenials || t.tripped {
+		return first
+	}
+	t.tripped = true
+
+	a.auditor.emit(sessionID, "policy.thrash", map[string]any{
+		"denials":     t.snapshot(),
+		"max_denials": a.cfg.maxDenials,
+	})
+	// Interrupting waits for the CLI's answer, which the bridge delivers
+	// independently of this stream
+	go func() {
+		_, _ = a.SendControl(context.Background(), "interrupt", nil) // Best effort; the turn may already be ending
+	}()
+	return first
+}
+
+// denialError returns the error for a run that exceeded MaxDenialsPerRun,
+// or nil.
+func (a *Agent) denialError(t *denialTracker) *PolicyThrashError {
+	if !t.tripped {
+		return nil
+	}
+	return &PolicyThrashError{Denials: t.snapshot(), MaxDenials: a.cfg.maxDenials}
+}
+
+// interruptError returns the error for a run whose Result is an error
+// after a PreToolUse hook denied one of its tool calls, or nil. Errors the
+// CLI's text identifies, such as an *AuthError, and a *PolicyThrashError
+// take precedence.
+func (a *Agent) interruptError(t *denialTracker, result *Result) *HookInterruptError {
+	if !result.IsError || t.total == 0 || t.tripped || classifyError(result.ResultText, nil) != nil {
+		return nil
+	}
+	return &HookInterruptError{
+		Hook:   "PreToolUse",
+		Tool:   t.last.Tool,
+		Reason: t.last.Reason,
+		Cause:  &TaskError{SessionID: a.SessionID(), Message: result.ResultText},
+	}
+}
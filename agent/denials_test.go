@@ -0,0 +1,253 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// denyRemovals denies Bash commands that remove files.
+func denyRemovals(tc *ToolCall) HookResult {
+	if cmd, _ := tc.Input["command"].(string); tc.Name == "Bash" && strings.HasPrefix(cmd, "rm ") {
+		return HookResult{Decision: Deny, Reason: "removal not allowed"}
+	}
+	if tc.Name == "Write" {
+		return HookResult{Decision: Deny, Reason: "read-only"}
+	}
+	return HookResult{Decision: Continue}
+}
+
+func TestDenialsRecordedOnResult(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"denial-test"}'
+echo '{"type":"control","request_id":"req_1","tool_name":"Bash","tool_input":{"command":"rm -rf build"}}'
+read -r response
+echo '{"type":"control","request_id":"req_2","tool_name":"Write","tool_input":{"file_path":"/a"}}'
+read -r response
+echo '{"type":"control","request_id":"req_3","tool_name":"Bash","tool_input":{"command":"rm -r build"}}'
+read -r response
+echo '{"type":"control","request_id":"req_4","tool_name":"Bash","tool_input":{"command":"ls"}}'
+read -r response
+echo '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var summary map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(denyRemovals),
+		Audit(func(e AuditEvent) {
+			if e.Type == "message.result" {
+				mu.Lock()
+				summary = e.Data.(map[string]any)
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "clean up")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []DenialRecord{
+		{Tool: "Bash", Reason: "removal not allowed", Count: 2},
+		{Tool: "Write", Reason: "read-only", Count: 1},
+	}
+	if !reflect.DeepEqual(result.Denials, want) {
+		t.Errorf("Denials = %+v, want %+v", result.Denials, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(summary["denials"], want) {
+		t.Errorf("message.result denials = %v, want %+v", summary["denials"], want)
+	}
+
+}
+
+func TestMaxDenialsPerRunStopsRun(t *testing.T) {
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"thrash-test"}'
+for i in 1 2 3; do
+  echo '{"type":"control","request_id":"req_'$i'","tool_name":"Bash","tool_input":{"command":"rm -rf build'$i'"}}'
+  read -r response
+done
+read -r line
+printf '%s\n' "$line" > ` + wire + `
+echo '{"type":"result","subtype":"error_during_execution","is_error":true,"result":"interrupted","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var thrash []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PreToolUse(denyRemovals),
+		MaxDenialsPerRun(2),
+		Audit(func(e AuditEvent) {
+			if e.Type == "policy.thrash" {
+				mu.Lock()
+				thrash = append(thrash, e)
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "clean up")
+	var thrashErr *PolicyThrashError
+	if !errors.As(err, &thrashErr) {
+		t.Fatalf("Run() error = %v, want *PolicyThrashError", err)
+	}
+	want := []DenialRecord{{Tool: "Bash", Reason: "removal not allowed", Count: 3}}
+	if thrashErr.MaxDenials != 2 || !reflect.DeepEqual(thrashErr.Denials, want) {
+		t.Errorf("PolicyThrashError = %+v, want %+v over a limit of 2", thrashErr, want)
+	}
+	if err.Error() != "agent: 3 tool calls denied in one run, exceeding the limit of 2" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+	if result == nil || !reflect.DeepEqual(result.Denials, want) {
+		t.Errorf("Run() result = %+v, want the interrupted turn's result with its denials", result)
+	}
+	if line := string(mustReadFile(t, wire)); !strings.Contains(line, `"subtype":"interrupt"`) {
+		t.Errorf("control request on the wire = %s, want an interrupt", line)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(thrash) != 1 || thrash[0].RunID != result.RunID {
+		t.Errorf("policy.thrash events = %+v, want one for the run", thrash)
+	}
+}
+
+// deniedTaskCLI writes a fake CLI that asks to use Bash and Write on each
+// prompt and then reports the result line, which may be an error.
+func deniedTaskCLI(t *testing.T, result string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+while read -r line; do
+	echo '{"type":"system","subtype":"init","session_id":"denied-test"}'
+	echo '{"type":"control","request_id":"req_1","tool_name":"Bash","tool_input":{"command":"rm -rf build"}}'
+	read -r response
+	echo '{"type":"control","request_id":"req_2","tool_name":"Write","tool_input":{"file_path":"/a"}}'
+	read -r response
+	echo '` + result + `'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestDeniedRunReturnsHookInterruptError(t *testing.T) {
+	var mu sync.Mutex
+	var stops []StopReason
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(deniedTaskCLI(t, `{"type":"result","result":"Could not write the file: permission denied","is_error":true,"num_turns":2}`)),
+		PreToolUse(denyRemovals),
+		OnStop(func(e *StopEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			stops = append(stops, e.Reason)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	result, err := a.Run(ctx, "write the report")
+	var herr *HookInterruptError
+	if !errors.As(err, &herr) {
+		t.Fatalf("Run() error = %v, want a *HookInterruptError", err)
+	}
+	if herr.Hook != "PreToolUse" || herr.Tool != "Write" || herr.Reason != "read-only" {
+		t.Errorf("HookInterruptError = %+v, want the last denial", herr)
+	}
+	var terr *TaskError
+	if !errors.As(err, &terr) || terr.SessionID != "denied-test" || !strings.Contains(terr.Message, "permission denied") {
+		t.Errorf("Run() error = %v, want it to wrap the Result's *TaskError", err)
+	}
+	if result == nil || !result.IsError || len(result.Denials) != 2 {
+		t.Errorf("Run() Result = %+v, want the error Result with its denials", result)
+	}
+
+	// Stream reports the same through Err
+	for range a.Stream(ctx, "try again") {
+	}
+	if err := a.Err(); !errors.As(err, &herr) || herr.Tool != "Write" {
+		t.Errorf("Err() = %v, want a *HookInterruptError", err)
+	}
+
+	mustClose(t, a)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stops) != 1 || stops[0] != StopDenied {
+		t.Errorf("stop reasons = %v, want %s", stops, StopDenied)
+	}
+}
+
+func TestDeniedRunOtherResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+		check  func(error) bool
+	}{
+		{"success after denials", `{"type":"result","result":"Done without writing","num_turns":2}`,
+			func(err error) bool { return err == nil }},
+		{"recognized CLI error", `{"type":"result","result":"Invalid API key · Please run /login","is_error":true,"num_turns":1}`,
+			func(err error) bool {
+				var aerr *AuthError
+				return errors.As(err, &aerr)
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, err := New(ctx, CLIPath(deniedTaskCLI(t, tt.result)), PreToolUse(denyRemovals))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer mustClose(t, a)
+			if _, err := a.Run(ctx, "write the report"); !tt.check(err) {
+				t.Errorf("Run() error = %v", err)
+			}
+			var herr *HookInterruptError
+			if errors.As(a.Err(), &herr) {
+				t.Errorf("Err() = %v, want no *HookInterruptError", a.Err())
+			}
+		})
+	}
+
+	// An error Result without a denial is not a hook's doing
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(deniedTaskCLI(t, `{"type":"result","result":"Something broke","is_error":true,"num_turns":2}`)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+	var herr *HookInterruptError
+	if _, err := a.Run(ctx, "write the report"); errors.As(err, &herr) {
+		t.Errorf("Run() error = %v, want no *HookInterruptError", err)
+	}
+}
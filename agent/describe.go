@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Description is the configuration a set of options produces, as reported
+// by Describe. It is meant for review and audit logs: it shows what
+// profiles expanded to and which CLI arguments New would pass.
+type Description struct {
+	Model           string
+	WorkDir         string
+	Tools           []string
+	AllowedTools    []string
+	DisallowedTools []string
+	PermissionMode  PermissionMode
+	MaxTurns        int
+	PreToolUseHooks int                  // Number of PreToolUse hooks, including those of profiles
+	Profiles        []ProfileDescription // Profiles applied, in order
+	Args            []string             // CLI arguments, with MCP server credentials redacted
+	AdvertisedTools []AdvertisedTool     // Custom tools as advertised to Claude, sorted by name
+	ToolTokens      int                  // Estimated tokens of AdvertisedTools
+	Credentials     []string             // Credential variables set for the CLI, as "NAME=[redacted]"
+}
+
+// Describe returns the configuration opts produce without starting the
+// CLI. Options are applied in order, as by New, so the Description shows
+// any overrides of a profile's settings by later options.
+//
+// Example:
+//
+//	fmt.Println(agent.Describe(agent.ProfileSandboxed("/work/repo"), agent.MaxTurns(100)))
+func Describe(opts ...Option) Description {
+	cfg := newConfig(opts...)
+	args := startAttemptData(cfg)["args"].([]string)
+	d := Description{
+		Model:           cfg.model,
+		WorkDir:         cfg.workDir,
+		Tools:           cfg.tools,
+		AllowedTools:    cfg.allowedTools,
+		DisallowedTools: cfg.disallowedTools,
+		PermissionMode:  cfg.permissionMode,
+		MaxTurns:        cfg.maxTurns,
+		PreToolUseHooks: len(cfg.preToolUseHooks),
+		Profiles:        cfg.profiles,
+		Args:            args,
+		AdvertisedTools: advertiseTools(cfg),
+		Credentials:     redactedCredentials(cfg),
+	}
+	for _, t := range d.AdvertisedTools {
+		d.ToolTokens += t.Tokens
+	}
+	if cfg.noTools {
+		d.Tools = []string{}
+	}
+	return d
+}
+
+// String formats d as one setting per line, with (none) for empty
+// settings, followed by the options of each profile.
+func (d Description) String() string {
+	var b strings.Builder
+	line := func(name string, value any) {
+		v := fmt.Sprint(value)
+		if v == "" {
+			v = "(none)"
+		}
+		fmt.Fprintf(&b, "%-18s%s\n", name+":", v)
+	}
+	line("model", d.Model)
+	line("work dir", d.WorkDir)
+	line("tools", strings.Join(d.Tools, ","))
+	line("allowed tools", strings.Join(d.AllowedTools, ","))
+	line("disallowed tools", strings.Join(d.DisallowedTools, ","))
+	line("permission mode", d.PermissionMode)
+	line("max turns", d.MaxTurns)
+	line("pre-tool hooks", d.PreToolUseHooks)
+	line("args", strings.Join(d.Args, " "))
+	line("credentials", strings.Join(d.Credentials, " "))
+	if len(d.AdvertisedTools) > 0 {
+		line("custom tools", fmt.Sprintf("~%d tokens", d.ToolTokens))
+		for _, t := range d.AdvertisedTools {
+			fmt.Fprintf(&b, "  %s: ~%d tokens\n", t.Name, t.Tokens)
+		}
+	}
+	for _, p := range d.Profiles {
+		fmt.Fprintf(&b, "profile %s:\n", p.Name)
+		for _, m := range p.Members {
+			fmt.Fprintf(&b, "  %s\n", m)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,364 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxPreviewFileBytes is the size of the largest file PreviewEdit reads.
+const MaxPreviewFileBytes = 4 << 20
+
+// EditPreview shows what an Edit, MultiEdit, or Write call would do to its
+// file.
+type EditPreview struct {
+	// Path is the file's absolute path.
+	Path string
+	// Diff is a unified diff from the current content to the proposed
+	// content, or empty if the call changes nothing.
+	Diff    string
+	Added   int // Lines added
+	Removed int // Lines removed
+
+	// Created is true when the file does not exist yet.
+	Created bool
+	// NotFound is true when an old_string is not in the file. The CLI
+	// rejects such a call; the preview leaves that edit out.
+	NotFound bool
+	// Ambiguous is true when an old_string occurs more than once and
+	// replace_all is not set. The CLI rejects such a call; the preview
+	// replaces the first occurrence.
+	Ambiguous bool
+}
+
+// PreviewEdit reads the file an Edit, MultiEdit, or Write call targets and
+// applies the call in memory, returning a unified diff of the change for
+// an approval prompt or audit record. A relative path resolves against
+// workDir, or the agent's WorkDir when workDir is empty. The file is not
+// modified.
+//
+// Edit replaces the first occurrence of old_string with new_string, or
+// every occurrence with replace_all; MultiEdit applies its edits in order;
+// Write replaces the whole content. A file that does not exist previews as
+// empty. Files with CRLF line endings are matched and diffed with LF
+// endings, as the CLI keeps a file's line endings when it edits it.
+//
+// PreviewEdit returns an error for other tools, a call without a path, a
+// path that is not a regular file, and files larger than
+// MaxPreviewFileBytes.
+//
+// Example:
+//
+//	agent.RequireApproval([]string{"*.go"}, func(ctx context.Context, tc *agent.ToolCall) bool {
+//	    preview, err := agent.PreviewEdit(tc, "")
+//	    if err != nil {
+//	        return false
+//	    }
+//	    return askUser(ctx, preview.Diff)
+//	})
+func PreviewEdit(tc *ToolCall, workDir string) (*EditPreview, error) {
+	if tc.Name != ToolEdit && tc.Name != ToolMultiEdit && tc.Name != ToolWrite {
+		return nil, fmt.Errorf("agent: cannot preview %s calls", tc.Name)
+	}
+	name, ok := extractPath(tc.Input)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("agent: %s call has no file_path", tc.Name)
+	}
+	if workDir == "" {
+		workDir = tc.workDir
+	}
+	preview := &EditPreview{Path: normalizePath(name, workDir, false)}
+
+	before, err := readPreviewFile(preview.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		preview.Created = true
+	} else if err != nil {
+		return nil, err
+	}
+	before = strings.ReplaceAll(before, "\r\n", "\n")
+
+	after := before
+	switch tc.Name {
+	case ToolWrite:
+		content, _ := tc.Input["content"].(string)
+		after = strings.ReplaceAll(content, "\r\n", "\n")
+	case ToolEdit:
+		after = preview.apply(after, tc.Input)
+	case ToolMultiEdit:
+		edits, _ := tc.Input["edits"].([]any)
+		for _, e := range edits {
+			if edit, ok := e.(map[string]any); ok {
+				after = preview.apply(after, edit)
+			}
+		}
+	}
+
+	oldName := name
+	if preview.Created {
+		oldName = "/dev/null"
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+	preview.Diff = unifiedDiff(oldName, name, ops)
+	for _, op := range ops {
+		switch op.kind {
+		case '+':
+			preview.Added++
+		case '-':
+			preview.Removed++
+		}
+	}
+	return preview, nil
+}
+
+// readPreviewFile reads a file of at most MaxPreviewFileBytes.
+func readPreviewFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("agent: cannot preview %s: not a regular file", path)
+	}
+	if info.Size() > MaxPreviewFileBytes {
+		return "", fmt.Errorf("agent: cannot preview %s: %d bytes exceeds the %d byte limit", path, info.Size(), MaxPreviewFileBytes)
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- Path from the tool call being previewed
+	return string(data), err
+}
+
+// apply applies one Edit to content, recording a missing or ambiguous
+// old_string.
+func (p *EditPreview) apply(content string, edit map[string]any) string {
+	oldString, _ := edit["old_string"].(string)
+	newString, _ := edit["new_string"].(string)
+	replaceAll, _ := edit["replace_all"].(bool)
+	oldString = strings.ReplaceAll(oldString, "\r\n", "\n")
+	newString = strings.ReplaceAll(newString, "\r\n", "\n")
+
+	// An empty old_string creates the file
+	if oldString == "" {
+		if content != "" {
+			p.NotFound = true
+			return content
+		}
+		return newString
+	}
+	switch n := strings.Count(content, oldString); {
+	case n == 0:
+		p.NotFound = true
+		return content
+	case n > 1 && !replaceAll:
+		p.Ambiguous = true
+	}
+	if replaceAll {
+		return strings.ReplaceAll(content, oldString, newString)
+	}
+	return strings.Replace(content, oldString, newString, 1)
+}
+
+// splitLines splits text into lines that keep their "\n", so a last line
+// without one differs from the same line with one.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOp is a line of a diff: ' ' for a line in both versions, '-' for a
+// removed line, and '+' for an added one.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// maxDiffEdits bounds the edit distance diffLines searches for. Versions
+// further apart are diffed as removing the differing lines and adding the
+// new ones.
+const maxDiffEdits = 1000
+
+// diffLines returns a shortest edit script from a to b.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b)-prefix-suffix)
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myersDiff finds a shortest edit script with Myers' algorithm.
+func myersDiff(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k = x - y
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int // The diagonals -d-1 to d+1 of v before each step d
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // Right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return myersBacktrack(a, b, trace)
+			}
+		}
+	}
+
+	// Too far apart to search; replace everything
+	ops := make([]diffOp, 0, n+m)
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
+
+// myersBacktrack recovers the edit script from the saved frontiers.
+func myersBacktrack(a, b []string, trace [][]int) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// unifiedDiff formats an edit script as a unified diff, or returns "" if
+// it has no changes.
+func unifiedDiff(oldName, newName string, ops []diffOp) string {
+	// Line numbers before each op, in the old and new versions
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	changed := false
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.kind != '-' {
+			newLine[i+1]++
+		}
+		changed = changed || op.kind != ' '
+	}
+	if !changed {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("--- " + oldName + "\n+++ " + newName + "\n")
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := max(i-diffContext, 0)
+
+		// Extend the hunk over changes separated by little context
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next < len(ops) && next-end <= 2*diffContext {
+				end = next
+				continue
+			}
+			end = min(end+diffContext, len(ops))
+			break
+		}
+
+		b.WriteString("@@ -" + hunkRange(oldLine[start], oldLine[end]-oldLine[start]) +
+			" +" + hunkRange(newLine[start], newLine[end]-newLine[start]) + " @@\n")
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the start and length of a hunk's lines. The start is
+// 1-based, or the line before the hunk when it is empty.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return strconv.Itoa(before) + ",0"
+	case 1:
+		return strconv.Itoa(before + 1)
+	default:
+		return strconv.Itoa(before+1) + "," + strconv.Itoa(count)
+	}
+}
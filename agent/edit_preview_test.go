@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreviewEdit(t *testing.T) {
+	dir := filepath.Join("testdata", "edit_preview")
+	tests := []struct {
+		name  string
+		tool  string
+		input map[string]any
+		want  EditPreview // Path and Diff are checked separately
+	}{
+		{
+			name:  "edit first occurrence",
+			tool:  ToolEdit,
+			input: map[string]any{"file_path": "main.go.txt", "old_string": `"hello"`, "new_string": `"hi"`},
+			want: EditPreview{
+				Added: 1, Removed: 1, Ambiguous: true,
+			},
+		},
+		{
+			name:  "edit replace all",
+			tool:  ToolEdit,
+			input: map[string]any{"file_path": "main.go.txt", "old_string": `"hello"`, "new_string": `"hi"`, "replace_all": true},
+			want: EditPreview{
+				Added: 2, Removed: 2,
+			},
+		},
+		{
+			name:  "old string not found",
+			tool:  ToolEdit,
+			input: map[string]any{"file_path": "main.go.txt", "old_string": "goodbye", "new_string": "x"},
+			want:  EditPreview{NotFound: true},
+		},
+		{
+			name: "multi edit hunks applied in order",
+			tool: ToolMultiEdit,
+			input: map[string]any{"file_path": "main.go.txt", "edits": []any{
+				map[string]any{"old_string": "package main", "new_string": "package app"},
+				map[string]any{"old_string": "return 1", "new_string": "return 2"},
+				// Sees the result of the edit before it
+				map[string]any{"old_string": "return 2", "new_string": "return 3"},
+			}},
+			want: EditPreview{
+				Added: 2, Removed: 2,
+			},
+		},
+		{
+			name: "multi edit with a missing hunk",
+			tool: ToolMultiEdit,
+			input: map[string]any{"file_path": "main.go.txt", "edits": []any{
+				map[string]any{"old_string": "missing", "new_string": "x"},
+				map[string]any{"old_string": "return 1", "new_string": "return 2"},
+			}},
+			want: EditPreview{
+				Added: 1, Removed: 1, NotFound: true,
+			},
+		},
+		{
+			name:  "write a new file",
+			tool:  ToolWrite,
+			input: map[string]any{"file_path": "new.txt", "content": "one\ntwo\n"},
+			want: EditPreview{
+				Added: 2, Created: true,
+			},
+		},
+		{
+			name:  "edit creates a file",
+			tool:  ToolEdit,
+			input: map[string]any{"file_path": "new.txt", "old_string": "", "new_string": "one\n"},
+			want: EditPreview{
+				Added:   1,
+				Created: true,
+			},
+		},
+		{
+			name:  "write over a file",
+			tool:  ToolWrite,
+			input: map[string]any{"file_path": "noeol.txt", "content": "alpha\nbeta\n"},
+			want: EditPreview{
+				Added: 1, Removed: 1,
+			},
+		},
+		{
+			name:  "write the same content",
+			tool:  ToolWrite,
+			input: map[string]any{"file_path": "noeol.txt", "content": "alpha\nbeta"},
+			want:  EditPreview{},
+		},
+		{
+			name:  "CRLF file",
+			tool:  ToolEdit,
+			input: map[string]any{"file_path": "windows.crlf", "old_string": "second line\r\nthird", "new_string": "2nd line\nthird"},
+			want: EditPreview{
+				Added: 1, Removed: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PreviewEdit(&ToolCall{Name: tt.tool, Input: tt.input}, dir)
+			if err != nil {
+				t.Fatalf("PreviewEdit() error = %v", err)
+			}
+			wantPath, _ := filepath.Abs(filepath.Join(dir, tt.input["file_path"].(string)))
+			if got.Path != wantPath {
+				t.Errorf("Path = %q, want %q", got.Path, wantPath)
+			}
+
+			// The diff matches testdata/edit_preview/<name>.diff, or is empty
+			golden := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_")+".diff")
+			if *updateGolden && got.Diff != "" {
+				if err := os.WriteFile(golden, []byte(got.Diff), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want := ""
+			if data, err := os.ReadFile(golden); err == nil {
+				want = string(data)
+			}
+			if got.Diff != want {
+				t.Errorf("Diff =\n%s\nwant\n%s", got.Diff, want)
+			}
+
+			got.Path, got.Diff = "", ""
+			if *got != tt.want {
+				t.Errorf("PreviewEdit() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviewEditUsesAgentWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	mustWriteFile(t, filepath.Join(dir, "a.txt"), []byte("x\n"), 0600)
+	tc := &ToolCall{Name: ToolEdit, Input: map[string]any{"file_path": "a.txt", "old_string": "x", "new_string": "y"}, workDir: dir}
+	got, err := PreviewEdit(tc, "")
+	if err != nil {
+		t.Fatalf("PreviewEdit() error = %v", err)
+	}
+	if got.Created || got.Added != 1 || got.Removed != 1 {
+		t.Errorf("PreviewEdit() = %+v, want the file in the agent's WorkDir", got)
+	}
+}
+
+func TestPreviewEditErrors(t *testing.T) {
+	dir := t.TempDir()
+	big := filepath.Join(dir, "big.txt")
+	f, err := os.Create(big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(MaxPreviewFileBytes + 1); err != nil {
+		t.Fatal(err)
+	}
+	mustClose(t, f)
+
+	tests := []struct {
+		name string
+		tc   *ToolCall
+		want string
+	}{
+		{"other tool", &ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "a"}}, "cannot preview Read calls"},
+		{"no path", &ToolCall{Name: ToolWrite, Input: map[string]any{"content": "x"}}, "has no file_path"},
+		{"directory", &ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": dir}}, "not a regular file"},
+		{"too large", &ToolCall{Name: ToolEdit, Input: map[string]any{"file_path": big}}, "exceeds the"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := PreviewEdit(tt.tc, dir); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("PreviewEdit() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		ops := diffLines(a, b)
+
+		// The script turns a into b
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("diffLines(%q, %q) = %v does not reproduce the inputs", a, b, ops)
+		}
+
+		// And is as short as the longest common subsequence allows
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("diffLines(%q, %q) has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
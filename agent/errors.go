@@ -1,18 +1,36 @@
 package agent
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrAgentClosed is reported by a Stream or Run cut short by Close, and by
+// streams started after Close.
+var ErrAgentClosed = errors.New("agent: closed")
+
+// ErrAgentEvicted is matched by the *EvictedError reported by runs started
+// after IdleTimeout closed the agent.
+var ErrAgentEvicted = errors.New("agent: evicted after idle timeout")
 
 // StartError indicates the agent failed to start.
+// Its message is scrubbed of secrets, such as a key on a failed command
+// line; see Scrub.
 type StartError struct {
 	Reason string
 	Cause  error
+
+	scrub *scrubber // Set by the agent that failed to start
 }
 
 func (e *StartError) Error() string {
 	if e.Cause != nil {
-		return fmt.Sprintf("agent: start failed: %s: %v", e.Reason, e.Cause)
+		return e.scrub.scrub(fmt.Sprintf("agent: start failed: %s: %v", e.Reason, e.Cause))
 	}
-	return fmt.Sprintf("agent: start failed: %s", e.Reason)
+	return e.scrub.scrub(fmt.Sprintf("agent: start failed: %s", e.Reason))
 }
 
 func (e *StartError) Unwrap() error {
@@ -20,13 +38,16 @@ func (e *StartError) Unwrap() error {
 }
 
 // ProcessError indicates the Claude Code process exited with an error.
+// Stderr is scrubbed of secrets by the agent that reports it; see Scrub.
 type ProcessError struct {
 	ExitCode int
 	Stderr   string
+
+	scrub *scrubber // Set by the agent that reports the error
 }
 
 func (e *ProcessError) Error() string {
-	return fmt.Sprintf("agent: process exited with code %d: %s", e.ExitCode, e.Stderr)
+	return e.scrub.scrub(fmt.Sprintf("agent: process exited with code %d: %s", e.ExitCode, e.Stderr))
 }
 
 // MaxTurnsError indicates the agent exceeded the maximum number of turns.
@@ -40,17 +61,26 @@ func (e *MaxTurnsError) Error() string {
 	return fmt.Sprintf("agent: max turns exceeded: %d/%d (session: %s)", e.Turns, e.MaxAllowed, e.SessionID)
 }
 
-// HookInterruptError indicates a hook blocked execution.
+// HookInterruptError indicates a hook blocked execution. Run returns it,
+// with the Result, when a PreToolUse hook denied a tool call and the run's
+// Result is an error, as when Claude gives up on the task. Tool and Reason
+// are those of the run's last denial. Hook names the kind of hook, such as
+// "PreToolUse". Cause is the *TaskError of the error Result.
 type HookInterruptError struct {
 	Hook   string
 	Tool   string
 	Reason string
+	Cause  error
 }
 
 func (e *HookInterruptError) Error() string {
 	return fmt.Sprintf("agent: hook %s blocked tool %s: %s", e.Hook, e.Tool, e.Reason)
 }
 
+func (e *HookInterruptError) Unwrap() error {
+	return e.Cause
+}
+
 // TaskError indicates a task-level error.
 type TaskError struct {
 	SessionID string
@@ -61,20 +91,386 @@ func (e *TaskError) Error() string {
 	return fmt.Sprintf("agent: task error (session: %s): %s", e.SessionID, e.Message)
 }
 
+// CancelledError reports a run that ended before its result because it was
+// cancelled, its deadline passed, or the agent was closed. It wraps Err,
+// which is context.Canceled, context.DeadlineExceeded, or ErrAgentClosed,
+// and the cause the context was cancelled with, if any, so errors.Is
+// matches either.
+type CancelledError struct {
+	Reason    StopReason // StopCancelled, StopTimeout, or StopShutdown
+	Cause     string     // Why, from Agent.Cancel or context.Cause; empty if none was given
+	SessionID string
+	Err       error
+
+	cause error // The context's cause, when it differs from Err
+}
+
+func (e *CancelledError) Error() string {
+	var msg string
+	switch e.Reason {
+	case StopTimeout:
+		msg = "agent: run timed out"
+	case StopShutdown:
+		msg = "agent: run ended by Close"
+	default:
+		msg = "agent: run cancelled"
+	}
+	if e.Cause != "" {
+		msg += ": " + e.Cause
+	}
+	return msg
+}
+
+// Unwrap returns Err and the context's cause.
+func (e *CancelledError) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.cause}
+}
+
+// EvictedError reports a run started on an agent that IdleTimeout closed.
+// SessionID is the session of the evicted agent, for a replacement created
+// with Resume. It matches both ErrAgentEvicted and ErrAgentClosed.
+type EvictedError struct {
+	SessionID string
+	Idle      time.Duration // The IdleTimeout that passed
+}
+
+func (e *EvictedError) Error() string {
+	return fmt.Sprintf("agent: evicted after idle for %s (session: %s)", e.Idle, e.SessionID)
+}
+
+// Unwrap returns ErrAgentEvicted and ErrAgentClosed.
+func (e *EvictedError) Unwrap() []error {
+	return []error{ErrAgentEvicted, ErrAgentClosed}
+}
+
+// PipelineError indicates that a Pipeline stage failed. Stage is the
+// stage's 1-based position and Name the name given to AddStage.
+type PipelineError struct {
+	PipelineID string
+	Stage      int
+	Name       string
+	Err        error
+}
+
+func (e *PipelineError) Error() string {
+	stage := strconv.Itoa(e.Stage)
+	if e.Name != "" {
+		stage += " (" + e.Name + ")"
+	}
+	return fmt.Sprintf("agent: pipeline stage %s failed: %v", stage, e.Err)
+}
+
+func (e *PipelineError) Unwrap() error {
+	return e.Err
+}
+
+// AuthError indicates the CLI is not logged in or its credentials were
+// rejected. Retrying will not help until someone signs in again.
+type AuthError struct {
+	Message string // The CLI's error text
+	Cause   error  // The *TaskError or *ProcessError the text came from
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("agent: authentication failed: %s", e.Message)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Cause
+}
+
+// UnsupportedFeatureError indicates the installed CLI rejected a flag that
+// an option needs, usually because the CLI predates the feature. Option
+// names the option, MinVersion the first CLI version known to accept the
+// flag, and Fallback an option that avoids the flag; each may be empty.
+type UnsupportedFeatureError struct {
+	Flag       string // e.g. "--json-schema"
+	Option     string // e.g. "WithSchema"
+	MinVersion string
+	Fallback   string
+	Message    string // The CLI's error text
+	Cause      error  // The *TaskError or *ProcessError the text came from
+}
+
+func (e *UnsupportedFeatureError) Error() string {
+	msg := fmt.Sprintf("agent: the installed CLI does not support %s", e.Flag)
+	if e.Option != "" {
+		msg += fmt.Sprintf(", needed by %s", e.Option)
+	}
+	if e.MinVersion != "" {
+		msg += fmt.Sprintf("; upgrade to CLI %s or later", e.MinVersion)
+	}
+	if e.Fallback != "" {
+		msg += fmt.Sprintf(" or use %s", e.Fallback)
+	}
+	return msg
+}
+
+func (e *UnsupportedFeatureError) Unwrap() error {
+	return e.Cause
+}
+
+// QuotaError indicates the account reached a usage limit. ResetsAt is when
+// the limit resets, or zero if the CLI did not say.
+type QuotaError struct {
+	Message  string
+	ResetsAt time.Time
+	Cause    error
+}
+
+func (e *QuotaError) Error() string {
+	if !e.ResetsAt.IsZero() {
+		return fmt.Sprintf("agent: usage limit reached (resets %s): %s", e.ResetsAt.UTC().Format(time.RFC3339), e.Message)
+	}
+	return fmt.Sprintf("agent: usage limit reached: %s", e.Message)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return e.Cause
+}
+
+// OverloadedError indicates the API was temporarily overloaded. The request
+// can be retried after a backoff.
+type OverloadedError struct {
+	Message string
+	Cause   error
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("agent: API overloaded: %s", e.Message)
+}
+
+func (e *OverloadedError) Unwrap() error {
+	return e.Cause
+}
+
+// BudgetError indicates a run was stopped because its estimated cost
+// passed the EstimatedBudget set with CostEstimator.
+type BudgetError struct {
+	EstimatedUSD float64
+	BudgetUSD    float64
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("agent: estimated cost $%.4f exceeded budget $%.4f", e.EstimatedUSD, e.BudgetUSD)
+}
+
+// PolicyThrashError indicates a run was stopped because PreToolUse hooks
+// denied more than MaxDenials of its tool calls. Denials lists them by tool
+// and reason.
+type PolicyThrashError struct {
+	Denials    []DenialRecord
+	MaxDenials int
+}
+
+func (e *PolicyThrashError) Error() string {
+	total := 0
+	for _, d := range e.Denials {
+		total += d.Count
+	}
+	return fmt.Sprintf("agent: %d tool calls denied in one run, exceeding the limit of %d", total, e.MaxDenials)
+}
+
+// EmptyResultError indicates a run's Result had no text while
+// RequireNonEmptyResult was set. Run returns it with the Result.
+type EmptyResultError struct {
+	SessionID string
+	Text      string  // The ResultText: empty or whitespace
+	CostUSD   float64 // The Result's cost
+}
+
+func (e *EmptyResultError) Error() string {
+	return fmt.Sprintf("agent: run returned an empty result (session: %s)", e.SessionID)
+}
+
+// RefusalError indicates the RefusalDetector matched a run's ResultText,
+// a refusal rather than an answer. Run returns it with the Result.
+type RefusalError struct {
+	SessionID string
+	Text      string  // The ResultText
+	CostUSD   float64 // The Result's cost
+}
+
+func (e *RefusalError) Error() string {
+	return fmt.Sprintf("agent: run result is a refusal (session: %s): %q", e.SessionID, truncateText(e.Text, 200))
+}
+
+// TreeCycleError indicates BuildTree found messages whose ParentUUID
+// linkage loops, so they have no path to the root. UUID is a message in
+// the loop.
+type TreeCycleError struct {
+	UUID string
+}
+
+func (e *TreeCycleError) Error() string {
+	return fmt.Sprintf("agent: conversation tree has a cycle through message %s", e.UUID)
+}
+
+// SinkPanicError indicates the sink given to Go panicked. The run was
+// cancelled, and Value is what the sink panicked with.
+type SinkPanicError struct {
+	SessionID string
+	Value     any
+}
+
+func (e *SinkPanicError) Error() string {
+	return fmt.Sprintf("agent: sink panicked: %v", e.Value)
+}
+
+// PromptTooLargeError indicates a prompt was longer than MaxPromptBytes.
+// Nothing is sent to the CLI. Size is the prompt's length, or for a reader
+// of unknown length, the bytes read before the limit was passed.
+type PromptTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (e *PromptTooLargeError) Error() string {
+	return fmt.Sprintf("agent: prompt of %d bytes exceeds the limit of %d", e.Size, e.Max)
+}
+
+// ChecksumMismatchError indicates the claude CLI binary's SHA-256 digest
+// is not one of those pinned with CLIChecksum or CLIChecksumFile. The CLI
+// is not started.
+type ChecksumMismatchError struct {
+	Path     string   // The binary that was hashed
+	Expected []string // The pinned digests
+	Actual   string   // The binary's digest
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("agent: claude CLI %s has SHA-256 %s, not a pinned digest (%s)", e.Path, e.Actual, strings.Join(e.Expected, ", "))
+}
+
+// PromptCompressionError indicates a prompt over its PromptBudget could not
+// be made to fit: the compressor failed, or its result was still over the
+// budget. The run ends before anything is sent to the CLI.
+type PromptCompressionError struct {
+	Tokens     int // Estimated tokens of the prompt
+	Compressed int // Estimated tokens after compression; Tokens if it did not run
+	Budget     int
+	Err        error // The compressor's error, if it failed
+}
+
+func (e *PromptCompressionError) Error() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("agent: compressing prompt of %d tokens to %d: %v", e.Tokens, e.Budget, e.Err)
+	case e.Compressed != e.Tokens:
+		return fmt.Sprintf("agent: prompt of %d tokens compressed to %d, over the budget of %d", e.Tokens, e.Compressed, e.Budget)
+	default:
+		return fmt.Sprintf("agent: prompt of %d tokens exceeds the budget of %d", e.Tokens, e.Budget)
+	}
+}
+
+func (e *PromptCompressionError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigError indicates an option was given a value New cannot use, such
+// as an unknown model name. Suggestions lists close valid values, if any.
+type ConfigError struct {
+	Option      string // Option name, e.g. "Model"
+	Value       string
+	Reason      string
+	Suggestions []string
+}
+
+func (e *ConfigError) Error() string {
+	msg := fmt.Sprintf("agent: invalid %s %q: %s", e.Option, e.Value, e.Reason)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(" (did you mean %s?)", quotedList(e.Suggestions))
+	}
+	return msg
+}
+
+// quotedList formats values as a quoted, comma-separated list ending in "or".
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = strconv.Quote(v)
+	}
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
+
+// LabelError indicates an invalid label key passed to Labels or SetLabel.
+type LabelError struct {
+	Key    string
+	Reason string
+}
+
+func (e *LabelError) Error() string {
+	return fmt.Sprintf("agent: invalid label key %q: %s", e.Key, e.Reason)
+}
+
+// ControlError indicates a control request sent with SendControl failed:
+// the CLI answered with an error, or no response arrived.
+type ControlError struct {
+	RequestID string
+	Subtype   string
+	Message   string
+	Cause     error
+}
+
+func (e *ControlError) Error() string {
+	msg := fmt.Sprintf("agent: control request %s failed: %s", e.Subtype, e.Message)
+	if e.RequestID != "" {
+		msg = fmt.Sprintf("agent: control request %s (%s) failed: %s", e.Subtype, e.RequestID, e.Message)
+	}
+	if e.Cause != nil {
+		msg += fmt.Sprintf(": %v", e.Cause)
+	}
+	return msg
+}
+
+func (e *ControlError) Unwrap() error {
+	return e.Cause
+}
+
 // SchemaError indicates a JSON Schema generation or unmarshaling error.
 type SchemaError struct {
-	Type   string // Go type name
-	Reason string
-	Cause  error
+	Type    string // Go type name
+	Path    string // Offending field path, e.g. "Recipe.Ingredients[].Amount"
+	Reason  string
+	RawText string // Leading portion of the response text (unmarshal failures only); not in Error()
+	Cause   error
 }
 
 func (e *SchemaError) Error() string {
+	where := e.Type
+	if e.Path != "" {
+		where += " at " + e.Path
+	}
+	msg := fmt.Sprintf("agent: schema error for type %s: %s", where, e.Reason)
 	if e.Cause != nil {
-		return fmt.Sprintf("agent: schema error for type %s: %s: %v", e.Type, e.Reason, e.Cause)
+		msg += fmt.Sprintf(": %v", e.Cause)
 	}
-	return fmt.Sprintf("agent: schema error for type %s: %s", e.Type, e.Reason)
+	return msg
 }
 
 func (e *SchemaError) Unwrap() error {
 	return e.Cause
 }
+
+// SchemaValidationError indicates that a structured response was valid JSON
+// but did not match the schema. It is returned by RunWithSchema when
+// StrictSchema is enabled.
+type SchemaValidationError struct {
+	Type       string // Go type name, or "schema" for raw schemas
+	Violations []SchemaViolation
+	RawText    string // Leading portion of the response text
+}
+
+func (e *SchemaValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.Path + ": " + v.Reason
+	}
+	return fmt.Sprintf("agent: response does not match schema for type %s: %s", e.Type, strings.Join(parts, "; "))
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errorRule maps CLI error text matching pattern to a typed error.
+type errorRule struct {
+	pattern *regexp.Regexp
+	build   func(text string, cause error) error
+}
+
+// errorRules classifies CLI failures, checked in order. To recognize a new
+// message, add its pattern to the matching group.
+var errorRules = []errorRule{
+	{
+		pattern: unknownFlagPattern,
+		build:   unsupportedFeatureError,
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)invalid api key|please run /login|not logged in|` +
+			`authentication[_ ](error|required|failed)|oauth token (has expired|revoked)|` +
+			`invalid bearer token|api error: 401\b`),
+		build: func(text string, cause error) error {
+			return &AuthError{Message: text, Cause: cause}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)usage limit reached|(hour|weekly|daily) limit reached|` +
+			`credit balance is too low|quota exceeded`),
+		build: func(text string, cause error) error {
+			return &QuotaError{Message: text, ResetsAt: parseResetTime(text), Cause: cause}
+		},
+	},
+	{
+		pattern: regexp.MustCompile(`(?i)overloaded|api error: 529\b`),
+		build: func(text string, cause error) error {
+			return &OverloadedError{Message: text, Cause: cause}
+		},
+	},
+}
+
+// Reset times appear as a Unix timestamp after a pipe
+// ("Claude AI usage limit reached|1767225600") or as an RFC 3339 time.
+var (
+	resetUnixPattern    = regexp.MustCompile(`\|(\d{9,11})\b`)
+	resetRFC3339Pattern = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+)
+
+// classifyError returns a typed error for CLI error text matching a known
+// failure, wrapping cause, or nil if the text is not recognized.
+func classifyError(text string, cause error) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	for _, rule := range errorRules {
+		if rule.pattern.MatchString(text) {
+			return rule.build(text, cause)
+		}
+	}
+	return nil
+}
+
+// exitWait bounds how long Run waits for the process to exit after its
+// output ends without a result.
+const exitWait = time.Second
+
+// classifyExit returns a typed error if the process exited with a
+// recognized failure on stderr, such as a missing login.
+func (a *Agent) classifyExit() error {
+	proc, _, _ := a.transport()
+	code, stderr, exited := proc.exitStatus(exitWait)
+	if !exited || code == 0 {
+		return nil
+	}
+	// Typed errors quote stderr, so scrub it before classifying
+	stderr = a.scrub.scrub(stderr)
+	return classifyError(stderr, &ProcessError{ExitCode: code, Stderr: stderr, scrub: a.scrub})
+}
+
+// parseResetTime extracts a quota reset time from error text, or returns
+// the zero time.
+func parseResetTime(text string) time.Time {
+	if m := resetUnixPattern.FindStringSubmatch(text); m != nil {
+		if secs, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			return time.Unix(secs, 0).UTC()
+		}
+	}
+	if m := resetRFC3339Pattern.FindString(text); m != "" {
+		if t, err := time.Parse(time.RFC3339, m); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
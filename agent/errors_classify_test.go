@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	// Error text as reported by the CLI, with account details removed
+	tests := []struct {
+		name     string
+		text     string
+		want     string // "auth", "quota", "overloaded", or "" for unrecognized
+		resetsAt time.Time
+	}{
+		{"invalid api key", "Invalid API key · Please run /login", "auth", time.Time{}},
+		{"not logged in", "Not logged in · Please run /login", "auth", time.Time{}},
+		{"expired oauth", `API Error: 401 {"type":"error","error":{"type":"authentication_error","message":"OAuth token has expired."}}`, "auth", time.Time{}},
+		{"bearer token", "Failed to authenticate. API Error: 401 Invalid bearer token", "auth", time.Time{}},
+		{"usage limit with reset", "Claude AI usage limit reached|1767225600", "quota", time.Unix(1767225600, 0).UTC()},
+		{"five hour limit", "5-hour limit reached ∙ resets 3pm", "quota", time.Time{}},
+		{"rfc3339 reset", "Usage limit reached. Your limit resets at 2026-01-01T05:00:00Z.", "quota", time.Date(2026, 1, 1, 5, 0, 0, 0, time.UTC)},
+		{"credit balance", "Credit balance is too low", "quota", time.Time{}},
+		{"overloaded json", `API Error: 529 {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, "overloaded", time.Time{}},
+		{"overloaded plain", "API Error: Repeated 529 Overloaded errors", "overloaded", time.Time{}},
+		{"unrelated", "Tool execution failed: file not found", "", time.Time{}},
+		{"empty", "  ", "", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cause := &TaskError{Message: tt.text}
+			err := classifyError(tt.text, cause)
+
+			var got string
+			var authErr *AuthError
+			var quotaErr *QuotaError
+			var overloadedErr *OverloadedError
+			switch {
+			case errors.As(err, &authErr):
+				got = "auth"
+			case errors.As(err, &quotaErr):
+				got = "quota"
+				if !quotaErr.ResetsAt.Equal(tt.resetsAt) {
+					t.Errorf("ResetsAt = %v, want %v", quotaErr.ResetsAt, tt.resetsAt)
+				}
+			case errors.As(err, &overloadedErr):
+				got = "overloaded"
+			}
+			if got != tt.want {
+				t.Fatalf("classifyError(%q) = %v, want %s", tt.text, err, tt.want)
+			}
+			if err != nil && !errors.Is(err, cause) {
+				t.Errorf("classified error does not wrap its cause")
+			}
+		})
+	}
+}
+
+func TestRunReturnsAuthErrorFromResult(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"auth-test"}'
+printf '%s\n' '{"type":"result","subtype":"success","is_error":true,"result":"Invalid API key · Please run /login","num_turns":1}'
+exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "hello")
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Run() error = %v, want *AuthError", err)
+	}
+	if authErr.Message != "Invalid API key · Please run /login" {
+		t.Errorf("Message = %q", authErr.Message)
+	}
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.SessionID != "auth-test" {
+		t.Errorf("AuthError cause = %v, want *TaskError for session auth-test", authErr.Cause)
+	}
+	if result == nil || !result.IsError {
+		t.Errorf("Run() result = %+v, want the error result", result)
+	}
+}
+
+func TestRunReturnsQuotaErrorFromStderr(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+echo 'Claude AI usage limit reached|1767225600' >&2
+exit 1
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }() // Close reports the nonzero exit
+
+	_, err = a.Run(ctx, "hello")
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) {
+		t.Fatalf("Run() error = %v, want *QuotaError", err)
+	}
+	if want := time.Unix(1767225600, 0).UTC(); !quotaErr.ResetsAt.Equal(want) {
+		t.Errorf("ResetsAt = %v, want %v", quotaErr.ResetsAt, want)
+	}
+	var procErr *ProcessError
+	if !errors.As(err, &procErr) || procErr.ExitCode != 1 {
+		t.Errorf("QuotaError cause = %v, want *ProcessError with exit code 1", quotaErr.Cause)
+	}
+}
+
+func TestRunUnrecognizedExitKeepsTaskError(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+echo 'segmentation fault' >&2
+exit 2
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	_, err = a.Run(ctx, "hello")
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) {
+		t.Fatalf("Run() error = %v, want *TaskError", err)
+	}
+}
@@ -0,0 +1,395 @@
+// Package eval compares how agent configurations answer the same prompt,
+// for A/B tests of models, system prompts, or tool sets.
+//
+// Compare runs the prompt once per Variant, or Repetitions times, each run
+// on a fresh agent, and reports the text, cost, latency, token usage, and
+// stop reason of every run along with per-variant aggregates. A variant
+// that fails does not stop the others; its failures are recorded in the
+// Report. The Report marshals to JSON for storage or dashboards.
+//
+// Every run's audit events carry the labels eval_id, eval_variant, and
+// eval_repetition, so evaluation runs can be told apart from production
+// traffic.
+//
+// Example:
+//
+//	report, err := eval.Compare(ctx, "Summarize README.md in one sentence",
+//	    []eval.Variant{
+//	        {Name: "sonnet", Opts: []agent.Option{agent.Model("sonnet")}},
+//	        {Name: "haiku", Opts: []agent.Option{agent.Model("haiku")}},
+//	    },
+//	    eval.Repetitions(5),
+//	    eval.Concurrency(4),
+//	)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, v := range report.Variants {
+//	    fmt.Printf("%s: $%.4f mean, %v mean\n", v.Name, v.Cost.Mean, v.Latency.Mean)
+//	}
+package eval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// Variant is one agent configuration under comparison.
+type Variant struct {
+	Name string
+	Opts []agent.Option
+
+	// Schema, if set, is an example value of a structured response type,
+	// as given to agent.WithSchema. The variant's responses are decoded
+	// into Run.Output so they can be compared field by field.
+	Schema any
+}
+
+// config holds the comparison settings.
+type config struct {
+	repetitions int
+	concurrency int
+	runOpts     []agent.RunOption
+}
+
+// Option configures Compare.
+type Option func(*config)
+
+// Repetitions runs the prompt n times per variant (default 1). Values
+// below 1 are ignored.
+func Repetitions(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.repetitions = n
+		}
+	}
+}
+
+// Concurrency runs up to n runs at once across all variants (default 1,
+// one run at a time). Values below 1 are ignored.
+func Concurrency(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
+}
+
+// RunOptions applies per-run options, such as agent.Timeout, to every run.
+func RunOptions(opts ...agent.RunOption) Option {
+	return func(c *config) {
+		c.runOpts = append(c.runOpts, opts...)
+	}
+}
+
+// Report is the outcome of Compare.
+type Report struct {
+	ID       string // The eval_id label on the runs' audit events
+	Prompt   string
+	Variants []VariantReport // In the order given to Compare
+
+	// Fields lists the structured output fields whose values differ
+	// between variants. Each variant contributes its first successful
+	// run. It is empty unless variants set a Schema.
+	Fields []FieldDiff
+}
+
+// VariantReport holds one variant's runs and their aggregates. Aggregates
+// cover successful runs only.
+type VariantReport struct {
+	Name      string
+	Runs      []Run // In repetition order
+	Succeeded int
+	Failed    int
+	Cost      Stats         // USD per run
+	Latency   DurationStats // Wall-clock time per run
+	Usage     agent.Usage   // Total tokens across runs
+}
+
+// Run is the outcome of one run of a variant.
+type Run struct {
+	Repetition int // 1-based
+	Text       string
+	Output     any // Decoded structured response, if the variant has a Schema
+	CostUSD    float64
+	Duration   time.Duration
+	Usage      agent.Usage
+	NumTurns   int
+	StopReason agent.StopReason
+	Error      string // Err's message, for JSON
+
+	// Err is the error that failed the run, or nil.
+	Err error `json:"-"`
+}
+
+// Stats summarizes a quantity over a variant's successful runs.
+type Stats struct {
+	Mean float64
+	Min  float64
+	Max  float64
+}
+
+// DurationStats summarizes durations over a variant's successful runs.
+type DurationStats struct {
+	Mean time.Duration
+	Min  time.Duration
+	Max  time.Duration
+}
+
+// FieldDiff is a structured output field whose value differs between
+// variants.
+type FieldDiff struct {
+	Path   string         // Such as "items[0].name"
+	Values map[string]any // Variant name to value; absent where the field is missing
+}
+
+// Compare runs prompt against each variant and reports the results. It
+// returns an error only for invalid arguments: no variants, or a variant
+// name that is empty or repeated. Failed runs, including agents that fail
+// to start, are recorded in the Report.
+func Compare(ctx context.Context, prompt string, variants []Variant, opts ...Option) (*Report, error) {
+	cfg := &config{repetitions: 1, concurrency: 1}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if err := checkVariants(variants); err != nil {
+		return nil, err
+	}
+
+	report := &Report{ID: newID(), Prompt: prompt, Variants: make([]VariantReport, len(variants))}
+	for i, v := range variants {
+		report.Variants[i] = VariantReport{Name: v.Name, Runs: make([]Run, cfg.repetitions)}
+	}
+
+	// Each run writes only its own slot, so results need no lock
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for rep := 1; rep <= cfg.repetitions; rep++ {
+		for i, v := range variants {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i, rep int, v Variant) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				report.Variants[i].Runs[rep-1] = runOnce(ctx, report.ID, prompt, v, rep, cfg.runOpts)
+			}(i, rep, v)
+		}
+	}
+	wg.Wait()
+
+	for i := range report.Variants {
+		report.Variants[i].summarize()
+	}
+	report.Fields = diffFields(report.Variants)
+	return report, nil
+}
+
+// checkVariants reports a missing, empty, or repeated variant name.
+func checkVariants(variants []Variant) error {
+	if len(variants) == 0 {
+		return errors.New("eval: no variants to compare")
+	}
+	seen := make(map[string]bool, len(variants))
+	for i, v := range variants {
+		if v.Name == "" {
+			return fmt.Errorf("eval: variant %d has no name", i)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("eval: variant name %q is used more than once", v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return nil
+}
+
+// runOnce runs prompt on a fresh agent for the variant.
+func runOnce(ctx context.Context, id, prompt string, v Variant, rep int, runOpts []agent.RunOption) Run {
+	run := Run{Repetition: rep}
+
+	var stopReason agent.StopReason
+	opts := append([]agent.Option{}, v.Opts...)
+	if v.Schema != nil {
+		opts = append(opts, agent.WithSchema(v.Schema))
+	}
+	opts = append(opts,
+		agent.Labels(map[string]string{
+			"eval_id":         id,
+			"eval_variant":    v.Name,
+			"eval_repetition": strconv.Itoa(rep),
+		}),
+		agent.OnStop(func(e *agent.StopEvent) { stopReason = e.Reason }),
+	)
+
+	a, err := agent.New(ctx, opts...)
+	if err != nil {
+		run.StopReason = agent.StopError
+		run.fail(err)
+		return run
+	}
+
+	start := time.Now()
+	var result *agent.Result
+	if v.Schema != nil {
+		result, err = a.RunWithSchema(ctx, prompt, nil, runOpts...)
+	} else {
+		result, err = a.Run(ctx, prompt, runOpts...)
+	}
+	run.Duration = time.Since(start)
+	_ = a.Close() // Close calls the Stop hook; the run's outcome is already known
+	run.StopReason = stopReason
+
+	if result != nil {
+		run.Text = result.ResultText
+		run.CostUSD = result.CostUSD
+		run.Usage = result.Usage
+		run.NumTurns = result.NumTurns
+	}
+	if err != nil {
+		run.fail(err)
+		return run
+	}
+	if v.Schema != nil {
+		if err := json.Unmarshal([]byte(result.ResultText), &run.Output); err != nil {
+			run.fail(fmt.Errorf("eval: decode structured response: %w", err))
+		}
+	}
+	return run
+}
+
+// fail records the error that failed the run.
+func (r *Run) fail(err error) {
+	r.Err = err
+	r.Error = err.Error()
+}
+
+// summarize counts the variant's runs and aggregates the successful ones.
+func (v *VariantReport) summarize() {
+	v.Succeeded, v.Failed = 0, 0
+	v.Cost, v.Latency, v.Usage = Stats{}, DurationStats{}, agent.Usage{}
+	var totalCost float64
+	var totalDuration time.Duration
+	for _, run := range v.Runs {
+		v.Usage.InputTokens += run.Usage.InputTokens
+		v.Usage.OutputTokens += run.Usage.OutputTokens
+		v.Usage.CacheRead += run.Usage.CacheRead
+		v.Usage.CacheWrite += run.Usage.CacheWrite
+		if run.Err != nil {
+			v.Failed++
+			continue
+		}
+		if v.Succeeded == 0 || run.CostUSD < v.Cost.Min {
+			v.Cost.Min = run.CostUSD
+		}
+		if v.Succeeded == 0 || run.CostUSD > v.Cost.Max {
+			v.Cost.Max = run.CostUSD
+		}
+		if v.Succeeded == 0 || run.Duration < v.Latency.Min {
+			v.Latency.Min = run.Duration
+		}
+		if v.Succeeded == 0 || run.Duration > v.Latency.Max {
+			v.Latency.Max = run.Duration
+		}
+		totalCost += run.CostUSD
+		totalDuration += run.Duration
+		v.Succeeded++
+	}
+	if v.Succeeded > 0 {
+		v.Cost.Mean = totalCost / float64(v.Succeeded)
+		v.Latency.Mean = totalDuration / time.Duration(v.Succeeded)
+	}
+}
+
+// diffFields compares the structured output of each variant's first
+// successful run and returns the fields that differ, sorted by path.
+func diffFields(variants []VariantReport) []FieldDiff {
+	outputs := make(map[string]map[string]any)
+	for _, v := range variants {
+		for _, run := range v.Runs {
+			if run.Err == nil && run.Output != nil {
+				fields := make(map[string]any)
+				flatten("", run.Output, fields)
+				outputs[v.Name] = fields
+				break
+			}
+		}
+	}
+	if len(outputs) < 2 {
+		return nil
+	}
+
+	paths := make(map[string]bool)
+	for _, fields := range outputs {
+		for path := range fields {
+			paths[path] = true
+		}
+	}
+	var diffs []FieldDiff
+	for path := range paths {
+		values := make(map[string]any, len(outputs))
+		var first any
+		same, seen := true, false
+		for name, fields := range outputs {
+			value, ok := fields[path]
+			if !ok {
+				same = false
+				continue
+			}
+			values[name] = value
+			if !seen {
+				first, seen = value, true
+			} else if !reflect.DeepEqual(first, value) {
+				same = false
+			}
+		}
+		if !same {
+			diffs = append(diffs, FieldDiff{Path: path, Values: values})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+// flatten records the leaf values of a decoded JSON value by path. Empty
+// objects and arrays are leaves.
+func flatten(path string, value any, fields map[string]any) {
+	switch v := value.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			fields[path] = v
+		}
+		for key, elem := range v {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			flatten(p, elem, fields)
+		}
+	case []any:
+		if len(v) == 0 {
+			fields[path] = v
+		}
+		for i, elem := range v {
+			flatten(path+"["+strconv.Itoa(i)+"]", elem, fields)
+		}
+	default:
+		fields[path] = v
+	}
+}
+
+// newID returns a random identifier for a comparison.
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
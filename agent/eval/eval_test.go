@@ -0,0 +1,217 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// countingScript answers with a cost and token count that grow with each
+// invocation: $0.01 and 10 output tokens, then $0.02 and 20, and so on.
+const countingScript = `#!/bin/sh
+read line
+n=$(cat "$(dirname "$0")/count" 2>/dev/null || echo 0)
+n=$((n + 1))
+echo $n > "$(dirname "$0")/count"
+echo '{"type":"system","subtype":"init","session_id":"eval-counting"}'
+echo "{\"type\":\"result\",\"result\":\"answer $n\",\"num_turns\":1,\"total_cost_usd\":0.0$n,\"usage\":{\"input_tokens\":100,\"output_tokens\":$((n * 10))}}"
+`
+
+// slowScript answers after 100ms with a fixed cost.
+const slowScript = `#!/bin/sh
+read line
+sleep 0.1
+echo '{"type":"system","subtype":"init","session_id":"eval-slow"}'
+echo '{"type":"result","result":"slow answer","num_turns":2,"total_cost_usd":0.5,"usage":{"input_tokens":200,"output_tokens":50}}'
+`
+
+// fakeCLI writes script to its own directory and returns its path.
+//
+//nolint:gosec // G306: Test scripts need executable permissions
+func fakeCLI(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCompareAggregates(t *testing.T) {
+	variants := []Variant{
+		{Name: "counting", Opts: []agent.Option{agent.CLIPath(fakeCLI(t, countingScript))}},
+		{Name: "slow", Opts: []agent.Option{agent.CLIPath(fakeCLI(t, slowScript))}},
+	}
+
+	report, err := Compare(context.Background(), "What is 2+2?", variants, Repetitions(3))
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	if report.Prompt != "What is 2+2?" || report.ID == "" {
+		t.Errorf("Prompt, ID = %q, %q; want the prompt and an ID", report.Prompt, report.ID)
+	}
+	if len(report.Variants) != 2 {
+		t.Fatalf("got %d variant reports, want 2", len(report.Variants))
+	}
+
+	counting := report.Variants[0]
+	if counting.Name != "counting" || counting.Succeeded != 3 || counting.Failed != 0 {
+		t.Errorf("counting: Name %q, Succeeded %d, Failed %d; want counting, 3, 0", counting.Name, counting.Succeeded, counting.Failed)
+	}
+	if !approx(counting.Cost.Mean, 0.02) || !approx(counting.Cost.Min, 0.01) || !approx(counting.Cost.Max, 0.03) {
+		t.Errorf("counting Cost = %+v, want mean 0.02, min 0.01, max 0.03", counting.Cost)
+	}
+	if want := (agent.Usage{InputTokens: 300, OutputTokens: 60}); counting.Usage != want {
+		t.Errorf("counting Usage = %+v, want %+v", counting.Usage, want)
+	}
+	for i, run := range counting.Runs {
+		if run.Repetition != i+1 {
+			t.Errorf("Runs[%d].Repetition = %d, want %d", i, run.Repetition, i+1)
+		}
+		if !strings.HasPrefix(run.Text, "answer ") || run.StopReason != agent.StopCompleted || run.Err != nil {
+			t.Errorf("Runs[%d] = %+v, want a completed answer", i, run)
+		}
+	}
+
+	slow := report.Variants[1]
+	if !approx(slow.Cost.Mean, 0.5) || !approx(slow.Cost.Min, 0.5) || !approx(slow.Cost.Max, 0.5) {
+		t.Errorf("slow Cost = %+v, want 0.5 throughout", slow.Cost)
+	}
+	if slow.Latency.Min < 100*time.Millisecond {
+		t.Errorf("slow Latency.Min = %v, want at least the script's 100ms", slow.Latency.Min)
+	}
+	if slow.Latency.Min > slow.Latency.Mean || slow.Latency.Mean > slow.Latency.Max {
+		t.Errorf("slow Latency = %+v, want min <= mean <= max", slow.Latency)
+	}
+	if slow.Runs[0].NumTurns != 2 || slow.Runs[0].Usage.OutputTokens != 50 {
+		t.Errorf("slow Runs[0] = %+v, want 2 turns and 50 output tokens", slow.Runs[0])
+	}
+}
+
+func TestCompareFailedVariant(t *testing.T) {
+	variants := []Variant{
+		{Name: "broken", Opts: []agent.Option{agent.CLIPath(filepath.Join(t.TempDir(), "missing"))}},
+		{Name: "slow", Opts: []agent.Option{agent.CLIPath(fakeCLI(t, slowScript))}},
+	}
+
+	report, err := Compare(context.Background(), "test", variants, Repetitions(2), Concurrency(4))
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	broken := report.Variants[0]
+	if broken.Succeeded != 0 || broken.Failed != 2 {
+		t.Errorf("broken: Succeeded %d, Failed %d; want 0, 2", broken.Succeeded, broken.Failed)
+	}
+	if broken.Cost != (Stats{}) || broken.Latency != (DurationStats{}) {
+		t.Errorf("broken aggregates = %+v, %+v; want zero without successful runs", broken.Cost, broken.Latency)
+	}
+	for _, run := range broken.Runs {
+		if run.Err == nil || run.Error == "" || run.StopReason != agent.StopError {
+			t.Errorf("broken run = %+v, want a start error", run)
+		}
+	}
+
+	if slow := report.Variants[1]; slow.Succeeded != 2 {
+		t.Errorf("slow Succeeded = %d, want 2 despite the broken variant", slow.Succeeded)
+	}
+}
+
+type verdict struct {
+	Label string `json:"label"`
+	Score int    `json:"score"`
+	Tags  []string
+}
+
+func TestCompareStructuredFields(t *testing.T) {
+	script := func(result string) string {
+		return `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"eval-structured"}'
+echo '{"type":"result","result":"` + result + `","num_turns":1,"total_cost_usd":0.01}'
+`
+	}
+	variants := []Variant{
+		{
+			Name:   "strict",
+			Opts:   []agent.Option{agent.CLIPath(fakeCLI(t, script(`{\"label\":\"spam\",\"score\":9,\"Tags\":[\"ads\"]}`)))},
+			Schema: verdict{},
+		},
+		{
+			Name:   "lenient",
+			Opts:   []agent.Option{agent.CLIPath(fakeCLI(t, script(`{\"label\":\"spam\",\"score\":4,\"Tags\":[\"ads\",\"links\"]}`)))},
+			Schema: verdict{},
+		},
+	}
+
+	report, err := Compare(context.Background(), "Classify this email", variants)
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	want := []FieldDiff{
+		{Path: "Tags[1]", Values: map[string]any{"lenient": "links"}},
+		{Path: "score", Values: map[string]any{"strict": 9.0, "lenient": 4.0}},
+	}
+	if !reflect.DeepEqual(report.Fields, want) {
+		t.Errorf("Fields = %+v, want %+v", report.Fields, want)
+	}
+	if output, ok := report.Variants[0].Runs[0].Output.(map[string]any); !ok || output["label"] != "spam" {
+		t.Errorf("Output = %#v, want the decoded response", report.Variants[0].Runs[0].Output)
+	}
+}
+
+func TestCompareInvalidVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []Variant
+	}{
+		{"none", nil},
+		{"unnamed", []Variant{{Name: ""}}},
+		{"duplicate", []Variant{{Name: "a"}, {Name: "a"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Compare(context.Background(), "test", tt.variants); err == nil {
+				t.Error("Compare() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	report := &Report{
+		ID:     "abc",
+		Prompt: "test",
+		Variants: []VariantReport{{
+			Name:    "v",
+			Runs:    []Run{{Repetition: 1, Error: "boom", Err: os.ErrNotExist}},
+			Failed:  1,
+			Latency: DurationStats{Mean: time.Second},
+		}},
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got := decoded.Variants[0]; got.Runs[0].Error != "boom" || got.Latency.Mean != time.Second || got.Failed != 1 {
+		t.Errorf("round trip = %+v, want the report's values", got)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import "context"
+
+// Event is the set of event types that OnEvent handlers can receive. Each
+// embeds the value the matching hook option passes, so fields added there
+// later are also available to OnEvent handlers.
+type Event interface {
+	ToolCallEvent | ToolResultEvent | PromptEvent | StopEvent | CompactEvent | SubagentEvent
+}
+
+// ToolCallEvent is received before a tool is executed, like a PreToolUse
+// hook.
+type ToolCallEvent struct {
+	*ToolCall
+}
+
+// ToolResultEvent is received after a tool has executed, like a
+// PostToolUse hook.
+type ToolResultEvent struct {
+	*ToolCall
+	Result *ToolResultContext
+}
+
+// PromptEvent is received before a prompt is sent to Claude, like a
+// UserPromptSubmit hook.
+type PromptEvent struct {
+	*PromptSubmitEvent
+}
+
+// CompactEvent is received before context window compaction, like a
+// PreCompact hook.
+type CompactEvent struct {
+	*PreCompactEvent
+}
+
+// SubagentEvent is received when a subagent completes, like a
+// SubagentStop hook.
+type SubagentEvent struct {
+	*SubagentStopEvent
+}
+
+// Outcome is what an OnEvent handler returns. The zero Outcome changes
+// nothing and lets the remaining handlers and hooks run. Each field
+// applies only to the event types named in its comment and is ignored for
+// the others.
+type Outcome struct {
+	// Decision, Reason, and UpdatedInput apply to ToolCallEvent, with the
+	// meaning of the HookResult fields of the same names.
+	Decision     Decision
+	Reason       string
+	UpdatedInput map[string]any
+
+	// UpdatedPrompt and Metadata apply to PromptEvent, with the meaning of
+	// the PromptSubmitResult fields of the same names.
+	UpdatedPrompt string
+	Metadata      any
+
+	// Archive, ArchiveTo, and Extract apply to CompactEvent, with the
+	// meaning of the PreCompactResult fields of the same names.
+	Archive   bool
+	ArchiveTo string
+	Extract   any
+}
+
+// OnEvent registers fn for events of type T, as a single extension point
+// in place of the hook option for each kind of event. New event fields
+// reach fn through the embedded value, so the signature does not change as
+// the hooks grow.
+//
+// fn is added to the chain of the matching hook option (PreToolUse,
+// PostToolUse, UserPromptSubmit, OnStop, PreCompact, or SubagentStop) and
+// behaves as a hook registered there: handlers and hooks of both styles
+// run in the order their options were given, a ToolCallEvent handler with
+// PriorityDefault, and a ToolCallEvent Deny or Allow ends the chain as a
+// hook's would. ctx is the context of the Run or
+// Stream call for ToolCallEvent, ToolResultEvent, and PromptEvent, and
+// context.Background for the others, which can occur outside a run.
+//
+// Example:
+//
+//	agent.OnEvent(func(ctx context.Context, e agent.ToolCallEvent) agent.Outcome {
+//	    if e.Name == agent.ToolBash {
+//	        return agent.Outcome{Decision: agent.Deny, Reason: "no shell"}
+//	    }
+//	    return agent.Outcome{}
+//	})
+func OnEvent[T Event](fn func(ctx context.Context, event T) Outcome) Option {
+	return func(c *config) {
+		switch fn := any(fn).(type) {
+		case func(context.Context, ToolCallEvent) Outcome:
+			c.addPreToolUse(PriorityDefault, func(tc *ToolCall) HookResult {
+				o := fn(tc.Context(), ToolCallEvent{tc})
+				return HookResult{Decision: o.Decision, Reason: o.Reason, UpdatedInput: o.UpdatedInput}
+			}, nil)
+		case func(context.Context, ToolResultEvent) Outcome:
+			c.postToolUseHooks = append(c.postToolUseHooks, func(tc *ToolCall, tr *ToolResultContext) HookResult {
+				fn(tc.Context(), ToolResultEvent{ToolCall: tc, Result: tr})
+				return HookResult{Decision: Continue}
+			})
+		case func(context.Context, PromptEvent) Outcome:
+			c.userPromptSubmitHooks = append(c.userPromptSubmitHooks, func(e *PromptSubmitEvent) PromptSubmitResult {
+				o := fn(e.Context(), PromptEvent{e})
+				return PromptSubmitResult{UpdatedPrompt: o.UpdatedPrompt, Metadata: o.Metadata}
+			})
+		case func(context.Context, StopEvent) Outcome:
+			c.stopHooks = append(c.stopHooks, func(e *StopEvent) {
+				fn(context.Background(), *e)
+			})
+		case func(context.Context, CompactEvent) Outcome:
+			c.preCompactHooks = append(c.preCompactHooks, func(e *PreCompactEvent) PreCompactResult {
+				o := fn(context.Background(), CompactEvent{e})
+				return PreCompactResult{Archive: o.Archive, ArchiveTo: o.ArchiveTo, Extract: o.Extract}
+			})
+		case func(context.Context, SubagentEvent) Outcome:
+			c.subagentStopHooks = append(c.subagentStopHooks, func(e *SubagentStopEvent) {
+				fn(context.Background(), SubagentEvent{e})
+			})
+		}
+	}
+}
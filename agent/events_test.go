@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnEventToolCallChain(t *testing.T) {
+	var order []string
+	old := func(name string, result HookResult) Option {
+		return PreToolUse(func(tc *ToolCall) HookResult {
+			order = append(order, name)
+			return result
+		})
+	}
+	event := func(name string, outcome Outcome) Option {
+		return OnEvent(func(ctx context.Context, e ToolCallEvent) Outcome {
+			order = append(order, name+":"+e.Input["command"].(string))
+			return outcome
+		})
+	}
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		want      HookResult
+		wantOrder string
+	}{
+		{"accumulates", []Option{
+			old("old", HookResult{UpdatedInput: map[string]any{"command": "ls -a"}}),
+			event("new", Outcome{UpdatedInput: map[string]any{"timeout": 5}}),
+		}, HookResult{Decision: Allow, UpdatedInput: map[string]any{"command": "ls -a", "timeout": 5}}, "old,new:ls -a"},
+		{"new allow ends chain", []Option{
+			event("new", Outcome{Decision: Allow}),
+			old("old", HookResult{Decision: Deny, Reason: "never"}),
+		}, HookResult{Decision: Allow}, "new:ls"},
+		{"new deny ends chain", []Option{
+			old("old", HookResult{}),
+			event("new", Outcome{Decision: Deny, Reason: "no shell", UpdatedPrompt: "ignored"}),
+			old("after", HookResult{}),
+		}, HookResult{Decision: Deny, Reason: "no shell"}, "old,new:ls"},
+		{"old deny ends chain", []Option{
+			old("old", HookResult{Decision: Deny, Reason: "never"}),
+			event("new", Outcome{}),
+		}, HookResult{Decision: Deny, Reason: "never"}, "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order = nil
+			cfg := newConfig(tt.opts...)
+			got := newHookChain(cfg.preToolUseHooks).evaluate(&ToolCall{Name: ToolBash, Input: map[string]any{"command": "ls"}})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluate() = %+v, want %+v", got, tt.want)
+			}
+			if strings.Join(order, ",") != tt.wantOrder {
+				t.Errorf("order = %v, want %s", order, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestOnEventPromptChain(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "run")
+	cfg := newConfig(
+		UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+			return PromptSubmitResult{UpdatedPrompt: e.Prompt + " carefully", Metadata: "old"}
+		}),
+		OnEvent(func(ctx context.Context, e PromptEvent) Outcome {
+			return Outcome{
+				UpdatedPrompt: e.Prompt + " (" + ctx.Value(key{}).(string) + ")",
+				Metadata:      "new",
+				Decision:      Deny, // Not applicable; ignored
+			}
+		}),
+		OnEvent(func(ctx context.Context, e PromptEvent) Outcome {
+			return Outcome{} // Keeps the prompt
+		}),
+	)
+
+	prompt, metadata := newPromptSubmitChain(cfg.userPromptSubmitHooks).evaluate(&PromptSubmitEvent{Prompt: "fix it", ctx: ctx})
+	if prompt != "fix it carefully (run)" {
+		t.Errorf("prompt = %q, want both updates", prompt)
+	}
+	if !reflect.DeepEqual(metadata, []any{"old", "new"}) {
+		t.Errorf("metadata = %v, want [old new]", metadata)
+	}
+}
+
+func TestOnEventObservers(t *testing.T) {
+	var order []string
+	cfg := newConfig(
+		OnEvent(func(ctx context.Context, e CompactEvent) Outcome {
+			order = append(order, "compact:"+e.Trigger)
+			return Outcome{Archive: true, ArchiveTo: "/a", Extract: 1}
+		}),
+		PreCompact(func(e *PreCompactEvent) PreCompactResult {
+			order = append(order, "old compact")
+			return PreCompactResult{}
+		}),
+		OnStop(func(e *StopEvent) { order = append(order, "old stop") }),
+		OnEvent(func(ctx context.Context, e StopEvent) Outcome {
+			order = append(order, "stop:"+string(e.Reason))
+			return Outcome{}
+		}),
+		OnEvent(func(ctx context.Context, e SubagentEvent) Outcome {
+			order = append(order, "subagent:"+e.SubagentType)
+			return Outcome{}
+		}),
+		OnEvent(func(ctx context.Context, e ToolResultEvent) Outcome {
+			order = append(order, "result:"+e.Name+":"+e.Result.ToolUseID)
+			return Outcome{Decision: Deny} // Not applicable; ignored
+		}),
+	)
+
+	results := newPreCompactChain(cfg.preCompactHooks).evaluate(&PreCompactEvent{Trigger: "auto"})
+	if len(results) != 2 || !reflect.DeepEqual(results[0], PreCompactResult{Archive: true, ArchiveTo: "/a", Extract: 1}) {
+		t.Errorf("PreCompact results = %+v, want the Outcome's archive first", results)
+	}
+	for _, hook := range cfg.stopHooks {
+		hook(&StopEvent{Reason: StopCompleted})
+	}
+	newSubagentStopChain(cfg.subagentStopHooks).evaluate(&SubagentStopEvent{SubagentType: "Explore"})
+	newPostToolUseChain(cfg.postToolUseHooks).evaluate(&ToolCall{Name: ToolRead}, &ToolResultContext{ToolUseID: "tu-1"})
+
+	want := "compact:auto,old compact,old stop,stop:completed,subagent:Explore,result:Read:tu-1"
+	if got := strings.Join(order, ","); got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+}
+
+func TestOnEventRunContext(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"event-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	type key struct{}
+	var seen []any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		OnEvent(func(ctx context.Context, e PromptEvent) Outcome {
+			seen = append(seen, ctx.Value(key{}))
+			return Outcome{}
+		}),
+		OnEvent(func(ctx context.Context, e ToolResultEvent) Outcome {
+			seen = append(seen, ctx.Value(key{}))
+			return Outcome{}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(context.WithValue(ctx, key{}, "tenant"), "read it"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !reflect.DeepEqual(seen, []any{"tenant", "tenant"}) {
+		t.Errorf("handlers saw context values %v, want the Run context's", seen)
+	}
+}
@@ -14,7 +14,7 @@ import (
 // ExampleNew demonstrates creating a new agent.
 func ExampleNew() {
 	ctx := context.Background()
-	a, err := agent.New(ctx, agent.Model("claude-haiku-3-5"))
+	a, err := agent.New(ctx, agent.Model("claude-haiku-4-5"))
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -29,7 +29,7 @@ func ExampleNew() {
 // ExampleAgent_Run demonstrates running a prompt and getting a result.
 func ExampleAgent_Run() {
 	ctx := context.Background()
-	a, err := agent.New(ctx, agent.Model("claude-haiku-3-5"))
+	a, err := agent.New(ctx, agent.Model("claude-haiku-4-5"))
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -56,7 +56,7 @@ func ExampleAgent_Run() {
 // ExampleAgent_Stream demonstrates streaming messages from an agent.
 func ExampleAgent_Stream() {
 	ctx := context.Background()
-	a, err := agent.New(ctx, agent.Model("claude-haiku-3-5"))
+	a, err := agent.New(ctx, agent.Model("claude-haiku-4-5"))
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -96,7 +96,7 @@ func ExampleModel() {
 	ctx := context.Background()
 
 	// Create agent with a specific model (haiku for cost efficiency)
-	a, err := agent.New(ctx, agent.Model("claude-haiku-3-5"))
+	a, err := agent.New(ctx, agent.Model("claude-haiku-4-5"))
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -126,7 +126,7 @@ func ExamplePreToolUse() {
 
 	// Create agent with security hooks
 	a, err := agent.New(ctx,
-		agent.Model("claude-haiku-3-5"),
+		agent.Model("claude-haiku-4-5"),
 		agent.PreToolUse(
 			// Block dangerous commands
 			agent.DenyCommands("rm -rf", "sudo"),
@@ -171,7 +171,7 @@ func ExampleWithSchema() {
 
 	// Create agent with structured output schema
 	a, err := agent.New(ctx,
-		agent.Model("claude-haiku-3-5"),
+		agent.Model("claude-haiku-4-5"),
 		agent.WithSchema(MathAnswer{}),
 	)
 	if err != nil {
@@ -211,7 +211,7 @@ func ExampleRunStructured() {
 		ctx,
 		"What is the capital of Japan? Return the city name and country.",
 		&answer,
-		agent.Model("claude-haiku-3-5"),
+		agent.Model("claude-haiku-4-5"),
 	)
 	if err != nil {
 		fmt.Println("Error:", err)
@@ -231,7 +231,7 @@ func ExampleRunStructured() {
 // ExampleAgent_SessionID demonstrates getting the session ID.
 func ExampleAgent_SessionID() {
 	ctx := context.Background()
-	a, err := agent.New(ctx, agent.Model("claude-haiku-3-5"))
+	a, err := agent.New(ctx, agent.Model("claude-haiku-4-5"))
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
@@ -478,6 +478,7 @@ echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
 
 	// Verify expected event types
 	expectedEvents := []string{
+		"session.start_attempt",
 		"session.start",
 		"message.prompt",
 		"session.init",
@@ -527,8 +528,8 @@ echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
 	// Read and verify the audit file
 	data := mustReadFile(t, auditPath)
 	lines := bytes.Count(data, []byte("\n"))
-	if lines != 5 {
-		t.Errorf("audit event count = %d, want 5", lines)
+	if lines != 6 {
+		t.Errorf("audit event count = %d, want 6", lines)
 	}
 }
 
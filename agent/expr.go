@@ -0,0 +1,781 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The policy expression language used by DenyWhen, AllowWhen, and
+// RedirectWhen. It reads the tool call and nothing else: there are no
+// assignments, loops, or calls other than the helpers below.
+//
+//	expr    = or
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = postfix [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) postfix ]
+//	postfix = primary { "." ident [ args ] | "[" expr "]" }
+//	primary = string | number | "true" | "false" | "null" | ident [ args ] | "(" expr ")"
+//	args    = "(" [ expr { "," expr } ] ")"
+
+// exprType is the type of an expression, when known before evaluation.
+type exprType int
+
+const (
+	exprDyn    exprType = iota // Known only at run time, such as an input field
+	exprBool                   // true or false
+	exprString                 // A string
+	exprNumber                 // A float64
+	exprMap                    // A map[string]any, such as input
+	exprNull                   // The null literal
+)
+
+func (t exprType) String() string {
+	switch t {
+	case exprBool:
+		return "bool"
+	case exprString:
+		return "string"
+	case exprNumber:
+		return "number"
+	case exprMap:
+		return "map"
+	case exprNull:
+		return "null"
+	default:
+		return "dyn"
+	}
+}
+
+// exprVars lists the variables an expression can read.
+var exprVars = map[string]exprType{
+	"tool":  exprString,
+	"input": exprMap,
+}
+
+// exprHelper describes a helper function. Each takes a string and a
+// string argument and reports a bool; a null first argument is false.
+type exprHelper struct {
+	fn func(s, arg string) bool
+}
+
+// exprHelpers lists the helper functions. matches is handled separately
+// because its pattern is compiled with the expression.
+var exprHelpers = map[string]exprHelper{
+	"contains":  {strings.Contains},
+	"hasPrefix": {strings.HasPrefix},
+	"hasSuffix": {strings.HasSuffix},
+}
+
+// maxExprDepth limits nesting so a hostile expression cannot exhaust the
+// stack while compiling.
+const maxExprDepth = 64
+
+// exprError reports an expression that does not compile, at a byte offset
+// into its source.
+type exprError struct {
+	pos         int
+	msg         string
+	suggestions []string
+}
+
+func (e *exprError) Error() string {
+	return fmt.Sprintf("at offset %d: %s", e.pos, e.msg)
+}
+
+// exprEnv holds the values of an expression's variables.
+type exprEnv struct {
+	tool  string
+	input map[string]any
+}
+
+// exprNode is a compiled expression.
+type exprNode struct {
+	typ     exprType
+	literal bool // The node is a literal, so eval ignores its env
+	eval    func(env *exprEnv) (any, error)
+}
+
+// expression is a compiled policy expression that evaluates to a bool.
+type expression struct {
+	src  string
+	root exprNode
+}
+
+// compileExpr parses and type-checks src. Types that are known before
+// evaluation, such as those of tool and literals, are checked here; the
+// types of input fields are checked when the expression runs.
+func compileExpr(src string) (*expression, error) {
+	toks, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &exprError{pos: tok.pos, msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+	if root.typ != exprBool && root.typ != exprDyn {
+		return nil, &exprError{pos: 0, msg: fmt.Sprintf("expression is a %s, want bool", root.typ)}
+	}
+	return &expression{src: src, root: root}, nil
+}
+
+// match evaluates the expression against a tool call. A null result, such
+// as a missing input field, is false.
+func (e *expression) match(tc *ToolCall) (bool, error) {
+	v, err := e.root.eval(&exprEnv{tool: tc.Name, input: tc.Input})
+	if err != nil {
+		return false, err
+	}
+	return truth(v)
+}
+
+// truth converts a value used as a condition to a bool.
+func truth(v any) (bool, error) {
+	switch b := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return b, nil
+	default:
+		return false, fmt.Errorf("%s used as a condition, want bool", typeName(v))
+	}
+}
+
+// typeName names the type of a run-time value for error messages.
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case map[string]any:
+		return "map"
+	case []any:
+		return "list"
+	}
+	if _, ok := toNumber(v); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toNumber converts the numeric types a tool input may hold to float64.
+func toNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string // Source text; the unquoted value for strings
+	pos  int
+	num  float64
+}
+
+func (t exprToken) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// exprOps lists the operators, longest first so "&&" wins over "&".
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "(", ")", "[", "]", ".", ","}
+
+// lexExpr splits src into tokens.
+func lexExpr(src string) ([]exprToken, error) {
+	var toks []exprToken
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			s, n, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, exprToken{kind: tokString, text: s, pos: i})
+			i += n
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, &exprError{pos: start, msg: fmt.Sprintf("invalid number %q", src[start:i])}
+			}
+			toks = append(toks, exprToken{kind: tokNumber, text: src[start:i], pos: start, num: num})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			toks = append(toks, exprToken{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, o := range exprOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &exprError{pos: i, msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			toks = append(toks, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(toks, exprToken{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString reads the quoted string at src[start], returning its value and
+// length in the source. The escapes \\, \', \", \n, and \t are supported.
+func lexString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var b strings.Builder
+	for i := start + 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1 - start, nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case '\\', '\'', '"':
+				b.WriteByte(src[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				return "", 0, &exprError{pos: i - 1, msg: fmt.Sprintf("unknown escape \\%c", src[i])}
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, &exprError{pos: start, msg: "unterminated string"}
+}
+
+// Parser
+
+type exprParser struct {
+	toks  []exprToken
+	i     int
+	depth int
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// expect consumes the operator op or reports an error.
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return &exprError{pos: tok.pos, msg: fmt.Sprintf("expected %q, found %s", op, tok)}
+	}
+	return nil
+}
+
+// enter guards against deep nesting; the caller defers p.leave().
+func (p *exprParser) enter() error {
+	p.depth++
+	if p.depth > maxExprDepth {
+		return &exprError{pos: p.peek().pos, msg: "expression is nested too deeply"}
+	}
+	return nil
+}
+
+func (p *exprParser) leave() {
+	p.depth--
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	return p.parseLogical("&&", p.parseUnary)
+}
+
+// parseLogical parses a chain of && or || operands. Evaluation stops at
+// the first operand that decides the result.
+func (p *exprParser) parseLogical(op string, operand func() (exprNode, error)) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for {
+		pos := p.peek().pos
+		if !p.accept(op) {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if err := checkBool(left, pos, op); err != nil {
+			return exprNode{}, err
+		}
+		if err := checkBool(right, pos, op); err != nil {
+			return exprNode{}, err
+		}
+		l, r := left.eval, right.eval
+		stopOn := op == "||" // || stops on true, && on false
+		left = exprNode{typ: exprBool, eval: func(env *exprEnv) (any, error) {
+			for _, eval := range []func(*exprEnv) (any, error){l, r} {
+				v, err := eval(env)
+				if err != nil {
+					return nil, err
+				}
+				b, err := truth(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s operand: %w", op, err)
+				}
+				if b == stopOn {
+					return stopOn, nil
+				}
+			}
+			return !stopOn, nil
+		}}
+	}
+}
+
+// checkBool reports an operand of op whose type is known not to be bool.
+func checkBool(n exprNode, pos int, op string) error {
+	if n.typ != exprBool && n.typ != exprDyn && n.typ != exprNull {
+		return &exprError{pos: pos, msg: fmt.Sprintf("%s operand is a %s, want bool", op, n.typ)}
+	}
+	return nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if err := p.enter(); err != nil {
+		return exprNode{}, err
+	}
+	defer p.leave()
+
+	pos := p.peek().pos
+	if !p.accept("!") {
+		return p.parseCompare()
+	}
+	operand, err := p.parseUnary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	if err := checkBool(operand, pos, "!"); err != nil {
+		return exprNode{}, err
+	}
+	eval := operand.eval
+	return exprNode{typ: exprBool, eval: func(env *exprEnv) (any, error) {
+		v, err := eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := truth(v)
+		if err != nil {
+			return nil, fmt.Errorf("! operand: %w", err)
+		}
+		return !b, nil
+	}}, nil
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return left, nil
+	}
+	op := tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	if op == "==" || op == "!=" {
+		for _, n := range []exprNode{left, right} {
+			if n.typ == exprMap {
+				return exprNode{}, &exprError{pos: tok.pos, msg: "cannot compare a map"}
+			}
+		}
+		if left.typ != right.typ && left.typ != exprDyn && right.typ != exprDyn &&
+			left.typ != exprNull && right.typ != exprNull {
+			return exprNode{}, &exprError{pos: tok.pos, msg: fmt.Sprintf("cannot compare %s %s %s", left.typ, op, right.typ)}
+		}
+		l, r := left.eval, right.eval
+		want := op == "=="
+		return exprNode{typ: exprBool, eval: func(env *exprEnv) (any, error) {
+			a, b, err := evalPair(env, l, r)
+			if err != nil {
+				return nil, err
+			}
+			eq, err := equal(a, b)
+			if err != nil {
+				return nil, err
+			}
+			return eq == want, nil
+		}}, nil
+	}
+
+	for _, n := range []exprNode{left, right} {
+		if n.typ != exprNumber && n.typ != exprDyn {
+			return exprNode{}, &exprError{pos: tok.pos, msg: fmt.Sprintf("%s operand is a %s, want number", op, n.typ)}
+		}
+	}
+	l, r := left.eval, right.eval
+	return exprNode{typ: exprBool, eval: func(env *exprEnv) (any, error) {
+		a, b, err := evalPair(env, l, r)
+		if err != nil {
+			return nil, err
+		}
+		if a == nil || b == nil {
+			return false, nil // A missing field is not ordered
+		}
+		x, ok1 := toNumber(a)
+		y, ok2 := toNumber(b)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("cannot compare %s %s %s", typeName(a), op, typeName(b))
+		}
+		switch op {
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		default:
+			return x >= y, nil
+		}
+	}}, nil
+}
+
+// evalPair evaluates two operands in order.
+func evalPair(env *exprEnv, l, r func(*exprEnv) (any, error)) (any, any, error) {
+	a, err := l(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := r(env)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, b, nil
+}
+
+// equal compares two scalar values. Values of different types are not
+// equal; maps and lists cannot be compared.
+func equal(a, b any) (bool, error) {
+	for _, v := range []any{a, b} {
+		switch v.(type) {
+		case map[string]any, []any:
+			return false, fmt.Errorf("cannot compare a %s", typeName(v))
+		}
+	}
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y, nil
+	}
+	return a == b, nil
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for {
+		pos := p.peek().pos
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return exprNode{}, &exprError{pos: name.pos, msg: fmt.Sprintf("expected a field or helper name after '.', found %s", name)}
+			}
+			if p.peek().kind == tokOp && p.peek().text == "(" {
+				// Method form: s.contains(x) is contains(s, x)
+				n, err = p.parseCall(name, []exprNode{n})
+			} else {
+				n, err = field(n, constNode(exprString, name.text), pos)
+			}
+		case p.accept("["):
+			var key exprNode
+			if key, err = p.parseOr(); err != nil {
+				return exprNode{}, err
+			}
+			if err = p.expect("]"); err != nil {
+				return exprNode{}, err
+			}
+			n, err = field(n, key, pos)
+		default:
+			return n, nil
+		}
+		if err != nil {
+			return exprNode{}, err
+		}
+	}
+}
+
+// field builds a lookup of key in a map, or of a numeric index in a list.
+// Looking up a missing key, or anything in null, gives null, so a rule
+// can refer to fields that only some tools have.
+func field(obj, key exprNode, pos int) (exprNode, error) {
+	switch obj.typ {
+	case exprMap, exprDyn, exprNull:
+	default:
+		return exprNode{}, &exprError{pos: pos, msg: fmt.Sprintf("cannot index a %s", obj.typ)}
+	}
+	if key.typ != exprString && key.typ != exprNumber && key.typ != exprDyn {
+		return exprNode{}, &exprError{pos: pos, msg: fmt.Sprintf("index is a %s, want string or number", key.typ)}
+	}
+	o, k := obj.eval, key.eval
+	return exprNode{typ: exprDyn, eval: func(env *exprEnv) (any, error) {
+		container, index, err := evalPair(env, o, k)
+		if err != nil {
+			return nil, err
+		}
+		switch c := container.(type) {
+		case nil:
+			return nil, nil
+		case map[string]any:
+			name, ok := index.(string)
+			if !ok {
+				return nil, fmt.Errorf("map index is a %s, want string", typeName(index))
+			}
+			return c[name], nil
+		case []any:
+			f, ok := toNumber(index)
+			if !ok || f != float64(int(f)) {
+				return nil, fmt.Errorf("list index is a %s, want integer", typeName(index))
+			}
+			if i := int(f); i >= 0 && i < len(c) {
+				return c[i], nil
+			}
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("cannot index a %s", typeName(container))
+		}
+	}}, nil
+}
+
+// constNode returns a node for a literal value.
+func constNode(typ exprType, v any) exprNode {
+	return exprNode{typ: typ, literal: true, eval: func(*exprEnv) (any, error) { return v, nil }}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return constNode(exprString, tok.text), nil
+	case tokNumber:
+		return constNode(exprNumber, tok.num), nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return constNode(exprBool, tok.text == "true"), nil
+		case "null":
+			return constNode(exprNull, nil), nil
+		}
+		if p.peek().kind == tokOp && p.peek().text == "(" {
+			return p.parseCall(tok, nil)
+		}
+		typ, ok := exprVars[tok.text]
+		if !ok {
+			return exprNode{}, &exprError{
+				pos:         tok.pos,
+				msg:         fmt.Sprintf("unknown variable %q; use tool or input", tok.text),
+				suggestions: suggestNames(tok.text, exprVars),
+			}
+		}
+		name := tok.text
+		return exprNode{typ: typ, eval: func(env *exprEnv) (any, error) {
+			if name == "tool" {
+				return env.tool, nil
+			}
+			if env.input == nil {
+				return map[string]any{}, nil
+			}
+			return env.input, nil
+		}}, nil
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.enter(); err != nil {
+				return exprNode{}, err
+			}
+			defer p.leave()
+			n, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			return n, p.expect(")")
+		}
+	}
+	return exprNode{}, &exprError{pos: tok.pos, msg: fmt.Sprintf("unexpected %s", tok)}
+}
+
+// parseCall parses the arguments of a helper call. recv holds the value
+// before the '.' in the method form.
+func (p *exprParser) parseCall(name exprToken, recv []exprNode) (exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return exprNode{}, err
+	}
+	args := recv
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return exprNode{}, err
+			}
+		}
+	}
+
+	helper, ok := exprHelpers[name.text]
+	if !ok && name.text != "matches" {
+		names := map[string]exprType{"matches": exprBool}
+		for n := range exprHelpers {
+			names[n] = exprBool
+		}
+		return exprNode{}, &exprError{
+			pos:         name.pos,
+			msg:         fmt.Sprintf("unknown function %q", name.text),
+			suggestions: suggestNames(name.text, names),
+		}
+	}
+	if len(args) != 2 {
+		return exprNode{}, &exprError{pos: name.pos, msg: fmt.Sprintf("%s takes 2 arguments, got %d", name.text, len(args))}
+	}
+	for _, arg := range args {
+		if arg.typ != exprString && arg.typ != exprDyn && arg.typ != exprNull {
+			return exprNode{}, &exprError{pos: name.pos, msg: fmt.Sprintf("%s argument is a %s, want string", name.text, arg.typ)}
+		}
+	}
+
+	fn := helper.fn
+	if name.text == "matches" {
+		re, err := literalPattern(args[1], name.pos)
+		if err != nil {
+			return exprNode{}, err
+		}
+		fn = func(s, _ string) bool { return re.MatchString(s) }
+	}
+	s, a, helperName := args[0].eval, args[1].eval, name.text
+	return exprNode{typ: exprBool, eval: func(env *exprEnv) (any, error) {
+		x, y, err := evalPair(env, s, a)
+		if err != nil {
+			return nil, err
+		}
+		if x == nil || y == nil {
+			return false, nil // A missing field matches nothing
+		}
+		xs, ok1 := x.(string)
+		ys, ok2 := y.(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%s(%s, %s), want strings", helperName, typeName(x), typeName(y))
+		}
+		return fn(xs, ys), nil
+	}}, nil
+}
+
+// literalPattern compiles the pattern argument of matches, which must be
+// a string literal so that a bad pattern is reported at compile time.
+func literalPattern(arg exprNode, pos int) (*regexp.Regexp, error) {
+	if arg.typ != exprString || !arg.literal {
+		return nil, &exprError{pos: pos, msg: "matches pattern must be a string literal"}
+	}
+	v, _ := arg.eval(nil)
+	re, err := regexp.Compile(v.(string))
+	if err != nil {
+		return nil, &exprError{pos: pos, msg: fmt.Sprintf("invalid pattern: %v", err)}
+	}
+	return re, nil
+}
+
+// suggestNames returns the names nearest to name, if any is close enough
+// to be a typo.
+func suggestNames(name string, names map[string]exprType) []string {
+	var out []string
+	best := -1
+	for n := range names {
+		d := editDistance(strings.ToLower(name), strings.ToLower(n))
+		switch {
+		case d > max(1, len(n)/3) || best >= 0 && d > best:
+		case d == best:
+			out = append(out, n)
+		default:
+			best, out = d, []string{n}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
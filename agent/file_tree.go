@@ -0,0 +1,378 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Delimiters around the file tree in the first prompt.
+const (
+	fileTreeStart = "<file-tree>\nFiles in the working directory:\n"
+	fileTreeEnd   = "\n</file-tree>\n\n"
+)
+
+// fileTreeTimeout bounds the walk of PrimeWithFileTree. A walk that takes
+// longer is abandoned, and the prompt is sent without a file tree.
+var fileTreeTimeout = 2 * time.Second
+
+// binarySniffBytes is how much of a file is read to tell whether it is
+// binary, as git does.
+const binarySniffBytes = 8000
+
+// fileTreeConfig holds the PrimeWithFileTree settings.
+type fileTreeConfig struct {
+	maxEntries int
+	ignore     []string
+	sizes      bool
+}
+
+// PrimeWithFileTree prepends a listing of WorkDir to the first prompt of a
+// session, so Claude knows the repository's layout without spending turns
+// on Glob and Bash calls. The listing follows .gitignore files and the
+// ignore patterns, which use .gitignore syntax relative to WorkDir. It
+// shows at most maxEntries files and directories, nearest the top first,
+// and notes when entries were left out. Symbolic links are listed but not
+// followed, and .git is never listed.
+//
+// The listing is prepended in a <file-tree> block before UserPromptSubmit
+// hooks are called, so they see it, and reported as a session.file_tree
+// audit event. A walk that takes longer than two seconds is abandoned: the
+// prompt is sent without the block and session.file_tree_skipped is
+// emitted. Later runs, and sessions resumed with Resume, get no block.
+// New returns a *ConfigError if maxEntries is not positive.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.WorkDir("/src/service"),
+//	    agent.PrimeWithFileTree(300, "vendor/", "*.pb.go"),
+//	)
+func PrimeWithFileTree(maxEntries int, ignore ...string) Option {
+	return func(c *config) {
+		sizes := c.fileTree != nil && c.fileTree.sizes
+		c.fileTree = &fileTreeConfig{maxEntries: maxEntries, ignore: ignore, sizes: sizes}
+	}
+}
+
+// FileTreeSizes adds each file's size to the PrimeWithFileTree listing,
+// and marks binary files. Telling binary files apart reads the start of
+// every listed file.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.PrimeWithFileTree(300), agent.FileTreeSizes(true))
+func FileTreeSizes(show bool) Option {
+	return func(c *config) {
+		var ft fileTreeConfig // Copied, as clones share the pointer
+		if c.fileTree != nil {
+			ft = *c.fileTree
+		}
+		ft.sizes = show
+		c.fileTree = &ft
+	}
+}
+
+// validateFileTree checks the PrimeWithFileTree settings.
+func validateFileTree(cfg *config) error {
+	if cfg.fileTree == nil {
+		return nil
+	}
+	if cfg.fileTree.maxEntries <= 0 {
+		return &ConfigError{Option: "PrimeWithFileTree", Value: fmt.Sprint(cfg.fileTree.maxEntries), Reason: "maxEntries must be positive"}
+	}
+	return nil
+}
+
+// fileTreeBlock returns the file tree to prepend to the session's first
+// prompt, or "" if there is none to add. The walk is reported as an audit
+// event.
+func (a *Agent) fileTreeBlock(sessionID string) string {
+	a.mu.Lock()
+	prime := a.cfg.fileTree != nil && !a.treePrimed && a.cfg.resume == ""
+	a.mu.Unlock()
+	if !prime {
+		return ""
+	}
+
+	root := a.cfg.workDir
+	if root == "" {
+		root = "."
+	}
+	start := time.Now()
+	tree, err := walkFileTree(root, a.cfg.fileTree, start.Add(fileTreeTimeout))
+	duration := time.Since(start).Milliseconds()
+	if err != nil {
+		a.auditor.emit(sessionID, "session.file_tree_skipped", map[string]any{
+			"work_dir":    root,
+			"error":       err.Error(),
+			"duration_ms": duration,
+		})
+		return ""
+	}
+	listing := tree.render()
+	a.auditor.emit(sessionID, "session.file_tree", map[string]any{
+		"work_dir":    root,
+		"entries":     tree.entries,
+		"truncated":   tree.truncated,
+		"bytes":       len(listing),
+		"duration_ms": duration,
+	})
+	return fileTreeStart + listing + fileTreeEnd
+}
+
+// fileTree is the result of walkFileTree.
+type fileTree struct {
+	root       *treeEntry
+	entries    int
+	truncated  bool
+	maxEntries int
+}
+
+// treeEntry is a file, directory, or symbolic link in a fileTree.
+type treeEntry struct {
+	name     string
+	dir      bool
+	link     string // Target of a symbolic link
+	size     int64
+	binary   bool
+	sized    bool // size and binary are set
+	children []*treeEntry
+}
+
+// walkFileTree lists root breadth first, so that a listing cut off at
+// maxEntries keeps the top levels. It returns an error if root cannot be
+// read or the walk passes deadline.
+func walkFileTree(root string, cfg *fileTreeConfig, deadline time.Time) (*fileTree, error) {
+	tree := &fileTree{root: &treeEntry{dir: true}, maxEntries: cfg.maxEntries}
+	type pending struct {
+		entry *treeEntry
+		rel   string // Slash-separated path from root, "" for root
+		rules *ignoreRules
+	}
+	base := (&ignoreRules{}).with("", cfg.ignore)
+	queue := []pending{{entry: tree.root, rules: base}}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("listing took longer than %s", fileTreeTimeout)
+		}
+		abs := filepath.Join(root, filepath.FromSlash(dir.rel))
+		items, err := os.ReadDir(abs)
+		if err != nil {
+			if dir.rel == "" {
+				return nil, err
+			}
+			continue // An unreadable subdirectory is listed without contents
+		}
+		rules := dir.rules.with(dir.rel, readGitignore(filepath.Join(abs, ".gitignore")))
+
+		// Directories before files, each by name
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].IsDir() && !items[j].IsDir()
+		})
+		for _, item := range items {
+			name := item.Name()
+			rel := path.Join(dir.rel, name)
+			isDir := item.IsDir()
+			if name == ".git" || rules.ignored(rel, isDir) {
+				continue
+			}
+			if tree.entries == cfg.maxEntries {
+				tree.truncated = true
+				return tree, nil
+			}
+			tree.entries++
+			e := &treeEntry{name: name, dir: isDir}
+			switch {
+			case item.Type()&os.ModeSymlink != 0:
+				e.link, _ = os.Readlink(filepath.Join(abs, name))
+			case isDir:
+				queue = append(queue, pending{entry: e, rel: rel, rules: rules})
+			case cfg.sizes:
+				e.size, e.binary = fileSizeAndKind(filepath.Join(abs, name))
+				e.sized = true
+			}
+			dir.entry.children = append(dir.entry.children, e)
+		}
+	}
+	return tree, nil
+}
+
+// fileSizeAndKind returns a file's size and whether it looks binary: it
+// has a NUL byte near the start.
+func fileSizeAndKind(name string) (int64, bool) {
+	f, err := os.Open(name) // #nosec G304 -- listing the configured WorkDir
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = f.Close() }()
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	buf := make([]byte, binarySniffBytes)
+	n, _ := io.ReadFull(f, buf)
+	return size, bytes.IndexByte(buf[:n], 0) >= 0
+}
+
+// render returns the listing, indenting each level by two spaces.
+func (t *fileTree) render() string {
+	var b strings.Builder
+	var write func(e *treeEntry, depth int)
+	write = func(e *treeEntry, depth int) {
+		for _, c := range e.children {
+			b.WriteString(strings.Repeat("  ", depth))
+			b.WriteString(c.name)
+			switch {
+			case c.link != "":
+				b.WriteString(" -> " + c.link)
+			case c.dir:
+				b.WriteByte('/')
+			case c.sized && c.binary:
+				fmt.Fprintf(&b, " (%s, binary)", formatBytes(c.size))
+			case c.sized:
+				fmt.Fprintf(&b, " (%s)", formatBytes(c.size))
+			}
+			b.WriteByte('\n')
+			write(c, depth+1)
+		}
+	}
+	write(t.root, 0)
+	if t.truncated {
+		fmt.Fprintf(&b, "[listing cut off at %d entries]\n", t.maxEntries)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatBytes formats a size as bytes, KB, or MB.
+func formatBytes(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+}
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+	base     string // Directory of the .gitignore, relative to the root
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool // Matched against the path from base, not the name
+}
+
+// ignoreRules are the .gitignore patterns in effect in a directory, from
+// the root down; the last that matches a path decides it.
+type ignoreRules struct {
+	rules []ignoreRule
+}
+
+// with returns the rules extended by .gitignore lines found in dir.
+func (r *ignoreRules) with(dir string, lines []string) *ignoreRules {
+	var added []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`) // Escaped leading # or !
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		added = append(added, rule)
+	}
+	if len(added) == 0 {
+		return r
+	}
+	return &ignoreRules{rules: append(append([]ignoreRule(nil), r.rules...), added...)}
+}
+
+// ignored reports whether the path rel, relative to the root, is ignored.
+func (r *ignoreRules) ignored(rel string, dir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !dir {
+			continue
+		}
+		var ok bool
+		if rule.anchored {
+			p := rel
+			if rule.base != "" {
+				p = strings.TrimPrefix(rel, rule.base+"/")
+			}
+			ok = matchSegments(strings.Split(rule.pattern, "/"), strings.Split(p, "/"))
+		} else {
+			ok, _ = path.Match(rule.pattern, path.Base(rel)) // Malformed patterns never match
+		}
+		if ok {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path segments against pattern segments, where
+// "**" matches any number of segments.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// readGitignore returns the lines of a .gitignore file, or nil if there is
+// none.
+func readGitignore(name string) []string {
+	f, err := os.Open(name) // #nosec G304 -- a .gitignore in the configured WorkDir
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
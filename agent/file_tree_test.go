@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fileTreeFixture writes a working directory with .gitignore files, a
+// symbolic link, and a binary file, and returns its path.
+func fileTreeFixture(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		".git/HEAD":           "ref: refs/heads/main\n",
+		".gitignore":          "# Build output\n*.log\nbuild/\n/secret.txt\n!keep.log\ndocs/**/draft.md\n",
+		"README.md":           "# Fixture\n",
+		"app.log":             "ignored\n",
+		"keep.log":            "kept by negation\n",
+		"secret.txt":          "anchored to the root\n",
+		"logo.png":            "\x89PNG\r\n\x1a\n\x00\x00\x00\r",
+		"build/out.bin":       "ignored directory\n",
+		"cmd/build":           "a file, so build/ does not match\n",
+		"cmd/main.go":         "package main\n",
+		"cmd/secret.txt":      "not at the root\n",
+		"docs/draft.md":       "** matches no directories\n",
+		"docs/guide/draft.md": "ignored\n",
+		"docs/guide/intro.md": "# Intro\n",
+		"vendor/lib/lib.go":   "package lib\n",
+		"web/.gitignore":      "dist\n",
+		"web/dist/app.js":     "ignored by web/.gitignore\n",
+		"web/index.html":      "<html></html>\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mustWriteFile(t, path, []byte(content), 0644)
+	}
+	if err := os.Symlink("README.md", filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	return dir
+}
+
+func TestWalkFileTree(t *testing.T) {
+	dir := fileTreeFixture(t)
+	deadline := time.Now().Add(time.Minute)
+
+	tree, err := walkFileTree(dir, &fileTreeConfig{maxEntries: 100, ignore: []string{"vendor/"}}, deadline)
+	if err != nil {
+		t.Fatalf("walkFileTree() error = %v", err)
+	}
+	want := strings.Join([]string{
+		"cmd/",
+		"  build",
+		"  main.go",
+		"  secret.txt",
+		"docs/",
+		"  guide/",
+		"    intro.md",
+		"web/",
+		"  .gitignore",
+		"  index.html",
+		".gitignore",
+		"README.md",
+		"keep.log",
+		"link -> README.md",
+		"logo.png",
+	}, "\n")
+	if got := tree.render(); got != want {
+		t.Errorf("render() =\n%s\nwant:\n%s", got, want)
+	}
+	if tree.entries != 15 || tree.truncated {
+		t.Errorf("entries = %d, truncated = %v, want 15 and false", tree.entries, tree.truncated)
+	}
+
+	// Sizes, with binary files marked; links are not followed
+	tree, err = walkFileTree(dir, &fileTreeConfig{maxEntries: 100, sizes: true}, deadline)
+	if err != nil {
+		t.Fatalf("walkFileTree() error = %v", err)
+	}
+	got := tree.render()
+	for _, line := range []string{"\nREADME.md (10 B)\n", "\nlogo.png (12 B, binary)", "\nlink -> README.md\n", "\nvendor/\n"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("render() with sizes =\n%s\nwant a line %q", got, strings.TrimSpace(line))
+		}
+	}
+}
+
+func TestWalkFileTreeCap(t *testing.T) {
+	dir := fileTreeFixture(t)
+	tree, err := walkFileTree(dir, &fileTreeConfig{maxEntries: 4}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("walkFileTree() error = %v", err)
+	}
+
+	// The top level is listed before anything nested
+	want := "cmd/\ndocs/\nvendor/\nweb/\n[listing cut off at 4 entries]"
+	if got := tree.render(); got != want {
+		t.Errorf("render() =\n%s\nwant:\n%s", got, want)
+	}
+	if tree.entries != 4 || !tree.truncated {
+		t.Errorf("entries = %d, truncated = %v, want 4 and true", tree.entries, tree.truncated)
+	}
+
+	// Exactly enough room is not a cut
+	tree, _ = walkFileTree(dir, &fileTreeConfig{maxEntries: 18}, time.Now().Add(time.Minute))
+	if tree.truncated {
+		t.Errorf("walkFileTree(maxEntries 18) truncated, want all %d entries", tree.entries)
+	}
+}
+
+func TestPrimeWithFileTree(t *testing.T) {
+	dir := fileTreeFixture(t)
+	wire := filepath.Join(t.TempDir(), "wire.jsonl")
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+while IFS= read -r line; do
+	printf '%s\n' "$line" >> ` + wire + `
+	printf '%s\n' '{"type":"system","subtype":"init","session_id":"tree-test"}'
+	printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var prompts []string
+	var events []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		WorkDir(dir),
+		PrimeWithFileTree(4),
+		UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+			mu.Lock()
+			defer mu.Unlock()
+			prompts = append(prompts, e.Prompt)
+			return PromptSubmitResult{}
+		}),
+		Audit(func(e AuditEvent) {
+			if strings.HasPrefix(e.Type, "session.file_tree") {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, e)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for _, prompt := range []string{"Find the entry point.", "Now the docs."} {
+		if _, err := a.Run(ctx, prompt); err != nil {
+			t.Fatalf("Run(%q) error = %v", prompt, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	block := fileTreeStart + "cmd/\ndocs/\nvendor/\nweb/\n[listing cut off at 4 entries]" + fileTreeEnd
+	if len(prompts) != 2 || prompts[0] != block+"Find the entry point." || prompts[1] != "Now the docs." {
+		t.Errorf("hooks saw %q, want the file tree on the first prompt only", prompts)
+	}
+	lines := strings.Split(strings.TrimSpace(string(mustReadFile(t, wire))), "\n")
+	if len(lines) != 2 || lines[0] != strings.TrimSuffix(string(marshalUserMessage(prompts[0])), "\n") {
+		t.Errorf("CLI read %q, want the first prompt with the file tree", lines)
+	}
+	var data map[string]any
+	if len(events) == 1 {
+		data, _ = events[0].Data.(map[string]any)
+	}
+	if len(events) != 1 || events[0].Type != "session.file_tree" || data["entries"] != 4 || data["truncated"] != true {
+		t.Errorf("audit events = %+v, want one session.file_tree", events)
+	}
+}
+
+func TestPrimeWithFileTreeSkipped(t *testing.T) {
+	defer func(d time.Duration) { fileTreeTimeout = d }(fileTreeTimeout)
+	fileTreeTimeout = 0 // Every walk is over time
+
+	cli, promptFile := pipelineCLI(t, `"ok"`, "0")
+	var skipped []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(cli), WorkDir(fileTreeFixture(t)), PrimeWithFileTree(100),
+		Audit(func(e AuditEvent) {
+			if strings.HasPrefix(e.Type, "session.file_tree") {
+				skipped = append(skipped, e)
+			}
+		}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := string(mustReadFile(t, promptFile)); strings.Contains(got, "file-tree") {
+		t.Errorf("CLI read %s, want no file tree", got)
+	}
+	if len(skipped) != 1 || skipped[0].Type != "session.file_tree_skipped" {
+		t.Errorf("audit events = %+v, want one session.file_tree_skipped", skipped)
+	}
+
+	_, err = New(ctx, CLIPath(cli), PrimeWithFileTree(0))
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Option != "PrimeWithFileTree" {
+		t.Errorf("New(PrimeWithFileTree(0)) error = %v, want a *ConfigError", err)
+	}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// forkPoint records a ForkFrom request until New prepares the transcript.
+type forkPoint struct {
+	sessionID string
+	turn      int
+}
+
+// claudeConfigDir returns the CLI's configuration directory, which holds
+// session transcripts under projects/<project>/<session ID>.jsonl.
+func claudeConfigDir() (string, error) {
+	if dir := os.Getenv("CLAUDE_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".claude"), nil
+}
+
+// findTranscript locates the transcript file of a session. The CLI keeps
+// a session in the project directory named after its working directory,
+// so the one for workDir is tried first; another project's copy is used
+// only if it is the only one.
+func findTranscript(sessionID, workDir string) (string, error) {
+	dir, err := claudeConfigDir()
+	if err != nil {
+		return "", err
+	}
+	projects := filepath.Join(dir, "projects")
+	if abs, err := filepath.Abs(workDir); err == nil {
+		path := filepath.Join(projects, projectDirName(abs), sessionID+".jsonl")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(projects, "*", sessionID+".jsonl"))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no transcript for session %s in %s", sessionID, projects)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("session %s has transcripts in several projects (%s); set WorkDir to the session's directory",
+		sessionID, strings.Join(matches, ", "))
+}
+
+// projectDirName returns the name of the CLI's project directory for a
+// working directory: the path with each character other than an ASCII
+// letter or digit replaced by '-'.
+func projectDirName(dir string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '-'
+	}, dir)
+}
+
+// isTurnStart reports whether a transcript entry is a user prompt, which
+// starts a new turn. Tool results are also sent as user entries but continue
+// the current turn.
+func isTurnStart(entry map[string]any) bool {
+	if entry["type"] != "user" {
+		return false
+	}
+	msg, _ := entry["message"].(map[string]any)
+	switch content := msg["content"].(type) {
+	case string:
+		return true
+	case []any:
+		for _, block := range content {
+			if b, ok := block.(map[string]any); ok && b["type"] == "tool_result" {
+				return false
+			}
+		}
+		return len(content) > 0
+	}
+	return false
+}
+
+// truncateTranscript returns the transcript entries up to the end of the
+// given turn, with sessionId rewritten to newSessionID. Entries before the
+// first prompt (e.g. summaries) are kept. It fails if the transcript has
+// fewer turns than requested.
+func truncateTranscript(data []byte, turn int, newSessionID string) ([]byte, error) {
+	var out bytes.Buffer
+	turns := 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64*1024*1024) // Transcript entries can hold large tool results
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry map[string]any
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber() // Keep numbers exactly as written
+		if err := dec.Decode(&entry); err != nil {
+			return nil, fmt.Errorf("invalid transcript entry: %w", err)
+		}
+		if isTurnStart(entry) {
+			turns++
+			if turns > turn {
+				break // Start of the first turn after the fork point
+			}
+		}
+
+		if _, ok := entry["sessionId"]; ok {
+			entry["sessionId"] = newSessionID
+		}
+		rewritten, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(rewritten)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if turns < turn {
+		return nil, fmt.Errorf("turn %d is beyond the last turn (%d)", turn, turns)
+	}
+	return out.Bytes(), nil
+}
+
+// prepareFork writes a copy of the session transcript truncated after the
+// requested turn, as a new session next to the original, and configures the
+// agent to resume it. Close removes the copy.
+func prepareFork(cfg *config) error {
+	fp := cfg.forkFrom
+	if fp == nil || cfg.forkTranscript != "" {
+		return nil
+	}
+	if fp.turn < 1 {
+		return &StartError{Reason: fmt.Sprintf("ForkFrom turn must be at least 1, got %d", fp.turn)}
+	}
+
+	src, err := findTranscript(fp.sessionID, cfg.workDir)
+	if err != nil {
+		return &StartError{Reason: "ForkFrom: transcript not found", Cause: err}
+	}
+	data, err := os.ReadFile(src) // #nosec G304 -- path is derived from the CLI config directory
+	if err != nil {
+		return &StartError{Reason: "ForkFrom: failed to read transcript", Cause: err}
+	}
+
+	newSessionID := newRunID() // Session IDs use the same UUID format as run IDs
+	truncated, err := truncateTranscript(data, fp.turn, newSessionID)
+	if err != nil {
+		return &StartError{Reason: "ForkFrom: invalid turn", Cause: err}
+	}
+
+	dst := filepath.Join(filepath.Dir(src), newSessionID+".jsonl")
+	if err := os.WriteFile(dst, truncated, 0600); err != nil {
+		return &StartError{Reason: "ForkFrom: failed to write transcript", Cause: err}
+	}
+
+	cfg.forkTranscript = dst
+	cfg.resume = newSessionID
+	cfg.fork = false
+	return nil
+}
+
+// removeFork removes the transcript copy written by prepareFork, if any.
+func removeFork(cfg *config) error {
+	if cfg.forkTranscript == "" {
+		return nil
+	}
+	if err := os.Remove(cfg.forkTranscript); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupTranscript installs the fixture transcript as session sess-orig in a
+// temporary CLI config directory and returns the project directory.
+func setupTranscript(t *testing.T) string {
+	t.Helper()
+	configDir := t.TempDir()
+	t.Setenv("CLAUDE_CONFIG_DIR", configDir)
+
+	projectDir := filepath.Join(configDir, "projects", "-work-repo")
+	mustMkdirAll(t, projectDir, 0755)
+	mustWriteFile(t, filepath.Join(projectDir, "sess-orig.jsonl"), mustReadFile(t, "testdata/transcript.jsonl"), 0600)
+	return projectDir
+}
+
+func TestTruncateTranscript(t *testing.T) {
+	data := mustReadFile(t, "testdata/transcript.jsonl")
+
+	tests := []struct {
+		turn     int
+		lastUUID string
+		entries  int
+	}{
+		{1, "u-4", 5}, // Tool result stays in turn 1
+		{2, "u-6", 7},
+		{3, "u-8", 9},
+	}
+
+	for _, tt := range tests {
+		out, err := truncateTranscript(data, tt.turn, "sess-new")
+		if err != nil {
+			t.Fatalf("truncateTranscript(turn %d) error = %v", tt.turn, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) != tt.entries {
+			t.Errorf("turn %d: got %d entries, want %d", tt.turn, len(lines), tt.entries)
+		}
+		last := lines[len(lines)-1]
+		if !strings.Contains(last, `"uuid":"`+tt.lastUUID+`"`) {
+			t.Errorf("turn %d: last entry = %s, want uuid %s", tt.turn, last, tt.lastUUID)
+		}
+		if strings.Contains(string(out), "sess-orig") {
+			t.Errorf("turn %d: output still references the original session", tt.turn)
+		}
+		if !strings.Contains(string(out), `"input_tokens":1200`) {
+			t.Errorf("turn %d: numbers should be preserved", tt.turn)
+		}
+	}
+}
+
+func TestTruncateTranscriptBeyondLastTurn(t *testing.T) {
+	data := mustReadFile(t, "testdata/transcript.jsonl")
+
+	if _, err := truncateTranscript(data, 4, "sess-new"); err == nil {
+		t.Error("truncateTranscript(turn 4) should fail for a 3-turn transcript")
+	}
+}
+
+func TestForkFromOption(t *testing.T) {
+	c := newConfig(ForkFrom("sess-abc", 2))
+
+	if c.forkFrom == nil || c.forkFrom.sessionID != "sess-abc" || c.forkFrom.turn != 2 {
+		t.Errorf("forkFrom = %+v, want sess-abc at turn 2", c.forkFrom)
+	}
+}
+
+func TestForkFromInvalidTurn(t *testing.T) {
+	setupTranscript(t)
+
+	for _, turn := range []int{0, -1, 4} {
+		_, err := New(context.Background(), CLIPath("/nonexistent/claude"), ForkFrom("sess-orig", turn))
+
+		var startErr *StartError
+		if !errors.As(err, &startErr) || !strings.Contains(startErr.Reason, "turn") {
+			t.Errorf("ForkFrom(turn %d): New() error = %v, want turn StartError", turn, err)
+		}
+	}
+}
+
+func TestForkFromMissingTranscript(t *testing.T) {
+	setupTranscript(t)
+
+	_, err := New(context.Background(), ForkFrom("sess-unknown", 1))
+	var startErr *StartError
+	if !errors.As(err, &startErr) || !strings.Contains(startErr.Reason, "transcript not found") {
+		t.Errorf("New() error = %v, want transcript not found", err)
+	}
+}
+
+func TestForkFromResumesTruncatedCopy(t *testing.T) {
+	projectDir := setupTranscript(t)
+
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	argsFile := filepath.Join(tmpDir, "args")
+	script := "#!/bin/sh\necho \"$@\" > '" + argsFile + "'\nread line\nexit 0\n"
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	a, err := New(context.Background(), CLIPath(fakeClaude), ForkFrom("sess-orig", 2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	newSessionID := a.cfg.resume
+	copied := string(mustReadFile(t, filepath.Join(projectDir, newSessionID+".jsonl")))
+	mustClose(t, a)
+
+	args := string(mustReadFile(t, argsFile))
+	if !strings.Contains(args, "--resume "+newSessionID) {
+		t.Errorf("args = %q, want --resume %s", args, newSessionID)
+	}
+	if strings.Contains(args, "--fork-session") || strings.Contains(args, "sess-orig") {
+		t.Errorf("args = %q, want the truncated copy resumed directly", args)
+	}
+
+	if n := strings.Count(copied, "\n"); n != 7 {
+		t.Errorf("copied transcript has %d entries, want 7", n)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, newSessionID+".jsonl")); !os.IsNotExist(err) {
+		t.Errorf("copied transcript still exists after Close: %v", err)
+	}
+
+	// The original session is untouched
+	original := mustReadFile(t, filepath.Join(projectDir, "sess-orig.jsonl"))
+	if want := mustReadFile(t, "testdata/transcript.jsonl"); string(original) != string(want) {
+		t.Error("original transcript was modified")
+	}
+}
+
+func TestFindTranscriptPrefersWorkDir(t *testing.T) {
+	projectDir := setupTranscript(t)
+	projects := filepath.Dir(projectDir)
+	workDir := t.TempDir()
+	own := filepath.Join(projects, projectDirName(workDir))
+	mustMkdirAll(t, own, 0755)
+	mustWriteFile(t, filepath.Join(own, "sess-orig.jsonl"), mustReadFile(t, "testdata/transcript.jsonl"), 0600)
+
+	// Two projects hold the session; the working directory's is chosen
+	got, err := findTranscript("sess-orig", workDir)
+	if want := filepath.Join(own, "sess-orig.jsonl"); err != nil || got != want {
+		t.Errorf("findTranscript() = %q, %v, want %q", got, err, want)
+	}
+
+	// From elsewhere the choice is ambiguous
+	if got, err := findTranscript("sess-orig", t.TempDir()); err == nil || !strings.Contains(err.Error(), "several projects") {
+		t.Errorf("findTranscript() = %q, %v, want an error naming both projects", got, err)
+	}
+
+	// One match is used wherever it is
+	if got, err := findTranscript("sess-orig", "/work/repo"); err != nil || got != filepath.Join(projectDir, "sess-orig.jsonl") {
+		t.Errorf("findTranscript() = %q, %v, want the /work/repo project", got, err)
+	}
+}
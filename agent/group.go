@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// drainTimeout bounds how long the turn of a run cut short is drained.
+var drainTimeout = DefaultControlTimeout
+
+// Go starts a run of prompt on a in a new goroutine and returns a func that
+// waits for it, for use as a task of an errgroup.Group or any other group
+// of goroutines sharing ctx:
+//
+//	g.Go(agent.Go(ctx, a, prompt, sink, done))
+//
+// Each message the run delivers, including the Result, is passed to sink.
+// When the run ends, done is called once with the Result and the error Run
+// would have returned, and the wait func then returns the same error.
+// Either func may be nil. If sink panics, the run is cancelled, sink is
+// not called again, and the error is a *SinkPanicError.
+//
+// When ctx is cancelled, as when another task of the group fails, the run
+// ends with a *CancelledError. Before done is called, the CLI is asked to
+// interrupt the turn, and its remaining output is read and discarded up to
+// the turn's Result, so a stays usable: the next run does not receive the
+// cancelled run's messages. The drain gives up after DefaultControlTimeout.
+// Start the next run on a after the wait func returns.
+//
+// Example:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(agent.Go(ctx, a, "Review the diff", func(msg agent.Message) {
+//	    if t, ok := msg.(*agent.Text); ok {
+//	        fmt.Print(t.Text)
+//	    }
+//	}, func(result *agent.Result, err error) {
+//	    if err == nil {
+//	        review = result.ResultText
+//	    }
+//	}))
+//	g.Go(func() error { return runTests(ctx) })
+//	err := g.Wait()
+func Go(ctx context.Context, a *Agent, prompt string, sink func(Message), done func(*Result, error), opts ...RunOption) func() error {
+	var (
+		finished = make(chan struct{})
+		err      error
+	)
+	go func() {
+		defer close(finished)
+		var result *Result
+		var panicked *SinkPanicError
+		result, err = a.run(ctx, promptSource{text: prompt}, opts, func(msg Message) {
+			if sink == nil || panicked != nil {
+				return
+			}
+			if panicked = callSink(sink, msg); panicked != nil {
+				panicked.SessionID = a.SessionID()
+				a.Cancel("sink panicked")
+			}
+		})
+		if panicked != nil {
+			err = panicked
+		}
+		a.drainTurn()
+		if done != nil {
+			done(result, err)
+		}
+	}()
+	return func() error {
+		<-finished
+		return err
+	}
+}
+
+// callSink calls sink with msg, recovering a panic as a *SinkPanicError.
+func callSink(sink func(Message), msg Message) (perr *SinkPanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			perr = &SinkPanicError{Value: r}
+		}
+	}()
+	sink(msg)
+	return nil
+}
+
+// drainTurn ends the turn of a run cut short before its Result, so that
+// the next run does not receive the turn's output. The CLI is asked to
+// interrupt the turn, and its messages are discarded up to the Result,
+// denying any permission requests. It gives up after drainTimeout, or
+// when the CLI's output ends.
+func (a *Agent) drainTurn() {
+	a.mu.Lock()
+	if !a.awaitingResult || a.closed {
+		a.mu.Unlock()
+		return
+	}
+	proc, bridge, sessionID := a.proc, a.bridge, a.sessionID
+	a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	// The response may follow the turn's output, so it is read meanwhile
+	go func() {
+		_, _ = a.SendControl(ctx, "interrupt", nil) // Best effort; the turn may already be ending
+	}()
+
+	start := time.Now()
+	discarded, completed := 0, false
+drain:
+	for {
+		select {
+		case msg, ok := <-bridge.recv():
+			if !ok {
+				break drain
+			}
+			switch m := msg.(type) {
+			case *SystemInit:
+				a.handleInit(m)
+				continue // Handled, not discarded
+			case *ControlRequestMsg:
+				_ = sendControlResponse(proc, m.RequestID, Deny, "run cancelled", nil)
+			case *Result:
+				if a.takeOwedResult() {
+					break // The run's last turn is still to come
+				}
+				a.mu.Lock()
+				a.awaitingResult = false
+				a.mu.Unlock()
+				completed = true
+				break drain
+			}
+			discarded++
+		case <-ctx.Done():
+			break drain
+		}
+	}
+	a.auditor.emit(sessionID, "run.drained", map[string]any{
+		"discarded":   discarded,
+		"completed":   completed,
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+}
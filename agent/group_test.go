@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// interruptCLI writes a fake CLI whose first turn runs until it is
+// interrupted, then ends with an "interrupted" result. Later prompts are
+// answered with "second".
+func interruptCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line || exit 0
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"grouped"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Working"}]}}'
+read line || exit 0
+id=$(echo "$line" | sed -n 's/.*"request_id":"\([^"]*\)".*/\1/p')
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Stopping"}]}}'
+printf '{"type":"control_response","response":{"subtype":"success","request_id":"%s","response":{}}}\n' "$id"
+printf '%s\n' '{"type":"result","result":"interrupted","num_turns":1}'
+while read line; do
+	printf '%s\n' '{"type":"result","result":"second","num_turns":1}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+// doneCalls records the calls of a Go done func.
+type doneCalls struct {
+	mu     sync.Mutex
+	calls  int
+	result *Result
+	err    error
+}
+
+func (d *doneCalls) done(result *Result, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls++
+	d.result, d.err = result, err
+}
+
+func TestGo(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(resultCLI(t, "4")))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var sunk []Message
+	var calls doneCalls
+	wait := Go(ctx, a, "What is 2+2?", func(msg Message) {
+		sunk = append(sunk, msg)
+	}, calls.done)
+	if err := wait(); err != nil {
+		t.Fatalf("wait() error = %v", err)
+	}
+	if calls.calls != 1 || calls.err != nil || calls.result == nil || calls.result.ResultText != "4" {
+		t.Errorf("done called %d times with %+v, %v, want once with the result", calls.calls, calls.result, calls.err)
+	}
+	if len(sunk) == 0 || sunk[len(sunk)-1] != calls.result {
+		t.Errorf("sink received %v, want the run's messages ending with its Result", sunk)
+	}
+
+	// Waiting again returns the same error without blocking
+	if err := wait(); err != nil {
+		t.Errorf("second wait() error = %v", err)
+	}
+	if err := Go(ctx, a, "again", nil, nil)(); err != nil {
+		t.Errorf("Go() without sink or done error = %v", err)
+	}
+}
+
+func TestGoCancelledThenReused(t *testing.T) {
+	var mu sync.Mutex
+	var drained []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(interruptCLI(t)), Audit(func(e AuditEvent) {
+		if e.Type == "run.drained" {
+			mu.Lock()
+			drained = append(drained, e)
+			mu.Unlock()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	// Cancelling the group's context once the turn is under way
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var calls doneCalls
+	wait := Go(groupCtx, a, "long task", func(msg Message) {
+		if _, ok := msg.(*Text); ok {
+			cancel()
+		}
+	}, calls.done)
+	err = wait()
+	var cerr *CancelledError
+	if !errors.As(err, &cerr) || cerr.Reason != StopCancelled {
+		t.Fatalf("wait() error = %v, want a *CancelledError", err)
+	}
+	if calls.calls != 1 || calls.err != err || calls.result != nil {
+		t.Errorf("done called %d times with %+v, %v, want once with the error", calls.calls, calls.result, calls.err)
+	}
+	mu.Lock()
+	if len(drained) != 1 {
+		t.Fatalf("run.drained events = %d, want 1", len(drained))
+	}
+	if data := drained[0].Data.(map[string]any); data["completed"] != true || data["discarded"] != 1 {
+		t.Errorf("run.drained data = %v, want the Stopping text discarded up to the result", data)
+	}
+	mu.Unlock()
+
+	// The interrupted turn's output is gone, so the next run gets its own
+	result, err := a.Run(ctx, "next")
+	if err != nil {
+		t.Fatalf("Run() after the cancelled run error = %v", err)
+	}
+	if result.ResultText != "second" {
+		t.Errorf("Run() result = %q, want second rather than the interrupted turn's", result.ResultText)
+	}
+}
+
+func TestGoSinkPanic(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(interruptCLI(t)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var calls doneCalls
+	err = Go(ctx, a, "long task", func(msg Message) {
+		if _, ok := msg.(*Text); ok {
+			panic("render failed")
+		}
+	}, calls.done)()
+	var perr *SinkPanicError
+	if !errors.As(err, &perr) || perr.Value != "render failed" || perr.SessionID != "grouped" {
+		t.Fatalf("wait() error = %v, want a *SinkPanicError", err)
+	}
+	if calls.calls != 1 || calls.err != err {
+		t.Errorf("done called %d times with %v, want once with the panic", calls.calls, calls.err)
+	}
+
+	// The run was cancelled and drained like a cancelled group's
+	result, err := a.Run(ctx, "next")
+	if err != nil || result.ResultText != "second" {
+		t.Errorf("Run() after the panic = %+v, %v, want the second result", result, err)
+	}
+}
@@ -0,0 +1,157 @@
+package agent
+
+import "time"
+
+// HeartbeatMsg reports that a run is still in progress although the CLI
+// has sent nothing for a while, for example during a long tool execution.
+// It is delivered on the Stream channel only with HeartbeatMessages.
+type HeartbeatMsg struct {
+	MessageMeta
+	Silence time.Duration // Time since the CLI last sent a message
+
+	// The oldest tool call of the run that has no result yet, if any
+	ToolUseID   string
+	Tool        string
+	ToolElapsed time.Duration // Time since the tool call was made
+}
+
+func (HeartbeatMsg) message() {}
+
+// Heartbeat emits a session.heartbeat audit event whenever a run has
+// received nothing from the CLI for interval, and again every interval
+// while the silence lasts, so that an orchestrator can tell a run that is
+// still working from a hung one. The event has the silence_seconds and the
+// oldest tool call without a result as tool, tool_use_id, and
+// tool_seconds. Heartbeats stop when the run ends and are never sent
+// between runs. A value of 0 or less turns them off (default).
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Heartbeat(30*time.Second), agent.HeartbeatMessages(true))
+func Heartbeat(interval time.Duration) Option {
+	return func(c *config) {
+		c.heartbeat = interval
+	}
+}
+
+// HeartbeatMessages also delivers each heartbeat as a *HeartbeatMsg on the
+// Stream channel, for example to show "still running Bash (93s)" in a UI.
+// Like ToolProgress, a heartbeat is dropped rather than waited for if the
+// caller is not keeping up, and its DeliveredSequence is 0.
+func HeartbeatMessages(deliver bool) Option {
+	return func(c *config) {
+		c.heartbeatMessages = deliver
+	}
+}
+
+// heartbeatWatch tracks the silence and pending tool calls of a run. It is
+// used only by the run's stream goroutine.
+type heartbeatWatch struct {
+	interval time.Duration
+	timer    *time.Timer
+	last     time.Time // When the CLI last sent a message
+	pending  []pendingTool
+}
+
+// pendingTool is a tool call without a result.
+type pendingTool struct {
+	id, name string
+	start    time.Time
+}
+
+// newHeartbeatWatch starts the heartbeat timer, or returns nil if
+// heartbeats are off.
+func newHeartbeatWatch(interval time.Duration) *heartbeatWatch {
+	if interval <= 0 {
+		return nil
+	}
+	return &heartbeatWatch{
+		interval: interval,
+		timer:    time.NewTimer(interval),
+		last:     time.Now(),
+	}
+}
+
+// beat returns the channel that fires when a heartbeat is due, or nil
+// (never ready) without heartbeats.
+func (w *heartbeatWatch) beat() <-chan time.Time {
+	if w == nil {
+		return nil
+	}
+	return w.timer.C
+}
+
+// seen records a message from the CLI and restarts the silence.
+func (w *heartbeatWatch) seen(msg Message) {
+	if w == nil {
+		return
+	}
+	w.last = time.Now()
+	if !w.timer.Stop() {
+		select {
+		case <-w.timer.C:
+		default:
+		}
+	}
+	w.timer.Reset(w.interval)
+
+	switch m := msg.(type) {
+	case *ToolUse:
+		w.pending = append(w.pending, pendingTool{id: m.ID, name: m.Name, start: w.last})
+	case *ToolResult:
+		for i, p := range w.pending {
+			if p.id == m.ToolUseID {
+				w.pending = append(w.pending[:i], w.pending[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// fired returns the heartbeat that is due and arms the timer for the next.
+func (w *heartbeatWatch) fired() *HeartbeatMsg {
+	w.timer.Reset(w.interval)
+	now := time.Now()
+	hb := &HeartbeatMsg{
+		MessageMeta: MessageMeta{Timestamp: now},
+		Silence:     now.Sub(w.last),
+	}
+	if len(w.pending) > 0 {
+		p := w.pending[0]
+		hb.ToolUseID, hb.Tool, hb.ToolElapsed = p.id, p.name, now.Sub(p.start)
+	}
+	return hb
+}
+
+// stop releases the timer.
+func (w *heartbeatWatch) stop() {
+	if w != nil {
+		w.timer.Stop()
+	}
+}
+
+// heartbeat reports a due heartbeat with an audit event and, with
+// HeartbeatMessages, on out if the run delivers heartbeats and out has
+// room.
+func (a *Agent) heartbeat(w *heartbeatWatch, out chan<- Message, rc *runConfig) {
+	hb := w.fired()
+	hb.SessionID = a.SessionID()
+
+	event := map[string]any{
+		"silence_seconds": hb.Silence.Seconds(),
+	}
+	if hb.Tool != "" {
+		event["tool"] = hb.Tool
+		event["tool_use_id"] = hb.ToolUseID
+		event["tool_seconds"] = hb.ToolElapsed.Seconds()
+	}
+	a.auditor.emit(hb.SessionID, "session.heartbeat", event)
+
+	if !a.cfg.heartbeatMessages || !rc.delivers(hb) {
+		return
+	}
+	select {
+	case out <- hb:
+	default: // The caller is not keeping up; a heartbeat is not worth blocking for
+	}
+}
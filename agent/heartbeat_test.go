@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	// Bash runs for 350ms, then the turn ends after another 250ms of
+	// silence with no tool pending
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"heartbeat-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Bash","input":{"command":"make"}}]}}'
+sleep 0.35
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"ok"}]}}'
+sleep 0.25
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	const interval = 100 * time.Millisecond
+	var mu sync.Mutex
+	var events []map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Heartbeat(interval),
+		HeartbeatMessages(true),
+		Audit(func(e AuditEvent) {
+			if e.Type == "session.heartbeat" {
+				mu.Lock()
+				events = append(events, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var beats []*HeartbeatMsg
+	for msg := range a.Stream(ctx, "build") {
+		if hb, ok := msg.(*HeartbeatMsg); ok {
+			beats = append(beats, hb)
+		}
+	}
+
+	// Heartbeats come while Bash runs and again after its result
+	if len(beats) < 3 {
+		t.Fatalf("got %d heartbeats, want at least 3", len(beats))
+	}
+	for i, hb := range beats[:2] {
+		want := time.Duration(i+1) * interval
+		if hb.Tool != "Bash" || hb.ToolUseID != "tu-1" || hb.Silence < want || hb.ToolElapsed < want {
+			t.Errorf("heartbeat %d = %+v, want Bash silent for at least %v", i, hb, want)
+		}
+		if hb.SessionID != "heartbeat-test" || hb.DeliveredSequence != 0 {
+			t.Errorf("heartbeat %d meta = %+v, want the session and no sequence", i, hb.MessageMeta)
+		}
+	}
+	if last := beats[len(beats)-1]; last.Tool != "" {
+		t.Errorf("heartbeat after the tool result names %s, want no pending tool", last.Tool)
+	}
+
+	mu.Lock()
+	n := len(events)
+	if n != len(beats) {
+		t.Errorf("got %d session.heartbeat events, want %d", n, len(beats))
+	}
+	if n > 0 && (events[0]["tool"] != "Bash" || events[0]["silence_seconds"].(float64) < interval.Seconds()) {
+		t.Errorf("first session.heartbeat = %v, want Bash and silence_seconds", events[0])
+	}
+	mu.Unlock()
+
+	// The agent is idle between runs
+	time.Sleep(3 * interval)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != n {
+		t.Errorf("got %d session.heartbeat events after the run, want none", len(events)-n)
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import "sort"
+
+// PreToolUse hook priority bands. Hooks with a higher priority run first;
+// hooks with the same priority run in the order they were registered.
+const (
+	// PrioritySecurity is the default priority of the built-in hooks that
+	// deny calls: DenyCommands, DenyCommandsFile, DenyPaths, AllowPaths,
+	// AllowPathsFile, RequireCommand, and DenyWhen. They run before hooks
+	// that may Allow a call and skip them.
+	PrioritySecurity = 100
+	// PriorityDefault is the priority of functions registered with
+	// PreToolUse and of RedirectPath, RedirectWhen, AllowWhen, and
+	// RequireApproval.
+	PriorityDefault = 0
+	// PriorityObserve is meant for hooks that only log or measure calls
+	// and always return Continue, so that they see the input as changed by
+	// the other hooks. They do not see calls an earlier hook allowed or
+	// denied.
+	PriorityObserve = -100
+)
+
+// BuiltinHook is a PreToolUse hook returned by a built-in builder, such as
+// DenyCommands, AllowPaths, or RedirectPath. It carries the builder's
+// default priority, which PreToolUse records when it registers the hook.
+// Use Evaluate to call it from a hook of your own; a hook that wraps it has
+// PriorityDefault.
+type BuiltinHook struct {
+	priority int
+	eval     PreToolUseHook
+
+	// Builder arguments, recorded in config when the hook is registered
+	allow    []string      // AllowPaths directories
+	deny     []string      // DenyPaths directories
+	redirect *redirectRule // RedirectPath rule
+}
+
+// builtinHook returns a BuiltinHook with the given default priority.
+func builtinHook(priority int, eval PreToolUseHook) *BuiltinHook {
+	return &BuiltinHook{priority: priority, eval: eval}
+}
+
+// Evaluate runs the hook against a tool call.
+func (h *BuiltinHook) Evaluate(tc *ToolCall) HookResult {
+	return h.eval(tc)
+}
+
+// PreToolUseHooks is the type of the hooks PreToolUse and
+// PreToolUsePriority accept: PreToolUseHook functions, or the BuiltinHook
+// values the built-in builders return. The hooks of one call have one
+// type; register functions and built-in hooks with separate calls.
+type PreToolUseHooks interface {
+	PreToolUseHook | func(*ToolCall) HookResult | *BuiltinHook
+}
+
+// PreToolUsePriority adds PreToolUse hooks with the given priority,
+// overriding the default priority of built-in hooks. The chain is sorted
+// once by New: hooks with a higher priority run first, and hooks with the
+// same priority run in the order they were registered, whether with
+// PreToolUse, PreToolUsePriority, or OnEvent.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PreToolUsePriority(agent.PriorityObserve, logCalls),
+//	    agent.PreToolUse(allowReads),
+//	    agent.PreToolUse(agent.DenyCommands("sudo")), // Still runs before allowReads
+//	)
+func PreToolUsePriority[H PreToolUseHooks](priority int, hooks ...H) Option {
+	return func(c *config) {
+		for _, hook := range hooks {
+			eval, builtin := splitHook(hook)
+			c.addPreToolUse(priority, eval, builtin)
+		}
+	}
+}
+
+// splitHook returns the function of a hook and, for a built-in hook, the
+// BuiltinHook that carries its default priority.
+func splitHook[H PreToolUseHooks](hook H) (PreToolUseHook, *BuiltinHook) {
+	switch h := any(hook).(type) {
+	case *BuiltinHook:
+		if h == nil {
+			return nil, nil
+		}
+		return h.eval, h
+	case PreToolUseHook:
+		return h, nil
+	case func(*ToolCall) HookResult:
+		return h, nil
+	}
+	return nil, nil
+}
+
+// addPreToolUse registers a hook with priority. For a built-in hook, it
+// also records the builder's arguments.
+func (c *config) addPreToolUse(priority int, hook PreToolUseHook, builtin *BuiltinHook) {
+	c.preToolUseHooks = append(c.preToolUseHooks, hook)
+	c.preToolUsePriorities = append(c.preToolUsePriorities, priority)
+	var redirect *redirectRule
+	if builtin != nil {
+		c.pathHooks.allow = append(c.pathHooks.allow, builtin.allow...)
+		c.pathHooks.deny = append(c.pathHooks.deny, builtin.deny...)
+		redirect = builtin.redirect
+	}
+	c.preToolUseRedirects = append(c.preToolUseRedirects, redirect)
+}
+
+// sortedPreToolUseHooks returns the PreToolUse hooks in evaluation order.
+// The MCPAllowTools and MCPDenyTools hooks come first among those with
+// PrioritySecurity. Each RedirectPath hook is replaced by one that picks
+// the longest match among its rule and those of the RedirectPath hooks
+// after it.
+func (c *config) sortedPreToolUseHooks() []PreToolUseHook {
+	all := append(mcpToolHooks(c), c.preToolUseHooks...)
+	builtin := len(all) - len(c.preToolUseHooks)
+	order := make([]int, len(all))
+	for i := range order {
+		order[i] = i
+	}
+	priority := func(i int) int {
+		if i < builtin {
+			return PrioritySecurity
+		}
+		if i-builtin < len(c.preToolUsePriorities) {
+			return c.preToolUsePriorities[i-builtin]
+		}
+		return PriorityDefault
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return priority(order[i]) > priority(order[j])
+	})
+
+	hooks := make([]PreToolUseHook, len(order))
+	var rules []redirectRule
+	for i := len(order) - 1; i >= 0; i-- {
+		idx := order[i]
+		hooks[i] = all[idx]
+		if j := idx - builtin; j >= 0 && j < len(c.preToolUseRedirects) && c.preToolUseRedirects[j] != nil {
+			rules = append([]redirectRule{*c.preToolUseRedirects[j]}, rules...)
+			if len(rules) > 1 {
+				hooks[i] = redirectPaths(rules)
+			}
+		}
+	}
+	return hooks
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// allowEverything is a user hook that short-circuits the chain.
+func allowEverything(*ToolCall) HookResult {
+	return HookResult{Decision: Allow}
+}
+
+func TestBuiltinHooksRunBeforeUserHooks(t *testing.T) {
+	denyWhen, err := DenyWhen(`tool == 'Bash' && input.command.contains('rm')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := filepath.Join(t.TempDir(), "patterns")
+	mustWriteFile(t, patterns, []byte("rm\n"), 0644)
+
+	bash := &ToolCall{Name: ToolBash, Input: map[string]any{"command": "rm -rf /"}}
+	write := &ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/etc/passwd"}}
+	tests := []struct {
+		name string
+		hook *BuiltinHook
+		tc   *ToolCall
+	}{
+		{"DenyCommands", DenyCommands("rm"), bash},
+		{"DenyCommandsFile", DenyCommandsFile(patterns), bash},
+		{"RequireCommand", RequireCommand("trash", "rm"), bash},
+		{"DenyWhen", denyWhen, bash},
+		{"DenyPaths", DenyPaths("/etc"), write},
+		{"AllowPaths", AllowPaths("/sandbox"), write},
+		{"AllowPathsFile", AllowPathsFile(patterns), write},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Registered after a hook that allows everything
+			cfg := newConfig(PreToolUse(allowEverything), PreToolUse(tt.hook))
+			if got := newHookChain(cfg.sortedPreToolUseHooks()).evaluate(tt.tc); got.Decision != Deny {
+				t.Errorf("Decision = %v, want deny by %s", got.Decision, tt.name)
+			}
+		})
+	}
+}
+
+func TestPreToolUsePriority(t *testing.T) {
+	var order []string
+	record := func(name string) PreToolUseHook {
+		return func(*ToolCall) HookResult {
+			order = append(order, name)
+			return HookResult{Decision: Continue}
+		}
+	}
+	bash := func() *ToolCall { return &ToolCall{Name: ToolBash, Input: map[string]any{"command": "rm -rf /"}} }
+
+	cfg := newConfig(
+		PreToolUsePriority(PriorityObserve, record("log")),
+		PreToolUse(record("a")),
+		OnEvent(func(context.Context, ToolCallEvent) Outcome {
+			order = append(order, "event")
+			return Outcome{}
+		}),
+		PreToolUsePriority(PrioritySecurity+1, record("first")),
+		PreToolUse(record("b")),
+	)
+	newHookChain(cfg.sortedPreToolUseHooks()).evaluate(bash())
+	if got := strings.Join(order, ","); got != "first,a,event,b,log" {
+		t.Errorf("order = %s, want first,a,event,b,log", got)
+	}
+
+	// Explicit priorities override the built-in defaults
+	tests := []struct {
+		name string
+		opts []Option
+		want Decision
+	}{
+		{"user hook raised", []Option{PreToolUse(DenyCommands("rm")), PreToolUsePriority(PrioritySecurity+1, allowEverything)}, Allow},
+		{"builtin lowered", []Option{PreToolUse(allowEverything), PreToolUsePriority(PriorityDefault, DenyCommands("rm"))}, Allow},
+		{"same band", []Option{PreToolUsePriority(PrioritySecurity, allowEverything), PreToolUse(DenyCommands("rm"))}, Allow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(tt.opts...)
+			if got := newHookChain(cfg.sortedPreToolUseHooks()).evaluate(bash()); got.Decision != tt.want {
+				t.Errorf("Decision = %v, want %v", got.Decision, tt.want)
+			}
+			if got := newHookChain(cfg.clone().sortedPreToolUseHooks()).evaluate(bash()); got.Decision != tt.want {
+				t.Errorf("clone Decision = %v, want %v", got.Decision, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathChecksRunBeforeRedirectPath(t *testing.T) {
+	// Registered in this order, RedirectPath used to rewrite and allow the
+	// call before AllowPaths saw it; AllowPaths now runs first
+	cfg := newConfig(PreToolUse(RedirectPath("/tmp", "/sandbox/tmp"), AllowPaths("/sandbox")))
+	tc := &ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/tmp/out.txt"}}
+	if got := newHookChain(cfg.sortedPreToolUseHooks()).evaluate(tc); got.Decision != Deny {
+		t.Errorf("Decision = %v, want deny by AllowPaths", got.Decision)
+	}
+
+	// A priority above PrioritySecurity keeps the rewrite first
+	cfg = newConfig(PreToolUsePriority(PrioritySecurity+1, RedirectPath("/tmp", "/sandbox/tmp")), PreToolUse(AllowPaths("/sandbox")))
+	tc = &ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/tmp/out.txt"}}
+	got := newHookChain(cfg.sortedPreToolUseHooks()).evaluate(tc)
+	if got.Decision != Allow || got.UpdatedInput["file_path"] != "/sandbox/tmp/out.txt" {
+		t.Errorf("result = %+v, want Allow with the rewritten path", got)
+	}
+}
+
+func TestHookPriorityAtNew(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	script := `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"priority-test"}'
+echo '{"type":"control","request_id":"req_1","tool_name":"Bash","tool_input":{"command":"sudo ls"}}'
+read -r response
+printf '%s\n' "$response" >> ` + wire + `
+echo '{"type":"result","result":"Done","num_turns":1}'
+read -r line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), PreToolUse(allowEverything), PreToolUse(DenyCommands("sudo")))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "list files"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := `{"request_id":"req_1","decision":"deny","reason":"command contains blocked pattern: sudo"}` + "\n"
+	if got := string(mustReadFile(t, wire)); got != want {
+		t.Errorf("wire = %s, want %s", got, want)
+	}
+}
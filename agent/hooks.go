@@ -1,6 +1,9 @@
 package agent
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Decision represents the outcome of a hook evaluation.
 type Decision int
@@ -28,10 +31,64 @@ func (d Decision) String() string {
 	}
 }
 
+// AgentKind identifies whether a tool call was made by the main agent or a subagent.
+type AgentKind string
+
+const (
+	// AgentMain is the top-level agent started by Run or Stream.
+	AgentMain AgentKind = "main"
+	// AgentSubagent is an agent spawned by the main agent (e.g., via the Task tool).
+	AgentSubagent AgentKind = "subagent"
+)
+
 // ToolCall represents a tool invocation that can be intercepted by hooks.
 type ToolCall struct {
 	Name  string
 	Input map[string]any
+
+	// ID is the tool_use ID, or empty if the CLI did not provide it.
+	ID string
+	// ParentToolUseID is the ID of the tool_use that spawned the subagent
+	// making this call. Empty for calls made by the main agent.
+	ParentToolUseID string
+	// AgentKind reports whether the main agent or a subagent made the call.
+	AgentKind AgentKind
+	// SubagentType is the subagent type (e.g., "Explore"), when known.
+	SubagentType string
+
+	ctx             context.Context // Context of the Stream call that made the request
+	workDir         string          // Agent WorkDir, for resolving relative paths
+	resolveSymlinks bool            // Set by ResolvePathSymlinks
+	values          runValues       // Set with RunValue
+	runTools        *runToolPolicy  // Set with ToolsRun and DisallowToolsRun
+	rewrite         *pathRewrite    // Set by RedirectPath hooks that rewrote the path
+
+	audit func(eventType string, data map[string]any) // Emits an agent audit event; nil outside an agent
+}
+
+// emit emits an audit event for the agent that made the call, if any.
+func (tc *ToolCall) emit(eventType string, data map[string]any) {
+	if tc.audit != nil {
+		tc.audit(eventType, data)
+	}
+}
+
+// Context returns the context of the Run or Stream call that made the tool
+// call. Hooks that block, such as approval prompts, should respect it.
+// It is never nil.
+func (tc *ToolCall) Context() context.Context {
+	if tc.ctx == nil {
+		return context.Background()
+	}
+	return tc.ctx
+}
+
+// agentKindFor derives the AgentKind from the parent context of a tool call.
+func agentKindFor(parentToolUseID, subagentType string) AgentKind {
+	if parentToolUseID != "" || subagentType != "" {
+		return AgentSubagent
+	}
+	return AgentMain
 }
 
 // HookResult is the outcome of evaluating a hook.
@@ -50,7 +107,8 @@ type PreToolUseHook func(*ToolCall) HookResult
 
 // hookChain evaluates multiple hooks in sequence.
 type hookChain struct {
-	hooks []PreToolUseHook
+	hooks   []PreToolUseHook
+	rewrite PreToolUseHook // Runs before hooks and is not timed, e.g. the ScratchDir rewrite (nil = none)
 }
 
 // newHookChain creates a new hook chain from the given hooks.
@@ -62,12 +120,28 @@ func newHookChain(hooks []PreToolUseHook) *hookChain {
 // First Deny wins, Allow short-circuits, Continue passes to next.
 // If all hooks return Continue, the result is Allow.
 func (c *hookChain) evaluate(tc *ToolCall) HookResult {
-	if len(c.hooks) == 0 {
-		return HookResult{Decision: Allow}
-	}
+	result, _ := c.evaluateTimed(tc, false)
+	return result
+}
 
+// evaluateTimed runs the hook chain like evaluate. If timed is set, it also
+// returns how long each hook that ran took, in chain order.
+func (c *hookChain) evaluateTimed(tc *ToolCall, timed bool) (HookResult, []time.Duration) {
 	// Track accumulated input updates
 	var accumulatedUpdates map[string]any
+	if c.rewrite != nil {
+		result := c.rewrite(tc)
+		if result.Decision == Deny {
+			return result, nil
+		}
+		accumulatedUpdates = result.UpdatedInput
+	}
+
+	if len(c.hooks) == 0 {
+		return HookResult{Decision: Allow, UpdatedInput: accumulatedUpdates}, nil
+	}
+
+	var durations []time.Duration
 
 	for _, hook := range c.hooks {
 		// Apply accumulated updates before each hook evaluation
@@ -75,12 +149,19 @@ func (c *hookChain) evaluate(tc *ToolCall) HookResult {
 			tc.Input = mergeInputs(tc.Input, accumulatedUpdates)
 		}
 
-		result := hook(tc)
+		var result HookResult
+		if timed {
+			start := time.Now()
+			result = hook(tc)
+			durations = append(durations, time.Since(start))
+		} else {
+			result = hook(tc)
+		}
 
 		switch result.Decision {
 		case Deny:
 			// First Deny wins immediately
-			return result
+			return result, durations
 		case Allow:
 			// Allow short-circuits, apply any final updates
 			if result.UpdatedInput != nil {
@@ -89,7 +170,7 @@ func (c *hookChain) evaluate(tc *ToolCall) HookResult {
 			return HookResult{
 				Decision:     Allow,
 				UpdatedInput: accumulatedUpdates,
-			}
+			}, durations
 		case Continue:
 			// Accumulate any input updates
 			if result.UpdatedInput != nil {
@@ -102,7 +183,7 @@ func (c *hookChain) evaluate(tc *ToolCall) HookResult {
 	return HookResult{
 		Decision:     Allow,
 		UpdatedInput: accumulatedUpdates,
-	}
+	}, durations
 }
 
 // mergeInputs merges two input maps, with updates taking precedence.
@@ -141,7 +222,13 @@ type ToolResultContext struct {
 	// IsError indicates whether the tool execution resulted in an error.
 	IsError bool
 	// Duration is how long the tool took to execute.
+	// For custom tools this is the in-process execution time only.
 	Duration time.Duration
+	// QueueDuration is how long a custom tool call waited for a concurrency
+	// slot before executing. It is zero for CLI-executed tools.
+	QueueDuration time.Duration
+
+	values runValues // Set with RunValue
 }
 
 // PostToolUseHook is called after a tool has executed.
@@ -163,12 +250,26 @@ func newPostToolUseChain(hooks []PostToolUseHook) *postToolUseChain {
 // evaluate runs the PostToolUse hook chain against a completed tool execution.
 // All hooks are called in order; the chain does not short-circuit.
 func (c *postToolUseChain) evaluate(tc *ToolCall, tr *ToolResultContext) {
+	c.evaluateTimed(tc, tr, false)
+}
+
+// evaluateTimed runs the chain like evaluate. If timed is set, it also
+// returns how long each hook took, in chain order.
+func (c *postToolUseChain) evaluateTimed(tc *ToolCall, tr *ToolResultContext, timed bool) []time.Duration {
 	if c == nil || len(c.hooks) == 0 {
-		return
+		return nil
 	}
+	var durations []time.Duration
 	for _, hook := range c.hooks {
+		if !timed {
+			hook(tc, tr)
+			continue
+		}
+		start := time.Now()
 		hook(tc, tr)
+		durations = append(durations, time.Since(start))
 	}
+	return durations
 }
 
 // StopReason describes why an agent session ended.
@@ -179,10 +280,27 @@ const (
 	StopCompleted StopReason = "completed"
 	// StopMaxTurns indicates the session was stopped because max turns was reached.
 	StopMaxTurns StopReason = "max_turns"
-	// StopInterrupted indicates the session was interrupted (e.g., context cancelled).
+	// StopInterrupted indicates the session was interrupted.
+	//
+	// Deprecated: Runs cut short end with StopCancelled, StopTimeout, or
+	// StopShutdown, which say why.
 	StopInterrupted StopReason = "interrupted"
 	// StopError indicates the session ended due to an error.
 	StopError StopReason = "error"
+	// StopCancelled indicates a run was cancelled, through its context or
+	// Agent.Cancel.
+	StopCancelled StopReason = "cancelled"
+	// StopTimeout indicates a run's deadline passed, such as its Timeout,
+	// its SoftDeadline plus grace, or a context deadline.
+	StopTimeout StopReason = "timeout"
+	// StopShutdown indicates the agent was closed while a run was in progress.
+	StopShutdown StopReason = "shutdown"
+	// StopIdle indicates the agent was closed by IdleTimeout after no run
+	// for the timeout.
+	StopIdle StopReason = "idle"
+	// StopDenied indicates a run ended in an error Result after a
+	// PreToolUse hook denied one of its tool calls; see HookInterruptError.
+	StopDenied StopReason = "denied"
 )
 
 // StopEvent provides context about why an agent session ended.
@@ -191,10 +309,17 @@ type StopEvent struct {
 	SessionID string
 	// Reason describes why the session ended.
 	Reason StopReason
+	// Cause says why a run was cut short, from Agent.Cancel or the cause
+	// its context was cancelled with. It is empty for other reasons.
+	Cause string
 	// NumTurns is the total number of turns in the session.
 	NumTurns int
 	// CostUSD is the total cost of the session in USD.
 	CostUSD float64
+	// Labels holds a copy of the agent's labels.
+	Labels map[string]string
+
+	values runValues // Set with RunValue on the last run
 }
 
 // StopHook is called when an agent session ends.
@@ -213,13 +338,19 @@ type PreCompactEvent struct {
 	TranscriptPath string
 	// TokenCount is the approximate token count before compaction.
 	TokenCount int
+	// ScratchDir is the agent's ScratchDir, a place for ArchiveTo that
+	// Close cleans up, or "" if it has none.
+	ScratchDir string
 }
 
 // PreCompactResult is returned from PreCompact hooks.
 type PreCompactResult struct {
 	// Archive indicates whether to archive the current transcript.
 	Archive bool
-	// ArchiveTo is the path to archive the transcript to (if Archive is true).
+	// ArchiveTo is the path to archive the transcript to (if Archive is
+	// true). The agent copies the transcript there, creating the directory
+	// and masking thinking content as ThinkingPolicy requires, and reports
+	// the copy as a compact.archived or compact.archive_failed audit event.
 	ArchiveTo string
 	// Extract allows the hook to extract and preserve arbitrary data
 	// from the pre-compaction state.
@@ -254,6 +385,25 @@ func (c *preCompactChain) evaluate(e *PreCompactEvent) []PreCompactResult {
 	return results
 }
 
+// SubagentStartEvent provides context about a subagent Claude started.
+type SubagentStartEvent struct {
+	// SessionID is the parent session identifier.
+	SessionID string
+	// SubagentType is the name of the configured subagent.
+	SubagentType string
+	// ParentToolUseID is the ID of the Task tool_use that started the
+	// subagent; SubagentStopEvent.ParentToolUseID matches it.
+	ParentToolUseID string
+	// Description is the Task's short description of the work.
+	Description string
+	// StartedAt is when the Task tool_use arrived.
+	StartedAt time.Time
+}
+
+// SubagentStartHook is called when a configured subagent starts. These
+// hooks are for observation and logging.
+type SubagentStartHook func(*SubagentStartEvent)
+
 // SubagentStopEvent provides context about a completed subagent execution.
 type SubagentStopEvent struct {
 	// SessionID is the parent session identifier.
@@ -302,8 +452,22 @@ type PromptSubmitEvent struct {
 	Prompt string
 	// SessionID is the session identifier.
 	SessionID string
+	// RunID identifies the Run or Stream call submitting the prompt.
+	RunID string
 	// Turn is the current turn number.
 	Turn int
+
+	ctx    context.Context // Context of the Run or Stream call
+	values runValues       // Set with RunValue
+}
+
+// Context returns the context of the Run or Stream call submitting the
+// prompt. It is never nil.
+func (e *PromptSubmitEvent) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
 // PromptSubmitResult is returned from UserPromptSubmit hooks.
@@ -346,7 +510,10 @@ func (c *promptSubmitChain) evaluate(e *PromptSubmitEvent) (string, []any) {
 		event := &PromptSubmitEvent{
 			Prompt:    currentPrompt,
 			SessionID: e.SessionID,
+			RunID:     e.RunID,
 			Turn:      e.Turn,
+			ctx:       e.ctx,
+			values:    e.values,
 		}
 		result := hook(event)
 
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+)
+
+// DefaultApprovalTimeout is how long RequireApproval waits for a decision
+// before applying the timeout default.
+const DefaultApprovalTimeout = 5 * time.Minute
+
+// ApprovalFunc decides whether a tool call may proceed. It may block, e.g.
+// while waiting for a human, but should return when ctx is done.
+type ApprovalFunc func(ctx context.Context, tc *ToolCall) bool
+
+// approvalConfig holds RequireApproval settings.
+type approvalConfig struct {
+	timeout   time.Duration
+	onTimeout bool
+}
+
+// ApprovalOption configures RequireApproval.
+type ApprovalOption func(*approvalConfig)
+
+// ApprovalTimeout sets how long to wait for the approval callback and the
+// decision to apply when it does not answer in time (true allows, false
+// denies). The default is DefaultApprovalTimeout, denying on timeout.
+// A timeout of 0 waits indefinitely.
+func ApprovalTimeout(d time.Duration, approve bool) ApprovalOption {
+	return func(c *approvalConfig) {
+		c.timeout = d
+		c.onTimeout = approve
+	}
+}
+
+// RequireApproval returns a PreToolUseHook that defers file writes matching
+// any of the glob patterns to an approval callback. For Write, Edit,
+// MultiEdit, and NotebookEdit calls whose path matches, fn is called with the
+// Stream context: true allows the call and false denies it. Calls to other
+// tools or paths continue to the next hook.
+//
+// Patterns use path.Match syntax. An absolute pattern is matched against the
+// whole path; a relative pattern is matched against the path's trailing
+// elements, so "go.mod" matches "/repo/go.mod" and ".github/workflows/*"
+// matches "/repo/.github/workflows/ci.yml".
+//
+// If the Stream context is cancelled while fn is running, the call is denied.
+//
+// Example:
+//
+//	agent.PreToolUse(
+//	    agent.RequireApproval(
+//	        []string{"go.mod", ".github/workflows/*", "migrations/*"},
+//	        func(ctx context.Context, tc *agent.ToolCall) bool {
+//	            return askUser(ctx, tc)
+//	        },
+//	        agent.ApprovalTimeout(time.Minute, false),
+//	    ),
+//	)
+func RequireApproval(patterns []string, fn ApprovalFunc, opts ...ApprovalOption) PreToolUseHook {
+	cfg := &approvalConfig{timeout: DefaultApprovalTimeout}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(tc *ToolCall) HookResult {
+		if !isFileMutationTool(tc.Name) {
+			return HookResult{Decision: Continue}
+		}
+
+		p, ok := extractPath(tc.Input)
+		if !ok {
+			p, ok = tc.Input["notebook_path"].(string)
+		}
+		if !ok || !matchAnyGlob(patterns, p) {
+			return HookResult{Decision: Continue}
+		}
+
+		return requestApproval(tc, p, fn, cfg)
+	}
+}
+
+// requestApproval calls fn and waits for its answer, the timeout, or
+// cancellation of the Stream context.
+func requestApproval(tc *ToolCall, p string, fn ApprovalFunc, cfg *approvalConfig) HookResult {
+	parent := tc.Context()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if cfg.timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, cfg.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	answer := make(chan bool, 1) // Buffered so a late answer does not leak the goroutine
+	go func() {
+		answer <- fn(ctx, tc)
+	}()
+
+	select {
+	case approved := <-answer:
+		return approvalResult(approved, "approval denied for path: "+p)
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			return HookResult{Decision: Deny, Reason: "approval cancelled for path: " + p}
+		}
+		return approvalResult(cfg.onTimeout, "approval timed out for path: "+p)
+	}
+}
+
+// approvalResult converts an approval decision to a HookResult.
+func approvalResult(approved bool, reason string) HookResult {
+	if approved {
+		return HookResult{Decision: Allow}
+	}
+	return HookResult{Decision: Deny, Reason: reason}
+}
+
+// matchAnyGlob reports whether p matches any of the patterns.
+func matchAnyGlob(patterns []string, p string) bool {
+	p = path.Clean(p)
+	for _, pattern := range patterns {
+		if matchGlob(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches an absolute pattern against the whole path and a
+// relative pattern against the path's trailing elements.
+func matchGlob(pattern, p string) bool {
+	if strings.HasPrefix(pattern, "/") {
+		ok, _ := path.Match(pattern, p) // Malformed patterns never match
+		return ok
+	}
+
+	elems := strings.Split(p, "/")
+	n := strings.Count(pattern, "/") + 1
+	if n > len(elems) {
+		return false
+	}
+	ok, _ := path.Match(pattern, strings.Join(elems[len(elems)-n:], "/")) // Malformed patterns never match
+	return ok
+}
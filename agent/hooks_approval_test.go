@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"go.mod", "/repo/go.mod", true},
+		{"go.mod", "go.mod", true},
+		{"go.mod", "/repo/go.sum", false},
+		{".github/workflows/*", "/repo/.github/workflows/ci.yml", true},
+		{".github/workflows/*", "/repo/.github/ci.yml", false},
+		{"migrations/*", "/repo/db/migrations/001_init.sql", true},
+		{"migrations/*", "/repo/migrations/2024/001.sql", false},
+		{"/etc/*.conf", "/etc/app.conf", true},
+		{"/etc/*.conf", "/opt/etc/app.conf", false},
+		{"a/b/c/d", "/b/c/d", false},
+		{"[", "/repo/[", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequireApprovalMatch(t *testing.T) {
+	var asked []string
+	hook := RequireApproval([]string{"go.mod", "migrations/*"}, func(_ context.Context, tc *ToolCall) bool {
+		path, _ := extractPath(tc.Input)
+		asked = append(asked, path)
+		return strings.HasSuffix(path, "go.mod")
+	})
+
+	result := hook(&ToolCall{Name: "Edit", Input: map[string]any{"file_path": "/repo/go.mod"}})
+	if result.Decision != Allow {
+		t.Errorf("approved call: got %v, want Allow", result.Decision)
+	}
+
+	result = hook(&ToolCall{Name: "Write", Input: map[string]any{"file_path": "/repo/migrations/002.sql"}})
+	if result.Decision != Deny {
+		t.Errorf("rejected call: got %v, want Deny", result.Decision)
+	}
+	if result.Reason != "approval denied for path: /repo/migrations/002.sql" {
+		t.Errorf("Reason = %q", result.Reason)
+	}
+
+	if len(asked) != 2 {
+		t.Errorf("callback called %d times, want 2", len(asked))
+	}
+}
+
+func TestRequireApprovalNonMatching(t *testing.T) {
+	called := false
+	hook := RequireApproval([]string{"go.mod"}, func(context.Context, *ToolCall) bool {
+		called = true
+		return false
+	})
+
+	calls := []*ToolCall{
+		{Name: "Edit", Input: map[string]any{"file_path": "/repo/main.go"}},
+		{Name: "Read", Input: map[string]any{"file_path": "/repo/go.mod"}},
+		{Name: "Bash", Input: map[string]any{"command": "echo > go.mod"}},
+		{Name: "Write", Input: map[string]any{"content": "no path"}},
+	}
+	for _, tc := range calls {
+		if result := hook(tc); result.Decision != Continue {
+			t.Errorf("%s %v: got %v, want Continue", tc.Name, tc.Input, result.Decision)
+		}
+	}
+	if called {
+		t.Error("callback should not be called for non-matching calls")
+	}
+}
+
+func TestRequireApprovalTimeoutDefault(t *testing.T) {
+	blocking := func(ctx context.Context, _ *ToolCall) bool {
+		<-ctx.Done()
+		return false
+	}
+	tc := func() *ToolCall {
+		return &ToolCall{Name: "Write", Input: map[string]any{"file_path": "/repo/go.mod"}}
+	}
+
+	deny := RequireApproval([]string{"go.mod"}, blocking, ApprovalTimeout(10*time.Millisecond, false))
+	result := deny(tc())
+	if result.Decision != Deny || !strings.Contains(result.Reason, "timed out") {
+		t.Errorf("deny on timeout: got %v %q, want Deny with timeout reason", result.Decision, result.Reason)
+	}
+
+	allow := RequireApproval([]string{"go.mod"}, blocking, ApprovalTimeout(10*time.Millisecond, true))
+	if result := allow(tc()); result.Decision != Allow {
+		t.Errorf("allow on timeout: got %v, want Allow", result.Decision)
+	}
+}
+
+func TestRequireApprovalContextCancelled(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	// A callback that ignores its context, like a human who never answers
+	hook := RequireApproval([]string{"go.mod"}, func(context.Context, *ToolCall) bool {
+		close(started)
+		<-release
+		return true
+	}, ApprovalTimeout(0, true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	tc := &ToolCall{Name: "Edit", Input: map[string]any{"file_path": "/repo/go.mod"}, ctx: ctx}
+	result := hook(tc)
+	if result.Decision != Deny || !strings.Contains(result.Reason, "cancelled") {
+		t.Errorf("got %v %q, want Deny with cancellation reason", result.Decision, result.Reason)
+	}
+}
+
+func TestToolCallContextDefault(t *testing.T) {
+	tc := &ToolCall{Name: "Read"}
+	if tc.Context() == nil {
+		t.Error("Context() should never be nil")
+	}
+}
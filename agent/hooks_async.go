@@ -0,0 +1,126 @@
+package agent
+
+import "sync"
+
+// QueuePolicy decides what AsyncHooks does when its queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the Stream goroutine wait for room in the queue.
+	// No events are lost.
+	QueueBlock QueuePolicy = iota
+	// QueueDropOldest discards the oldest queued event to make room.
+	// Message delivery never waits on handlers.
+	QueueDropOldest
+)
+
+// AsyncOption configures AsyncHooks.
+type AsyncOption func(*asyncConfig)
+
+// asyncConfig holds the worker pool settings from AsyncHooks.
+type asyncConfig struct {
+	workers   int
+	queueSize int
+	policy    QueuePolicy
+}
+
+// AsyncQueuePolicy sets what happens when the AsyncHooks queue is full.
+// The default is QueueBlock.
+func AsyncQueuePolicy(p QueuePolicy) AsyncOption {
+	return func(c *asyncConfig) {
+		c.policy = p
+	}
+}
+
+// hookPool runs queued handler calls on a fixed set of workers.
+type hookPool struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []func()
+	size    int
+	policy  QueuePolicy
+	closed  bool
+	dropped int
+	wg      sync.WaitGroup
+}
+
+// newHookPool starts the workers. Values below 1 are raised to 1.
+func newHookPool(cfg *asyncConfig) *hookPool {
+	workers := max(cfg.workers, 1)
+	p := &hookPool{
+		size:   max(cfg.queueSize, 1),
+		policy: cfg.policy,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit queues fn. Once the pool is closed, fn runs on the caller's
+// goroutine so late events are still delivered.
+func (p *hookPool) submit(fn func()) {
+	p.mu.Lock()
+	for !p.closed && len(p.queue) >= p.size {
+		if p.policy == QueueDropOldest {
+			p.queue = p.queue[1:]
+			p.dropped++
+			break
+		}
+		p.cond.Wait()
+	}
+	if p.closed {
+		p.mu.Unlock()
+		runRecovered(fn)
+		return
+	}
+	p.queue = append(p.queue, fn)
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// work runs queued calls until the pool is closed and drained.
+func (p *hookPool) work() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		fn := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		p.cond.Broadcast() // Wake a blocked submit
+
+		runRecovered(fn)
+	}
+}
+
+// close stops accepting work and waits for queued calls to finish.
+// It returns the number of calls dropped by QueueDropOldest.
+func (p *hookPool) close() int {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// runRecovered calls fn, recovering from a panic so one bad handler
+// does not stop the worker.
+func runRecovered(fn func()) {
+	defer func() {
+		_ = recover()
+	}()
+	fn()
+}
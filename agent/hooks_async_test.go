@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHookPoolSingleWorkerIsFIFO(t *testing.T) {
+	pool := newHookPool(&asyncConfig{workers: 1, queueSize: 4})
+
+	var mu sync.Mutex
+	var got []int
+	for i := 0; i < 50; i++ {
+		i := i
+		pool.submit(func() {
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		})
+	}
+	if dropped := pool.close(); dropped != 0 {
+		t.Errorf("close() dropped = %d, want 0 with QueueBlock", dropped)
+	}
+
+	if len(got) != 50 {
+		t.Fatalf("ran %d calls, want 50", len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("call %d ran at position %d; want FIFO order", v, i)
+		}
+	}
+}
+
+func TestHookPoolDropOldest(t *testing.T) {
+	pool := newHookPool(&asyncConfig{workers: 1, queueSize: 2, policy: QueueDropOldest})
+
+	// Hold the only worker so later calls pile up in the queue
+	release := make(chan struct{})
+	started := make(chan struct{})
+	pool.submit(func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	var mu sync.Mutex
+	var got []int
+	for i := 0; i < 5; i++ {
+		i := i
+		pool.submit(func() {
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+		})
+	}
+	close(release)
+
+	if dropped := pool.close(); dropped != 3 {
+		t.Errorf("close() dropped = %d, want 3", dropped)
+	}
+	if len(got) != 2 || got[0] != 3 || got[1] != 4 {
+		t.Errorf("ran %v, want the newest calls [3 4]", got)
+	}
+}
+
+func TestHookPoolRecoversPanics(t *testing.T) {
+	pool := newHookPool(&asyncConfig{workers: 1, queueSize: 1})
+
+	ran := false
+	pool.submit(func() { panic("handler failed") })
+	pool.submit(func() { ran = true })
+	pool.close()
+
+	if !ran {
+		t.Error("call after a panicking call did not run")
+	}
+}
+
+func TestHookPoolSubmitAfterCloseRunsInline(t *testing.T) {
+	pool := newHookPool(&asyncConfig{workers: 2, queueSize: 1})
+	pool.close()
+
+	ran := false
+	pool.submit(func() { ran = true })
+	if !ran {
+		t.Error("submit after close did not run the call")
+	}
+}
+
+func TestAsyncHooksDoNotThrottleStream(t *testing.T) {
+	const tools = 20
+	const hookDelay = 50 * time.Millisecond
+
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Twenty tool calls, each followed by its result
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"async-test"}'
+i=0
+while [ $i -lt 20 ]; do
+	echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tool-'$i'","name":"Bash","input":{"command":"true"}}]}}'
+	echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tool-'$i'","content":"ok"}]}}'
+	i=$((i+1))
+done
+echo '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var hooked []string
+	var seqs []uint64
+	var types []string
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PostToolUse(func(tc *ToolCall, _ *ToolResultContext) HookResult {
+			time.Sleep(hookDelay)
+			mu.Lock()
+			defer mu.Unlock()
+			hooked = append(hooked, tc.ID)
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			seqs = append(seqs, e.Seq)
+			types = append(types, e.Type)
+		}),
+		AsyncHooks(1, 256),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	start := time.Now()
+	if _, err := a.Run(ctx, "run tools"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	elapsed := time.Since(start)
+
+	// Synchronous hooks would take at least tools*hookDelay (1s)
+	if elapsed >= tools*hookDelay/2 {
+		t.Errorf("Run() took %v; hooks appear to throttle the stream", elapsed)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hooked) != tools {
+		t.Fatalf("PostToolUse ran %d times before Close returned, want %d", len(hooked), tools)
+	}
+	for i, id := range hooked {
+		if want := "tool-" + strconv.Itoa(i); id != want {
+			t.Errorf("hook %d saw %s, want %s (single worker is FIFO)", i, id, want)
+		}
+	}
+	for i, seq := range seqs {
+		if seq != uint64(i+1) {
+			t.Fatalf("audit event %d has Seq %d; events were lost or reordered", i, seq)
+		}
+	}
+	if types[len(types)-1] != "session.end" {
+		t.Errorf("last audit event = %s, want session.end", types[len(types)-1])
+	}
+}
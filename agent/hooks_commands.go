@@ -4,7 +4,7 @@ import (
 	"strings"
 )
 
-// DenyCommands returns a PreToolUseHook that blocks Bash commands matching any pattern.
+// DenyCommands returns a BuiltinHook that blocks Bash commands matching any pattern.
 // Patterns are matched using substring containment.
 //
 // Example:
@@ -12,31 +12,36 @@ import (
 //	agent.PreToolUse(
 //	    agent.DenyCommands("sudo", "curl", "wget"),
 //	)
-func DenyCommands(patterns ...string) PreToolUseHook {
-	return func(tc *ToolCall) HookResult {
-		if tc.Name != "Bash" {
-			return HookResult{Decision: Continue}
-		}
+func DenyCommands(patterns ...string) *BuiltinHook {
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		return denyCommands(tc, patterns)
+	})
+}
 
-		command, ok := tc.Input["command"].(string)
-		if !ok {
-			return HookResult{Decision: Continue}
-		}
+// denyCommands denies a Bash call whose command contains any of patterns.
+func denyCommands(tc *ToolCall, patterns []string) HookResult {
+	if tc.Name != ToolBash {
+		return HookResult{Decision: Continue}
+	}
 
-		for _, pattern := range patterns {
-			if strings.Contains(command, pattern) {
-				return HookResult{
-					Decision: Deny,
-					Reason:   "command contains blocked pattern: " + pattern,
-				}
+	command, ok := tc.Input["command"].(string)
+	if !ok {
+		return HookResult{Decision: Continue}
+	}
+
+	for _, pattern := range patterns {
+		if strings.Contains(command, pattern) {
+			return HookResult{
+				Decision: Deny,
+				Reason:   "command contains blocked pattern: " + pattern,
 			}
 		}
-
-		return HookResult{Decision: Continue}
 	}
+
+	return HookResult{Decision: Continue}
 }
 
-// RequireCommand returns a PreToolUseHook that blocks commands matching any
+// RequireCommand returns a BuiltinHook that blocks commands matching any
 // of the insteadOf patterns and suggests using the preferred command instead.
 //
 // Example:
@@ -46,9 +51,9 @@ func DenyCommands(patterns ...string) PreToolUseHook {
 //	)
 //
 // This will deny "go build" and "go test" commands, telling Claude to use "make" instead.
-func RequireCommand(use string, insteadOf ...string) PreToolUseHook {
-	return func(tc *ToolCall) HookResult {
-		if tc.Name != "Bash" {
+func RequireCommand(use string, insteadOf ...string) *BuiltinHook {
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		if tc.Name != ToolBash {
 			return HookResult{Decision: Continue}
 		}
 
@@ -67,5 +72,5 @@ func RequireCommand(use string, insteadOf ...string) PreToolUseHook {
 		}
 
 		return HookResult{Decision: Continue}
-	}
+	})
 }
@@ -13,7 +13,7 @@ func TestDenyCommands_BlocksMatchingCommand(t *testing.T) {
 		Input: map[string]any{"command": "sudo apt update"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Deny {
 		t.Errorf("expected Deny, got %v", result.Decision)
@@ -31,7 +31,7 @@ func TestDenyCommands_AllowsNonMatchingCommand(t *testing.T) {
 		Input: map[string]any{"command": "ls -la"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue, got %v", result.Decision)
@@ -57,7 +57,7 @@ func TestDenyCommands_MultiplePatterns(t *testing.T) {
 			Name:  "Bash",
 			Input: map[string]any{"command": tt.command},
 		}
-		result := hook(tc)
+		result := hook.Evaluate(tc)
 		if result.Decision != tt.expected {
 			t.Errorf("command %q: expected %v, got %v", tt.command, tt.expected, result.Decision)
 		}
@@ -72,7 +72,7 @@ func TestDenyCommands_ContinuesForNonBashTools(t *testing.T) {
 		Input: map[string]any{"file_path": "/tmp/test.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-Bash tool, got %v", result.Decision)
@@ -87,7 +87,7 @@ func TestDenyCommands_ContinuesForMissingCommand(t *testing.T) {
 		Input: map[string]any{}, // no command field
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for missing command, got %v", result.Decision)
@@ -102,7 +102,7 @@ func TestRequireCommand_BlocksMatchingPattern(t *testing.T) {
 		Input: map[string]any{"command": "go build ./..."},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Deny {
 		t.Errorf("expected Deny, got %v", result.Decision)
@@ -123,7 +123,7 @@ func TestRequireCommand_AllowsPreferredCommand(t *testing.T) {
 		Input: map[string]any{"command": "make build"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for preferred command, got %v", result.Decision)
@@ -138,7 +138,7 @@ func TestRequireCommand_ReasonMessageIsHelpful(t *testing.T) {
 		Input: map[string]any{"command": "go test -v ./..."},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Deny {
 		t.Errorf("expected Deny, got %v", result.Decision)
@@ -158,7 +158,7 @@ func TestRequireCommand_ContinuesForNonBashTools(t *testing.T) {
 		Input: map[string]any{"file_path": "/tmp/test.go"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-Bash tool, got %v", result.Decision)
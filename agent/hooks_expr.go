@@ -0,0 +1,107 @@
+package agent
+
+// DenyWhen returns a BuiltinHook that denies tool calls for which the
+// expression is true, for policy rules kept in configuration rather than
+// code. An expression that does not compile returns a *ConfigError.
+//
+// Expressions read two variables: tool, the tool name, and input, the tool
+// input. Fields are read with input.command or input["file_path"], and a
+// missing field is null, which is false as a condition and matches no
+// helper. Expressions combine comparisons (==, !=, <, <=, >, >=) with &&,
+// ||, and !, and call the helpers contains, hasPrefix, hasSuffix, and
+// matches, as contains(s, x) or s.contains(x). The pattern of matches must
+// be a string literal and uses Go regexp syntax. Nothing else can be
+// called, so an expression cannot run code.
+//
+// An expression that fails while evaluating, such as contains on a number
+// field, denies the call.
+//
+// Example:
+//
+//	deny, err := agent.DenyWhen(`tool == 'Bash' && input.command.contains('curl')`)
+//	if err != nil {
+//	    return err
+//	}
+//	a, _ := agent.New(ctx, agent.PreToolUse(deny))
+func DenyWhen(expr string) (*BuiltinHook, error) {
+	e, err := compileHookExpr("DenyWhen", expr)
+	if err != nil {
+		return nil, err
+	}
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		matched, err := e.match(tc)
+		if err != nil {
+			return HookResult{Decision: Deny, Reason: "policy expression failed: " + err.Error()}
+		}
+		if matched {
+			return HookResult{Decision: Deny, Reason: "denied by policy: " + expr}
+		}
+		return HookResult{Decision: Continue}
+	}), nil
+}
+
+// AllowWhen returns a PreToolUseHook that allows tool calls for which the
+// expression is true, skipping later hooks. Other calls, and calls for which
+// the expression fails while evaluating, continue to the next hook.
+// Expressions are written as for DenyWhen.
+//
+// Example:
+//
+//	allow, err := agent.AllowWhen(`tool == 'Read' && input.file_path.hasPrefix('/workspace/')`)
+func AllowWhen(expr string) (PreToolUseHook, error) {
+	e, err := compileHookExpr("AllowWhen", expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(tc *ToolCall) HookResult {
+		if matched, err := e.match(tc); err == nil && matched {
+			return HookResult{Decision: Allow}
+		}
+		return HookResult{Decision: Continue}
+	}, nil
+}
+
+// RedirectWhen returns a PreToolUseHook that sets the input field to
+// replacement for tool calls for which the expression is true, and allows
+// them. Other calls, and calls for which the expression fails while
+// evaluating, continue to the next hook. Expressions are written as for
+// DenyWhen.
+//
+// Example:
+//
+//	redirect, err := agent.RedirectWhen(
+//	    `tool == 'Write' && input.file_path.matches('^/etc/')`,
+//	    "file_path", "/sandbox/blocked.txt",
+//	)
+func RedirectWhen(expr, field, replacement string) (PreToolUseHook, error) {
+	e, err := compileHookExpr("RedirectWhen", expr)
+	if err != nil {
+		return nil, err
+	}
+	if field == "" {
+		return nil, &ConfigError{Option: "RedirectWhen", Value: field, Reason: "field name is empty"}
+	}
+	return func(tc *ToolCall) HookResult {
+		if matched, err := e.match(tc); err != nil || !matched {
+			return HookResult{Decision: Continue}
+		}
+		return HookResult{
+			Decision:     Allow,
+			UpdatedInput: map[string]any{field: replacement},
+		}
+	}, nil
+}
+
+// compileHookExpr compiles the expression of an expression hook, reporting
+// errors as a *ConfigError for the option.
+func compileHookExpr(option, expr string) (*expression, error) {
+	e, err := compileExpr(expr)
+	if err != nil {
+		cfgErr := &ConfigError{Option: option, Value: expr, Reason: err.Error()}
+		if ee, ok := err.(*exprError); ok {
+			cfgErr.Suggestions = ee.suggestions
+		}
+		return nil, cfgErr
+	}
+	return e, nil
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDenyWhen(t *testing.T) {
+	hook, err := DenyWhen(`tool == 'Bash' && input.command.contains('curl')`)
+	if err != nil {
+		t.Fatalf("DenyWhen() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		tc   *ToolCall
+		want Decision
+	}{
+		{"matching command", &ToolCall{Name: ToolBash, Input: map[string]any{"command": "curl example.com"}}, Deny},
+		{"other command", &ToolCall{Name: ToolBash, Input: map[string]any{"command": "ls"}}, Continue},
+		{"other tool", &ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "/curl"}}, Continue},
+		{"missing command", &ToolCall{Name: ToolBash, Input: map[string]any{}}, Continue},
+		{"nil input", &ToolCall{Name: ToolBash}, Continue},
+		// A rule that cannot be evaluated fails closed
+		{"command not a string", &ToolCall{Name: ToolBash, Input: map[string]any{"command": 42.0}}, Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hook.Evaluate(tt.tc); got.Decision != tt.want {
+				t.Errorf("Decision = %v, want %v (reason %q)", got.Decision, tt.want, got.Reason)
+			}
+		})
+	}
+
+	result := hook.Evaluate(&ToolCall{Name: ToolBash, Input: map[string]any{"command": "curl x"}})
+	if !strings.Contains(result.Reason, "input.command.contains('curl')") {
+		t.Errorf("Reason = %q, want the rule named", result.Reason)
+	}
+	result = hook.Evaluate(&ToolCall{Name: ToolBash, Input: map[string]any{"command": 42.0}})
+	if !strings.HasPrefix(result.Reason, "policy expression failed:") {
+		t.Errorf("Reason = %q, want the evaluation error", result.Reason)
+	}
+}
+
+func TestAllowWhen(t *testing.T) {
+	hook, err := AllowWhen(`tool == 'Read' && input.file_path.hasPrefix('/workspace/')`)
+	if err != nil {
+		t.Fatalf("AllowWhen() error = %v", err)
+	}
+	tests := []struct {
+		tc   *ToolCall
+		want Decision
+	}{
+		{&ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "/workspace/main.go"}}, Allow},
+		{&ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "/etc/passwd"}}, Continue},
+		{&ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/workspace/main.go"}}, Continue},
+		// An evaluation error does not allow the call
+		{&ToolCall{Name: ToolRead, Input: map[string]any{"file_path": []any{"/workspace/x"}}}, Continue},
+	}
+	for _, tt := range tests {
+		if got := hook(tt.tc); got.Decision != tt.want {
+			t.Errorf("hook(%v) = %v, want %v", tt.tc.Input, got.Decision, tt.want)
+		}
+	}
+}
+
+func TestRedirectWhen(t *testing.T) {
+	hook, err := RedirectWhen(`tool == 'Write' && input.file_path.matches('^/etc/')`, "file_path", "/sandbox/blocked.txt")
+	if err != nil {
+		t.Fatalf("RedirectWhen() error = %v", err)
+	}
+
+	got := hook(&ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/etc/hosts", "content": "x"}})
+	want := HookResult{Decision: Allow, UpdatedInput: map[string]any{"file_path": "/sandbox/blocked.txt"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hook() = %+v, want %+v", got, want)
+	}
+
+	got = hook(&ToolCall{Name: ToolWrite, Input: map[string]any{"file_path": "/tmp/hosts"}})
+	if got.Decision != Continue || got.UpdatedInput != nil {
+		t.Errorf("hook() = %+v, want Continue without changes", got)
+	}
+}
+
+func TestExprHookConfigErrors(t *testing.T) {
+	build := map[string]func(string) error{
+		"DenyWhen": func(expr string) error {
+			_, err := DenyWhen(expr)
+			return err
+		},
+		"AllowWhen": func(expr string) error {
+			_, err := AllowWhen(expr)
+			return err
+		},
+		"RedirectWhen": func(expr string) error {
+			_, err := RedirectWhen(expr, "file_path", "/x")
+			return err
+		},
+	}
+	for option, fn := range build {
+		err := fn(`tol == 'Bash'`)
+		var cfgErr *ConfigError
+		if !errors.As(err, &cfgErr) {
+			t.Fatalf("%s() error = %v, want ConfigError", option, err)
+		}
+		if cfgErr.Option != option || cfgErr.Value != `tol == 'Bash'` || !reflect.DeepEqual(cfgErr.Suggestions, []string{"tool"}) {
+			t.Errorf("%s() error = %+v", option, cfgErr)
+		}
+		if !strings.Contains(err.Error(), "at offset 0") {
+			t.Errorf("%s() error = %q, want the offset", option, err.Error())
+		}
+	}
+
+	_, err := RedirectWhen(`tool == 'Write'`, "", "/x")
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Option != "RedirectWhen" {
+		t.Errorf("RedirectWhen() error = %v, want ConfigError for the empty field", err)
+	}
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PatternFileCheckInterval is the shortest time between checks of a
+// pattern file for changes by DenyCommandsFile and AllowPathsFile.
+const PatternFileCheckInterval = time.Second
+
+// DenyCommandsFile returns a BuiltinHook that works like DenyCommands
+// with the patterns read from a file, one per line. The file is read when
+// the hook is created and read again when its modification time or size
+// changes, so an updated list applies to later tool calls without
+// restarting the agent.
+//
+// The file is checked when the hook is evaluated, at most once every
+// PatternFileCheckInterval; no goroutine watches it. Blank lines and lines
+// starting with # are ignored, and surrounding whitespace is trimmed. A
+// line that is not valid UTF-8 or contains control characters is
+// reported with a hook.pattern_file.malformed audit event, and the file is
+// rejected. If the file cannot be read or is rejected, the last list read
+// is kept and a hook.pattern_file.error audit event is emitted; until a
+// read succeeds, every Bash call is denied. Each successful read emits
+// hook.pattern_file.loaded.
+//
+// Replace the file with a rename rather than rewriting it in place, so the
+// hook never reads a partly written list.
+//
+// Example:
+//
+//	agent.PreToolUse(
+//	    agent.DenyCommandsFile("/etc/agent/denied-commands"),
+//	)
+func DenyCommandsFile(path string) *BuiltinHook {
+	f := &patternFile{path: path, interval: PatternFileCheckInterval, now: time.Now, strict: true}
+	f.check()
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		patterns := f.patterns(tc)
+		if patterns == nil && tc.Name == ToolBash {
+			return HookResult{
+				Decision: Deny,
+				Reason:   "denied command list " + path + " has not been read",
+			}
+		}
+		return denyCommands(tc, patterns)
+	})
+}
+
+// AllowPathsFile returns a BuiltinHook that works like AllowPaths with
+// the allowed directories read from a file, one per line. The file is
+// read and reloaded as for DenyCommandsFile, but a malformed line is
+// skipped rather than rejecting the file. Until the file is read
+// successfully the list is empty, so all file operations are denied.
+//
+// Example:
+//
+//	agent.PreToolUse(
+//	    agent.AllowPathsFile("/etc/agent/allowed-paths"),
+//	)
+func AllowPathsFile(path string) *BuiltinHook {
+	f := newPatternFile(path, PatternFileCheckInterval)
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		return allowPaths(tc, f.patterns(tc))
+	})
+}
+
+// patternFile is a list of patterns read from a file and reloaded when the
+// file changes.
+type patternFile struct {
+	path     string
+	interval time.Duration
+	now      func() time.Time
+	strict   bool // Reject a file with a malformed line instead of skipping the line
+
+	list atomic.Pointer[[]string] // Swapped whole on reload, so readers never see a partial list; nil until loaded
+
+	mu      sync.Mutex // Held while checking the file
+	checked time.Time
+	read    bool // modTime and size are those of the last file read, loaded or rejected
+	modTime time.Time
+	size    int64
+	lastErr string         // Last read error, reported once until it changes
+	events  []patternEvent // Audit events not yet emitted
+}
+
+// patternEvent is an audit event found while reading a pattern file. It is
+// emitted by the next evaluation, which knows the agent.
+type patternEvent struct {
+	eventType string
+	data      map[string]any
+}
+
+// newPatternFile reads the patterns in path.
+func newPatternFile(path string, interval time.Duration) *patternFile {
+	f := &patternFile{path: path, interval: interval, now: time.Now}
+	f.check()
+	return f
+}
+
+// patterns returns the current patterns, first reloading the file if the
+// check interval has passed and it changed. If another evaluation is
+// checking the file, the current patterns are returned without waiting.
+// It returns nil if the file has never been loaded.
+func (f *patternFile) patterns(tc *ToolCall) []string {
+	if f.mu.TryLock() {
+		if f.now().Sub(f.checked) >= f.interval {
+			f.check()
+		}
+		events := f.events
+		f.events = nil
+		f.mu.Unlock()
+
+		for _, e := range events {
+			tc.emit(e.eventType, e.data)
+		}
+	}
+	if list := f.list.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// check reloads the file if it changed since it was last read. f.mu must
+// be held, or f not yet shared.
+func (f *patternFile) check() {
+	f.checked = f.now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+	if f.read && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		f.fail(err)
+		return
+	}
+
+	list, malformed := f.parse(string(data))
+	f.read = true
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	if f.strict && malformed != "" {
+		f.fail(errors.New(malformed))
+		return
+	}
+	f.list.Store(&list)
+	f.lastErr = ""
+	f.record("hook.pattern_file.loaded", map[string]any{
+		"path":     f.path,
+		"patterns": len(list),
+	})
+}
+
+// parse returns the patterns in data, recording an event for each
+// malformed line, and describes the first malformed line, if any.
+func (f *patternFile) parse(data string) (list []string, malformed string) {
+	list = []string{}
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if reason := malformedPattern(line); reason != "" {
+			f.record("hook.pattern_file.malformed", map[string]any{
+				"path":   f.path,
+				"line":   i + 1,
+				"reason": reason,
+			})
+			if malformed == "" {
+				malformed = "line " + strconv.Itoa(i+1) + ": " + reason
+			}
+			continue
+		}
+		list = append(list, line)
+	}
+	return list, malformed
+}
+
+// malformedPattern returns why line cannot be a pattern, or "" if it can.
+func malformedPattern(line string) string {
+	if !utf8.ValidString(line) {
+		return "not valid UTF-8"
+	}
+	if strings.IndexFunc(line, unicode.IsControl) >= 0 {
+		return "contains a control character"
+	}
+	return ""
+}
+
+// fail records a read error, keeping the current patterns.
+func (f *patternFile) fail(err error) {
+	if err.Error() == f.lastErr {
+		return
+	}
+	f.lastErr = err.Error()
+	patterns := 0
+	if list := f.list.Load(); list != nil {
+		patterns = len(*list)
+	}
+	f.record("hook.pattern_file.error", map[string]any{
+		"path":     f.path,
+		"error":    err.Error(),
+		"patterns": patterns,
+	})
+}
+
+// record queues an audit event for the next evaluation.
+func (f *patternFile) record(eventType string, data map[string]any) {
+	f.events = append(f.events, patternEvent{eventType, data})
+}
@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writePatterns replaces the file at path with content by renaming a
+// temporary file over it, and sets its modification time to mod.
+func writePatterns(t *testing.T, path, content string, mod time.Time) {
+	t.Helper()
+	tmp := path + ".tmp"
+	mustWriteFile(t, tmp, []byte(content), 0o644)
+	if err := os.Chtimes(tmp, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordAudit returns a ToolCall whose audit events are appended to events.
+func recordAudit(tc *ToolCall, events *[]string) *ToolCall {
+	tc.audit = func(eventType string, data map[string]any) {
+		*events = append(*events, eventType)
+	}
+	return tc
+}
+
+func bashCall(command string) *ToolCall {
+	return &ToolCall{Name: ToolBash, Input: map[string]any{"command": command}}
+}
+
+func TestDenyCommandsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	writePatterns(t, path, "# Denied commands\nsudo\n\n  curl  \n", time.Now().Add(-time.Minute))
+
+	hook := DenyCommandsFile(path)
+	tests := []struct {
+		command string
+		want    Decision
+	}{
+		{"sudo ls", Deny},
+		{"curl http://example.com", Deny},
+		{"# Denied commands", Continue},
+		{"ls -la", Continue},
+	}
+	for _, tt := range tests {
+		if got := hook.Evaluate(bashCall(tt.command)).Decision; got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestDenyCommandsFileFailsClosed(t *testing.T) {
+	dir := t.TempDir()
+
+	// A missing file denies every command, and nothing else
+	missing := DenyCommandsFile(filepath.Join(dir, "missing"))
+	if got := missing.Evaluate(bashCall("ls -la")).Decision; got != Deny {
+		t.Errorf("missing file: got %v, want Deny", got)
+	}
+	read := &ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "main.go"}}
+	if got := missing.Evaluate(read).Decision; got != Continue {
+		t.Errorf("missing file, Read: got %v, want Continue", got)
+	}
+
+	// A malformed line rejects the file rather than dropping a pattern
+	path := filepath.Join(dir, "denied")
+	writePatterns(t, path, "sudo\nbad\x00line\n", time.Now().Add(-time.Minute))
+	hook := DenyCommandsFile(path)
+	var events []string
+	if got := hook.Evaluate(recordAudit(bashCall("ls -la"), &events)).Decision; got != Deny {
+		t.Errorf("malformed file: got %v, want Deny", got)
+	}
+	want := []string{"hook.pattern_file.malformed", "hook.pattern_file.error"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestPatternFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	start := time.Now().Add(-time.Hour)
+	writePatterns(t, path, "sudo\n", start)
+
+	f := newPatternFile(path, 0)
+	if got := f.patterns(bashCall("")); len(got) != 1 || got[0] != "sudo" {
+		t.Fatalf("initial patterns = %v", got)
+	}
+
+	writePatterns(t, path, "curl\nwget\n", start.Add(time.Minute))
+	var events []string
+	got := f.patterns(recordAudit(bashCall(""), &events))
+	if strings.Join(got, ",") != "curl,wget" {
+		t.Errorf("reloaded patterns = %v, want [curl wget]", got)
+	}
+	if len(events) != 1 || events[0] != "hook.pattern_file.loaded" {
+		t.Errorf("events = %v, want [hook.pattern_file.loaded]", events)
+	}
+
+	// An unchanged file is not read again
+	events = nil
+	f.patterns(recordAudit(bashCall(""), &events))
+	if len(events) != 0 {
+		t.Errorf("events for unchanged file = %v, want none", events)
+	}
+}
+
+func TestPatternFileCheckInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	start := time.Now().Add(-time.Hour)
+	writePatterns(t, path, "sudo\n", start)
+
+	clock := time.Now()
+	f := newPatternFile(path, time.Minute)
+	f.now = func() time.Time { return clock }
+	f.checked = clock
+
+	writePatterns(t, path, "curl\n", start.Add(time.Minute))
+
+	clock = clock.Add(59 * time.Second)
+	if got := f.patterns(bashCall("")); got[0] != "sudo" {
+		t.Errorf("patterns before interval = %v, want [sudo]", got)
+	}
+
+	clock = clock.Add(time.Second)
+	if got := f.patterns(bashCall("")); got[0] != "curl" {
+		t.Errorf("patterns after interval = %v, want [curl]", got)
+	}
+}
+
+func TestPatternFileMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	writePatterns(t, path, "sudo\nbad\x00line\n\xff\xfe\ncurl\n", time.Now())
+
+	f := newPatternFile(path, 0)
+	var events []string
+	got := f.patterns(recordAudit(bashCall(""), &events))
+	if strings.Join(got, ",") != "sudo,curl" {
+		t.Errorf("patterns = %v, want [sudo curl]", got)
+	}
+	want := []string{"hook.pattern_file.malformed", "hook.pattern_file.malformed", "hook.pattern_file.loaded"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestPatternFileUnreadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	start := time.Now().Add(-time.Hour)
+	writePatterns(t, path, "sudo\n", start)
+
+	hook := DenyCommandsFile(path)
+	if got := hook.Evaluate(bashCall("sudo ls")).Decision; got != Deny {
+		t.Fatalf("initial decision = %v, want Deny", got)
+	}
+
+	f := newPatternFile(path, 0)
+	f.patterns(bashCall("")) // Drains the initial load event
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	got := f.patterns(recordAudit(bashCall(""), &events))
+	if len(got) != 1 || got[0] != "sudo" {
+		t.Errorf("patterns after removal = %v, want last good list [sudo]", got)
+	}
+	if len(events) != 1 || events[0] != "hook.pattern_file.error" {
+		t.Errorf("events = %v, want [hook.pattern_file.error]", events)
+	}
+
+	// The same error is reported once
+	events = nil
+	f.patterns(recordAudit(bashCall(""), &events))
+	if len(events) != 0 {
+		t.Errorf("events for repeated error = %v, want none", events)
+	}
+
+	writePatterns(t, path, "wget\n", start.Add(time.Minute))
+	if got := f.patterns(bashCall("")); len(got) != 1 || got[0] != "wget" {
+		t.Errorf("patterns after restore = %v, want [wget]", got)
+	}
+}
+
+func TestAllowPathsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "allowed")
+	start := time.Now().Add(-time.Hour)
+	writePatterns(t, path, "/sandbox\n", start)
+
+	hook := AllowPathsFile(path)
+	read := func(p string) *ToolCall {
+		return &ToolCall{Name: ToolRead, Input: map[string]any{"file_path": p}}
+	}
+	if got := hook.Evaluate(read("/sandbox/a.txt")).Decision; got != Continue {
+		t.Errorf("/sandbox/a.txt: got %v, want Continue", got)
+	}
+	if got := hook.Evaluate(read("/tmp/a.txt")).Decision; got != Deny {
+		t.Errorf("/tmp/a.txt: got %v, want Deny", got)
+	}
+
+	// A missing file allows nothing
+	missing := AllowPathsFile(filepath.Join(dir, "missing"))
+	if got := missing.Evaluate(read("/sandbox/a.txt")).Decision; got != Deny {
+		t.Errorf("missing file: got %v, want Deny", got)
+	}
+}
+
+func TestPatternFileConcurrentReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "denied")
+	start := time.Now().Add(-time.Hour)
+	lists := []string{"a\nb\nc\n", "x\ny\nz\n"}
+	writePatterns(t, path, lists[0], start)
+
+	f := newPatternFile(path, 0)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				got := strings.Join(f.patterns(bashCall("")), ",")
+				if got != "a,b,c" && got != "x,y,z" {
+					t.Errorf("torn patterns %q", got)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 50; i++ {
+		writePatterns(t, path, lists[i%2], start.Add(time.Duration(i)*time.Second))
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	wg.Wait()
+
+	if got := strings.Join(f.patterns(bashCall("")), ","); got != "a,b,c" {
+		t.Errorf("final patterns = %q, want a,b,c", got)
+	}
+}
@@ -1,11 +1,13 @@
 package agent
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 )
 
 // pathTools is the list of tools that operate on file paths.
-var pathTools = []string{"Read", "Write", "Edit", "MultiEdit"}
+var pathTools = []string{ToolRead, ToolWrite, ToolEdit, ToolMultiEdit}
 
 // isPathTool checks if the tool name is a path-operating tool.
 func isPathTool(name string) bool {
@@ -31,59 +33,140 @@ func extractPath(input map[string]any) (string, bool) {
 	return "", false
 }
 
-// AllowPaths returns a PreToolUseHook that only allows file operations on paths
-// that start with one of the allowed prefixes. All other paths are denied.
+// normalizePath makes p absolute and clean so that it can be compared with
+// other normalized paths. A leading "~/" expands to the home directory and a
+// relative path is resolved against dir (the agent's WorkDir), or the current
+// directory when dir is empty. With resolve set, symlinks in the longest
+// existing ancestor are resolved, so paths that do not exist yet (such as
+// the target of a Write) still normalize.
+func normalizePath(p, dir string, resolve bool) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	if !filepath.IsAbs(p) {
+		if dir == "" {
+			dir = "."
+		}
+		p = filepath.Join(dir, p)
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	p = filepath.Clean(p)
+	if resolve {
+		p = resolveExisting(p)
+	}
+	return p
+}
+
+// resolveExisting resolves symlinks in the longest existing ancestor of p
+// and appends the remainder unchanged.
+func resolveExisting(p string) string {
+	rest := ""
+	for dir := p; ; {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return p
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// withinPath reports whether path is root or a descendant of it. Both must
+// be normalized. Matching stops at a separator boundary, so "/sandboxevil"
+// is not within "/sandbox".
+func withinPath(path, root string) bool {
+	if path == root {
+		return true
+	}
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, root)
+}
+
+// toolPath returns the normalized path a file tool operates on.
+func toolPath(tc *ToolCall) (string, bool) {
+	path, ok := extractPath(tc.Input)
+	if !ok {
+		return "", false
+	}
+	return normalizePath(path, tc.workDir, tc.resolveSymlinks), true
+}
+
+// AllowPaths returns a BuiltinHook that only allows file operations on paths
+// within one of the allowed directories. All other paths are denied.
+//
+// Paths are cleaned before comparison, so "/sandbox/../etc/passwd" is treated
+// as "/etc/passwd", and relative paths (in the list or from Claude) resolve
+// against the agent's WorkDir. A directory matches itself and its
+// descendants only: AllowPaths("/sandbox") does not allow "/sandboxevil".
+// Use ResolvePathSymlinks to also resolve symlinks before comparison.
 //
 // Example:
 //
 //	agent.PreToolUse(
 //	    agent.AllowPaths("/sandbox", "/tmp"),
 //	)
-func AllowPaths(paths ...string) PreToolUseHook {
-	return func(tc *ToolCall) HookResult {
-		if !isPathTool(tc.Name) {
-			return HookResult{Decision: Continue}
-		}
+func AllowPaths(paths ...string) *BuiltinHook {
+	hook := builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		return allowPaths(tc, paths)
+	})
+	hook.allow = paths
+	return hook
+}
 
-		path, ok := extractPath(tc.Input)
-		if !ok {
-			return HookResult{Decision: Continue}
-		}
+// allowPaths denies a file tool call on a path outside all of paths.
+func allowPaths(tc *ToolCall, paths []string) HookResult {
+	if !isPathTool(tc.Name) {
+		return HookResult{Decision: Continue}
+	}
 
-		for _, allowed := range paths {
-			if strings.HasPrefix(path, allowed) {
-				return HookResult{Decision: Continue}
-			}
-		}
+	path, ok := toolPath(tc)
+	if !ok {
+		return HookResult{Decision: Continue}
+	}
 
-		return HookResult{
-			Decision: Deny,
-			Reason:   "path not in allowed list: " + path,
+	for _, allowed := range paths {
+		if withinPath(path, normalizePath(allowed, tc.workDir, tc.resolveSymlinks)) {
+			return HookResult{Decision: Continue}
 		}
 	}
+
+	return HookResult{
+		Decision: Deny,
+		Reason:   "path not in allowed list: " + path,
+	}
 }
 
-// DenyPaths returns a PreToolUseHook that blocks file operations on paths
-// that start with any of the denied prefixes.
+// DenyPaths returns a BuiltinHook that blocks file operations on paths
+// within any of the denied directories. Paths are normalized as for
+// AllowPaths.
 //
 // Example:
 //
 //	agent.PreToolUse(
 //	    agent.DenyPaths("/etc", "/usr", "~/.ssh"),
 //	)
-func DenyPaths(paths ...string) PreToolUseHook {
-	return func(tc *ToolCall) HookResult {
+func DenyPaths(paths ...string) *BuiltinHook {
+	hook := builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
 		if !isPathTool(tc.Name) {
 			return HookResult{Decision: Continue}
 		}
 
-		path, ok := extractPath(tc.Input)
+		path, ok := toolPath(tc)
 		if !ok {
 			return HookResult{Decision: Continue}
 		}
 
 		for _, denied := range paths {
-			if strings.HasPrefix(path, denied) {
+			if withinPath(path, normalizePath(denied, tc.workDir, tc.resolveSymlinks)) {
 				return HookResult{
 					Decision: Deny,
 					Reason:   "path is in denied list: " + path,
@@ -92,12 +175,29 @@ func DenyPaths(paths ...string) PreToolUseHook {
 		}
 
 		return HookResult{Decision: Continue}
-	}
+	})
+	hook.deny = paths
+	return hook
+}
+
+// PathRedirect maps a path Claude asked for to the path RedirectPath
+// rewrote it to, so that paths in tool results and Result text can be
+// translated back.
+type PathRedirect struct {
+	Original   string // Path in the tool call, as Claude sent it
+	Redirected string // Path after the rewrite
 }
 
-// RedirectPath returns a PreToolUseHook that rewrites file paths.
-// If a path starts with 'from', it is rewritten to start with 'to'.
-// The hook returns Allow with UpdatedInput to apply the rewrite.
+// RedirectPath returns a BuiltinHook that rewrites file paths.
+// If a path is within 'from', it is rewritten to the same relative path
+// within 'to'. Paths are normalized as for AllowPaths, so traversal such as
+// "/tmp/../etc/passwd" is not redirected, and the rewritten path is always
+// clean. The hook returns Allow with UpdatedInput to apply the rewrite.
+//
+// When several RedirectPath hooks in an agent's chain match a path, the one
+// whose 'from' is longest rewrites it, whatever their order. Each rewrite
+// is recorded in the hook.pre_tool_use audit event under redirect, in the
+// run's Result.PathRedirects, and in the OriginalPath of the file changes.
 //
 // Example:
 //
@@ -106,23 +206,91 @@ func DenyPaths(paths ...string) PreToolUseHook {
 //	)
 //
 // A path like "/tmp/foo.txt" becomes "/sandbox/tmp/foo.txt".
-func RedirectPath(from, to string) PreToolUseHook {
+func RedirectPath(from, to string) *BuiltinHook {
+	return redirectPath(from, to, false)
+}
+
+// RedirectPathCreate is like RedirectPath, but also creates the parent
+// directory of each rewritten path, with any missing ancestors, so that a
+// Write to a directory that exists outside the sandbox does not fail
+// inside it. A directory that cannot be created denies the call.
+//
+// Example:
+//
+//	agent.PreToolUse(
+//	    agent.RedirectPathCreate("/tmp", "./sandbox/tmp"),
+//	)
+func RedirectPathCreate(from, to string) *BuiltinHook {
+	return redirectPath(from, to, true)
+}
+
+// pathRewrite is the rewrite a RedirectPath hook made, recorded on the
+// ToolCall.
+type pathRewrite struct {
+	mapping PathRedirect
+}
+
+// redirectRule is the arguments of a RedirectPath or RedirectPathCreate
+// hook.
+type redirectRule struct {
+	from   string
+	to     string
+	create bool
+}
+
+// redirectPath implements RedirectPath and RedirectPathCreate.
+func redirectPath(from, to string, create bool) *BuiltinHook {
+	rule := redirectRule{from: from, to: to, create: create}
+	hook := builtinHook(PriorityDefault, redirectPaths([]redirectRule{rule}))
+	hook.redirect = &rule
+	return hook
+}
+
+// redirectPaths returns a hook that rewrites a path with the rule whose
+// 'from' is the longest that contains it. Ties go to the earlier rule.
+func redirectPaths(rules []redirectRule) PreToolUseHook {
 	return func(tc *ToolCall) HookResult {
 		if !isPathTool(tc.Name) {
 			return HookResult{Decision: Continue}
 		}
 
-		path, ok := extractPath(tc.Input)
+		original, ok := extractPath(tc.Input)
 		if !ok {
 			return HookResult{Decision: Continue}
 		}
+		path := normalizePath(original, tc.workDir, tc.resolveSymlinks)
 
-		if !strings.HasPrefix(path, from) {
+		var rule *redirectRule
+		var root string
+		for i := range rules {
+			r := normalizePath(rules[i].from, tc.workDir, tc.resolveSymlinks)
+			if withinPath(path, r) && (rule == nil || len(r) > len(root)) {
+				rule, root = &rules[i], r
+			}
+		}
+		if rule == nil {
+			return HookResult{Decision: Continue}
+		}
+
+		// Rebuild the path from the cleaned remainder below 'from'
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
 			return HookResult{Decision: Continue}
 		}
+		newPath := filepath.Join(rule.to, rel)
 
-		// Rewrite the path
-		newPath := to + strings.TrimPrefix(path, from)
+		if rule.create {
+			dir := filepath.Dir(normalizePath(newPath, tc.workDir, false))
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return HookResult{Decision: Deny, Reason: "redirected directory unavailable: " + err.Error()}
+			}
+		}
+
+		// A path an earlier rewrite produced maps back to its original
+		if prev := tc.rewrite; prev != nil && prev.mapping.Redirected == original {
+			original = prev.mapping.Original
+		}
+		tc.rewrite = &pathRewrite{mapping: PathRedirect{Original: original, Redirected: newPath}}
 
 		// Determine which field to update
 		fieldName := "file_path"
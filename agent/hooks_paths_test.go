@@ -1,6 +1,9 @@
 package agent
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -12,7 +15,7 @@ func TestAllowPaths_AllowsMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/sandbox/file.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for allowed path, got %v", result.Decision)
@@ -27,7 +30,7 @@ func TestAllowPaths_DeniesNonMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/etc/passwd"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Deny {
 		t.Errorf("expected Deny for non-allowed path, got %v", result.Decision)
@@ -53,7 +56,7 @@ func TestAllowPaths_MultiplePaths(t *testing.T) {
 			Name:  "Write",
 			Input: map[string]any{"file_path": tt.path},
 		}
-		result := hook(tc)
+		result := hook.Evaluate(tc)
 		if result.Decision != tt.expected {
 			t.Errorf("path %q: expected %v, got %v", tt.path, tt.expected, result.Decision)
 		}
@@ -68,7 +71,7 @@ func TestAllowPaths_ContinuesForNonPathTools(t *testing.T) {
 		Input: map[string]any{"command": "cat /etc/passwd"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-path tool, got %v", result.Decision)
@@ -83,7 +86,7 @@ func TestDenyPaths_DeniesMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/etc/passwd"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Deny {
 		t.Errorf("expected Deny for denied path, got %v", result.Decision)
@@ -98,7 +101,7 @@ func TestDenyPaths_AllowsNonMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/tmp/file.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-denied path, got %v", result.Decision)
@@ -124,7 +127,7 @@ func TestDenyPaths_MultiplePaths(t *testing.T) {
 			Name:  "Edit",
 			Input: map[string]any{"file_path": tt.path},
 		}
-		result := hook(tc)
+		result := hook.Evaluate(tc)
 		if result.Decision != tt.expected {
 			t.Errorf("path %q: expected %v, got %v", tt.path, tt.expected, result.Decision)
 		}
@@ -139,7 +142,7 @@ func TestDenyPaths_ContinuesForNonPathTools(t *testing.T) {
 		Input: map[string]any{"command": "cat /etc/passwd"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-path tool, got %v", result.Decision)
@@ -154,7 +157,7 @@ func TestRedirectPath_RewritesMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/tmp/foo.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Allow {
 		t.Errorf("expected Allow for redirected path, got %v", result.Decision)
@@ -177,7 +180,7 @@ func TestRedirectPath_ContinuesForNonMatchingPath(t *testing.T) {
 		Input: map[string]any{"file_path": "/home/user/file.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-matching path, got %v", result.Decision)
@@ -193,7 +196,7 @@ func TestRedirectPath_ReturnsAllowNotContinue(t *testing.T) {
 		Input: map[string]any{"file_path": "/tmp/test.txt"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Allow {
 		t.Errorf("expected Allow (to apply rewrite), got %v", result.Decision)
@@ -208,7 +211,7 @@ func TestRedirectPath_ContinuesForNonPathTools(t *testing.T) {
 		Input: map[string]any{"command": "ls /tmp"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Continue {
 		t.Errorf("expected Continue for non-path tool, got %v", result.Decision)
@@ -224,7 +227,7 @@ func TestRedirectPath_HandlesPathField(t *testing.T) {
 		Input: map[string]any{"path": "/tmp/data.json"},
 	}
 
-	result := hook(tc)
+	result := hook.Evaluate(tc)
 
 	if result.Decision != Allow {
 		t.Errorf("expected Allow for redirected path, got %v", result.Decision)
@@ -259,3 +262,214 @@ func TestPathTools_NonPathToolsNotRecognized(t *testing.T) {
 		}
 	}
 }
+
+func TestPathHooks_BypassAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		workDir string
+		allow   Decision // AllowPaths("/sandbox")
+		deny    Decision // DenyPaths("/sandbox")
+	}{
+		{"inside", "/sandbox/file.txt", "", Continue, Deny},
+		{"root itself", "/sandbox", "", Continue, Deny},
+		{"dot-dot escape", "/sandbox/../etc/passwd", "", Deny, Continue},
+		{"nested dot-dot escape", "/sandbox/a/../../etc/passwd", "", Deny, Continue},
+		{"dot-dot staying inside", "/sandbox/a/../b.txt", "", Continue, Deny},
+		{"double slash", "//sandbox//file.txt", "", Continue, Deny},
+		{"trailing slash", "/sandbox/dir/", "", Continue, Deny},
+		{"dot segments", "/sandbox/./file.txt", "", Continue, Deny},
+		{"sibling prefix", "/sandboxevil/file.txt", "", Deny, Continue},
+		{"sibling prefix via dot-dot", "/sandbox/../sandboxevil/x", "", Deny, Continue},
+		{"relative inside workdir", "file.txt", "/sandbox", Continue, Deny},
+		{"relative escape from workdir", "../etc/passwd", "/sandbox", Deny, Continue},
+		{"relative outside workdir", "sandbox/file.txt", "/home", Deny, Continue},
+	}
+
+	allow := AllowPaths("/sandbox")
+	deny := DenyPaths("/sandbox")
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &ToolCall{Name: "Write", Input: map[string]any{"file_path": tt.path}, workDir: tt.workDir}
+			if got := allow.Evaluate(tc).Decision; got != tt.allow {
+				t.Errorf("AllowPaths(/sandbox) on %q = %v, want %v", tt.path, got, tt.allow)
+			}
+			if got := deny.Evaluate(tc).Decision; got != tt.deny {
+				t.Errorf("DenyPaths(/sandbox) on %q = %v, want %v", tt.path, got, tt.deny)
+			}
+		})
+	}
+}
+
+func TestPathHooks_RootsResolveAgainstWorkDir(t *testing.T) {
+	hook := AllowPaths("./src")
+	for path, want := range map[string]Decision{
+		"/work/src/main.go":  Continue,
+		"src/main.go":        Continue,
+		"/work/srcevil/x.go": Deny,
+		"/src/main.go":       Deny,
+	} {
+		tc := &ToolCall{Name: "Read", Input: map[string]any{"file_path": path}, workDir: "/work"}
+		if got := hook.Evaluate(tc).Decision; got != want {
+			t.Errorf("AllowPaths(./src) in /work on %q = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRedirectPath_NormalizesPaths(t *testing.T) {
+	hook := RedirectPath("/tmp", "/sandbox/tmp")
+	tests := []struct {
+		path string
+		want string // "" means not redirected
+	}{
+		{"/tmp/foo.txt", "/sandbox/tmp/foo.txt"},
+		{"/tmp", "/sandbox/tmp"},
+		{"//tmp//a/./b.txt", "/sandbox/tmp/a/b.txt"},
+		{"/tmp/a/../b.txt", "/sandbox/tmp/b.txt"},
+		{"/tmp/../etc/passwd", ""},
+		{"/tmpevil/x", ""},
+	}
+	for _, tt := range tests {
+		result := hook.Evaluate(&ToolCall{Name: "Write", Input: map[string]any{"file_path": tt.path}})
+		if tt.want == "" {
+			if result.Decision != Continue {
+				t.Errorf("RedirectPath on %q = %v %v, want Continue", tt.path, result.Decision, result.UpdatedInput)
+			}
+			continue
+		}
+		if got := result.UpdatedInput["file_path"]; result.Decision != Allow || got != tt.want {
+			t.Errorf("RedirectPath on %q = %v %v, want Allow to %q", tt.path, result.Decision, got, tt.want)
+		}
+	}
+}
+
+func TestPathHooks_ResolveSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	sandbox := filepath.Join(dir, "sandbox")
+	outside := filepath.Join(dir, "outside")
+	for _, d := range []string{sandbox, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sandbox/link points outside the sandbox
+	if err := os.Symlink(outside, filepath.Join(sandbox, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	hook := AllowPaths(sandbox)
+	tests := []struct {
+		path    string
+		resolve bool
+		want    Decision
+	}{
+		{filepath.Join(sandbox, "link", "new.txt"), false, Continue},
+		{filepath.Join(sandbox, "link", "new.txt"), true, Deny},
+		{filepath.Join(sandbox, "link", "a", "b", "new.txt"), true, Deny},
+		{filepath.Join(sandbox, "real", "new.txt"), true, Continue},
+	}
+	for _, tt := range tests {
+		tc := &ToolCall{Name: "Write", Input: map[string]any{"file_path": tt.path}, resolveSymlinks: tt.resolve}
+		if got := hook.Evaluate(tc).Decision; got != tt.want {
+			t.Errorf("AllowPaths on %q (resolve=%v) = %v, want %v", tt.path, tt.resolve, got, tt.want)
+		}
+	}
+}
+
+func TestPathHooks_UseAgentWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	var seen *ToolCall
+	ctx := context.Background()
+	a, err := New(ctx,
+		replayFixture("tool_control"),
+		WorkDir(dir),
+		ResolvePathSymlinks(),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			seen = tc
+			return HookResult{Decision: Continue}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	_, _ = a.Run(ctx, "read /a")
+	if seen == nil {
+		t.Fatal("PreToolUse hook was not called")
+	}
+	if seen.workDir != dir || !seen.resolveSymlinks {
+		t.Errorf("ToolCall workDir = %q, resolveSymlinks = %v; want %q, true", seen.workDir, seen.resolveSymlinks, dir)
+	}
+}
+
+func TestRedirectPathCreate(t *testing.T) {
+	dir := t.TempDir()
+	tc := &ToolCall{
+		Name:    "Write",
+		Input:   map[string]any{"file_path": "/tmp/logs/today/run.log"},
+		workDir: dir,
+	}
+
+	result := RedirectPathCreate("/tmp", "sandbox/tmp").Evaluate(tc)
+	if result.Decision != Allow || result.UpdatedInput["file_path"] != "sandbox/tmp/logs/today/run.log" {
+		t.Fatalf("result = %+v, want Allow with the redirected path", result)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "sandbox", "tmp", "logs", "today")); err != nil || !info.IsDir() {
+		t.Errorf("parent directory not created under the WorkDir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sandbox", "tmp", "logs", "today", "run.log")); !os.IsNotExist(err) {
+		t.Errorf("Stat(run.log) error = %v, want the file itself left to the tool", err)
+	}
+
+	// RedirectPath leaves directories alone
+	tc.Input = map[string]any{"file_path": "/tmp/other/run.log"}
+	RedirectPath("/tmp", "sandbox/tmp").Evaluate(tc)
+	if _, err := os.Stat(filepath.Join(dir, "sandbox", "tmp", "other")); !os.IsNotExist(err) {
+		t.Errorf("Stat(other) error = %v, want RedirectPath not to create it", err)
+	}
+
+	// A directory that cannot be created denies the call
+	blocker := filepath.Join(dir, "file")
+	mustWriteFile(t, blocker, nil, 0o644)
+	tc.Input = map[string]any{"file_path": "/tmp/sub/run.log"}
+	if result := RedirectPathCreate("/tmp", blocker).Evaluate(tc); result.Decision != Deny {
+		t.Errorf("Decision = %v, want Deny when the directory cannot be created", result.Decision)
+	}
+}
+
+func TestRedirectPathLongestPrefix(t *testing.T) {
+	dir := t.TempDir()
+	broad := RedirectPathCreate("/tmp", filepath.Join(dir, "broad"))
+	narrow := RedirectPathCreate("/tmp/cache", filepath.Join(dir, "narrow"))
+
+	for name, opt := range map[string]Option{
+		"broad first":  PreToolUse(broad, narrow),
+		"narrow first": PreToolUse(narrow, broad),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := &config{}
+			opt(cfg)
+			chain := newHookChain(cfg.sortedPreToolUseHooks())
+			for path, want := range map[string]string{
+				"/tmp/cache/index.db": filepath.Join(dir, "narrow", "index.db"),
+				"/tmp/notes.txt":      filepath.Join(dir, "broad", "notes.txt"),
+			} {
+				tc := &ToolCall{Name: "Write", Input: map[string]any{"file_path": path}}
+				result := chain.evaluate(tc)
+				if got := result.UpdatedInput["file_path"]; got != want {
+					t.Errorf("%s redirected to %v, want %s", path, got, want)
+				}
+				if tc.rewrite == nil || tc.rewrite.mapping != (PathRedirect{Original: path, Redirected: want}) {
+					t.Errorf("%s recorded %+v, want the mapping to %s", path, tc.rewrite, want)
+				}
+			}
+		})
+	}
+
+	// Only the rule that rewrote the path created a directory
+	if _, err := os.Stat(filepath.Join(dir, "broad", "cache")); !os.IsNotExist(err) {
+		t.Errorf("Stat(broad/cache) error = %v, want it not created", err)
+	}
+}
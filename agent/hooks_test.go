@@ -175,6 +175,64 @@ func TestUpdatedInputPassedThroughChain(t *testing.T) {
 	}
 }
 
+func TestUpdatedInputPreservesCallContext(t *testing.T) {
+	var seen []ToolCall
+	record := func(update map[string]any) PreToolUseHook {
+		return func(tc *ToolCall) HookResult {
+			seen = append(seen, *tc)
+			return HookResult{Decision: Continue, UpdatedInput: update}
+		}
+	}
+
+	chain := newHookChain([]PreToolUseHook{
+		record(map[string]any{"path": "/a"}),
+		record(map[string]any{"path": "/b"}),
+		record(nil),
+	})
+	tc := &ToolCall{
+		Name:            "Read",
+		Input:           map[string]any{"path": "/orig"},
+		ID:              "toolu_1",
+		ParentToolUseID: "toolu_task",
+		AgentKind:       AgentSubagent,
+		SubagentType:    "Explore",
+	}
+
+	result := chain.evaluate(tc)
+	if result.UpdatedInput["path"] != "/b" {
+		t.Errorf("UpdatedInput[path] = %v, want /b", result.UpdatedInput["path"])
+	}
+
+	if len(seen) != 3 {
+		t.Fatalf("hooks called %d times, want 3", len(seen))
+	}
+	for i, got := range seen {
+		if got.ID != "toolu_1" || got.ParentToolUseID != "toolu_task" ||
+			got.AgentKind != AgentSubagent || got.SubagentType != "Explore" {
+			t.Errorf("hook %d saw %+v, want call context unchanged", i, got)
+		}
+	}
+	if seen[2].Input["path"] != "/b" {
+		t.Errorf("hook 3 saw path %v, want /b", seen[2].Input["path"])
+	}
+}
+
+func TestAgentKindFor(t *testing.T) {
+	tests := []struct {
+		parent, subagentType string
+		want                 AgentKind
+	}{
+		{"", "", AgentMain},
+		{"toolu_task", "", AgentSubagent},
+		{"", "Explore", AgentSubagent},
+	}
+	for _, tt := range tests {
+		if got := agentKindFor(tt.parent, tt.subagentType); got != tt.want {
+			t.Errorf("agentKindFor(%q, %q) = %q, want %q", tt.parent, tt.subagentType, got, tt.want)
+		}
+	}
+}
+
 func TestMultipleHooksCompose(t *testing.T) {
 	callOrder := []string{}
 
@@ -414,8 +472,11 @@ func TestStopReasonConstants(t *testing.T) {
 		StopMaxTurns:    true,
 		StopInterrupted: true,
 		StopError:       true,
+		StopCancelled:   true,
+		StopTimeout:     true,
+		StopShutdown:    true,
 	}
-	if len(reasons) != 4 {
+	if len(reasons) != 7 {
 		t.Error("stop reasons should be distinct")
 	}
 }
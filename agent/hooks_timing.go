@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"log/slog"
+	"time"
+)
+
+// timeHooks reports whether hook chains should measure each hook. Timing
+// is skipped when nothing would see the result.
+func (a *Agent) timeHooks() bool {
+	return a.auditor != nil || a.cfg.slowHookThreshold > 0
+}
+
+// checkHookDurations reports hooks slower than SlowHookThreshold with a
+// hook.slow audit event and a log warning, and returns the durations
+// formatted for audit data. hook names the chain, e.g. "pre_tool_use".
+func (a *Agent) checkHookDurations(hook string, tc *ToolCall, durations []time.Duration) []string {
+	if durations == nil {
+		return nil
+	}
+	threshold := a.cfg.slowHookThreshold
+	formatted := make([]string, len(durations))
+	for i, d := range durations {
+		formatted[i] = d.String()
+		if threshold <= 0 || d <= threshold {
+			continue
+		}
+		a.auditor.emit(a.SessionID(), "hook.slow", map[string]any{
+			"hook":        hook,
+			"index":       i,
+			"tool":        tc.Name,
+			"tool_use_id": tc.ID,
+			"duration":    d.String(),
+			"threshold":   threshold.String(),
+		})
+		slog.Warn("agent: slow hook",
+			"hook", hook,
+			"index", i,
+			"tool", tc.Name,
+			"duration", d,
+			"threshold", threshold,
+		)
+	}
+	return formatted
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookTimingCLI writes a fake CLI that requests permission for one Read
+// call and then reports its result.
+func hookTimingCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"timing-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"toolu_1","tool_name":"Read","tool_input":{"file_path":"/a"}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestSlowHookThreshold(t *testing.T) {
+	fast := func(*ToolCall) HookResult { return HookResult{Decision: Continue} }
+	slow := func(*ToolCall) HookResult {
+		time.Sleep(50 * time.Millisecond)
+		return HookResult{Decision: Continue}
+	}
+	slowPost := func(*ToolCall, *ToolResultContext) HookResult {
+		time.Sleep(50 * time.Millisecond)
+		return HookResult{Decision: Continue}
+	}
+
+	var mu sync.Mutex
+	events := map[string]map[string]any{}
+	var slowEvents []map[string]any
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(hookTimingCLI(t)),
+		PreToolUse(fast, slow),
+		PostToolUse(slowPost),
+		SlowHookThreshold(20*time.Millisecond),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			data, _ := e.Data.(map[string]any)
+			if e.Type == "hook.slow" {
+				slowEvents = append(slowEvents, data)
+			}
+			events[e.Type] = data
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "read"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Every hook that ran is timed, in chain order
+	pre, _ := events["hook.pre_tool_use"]["hook_durations"].([]string)
+	if len(pre) != 2 {
+		t.Fatalf("hook.pre_tool_use hook_durations = %v, want 2 entries", pre)
+	}
+	if d, err := time.ParseDuration(pre[1]); err != nil || d < 50*time.Millisecond {
+		t.Errorf("slow PreToolUse hook duration = %q, want at least 50ms", pre[1])
+	}
+	post, _ := events["hook.post_tool_use"]["hook_durations"].([]string)
+	if len(post) != 1 {
+		t.Fatalf("hook.post_tool_use hook_durations = %v, want 1 entry", post)
+	}
+
+	// Only the slow hooks are reported
+	if len(slowEvents) != 2 {
+		t.Fatalf("got %d hook.slow events, want 2: %v", len(slowEvents), slowEvents)
+	}
+	if e := slowEvents[0]; e["hook"] != "pre_tool_use" || e["index"] != 1 || e["tool"] != "Read" || e["threshold"] != "20ms" {
+		t.Errorf("first hook.slow = %v, want pre_tool_use hook 1 on Read", e)
+	}
+	if e := slowEvents[1]; e["hook"] != "post_tool_use" || e["index"] != 0 || e["tool_use_id"] != "toolu_1" {
+		t.Errorf("second hook.slow = %v, want post_tool_use hook 0 on toolu_1", e)
+	}
+}
+
+func TestHookChainEvaluateTimedStopsAtDecision(t *testing.T) {
+	calls := 0
+	count := func(d Decision) PreToolUseHook {
+		return func(*ToolCall) HookResult {
+			calls++
+			return HookResult{Decision: d}
+		}
+	}
+	chain := newHookChain([]PreToolUseHook{count(Continue), count(Deny), count(Allow)})
+
+	result, durations := chain.evaluateTimed(&ToolCall{Name: "Bash"}, true)
+	if result.Decision != Deny {
+		t.Errorf("Decision = %v, want Deny", result.Decision)
+	}
+	if calls != 2 || len(durations) != 2 {
+		t.Errorf("ran %d hooks with %d durations, want 2 of each", calls, len(durations))
+	}
+
+	// Untimed evaluation records nothing
+	if _, durations := chain.evaluateTimed(&ToolCall{Name: "Bash"}, false); durations != nil {
+		t.Errorf("untimed durations = %v, want nil", durations)
+	}
+}
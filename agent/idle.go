@@ -0,0 +1,110 @@
+package agent
+
+import "time"
+
+// IdleTimeout closes the agent once it has had no run in progress for d,
+// so that a server holding an agent per user session does not keep CLI
+// processes for sessions their users have left. A single timer is stopped
+// when a Run or Stream starts and restarted when it ends, so an agent is
+// never evicted mid-run.
+//
+// Eviction emits a session.evicted audit event with idle_seconds, then
+// closes the agent as Close does: Stop hooks see StopIdle, and the
+// session.end event has stop_reason "idle". onEvict, if not nil, is then
+// called with the same StopEvent; it runs on the timer's goroutine. Runs
+// started afterwards fail with an *EvictedError carrying the session ID,
+// so the caller can create a replacement with Resume. Close stops the
+// timer. A value of 0 or less never evicts (default).
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.IdleTimeout(15*time.Minute, func(e *agent.StopEvent) {
+//	    sessions.Forget(e.SessionID)
+//	}))
+//	...
+//	result, err := a.Run(ctx, prompt)
+//	var evicted *agent.EvictedError
+//	if errors.As(err, &evicted) {
+//	    a, _ = agent.New(ctx, agent.Resume(evicted.SessionID))
+//	    result, err = a.Run(ctx, prompt)
+//	}
+func IdleTimeout(d time.Duration, onEvict func(*StopEvent)) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+		c.onEvict = onEvict
+	}
+}
+
+// startIdleTimer starts timing the idle period after New.
+func (a *Agent) startIdleTimer() {
+	if a.cfg.idleTimeout <= 0 {
+		return
+	}
+	a.mu.Lock()
+	a.idleSince = time.Now()
+	a.idleTimer = time.AfterFunc(a.cfg.idleTimeout, a.evictIdle)
+	a.mu.Unlock()
+}
+
+// suspendIdleLocked stops the idle timer while a run is in progress.
+// Caller must hold a.mu.
+func (a *Agent) suspendIdleLocked() {
+	if a.idleTimer != nil {
+		a.idleTimer.Stop()
+	}
+}
+
+// resumeIdleLocked restarts the idle timer when a run ends. Caller must
+// hold a.mu.
+func (a *Agent) resumeIdleLocked() {
+	if a.idleTimer != nil && !a.closed {
+		a.idleSince = time.Now()
+		a.idleTimer.Reset(a.cfg.idleTimeout)
+	}
+}
+
+// evictIdle closes the agent if it is still idle when the timer fires. A
+// run may have started, or ended and restarted the timer, since the timer
+// fired; the agent is then left alone.
+func (a *Agent) evictIdle() {
+	d := a.cfg.idleTimeout
+	a.mu.Lock()
+	idle := time.Since(a.idleSince)
+	if a.closed || a.runID != "" || idle < d {
+		a.mu.Unlock()
+		return
+	}
+	sessionID := a.sessionID
+	a.evicted = &EvictedError{SessionID: sessionID, Idle: d}
+	a.stopReason = StopIdle
+	a.stopCause = ""
+	a.mu.Unlock()
+
+	a.auditor.emit(sessionID, "session.evicted", map[string]any{
+		"idle_seconds": idle.Seconds(),
+	})
+	_ = a.Close() // Reported by Close's audit events
+
+	if a.cfg.onEvict != nil {
+		a.mu.Lock()
+		event := &StopEvent{
+			SessionID: sessionID,
+			Reason:    StopIdle,
+			NumTurns:  a.totalTurns,
+			CostUSD:   a.totalCost,
+			Labels:    copyLabels(a.labels),
+			values:    a.runValues,
+		}
+		a.mu.Unlock()
+		a.cfg.onEvict(event)
+	}
+}
+
+// closedErrLocked returns the error for a run refused because the agent is
+// closed or evicted. Caller must hold a.mu.
+func (a *Agent) closedErrLocked() error {
+	if a.evicted != nil {
+		return a.evicted
+	}
+	return ErrAgentClosed
+}
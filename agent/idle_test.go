@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idleCLI writes a fake CLI that answers each prompt after delay and
+// appends its arguments to args.
+func idleCLI(t *testing.T, delay string) (cliPath, argsPath string) {
+	t.Helper()
+	dir := t.TempDir()
+	cliPath = filepath.Join(dir, "claude")
+	argsPath = filepath.Join(dir, "args")
+	script := `#!/bin/sh
+printf '%s\n' "$*" >> ` + argsPath + `
+while read line; do
+  sleep ` + delay + `
+  printf '%s\n' '{"type":"system","subtype":"init","session_id":"idle-1"}'
+  printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+done
+`
+	mustWriteFile(t, cliPath, []byte(script), 0755)
+	return cliPath, argsPath
+}
+
+func TestIdleTimeoutEvicts(t *testing.T) {
+	cliPath, argsPath := idleCLI(t, "0")
+	evicted := make(chan *StopEvent, 1)
+	var stopReason StopReason
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(cliPath),
+		IdleTimeout(50*time.Millisecond, func(e *StopEvent) { evicted <- e }),
+		OnStop(func(e *StopEvent) { stopReason = e.Reason }),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	select {
+	case e := <-evicted:
+		if e.Reason != StopIdle || e.SessionID != "idle-1" || e.NumTurns != 1 {
+			t.Errorf("onEvict event = %+v, want StopIdle for idle-1 after 1 turn", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("agent not evicted")
+	}
+	if stopReason != StopIdle {
+		t.Errorf("Stop hook reason = %s, want idle", stopReason)
+	}
+
+	// A run on the evicted agent names the session to resume
+	_, err = a.Run(ctx, "are you there?")
+	var evictedErr *EvictedError
+	if !errors.As(err, &evictedErr) || evictedErr.SessionID != "idle-1" {
+		t.Fatalf("Run() error = %v, want an *EvictedError for idle-1", err)
+	}
+	if !errors.Is(err, ErrAgentEvicted) || !errors.Is(err, ErrAgentClosed) {
+		t.Errorf("Run() error = %v, want it to match ErrAgentEvicted and ErrAgentClosed", err)
+	}
+
+	b, err := New(ctx, CLIPath(cliPath), Resume(evictedErr.SessionID))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, b)
+	if _, err := b.Run(ctx, "are you there?"); err != nil {
+		t.Fatalf("resumed Run() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(mustReadFile(t, argsPath))), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "--resume idle-1") {
+		t.Errorf("CLI args = %q, want the second start to resume idle-1", lines)
+	}
+}
+
+func TestIdleTimeoutSuspendedDuringRun(t *testing.T) {
+	cliPath, _ := idleCLI(t, "0.3")
+	evicted := make(chan *StopEvent, 1)
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(cliPath),
+		IdleTimeout(50*time.Millisecond, func(e *StopEvent) { evicted <- e }),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "take your time"); err != nil {
+		t.Fatalf("Run() error = %v, want the long run to complete", err)
+	}
+	select {
+	case <-evicted:
+		t.Fatal("agent evicted during the run")
+	default:
+	}
+
+	// The timer restarts when the run ends
+	select {
+	case <-evicted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("agent not evicted after the run")
+	}
+}
+
+func TestIdleTimeoutStoppedByClose(t *testing.T) {
+	cliPath, _ := idleCLI(t, "0")
+	evicted := make(chan *StopEvent, 1)
+	a, err := New(context.Background(),
+		CLIPath(cliPath),
+		IdleTimeout(20*time.Millisecond, func(e *StopEvent) { evicted <- e }),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mustClose(t, a)
+
+	select {
+	case <-evicted:
+		t.Error("closed agent evicted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := a.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil after Close", err)
+	}
+}
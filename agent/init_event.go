@@ -0,0 +1,96 @@
+package agent
+
+// InitEvent describes the CLI's session init message; see OnInit.
+type InitEvent struct {
+	SessionID      string
+	TranscriptPath string
+	Tools          []ToolInfo
+	MCPServers     []MCPStatus
+	// Raw is the init message's JSON line with KeepRaw, otherwise nil.
+	Raw []byte
+}
+
+// InitHook is called when a CLI process's session initializes; see OnInit.
+type InitHook func(e *InitEvent)
+
+// OnInit adds hooks called with the CLI's init message: its session ID,
+// transcript path, tools, and MCP servers. They are called once per CLI
+// process, so again after AutoRestart replaces it, and before the first
+// message of the run is delivered, so setup such as shipping the
+// transcript finishes before content flows. Hooks run on the goroutine
+// forwarding the run's messages and must not block; a panicking hook does
+// not affect the others.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.OnInit(func(e *agent.InitEvent) {
+//	    shipper.Watch(e.SessionID, e.TranscriptPath)
+//	}))
+func OnInit(hooks ...InitHook) Option {
+	return func(c *config) {
+		c.initHooks = append(c.initHooks, hooks...)
+	}
+}
+
+// KeepRaw keeps the JSON line of the CLI's init message, for InitEvent.Raw
+// and SystemInit.Raw. It is off by default, as most agents never read it.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.KeepRaw(true),
+//	    agent.OnInit(func(e *agent.InitEvent) {
+//	        archive.Put("init/"+e.SessionID+".json", e.Raw)
+//	    }),
+//	)
+func KeepRaw(keep bool) Option {
+	return func(c *config) {
+		c.keepRaw = keep
+	}
+}
+
+// handleInit records the session of an init message, reports it as a
+// session.init audit event, and calls OnMCPStatus and OnInit hooks. OnInit
+// hooks are called for the first init of each CLI process.
+func (a *Agent) handleInit(init *SystemInit) {
+	sessionID := a.initSession(init)
+	a.auditor.emit(sessionID, "session.init", map[string]any{
+		"transcript_path": init.TranscriptPath,
+		"tools":           init.Tools,
+		"mcp_servers":     init.MCPServers,
+	})
+	if hooks := a.cfg.mcpStatusHooks; len(hooks) > 0 {
+		a.runHook(func() {
+			for _, s := range init.MCPServers {
+				callMCPStatusHooks(hooks, s)
+			}
+		})
+	}
+
+	hooks := a.cfg.initHooks
+	if len(hooks) == 0 {
+		return
+	}
+	a.mu.Lock()
+	seen := a.initSeen
+	a.initSeen = true
+	a.mu.Unlock()
+	if seen {
+		return
+	}
+	e := &InitEvent{
+		SessionID:      sessionID,
+		TranscriptPath: init.TranscriptPath,
+		Tools:          init.Tools,
+		MCPServers:     init.MCPServers,
+		Raw:            init.Raw,
+	}
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				_ = recover()
+			}()
+			hook(e)
+		}()
+	}
+}
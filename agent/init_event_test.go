@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// initOrder records OnInit events and delivered messages in one log.
+type initOrder struct {
+	mu     sync.Mutex
+	log    []string
+	events []*InitEvent
+}
+
+func (o *initOrder) hook(e *InitEvent) {
+	time.Sleep(20 * time.Millisecond) // Setup that content must wait for
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.log = append(o.log, "init:"+e.SessionID)
+	o.events = append(o.events, e)
+}
+
+func (o *initOrder) add(entry string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.log = append(o.log, entry)
+}
+
+func TestOnInit(t *testing.T) {
+	// Like the CLI, the fake sends an init message at the start of each turn
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+while read line; do
+	printf '%s\n' '{"type":"system","subtype":"init","session_id":"inited","transcript_path":"/tmp/inited.jsonl","tools":["Read","Bash"],"mcp_servers":[{"name":"docs","status":"connected"}]}'
+	printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello"}]}}'
+	printf '%s\n' '{"type":"result","result":"done","num_turns":1}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	for _, keepRaw := range []bool{false, true} {
+		var order initOrder
+		ctx := context.Background()
+		a, err := New(ctx, CLIPath(fakeClaude), KeepRaw(keepRaw), OnInit(func(*InitEvent) {
+			panic("setup failed")
+		}, order.hook))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			for msg := range a.Stream(ctx, "hi") {
+				if text, ok := msg.(*Text); ok {
+					order.add("text:" + text.Text)
+				}
+			}
+		}
+		mustClose(t, a)
+
+		// Once per process, before the first Text, despite the panicking hook
+		order.mu.Lock()
+		if got, want := strings.Join(order.log, ","), "init:inited,text:Hello,text:Hello"; got != want {
+			t.Errorf("KeepRaw(%v): order = %s, want %s", keepRaw, got, want)
+		}
+		e := order.events[0]
+		if e.TranscriptPath != "/tmp/inited.jsonl" || len(e.Tools) != 2 || e.Tools[1].Name != "Bash" ||
+			len(e.MCPServers) != 1 || e.MCPServers[0].Status != "connected" {
+			t.Errorf("InitEvent = %+v, want the init message's fields", e)
+		}
+		if keepRaw != strings.Contains(string(e.Raw), `"transcript_path":"/tmp/inited.jsonl"`) {
+			t.Errorf("KeepRaw(%v): Raw = %q", keepRaw, e.Raw)
+		}
+		order.mu.Unlock()
+	}
+}
+
+func TestOnInitAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	var order initOrder
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(restartCLI(t, dir, crashAfterResult)),
+		AutoRestart(RestartPolicy{MaxRestarts: 1}),
+		OnInit(order.hook),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			waitExited(t, a)
+		}
+		if _, err := a.Run(ctx, "hello"); err != nil {
+			t.Fatalf("Run() %d error = %v", i+1, err)
+		}
+	}
+	order.mu.Lock()
+	defer order.mu.Unlock()
+	if got, want := strings.Join(order.log, ","), "init:sess-1,init:sess-2"; got != want {
+		t.Errorf("OnInit calls = %s, want one per process", got)
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import "fmt"
+
+// maxLabelKeyLen is the maximum length of a label key.
+const maxLabelKeyLen = 128
+
+// validateLabelKey checks that a label key is non-empty and uses only
+// letters, digits, '.', '_', '-' and '/', starting with a letter or digit.
+func validateLabelKey(key string) error {
+	if key == "" {
+		return &LabelError{Key: key, Reason: "key is empty"}
+	}
+	if len(key) > maxLabelKeyLen {
+		return &LabelError{Key: key, Reason: fmt.Sprintf("key exceeds %d characters", maxLabelKeyLen)}
+	}
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case i > 0 && (r == '.' || r == '_' || r == '-' || r == '/'):
+		default:
+			return &LabelError{Key: key, Reason: fmt.Sprintf("invalid character %q at position %d", r, i)}
+		}
+	}
+	return nil
+}
+
+// copyLabels returns a copy of labels, or nil if there are none.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
+
+// SetLabel adds or replaces a label on the agent. The label is attached to
+// audit events and StopEvent from this point on, including events of a run
+// that is already streaming. It is safe to call concurrently with Run.
+func (a *Agent) SetLabel(key, value string) error {
+	if err := validateLabelKey(key); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Replace rather than mutate so snapshots already handed out stay unchanged
+	labels := make(map[string]string, len(a.labels)+1)
+	for k, v := range a.labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	a.labels = labels
+	a.auditor.setLabels(labels)
+	return nil
+}
+
+// Labels returns a copy of the agent's labels.
+func (a *Agent) Labels() map[string]string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return copyLabels(a.labels)
+}
@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidateLabelKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"tenant", true},
+		{"cost-center", true},
+		{"team.name", true},
+		{"example.com/workflow_id", true},
+		{"A1", true},
+		{"", false},
+		{"-leading", false},
+		{"has space", false},
+		{"emoji🙂", false},
+		{strings.Repeat("k", maxLabelKeyLen), true},
+		{strings.Repeat("k", maxLabelKeyLen+1), false},
+	}
+
+	for _, tt := range tests {
+		err := validateLabelKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("validateLabelKey(%q) error = %v, want nil", tt.key, err)
+		}
+		if !tt.valid {
+			var labelErr *LabelError
+			if !errors.As(err, &labelErr) {
+				t.Errorf("validateLabelKey(%q) error = %v, want *LabelError", tt.key, err)
+			}
+		}
+	}
+}
+
+func TestLabelsOptionMerges(t *testing.T) {
+	cfg := newConfig(
+		Labels(map[string]string{"tenant": "acme", "env": "dev"}),
+		Labels(map[string]string{"env": "prod"}),
+	)
+
+	if cfg.schemaError != nil {
+		t.Fatalf("schemaError = %v, want nil", cfg.schemaError)
+	}
+	if cfg.labels["tenant"] != "acme" || cfg.labels["env"] != "prod" {
+		t.Errorf("labels = %v, want tenant=acme env=prod", cfg.labels)
+	}
+}
+
+func TestNewRejectsInvalidLabelKey(t *testing.T) {
+	_, err := New(context.Background(), Labels(map[string]string{"bad key": "x"}))
+
+	var labelErr *LabelError
+	if !errors.As(err, &labelErr) {
+		t.Fatalf("New() error = %v, want *LabelError", err)
+	}
+	if labelErr.Key != "bad key" {
+		t.Errorf("Key = %q, want %q", labelErr.Key, "bad key")
+	}
+}
+
+func TestAuditEventLabelsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	aud := newAuditor([]AuditHandler{AuditWriterHandler(&buf)})
+
+	aud.emit("sess-1", "plain", nil)
+	aud.setLabels(map[string]string{"tenant": "acme"})
+	aud.emit("sess-1", "labeled", nil)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+
+	// Events without labels omit the field entirely
+	if strings.Contains(lines[0], `"labels"`) {
+		t.Errorf("unlabeled event = %s, want no labels field", lines[0])
+	}
+
+	var event map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	labels, ok := event["labels"].(map[string]any)
+	if !ok || labels["tenant"] != "acme" {
+		t.Errorf("labels = %v, want {tenant: acme}", event["labels"])
+	}
+}
+
+func TestLabelsPropagateToEvents(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+while read line; do
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"labels-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"hi"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var events []AuditEvent
+	var stop *StopEvent
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Labels(map[string]string{"tenant": "acme"}),
+		Audit(func(e AuditEvent) {
+			mu.Lock()
+			events = append(events, e)
+			mu.Unlock()
+		}),
+		OnStop(func(e *StopEvent) { stop = e }),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := a.Run(ctx, "first"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if err := a.SetLabel("workflow", "review"); err != nil {
+		t.Fatalf("SetLabel() error = %v", err)
+	}
+	if err := a.SetLabel("", "x"); err == nil {
+		t.Error("SetLabel() with empty key should fail")
+	}
+	if _, err := a.Run(ctx, "second"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mustClose(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range events {
+		if e.Labels["tenant"] != "acme" {
+			t.Errorf("%s event labels = %v, want tenant=acme", e.Type, e.Labels)
+		}
+	}
+	if last := events[len(events)-1]; last.Labels["workflow"] != "review" {
+		t.Errorf("%s event labels = %v, want workflow=review", last.Type, last.Labels)
+	}
+
+	if stop == nil {
+		t.Fatal("Stop hook not called")
+	}
+	if stop.Labels["tenant"] != "acme" || stop.Labels["workflow"] != "review" {
+		t.Errorf("StopEvent.Labels = %v, want tenant=acme workflow=review", stop.Labels)
+	}
+}
+
+func TestSetLabelConcurrentWithStream(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"labels-race"}'
+i=0
+while [ $i -lt 50 ]; do
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"tick"}]}}'
+i=$((i+1))
+done
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Audit(func(e AuditEvent) {
+			for range e.Labels { // Read labels while SetLabel runs
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_ = a.SetLabel(fmt.Sprintf("k%d", i), "v")
+			_ = a.Labels()
+		}
+	}()
+
+	for range a.Stream(ctx, "go") {
+	}
+	wg.Wait()
+
+	if err := a.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if got := len(a.Labels()); got != 50 {
+		t.Errorf("len(Labels()) = %d, want 50", got)
+	}
+}
+
+func TestStatsByLabels(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(resultCLI(t, "ok")), Labels(map[string]string{"team": "search"}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for _, tenant := range []string{"acme", "globex", "acme"} {
+		if err := a.SetLabel("tenant", tenant); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.Run(ctx, "hello"); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+
+	// Grouped by label set and sorted, adding up to Stats
+	want := []LabeledStats{
+		{Labels: map[string]string{"team": "search", "tenant": "acme"}, Stats: Stats{Runs: 2, CostUSD: 0.5, PromptBytes: 10}},
+		{Labels: map[string]string{"team": "search", "tenant": "globex"}, Stats: Stats{Runs: 1, CostUSD: 0.25, PromptBytes: 5}},
+	}
+	if got := a.StatsByLabels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("StatsByLabels() = %+v, want %+v", got, want)
+	}
+	if got := a.Stats(); got.Runs != 3 || got.CostUSD != 0.75 || got.PromptBytes != 15 {
+		t.Errorf("Stats() = %+v, want the groups' sum", got)
+	}
+
+	// Groups are copies
+	a.StatsByLabels()[0].Labels["tenant"] = "changed"
+	if got := a.StatsByLabels()[0].Labels["tenant"]; got != "acme" {
+		t.Errorf("StatsByLabels() shares its labels: tenant = %q", got)
+	}
+}
@@ -10,8 +10,23 @@ type MCPConfig struct {
 	URL       string            // Server URL (sse/http only)
 	Headers   map[string]string // Request headers (sse/http only)
 	Env       map[string]string // Environment variables (stdio only)
+
+	// Managed makes the SDK run the stdio server rather than the CLI; see
+	// MCPManaged. MaxRestarts is how many times a managed server is
+	// restarted after it exits unexpectedly.
+	Managed     bool
+	MaxRestarts int
+
+	// AllowTools and DenyTools are patterns for the names of the server's
+	// tools; see MCPAllowTools and MCPDenyTools.
+	AllowTools []string
+	DenyTools  []string
 }
 
+// MCPStatusHook is called with the status of an MCP server; see
+// OnMCPStatus.
+type MCPStatusHook func(s MCPStatus)
+
 // MCPOption configures an MCP server.
 type MCPOption func(*MCPConfig)
 
@@ -30,6 +45,44 @@ func MCPArgs(args ...string) MCPOption {
 	}
 }
 
+// MCPManaged makes the SDK run a stdio server itself instead of asking the
+// CLI to. The SDK starts the command when the agent starts, in a process
+// group of its own, and connects the CLI to it over HTTP on a loopback
+// address. Close stops the server and kills its process group, including
+// any processes it started, after the CLI has exited or been killed, so a
+// managed server never outlives the agent.
+//
+// Status changes are reported to OnMCPStatus hooks and as mcp.status audit
+// events. A server that exits unexpectedly is failed, unless
+// MCPMaxRestarts allows it to be restarted.
+//
+// The bridge relays messages in both directions, as MCP's streamable HTTP
+// transport does: the CLI's requests, notifications, and batches go to the
+// server, and the server's requests and notifications, such as sampling
+// requests and progress, reach the CLI on an event stream.
+//
+// Example:
+//
+//	agent.MCPServer("internal",
+//	    agent.MCPCommand("/usr/local/bin/internal-mcp"),
+//	    agent.MCPManaged(true),
+//	    agent.MCPMaxRestarts(3),
+//	)
+func MCPManaged(managed bool) MCPOption {
+	return func(c *MCPConfig) {
+		c.Managed = managed
+	}
+}
+
+// MCPMaxRestarts sets how many times a managed server is restarted after
+// it exits unexpectedly. The default is 0: the server is not restarted.
+// A restarted server is sent the CLI's initialize request again.
+func MCPMaxRestarts(n int) MCPOption {
+	return func(c *MCPConfig) {
+		c.MaxRestarts = n
+	}
+}
+
 // MCPSSE sets the transport to "sse" (Server-Sent Events) and specifies the URL.
 func MCPSSE(url string) MCPOption {
 	return func(c *MCPConfig) {
@@ -0,0 +1,731 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of an MCP server managed by the SDK, reported to OnMCPStatus
+// hooks. The CLI reports its own statuses, such as "connected" and
+// "failed", for the servers it runs.
+const (
+	MCPStatusConnected  = "connected"  // The server is running
+	MCPStatusRestarting = "restarting" // The server exited and is about to be restarted
+	MCPStatusFailed     = "failed"     // The server exited and will not be restarted
+	MCPStatusStopped    = "stopped"    // The server was stopped with the agent
+)
+
+// mcpRestartDelay is the pause before a managed server is restarted.
+var mcpRestartDelay = 100 * time.Millisecond
+
+// mcpStopTimeout is how long a managed server has to exit after its stdin
+// is closed before its process group is killed.
+var mcpStopTimeout = 2 * time.Second
+
+// mcpMaxMessageBytes limits the size of a message sent through the bridge.
+const mcpMaxMessageBytes = 64 << 20
+
+// mcpMaxQueuedMessages limits the messages from a managed server that wait
+// for the CLI to open its event stream. Beyond it, the server's requests
+// are answered with an error and its notifications are dropped.
+const mcpMaxQueuedMessages = 256
+
+// validateManagedMCP checks that each managed MCP server runs a command.
+func validateManagedMCP(cfg *config) error {
+	for _, name := range sortedMCPNames(cfg) {
+		mcp := cfg.mcpServers[name]
+		if !mcp.Managed {
+			continue
+		}
+		if (mcp.Transport != "stdio" && mcp.Transport != "") || mcp.Command == "" {
+			return &ConfigError{
+				Option: "MCPManaged",
+				Value:  name,
+				Reason: "a managed MCP server needs a stdio command; set it with MCPCommand",
+			}
+		}
+		if mcp.MaxRestarts < 0 {
+			return &ConfigError{
+				Option: "MCPMaxRestarts",
+				Value:  fmt.Sprint(mcp.MaxRestarts),
+				Reason: "MCP server " + name + ": must not be negative",
+			}
+		}
+	}
+	return nil
+}
+
+// sortedMCPNames returns the names of the configured MCP servers in order.
+func sortedMCPNames(cfg *config) []string {
+	names := make([]string, 0, len(cfg.mcpServers))
+	for name := range cfg.mcpServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// managedMCP runs the MCP servers configured with MCPManaged. Each server
+// is a child process of the SDK in its own process group, and the CLI
+// reaches it over HTTP on a loopback address, through a bridge that
+// relays JSON-RPC messages to the server's stdin and its responses back.
+// The bridge only accepts requests that carry its random bearer token,
+// which the CLI is given in the server's Headers, so other local processes
+// and web pages cannot reach the server. The servers' configuration is
+// passed to the CLI in a file only the user can read, not on its command
+// line, where any local user could read the tokens. A nil *managedMCP has
+// no servers.
+type managedMCP struct {
+	servers []*managedServer
+	hooks   []MCPStatusHook
+	auditor *auditor
+	workDir string
+	scratch string                // The agent's ScratchDir, where the config file is written
+	tempDir string                // Created for the config file when there is no ScratchDir
+	file    string                // The config file
+	agent   atomic.Pointer[Agent] // Set once the agent exists, for its session ID
+}
+
+// newManagedMCP returns the managed servers in cfg, not yet started, or
+// nil if there are none.
+func newManagedMCP(cfg *config, aud *auditor) *managedMCP {
+	m := &managedMCP{hooks: cfg.mcpStatusHooks, auditor: aud, workDir: cfg.workDir, scratch: cfg.scratchPath}
+	for _, name := range sortedMCPNames(cfg) {
+		if mcp := cfg.mcpServers[name]; mcp.Managed {
+			m.servers = append(m.servers, &managedServer{cfg: *mcp, set: m})
+		}
+	}
+	if len(m.servers) == 0 {
+		return nil
+	}
+	return m
+}
+
+// start starts the servers and returns a copy of cfg in which they are
+// configured as HTTP servers at their bridge addresses. If a server fails
+// to start, the others are stopped.
+func (m *managedMCP) start(cfg *config) (*config, error) {
+	if m == nil {
+		return cfg, nil
+	}
+	for _, s := range m.servers {
+		if err := s.start(); err != nil {
+			m.stop()
+			return nil, &StartError{Reason: "failed to start MCP server " + s.cfg.Name, Cause: err}
+		}
+	}
+	if err := m.writeConfig(); err != nil {
+		m.stop()
+		return nil, &StartError{Reason: "failed to write MCP server config", Cause: err}
+	}
+	return m.configFor(cfg), nil
+}
+
+// writeConfig writes the --mcp-config file for the running servers, with
+// mode 0600, to the scratch directory or to a temporary directory of its
+// own.
+func (m *managedMCP) writeConfig() error {
+	servers := make(map[string]any, len(m.servers))
+	for _, s := range m.servers {
+		servers[s.cfg.Name] = mcpServerJSON(s.httpConfig())
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return err
+	}
+	dir := m.scratch
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "claude-agent-mcp-"); err != nil {
+			return err
+		}
+		m.tempDir = dir
+	}
+	f, err := os.CreateTemp(dir, "mcp-config-*.json") // Created with mode 0600
+	if err != nil {
+		return err
+	}
+	m.file = f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// configFor returns a copy of cfg that points the CLI at the running
+// servers' config file.
+func (m *managedMCP) configFor(cfg *config) *config {
+	if m == nil {
+		return cfg
+	}
+	n := *cfg
+	n.mcpServers = make(map[string]*MCPConfig, len(cfg.mcpServers))
+	for name, mcp := range cfg.mcpServers {
+		n.mcpServers[name] = mcp
+	}
+	for _, s := range m.servers {
+		n.mcpServers[s.cfg.Name] = s.httpConfig()
+	}
+	n.mcpConfigFile = m.file
+	return &n
+}
+
+// stop stops the servers and their bridges and removes the config file.
+func (m *managedMCP) stop() {
+	if m == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, s := range m.servers {
+		wg.Add(1)
+		go func(s *managedServer) {
+			defer wg.Done()
+			s.stop()
+		}(s)
+	}
+	wg.Wait()
+	if m.file != "" {
+		_ = os.Remove(m.file) // Best effort; the tokens no longer work
+	}
+	if m.tempDir != "" {
+		_ = os.RemoveAll(m.tempDir)
+	}
+}
+
+// setAgent attaches the agent whose session audit events belong to.
+func (m *managedMCP) setAgent(a *Agent) {
+	if m != nil {
+		m.agent.Store(a)
+	}
+}
+
+// notify reports a server's status to OnMCPStatus hooks and as an
+// mcp.status audit event.
+func (m *managedMCP) notify(status MCPStatus, cause error) {
+	sessionID := ""
+	if a := m.agent.Load(); a != nil {
+		a.mu.Lock()
+		sessionID = a.sessionID
+		a.mu.Unlock()
+	}
+	data := map[string]any{
+		"name":     status.Name,
+		"status":   status.Status,
+		"restarts": status.Restarts,
+		"managed":  true,
+	}
+	if cause != nil {
+		data["error"] = cause.Error()
+	}
+	m.auditor.emit(sessionID, "mcp.status", data)
+	callMCPStatusHooks(m.hooks, status)
+}
+
+// callMCPStatusHooks calls each OnMCPStatus hook, recovering panics so
+// that one hook cannot stop the others.
+func callMCPStatusHooks(hooks []MCPStatusHook, status MCPStatus) {
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				_ = recover()
+			}()
+			hook(status)
+		}()
+	}
+}
+
+// managedServer is one managed MCP server and its HTTP bridge.
+type managedServer struct {
+	cfg   MCPConfig
+	set   *managedMCP
+	url   string
+	token string // Bearer token the CLI sends with each request
+	srv   *http.Server
+
+	wmu sync.Mutex // Serializes writes to the server's stdin
+
+	events chan []byte // Requests and notifications from the server, for the CLI's event stream
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	exited   chan struct{}          // Closed when the current process has exited
+	waiters  map[string]chan []byte // Responses awaited by the bridge, by request ID
+	replay   [][]byte               // Initialization messages, sent again after a restart
+	restarts int
+	status   string
+	stopping bool
+
+	reports   []statusReport // Status changes not yet reported
+	reporting bool           // A goroutine is calling the status hooks
+}
+
+// statusReport is a status change of a managed server, reported to the
+// OnMCPStatus hooks by reportStatus.
+type statusReport struct {
+	status MCPStatus
+	cause  error
+}
+
+// httpConfig returns the server as the CLI reaches it: an HTTP server at
+// its bridge's address, with its bearer token.
+func (s *managedServer) httpConfig() *MCPConfig {
+	c := s.cfg
+	c.Transport = "http"
+	c.Command, c.Args, c.Env = "", nil, nil
+	c.URL = s.url
+	c.Headers = map[string]string{"Authorization": "Bearer " + s.token}
+	return &c
+}
+
+// start starts the bridge and the server process.
+func (s *managedServer) start() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	var token [32]byte
+	_, _ = rand.Read(token[:]) // crypto/rand.Read does not fail on supported platforms
+	s.token = hex.EncodeToString(token[:])
+	s.url = "http://" + ln.Addr().String() + "/mcp"
+	s.events = make(chan []byte, mcpMaxQueuedMessages)
+	s.srv = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() { _ = s.srv.Serve(ln) }()
+
+	s.mu.Lock()
+	if err := s.launchLocked(); err != nil {
+		s.mu.Unlock()
+		_ = s.srv.Close()
+		return err
+	}
+	s.setStatusLocked(MCPStatusConnected, nil)
+	s.mu.Unlock()
+	s.reportStatus()
+	return nil
+}
+
+// launchLocked starts the server process. s.mu must be held.
+func (s *managedServer) launchLocked() error {
+	cmd := exec.Command(s.cfg.Command, s.cfg.Args...) // #nosec G204 -- command is configured by the application
+	cmd.Dir = s.set.workDir
+	cmd.Env = os.Environ()
+	for k, v := range s.cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	// Its own process group, so stop can kill anything the server spawns
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.exited = make(chan struct{})
+	s.waiters = make(map[string]chan []byte)
+	s.status = MCPStatusConnected
+	for _, msg := range s.replay {
+		_ = s.write(stdin, msg) // Responses to replayed requests have no waiter and are dropped
+	}
+	go s.read(cmd, stdin, stdout, s.exited)
+	return nil
+}
+
+// read relays the server's output to the bridge until the process exits,
+// then restarts it or reports it failed.
+func (s *managedServer) read(cmd *exec.Cmd, stdin io.Writer, stdout io.Reader, exited chan struct{}) {
+	r := bufio.NewReader(stdout)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			s.deliver(stdin, line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	waitErr := cmd.Wait()
+	close(exited)
+	s.exit(waitErr)
+}
+
+// rpcMessage holds the fields of a JSON-RPC message the bridge routes on.
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+}
+
+// deliver passes a response from the server to the request waiting for
+// it, and a request or notification from the server to the CLI's event
+// stream. If too many messages are waiting for the stream, a request is
+// answered with an error and a notification is dropped.
+func (s *managedServer) deliver(stdin io.Writer, line []byte) {
+	var msg rpcMessage
+	if json.Unmarshal(line, &msg) != nil {
+		return
+	}
+	if msg.Method != "" {
+		select {
+		case s.events <- bytes.TrimSpace(line):
+		default:
+			if msg.ID != nil {
+				reply, _ := json.Marshal(map[string]any{
+					"jsonrpc": "2.0",
+					"id":      msg.ID,
+					"error":   map[string]any{"code": -32603, "message": "too many messages waiting for the client"},
+				})
+				// Not on this goroutine, which must keep reading for the
+				// server to keep reading its stdin
+				go s.write(stdin, append(reply, '\n'))
+			}
+		}
+		return
+	}
+	if msg.ID == nil {
+		return
+	}
+	id := compactID(msg.ID)
+	s.mu.Lock()
+	ch, ok := s.waiters[id]
+	delete(s.waiters, id)
+	s.mu.Unlock()
+	if ok {
+		ch <- line
+	}
+}
+
+// write writes a message to the server's stdin.
+func (s *managedServer) write(stdin io.Writer, msg []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, err := stdin.Write(msg)
+	return err
+}
+
+// compactID returns a JSON-RPC ID in a canonical form for matching.
+func compactID(id json.RawMessage) string {
+	var b bytes.Buffer
+	if json.Compact(&b, id) != nil {
+		return string(id)
+	}
+	return b.String()
+}
+
+// exit handles the end of the server process: requests waiting for it
+// fail, and it is restarted if it crashed and has restarts left.
+func (s *managedServer) exit(waitErr error) {
+	defer s.reportStatus()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ch := range s.waiters {
+		close(ch)
+		delete(s.waiters, id)
+	}
+	if s.stopping {
+		return
+	}
+	if waitErr == nil {
+		waitErr = errors.New("exited")
+	}
+	if s.restarts >= s.cfg.MaxRestarts {
+		s.setStatusLocked(MCPStatusFailed, waitErr)
+		return
+	}
+	s.restarts++
+	s.setStatusLocked(MCPStatusRestarting, waitErr)
+
+	s.mu.Unlock()
+	s.reportStatus()
+	time.Sleep(mcpRestartDelay)
+	s.mu.Lock()
+	if s.stopping {
+		return
+	}
+	if err := s.launchLocked(); err != nil {
+		s.setStatusLocked(MCPStatusFailed, err)
+		return
+	}
+	s.setStatusLocked(MCPStatusConnected, nil)
+}
+
+// setStatusLocked sets the server's status and queues its report for
+// reportStatus. s.mu must be held.
+func (s *managedServer) setStatusLocked(status string, cause error) {
+	s.status = status
+	s.reports = append(s.reports, statusReport{
+		status: MCPStatus{Name: s.cfg.Name, Status: status, Restarts: s.restarts},
+		cause:  cause,
+	})
+}
+
+// reportStatus reports the queued status changes in order. The hooks are
+// called without s.mu held, so they may call back into the agent; if
+// another goroutine is already reporting, it reports these too. s.mu must
+// not be held.
+func (s *managedServer) reportStatus() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reporting {
+		return
+	}
+	s.reporting = true
+	for len(s.reports) > 0 {
+		r := s.reports[0]
+		s.reports = s.reports[1:]
+		s.mu.Unlock()
+		s.set.notify(r.status, r.cause)
+		s.mu.Lock()
+	}
+	s.reporting = false
+}
+
+// authorized reports whether a request comes from the CLI: it carries the
+// bridge's token, names a loopback host, and, if it comes from a web page,
+// one served from a loopback host. The host checks stop DNS rebinding.
+func (s *managedServer) authorized(r *http.Request) (int, string) {
+	auth := r.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+		return http.StatusUnauthorized, "missing or wrong bearer token"
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if !isLoopbackHost(host) {
+		return http.StatusForbidden, "host must be a loopback address"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !isLoopbackHost(u.Hostname()) {
+			return http.StatusForbidden, "origin must be a loopback address"
+		}
+	}
+	return http.StatusOK, ""
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP relays JSON-RPC messages between the CLI and the server, as
+// MCP's streamable HTTP transport does. A POST carries one message or a
+// batch of them to the server: requests wait for the server's responses,
+// and notifications and responses are accepted without one. A GET opens an
+// event stream for the requests and notifications the server sends on its
+// own. Requests that are not from the CLI are refused before anything
+// else.
+func (s *managedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if code, reason := s.authorized(r); code != http.StatusOK {
+		http.Error(w, reason, code)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet:
+		s.serveEvents(w, r)
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, mcpMaxMessageBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	batch := bytes.HasPrefix(body, []byte("["))
+	raws := []json.RawMessage{body}
+	if batch {
+		if json.Unmarshal(body, &raws) != nil || len(raws) == 0 {
+			http.Error(w, "body must be a non-empty JSON-RPC batch", http.StatusBadRequest)
+			return
+		}
+	}
+	msgs := make([]rpcMessage, len(raws))
+	for i, raw := range raws {
+		if err := json.Unmarshal(raw, &msgs[i]); err != nil {
+			http.Error(w, "body must be a JSON-RPC message or batch", http.StatusBadRequest)
+			return
+		}
+	}
+
+	replies, exited, code, err := s.send(raws, msgs)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	if len(replies) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	responses := make([][]byte, 0, len(replies))
+	for _, reply := range replies {
+		select {
+		case resp, ok := <-reply.ch:
+			if !ok {
+				http.Error(w, "MCP server "+s.cfg.Name+" exited", http.StatusBadGateway)
+				return
+			}
+			responses = append(responses, bytes.TrimSpace(resp))
+		case <-exited:
+			http.Error(w, "MCP server "+s.cfg.Name+" exited", http.StatusBadGateway)
+			return
+		case <-r.Context().Done():
+			s.mu.Lock()
+			for _, reply := range replies {
+				delete(s.waiters, reply.id)
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !batch {
+		_, _ = w.Write(append(responses[0], '\n'))
+		return
+	}
+	_, _ = w.Write(append(append([]byte("["), bytes.Join(responses, []byte(","))...), ']', '\n'))
+}
+
+// pendingReply is a request relayed to the server and the channel its
+// response arrives on.
+type pendingReply struct {
+	id string
+	ch chan []byte
+}
+
+// send writes messages from the CLI to the server, in order, and returns
+// the replies to wait for, one per request, with the channel closed when
+// the server exits. Initialization messages are kept to replay after a
+// restart. An error comes with the HTTP status to answer it with.
+func (s *managedServer) send(raws []json.RawMessage, msgs []rpcMessage) ([]pendingReply, chan struct{}, int, error) {
+	lines := make([][]byte, len(raws))
+	for i, raw := range raws {
+		lines[i] = append(bytes.ReplaceAll(raw, []byte("\n"), nil), '\n')
+	}
+
+	s.mu.Lock()
+	if s.status != MCPStatusConnected {
+		status := s.status
+		s.mu.Unlock()
+		return nil, nil, http.StatusServiceUnavailable, errors.New("MCP server " + s.cfg.Name + " is " + status)
+	}
+	var replies []pendingReply
+	for i, msg := range msgs {
+		if msg.Method == "initialize" || msg.Method == "notifications/initialized" {
+			if msg.Method == "initialize" {
+				s.replay = nil
+			}
+			s.replay = append(s.replay, lines[i])
+		}
+		if msg.ID != nil && msg.Method != "" {
+			reply := pendingReply{id: compactID(msg.ID), ch: make(chan []byte, 1)}
+			s.waiters[reply.id] = reply.ch
+			replies = append(replies, reply)
+		}
+	}
+	stdin, exited := s.stdin, s.exited
+	s.mu.Unlock()
+
+	for _, line := range lines {
+		if err := s.write(stdin, line); err != nil {
+			s.mu.Lock()
+			for _, reply := range replies {
+				delete(s.waiters, reply.id)
+			}
+			s.mu.Unlock()
+			return nil, nil, http.StatusBadGateway, fmt.Errorf("MCP server %s: %w", s.cfg.Name, err)
+		}
+	}
+	return replies, exited, http.StatusOK, nil
+}
+
+// serveEvents streams the server's requests and notifications to the CLI
+// as server-sent events until the CLI disconnects. Messages sent while no
+// stream is open wait for the next one; each goes to one stream.
+func (s *managedServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case msg := <-s.events:
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg); err != nil {
+				select {
+				case s.events <- msg: // For the next stream
+				default:
+				}
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// stop closes the server's stdin, kills its process group if it has not
+// exited within mcpStopTimeout, and closes the bridge. The group is killed
+// after the server exits too, so processes it started cannot outlive it.
+func (s *managedServer) stop() {
+	s.mu.Lock()
+	s.stopping = true
+	cmd, stdin, exited := s.cmd, s.stdin, s.exited
+	s.mu.Unlock()
+	if s.srv == nil {
+		return // Never started
+	}
+
+	if cmd != nil {
+		_ = stdin.Close() // Signals EOF to the server
+		select {
+		case <-exited:
+		case <-time.After(mcpStopTimeout):
+		}
+		killProcessGroup(cmd)
+		<-exited
+	}
+	_ = s.srv.Close()
+
+	s.mu.Lock()
+	s.setStatusLocked(MCPStatusStopped, nil)
+	s.mu.Unlock()
+	s.reportStatus()
+}
@@ -0,0 +1,410 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForFile waits for a non-empty file to appear and returns its contents.
+func waitForFile(t *testing.T, path string) []byte {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && len(data) > 0 && bytes.HasSuffix(data, []byte("\n")) {
+			return data
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not written", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readPIDs reads one process ID per line.
+func readPIDs(t *testing.T, data []byte) []int {
+	t.Helper()
+	var pids []int
+	for _, line := range strings.Fields(string(data)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			t.Fatalf("bad pid %q", line)
+		}
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// managedMCPURL returns the URL and Authorization header of the named
+// server in the --mcp-config arguments the fake CLI wrote, one per line.
+// Managed servers are in a config file, which must be private.
+func managedMCPURL(t *testing.T, args []byte, name string) (string, string) {
+	t.Helper()
+	lines := strings.Split(string(args), "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		if lines[i] != "--mcp-config" {
+			continue
+		}
+		config := []byte(lines[i+1])
+		if !strings.HasPrefix(lines[i+1], "{") {
+			info, err := os.Stat(lines[i+1])
+			if err != nil {
+				t.Fatalf("--mcp-config file: %v", err)
+			}
+			if mode := info.Mode().Perm(); mode != 0600 {
+				t.Errorf("--mcp-config file mode = %v, want 0600", mode)
+			}
+			config = mustReadFile(t, lines[i+1])
+		}
+		var servers map[string]map[string]any
+		if err := json.Unmarshal(config, &servers); err != nil {
+			t.Fatalf("bad --mcp-config %q: %v", config, err)
+		}
+		if srv, ok := servers[name]; ok {
+			if srv["type"] != "http" || srv["command"] != nil {
+				t.Fatalf("managed server config = %v, want an http server", srv)
+			}
+			headers, _ := srv["headers"].(map[string]any)
+			auth, _ := headers["Authorization"].(string)
+			if !strings.HasPrefix(auth, "Bearer ") {
+				t.Fatalf("managed server headers = %v, want a bearer token", headers)
+			}
+			return srv["url"].(string), auth
+		}
+	}
+	t.Fatalf("no --mcp-config for %s in %q", name, args)
+	return "", ""
+}
+
+// echoMCPServer answers every request with an empty result, except
+// tools/call: for it, the server sends a progress notification and a
+// roots/list request of its own, and answers with the response it reads.
+const echoMCPServer = `#!/bin/sh
+echo $$ >> PIDS
+while read line; do
+  id=$(printf '%s' "$line" | sed -n 's/.*"id":\([0-9][0-9]*\).*/\1/p')
+  case "$line" in
+  *'"tools/call"'*)
+    printf '{"jsonrpc":"2.0","method":"notifications/progress","params":{"progress":1}}\n'
+    printf '{"jsonrpc":"2.0","id":"srv-1","method":"roots/list"}\n'
+    read answer
+    printf '{"jsonrpc":"2.0","id":%s,"result":{"answer":%s}}\n' "$id" "$answer"
+    ;;
+  *'"method"'*) [ -n "$id" ] && printf '{"jsonrpc":"2.0","id":%s,"result":{}}\n' "$id" ;;
+  esac
+done
+`
+
+func TestManagedMCP(t *testing.T) {
+	tmpDir := t.TempDir()
+	argsFile := filepath.Join(tmpDir, "args")
+	pidsFile := filepath.Join(tmpDir, "pids")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+for arg in "$@"; do printf '%s\n' "$arg"; done > `+argsFile+`
+while read line; do :; done
+`), 0755)
+	server := filepath.Join(tmpDir, "server")
+	mustWriteFile(t, server, []byte(strings.Replace(echoMCPServer, "PIDS", pidsFile, 1)), 0755)
+
+	var mu sync.Mutex
+	var statuses []string
+	var audited []string
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		MCPServer("tools", MCPCommand(server), MCPManaged(true)),
+		ScratchDirIn(tmpDir),
+		OnMCPStatus(func(s MCPStatus) {
+			mu.Lock()
+			statuses = append(statuses, s.Status)
+			mu.Unlock()
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "mcp.status" {
+				mu.Lock()
+				audited = append(audited, e.Data.(map[string]any)["status"].(string))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	args := waitForFile(t, argsFile)
+	url, auth := managedMCPURL(t, args, "tools")
+	if token := strings.TrimPrefix(auth, "Bearer "); bytes.Contains(args, []byte(token)) {
+		t.Errorf("CLI arguments contain the bridge token: %s", args)
+	}
+	configFile := ""
+	lines := strings.Split(string(args), "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i-1] == "--mcp-config" && !strings.HasPrefix(lines[i], "{") {
+			configFile = lines[i]
+		}
+	}
+	if filepath.Dir(configFile) != a.ScratchPath() {
+		t.Errorf("--mcp-config file = %q, want one in the scratch directory %s", configFile, a.ScratchPath())
+	}
+	send := func(method, body string, header map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest error = %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			if k == "Host" {
+				req.Host = v
+			} else {
+				req.Header.Set(k, v)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s error = %v", method, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	post := func(body string) (int, string) {
+		t.Helper()
+		return send(http.MethodPost, body, map[string]string{"Authorization": auth})
+	}
+
+	code, body := post(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	if code != http.StatusOK || !strings.Contains(body, `"id":1`) {
+		t.Errorf("initialize = %d %q, want 200 with the response", code, body)
+	}
+	if code, _ := post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`); code != http.StatusAccepted {
+		t.Errorf("notification = %d, want 202", code)
+	}
+	if code, _ := send(http.MethodDelete, "", map[string]string{"Authorization": auth}); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", code)
+	}
+
+	// A batch is answered with a batch of the requests' responses
+	code, body = post(`[{"jsonrpc":"2.0","id":10,"method":"ping"},{"jsonrpc":"2.0","method":"notifications/cancelled","params":{}},{"jsonrpc":"2.0","id":11,"method":"ping"}]`)
+	var batch []map[string]any
+	if err := json.Unmarshal([]byte(body), &batch); code != http.StatusOK || err != nil || len(batch) != 2 || batch[0]["id"] != 10.0 || batch[1]["id"] != 11.0 {
+		t.Errorf("batch = %d %q, want 200 with the responses to 10 and 11", code, body)
+	}
+
+	// The server's own messages arrive on the event stream, and its
+	// request is answered with a POST
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Accept", "text/event-stream")
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer func() { _ = stream.Body.Close() }()
+	if stream.StatusCode != http.StatusOK || stream.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET = %d %s, want an event stream", stream.StatusCode, stream.Header.Get("Content-Type"))
+	}
+	called := make(chan string, 1)
+	go func() {
+		_, body := post(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"x"}}`)
+		called <- body
+	}()
+	events := bufio.NewReader(stream.Body)
+	var data []string
+	for len(data) < 2 {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event stream error = %v", err)
+		}
+		if rest, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, strings.TrimSpace(rest))
+		}
+	}
+	if !strings.Contains(data[0], "notifications/progress") || !strings.Contains(data[1], `"roots/list"`) {
+		t.Errorf("events = %q, want the progress notification and the roots/list request", data)
+	}
+	if code, _ := post(`{"jsonrpc":"2.0","id":"srv-1","result":{"roots":[]}}`); code != http.StatusAccepted {
+		t.Errorf("response to the server = %d, want 202", code)
+	}
+	if body := <-called; !strings.Contains(body, `"answer":{"jsonrpc":"2.0","id":"srv-1","result":{"roots":[]}}`) {
+		t.Errorf("tools/call = %q, want the server's answer with the roots response", body)
+	}
+
+	ping := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+	refused := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"no token", nil, http.StatusUnauthorized},
+		{"wrong token", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+		{"rebound host", map[string]string{"Authorization": auth, "Host": "evil.example:80"}, http.StatusForbidden},
+		{"foreign origin", map[string]string{"Authorization": auth, "Origin": "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range refused {
+		if code, _ := send(http.MethodPost, ping, tt.header); code != tt.want {
+			t.Errorf("%s: POST = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if code, _ := send(http.MethodPost, ping, map[string]string{"Authorization": auth, "Origin": "http://localhost:3000"}); code != http.StatusOK {
+		t.Errorf("loopback origin: POST = %d, want 200", code)
+	}
+
+	pids := readPIDs(t, waitForFile(t, pidsFile))
+	mustClose(t, a)
+	if !processGone(pids[0]) {
+		t.Errorf("MCP server %d still running after Close", pids[0])
+	}
+	if _, err := os.Stat(configFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("--mcp-config file %q still exists after Close: %v", configFile, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{MCPStatusConnected, MCPStatusStopped}
+	if strings.Join(statuses, ",") != strings.Join(want, ",") {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+	if strings.Join(audited, ",") != strings.Join(want, ",") {
+		t.Errorf("mcp.status events = %v, want %v", audited, want)
+	}
+}
+
+func TestManagedMCP_KillPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	argsFile := filepath.Join(tmpDir, "args")
+	pidsFile := filepath.Join(tmpDir, "pids")
+	// The CLI ignores stdin EOF and has to be killed; the server ignores
+	// EOF too and has started a child of its own
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(`#!/bin/sh
+trap '' TERM
+for arg in "$@"; do printf '%s\n' "$arg"; done > `+argsFile+`
+while true; do sleep 1; done
+`), 0755)
+	server := filepath.Join(tmpDir, "server")
+	mustWriteFile(t, server, []byte(`#!/bin/sh
+trap '' TERM HUP
+sleep 300 &
+echo $! >> `+pidsFile+`
+echo $$ >> `+pidsFile+`
+while true; do sleep 1; done
+`), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		MCPServer("stubborn", MCPCommand(server), MCPManaged(true)),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	waitForFile(t, argsFile)
+	deadline := time.Now().Add(5 * time.Second)
+	var pids []int
+	for len(pids) < 2 {
+		pids = readPIDs(t, waitForFile(t, pidsFile))
+		if time.Now().After(deadline) {
+			t.Fatalf("server wrote pids %v, want 2", pids)
+		}
+	}
+
+	start := time.Now()
+	_ = a.Close()
+	if elapsed := time.Since(start); elapsed < 5*time.Second {
+		t.Errorf("Close() took %v; the CLI should have needed the kill path", elapsed)
+	}
+	for _, pid := range pids {
+		if !processGone(pid) {
+			t.Errorf("process %d still running after Close", pid)
+		}
+	}
+}
+
+func TestManagedMCP_Restarts(t *testing.T) {
+	tmpDir := t.TempDir()
+	pidsFile := filepath.Join(tmpDir, "pids")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte("#!/bin/sh\nwhile read line; do :; done\n"), 0755)
+	server := filepath.Join(tmpDir, "server")
+	mustWriteFile(t, server, []byte("#!/bin/sh\necho $$ >> "+pidsFile+"\nexit 3\n"), 0755)
+
+	var mu sync.Mutex
+	var got []MCPStatus
+	failed := make(chan struct{})
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		MCPServer("flaky", MCPCommand(server), MCPManaged(true), MCPMaxRestarts(2)),
+		OnMCPStatus(func(s MCPStatus) {
+			mu.Lock()
+			got = append(got, s)
+			mu.Unlock()
+			if s.Status == MCPStatusFailed {
+				close(failed)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	select {
+	case <-failed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server was not reported failed")
+	}
+
+	want := []MCPStatus{
+		{"flaky", MCPStatusConnected, 0},
+		{"flaky", MCPStatusRestarting, 1},
+		{"flaky", MCPStatusConnected, 1},
+		{"flaky", MCPStatusRestarting, 2},
+		{"flaky", MCPStatusConnected, 2},
+		{"flaky", MCPStatusFailed, 2},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if pids := readPIDs(t, waitForFile(t, pidsFile)); len(pids) != 3 {
+		t.Errorf("server started %d times, want 3", len(pids))
+	}
+}
+
+func TestManagedMCP_Validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []MCPOption
+		option string
+	}{
+		{"no command", []MCPOption{MCPManaged(true)}, "MCPManaged"},
+		{"http", []MCPOption{MCPHTTP("http://localhost:1/mcp"), MCPManaged(true)}, "MCPManaged"},
+		{"negative restarts", []MCPOption{MCPCommand("server"), MCPManaged(true), MCPMaxRestarts(-1)}, "MCPMaxRestarts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), CLIPath("/bin/true"), MCPServer("srv", tt.opts...))
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) || cfgErr.Option != tt.option {
+				t.Errorf("New() error = %v, want a ConfigError for %s", err, tt.option)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MCPAllowTools limits the server's tools to those whose names match one
+// of patterns. Patterns use path.Match syntax and are matched against the
+// tool's own name, without the "mcp__<server>__" prefix, so they survive
+// server versions that add tools. Calls to other tools of the server are
+// denied by a PreToolUse hook with PrioritySecurity, and patterns without
+// wildcards are also passed to the CLI as AllowedTools entries, so those
+// tools run without a permission prompt. It adds to earlier patterns.
+//
+// Example:
+//
+//	agent.MCPServer("github",
+//	    agent.MCPCommand("github-mcp"),
+//	    agent.MCPAllowTools("get_issue", "list_issues", "search_*"),
+//	    agent.MCPDenyTools("*_repository"),
+//	)
+func MCPAllowTools(patterns ...string) MCPOption {
+	return func(c *MCPConfig) {
+		c.AllowTools = append(c.AllowTools, patterns...)
+	}
+}
+
+// MCPDenyTools denies the server's tools whose names match one of
+// patterns, matched as for MCPAllowTools. A denial takes precedence over
+// MCPAllowTools. Patterns without wildcards are also passed to the CLI as
+// DisallowedTools entries. It adds to earlier patterns.
+func MCPDenyTools(patterns ...string) MCPOption {
+	return func(c *MCPConfig) {
+		c.DenyTools = append(c.DenyTools, patterns...)
+	}
+}
+
+// validateMCPTools checks the MCPAllowTools and MCPDenyTools patterns of
+// each server: each must be well formed, and a tool allowed by name must
+// not also be denied.
+func validateMCPTools(cfg *config) error {
+	for _, name := range sortedMCPNames(cfg) {
+		mcp := cfg.mcpServers[name]
+		for _, lists := range []struct {
+			option   string
+			patterns []string
+		}{{"MCPAllowTools", mcp.AllowTools}, {"MCPDenyTools", mcp.DenyTools}} {
+			for _, p := range lists.patterns {
+				if _, err := path.Match(p, ""); err != nil || p == "" {
+					return &ConfigError{Option: lists.option, Value: p, Reason: "MCP server " + name + ": malformed pattern"}
+				}
+			}
+		}
+		for _, allow := range mcp.AllowTools {
+			for _, deny := range mcp.DenyTools {
+				if allow == deny || (isLiteralPattern(allow) && matchToolPattern(deny, allow)) {
+					return &ConfigError{
+						Option: "MCPAllowTools",
+						Value:  allow,
+						Reason: fmt.Sprintf("MCP server %s: also denied by MCPDenyTools pattern %q", name, deny),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mcpToolFlags returns the AllowedTools and DisallowedTools entries for
+// the patterns without wildcards.
+func mcpToolFlags(cfg *config) (allowed, disallowed []string) {
+	for _, name := range sortedMCPNames(cfg) {
+		mcp := cfg.mcpServers[name]
+		for _, p := range mcp.AllowTools {
+			if isLiteralPattern(p) {
+				allowed = append(allowed, mcpToolPrefix+name+mcpToolSeparator+p)
+			}
+		}
+		for _, p := range mcp.DenyTools {
+			if isLiteralPattern(p) {
+				disallowed = append(disallowed, mcpToolPrefix+name+mcpToolSeparator+p)
+			}
+		}
+	}
+	return allowed, disallowed
+}
+
+// mcpToolHooks returns a PreToolUse hook for each server with tool
+// patterns.
+func mcpToolHooks(cfg *config) []PreToolUseHook {
+	var hooks []PreToolUseHook
+	for _, name := range sortedMCPNames(cfg) {
+		mcp := cfg.mcpServers[name]
+		if len(mcp.AllowTools) > 0 || len(mcp.DenyTools) > 0 {
+			hooks = append(hooks, mcpToolPolicy(name, mcp.AllowTools, mcp.DenyTools))
+		}
+	}
+	return hooks
+}
+
+// mcpToolPolicy returns a hook that denies calls to the server's tools
+// that match deny or, if allow is not empty, match none of allow. Each
+// denial is reported as an mcp.tool_denied audit event naming the server
+// and the pattern that matched.
+func mcpToolPolicy(server string, allow, deny []string) PreToolUseHook {
+	allow = append([]string(nil), allow...)
+	deny = append([]string(nil), deny...)
+	return func(tc *ToolCall) HookResult {
+		kind, srv, tool := ParseToolName(tc.Name)
+		if kind != ToolKindMCP || srv != server {
+			return HookResult{Decision: Continue}
+		}
+		deniedBy := func(list, pattern, reason string) HookResult {
+			tc.emit("mcp.tool_denied", map[string]any{
+				"server":  server,
+				"tool":    tool,
+				"pattern": pattern,
+				"list":    list,
+			})
+			return HookResult{Decision: Deny, Reason: reason}
+		}
+		for _, p := range deny {
+			if matchToolPattern(p, tool) {
+				return deniedBy("deny", p, fmt.Sprintf("MCP server %s: tool %s is denied by pattern %q", server, tool, p))
+			}
+		}
+		if len(allow) == 0 {
+			return HookResult{Decision: Continue}
+		}
+		for _, p := range allow {
+			if matchToolPattern(p, tool) {
+				return HookResult{Decision: Continue}
+			}
+		}
+		return deniedBy("allow", "", fmt.Sprintf("MCP server %s: tool %s matches no allowed pattern (%s)", server, tool, strings.Join(allow, ", ")))
+	}
+}
+
+// matchToolPattern reports whether tool matches pattern.
+func matchToolPattern(pattern, tool string) bool {
+	ok, _ := path.Match(pattern, tool) // Malformed patterns are refused by New
+	return ok
+}
+
+// isLiteralPattern reports whether pattern has no wildcards.
+func isLiteralPattern(pattern string) bool {
+	return !strings.ContainsAny(pattern, `*?[\`)
+}
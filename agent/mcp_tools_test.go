@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// githubTools configures a server with both lists.
+func githubTools() *config {
+	return newConfig(
+		AllowedTools("Read"),
+		MCPServer("github",
+			MCPCommand("github-mcp"),
+			MCPAllowTools("get_issue", "search_*"),
+			MCPDenyTools("delete_repository", "*_secret"),
+		),
+		MCPServer("docs", MCPCommand("docs-mcp")),
+	)
+}
+
+func TestMCPToolFlags(t *testing.T) {
+	cfg := githubTools()
+	args := strings.Join(buildArgs(cfg), " ")
+	for _, want := range []string{
+		"--allowedTools Read,mcp__github__get_issue ",
+		"--disallowedTools mcp__github__delete_repository ",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("args = %s\nwant %q", args, want)
+		}
+	}
+	if strings.Contains(args, "search_") || strings.Contains(args, "_secret") {
+		t.Errorf("args = %s, want glob patterns left to the hook", args)
+	}
+	if len(cfg.allowedTools) != 1 {
+		t.Errorf("allowedTools = %v, want the configuration unchanged", cfg.allowedTools)
+	}
+}
+
+func TestMCPToolPolicy(t *testing.T) {
+	chain := newHookChain(githubTools().sortedPreToolUseHooks())
+	tests := []struct {
+		tool    string
+		want    Decision
+		pattern string
+	}{
+		{"mcp__github__get_issue", Allow, ""},
+		{"mcp__github__search_code", Allow, ""},
+		{"mcp__github__search_secret", Deny, "*_secret"}, // Deny wins
+		{"mcp__github__delete_repository", Deny, "delete_repository"},
+		{"mcp__github__create_issue", Deny, ""}, // Not allowed
+		{"mcp__docs__delete_repository", Allow, ""},
+		{"Bash", Allow, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			var events []map[string]any
+			tc := &ToolCall{Name: tt.tool, audit: func(eventType string, data map[string]any) {
+				if eventType == "mcp.tool_denied" {
+					events = append(events, data)
+				}
+			}}
+			got := chain.evaluate(tc)
+			if got.Decision != tt.want {
+				t.Fatalf("Decision = %v (%s), want %v", got.Decision, got.Reason, tt.want)
+			}
+			if tt.want == Allow {
+				if len(events) != 0 {
+					t.Errorf("events = %v, want none", events)
+				}
+				return
+			}
+			if !strings.Contains(got.Reason, "MCP server github") || !strings.Contains(got.Reason, tt.pattern) {
+				t.Errorf("Reason = %q, want the server and pattern", got.Reason)
+			}
+			if len(events) != 1 || events[0]["server"] != "github" || events[0]["pattern"] != tt.pattern {
+				t.Errorf("events = %v, want one naming github and %q", events, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestValidateMCPTools(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []MCPOption
+		option string
+		value  string
+	}{
+		{"same pattern", []MCPOption{MCPAllowTools("search_*"), MCPDenyTools("search_*")}, "MCPAllowTools", "search_*"},
+		{"denied by glob", []MCPOption{MCPAllowTools("get_issue"), MCPDenyTools("get_*")}, "MCPAllowTools", "get_issue"},
+		{"malformed", []MCPOption{MCPDenyTools("get_[")}, "MCPDenyTools", "get_["},
+		{"empty", []MCPOption{MCPAllowTools("")}, "MCPAllowTools", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(MCPServer("github", append([]MCPOption{MCPCommand("github-mcp")}, tt.opts...)...))
+			var cerr *ConfigError
+			if err := validateMCPTools(cfg); !errors.As(err, &cerr) || cerr.Option != tt.option || cerr.Value != tt.value {
+				t.Errorf("validateMCPTools() = %v, want a *ConfigError for %s %q", err, tt.option, tt.value)
+			}
+		})
+	}
+
+	// A glob allowed alongside a narrower denial is not a conflict
+	if err := validateMCPTools(githubTools()); err != nil {
+		t.Errorf("validateMCPTools() = %v, want nil", err)
+	}
+}
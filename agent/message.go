@@ -5,12 +5,40 @@ import "time"
 
 // MessageMeta contains metadata common to all message types.
 type MessageMeta struct {
-	Timestamp  time.Time
-	SessionID  string
-	Turn       int
-	Sequence   int
+	Timestamp time.Time
+	// SessionID is the session's ID from the CLI's init message. Messages
+	// parsed before an init message carry a synthetic ID instead; see
+	// Agent.SessionID.
+	SessionID string
+	Turn      int
+
+	// Sequence numbers every message parsed from the CLI, including those
+	// the SDK handles itself, such as the init message, control requests,
+	// and compaction events. It therefore skips numbers between delivered
+	// messages and is not suited to detecting lost messages.
+	Sequence int
+
+	// DeliveredSequence numbers the messages an Agent delivers on Stream
+	// channels, from 1, with no gaps across runs. Messages filtered out of
+	// a run and diverted Thinking are not numbered. ToolProgress and
+	// HeartbeatMsg, which may be dropped, and the Error sent when a stream fails to start or is cut
+	// short keep 0. See ValidateSequence.
+	DeliveredSequence int
+
 	ParentID   string
 	SubagentID string
+
+	// UUID identifies the CLI line the message was parsed from, and
+	// ParentUUID the line before it in the conversation, as in the
+	// session's transcript. Both are empty when the CLI does not send
+	// them. Messages from one line, such as the blocks of an assistant
+	// message, share a UUID. See BuildTree.
+	UUID       string
+	ParentUUID string
+
+	// EstimatedCostUSD is the run's estimated cost when the message was
+	// delivered. It is set only when CostEstimator is configured.
+	EstimatedCostUSD float64
 }
 
 // Message is the interface implemented by all message types.
@@ -18,6 +46,76 @@ type Message interface {
 	message() // unexported marker method
 }
 
+// MessageType identifies a kind of message delivered by Stream.
+type MessageType string
+
+// Message kinds, one per message type delivered by Stream.
+const (
+	MessageText         MessageType = "text"
+	MessageThinking     MessageType = "thinking"
+	MessageToolUse      MessageType = "tool_use"
+	MessageToolResult   MessageType = "tool_result"
+	MessageResult       MessageType = "result"
+	MessageError        MessageType = "error"
+	MessageParseWarning MessageType = "parse_warning"
+	MessageToolProgress MessageType = "tool_progress"
+	MessageHeartbeat    MessageType = "heartbeat"
+)
+
+// messageTypeOf returns the MessageType of a message, or "" for internal
+// message types that Stream does not deliver.
+func messageTypeOf(msg Message) MessageType {
+	switch msg.(type) {
+	case *Text:
+		return MessageText
+	case *Thinking:
+		return MessageThinking
+	case *ToolUse:
+		return MessageToolUse
+	case *ToolResult:
+		return MessageToolResult
+	case *Result:
+		return MessageResult
+	case *Error:
+		return MessageError
+	case *ParseWarning:
+		return MessageParseWarning
+	case *ToolProgress:
+		return MessageToolProgress
+	case *HeartbeatMsg:
+		return MessageHeartbeat
+	default:
+		return ""
+	}
+}
+
+// messageMeta returns the metadata of a delivered message type, or nil
+// for internal message types.
+func messageMeta(msg Message) *MessageMeta {
+	switch m := msg.(type) {
+	case *Text:
+		return &m.MessageMeta
+	case *Thinking:
+		return &m.MessageMeta
+	case *ToolUse:
+		return &m.MessageMeta
+	case *ToolResult:
+		return &m.MessageMeta
+	case *Result:
+		return &m.MessageMeta
+	case *Error:
+		return &m.MessageMeta
+	case *ParseWarning:
+		return &m.MessageMeta
+	case *ToolProgress:
+		return &m.MessageMeta
+	case *HeartbeatMsg:
+		return &m.MessageMeta
+	default:
+		return nil
+	}
+}
+
 // ToolInfo describes a tool available to the agent.
 type ToolInfo struct {
 	Name        string
@@ -26,8 +124,9 @@ type ToolInfo struct {
 
 // MCPStatus describes the status of an MCP server.
 type MCPStatus struct {
-	Name   string
-	Status string
+	Name     string
+	Status   string
+	Restarts int // Times an MCPManaged server has been restarted
 }
 
 // Usage contains token usage information.
@@ -44,6 +143,8 @@ type SystemInit struct {
 	TranscriptPath string
 	Tools          []ToolInfo
 	MCPServers     []MCPStatus
+	// Raw is the complete JSON line with KeepRaw, otherwise nil
+	Raw []byte
 }
 
 func (SystemInit) message() {}
@@ -71,6 +172,8 @@ type ToolUse struct {
 	ID    string
 	Name  string
 	Input map[string]any
+	// ParentToolUseID is set when the tool call was made by a subagent.
+	ParentToolUseID string
 }
 
 func (ToolUse) message() {}
@@ -96,6 +199,43 @@ type Result struct {
 	Usage         Usage
 	ResultText    string
 	IsError       bool
+	FileChanges   []FileChange   // Files modified by Write/Edit tools during the run
+	PathRedirects []PathRedirect // Paths rewritten by RedirectPath during the run, in first-use order
+	Denials       []DenialRecord // Tool calls denied by PreToolUse hooks during the run
+	CostBreakdown CostBreakdown  // Estimated input tokens and cost of the run's tool results, by tool and subagent
+	RunID         string         // Matches the RunID of the run's audit events
+
+	// Prompt is the text sent to Claude for the run, after UserPromptSubmit
+	// hooks and any context preserved across compaction. OriginalPrompt is
+	// the text passed to Run or Stream. Both share storage with the strings
+	// the run used rather than copying them.
+	Prompt         string
+	OriginalPrompt string
+	PromptMetadata []any // Non-nil Metadata from UserPromptSubmit hooks, in hook order
+
+	// SoftDeadlineHit is true when the run passed its SoftDeadline and
+	// Claude was asked to wrap up.
+	SoftDeadlineHit bool
+
+	// Sizes of the run, for capacity planning. PromptBytes is the length of
+	// the prompt sent, including a streamed prompt's body. ResponseBytes is
+	// the total length of the run's Text messages, and ToolOutputBytes of
+	// its tool results: text content by length, other content by the length
+	// of its JSON encoding.
+	PromptBytes     int
+	ResponseBytes   int
+	ToolOutputBytes int
+
+	// PromptTokens and CompletionTokens are Usage.InputTokens and
+	// Usage.OutputTokens. Cache tokens are only in Usage.
+	PromptTokens     int
+	CompletionTokens int
+
+	duplicates int                 // Repeated assistant content blocks suppressed during the turn
+	budgetErr  *BudgetError        // Set when the run passed its EstimatedBudget
+	denialErr  *PolicyThrashError  // Set when the run passed MaxDenialsPerRun
+	hookErr    *HookInterruptError // Set when the run ended in an error after a denial
+	checkErr   error               // Set when RequireNonEmptyResult or RefusalDetector rejected the Result
 }
 
 func (Result) message() {}
@@ -108,6 +248,16 @@ type Error struct {
 
 func (Error) message() {}
 
+// ParseWarning reports CLI output that could not be parsed but did not end
+// the stream, such as a line longer than the MaxLineBytes limit.
+type ParseWarning struct {
+	MessageMeta
+	Reason string
+	Bytes  int // Size of the discarded input in bytes
+}
+
+func (ParseWarning) message() {}
+
 // ControlRequestMsg represents a permission request from the CLI.
 // This is an internal message type used for hook evaluation.
 type ControlRequestMsg struct {
@@ -116,6 +266,12 @@ type ControlRequestMsg struct {
 	Type      string
 	ToolName  string
 	ToolInput map[string]any
+	ToolUseID string
+	// Parent context, when provided by the CLI for subagent tool calls
+	ParentToolUseID string
+	SubagentType    string
+	// Raw is the complete JSON line, passed to OnControlRequest handlers
+	Raw []byte
 }
 
 func (ControlRequestMsg) message() {}
@@ -125,3 +125,27 @@ func TestSupportingTypes(t *testing.T) {
 		t.Errorf("Usage.InputTokens = %d, want %d", u.InputTokens, 100)
 	}
 }
+
+func TestMessageTypeOf(t *testing.T) {
+	tests := []struct {
+		msg  Message
+		want MessageType
+	}{
+		{&Text{}, MessageText},
+		{&Thinking{}, MessageThinking},
+		{&ToolUse{}, MessageToolUse},
+		{&ToolResult{}, MessageToolResult},
+		{&Result{}, MessageResult},
+		{&Error{}, MessageError},
+		{&ParseWarning{}, MessageParseWarning},
+		{&ToolProgress{}, MessageToolProgress},
+		{&HeartbeatMsg{}, MessageHeartbeat},
+		{&SystemInit{}, ""},
+		{&ControlRequestMsg{}, ""},
+	}
+	for _, tt := range tests {
+		if got := messageTypeOf(tt.msg); got != tt.want {
+			t.Errorf("messageTypeOf(%T) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
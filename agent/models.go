@@ -0,0 +1,198 @@
+package agent
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ModelInfo describes a model known to the SDK.
+type ModelInfo struct {
+	ID      string   // Full model identifier passed to the CLI, e.g. "claude-sonnet-4-5"
+	Family  string   // "opus", "sonnet", or "haiku"
+	Aliases []string // Short names that resolve to ID, e.g. "sonnet"
+}
+
+// knownModels lists the models Model and SubagentModel accept, newest
+// first within each family. The family alias points at the newest model.
+var knownModels = []ModelInfo{
+	{ID: "claude-opus-4-5", Family: "opus", Aliases: []string{"opus"}},
+	{ID: "claude-opus-4-1", Family: "opus"},
+	{ID: "claude-opus-4-0", Family: "opus"},
+	{ID: "claude-3-opus", Family: "opus"},
+	{ID: "claude-sonnet-4-5", Family: "sonnet", Aliases: []string{"sonnet"}},
+	{ID: "claude-sonnet-4-0", Family: "sonnet"},
+	{ID: "claude-3-7-sonnet", Family: "sonnet"},
+	{ID: "claude-3-5-sonnet", Family: "sonnet"},
+	{ID: "claude-haiku-4-5", Family: "haiku", Aliases: []string{"haiku"}},
+	{ID: "claude-3-5-haiku", Family: "haiku"},
+	{ID: "claude-3-haiku", Family: "haiku"},
+}
+
+// inheritModel is the SubagentModel value for using the parent's model.
+const inheritModel = "inherit"
+
+// modelSuffix matches the parts of a model name that may follow a known
+// identifier: a dated snapshot, "-latest", or a Google Vertex AI snapshot
+// ("@20250929", after an optional "-v2"), then the extended context marker.
+var modelSuffix = regexp.MustCompile(`^(-\d{8}|-latest|(-v\d+)?@\d{8})?(\[1m\])?$`)
+
+// bedrockPrefix matches the start of an Amazon Bedrock model ID: an
+// optional cross-region inference profile such as "us." or "global.",
+// then "anthropic.".
+var bedrockPrefix = regexp.MustCompile(`^([a-z]+\.)?anthropic\.`)
+
+// bedrockSuffix matches what follows a known identifier in a Bedrock model
+// ID: a dated snapshot and a version such as "-v1:0", then the extended
+// context marker.
+var bedrockSuffix = regexp.MustCompile(`^-\d{8}-v\d+:\d+(\[1m\])?$`)
+
+// KnownModels returns the models the SDK recognizes, for example to offer
+// choices in a user interface. The returned slice is a copy.
+func KnownModels() []ModelInfo {
+	models := make([]ModelInfo, len(knownModels))
+	for i, m := range knownModels {
+		m.Aliases = append([]string(nil), m.Aliases...)
+		models[i] = m
+	}
+	return models
+}
+
+// StrictModels controls whether New rejects model names the SDK does not
+// know. It is on by default; pass false to use a model released after this
+// version of the SDK.
+func StrictModels(strict bool) Option {
+	return func(c *config) {
+		c.lenientModels = !strict
+	}
+}
+
+// resolveModel returns the full identifier for a model name or alias.
+// Dated snapshots ("claude-sonnet-4-5-20250929") and the extended context
+// marker ("[1m]") are accepted after a known identifier and kept as given,
+// as are Vertex AI ("claude-sonnet-4-5@20250929") and Bedrock
+// ("us.anthropic.claude-sonnet-4-5-20250929-v1:0") model IDs. The
+// "claude-sonnet-4" and "claude-opus-4" shorthands resolve to their "-0"
+// identifiers. ok is false if the name is not recognized.
+func resolveModel(name string) (id string, ok bool) {
+	for _, m := range knownModels {
+		for _, alias := range m.Aliases {
+			if name == alias {
+				return m.ID, true
+			}
+		}
+	}
+	model, suffix := name, modelSuffix
+	if prefix := bedrockPrefix.FindString(name); prefix != "" {
+		model, suffix = name[len(prefix):], bedrockSuffix
+	}
+	for _, m := range knownModels {
+		for _, base := range []string{m.ID, strings.TrimSuffix(m.ID, "-0")} {
+			if rest, found := strings.CutPrefix(model, base); found && suffix.MatchString(rest) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeModels resolves aliases in the agent model to full identifiers
+// and checks subagent models. Subagent aliases are kept as given because
+// the CLI resolves them in agent definitions. With StrictModels(false),
+// unknown names are passed through unchanged.
+func normalizeModels(cfg *config) error {
+	if cfg.model != "" {
+		id, ok := resolveModel(cfg.model)
+		switch {
+		case ok:
+			cfg.model = id
+		case !cfg.lenientModels:
+			return unknownModelError("Model", cfg.model)
+		}
+	}
+
+	if cfg.lenientModels {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.subagents))
+	for name := range cfg.subagents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		model := cfg.subagents[name].Model
+		if model == "" || model == inheritModel {
+			continue
+		}
+		if _, ok := resolveModel(model); !ok {
+			return unknownModelError("SubagentModel", model)
+		}
+	}
+	return nil
+}
+
+// unknownModelError builds the ConfigError for an unrecognized model,
+// suggesting the closest known names.
+func unknownModelError(option, name string) *ConfigError {
+	return &ConfigError{
+		Option:      option,
+		Value:       name,
+		Reason:      "unknown model; use StrictModels(false) to allow it",
+		Suggestions: suggestModels(name),
+	}
+}
+
+// maxModelSuggestions caps the close matches listed in a ConfigError.
+const maxModelSuggestions = 3
+
+// suggestModels returns the known names and aliases closest to name by
+// edit distance, nearest first. Names too far away to be a typo are left
+// out.
+func suggestModels(name string) []string {
+	type candidate struct {
+		name     string
+		distance int
+	}
+	limit := max(2, len(name)/3)
+	var candidates []candidate
+	for _, m := range knownModels {
+		for _, n := range append([]string{m.ID}, m.Aliases...) {
+			if d := editDistance(strings.ToLower(name), n); d <= limit {
+				candidates = append(candidates, candidate{n, d})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var suggestions []string
+	for _, c := range candidates {
+		if len(suggestions) == maxModelSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.name)
+	}
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		wantID string
+		wantOK bool
+	}{
+		{"sonnet", "claude-sonnet-4-5", true},
+		{"haiku", "claude-haiku-4-5", true},
+		{"opus", "claude-opus-4-5", true},
+		{"claude-sonnet-4-5", "claude-sonnet-4-5", true},
+		{"claude-sonnet-4-5-20250929", "claude-sonnet-4-5-20250929", true},
+		{"claude-sonnet-4-20250514", "claude-sonnet-4-20250514", true},
+		{"claude-sonnet-4-5[1m]", "claude-sonnet-4-5[1m]", true},
+		{"claude-3-5-haiku-latest", "claude-3-5-haiku-latest", true},
+		{"claude-sonnet-4-5@20250929", "claude-sonnet-4-5@20250929", true},
+		{"claude-3-5-sonnet-v2@20241022", "claude-3-5-sonnet-v2@20241022", true},
+		{"claude-sonnet-4@20250514", "claude-sonnet-4@20250514", true},
+		{"us.anthropic.claude-sonnet-4-5-20250929-v1:0", "us.anthropic.claude-sonnet-4-5-20250929-v1:0", true},
+		{"global.anthropic.claude-haiku-4-5-20251001-v1:0", "global.anthropic.claude-haiku-4-5-20251001-v1:0", true},
+		{"anthropic.claude-3-5-sonnet-20241022-v2:0", "anthropic.claude-3-5-sonnet-20241022-v2:0", true},
+		{"claude-sonnet-4-5@latest", "", false},
+		{"anthropic.claude-sonnet-4-5", "", false},
+		{"claude-sonnet-4-5-20250929-v1:0", "", false},
+		{"claude-sonnet-45", "", false},
+		{"claude-sonnet-4-5-preview", "", false},
+		{"Sonnet", "", false},
+		{"gpt-4", "", false},
+	}
+	for _, tt := range tests {
+		id, ok := resolveModel(tt.name)
+		if id != tt.wantID || ok != tt.wantOK {
+			t.Errorf("resolveModel(%q) = %q, %v; want %q, %v", tt.name, id, ok, tt.wantID, tt.wantOK)
+		}
+	}
+}
+
+func TestSuggestModels(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"claude-sonnet-45", "claude-sonnet-4-5"},
+		{"claude-haiku-3-5", "claude-haiku-4-5"},
+		{"sonet", "sonnet"},
+		{"Opus", "opus"},
+	}
+	for _, tt := range tests {
+		got := suggestModels(tt.name)
+		if len(got) == 0 || got[0] != tt.want {
+			t.Errorf("suggestModels(%q) = %v, want %q first", tt.name, got, tt.want)
+		}
+	}
+	if got := suggestModels("gpt-4"); len(got) != 0 {
+		t.Errorf("suggestModels(gpt-4) = %v, want none", got)
+	}
+}
+
+// modelArgsCLI writes a fake CLI that records its arguments to args.
+func modelArgsCLI(t *testing.T, args string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := "#!/bin/sh\necho \"$@\" > '" + args + "'\nread line\nexit 0\n"
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestNewResolvesModelAlias(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args.txt")
+	a, err := New(context.Background(), CLIPath(modelArgsCLI(t, args)), Model("haiku"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mustClose(t, a)
+
+	if got := string(mustReadFile(t, args)); !strings.Contains(got, "--model claude-haiku-4-5") {
+		t.Errorf("CLI args = %q, want --model claude-haiku-4-5", got)
+	}
+}
+
+func TestNewAcceptsProviderModelIDs(t *testing.T) {
+	for _, model := range []string{
+		"claude-sonnet-4-5@20250929",
+		"us.anthropic.claude-sonnet-4-5-20250929-v1:0",
+	} {
+		args := filepath.Join(t.TempDir(), "args.txt")
+		a, err := New(context.Background(), CLIPath(modelArgsCLI(t, args)), Model(model))
+		if err != nil {
+			t.Fatalf("New(Model(%q)) error = %v", model, err)
+		}
+		mustClose(t, a)
+
+		if got := string(mustReadFile(t, args)); !strings.Contains(got, "--model "+model) {
+			t.Errorf("CLI args = %q, want --model %s", got, model)
+		}
+	}
+}
+
+func TestNewRejectsUnknownModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		opt    Option
+		option string
+	}{
+		{"model", Model("claude-sonnet-45"), "Model"},
+		{"subagent model", Subagent("reviewer", SubagentModel("claude-sonnet-45")), "SubagentModel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), CLIPath(modelArgsCLI(t, filepath.Join(t.TempDir(), "args.txt"))), tt.opt)
+			var cfgErr *ConfigError
+			if !errors.As(err, &cfgErr) {
+				t.Fatalf("New() error = %v, want *ConfigError", err)
+			}
+			if cfgErr.Option != tt.option || cfgErr.Value != "claude-sonnet-45" {
+				t.Errorf("ConfigError = %+v, want %s claude-sonnet-45", cfgErr, tt.option)
+			}
+			if !strings.Contains(err.Error(), `did you mean "claude-sonnet-4-5"`) {
+				t.Errorf("error = %q, want a suggestion", err)
+			}
+		})
+	}
+}
+
+func TestNewAcceptsSubagentAliases(t *testing.T) {
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(modelArgsCLI(t, filepath.Join(t.TempDir(), "args.txt"))),
+		Subagent("fast", SubagentDescription("Quick lookups"), SubagentModel("haiku")),
+		Subagent("same", SubagentDescription("Everything else"), SubagentModel("inherit")),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	// The CLI resolves aliases in agent definitions, so they are kept
+	if got := a.cfg.subagents["fast"].Model; got != "haiku" {
+		t.Errorf("subagent model = %q, want haiku", got)
+	}
+}
+
+func TestStrictModelsFalsePassesUnknownNames(t *testing.T) {
+	args := filepath.Join(t.TempDir(), "args.txt")
+	a, err := New(context.Background(),
+		CLIPath(modelArgsCLI(t, args)),
+		StrictModels(false),
+		Model("claude-sonnet-9"),
+		Subagent("next", SubagentDescription("Tries the next model"), SubagentModel("claude-haiku-9")),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	mustClose(t, a)
+
+	if got := string(mustReadFile(t, args)); !strings.Contains(got, "--model claude-sonnet-9") {
+		t.Errorf("CLI args = %q, want --model claude-sonnet-9", got)
+	}
+}
+
+func TestKnownModelsReturnsCopy(t *testing.T) {
+	models := KnownModels()
+	if len(models) == 0 {
+		t.Fatal("KnownModels() is empty")
+	}
+	for _, m := range models {
+		if id, ok := resolveModel(m.ID); !ok || id != m.ID {
+			t.Errorf("resolveModel(%q) = %q, %v; every known ID must resolve to itself", m.ID, id, ok)
+		}
+		for _, alias := range m.Aliases {
+			if id, _ := resolveModel(alias); id != m.ID {
+				t.Errorf("alias %q resolves to %q, want %q", alias, id, m.ID)
+			}
+		}
+	}
+
+	models[0].ID = "changed"
+	for i := range models {
+		if len(models[i].Aliases) > 0 {
+			models[i].Aliases[0] = "changed"
+		}
+	}
+	if _, ok := resolveModel("sonnet"); !ok || KnownModels()[0].ID == "changed" {
+		t.Error("modifying the KnownModels() result changed the table")
+	}
+}
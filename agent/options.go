@@ -2,7 +2,10 @@ package agent
 
 import (
 	"encoding/json"
+	"io"
 	"reflect"
+	"regexp"
+	"text/template"
 	"time"
 )
 
@@ -24,19 +27,31 @@ const (
 
 // config holds agent configuration.
 type config struct {
-	model           string
-	workDir         string
-	cliPath         string
-	preToolUseHooks []PreToolUseHook
+	model                string
+	workDir              string
+	cliPath              string
+	noCLICache           bool     // Look for the CLI without the process-wide cache
+	resolvedCLI          string   // Found by New when cliPath is empty; never carried over to a clone
+	cliChecksums         []string // Allowed SHA-256 digests of the CLI (CLIChecksum)
+	cliChecksumFile      string   // File of allowed digests (CLIChecksumFile)
+	maxLineBytes         int      // Hard limit on CLI output line length (0 = unlimited)
+	preToolUseHooks      []PreToolUseHook
+	preToolUsePriorities []int                // Priority of each of preToolUseHooks
+	preToolUseRedirects  []*redirectRule      // RedirectPath rule of each of preToolUseHooks, or nil
+	pathHooks            pathHookArgs         // Arguments of the AllowPaths and DenyPaths hooks
+	profiles             []ProfileDescription // Profiles applied, for Describe
 
 	// Tool configuration
 	tools           []string // --tools: available tools
+	noTools         bool     // --tools "": Tools was given no names
 	allowedTools    []string // --allowedTools: permission patterns
 	disallowedTools []string // --disallowedTools: deny patterns
 
 	// Permission and environment
 	permissionMode PermissionMode    // --permission-mode
 	env            map[string]string // process environment variables
+	apiKey         string            // ANTHROPIC_API_KEY for the process only
+	oauthToken     string            // CLAUDE_CODE_OAUTH_TOKEN for the process only
 
 	// Directory and settings
 	addDirs        []string // --add-dir: additional allowed directories
@@ -45,38 +60,163 @@ type config struct {
 	// Limits
 	maxTurns int // Maximum turns allowed (0 = unlimited)
 
+	// Run options applied before each call's own (see DefaultRunOptions)
+	runDefaults []RunOption
+
+	// Context window tracking
+	contextWindow   int                   // Context window override in tokens (0 = model default)
+	contextWatchers []contextUsageWatcher // Threshold hooks for context utilization
+
 	// Session management
-	resume string // Session ID to resume
-	fork   bool   // Fork from resumed session (creates new session ID)
+	resume         string     // Session ID to resume
+	fork           bool       // Fork from resumed session (creates new session ID)
+	forkFrom       *forkPoint // Fork from an earlier turn (prepared in New)
+	forkTranscript string     // Transcript copy written by New for forkFrom; removed by Close
 
 	// Structured output
-	jsonSchema  string // JSON Schema for --json-schema flag
-	schemaError error  // Error from schema generation (deferred until New())
+	jsonSchema      string         // JSON Schema for --json-schema flag
+	schemaType      reflect.Type   // Type given to WithSchema, pointers unwrapped (nil for raw schemas)
+	strictSchema    bool           // Validate responses against jsonSchema
+	schemaFallback  bool           // Send jsonSchema in the prompt instead of --json-schema
+	schemaError     error          // Error from schema generation (deferred until New())
+	rawSchema       map[string]any // Schema given to WithSchemaRaw, linted by New
+	looseSchemaRaw  bool           // Skip linting rawSchema (LintSchemaRaw(false))
+	lintToolSchemas bool           // Lint custom tools' input schemas in New
+
+	// Listing of workDir prepended to the first prompt (PrimeWithFileTree)
+	fileTree *fileTreeConfig
+
+	// Fail New on contradictory options rather than warn (StrictConfig)
+	strictConfig bool
+
+	// Labels attached to audit events and StopEvent
+	labels map[string]string
 
 	// Audit system
-	auditHandlers []AuditHandler // Handlers to receive audit events
-	auditCleanup  []func() error // Cleanup functions for file handlers
+	auditHandlers []AuditHandler   // Handlers to receive audit events
+	auditClock    func() time.Time // Source of audit timestamps (nil = time.Now)
+	auditCleanup  []func() error   // Cleanup functions for file handlers
+
+	// Asynchronous handler execution (nil = synchronous)
+	asyncHooks *asyncConfig
+
+	// Hooks slower than this emit hook.slow (0 = off)
+	slowHookThreshold time.Duration
+
+	// PreToolUse denials allowed per run before it is stopped (0 = unlimited)
+	maxDenials int
+
+	// Hints sent when a tool result matches
+	reactions    []ToolResultReaction
+	maxReactions int // Firings allowed per run (0 = unlimited)
+
+	// Notes sent with PreToolUse denials
+	explainDenials bool
+	denialNote     *template.Template // DenialNoteTemplate (nil = DenialNote.String)
+	denialNoteText string             // Source of denialNote, for errors
+	denialNoteErr  error              // Parse error, reported by New
+
+	// Largest prompt, in bytes, Run and RunReader send (0 = unlimited)
+	maxPromptBytes int
+
+	// Token budget and compressor for oversized prompts (nil = off)
+	promptBudget *promptBudget
+
+	// Directory of crash recovery state files ("" = off)
+	stateDir string
+
+	// Per-agent scratch directory
+	scratch       bool   // Create one in New
+	scratchParent string // Where to create it ("" = system temp)
+	keepScratch   bool   // Leave it in place at Close
+	scratchPath   string // Created by New; never carried over to a clone
+
+	// Per-agent git worktree
+	worktreeRepo   string // Repository to create one from ("" = none)
+	worktreeBase   string // Ref to check out ("" = HEAD)
+	worktreeParent string // Where to create it ("" = system temp)
+	keepWorktree   bool   // Leave it in place at Close
+	worktreePath   string // Created by New; never carried over to a clone
+	worktreeHead   string // Commit it was created at
+
+	// Patterns redacted from errors and audit events, on top of the defaults
+	scrubPatterns []*regexp.Regexp
+	scrubErr      error // First invalid pattern, reported by New
+
+	// Resolve symlinks before path hooks compare paths
+	resolvePathSymlinks bool
+
+	// Sink for Thinking content (nil = off), and how much of it is persisted
+	thinking     *thinkingConfig
+	thinkingMode ThinkingMode
+
+	// Record and replay of CLI sessions
+	recordPath string        // File to record the session to (empty = off)
+	replay     *replayConfig // Recorded session to play instead of starting the CLI
+
+	// Log of raw CLI traffic (nil = off)
+	debugWire      io.Writer
+	debugWireOwned bool // debugWire is a file opened by DebugWireFile
+
+	// Summary carried across compaction (nil = disabled)
+	preserveFn PreserveFunc
 
 	// Lifecycle hooks
 	postToolUseHooks      []PostToolUseHook      // Called after tool execution
 	stopHooks             []StopHook             // Called when agent stops
 	preCompactHooks       []PreCompactHook       // Called before context compaction
+	subagentStartHooks    []SubagentStartHook    // Called when a configured subagent starts
 	subagentStopHooks     []SubagentStopHook     // Called when subagent completes
+	mcpStatusHooks        []MCPStatusHook        // Called when an MCP server's status is known or changes
+	initHooks             []InitHook             // Called with each CLI process's init message
+	keepRaw               bool                   // Keep the init message's JSON line
 	userPromptSubmitHooks []UserPromptSubmitHook // Called before prompt submission
+	fileChangedHooks      []FileChangedHook      // Called when a file-mutating tool completes
+
+	// Raw control protocol handlers
+	controlHandlers []ControlRequestHandler
 
 	// Custom tools
-	customTools map[string]Tool // In-process tools executed by SDK
+	customTools        map[string]Tool // In-process tools executed by SDK
+	maxConcurrentTools int             // Global limit on concurrent custom tool executions (0 = unlimited)
+	progressRate       float64         // ToolProgress messages per second per call (0 or less = unlimited)
+	compactToolSchemas bool            // Strip nested descriptions and long enums from advertised schemas
+
+	// Heartbeats during silent runs
+	heartbeat         time.Duration // Silence before a session.heartbeat (0 = off)
+	heartbeatMessages bool          // Also deliver heartbeats on Stream
+
+	// Restarts of a CLI process that exited (nil = off)
+	restartPolicy *RestartPolicy
+	restartHooks  []RestartHook // Called after a restart
+
+	// Eviction of idle agents
+	idleTimeout time.Duration    // Idle time before Close (0 = never)
+	onEvict     func(*StopEvent) // Called after an idle agent is closed
 
 	// MCP server configuration
 	mcpServers      map[string]*MCPConfig // MCP servers keyed by name
 	strictMCPConfig bool                  // Only use SDK-configured MCP servers
+	mcpConfigFile   string                // Config file of the running MCPManaged servers
 
 	// Subagent configuration
 	subagents map[string]*SubagentConfig // Subagents keyed by name
 
+	lenientModels bool     // Pass unknown model names to the CLI (StrictModels(false))
+	declaredTools []string // Tool names New should not warn about (DeclareTools)
+
+	costEstimator      *estimatorConfig // Streaming cost estimates (nil = off)
+	auditCostBreakdown bool             // Add Result.CostBreakdown to message.result events
+	tokenCounter       TokenCounter     // Token estimates (nil = CountTokens)
+
+	// Results Run rejects
+	requireNonEmptyResult bool                   // Empty or whitespace ResultText
+	refusalDetector       func(text string) bool // ResultText it matches (nil = off)
+
 	// Skills configuration
 	skills    map[string]*SkillConfig // Inline skills keyed by name
 	skillDirs []string                // Directories to load skills from
+	skillMode SkillMode               // How skills are delivered to the CLI
 
 	// System prompt configuration
 	systemPromptPreset string // Preset system prompt name
@@ -86,7 +226,11 @@ type config struct {
 // Option configures an Agent.
 type Option func(*config)
 
-// Model sets the Claude model to use.
+// Model sets the Claude model to use. It accepts a full identifier such
+// as "claude-sonnet-4-5", a dated snapshot, a Vertex AI or Bedrock model
+// ID, or an alias ("sonnet", "haiku", "opus") that resolves to the newest
+// model in the family. New
+// rejects names missing from KnownModels unless StrictModels(false) is set.
 func Model(name string) Option {
 	return func(c *config) {
 		c.model = name
@@ -107,20 +251,60 @@ func CLIPath(path string) Option {
 	}
 }
 
+// MaxLineBytes limits the length of a single line of CLI output. A line that
+// exceeds the limit is discarded and reported as a ParseWarning message (and a
+// parse.warning audit event) instead of ending the stream. A value of 0 means
+// unlimited (default).
+func MaxLineBytes(n int) Option {
+	return func(c *config) {
+		c.maxLineBytes = n
+	}
+}
+
 // PreToolUse adds hooks that are called before tool execution.
 // Hooks are evaluated in order: first Deny wins, Allow short-circuits.
-func PreToolUse(hooks ...PreToolUseHook) Option {
+// The built-in hooks that deny calls, such as DenyCommands and AllowPaths,
+// have PrioritySecurity and run before the others, however they were
+// registered; other hooks have PriorityDefault. Use PreToolUsePriority to
+// set a priority.
+//
+// A path check therefore sees a path before RedirectPath rewrites it, even
+// when the RedirectPath hook was registered first: with
+// PreToolUse(RedirectPath("/tmp", "/sandbox/tmp"), AllowPaths("/sandbox")),
+// AllowPaths denies writes to /tmp instead of allowing the rewritten path.
+// Give the RedirectPath hook a priority above PrioritySecurity to rewrite
+// first.
+func PreToolUse[H PreToolUseHooks](hooks ...H) Option {
+	return func(c *config) {
+		for _, hook := range hooks {
+			eval, builtin := splitHook(hook)
+			priority := PriorityDefault
+			if builtin != nil {
+				priority = builtin.priority
+			}
+			c.addPreToolUse(priority, eval, builtin)
+		}
+	}
+}
+
+// ResolvePathSymlinks makes AllowPaths, DenyPaths, and RedirectPath resolve
+// symlinks before comparing paths, so a symlink inside an allowed directory
+// cannot point outside it. Paths that do not exist yet, such as the target
+// of a Write, are resolved through their longest existing parent.
+func ResolvePathSymlinks() Option {
 	return func(c *config) {
-		c.preToolUseHooks = append(c.preToolUseHooks, hooks...)
+		c.resolvePathSymlinks = true
 	}
 }
 
 // Tools sets the available tools for the agent.
-// Use specific names like "Bash", "Read", "Write", "Edit", "Glob", "Grep", "Task".
+// Use the built-in names such as ToolBash, ToolRead, or ToolEdit, or
+// MCPTool for tools from an MCP server.
 // An empty slice disables all tools.
 func Tools(names ...string) Option {
 	return func(c *config) {
 		c.tools = names
+		c.noTools = len(names) == 0
 	}
 }
 
@@ -182,6 +366,19 @@ func CustomTool(tools ...Tool) Option {
 	}
 }
 
+// MaxConcurrentTools limits how many custom tool executions may run at the
+// same time across all custom tools. When Claude issues several tool calls in
+// one assistant message, calls beyond the limit wait and are started in
+// message order. A value of 0 means unlimited (default).
+//
+// Per-tool limits can be set with FuncTool.WithMaxConcurrency; a call must
+// satisfy both limits before it executes.
+func MaxConcurrentTools(n int) Option {
+	return func(c *config) {
+		c.maxConcurrentTools = n
+	}
+}
+
 // MCPServer configures an MCP (Model Context Protocol) server.
 // MCP servers provide external tools to Claude via stdio, SSE, or HTTP transports.
 //
@@ -211,6 +408,27 @@ func MCPServer(name string, opts ...MCPOption) Option {
 	}
 }
 
+// OnMCPStatus adds hooks that are called with the status of MCP servers:
+// for each server the CLI reports when the session initializes, and
+// whenever an MCPManaged server starts, exits, is restarted, or is stopped.
+// Hooks for managed servers are called from the goroutine that watches the
+// server, so they must not block. A panicking hook does not affect the
+// others.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.MCPServer("internal", agent.MCPCommand("internal-mcp"), agent.MCPManaged(true)),
+//	    agent.OnMCPStatus(func(s agent.MCPStatus) {
+//	        log.Printf("MCP server %s: %s (%d restarts)", s.Name, s.Status, s.Restarts)
+//	    }),
+//	)
+func OnMCPStatus(hooks ...MCPStatusHook) Option {
+	return func(c *config) {
+		c.mcpStatusHooks = append(c.mcpStatusHooks, hooks...)
+	}
+}
+
 // StrictMCPConfig ensures only SDK-configured MCP servers are used.
 // When enabled, user and project MCP configurations are ignored,
 // providing a controlled environment with only explicitly configured servers.
@@ -279,6 +497,23 @@ func SkillsDir(path string) Option {
 	}
 }
 
+// SkillsMode controls how skills configured with Skill and SkillsDir are
+// delivered to the CLI. The default, SkillModeSystemPrompt, appends them to the
+// system prompt. SkillModeNative writes them to a temporary .claude/skills
+// directory under the working directory, and SkillModeOff ignores them.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.SkillsDir("./skills"),
+//	    agent.SkillsMode(agent.SkillModeNative),
+//	)
+func SkillsMode(mode SkillMode) Option {
+	return func(c *config) {
+		c.skillMode = mode
+	}
+}
+
 // SystemPromptPreset sets a preset system prompt by name.
 // Presets provide predefined personas and behaviors for Claude.
 func SystemPromptPreset(name string) Option {
@@ -317,6 +552,30 @@ func Env(key, value string) Option {
 	}
 }
 
+// APIKey sets the Anthropic API key the CLI process uses, instead of the
+// CLI's global login. The key is passed in the process environment only;
+// it overrides an ANTHROPIC_API_KEY set with Env or inherited, and the
+// other credential variables, ANTHROPIC_AUTH_TOKEN and
+// CLAUDE_CODE_OAUTH_TOKEN, are removed whether set with Env or inherited.
+// There is no option to clear the rest of the inherited environment.
+// APIKey and OAuthToken are mutually exclusive; New returns a *ConfigError
+// if both are set.
+func APIKey(key string) Option {
+	return func(c *config) {
+		c.apiKey = key
+	}
+}
+
+// OAuthToken sets the OAuth token the CLI process uses, instead of the
+// CLI's global login. Like APIKey, it applies to the process environment
+// only, overrides Env, and removes ANTHROPIC_API_KEY and
+// ANTHROPIC_AUTH_TOKEN.
+func OAuthToken(token string) Option {
+	return func(c *config) {
+		c.oauthToken = token
+	}
+}
+
 // AddDir adds directories the agent is allowed to access.
 // These are passed to the CLI via --add-dir flag.
 func AddDir(paths ...string) Option {
@@ -342,6 +601,54 @@ func MaxTurns(n int) Option {
 	}
 }
 
+// ContextWindow overrides the context window size, in tokens, used to compute
+// context utilization. By default the size is looked up from the model name.
+func ContextWindow(tokens int) Option {
+	return func(c *config) {
+		c.contextWindow = tokens
+	}
+}
+
+// OnFileChanged adds hooks that are called when a Write, Edit, MultiEdit,
+// or NotebookEdit call completes, so editors can reload the file without
+// watching the file system. Each hook is called once per call, when the
+// tool result arrives, with the path after any RedirectPath rewrite. Calls
+// whose result is an error are reported too, with IsError set. Hooks run
+// on the AsyncHooks pool when it is configured; otherwise they run on the
+// stream and should return quickly. A panicking hook is recovered.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.AsyncHooks(2, 64),
+//	    agent.OnFileChanged(func(e agent.FileChangeEvent) {
+//	        if !e.IsError {
+//	            editor.Reload(e.Path)
+//	        }
+//	    }),
+//	)
+func OnFileChanged(hooks ...FileChangedHook) Option {
+	return func(c *config) {
+		c.fileChangedHooks = append(c.fileChangedHooks, hooks...)
+	}
+}
+
+// OnContextUsage registers a hook that is called once, after a Result, when
+// approximate context utilization first reaches threshold (0.0 to 1.0).
+// Utilization is the cumulative input and output token count divided by the
+// context window (see ContextWindow). Use it to intervene before compaction.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.OnContextUsage(0.8, func(e *agent.ContextUsageEvent) {
+//	    log.Printf("context %.0f%% full (%d/%d tokens)", e.Utilization*100, e.Tokens, e.Window)
+//	}))
+func OnContextUsage(threshold float64, fn ContextUsageHook) Option {
+	return func(c *config) {
+		c.contextWatchers = append(c.contextWatchers, contextUsageWatcher{threshold: threshold, fn: fn})
+	}
+}
+
 // Resume continues a previous session by its ID.
 // The session ID can be obtained from Agent.SessionID() or from a previous result.
 func Resume(sessionID string) Option {
@@ -360,9 +667,31 @@ func Fork(sessionID string) Option {
 	}
 }
 
+// ForkFrom branches from an existing session at the end of the given turn
+// (1-based), discarding later turns. This lets several attempts start from
+// the same baseline before later turns changed the context.
+//
+// The CLI has no flag for forking at a turn, so the SDK implements it: New
+// copies the session transcript, truncated after atTurn, to a new session
+// and resumes that session; Close removes the copy. This requires a CLI that
+// stores transcripts as JSONL under $CLAUDE_CONFIG_DIR/projects (default
+// ~/.claude/projects), as current Claude Code releases do. The transcript
+// is looked up in the project directory for WorkDir first. New returns a
+// *StartError if the transcript cannot be found, is in several other
+// projects, or atTurn is not between 1 and the last turn.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.ForkFrom(sessionID, 2))
+func ForkFrom(sessionID string, atTurn int) Option {
+	return func(c *config) {
+		c.forkFrom = &forkPoint{sessionID: sessionID, turn: atTurn}
+	}
+}
+
 // WithSchema configures the agent for structured output using the provided
 // type as a template. All responses will be formatted as JSON matching
-// the generated schema.
+// the generated schema. Use SchemaFor to inspect the generated schema.
 //
 // Example:
 //
@@ -370,21 +699,34 @@ func Fork(sessionID string) Option {
 //	    Answer string `json:"answer" desc:"The answer"`
 //	}
 //	a, _ := agent.New(ctx, agent.WithSchema(Response{}))
-func WithSchema(example any) Option {
-	return func(c *config) {
-		t := reflect.TypeOf(example)
-		if t == nil {
+//
+// A nil typed pointer such as (*Response)(nil) is accepted and describes
+// its element type, so WithSchema(Response{}) and WithSchema(&Response{})
+// are equivalent.
+//
+// Options such as UseDefs change how the schema is generated.
+func WithSchema(example any, opts ...SchemaOption) Option {
+	t := reflect.TypeOf(example)
+	if t == nil {
+		return func(c *config) {
 			c.schemaError = &SchemaError{Type: "nil", Reason: "example cannot be nil"}
-			return
 		}
+	}
+	return withSchemaType(t, opts...)
+}
 
-		schema, err := schemaFromType(t)
+// withSchemaType configures structured output for t, with pointers
+// unwrapped, and records the type for RunWithSchema to check against.
+func withSchemaType(t reflect.Type, opts ...SchemaOption) Option {
+	t = derefType(t)
+	return func(c *config) {
+		schema, err := schemaFromType(t, opts...)
 		if err != nil {
 			c.schemaError = err
 			return
 		}
 
-		schemaJSON, err := json.Marshal(schema)
+		schemaJSON, err := marshalSchema(schema)
 		if err != nil {
 			c.schemaError = &SchemaError{
 				Type:   t.String(),
@@ -395,11 +737,15 @@ func WithSchema(example any) Option {
 		}
 
 		c.jsonSchema = string(schemaJSON)
+		c.schemaType = t
+		c.rawSchema = nil
 	}
 }
 
 // WithSchemaRaw configures the agent with a custom JSON Schema.
-// Use this for schemas that cannot be derived from Go types.
+// Use this for schemas that cannot be derived from Go types. New checks
+// the schema with ValidateSchema and returns a *ConfigError if it finds
+// issues, unless LintSchemaRaw(false) is set.
 //
 // Example:
 //
@@ -412,7 +758,7 @@ func WithSchema(example any) Option {
 //	a, _ := agent.New(ctx, agent.WithSchemaRaw(schema))
 func WithSchemaRaw(schema map[string]any) Option {
 	return func(c *config) {
-		schemaJSON, err := json.Marshal(schema)
+		schemaJSON, err := marshalSchema(schema)
 		if err != nil {
 			c.schemaError = &SchemaError{
 				Reason: "failed to marshal schema",
@@ -421,6 +767,89 @@ func WithSchemaRaw(schema map[string]any) Option {
 			return
 		}
 		c.jsonSchema = string(schemaJSON)
+		c.schemaType = nil
+		c.rawSchema = schema
+	}
+}
+
+// LintSchemaRaw sets whether New checks a WithSchemaRaw schema with
+// ValidateSchema, which it does by default. New returns a *ConfigError
+// listing every issue found. Pass false for a schema that uses keywords
+// ValidateSchema does not know.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.WithSchemaRaw(legacySchema),
+//	    agent.LintSchemaRaw(false),
+//	)
+func LintSchemaRaw(lint bool) Option {
+	return func(c *config) {
+		c.looseSchemaRaw = !lint
+	}
+}
+
+// LintToolSchemas makes New check the InputSchema of each CustomTool with
+// ValidateSchema. New returns a *ConfigError naming the tool and listing
+// every issue of its schema. Tools without an input schema are skipped.
+// It is off by default, so existing loose schemas keep working.
+//
+// Example:
+//
+//	a, err := agent.New(ctx,
+//	    agent.CustomTool(search, fetch),
+//	    agent.LintToolSchemas(true),
+//	)
+func LintToolSchemas(lint bool) Option {
+	return func(c *config) {
+		c.lintToolSchemas = lint
+	}
+}
+
+// WithSchemaString configures the agent with a JSON Schema passed verbatim,
+// such as a pre-approved schema snapshot produced by SchemaFor. The string
+// must be a JSON object; it is otherwise passed to the CLI unchanged.
+//
+// Example:
+//
+//	//go:embed response.schema.json
+//	var responseSchema string
+//
+//	a, _ := agent.New(ctx, agent.WithSchemaString(responseSchema))
+func WithSchemaString(schema string) Option {
+	return func(c *config) {
+		var obj map[string]any
+		if err := json.Unmarshal([]byte(schema), &obj); err != nil || obj == nil {
+			c.schemaError = &SchemaError{
+				Type:   "string",
+				Reason: "schema must be a JSON object",
+				Cause:  err,
+			}
+			return
+		}
+		c.jsonSchema = schema
+		c.schemaType = nil
+		c.rawSchema = nil
+	}
+}
+
+// StrictSchema makes RunWithSchema check the response against the schema
+// before unmarshaling it. Required fields must be present, values must have
+// the declared JSON types, enum values must be listed, and objects without
+// additionalProperties allowed must have no extra fields. A response that
+// fails returns a *SchemaValidationError listing every violation, and ptr
+// is left unchanged. Without StrictSchema, a response that unmarshals is
+// accepted even if fields are missing.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.WithSchema(Report{}),
+//	    agent.StrictSchema(true),
+//	)
+func StrictSchema(strict bool) Option {
+	return func(c *config) {
+		c.strictSchema = strict
 	}
 }
 
@@ -440,15 +869,62 @@ func Audit(h AuditHandler) Option {
 	}
 }
 
+// Labels attaches labels, such as tenant or workflow identifiers, to the agent.
+// Labels are included in every AuditEvent and StopEvent. Multiple calls merge,
+// with later values taking precedence. Keys must be non-empty and contain only
+// letters, digits, '.', '_', '-' and '/'; an invalid key makes New return a
+// *LabelError. Use Agent.SetLabel to add labels after construction.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Labels(map[string]string{
+//	    "tenant":   "acme",
+//	    "workflow": "nightly-review",
+//	}))
+func Labels(labels map[string]string) Option {
+	return func(c *config) {
+		for k, v := range labels {
+			if err := validateLabelKey(k); err != nil {
+				// Deferred until New(), like schema errors
+				if c.schemaError == nil {
+					c.schemaError = err
+				}
+				continue
+			}
+			if c.labels == nil {
+				c.labels = make(map[string]string, len(labels))
+			}
+			c.labels[k] = v
+		}
+	}
+}
+
+// AuditClock sets the source of AuditEvent timestamps, which defaults to
+// time.Now. Inject a fixed or stepped clock to make audit output
+// reproducible, e.g. for golden-file tests.
+//
+// Example:
+//
+//	fixed := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+//	a, _ := agent.New(ctx, agent.AuditClock(func() time.Time { return fixed }))
+func AuditClock(clock func() time.Time) Option {
+	return func(c *config) {
+		c.auditClock = clock
+	}
+}
+
 // AuditToFile configures the agent to write audit events to a file in JSONL format.
 // The file is created or appended to. The file is closed when the agent is closed.
+// Agents in one process given the same path share the file through
+// SharedAuditFile: their lines never interleave, and the file is closed
+// when the last of them is closed.
 //
 // Example:
 //
 //	a, _ := agent.New(ctx, agent.AuditToFile("audit.jsonl"))
 func AuditToFile(path string) Option {
 	return func(c *config) {
-		handler, cleanup, err := AuditFileHandler(path)
+		handler, cleanup, err := SharedAuditFile(path)
 		if err != nil {
 			// Store error for later reporting - we can't return it from Option
 			// Use a special error that will be checked in New()
@@ -484,6 +960,50 @@ func PostToolUse(hooks ...PostToolUseHook) Option {
 	}
 }
 
+// AsyncHooks runs PostToolUse hooks, SubagentStop hooks, and audit handlers
+// on a pool of workers instead of the Stream goroutine, so slow handlers do
+// not delay message delivery. Calls wait in a queue of queueSize; when it is
+// full, the AsyncQueuePolicy decides whether to block or drop the oldest
+// call. Values below 1 are raised to 1. Close waits for queued calls to
+// finish before returning.
+//
+// With one worker, calls run in the order they were queued. With more
+// workers there is no ordering guarantee; audit events still carry Seq.
+// Panics in handlers are recovered per call.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PostToolUse(pushMetrics),
+//	    agent.AsyncHooks(1, 1024, agent.AsyncQueuePolicy(agent.QueueDropOldest)),
+//	)
+func AsyncHooks(workers, queueSize int, opts ...AsyncOption) Option {
+	return func(c *config) {
+		ac := &asyncConfig{workers: workers, queueSize: queueSize}
+		for _, opt := range opts {
+			opt(ac)
+		}
+		c.asyncHooks = ac
+	}
+}
+
+// SlowHookThreshold reports PreToolUse and PostToolUse hooks that take
+// longer than d. Each slow hook emits a hook.slow audit event and logs a
+// warning with log/slog, naming the hook by its index in the chain.
+// Hooks run by AsyncHooks are not checked, since they do not delay tools.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PreToolUse(checkPolicyService),
+//	    agent.SlowHookThreshold(200*time.Millisecond),
+//	)
+func SlowHookThreshold(d time.Duration) Option {
+	return func(c *config) {
+		c.slowHookThreshold = d
+	}
+}
+
 // OnStop adds hooks that are called when the agent session ends.
 // Stop hooks receive information about the session including total turns,
 // cost, and the reason for stopping.
@@ -503,6 +1023,29 @@ func OnStop(hooks ...StopHook) Option {
 	}
 }
 
+// OnControlRequest adds handlers that see every control request from the
+// CLI before the SDK handles it. A handler returning (resp, true) answers the
+// request with resp, and PreToolUse hooks and custom tools are skipped;
+// returning (nil, false) passes the request to the next handler and then to
+// the default handling. This is an escape hatch for protocol features the
+// SDK does not model yet.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.OnControlRequest(
+//	    func(ctx context.Context, req *agent.RawControlRequest) (*agent.RawControlResponse, bool) {
+//	        if req.Subtype != "hook_callback" {
+//	            return nil, false
+//	        }
+//	        return &agent.RawControlResponse{Response: map[string]any{"continue": true}}, true
+//	    },
+//	))
+func OnControlRequest(handlers ...ControlRequestHandler) Option {
+	return func(c *config) {
+		c.controlHandlers = append(c.controlHandlers, handlers...)
+	}
+}
+
 // PreCompact adds hooks that are called before context window compaction.
 // These hooks can archive the current transcript or extract important data
 // before Claude compacts the context window.
@@ -523,6 +1066,42 @@ func PreCompact(hooks ...PreCompactHook) Option {
 	}
 }
 
+// PreserveOnCompact calls fn when the context window is compacted and
+// carries the summary it returns into the next prompt, so decisions made
+// early in a session survive compaction. The summary is prepended to the
+// next prompt in a delimited "Preserved context" block, before
+// UserPromptSubmit hooks run. fn runs after PreCompact hooks and may take as
+// long as it needs, for example to summarize with RunStructured on a cheaper
+// model. An error or empty summary leaves the next prompt unchanged; errors
+// are reported as a compact.preserve_failed audit event.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.PreserveOnCompact(func(path string) (string, error) {
+//	    return summarizeDecisions(ctx, path)
+//	}))
+func PreserveOnCompact(fn PreserveFunc) Option {
+	return func(c *config) {
+		c.preserveFn = fn
+	}
+}
+
+// SubagentStart adds hooks that are called when Claude starts one of the
+// subagents configured with Subagent, that is, when a Task tool call names
+// it as its subagent_type. Together with SubagentStop and
+// Agent.ActiveSubagents, they show which subagents are running.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.SubagentStart(func(e *agent.SubagentStartEvent) {
+//	    log.Printf("Subagent %s started for %q", e.SubagentType, e.Description)
+//	}))
+func SubagentStart(hooks ...SubagentStartHook) Option {
+	return func(c *config) {
+		c.subagentStartHooks = append(c.subagentStartHooks, hooks...)
+	}
+}
+
 // SubagentStop adds hooks that are called when a subagent completes execution.
 // Subagents are spawned by the Task tool and run autonomously. These hooks
 // allow observation of subagent execution for metrics and logging.
@@ -568,6 +1147,8 @@ func newConfig(opts ...Option) *config {
 		workDir:        ".",
 		permissionMode: PermissionDefault,
 		env:            make(map[string]string),
+		progressRate:   DefaultToolProgressRate,
+		maxReactions:   DefaultMaxReactionsPerRun,
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -578,10 +1159,40 @@ func newConfig(opts ...Option) *config {
 // runConfig holds per-run configuration.
 type runConfig struct {
 	timeout  time.Duration // Per-run timeout (0 = use context timeout)
-	maxTurns int           // Per-run max turns override (0 = use agent default)
+	maxTurns int           // Max turns for the run, starting from the agent's MaxTurns (0 = unlimited)
+
+	// Message filtering for Stream
+	onlyMessages    map[MessageType]bool // Deliver only these kinds (nil = all)
+	excludeMessages map[MessageType]bool // Never deliver these kinds
+
+	// Soft deadline
+	softDeadline      time.Duration // Ask Claude to wrap up after this long (0 = none)
+	softDeadlineGrace time.Duration // Time allowed after the soft deadline (0 = default)
+
+	// Labels added to the run's audit events (set by Pipeline)
+	labels map[string]string
+
+	// Values read by hooks during the run (set by RunValue)
+	values runValues
+
+	// Tools the run may use (set by ToolsRun and DisallowToolsRun; nil = all)
+	tools *runToolPolicy
+}
+
+// delivers reports whether Stream should send msg on its channel.
+// A Result is always delivered because it ends the stream.
+func (rc *runConfig) delivers(msg Message) bool {
+	typ := messageTypeOf(msg)
+	if typ == MessageResult {
+		return true
+	}
+	if rc.onlyMessages != nil && !rc.onlyMessages[typ] {
+		return false
+	}
+	return !rc.excludeMessages[typ]
 }
 
-// RunOption configures a single Run() call.
+// RunOption configures a single Run() or Stream() call.
 type RunOption func(*runConfig)
 
 // newRunConfig creates a runConfig and applies options.
@@ -594,16 +1205,90 @@ func newRunConfig(opts ...RunOption) *runConfig {
 }
 
 // Timeout sets a timeout for this Run() call.
-// If the operation exceeds this duration, the context is cancelled.
+// If the operation exceeds this duration, the context is cancelled. A zero
+// d removes a timeout set by DefaultRunOptions.
 func Timeout(d time.Duration) RunOption {
 	return func(rc *runConfig) {
 		rc.timeout = d
 	}
 }
 
+// SoftDeadline asks Claude to wrap up once the run has taken d. When d
+// passes, a message is sent telling Claude that time is nearly up and to
+// summarize its progress and stop. The run is cut off at d plus the grace
+// period (DefaultSoftDeadlineGrace unless SoftDeadlineGrace is set), in
+// which case Run returns context.DeadlineExceeded.
+//
+// Claude answers the warning with a turn of its own after the current one.
+// The run ends with that turn's Result, whose NumTurns, CostUSD, and Usage
+// include the turn the warning interrupted. Result.SoftDeadlineHit reports
+// whether the warning was sent.
+//
+// Example:
+//
+//	result, err := a.Run(ctx, prompt,
+//	    agent.SoftDeadline(5*time.Minute),
+//	    agent.SoftDeadlineGrace(time.Minute),
+//	)
+func SoftDeadline(d time.Duration) RunOption {
+	return func(rc *runConfig) {
+		rc.softDeadline = d
+	}
+}
+
+// SoftDeadlineGrace sets how long a run may continue after its
+// SoftDeadline before it is cut off.
+func SoftDeadlineGrace(d time.Duration) RunOption {
+	return func(rc *runConfig) {
+		rc.softDeadlineGrace = d
+	}
+}
+
+// OnlyMessages limits the messages Stream delivers to the given kinds.
+// Other messages are still processed (hooks, audit events, tool tracking)
+// but not sent on the channel. The Result is always delivered.
+//
+// Example:
+//
+//	for msg := range a.Stream(ctx, prompt, agent.OnlyMessages(agent.MessageText)) {
+//	    switch m := msg.(type) {
+//	    case *agent.Text:
+//	        fmt.Print(m.Text)
+//	    case *agent.Result:
+//	        fmt.Printf("\n$%.4f\n", m.CostUSD)
+//	    }
+//	}
+func OnlyMessages(types ...MessageType) RunOption {
+	return func(rc *runConfig) {
+		if rc.onlyMessages == nil {
+			rc.onlyMessages = make(map[MessageType]bool)
+		}
+		for _, t := range types {
+			rc.onlyMessages[t] = true
+		}
+	}
+}
+
+// ExcludeMessages stops Stream from delivering the given kinds of message.
+// Excluded messages are still processed (hooks, audit events, tool tracking).
+// The Result cannot be excluded.
+func ExcludeMessages(types ...MessageType) RunOption {
+	return func(rc *runConfig) {
+		if rc.excludeMessages == nil {
+			rc.excludeMessages = make(map[MessageType]bool)
+		}
+		for _, t := range types {
+			rc.excludeMessages[t] = true
+		}
+	}
+}
+
 // MaxTurnsRun overrides the agent-level MaxTurns for this Run() call.
+// A value of 0 keeps the agent's limit.
 func MaxTurnsRun(n int) RunOption {
 	return func(rc *runConfig) {
-		rc.maxTurns = n
+		if n > 0 {
+			rc.maxTurns = n
+		}
 	}
 }
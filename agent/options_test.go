@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -632,3 +633,37 @@ func TestSystemPromptAppendDefaultEmpty(t *testing.T) {
 		t.Errorf("default systemPromptAppend = %q, want empty", c.systemPromptAppend)
 	}
 }
+
+func TestSkillsModeOption(t *testing.T) {
+	if c := newConfig(); c.skillMode != SkillModeSystemPrompt {
+		t.Errorf("default skillMode = %v, want SkillModeSystemPrompt", c.skillMode)
+	}
+	if c := newConfig(SkillsMode(SkillModeNative)); c.skillMode != SkillModeNative {
+		t.Errorf("skillMode = %v, want SkillModeNative", c.skillMode)
+	}
+}
+
+func TestWithSchemaString(t *testing.T) {
+	schema := `{"type": "object",  "properties": {"b": {"type": "string"}, "a": {"type": "integer"}}}`
+
+	cfg := newConfig(WithSchemaString(schema))
+	if cfg.schemaError != nil {
+		t.Fatalf("schemaError = %v", cfg.schemaError)
+	}
+	if cfg.jsonSchema != schema {
+		t.Errorf("jsonSchema = %q, want schema passed verbatim", cfg.jsonSchema)
+	}
+}
+
+func TestWithSchemaStringInvalid(t *testing.T) {
+	for _, schema := range []string{"", "not json", `["array"]`, "null"} {
+		cfg := newConfig(WithSchemaString(schema))
+		var schemaErr *SchemaError
+		if !errors.As(cfg.schemaError, &schemaErr) {
+			t.Errorf("WithSchemaString(%q) schemaError = %v, want *SchemaError", schema, cfg.schemaError)
+		}
+		if cfg.jsonSchema != "" {
+			t.Errorf("WithSchemaString(%q) jsonSchema = %q, want empty", schema, cfg.jsonSchema)
+		}
+	}
+}
@@ -2,18 +2,43 @@ package agent
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"io"
 	"time"
 )
 
 // parser parses JSON lines from Claude Code CLI output.
 type parser struct {
-	scanner   *bufio.Scanner
-	sessionID string
-	turn      int
-	sequence  int
-	pending   []Message // buffered messages from multi-block assistant messages
+	reader       *bufio.Reader
+	maxLineBytes int // Hard limit on line length (0 = unlimited)
+	sessionID    string
+	turn         int
+	sequence     int
+	pending      []Message // buffered messages from multi-block assistant messages
+
+	// skipInitLists leaves the tool and MCP server lists of SystemInit
+	// empty, for agents with no audit handler to report them to.
+	skipInitLists bool
+
+	// keepRaw keeps the JSON line of SystemInit, for KeepRaw
+	keepRaw bool
+
+	// readAny is set once a line has been read; only the first line may
+	// start with a byte order mark
+	readAny bool
+
+	// Buffers reused from line to line, so that parsing a line allocates
+	// little beyond the messages it returns
+	line   []byte         // Lines longer than the reader's buffer
+	raw    rawMessage     // The line being parsed
+	blocks []contentBlock // Content blocks of the assistant message being parsed
+
+	// Duplicate suppression for assistant content, reset at the end of each turn
+	seenBlocks map[string]struct{} // Keys of content blocks already emitted this turn
+	duplicates int                 // Blocks suppressed this turn
 }
 
 // rawMessage is used for initial JSON parsing before type discrimination.
@@ -21,7 +46,7 @@ type rawMessage struct {
 	Type    string          `json:"type"`
 	Subtype string          `json:"subtype,omitempty"`
 	Content json.RawMessage `json:"content,omitempty"`
-	Message json.RawMessage `json:"message,omitempty"` // For assistant messages
+	Message assistantBody   `json:"message,omitempty"` // For assistant messages
 
 	// System init fields
 	SessionID      string   `json:"session_id,omitempty"`
@@ -32,19 +57,27 @@ type rawMessage struct {
 		Status string `json:"status"`
 	} `json:"mcp_servers,omitempty"`
 
+	// Conversation linkage; transcripts spell the parent parentUuid
+	UUID            string `json:"uuid,omitempty"`
+	ParentUUID      string `json:"parent_uuid,omitempty"`
+	ParentUUIDCamel string `json:"parentUuid,omitempty"`
+
 	// Result fields
-	DurationMS    float64 `json:"duration_ms,omitempty"`
-	DurationAPIMS float64 `json:"duration_api_ms,omitempty"`
-	NumTurns      int     `json:"num_turns,omitempty"`
-	TotalCostUSD  float64 `json:"total_cost_usd,omitempty"` // total_cost_usd, not cost_usd
-	IsError       bool    `json:"is_error,omitempty"`
-	Result        string  `json:"result,omitempty"`
-	Usage         *Usage  `json:"usage,omitempty"`
+	DurationMS    float64   `json:"duration_ms,omitempty"`
+	DurationAPIMS float64   `json:"duration_api_ms,omitempty"`
+	NumTurns      int       `json:"num_turns,omitempty"`
+	TotalCostUSD  float64   `json:"total_cost_usd,omitempty"` // total_cost_usd, not cost_usd
+	IsError       bool      `json:"is_error,omitempty"`
+	Result        string    `json:"result,omitempty"`
+	Usage         *rawUsage `json:"usage,omitempty"`
 
 	// Permission/Control request fields
-	RequestID string         `json:"request_id,omitempty"`
-	ToolName  string         `json:"tool_name,omitempty"`
-	ToolInput map[string]any `json:"tool_input,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	ToolName  string          `json:"tool_name,omitempty"`
+	ToolInput map[string]any  `json:"tool_input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Request   json.RawMessage `json:"request,omitempty"`  // control_request body
+	Response  json.RawMessage `json:"response,omitempty"` // control_response body
 
 	// Compact event fields
 	Trigger    string `json:"trigger,omitempty"`
@@ -57,6 +90,40 @@ type rawMessage struct {
 	SubagentCost    float64 `json:"subagent_cost,omitempty"`
 }
 
+// rawUsage holds token usage as sent by the CLI. It accepts the CLI's
+// snake_case field names as well as the legacy Go field names.
+type rawUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+
+	// Legacy field names
+	LegacyInputTokens  int `json:"InputTokens"`
+	LegacyOutputTokens int `json:"OutputTokens"`
+	LegacyCacheRead    int `json:"CacheRead"`
+	LegacyCacheWrite   int `json:"CacheWrite"`
+}
+
+// toUsage converts raw usage, preferring the CLI's field names.
+func (u *rawUsage) toUsage() Usage {
+	if u == nil {
+		return Usage{}
+	}
+	pick := func(primary, legacy int) int {
+		if primary != 0 {
+			return primary
+		}
+		return legacy
+	}
+	return Usage{
+		InputTokens:  pick(u.InputTokens, u.LegacyInputTokens),
+		OutputTokens: pick(u.OutputTokens, u.LegacyOutputTokens),
+		CacheRead:    pick(u.CacheReadInputTokens, u.LegacyCacheRead),
+		CacheWrite:   pick(u.CacheCreationInputTokens, u.LegacyCacheWrite),
+	}
+}
+
 // contentBlock represents a content block in an assistant message.
 type contentBlock struct {
 	Type      string         `json:"type"`
@@ -75,51 +142,146 @@ type contentBlock struct {
 }
 
 // newParser creates a new parser for the given reader.
+// Lines may be of any length; set maxLineBytes to impose a limit.
 func newParser(r io.Reader) *parser {
-	scanner := bufio.NewScanner(r)
-	// Set a larger buffer for potentially long JSON lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
 	return &parser{
-		scanner:  scanner,
+		reader:   bufio.NewReaderSize(r, 64*1024),
 		turn:     1,
 		sequence: 0,
 	}
 }
 
+// readLine reads the next line without its trailing newline.
+// If the line exceeds maxLineBytes, the rest of the line is consumed and
+// discarded, and the returned line is nil with dropped set to the total
+// length of the line. It returns io.EOF when no more data is available.
+//
+// The line is valid only until the next call: it refers to the reader's
+// buffer, or for lines longer than the buffer, to p.line.
+func (p *parser) readLine() (line []byte, dropped int, err error) {
+	buf := p.line[:0]
+	total := 0
+	for {
+		chunk, err := p.reader.ReadSlice('\n')
+		total += len(chunk)
+
+		tooLong := p.maxLineBytes > 0 && total > p.maxLineBytes+1 // +1 for the newline
+		switch {
+		case tooLong || dropped > 0:
+			buf = buf[:0]
+			dropped = total
+		case err == nil && len(buf) == 0:
+			// The whole line is in the reader's buffer; use it in place
+			return chunk[:len(chunk)-1], 0, nil
+		default:
+			buf = append(buf, chunk...)
+			p.line = buf
+		}
+
+		switch err {
+		case nil:
+			if dropped > 0 {
+				return nil, dropped, nil
+			}
+			return buf[:len(buf)-1], 0, nil
+		case bufio.ErrBufferFull:
+			continue // Line continues beyond the buffer
+		case io.EOF:
+			if total == 0 {
+				return nil, 0, io.EOF
+			}
+			if dropped > 0 {
+				return nil, dropped, nil
+			}
+			return buf, 0, nil // Final line without a newline
+		default:
+			return nil, 0, err
+		}
+	}
+}
+
+// utf8BOM is the UTF-8 byte order mark some Windows shells and wrappers
+// write at the start of the output.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// trimLine removes a byte order mark from the first line and carriage
+// returns from the end of every line, so that output with CRLF line
+// endings parses as it would with LF. JSON strings escape their line
+// breaks, so text content is never changed.
+func (p *parser) trimLine(line []byte) []byte {
+	if !p.readAny {
+		p.readAny = true
+		line = bytes.TrimPrefix(line, utf8BOM)
+	}
+	return bytes.TrimRight(line, "\r")
+}
+
 // next returns the next message from the stream.
 func (p *parser) next() (Message, error) {
-	// Drain pending buffer first (from multi-block assistant messages)
-	if len(p.pending) > 0 {
-		msg := p.pending[0]
-		p.pending = p.pending[1:]
-		return msg, nil
-	}
+	for {
+		// Drain pending buffer first (from multi-block assistant messages)
+		if len(p.pending) > 0 {
+			msg := p.pending[0]
+			p.pending[0] = nil // Let the message be collected once delivered
+			p.pending = p.pending[1:]
+			return msg, nil
+		}
 
-	if !p.scanner.Scan() {
-		if err := p.scanner.Err(); err != nil {
+		line, dropped, err := p.readLine()
+		if err != nil {
 			return nil, err
 		}
-		return nil, io.EOF
-	}
+		if dropped > 0 {
+			// Oversized line was discarded; report it and carry on with the next line
+			return &ParseWarning{
+				MessageMeta: p.makeMeta(),
+				Reason:      "line exceeds maximum length and was discarded",
+				Bytes:       dropped,
+			}, nil
+		}
+		line = p.trimLine(line)
+		if len(line) == 0 {
+			continue // Skip empty lines
+		}
 
-	line := p.scanner.Bytes()
-	if len(line) == 0 {
-		// Skip empty lines, try next
-		return p.next()
-	}
+		// Reuse the decode target and its content block buffer. Messages
+		// copy what they keep, so nothing refers to the previous line.
+		clear(p.blocks[:cap(p.blocks)])
+		p.raw = rawMessage{Message: assistantBody{messageContent: messageContent{Content: p.blocks[:0]}}}
+		if err := json.Unmarshal(line, &p.raw); err != nil {
+			return nil, err
+		}
+		if cap(p.raw.Message.Content) > cap(p.blocks) {
+			p.blocks = p.raw.Message.Content[:0]
+		}
 
-	var raw rawMessage
-	if err := json.Unmarshal(line, &raw); err != nil {
-		return nil, err
+		msg, err := p.parseMessage(&p.raw)
+		switch m := msg.(type) {
+		case *ControlRequestMsg:
+			m.Raw = append([]byte(nil), line...)
+		case *SystemInit:
+			if p.keepRaw {
+				m.Raw = append([]byte(nil), line...)
+			}
+		}
+		if msg == nil && err == nil {
+			continue // Line held only duplicate content
+		}
+		return msg, err
 	}
-
-	return p.parseMessage(&raw)
 }
 
 // parseMessage converts a rawMessage to a typed Message.
 func (p *parser) parseMessage(raw *rawMessage) (Message, error) {
+	switch raw.Type {
+	case "assistant", "result", "permission", "control", "control_request":
+		p.ensureSessionID()
+	}
 	meta := p.makeMeta()
+	meta.UUID, meta.ParentUUID = raw.UUID, raw.ParentUUID
+	if meta.ParentUUID == "" {
+		meta.ParentUUID = raw.ParentUUIDCamel
+	}
 
 	switch raw.Type {
 	case "system":
@@ -128,8 +290,10 @@ func (p *parser) parseMessage(raw *rawMessage) (Message, error) {
 		return p.parseAssistantMessages(raw, meta)
 	case "result":
 		return p.parseResultMessage(raw, meta)
-	case "permission", "control":
+	case "permission", "control", "control_request":
 		return p.parseControlRequest(raw, meta)
+	case "control_response":
+		return parseControlResponse(raw)
 	default:
 		// Unknown message type - return as Text with the raw type info
 		p.sequence++
@@ -141,13 +305,63 @@ func (p *parser) parseMessage(raw *rawMessage) (Message, error) {
 }
 
 // parseControlRequest handles permission/control request messages.
+// Requests of type control_request nest their fields in a request object.
 func (p *parser) parseControlRequest(raw *rawMessage, meta MessageMeta) (Message, error) {
-	return &ControlRequestMsg{
+	msg := &ControlRequestMsg{
 		MessageMeta: meta,
 		RequestID:   raw.RequestID,
 		Type:        raw.Subtype,
 		ToolName:    raw.ToolName,
 		ToolInput:   raw.ToolInput,
+		ToolUseID:   raw.ToolUseID,
+
+		ParentToolUseID: raw.ParentToolUseID,
+		SubagentType:    raw.SubagentType,
+	}
+
+	if len(raw.Request) > 0 {
+		var req struct {
+			Subtype   string         `json:"subtype"`
+			ToolName  string         `json:"tool_name"`
+			Input     map[string]any `json:"input"`
+			ToolUseID string         `json:"tool_use_id"`
+		}
+		if err := json.Unmarshal(raw.Request, &req); err != nil {
+			return nil, err
+		}
+		if msg.Type == "" {
+			msg.Type = req.Subtype
+		}
+		if msg.ToolName == "" {
+			msg.ToolName = req.ToolName
+		}
+		if msg.ToolInput == nil {
+			msg.ToolInput = req.Input
+		}
+		if msg.ToolUseID == "" {
+			msg.ToolUseID = req.ToolUseID
+		}
+	}
+
+	return msg, nil
+}
+
+// parseControlResponse handles a response to a control request sent by the SDK.
+func parseControlResponse(raw *rawMessage) (Message, error) {
+	var body controlBody
+	if len(raw.Response) > 0 {
+		if err := json.Unmarshal(raw.Response, &body); err != nil {
+			return nil, err
+		}
+	}
+	if body.RequestID == "" {
+		body.RequestID = raw.RequestID
+	}
+	return &controlResponseMsg{
+		RequestID: body.RequestID,
+		Subtype:   body.Subtype,
+		Response:  body.Response,
+		Error:     body.Error,
 	}, nil
 }
 
@@ -161,24 +375,26 @@ func (p *parser) parseSystemMessage(raw *rawMessage, meta MessageMeta) (Message,
 			meta.SessionID = raw.SessionID
 		}
 
+		init := &SystemInit{
+			MessageMeta:    meta,
+			TranscriptPath: raw.TranscriptPath,
+		}
+		if p.skipInitLists {
+			return init, nil
+		}
+
 		// Convert tool strings to ToolInfo
-		tools := make([]ToolInfo, len(raw.Tools))
+		init.Tools = make([]ToolInfo, len(raw.Tools))
 		for i, name := range raw.Tools {
-			tools[i] = ToolInfo{Name: name}
+			init.Tools[i] = ToolInfo{Name: name}
 		}
 
 		// Convert MCP servers
-		mcpServers := make([]MCPStatus, len(raw.MCPServers))
+		init.MCPServers = make([]MCPStatus, len(raw.MCPServers))
 		for i, srv := range raw.MCPServers {
-			mcpServers[i] = MCPStatus{Name: srv.Name, Status: srv.Status}
+			init.MCPServers[i] = MCPStatus{Name: srv.Name, Status: srv.Status}
 		}
-
-		return &SystemInit{
-			MessageMeta:    meta,
-			TranscriptPath: raw.TranscriptPath,
-			Tools:          tools,
-			MCPServers:     mcpServers,
-		}, nil
+		return init, nil
 
 	case "compact":
 		// Context window compaction event
@@ -211,22 +427,40 @@ func (p *parser) parseSystemMessage(raw *rawMessage, meta MessageMeta) (Message,
 
 // messageContent holds the parsed message structure.
 type messageContent struct {
+	ID      string         `json:"id,omitempty"`
 	Role    string         `json:"role"`
 	Content []contentBlock `json:"content"`
 }
 
+// assistantBody is the message object of an assistant line. It is decoded
+// with the rest of the line rather than kept as raw JSON and decoded again.
+// A message that is not an object with content blocks is kept as raw JSON.
+type assistantBody struct {
+	messageContent
+	set     bool   // The line had a message field
+	invalid []byte // The raw message, when it could not be decoded
+}
+
+func (b *assistantBody) UnmarshalJSON(data []byte) error {
+	b.set = true
+	if err := json.Unmarshal(data, &b.messageContent); err != nil {
+		b.messageContent = messageContent{}
+		b.invalid = append([]byte(nil), data...)
+	}
+	return nil
+}
+
 // parseAssistantMessages handles assistant-type messages with content blocks.
 // A single assistant message may contain multiple content blocks (e.g., thinking + text + tool_use).
 // The first block is returned directly; remaining blocks are buffered in p.pending.
 func (p *parser) parseAssistantMessages(raw *rawMessage, meta MessageMeta) (Message, error) {
-	// Parse the message wrapper
-	var msgContent messageContent
-	if len(raw.Message) > 0 {
-		if err := json.Unmarshal(raw.Message, &msgContent); err != nil {
+	msgContent := &raw.Message.messageContent
+	if raw.Message.set {
+		if raw.Message.invalid != nil {
 			// Fall back to raw content
 			return &Text{
 				MessageMeta: meta,
-				Text:        string(raw.Message),
+				Text:        string(raw.Message.invalid),
 			}, nil
 		}
 	} else if len(raw.Content) > 0 {
@@ -246,15 +480,29 @@ func (p *parser) parseAssistantMessages(raw *rawMessage, meta MessageMeta) (Mess
 		}, nil
 	}
 
+	// Drop blocks already emitted this turn. The CLI can repeat an assistant
+	// message, e.g. a full snapshot after partial output or a replay on resume.
+	blocks := p.dedupeBlocks(msgContent.ID, msgContent.Content)
+	if len(blocks) == 0 {
+		p.sequence = meta.Sequence - 1 // Give back the unused sequence number
+		return nil, nil
+	}
+
+	// Most lines hold one block; return it without building a slice
+	if len(blocks) == 1 {
+		return p.blockMessage(raw, blocks[0], meta), nil
+	}
+
 	// Convert all content blocks to messages
-	messages := make([]Message, 0, len(msgContent.Content))
-	for i, block := range msgContent.Content {
+	messages := make([]Message, 0, len(blocks))
+	for i, block := range blocks {
 		blockMeta := meta
 		if i > 0 {
 			// Additional blocks get their own sequence numbers
 			blockMeta = p.makeMeta()
+			blockMeta.UUID, blockMeta.ParentUUID = meta.UUID, meta.ParentUUID
 		}
-		messages = append(messages, p.contentBlockToMessage(block, blockMeta))
+		messages = append(messages, p.blockMessage(raw, block, blockMeta))
 	}
 
 	// Buffer remaining messages for subsequent next() calls
@@ -265,6 +513,61 @@ func (p *parser) parseAssistantMessages(raw *rawMessage, meta MessageMeta) (Mess
 	return messages[0], nil
 }
 
+// blockMessage converts a content block of an assistant line to a Message.
+func (p *parser) blockMessage(raw *rawMessage, block contentBlock, meta MessageMeta) Message {
+	msg := p.contentBlockToMessage(block, meta)
+	if tu, ok := msg.(*ToolUse); ok {
+		// Subagent messages carry the tool_use that spawned the subagent
+		tu.ParentToolUseID = raw.ParentToolUseID
+	}
+	return msg
+}
+
+// dedupeBlocks returns the blocks of a message that were not emitted by an
+// earlier line this turn. Blocks are identified by message ID and content
+// rather than position, because the CLI may send each block on its own line
+// and later repeat the whole message. Repeats within a single line are kept,
+// and messages without an ID are never deduplicated.
+func (p *parser) dedupeBlocks(messageID string, blocks []contentBlock) []contentBlock {
+	if messageID == "" {
+		return blocks
+	}
+	if p.seenBlocks == nil {
+		p.seenBlocks = make(map[string]struct{})
+	}
+
+	fresh := make([]contentBlock, 0, len(blocks))
+	keys := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		key := blockKey(messageID, block)
+		if _, seen := p.seenBlocks[key]; seen {
+			p.duplicates++
+			continue
+		}
+		keys = append(keys, key)
+		fresh = append(fresh, block)
+	}
+	for _, key := range keys {
+		p.seenBlocks[key] = struct{}{}
+	}
+	return fresh
+}
+
+// blockKey identifies a content block within a message.
+func blockKey(messageID string, block contentBlock) string {
+	switch block.Type {
+	case "tool_use":
+		return messageID + "/tool_use/" + block.ID
+	case "tool_result":
+		return messageID + "/tool_result/" + block.ToolUseID
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(block.Text)) // hash.Hash writes never fail
+	_, _ = h.Write([]byte(block.Thinking))
+	_, _ = h.Write([]byte(block.Signature))
+	return fmt.Sprintf("%s/%s/%x", messageID, block.Type, h.Sum64())
+}
+
 // contentBlockToMessage converts a single content block to a Message.
 func (p *parser) contentBlockToMessage(block contentBlock, meta MessageMeta) Message {
 	switch block.Type {
@@ -306,10 +609,10 @@ func (p *parser) contentBlockToMessage(block contentBlock, meta MessageMeta) Mes
 func (p *parser) parseResultMessage(raw *rawMessage, meta MessageMeta) (Message, error) {
 	p.turn++ // Result typically ends a turn
 
-	usage := Usage{}
-	if raw.Usage != nil {
-		usage = *raw.Usage
-	}
+	// Duplicate tracking is per turn
+	duplicates := p.duplicates
+	p.seenBlocks = nil
+	p.duplicates = 0
 
 	return &Result{
 		MessageMeta:   meta,
@@ -317,12 +620,22 @@ func (p *parser) parseResultMessage(raw *rawMessage, meta MessageMeta) (Message,
 		DurationAPI:   time.Duration(raw.DurationAPIMS * float64(time.Millisecond)),
 		NumTurns:      raw.NumTurns,
 		CostUSD:       raw.TotalCostUSD, // Use TotalCostUSD
-		Usage:         usage,
+		Usage:         raw.Usage.toUsage(),
 		ResultText:    raw.Result,
 		IsError:       raw.IsError,
+		duplicates:    duplicates,
 	}, nil
 }
 
+// ensureSessionID gives the session a synthetic ID when the conversation
+// starts without an init message, so its messages never carry an empty
+// SessionID. An init message that arrives later replaces it.
+func (p *parser) ensureSessionID() {
+	if p.sessionID == "" {
+		p.sessionID = newSyntheticSessionID()
+	}
+}
+
 // makeMeta creates a MessageMeta with current state.
 // It increments sequence for each message.
 func (p *parser) makeMeta() MessageMeta {
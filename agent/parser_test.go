@@ -1,7 +1,11 @@
 package agent
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -238,6 +242,122 @@ func TestParseEOF(t *testing.T) {
 	}
 }
 
+// longTextJSON returns an assistant text message whose line is at least n bytes.
+func longTextJSON(n int) string {
+	return `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"` +
+		strings.Repeat("x", n) + `"}]}}`
+}
+
+func TestParseLongLineDefaultUnlimited(t *testing.T) {
+	input := longTextJSON(5*1024*1024) + "\n" + textMessageJSON
+	p := newParser(strings.NewReader(input))
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	text, ok := msg.(*Text)
+	if !ok {
+		t.Fatalf("expected *Text, got %T", msg)
+	}
+	if len(text.Text) != 5*1024*1024 {
+		t.Errorf("len(Text) = %d, want %d", len(text.Text), 5*1024*1024)
+	}
+
+	msg, err = p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	if _, ok := msg.(*Text); !ok {
+		t.Fatalf("expected *Text after long line, got %T", msg)
+	}
+}
+
+func TestParseLongLineExceedsLimit(t *testing.T) {
+	long := longTextJSON(5 * 1024 * 1024)
+	input := long + "\n" + textMessageJSON
+	p := newParser(strings.NewReader(input))
+	p.maxLineBytes = 1024 * 1024
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	warn, ok := msg.(*ParseWarning)
+	if !ok {
+		t.Fatalf("expected *ParseWarning, got %T", msg)
+	}
+	if warn.Bytes != len(long)+1 {
+		t.Errorf("Bytes = %d, want %d", warn.Bytes, len(long)+1)
+	}
+
+	// Parsing continues with the next line
+	msg, err = p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	text, ok := msg.(*Text)
+	if !ok {
+		t.Fatalf("expected *Text after discarded line, got %T", msg)
+	}
+	if text.Text != "Hello, I'm Claude!" {
+		t.Errorf("Text = %q, want %q", text.Text, "Hello, I'm Claude!")
+	}
+}
+
+func TestParseLineAtLimit(t *testing.T) {
+	p := newParser(strings.NewReader(textMessageJSON + "\n"))
+	p.maxLineBytes = len(textMessageJSON)
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	if _, ok := msg.(*Text); !ok {
+		t.Fatalf("expected *Text for line at limit, got %T", msg)
+	}
+}
+
+func TestParseToolUseParentContext(t *testing.T) {
+	input := `{"type":"assistant","parent_tool_use_id":"toolu_task","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_sub","name":"Read","input":{}}]}}` + "\n" +
+		`{"type":"control","request_id":"req-1","tool_use_id":"toolu_sub","tool_name":"Read","parent_tool_use_id":"toolu_task","subagent_type":"Explore"}` + "\n" +
+		toolUseMessageJSON
+	p := newParser(strings.NewReader(input))
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	tu, ok := msg.(*ToolUse)
+	if !ok {
+		t.Fatalf("expected *ToolUse, got %T", msg)
+	}
+	if tu.ParentToolUseID != "toolu_task" {
+		t.Errorf("ParentToolUseID = %q, want %q", tu.ParentToolUseID, "toolu_task")
+	}
+
+	msg, err = p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	req, ok := msg.(*ControlRequestMsg)
+	if !ok {
+		t.Fatalf("expected *ControlRequestMsg, got %T", msg)
+	}
+	if req.ParentToolUseID != "toolu_task" || req.SubagentType != "Explore" {
+		t.Errorf("control request parent = %q/%q, want toolu_task/Explore", req.ParentToolUseID, req.SubagentType)
+	}
+
+	// Main agent tool calls have no parent
+	msg, err = p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	if tu := msg.(*ToolUse); tu.ParentToolUseID != "" {
+		t.Errorf("ParentToolUseID = %q, want empty", tu.ParentToolUseID)
+	}
+}
+
 func TestMessageMetaPopulation(t *testing.T) {
 	input := systemInitJSON + "\n" + textMessageJSON
 	p := newParser(strings.NewReader(input))
@@ -272,6 +392,10 @@ func TestMessageMetaPopulation(t *testing.T) {
 	if text.Sequence != 2 {
 		t.Errorf("second message Sequence = %d, want 2", text.Sequence)
 	}
+	// Only an Agent numbers the messages it delivers
+	if text.DeliveredSequence != 0 {
+		t.Errorf("parsed message DeliveredSequence = %d, want 0", text.DeliveredSequence)
+	}
 }
 
 func TestParseMultipleMessages(t *testing.T) {
@@ -599,3 +723,202 @@ func TestParseSingleBlockAssistantStillWorks(t *testing.T) {
 		t.Errorf("expected io.EOF, got %v", err)
 	}
 }
+
+func TestParseResultSnakeCaseUsage(t *testing.T) {
+	line := `{"type":"result","result":"ok","usage":{"input_tokens":120,"output_tokens":30,"cache_read_input_tokens":7,"cache_creation_input_tokens":3}}`
+	p := newParser(strings.NewReader(line))
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	result, ok := msg.(*Result)
+	if !ok {
+		t.Fatalf("expected *Result, got %T", msg)
+	}
+
+	want := Usage{InputTokens: 120, OutputTokens: 30, CacheRead: 7, CacheWrite: 3}
+	if result.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", result.Usage, want)
+	}
+}
+
+func TestParseSuppressesDuplicateAssistantContent(t *testing.T) {
+	// Fixture follows the CLI pattern of one line per content block followed
+	// by a full snapshot of the same message, plus repeated single lines.
+	fixture, err := os.ReadFile("testdata/duplicate_assistant.jsonl")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	p := newParser(bytes.NewReader(fixture))
+
+	var got []Message
+	for {
+		msg, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		got = append(got, msg)
+	}
+
+	want := []string{"*agent.SystemInit", "*agent.Thinking", "*agent.Text", "*agent.ToolUse", "*agent.ToolResult", "*agent.Text", "*agent.Result"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d: %v", len(got), len(want), got)
+	}
+	for i, msg := range got {
+		if typ := fmt.Sprintf("%T", msg); typ != want[i] {
+			t.Errorf("message %d = %s, want %s", i, typ, want[i])
+		}
+		meta := reflect.ValueOf(msg).Elem().FieldByName("MessageMeta").Interface().(MessageMeta)
+		if seq := meta.Sequence; seq != i+1 {
+			t.Errorf("message %d Sequence = %d, want %d", i, seq, i+1)
+		}
+	}
+
+	result := got[len(got)-1].(*Result)
+	if result.duplicates != 6 {
+		t.Errorf("duplicates = %d, want 6", result.duplicates)
+	}
+}
+
+func TestParseDuplicateTrackingResetsPerTurn(t *testing.T) {
+	line := `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"same"}]}}`
+	input := line + "\n" + `{"type":"result","result":"ok"}` + "\n" + line
+	p := newParser(strings.NewReader(input))
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.next(); err != nil {
+			t.Fatalf("next() %d error = %v, want content repeated in a new turn to be kept", i, err)
+		}
+	}
+}
+
+func TestParseKeepsRepeatedBlocksWithinMessage(t *testing.T) {
+	input := `{"type":"assistant","message":{"id":"msg_1","role":"assistant","content":[{"type":"text","text":"again"},{"type":"text","text":"again"}]}}`
+	p := newParser(strings.NewReader(input))
+
+	for i := 0; i < 2; i++ {
+		msg, err := p.next()
+		if err != nil {
+			t.Fatalf("next() %d error = %v", i, err)
+		}
+		if _, ok := msg.(*Text); !ok {
+			t.Fatalf("next() %d = %T, want *Text", i, msg)
+		}
+	}
+}
+
+func TestParseReusedBuffersDoNotLeak(t *testing.T) {
+	// A line longer than the reader's buffer, between short lines, and
+	// fields that a later line leaves out
+	long := strings.Repeat("x", 100*1024)
+	input := strings.Join([]string{
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"Bash","input":{"command":"ls"}},{"type":"thinking","thinking":"hmm","signature":"sig"}]}}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"t2","name":"Read","input":{"file_path":"/a"}}]}}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"` + long + `"}]}}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"again"}]}}`,
+		`{"type":"assistant","message":"not an object"}`,
+	}, "\n")
+
+	p := newParser(strings.NewReader(input))
+	var msgs []Message
+	for {
+		msg, err := p.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 6 {
+		t.Fatalf("got %d messages, want 6", len(msgs))
+	}
+
+	if got := msgs[0].(*ToolUse).Input; !reflect.DeepEqual(got, map[string]any{"command": "ls"}) {
+		t.Errorf("first Input = %v, want it unchanged by later lines", got)
+	}
+	if got := msgs[2].(*ToolUse).Input; !reflect.DeepEqual(got, map[string]any{"file_path": "/a"}) {
+		t.Errorf("second Input = %v, want only its own fields", got)
+	}
+	if got := msgs[3].(*Text).Text; got != long {
+		t.Errorf("long text has %d bytes, want %d", len(got), len(long))
+	}
+	if th := msgs[4].(*Thinking); th.Thinking != "again" || th.Signature != "" {
+		t.Errorf("Thinking = %+v, want no signature carried over from the first line", th)
+	}
+	if got := msgs[5].(*Text).Text; got != `"not an object"` {
+		t.Errorf("Text = %q, want the raw message", got)
+	}
+}
+
+func TestParseSkipInitLists(t *testing.T) {
+	p := newParser(strings.NewReader(systemInitJSON))
+	p.skipInitLists = true
+
+	msg, err := p.next()
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	init := msg.(*SystemInit)
+	if init.SessionID != "sess-abc123" || init.TranscriptPath != "/tmp/transcript.jsonl" {
+		t.Errorf("SystemInit = %+v, want the session and transcript", init)
+	}
+	if init.Tools != nil || init.MCPServers != nil {
+		t.Errorf("SystemInit lists = %v, %v, want them skipped", init.Tools, init.MCPServers)
+	}
+}
+
+// repeatReader returns data over and over, so benchmarks can parse an
+// endless stream without allocating input.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+// benchmarkParse parses b.N messages from lines repeated endlessly.
+func benchmarkParse(b *testing.B, lines ...string) {
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	p := newParser(&repeatReader{data: data})
+	b.SetBytes(int64(len(data) / len(lines)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseText(b *testing.B) {
+	benchmarkParse(b, `{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Reading the parser to find where lines are split."}]},"parent_tool_use_id":null,"session_id":"sess-bench"}`)
+}
+
+func BenchmarkParseToolResultLarge(b *testing.B) {
+	output := strings.Repeat(`ok  \tgithub.com/example/pkg\t0.012s\n`, 2000) // About 70 KB of tool output
+	benchmarkParse(b, `{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"`+output+`","is_error":false}]},"session_id":"sess-bench"}`)
+}
+
+func BenchmarkStreamThroughput(b *testing.B) {
+	// A turn as a busy agent produces it, without message IDs so that
+	// duplicate suppression does not drop the repeats
+	benchmarkParse(b,
+		`{"type":"system","subtype":"init","session_id":"sess-bench","tools":["Bash","Read","Edit"],"mcp_servers":[{"name":"github","status":"connected"}]}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"The test fails on long lines.","signature":"c2lnbmF0dXJl"}]},"session_id":"sess-bench"}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Let me run the tests."}]},"session_id":"sess-bench"}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"command":"go test ./...","timeout":120000}}]},"session_id":"sess-bench"}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"`+strings.Repeat(`--- PASS: TestParse (0.00s)\n`, 40)+`"}]},"session_id":"sess-bench"}`,
+		`{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"All tests pass."}]},"session_id":"sess-bench"}`,
+		`{"type":"result","subtype":"success","duration_ms":5120,"duration_api_ms":4100,"num_turns":2,"total_cost_usd":0.0123,"is_error":false,"result":"All tests pass.","usage":{"input_tokens":1200,"output_tokens":80,"cache_read_input_tokens":900}}`,
+	)
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Pipeline runs agents in sequence, feeding each stage's structured output
+// into the prompt of the next. Build one with NewPipeline and AddStage, then
+// call Run. A Pipeline may be run more than once but not concurrently.
+//
+// Example:
+//
+//	var facts Facts
+//	var report Report
+//	result, err := agent.NewPipeline().
+//	    AddStage("extract", extractor, "Extract the facts from {{.}}", &facts).
+//	    AddStage("report", writer, "Write a report on these facts:\n{{json .}}", &report).
+//	    Run(ctx, document)
+type Pipeline struct {
+	stages []*pipelineStage
+}
+
+// pipelineStage is one step of a Pipeline.
+type pipelineStage struct {
+	name   string
+	agent  *Agent
+	prompt string             // Used verbatim when tmpl is nil
+	tmpl   *template.Template // Executed with the previous output as dot
+	err    error              // Template parse error, reported by Run
+	out    any                // Pointer receiving the structured output, or nil
+}
+
+// PipelineResult aggregates the results of a pipeline's stages.
+type PipelineResult struct {
+	// PipelineID identifies the pipeline run. It is the pipeline_id label
+	// on the stages' audit events.
+	PipelineID   string
+	Stages       []StageResult // Stages that ran, in order, including a failed one
+	TotalCostUSD float64
+	NumTurns     int
+	Duration     time.Duration
+}
+
+// StageResult is the outcome of one pipeline stage.
+type StageResult struct {
+	Name     string
+	Prompt   string  // Prompt sent to the stage, after template execution
+	Result   *Result // Nil if the stage failed before Claude answered
+	Duration time.Duration
+}
+
+// pipelineFuncs are available in stage prompt templates.
+var pipelineFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		return string(data), err
+	},
+}
+
+// NewPipeline returns an empty pipeline.
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// AddStage appends a stage that runs a on a prompt built from
+// promptTemplate, a text/template executed with the previous stage's output
+// as dot: the value out points to, or the response text for a stage whose
+// out is nil. The first stage's template receives the input given to Run.
+// Templates can call json to embed a value as indented JSON.
+//
+// The stage runs with RunWithSchema, so out must point to the type given to
+// a's WithSchema. A nil out passes the response text to the next stage.
+// Template errors are reported by Run.
+func (p *Pipeline) AddStage(name string, a *Agent, promptTemplate string, out any) *Pipeline {
+	stage := &pipelineStage{name: name, agent: a, out: out}
+	stage.tmpl, stage.err = template.New(name).Funcs(pipelineFuncs).Option("missingkey=error").Parse(promptTemplate)
+	p.stages = append(p.stages, stage)
+	return p
+}
+
+// addPrompt appends a stage whose prompt is used verbatim.
+func (p *Pipeline) addPrompt(name string, a *Agent, prompt string, out any) *Pipeline {
+	p.stages = append(p.stages, &pipelineStage{name: name, agent: a, prompt: prompt, out: out})
+	return p
+}
+
+// Run runs the stages in order, starting with input as the first stage's
+// template data. Each stage's audit events carry the pipeline_id and
+// pipeline_stage labels, and each stage's agent emits a pipeline.stage
+// event when the stage ends.
+//
+// The first stage to fail stops the pipeline with a *PipelineError naming
+// it. The returned PipelineResult is never nil and includes every stage
+// that ran.
+func (p *Pipeline) Run(ctx context.Context, input any) (*PipelineResult, error) {
+	start := time.Now()
+	result := &PipelineResult{PipelineID: newRunID()}
+	defer func() { result.Duration = time.Since(start) }()
+
+	data := input
+	for i, stage := range p.stages {
+		sr, next, err := stage.run(ctx, result.PipelineID, i+1, data)
+		if sr.Result != nil {
+			result.TotalCostUSD += sr.Result.CostUSD
+			result.NumTurns += sr.Result.NumTurns
+		}
+		result.Stages = append(result.Stages, sr)
+		if err != nil {
+			return result, &PipelineError{PipelineID: result.PipelineID, Stage: i + 1, Name: stage.name, Err: err}
+		}
+		data = next
+	}
+	return result, nil
+}
+
+// run executes the stage with data as template input. It returns the
+// stage's result and the data for the next stage.
+func (s *pipelineStage) run(ctx context.Context, pipelineID string, index int, data any) (StageResult, any, error) {
+	sr := StageResult{Name: s.name}
+
+	switch {
+	case s.err != nil:
+		return sr, nil, s.err
+	case s.agent == nil:
+		return sr, nil, errors.New("stage has no agent")
+	case s.out != nil && s.agent.cfg.jsonSchema == "":
+		return sr, nil, &SchemaError{
+			Type:   reflect.TypeOf(s.out).String(),
+			Reason: "stage output requires an agent created with WithSchema",
+		}
+	}
+
+	sr.Prompt = s.prompt
+	if s.tmpl != nil {
+		var b strings.Builder
+		if err := s.tmpl.Execute(&b, data); err != nil {
+			return sr, nil, err
+		}
+		sr.Prompt = b.String()
+	}
+
+	label := s.name
+	if label == "" {
+		label = strconv.Itoa(index)
+	}
+	labels := map[string]string{"pipeline_id": pipelineID, "pipeline_stage": label}
+
+	start := time.Now()
+	result, err := s.agent.RunWithSchema(ctx, sr.Prompt, s.out, withRunLabels(labels))
+	sr.Result = result
+	sr.Duration = time.Since(start)
+	s.agent.emitPipelineStage(pipelineID, index, s.name, sr, err)
+	if err != nil {
+		return sr, nil, err
+	}
+
+	if s.out == nil {
+		return sr, result.ResultText, nil
+	}
+	return sr, reflect.ValueOf(s.out).Elem().Interface(), nil
+}
+
+// emitPipelineStage emits a pipeline.stage audit event for a finished stage.
+func (a *Agent) emitPipelineStage(pipelineID string, index int, name string, sr StageResult, err error) {
+	data := map[string]any{
+		"pipeline_id": pipelineID,
+		"stage":       index,
+		"name":        name,
+		"duration":    sr.Duration.String(),
+	}
+	if sr.Result != nil {
+		data["cost_usd"] = sr.Result.CostUSD
+		data["run_id"] = sr.Result.RunID
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+
+	a.mu.Lock()
+	sessionID := a.sessionID
+	a.mu.Unlock()
+	a.auditor.emit(sessionID, "pipeline.stage", data)
+}
+
+// withRunLabels adds labels to the audit events of a single run.
+func withRunLabels(labels map[string]string) RunOption {
+	return func(rc *runConfig) {
+		rc.labels = labels
+	}
+}
+
+// Pipe runs a two-stage pipeline: stage1 answers prompt1 with a T, which
+// becomes dot in promptTemplate for stage2, which answers with a U. Both
+// agents must be created with WithSchema for their types. Pipe is
+// shorthand for a Pipeline whose first prompt is used verbatim. On error
+// the returned U is the zero value.
+//
+// Example:
+//
+//	report, result, err := agent.Pipe[Findings, Report](ctx,
+//	    analyzer, "Analyze the logs in ./logs",
+//	    writer, "Summarize these findings for the on-call engineer:\n{{json .}}")
+func Pipe[T, U any](ctx context.Context, stage1 *Agent, prompt1 string, stage2 *Agent, promptTemplate string) (U, *PipelineResult, error) {
+	var mid T
+	var out U
+	result, err := NewPipeline().
+		addPrompt("", stage1, prompt1, &mid).
+		AddStage("", stage2, promptTemplate, &out).
+		Run(ctx, nil)
+	if err != nil {
+		var zero U
+		return zero, result, err
+	}
+	return out, result, nil
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type pipelineFacts struct {
+	Service string `json:"service"`
+	Errors  int    `json:"errors"`
+}
+
+type pipelineReport struct {
+	Summary  string `json:"summary"`
+	Severity string `json:"severity"`
+}
+
+// pipelineCLI writes a fake CLI that saves the prompt line to a file and
+// answers with result, a JSON string literal, at the given cost.
+func pipelineCLI(t *testing.T, result, cost string) (cli, promptFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	cli = filepath.Join(dir, "claude")
+	promptFile = filepath.Join(dir, "prompt.json")
+	script := `#!/bin/sh
+read -r line
+printf '%s\n' "$line" > ` + promptFile + `
+echo '{"type":"system","subtype":"init","session_id":"pipeline-test"}'
+echo '{"type":"result","result":` + result + `,"num_turns":2,"total_cost_usd":` + cost + `}'
+`
+	mustWriteFile(t, cli, []byte(script), 0755)
+	return cli, promptFile
+}
+
+// pipelineAgent creates an agent for a pipeline stage and closes it when
+// the test ends.
+func pipelineAgent(t *testing.T, opts ...Option) *Agent {
+	t.Helper()
+	a, err := New(context.Background(), opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { _ = a.Close() })
+	return a
+}
+
+func TestPipe(t *testing.T) {
+	cli1, _ := pipelineCLI(t, `"{\"service\":\"api\",\"errors\":3}"`, "0.25")
+	cli2, prompt2 := pipelineCLI(t, `"{\"summary\":\"api failing\",\"severity\":\"high\"}"`, "0.5")
+
+	var mu sync.Mutex
+	var events []AuditEvent
+	record := Audit(func(e AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+
+	ctx := context.Background()
+	extract := pipelineAgent(t, CLIPath(cli1), WithSchema(pipelineFacts{}), record)
+	report := pipelineAgent(t, CLIPath(cli2), WithSchema(pipelineReport{}), record)
+
+	got, result, err := Pipe[pipelineFacts, pipelineReport](ctx,
+		extract, "Read the {{logs}}",
+		report, "Service {{.Service}} logged {{.Errors}} errors:\n{{json .}}")
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	if got != (pipelineReport{Summary: "api failing", Severity: "high"}) {
+		t.Errorf("Pipe() = %+v", got)
+	}
+
+	// Stage 2's prompt is the template executed with stage 1's output
+	wantPrompt := "Service api logged 3 errors:\n{\n  \"service\": \"api\",\n  \"errors\": 3\n}"
+	if len(result.Stages) != 2 || result.Stages[0].Prompt != "Read the {{logs}}" || result.Stages[1].Prompt != wantPrompt {
+		t.Fatalf("Stages = %+v, want the literal first prompt and the templated second", result.Stages)
+	}
+	if sent := string(mustReadFile(t, prompt2)); !strings.Contains(sent, `"text":"Service api logged 3 errors:\n{\n`) {
+		t.Errorf("stage 2 CLI received %s", sent)
+	}
+	if result.TotalCostUSD != 0.75 || result.NumTurns != 4 {
+		t.Errorf("TotalCostUSD = %v, NumTurns = %d; want 0.75 and 4", result.TotalCostUSD, result.NumTurns)
+	}
+
+	// Every event of each stage's run carries the pipeline labels
+	mu.Lock()
+	defer mu.Unlock()
+	stages := map[string]bool{}
+	for _, e := range events {
+		switch {
+		case e.Type == "pipeline.stage":
+			data := e.Data.(map[string]any)
+			if data["pipeline_id"] != result.PipelineID {
+				t.Errorf("pipeline.stage pipeline_id = %v, want %s", data["pipeline_id"], result.PipelineID)
+			}
+		case e.RunID != "":
+			if e.Labels["pipeline_id"] != result.PipelineID {
+				t.Errorf("%s event labels = %v, want pipeline_id %s", e.Type, e.Labels, result.PipelineID)
+			}
+			stages[e.Labels["pipeline_stage"]] = true
+		case e.Labels["pipeline_id"] != "":
+			t.Errorf("%s event outside a run has pipeline labels %v", e.Type, e.Labels)
+		}
+	}
+	if !stages["1"] || !stages["2"] {
+		t.Errorf("pipeline_stage labels seen = %v, want 1 and 2", stages)
+	}
+}
+
+func TestPipelineStageSchemaFailure(t *testing.T) {
+	cli1, _ := pipelineCLI(t, `"{\"service\":\"api\",\"errors\":3}"`, "0.25")
+	cli2, _ := pipelineCLI(t, `"The api service is failing."`, "0.5")
+
+	ctx := context.Background()
+	extract := pipelineAgent(t, CLIPath(cli1), WithSchema(pipelineFacts{}))
+	report := pipelineAgent(t, CLIPath(cli2), WithSchema(pipelineReport{}))
+
+	var facts pipelineFacts
+	var rep pipelineReport
+	result, err := NewPipeline().
+		AddStage("extract", extract, "Extract facts from {{.}}", &facts).
+		AddStage("report", report, "Report on {{json .}}", &rep).
+		Run(ctx, "app.log")
+
+	var pipeErr *PipelineError
+	if !errors.As(err, &pipeErr) {
+		t.Fatalf("Run() error = %v, want *PipelineError", err)
+	}
+	if pipeErr.Stage != 2 || pipeErr.Name != "report" || pipeErr.PipelineID != result.PipelineID {
+		t.Errorf("PipelineError = %+v, want stage 2 (report)", pipeErr)
+	}
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("Run() error = %v, want it to wrap *SchemaError", err)
+	}
+	if !strings.HasPrefix(err.Error(), "agent: pipeline stage 2 (report) failed: ") {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	// The failed stage's cost still counts
+	if len(result.Stages) != 2 || result.Stages[1].Result == nil || result.TotalCostUSD != 0.75 {
+		t.Errorf("result = %+v, want both stages and cost 0.75", result)
+	}
+	if facts.Service != "api" || result.Stages[0].Prompt != "Extract facts from app.log" {
+		t.Errorf("stage 1 = %+v with prompt %q", facts, result.Stages[0].Prompt)
+	}
+}
+
+func TestPipelineStageErrorsBeforeRun(t *testing.T) {
+	cli, _ := pipelineCLI(t, `"plain text"`, "0")
+	plain := pipelineAgent(t, CLIPath(cli))
+
+	var facts pipelineFacts
+	tests := []struct {
+		name     string
+		pipeline *Pipeline
+	}{
+		{"bad template", NewPipeline().AddStage("a", plain, "{{.Missing", nil)},
+		{"missing field", NewPipeline().AddStage("a", plain, "{{.Missing}}", nil)},
+		{"no schema", NewPipeline().AddStage("a", plain, "go", &facts)},
+		{"no agent", NewPipeline().AddStage("a", nil, "go", nil)},
+	}
+	for _, tt := range tests {
+		result, err := tt.pipeline.Run(context.Background(), pipelineFacts{})
+		var pipeErr *PipelineError
+		if !errors.As(err, &pipeErr) || pipeErr.Stage != 1 {
+			t.Errorf("%s: Run() error = %v, want *PipelineError for stage 1", tt.name, err)
+			continue
+		}
+		if len(result.Stages) != 1 || result.Stages[0].Result != nil {
+			t.Errorf("%s: Stages = %+v, want one stage without a result", tt.name, result.Stages)
+		}
+	}
+}
+
+func TestPipelineTextStage(t *testing.T) {
+	cli1, _ := pipelineCLI(t, `"three errors in api"`, "0")
+	cli2, _ := pipelineCLI(t, `"{\"summary\":\"ok\",\"severity\":\"low\"}"`, "0")
+	summarize := pipelineAgent(t, CLIPath(cli1))
+	report := pipelineAgent(t, CLIPath(cli2), WithSchema(pipelineReport{}))
+
+	var rep pipelineReport
+	result, err := NewPipeline().
+		AddStage("summarize", summarize, "Summarize {{.}}", nil).
+		AddStage("report", report, "Rate: {{.}}", &rep).
+		Run(context.Background(), "app.log")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Stages[1].Prompt != "Rate: three errors in api" || rep.Severity != "low" {
+		t.Errorf("stage 2 prompt = %q, report = %+v", result.Stages[1].Prompt, rep)
+	}
+}
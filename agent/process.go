@@ -10,10 +10,60 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 )
 
+// cliTransport carries stream-json lines to and from the CLI. It is
+// implemented by the CLI process, by replays of recorded sessions, and by
+// the recorder that wraps either.
+type cliTransport interface {
+	write(data []byte) error
+	reader() io.Reader
+	close() error
+	exitStatus(d time.Duration) (code int, stderr string, exited bool)
+}
+
+// startTransport starts the CLI, or a replay if Replay is set, and records
+// the session if RecordCLI is set.
+//
+// MCPManaged servers are started before the CLI and stopped when it is
+// closed; a replay starts none.
+func startTransport(ctx context.Context, cfg *config, mcp *managedMCP) (cliTransport, error) {
+	var t cliTransport
+	if cfg.replay != nil {
+		r, err := newReplayer(cfg.replay)
+		if err != nil {
+			return nil, err
+		}
+		t = r
+	} else {
+		pcfg, err := mcp.start(cfg)
+		if err != nil {
+			return nil, err
+		}
+		p, err := startProcess(ctx, pcfg)
+		if err != nil {
+			mcp.stop()
+			return nil, err
+		}
+		p.mcp = mcp
+		t = p
+	}
+
+	if cfg.recordPath != "" {
+		rec, err := newRecorder(t, cfg.recordPath, cfg.thinkingMode)
+		if err != nil {
+			_ = t.close() // Best-effort cleanup
+			return nil, err
+		}
+		t = rec
+	}
+	if cfg.debugWire != nil {
+		t = newWireTransport(t, cfg.debugWire, newScrubber(cfg.scrubPatterns), cfg.thinkingMode)
+	}
+	return t, nil
+}
+
 // process manages the Claude Code CLI subprocess.
 type process struct {
 	cmd     *exec.Cmd
@@ -22,6 +72,8 @@ type process struct {
 	stderr  bytes.Buffer
 	done    chan struct{}
 	exitErr error
+	cleanup func() error // Removes files written for the process (e.g. native skills)
+	mcp     *managedMCP  // MCP servers run by the SDK, stopped after the process
 	mu      sync.Mutex
 }
 
@@ -53,6 +105,9 @@ func findCLI() (string, error) {
 func startProcess(ctx context.Context, cfg *config) (*process, error) {
 	// Find CLI path
 	cliPath := cfg.cliPath
+	if cliPath == "" {
+		cliPath = cfg.resolvedCLI
+	}
 	if cliPath == "" {
 		var err error
 		cliPath, err = findCLI()
@@ -61,7 +116,97 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 		}
 	}
 
-	// Build command arguments
+	args, skillCleanup, err := applySkills(cfg, buildArgs(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, cliPath, args...) // #nosec G204 -- CLI path is validated in New()
+	cmd.Dir = cfg.workDir
+
+	// Create a new process group so we can kill all child processes
+	setProcessGroup(cmd)
+
+	// Environment variables - start with current environment, then add/override
+	cmd.Env = processEnv(cfg)
+
+	// Create pipes
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		_ = skillCleanup() // Best-effort cleanup
+		return nil, &StartError{Reason: "failed to create stdin pipe", Cause: err}
+	}
+
+	// Use an explicit pipe rather than cmd.StdoutPipe: Wait closes the read
+	// end of StdoutPipe as soon as the process exits, which would discard
+	// output the parser has not consumed yet. With os.Pipe the reader sees
+	// EOF only after all buffered output has been read.
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		_ = stdin.Close()  // Best-effort cleanup
+		_ = skillCleanup() // Best-effort cleanup
+		return nil, &StartError{Reason: "failed to create stdout pipe", Cause: err}
+	}
+	cmd.Stdout = stdoutW
+
+	p := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  stdout,
+		done:    make(chan struct{}),
+		cleanup: skillCleanup,
+	}
+
+	// Capture stderr
+	cmd.Stderr = &p.stderr
+
+	// Start the process
+	if err := cmd.Start(); err != nil {
+		_ = stdin.Close()   // Best-effort cleanup
+		_ = stdout.Close()  // Best-effort cleanup
+		_ = stdoutW.Close() // Best-effort cleanup
+		_ = skillCleanup()  // Best-effort cleanup
+		return nil, &StartError{Reason: "failed to start claude CLI", Cause: err}
+	}
+
+	// The child holds its own copy of the write end
+	_ = stdoutW.Close() // Best-effort; parent no longer writes to stdout
+
+	// Launch goroutine to wait for exit
+	go func() {
+		p.exitErr = cmd.Wait()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// mcpServerJSON returns the --mcp-config entry for an MCP server.
+func mcpServerJSON(mcp *MCPConfig) map[string]any {
+	var mcpJSON map[string]any
+	switch mcp.Transport {
+	case "stdio", "":
+		mcpJSON = map[string]any{
+			"command": mcp.Command,
+			"args":    mcp.Args,
+		}
+		if len(mcp.Env) > 0 {
+			mcpJSON["env"] = mcp.Env
+		}
+	case "sse", "http":
+		mcpJSON = map[string]any{
+			"type": mcp.Transport,
+			"url":  mcp.URL,
+		}
+		if len(mcp.Headers) > 0 {
+			mcpJSON["headers"] = mcp.Headers
+		}
+	}
+	return mcpJSON
+}
+
+// buildArgs returns the CLI arguments for cfg, before skills are applied.
+func buildArgs(cfg *config) []string {
 	args := []string{
 		"--print", "-",
 		"--output-format", "stream-json",
@@ -72,14 +217,17 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 	}
 
 	// Tool configuration
-	if len(cfg.tools) > 0 {
+	if cfg.noTools {
+		args = append(args, "--tools", "")
+	} else if len(cfg.tools) > 0 {
 		args = append(args, "--tools", strings.Join(cfg.tools, ","))
 	}
-	if len(cfg.allowedTools) > 0 {
-		args = append(args, "--allowedTools", strings.Join(cfg.allowedTools, ","))
+	mcpAllowed, mcpDisallowed := mcpToolFlags(cfg)
+	if allowed := append(cfg.allowedTools[:len(cfg.allowedTools):len(cfg.allowedTools)], mcpAllowed...); len(allowed) > 0 {
+		args = append(args, "--allowedTools", strings.Join(allowed, ","))
 	}
-	if len(cfg.disallowedTools) > 0 {
-		args = append(args, "--disallowedTools", strings.Join(cfg.disallowedTools, ","))
+	if disallowed := append(cfg.disallowedTools[:len(cfg.disallowedTools):len(cfg.disallowedTools)], mcpDisallowed...); len(disallowed) > 0 {
+		args = append(args, "--disallowedTools", strings.Join(disallowed, ","))
 	}
 
 	// Permission mode
@@ -93,6 +241,12 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 			args = append(args, "--add-dir", dir)
 		}
 	}
+	if cfg.worktreePath != "" {
+		args = append(args, "--add-dir", cfg.worktreePath)
+	}
+	if cfg.scratchPath != "" {
+		args = append(args, "--add-dir", cfg.scratchPath)
+	}
 
 	// Setting sources
 	if len(cfg.settingSources) > 0 {
@@ -108,34 +262,22 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 	}
 
 	// Structured output
-	if cfg.jsonSchema != "" {
+	if cfg.jsonSchema != "" && !cfg.schemaFallback {
 		args = append(args, "--json-schema", cfg.jsonSchema)
 	}
 
-	// MCP server configuration
+	// MCP server configuration. Running MCPManaged servers are in a file,
+	// so their bridge tokens are not on the command line.
 	for name, mcp := range cfg.mcpServers {
-		var mcpJSON map[string]any
-		switch mcp.Transport {
-		case "stdio", "":
-			mcpJSON = map[string]any{
-				"command": mcp.Command,
-				"args":    mcp.Args,
-			}
-			if len(mcp.Env) > 0 {
-				mcpJSON["env"] = mcp.Env
-			}
-		case "sse", "http":
-			mcpJSON = map[string]any{
-				"type": mcp.Transport,
-				"url":  mcp.URL,
-			}
-			if len(mcp.Headers) > 0 {
-				mcpJSON["headers"] = mcp.Headers
-			}
+		if mcp.Managed && cfg.mcpConfigFile != "" {
+			continue
 		}
-		jsonBytes, _ := json.Marshal(map[string]any{name: mcpJSON})
+		jsonBytes, _ := json.Marshal(map[string]any{name: mcpServerJSON(mcp)})
 		args = append(args, "--mcp-config", string(jsonBytes))
 	}
+	if cfg.mcpConfigFile != "" {
+		args = append(args, "--mcp-config", cfg.mcpConfigFile)
+	}
 
 	// Strict MCP config
 	if cfg.strictMCPConfig {
@@ -150,52 +292,6 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 		args = append(args, "--append-system-prompt", cfg.systemPromptAppend)
 	}
 
-	// Load skills from directories
-	allSkills := make(map[string]*SkillConfig)
-	for name, skill := range cfg.skills {
-		allSkills[name] = skill
-	}
-	for _, dir := range cfg.skillDirs {
-		dirSkills, err := loadSkillsFromDir(dir)
-		if err != nil {
-			return nil, &StartError{Reason: "failed to load skills from " + dir, Cause: err}
-		}
-		for name, skill := range dirSkills {
-			allSkills[name] = skill
-		}
-	}
-	// Note: Skills are typically loaded into context via system prompt or config
-	// The CLI may support --skill flag or require skills in a config file
-	// For now, we append skill content to system prompt if present
-	if len(allSkills) > 0 && cfg.systemPromptAppend == "" {
-		var skillContent strings.Builder
-		for name, skill := range allSkills {
-			skillContent.WriteString("\n\n## Skill: ")
-			skillContent.WriteString(name)
-			skillContent.WriteString("\n")
-			skillContent.WriteString(skill.Content)
-		}
-		args = append(args, "--append-system-prompt", skillContent.String())
-	} else if len(allSkills) > 0 {
-		// Skills were requested but systemPromptAppend is already set
-		// Combine them
-		var skillContent strings.Builder
-		skillContent.WriteString(cfg.systemPromptAppend)
-		for name, skill := range allSkills {
-			skillContent.WriteString("\n\n## Skill: ")
-			skillContent.WriteString(name)
-			skillContent.WriteString("\n")
-			skillContent.WriteString(skill.Content)
-		}
-		// Find and replace the existing --append-system-prompt arg
-		for i, arg := range args {
-			if arg == "--append-system-prompt" && i+1 < len(args) {
-				args[i+1] = skillContent.String()
-				break
-			}
-		}
-	}
-
 	// Subagent configuration
 	// Note: Subagents are typically defined via Task tool configuration
 	// The exact CLI flag depends on Claude Code's implementation
@@ -219,56 +315,7 @@ func startProcess(ctx context.Context, cfg *config) (*process, error) {
 		args = append(args, "--subagent", string(jsonBytes))
 	}
 
-	cmd := exec.CommandContext(ctx, cliPath, args...) // #nosec G204 -- CLI path is validated in New()
-	cmd.Dir = cfg.workDir
-
-	// Create a new process group so we can kill all child processes
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	// Environment variables - start with current environment, then add/override
-	if len(cfg.env) > 0 {
-		cmd.Env = os.Environ()
-		for k, v := range cfg.env {
-			cmd.Env = append(cmd.Env, k+"="+v)
-		}
-	}
-
-	// Create pipes
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, &StartError{Reason: "failed to create stdin pipe", Cause: err}
-	}
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		_ = stdin.Close() // Best-effort cleanup
-		return nil, &StartError{Reason: "failed to create stdout pipe", Cause: err}
-	}
-
-	p := &process{
-		cmd:    cmd,
-		stdin:  stdin,
-		stdout: stdout,
-		done:   make(chan struct{}),
-	}
-
-	// Capture stderr
-	cmd.Stderr = &p.stderr
-
-	// Start the process
-	if err := cmd.Start(); err != nil {
-		_ = stdin.Close()  // Best-effort cleanup
-		_ = stdout.Close() // Best-effort cleanup
-		return nil, &StartError{Reason: "failed to start claude CLI", Cause: err}
-	}
-
-	// Launch goroutine to wait for exit
-	go func() {
-		p.exitErr = cmd.Wait()
-		close(p.done)
-	}()
-
-	return p, nil
+	return args
 }
 
 // write sends data to the process stdin.
@@ -306,8 +353,7 @@ func (p *process) close() error {
 		// This is necessary because shell scripts may spawn child processes (like sleep)
 		// that would continue running and keep pipes open if we only killed the parent.
 		if p.cmd.Process != nil {
-			// Kill process group using negative PID
-			_ = syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL) // Best-effort termination
+			killProcessGroup(p.cmd)
 			killed = true
 		}
 		// Close stdout to unblock any IO goroutines reading from it.
@@ -320,6 +366,18 @@ func (p *process) close() error {
 		<-p.done
 	}
 
+	// Remove files written for the process now that it has exited,
+	// including when it had to be killed
+	if p.cleanup != nil {
+		_ = p.cleanup() // Best-effort cleanup
+		p.cleanup = nil
+	}
+
+	// Stop managed MCP servers now that the CLI is gone, including when it
+	// had to be killed, so they cannot outlive it
+	p.mcp.stop()
+	p.mcp = nil
+
 	// Check exit status - ignore if we killed the process
 	if p.exitErr != nil && !killed {
 		if exitErr, ok := p.exitErr.(*exec.ExitError); ok {
@@ -336,6 +394,20 @@ func (p *process) close() error {
 	return nil
 }
 
+// exitStatus waits up to d for the process to exit and returns its exit
+// code and stderr. exited is false if it is still running.
+func (p *process) exitStatus(d time.Duration) (code int, stderr string, exited bool) {
+	select {
+	case <-p.done:
+	case <-time.After(d):
+		return 0, "", false
+	}
+	if exitErr, ok := p.exitErr.(*exec.ExitError); ok {
+		code = exitErr.ExitCode()
+	}
+	return code, p.stderr.String(), true
+}
+
 // wait blocks until the process exits.
 func (p *process) wait() error {
 	<-p.done
@@ -0,0 +1,35 @@
+//go:build unix
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// processGone reports whether pid has exited, waiting briefly for it to be
+// reaped. A zombie counts as gone.
+func processGone(pid int) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+			return true
+		}
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+			if i := bytes.LastIndexByte(data, ')'); i >= 0 {
+				if state := strings.Fields(string(data[i+1:])); len(state) > 0 && state[0] == "Z" {
+					return true
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package agent
+
+import "os/exec"
+
+// setProcessGroup does nothing on Windows, which has no process groups to
+// kill.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd. Processes it started are not killed with it
+// on Windows. It is best effort; the process may be gone.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
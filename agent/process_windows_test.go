@@ -0,0 +1,25 @@
+//go:build windows
+
+package agent
+
+import (
+	"os"
+	"time"
+)
+
+// processGone reports whether pid has exited, waiting briefly for it to
+// exit.
+func processGone(pid int) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return true
+		}
+		_ = p.Release()
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultProfileMaxTurns is the MaxTurns set by ProfileSandboxed and
+// ProfileReadOnly.
+const DefaultProfileMaxTurns = 50
+
+// ProfileDescription lists the options a profile expanded to, as reported
+// by Describe.
+type ProfileDescription struct {
+	Name    string
+	Members []string // Each option, written as Go code
+}
+
+// profileMember is one option of a profile and its description.
+type profileMember struct {
+	desc string
+	opt  Option
+}
+
+// sandboxDeniedCommands are the network and privilege escalation commands
+// denied by ProfileSandboxed.
+var sandboxDeniedCommands = []string{
+	"sudo", "su -", "doas", "pkexec",
+	"curl", "wget", "ssh ", "scp ", "sftp", "rsync", "ftp ", "telnet", "netcat", "ncat", "socat",
+}
+
+// credentialPaths are the directories and files denied by both profiles.
+var credentialPaths = []string{
+	"/etc",
+	"~/.ssh", "~/.gnupg", "~/.aws", "~/.azure", "~/.config/gcloud", "~/.kube", "~/.docker",
+	"~/.netrc", "~/.git-credentials", "~/.npmrc", "~/.pypirc",
+}
+
+// readOnlyCommands are the commands ProfileReadOnly allows in Bash, alone
+// or followed by arguments.
+var readOnlyCommands = []string{
+	"ls", "cat", "head", "tail", "wc", "grep", "pwd",
+	"git status", "git log", "git diff", "git show", "git blame",
+}
+
+// readOnlyForbidden are the parts of a command that could chain another
+// command or write a file, which ProfileReadOnly denies in any command.
+var readOnlyForbidden = []string{";", "&", "|", ">", "<", "`", "$(", "\n", "--output"}
+
+// ProfileSandboxed confines an agent to workDir with a vetted set of
+// options, for users who would otherwise assemble them by hand:
+//
+//   - WorkDir(workDir) and ScratchDir()
+//   - Tools Read, Write, Edit, Glob, Grep, and Bash
+//   - DenyCommands for network access and privilege escalation
+//   - AllowPaths(workDir, os.TempDir()); /tmp paths are rewritten into the
+//     scratch directory
+//   - DenyPaths for /etc and credential directories such as ~/.ssh and ~/.aws
+//   - PermissionPrompt(PermissionAcceptEdits)
+//   - MaxTurns(DefaultProfileMaxTurns)
+//
+// A profile is only a bundle of options: options given after it override
+// its settings, such as MaxTurns(100), and hooks given after it are added
+// to its hooks. Its hooks deny calls and run first, as described for
+// PreToolUsePriority, so a later hook cannot allow what they deny.
+// Describe lists exactly what the profile expands to.
+//
+// Example:
+//
+//	a, err := agent.New(ctx, agent.ProfileSandboxed("/work/repo"), agent.MaxTurns(100))
+func ProfileSandboxed(workDir string) Option {
+	tmp := os.TempDir()
+	tools := []string{ToolRead, ToolWrite, ToolEdit, ToolGlob, ToolGrep, ToolBash}
+	return profile("ProfileSandboxed", []profileMember{
+		{call("WorkDir", workDir), WorkDir(workDir)},
+		{call("ScratchDir"), ScratchDir()},
+		{call("Tools", tools...), Tools(tools...)},
+		{"PreToolUse(" + call("DenyCommands", sandboxDeniedCommands...) + ")", PreToolUse(DenyCommands(sandboxDeniedCommands...))},
+		{"PreToolUse(" + call("AllowPaths", workDir, tmp) + ")", PreToolUse(AllowPaths(workDir, tmp))},
+		{"PreToolUse(" + call("DenyPaths", credentialPaths...) + ")", PreToolUse(DenyPaths(credentialPaths...))},
+		{"PermissionPrompt(PermissionAcceptEdits)", PermissionPrompt(PermissionAcceptEdits)},
+		{fmt.Sprintf("MaxTurns(%d)", DefaultProfileMaxTurns), MaxTurns(DefaultProfileMaxTurns)},
+	})
+}
+
+// ProfileReadOnly lets an agent read but not change files:
+//
+//   - Tools Read, Glob, Grep, and Bash, with Write, Edit, MultiEdit, and
+//     NotebookEdit also disallowed
+//   - Bash limited to ls, cat, head, tail, wc, grep, pwd, and git status,
+//     log, diff, show, and blame, with no pipes, redirection, or command
+//     chaining
+//   - AllowPaths(paths...), or the WorkDir if no paths are given
+//   - DenyPaths for /etc and credential directories such as ~/.ssh and ~/.aws
+//   - MaxTurns(DefaultProfileMaxTurns)
+//
+// Options given after it override its settings and add to its hooks, as
+// for ProfileSandboxed.
+func ProfileReadOnly(paths ...string) Option {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	tools := []string{ToolRead, ToolGlob, ToolGrep, ToolBash}
+	mutating := []string{ToolWrite, ToolEdit, ToolMultiEdit, ToolNotebookEdit}
+	return profile("ProfileReadOnly", []profileMember{
+		{call("Tools", tools...), Tools(tools...)},
+		{call("DisallowedTools", mutating...), DisallowedTools(mutating...)},
+		{"PreToolUse(" + call("readOnlyBash", readOnlyCommands...) + ")", PreToolUse(readOnlyBash())},
+		{"PreToolUse(" + call("AllowPaths", paths...) + ")", PreToolUse(AllowPaths(paths...))},
+		{"PreToolUse(" + call("DenyPaths", credentialPaths...) + ")", PreToolUse(DenyPaths(credentialPaths...))},
+		{fmt.Sprintf("MaxTurns(%d)", DefaultProfileMaxTurns), MaxTurns(DefaultProfileMaxTurns)},
+	})
+}
+
+// profile returns an Option that applies members in order and records
+// them for Describe.
+func profile(name string, members []profileMember) Option {
+	return func(c *config) {
+		d := ProfileDescription{Name: name}
+		for _, m := range members {
+			m.opt(c)
+			d.Members = append(d.Members, m.desc)
+		}
+		c.profiles = append(c.profiles, d)
+	}
+}
+
+// call writes a call of an option with string arguments as Go code.
+func call(name string, args ...string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = fmt.Sprintf("%q", arg)
+	}
+	return name + "(" + strings.Join(quoted, ", ") + ")"
+}
+
+// readOnlyBash returns the hook of ProfileReadOnly that denies Bash
+// commands other than readOnlyCommands.
+func readOnlyBash() *BuiltinHook {
+	return builtinHook(PrioritySecurity, func(tc *ToolCall) HookResult {
+		if tc.Name != ToolBash {
+			return HookResult{Decision: Continue}
+		}
+		command, _ := tc.Input["command"].(string)
+		command = strings.TrimSpace(command)
+		for _, s := range readOnlyForbidden {
+			if strings.Contains(command, s) {
+				return HookResult{Decision: Deny, Reason: fmt.Sprintf("read-only profile: %q is not allowed in commands", s)}
+			}
+		}
+		for _, allowed := range readOnlyCommands {
+			if command == allowed || strings.HasPrefix(command, allowed+" ") {
+				return HookResult{Decision: Continue}
+			}
+		}
+		return HookResult{
+			Decision: Deny,
+			Reason:   "read-only profile: only these commands are allowed: " + strings.Join(readOnlyCommands, ", "),
+		}
+	})
+}
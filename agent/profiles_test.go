@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfileDescribeGolden(t *testing.T) {
+	t.Setenv("TMPDIR", "/tmp/profile") // os.TempDir is part of the expansion
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"sandboxed", []Option{ProfileSandboxed("/work/repo")}},
+		{"read_only", []Option{ProfileReadOnly("/work/repo", "/work/docs")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Describe(tt.opts...).String()
+			golden := filepath.Join("testdata", "profiles", tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if want := string(mustReadFile(t, golden)); got != want {
+				t.Errorf("Describe() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestProfileOverrides(t *testing.T) {
+	d := Describe(
+		ProfileSandboxed("/work/repo"),
+		MaxTurns(100),
+		Tools(ToolRead),
+		PermissionPrompt(PermissionDefault),
+		PreToolUse(allowEverything),
+	)
+	if d.MaxTurns != 100 {
+		t.Errorf("MaxTurns = %d, want the later option's 100", d.MaxTurns)
+	}
+	if !reflect.DeepEqual(d.Tools, []string{ToolRead}) {
+		t.Errorf("Tools = %v, want the later option's [Read]", d.Tools)
+	}
+	if d.PermissionMode != PermissionDefault {
+		t.Errorf("PermissionMode = %s, want default", d.PermissionMode)
+	}
+	if d.PreToolUseHooks != 4 {
+		t.Errorf("PreToolUseHooks = %d, want the profile's 3 and the later hook", d.PreToolUseHooks)
+	}
+	if len(d.Profiles) != 1 || d.Profiles[0].Name != "ProfileSandboxed" {
+		t.Errorf("Profiles = %+v, want ProfileSandboxed", d.Profiles)
+	}
+
+	// Options before the profile are overridden by it
+	if d := Describe(MaxTurns(100), ProfileReadOnly()); d.MaxTurns != DefaultProfileMaxTurns {
+		t.Errorf("MaxTurns = %d, want the profile's %d", d.MaxTurns, DefaultProfileMaxTurns)
+	}
+}
+
+func TestProfileSandboxedHooks(t *testing.T) {
+	work := t.TempDir()
+	// A later hook that allows everything does not skip the profile's hooks
+	cfg := newConfig(ProfileSandboxed(work), PreToolUse(allowEverything))
+	chain := newHookChain(cfg.sortedPreToolUseHooks())
+
+	tests := []struct {
+		name  string
+		tool  string
+		input map[string]any
+		want  Decision
+	}{
+		{"network", ToolBash, map[string]any{"command": "curl https://example.com"}, Deny},
+		{"privilege", ToolBash, map[string]any{"command": "sudo make install"}, Deny},
+		{"credentials", ToolRead, map[string]any{"file_path": "~/.ssh/id_ed25519"}, Deny},
+		{"outside work dir", ToolWrite, map[string]any{"file_path": "/usr/local/bin/tool"}, Deny},
+		{"inside work dir", ToolWrite, map[string]any{"file_path": filepath.Join(work, "main.go")}, Allow},
+		{"build", ToolBash, map[string]any{"command": "go test ./..."}, Allow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chain.evaluate(&ToolCall{Name: tt.tool, Input: tt.input})
+			if got.Decision != tt.want {
+				t.Errorf("Decision = %v (%s), want %v", got.Decision, got.Reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfileReadOnlyBash(t *testing.T) {
+	hook := readOnlyBash()
+	tests := []struct {
+		command string
+		want    Decision
+	}{
+		{"ls -la", Continue},
+		{"git log --oneline -5", Continue},
+		{"git status", Continue},
+		{"  pwd  ", Continue},
+		{"lsof", Deny},
+		{"rm -rf build", Deny},
+		{"git push", Deny},
+		{"cat a.txt > b.txt", Deny},
+		{"ls; rm -rf /", Deny},
+		{"cat $(which rm)", Deny},
+		{"grep -r x . | tee out", Deny},
+		{"git diff --output=patch", Deny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got := hook.Evaluate(&ToolCall{Name: ToolBash, Input: map[string]any{"command": tt.command}})
+			if got.Decision != tt.want {
+				t.Errorf("Decision = %v (%s), want %v", got.Decision, got.Reason, tt.want)
+			}
+		})
+	}
+	if got := hook.Evaluate(&ToolCall{Name: ToolRead, Input: map[string]any{"file_path": "a"}}); got.Decision != Continue {
+		t.Errorf("Read Decision = %v, want continue", got.Decision)
+	}
+	if got := hook.priority; got != PrioritySecurity {
+		t.Errorf("priority = %d, want PrioritySecurity", got)
+	}
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultToolProgressRate is how many ToolProgress messages per second a
+// tool call may deliver unless ToolProgressRate says otherwise.
+const DefaultToolProgressRate = 10
+
+// ToolProgress reports the progress of a tool call, sent by a custom tool
+// or a PreToolUse hook through ProgressFromContext. Each message carries
+// the latest text and percentage reported for the call, so a UI can render
+// it as is.
+type ToolProgress struct {
+	MessageMeta
+	ToolUseID string // Empty if the CLI did not provide the tool_use ID
+	Name      string
+	Text      string
+	Percent   float64 // From 0 to 100; 0 until Percent is called
+}
+
+func (ToolProgress) message() {}
+
+// ToolProgressRate limits how many ToolProgress messages per second each
+// tool call may deliver. Reports beyond the limit are dropped, though the
+// text and percentage they set are carried by the next message delivered.
+// A value of 0 or less removes the limit. The default is
+// DefaultToolProgressRate.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Tools(buildTool), agent.ToolProgressRate(2))
+func ToolProgressRate(perSecond float64) Option {
+	return func(c *config) {
+		c.progressRate = perSecond
+	}
+}
+
+// ProgressReporter sends ToolProgress messages for one tool call. Its
+// methods are safe for concurrent use, and do nothing once the tool has
+// returned or on a nil reporter.
+type ProgressReporter struct {
+	agent     *Agent
+	sink      *progressSink
+	toolUseID string
+	name      string
+	interval  time.Duration // Minimum time between messages (0 = unlimited)
+
+	mu      sync.Mutex
+	done    bool
+	last    time.Time
+	text    string
+	percent float64
+}
+
+// progressKey is the context key of a tool call's ProgressReporter.
+type progressKey struct{}
+
+// ProgressFromContext returns the reporter of the tool call whose context
+// is ctx: the context passed to a custom tool's Execute, or ToolCall.Context
+// in a PreToolUse hook. If ctx belongs to no tool call, the reporter does
+// nothing.
+//
+// Messages are delivered on the Stream channel of the run that made the
+// call, ahead of the call's ToolResult, and emitted as tool.progress audit
+// events. They are dropped rather than wait if the caller is not receiving.
+//
+// Example:
+//
+//	func(ctx context.Context, input map[string]any) (any, error) {
+//	    progress := agent.ProgressFromContext(ctx)
+//	    progress.Report("compiling")
+//	    progress.Percent(40)
+//	    // ...
+//	}
+func ProgressFromContext(ctx context.Context) *ProgressReporter {
+	r, _ := ctx.Value(progressKey{}).(*ProgressReporter)
+	return r
+}
+
+// Report sets the progress text and sends it.
+func (r *ProgressReporter) Report(text string) {
+	r.update(func() { r.text = text })
+}
+
+// Percent sets the percentage complete, clamped to 0 to 100, and sends it.
+func (r *ProgressReporter) Percent(p float64) {
+	if math.IsNaN(p) {
+		return
+	}
+	r.update(func() { r.percent = math.Max(0, math.Min(100, p)) })
+}
+
+// update applies set and sends the reporter's state, subject to the rate
+// limit.
+func (r *ProgressReporter) update(set func()) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.done {
+		r.mu.Unlock()
+		return
+	}
+	set()
+	now := time.Now()
+	if r.interval > 0 && !r.last.IsZero() && now.Sub(r.last) < r.interval {
+		r.mu.Unlock()
+		return
+	}
+	r.last = now
+	msg := &ToolProgress{
+		MessageMeta: MessageMeta{Timestamp: now, SessionID: r.agent.SessionID()},
+		ToolUseID:   r.toolUseID,
+		Name:        r.name,
+		Text:        r.text,
+		Percent:     r.percent,
+	}
+	// Sent under the lock, so messages keep their order and none follows finish
+	r.agent.auditor.emit(msg.SessionID, "tool.progress", map[string]any{
+		"tool":        msg.Name,
+		"tool_use_id": msg.ToolUseID,
+		"text":        msg.Text,
+		"percent":     msg.Percent,
+	})
+	r.sink.deliver(msg)
+	r.mu.Unlock()
+}
+
+// finish turns the reporter into a no-op once the tool has returned.
+func (r *ProgressReporter) finish() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.done = true
+	r.mu.Unlock()
+}
+
+// newProgressReporter returns a reporter for a tool call made during the
+// run whose context is ctx, or nil if ctx has no run.
+func (a *Agent) newProgressReporter(ctx context.Context, req *ControlRequest) *ProgressReporter {
+	sink, _ := ctx.Value(progressSinkKey{}).(*progressSink)
+	if sink == nil {
+		return nil
+	}
+	r := &ProgressReporter{
+		agent:     a,
+		sink:      sink,
+		toolUseID: req.ToolUseID,
+		name:      req.Tool.Name,
+	}
+	if req.ToolUseID == "" {
+		r.toolUseID = req.Tool.ID
+	}
+	if a.cfg.progressRate > 0 {
+		r.interval = time.Duration(float64(time.Second) / a.cfg.progressRate)
+	}
+	return r
+}
+
+// progressSinkKey is the context key of a run's progressSink.
+type progressSinkKey struct{}
+
+// progressSink delivers ToolProgress messages on a run's Stream channel
+// from tool goroutines. The run closes the channel through the sink, so no
+// message is sent after it closes.
+type progressSink struct {
+	rc *runConfig
+
+	mu     sync.Mutex
+	out    chan<- Message
+	closed bool
+}
+
+// newProgressSink returns a sink for a run's channel.
+func newProgressSink(out chan<- Message, rc *runConfig) *progressSink {
+	return &progressSink{out: out, rc: rc}
+}
+
+// deliver sends msg if the channel is open, has room, and the run's
+// message filter allows it.
+func (s *progressSink) deliver(msg *ToolProgress) {
+	if !s.rc.delivers(msg) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.out <- msg:
+	default: // The caller is not keeping up; progress is not worth blocking for
+	}
+}
+
+// close closes the run's channel.
+func (s *progressSink) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.out)
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestToolProgress(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"progress-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"build","input":{}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"build","tool_input":{}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"built"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var leaked *ProgressReporter
+	build := NewFuncTool("build", "Builds", nil, func(ctx context.Context, input map[string]any) (any, error) {
+		progress := ProgressFromContext(ctx)
+		progress.Report("compiling")
+		time.Sleep(20 * time.Millisecond) // A long build, in brief
+		progress.Percent(50)
+		progress.Report("linking")
+		leaked = progress
+		return "built", nil
+	})
+
+	var mu sync.Mutex
+	var events []map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		CustomTool(build),
+		ToolProgressRate(0),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			ProgressFromContext(tc.Context()).Report("waiting for approval")
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "tool.progress" {
+				mu.Lock()
+				events = append(events, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	type step struct {
+		text    string
+		percent float64
+	}
+	var progress []step
+	sawResult := false
+	for msg := range a.Stream(ctx, "build it") {
+		switch m := msg.(type) {
+		case *ToolProgress:
+			if sawResult {
+				t.Errorf("ToolProgress %q after the ToolResult", m.Text)
+			}
+			if m.ToolUseID != "tu-1" || m.Name != "build" || m.Timestamp.IsZero() {
+				t.Errorf("ToolProgress = %+v, want tu-1 build with a timestamp", m)
+			}
+			progress = append(progress, step{m.Text, m.Percent})
+		case *ToolResult:
+			sawResult = true
+		}
+	}
+	if err := a.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+
+	want := []step{{"waiting for approval", 0}, {"compiling", 0}, {"compiling", 50}, {"linking", 50}}
+	if !reflect.DeepEqual(progress, want) {
+		t.Errorf("progress = %v, want %v", progress, want)
+	}
+	if !sawResult {
+		t.Error("no ToolResult")
+	}
+
+	// The reporter does nothing once the tool has returned
+	leaked.Report("too late")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want) || events[3]["text"] != "linking" || events[3]["tool_use_id"] != "tu-1" {
+		t.Errorf("tool.progress events = %v", events)
+	}
+}
+
+func TestToolProgressRateLimit(t *testing.T) {
+	out := make(chan Message, 10)
+	sink := newProgressSink(out, &runConfig{})
+	r := &ProgressReporter{agent: &Agent{}, sink: sink, name: "build", interval: 50 * time.Millisecond}
+
+	r.Report("one")
+	r.Report("two")
+	r.Percent(20)
+	time.Sleep(60 * time.Millisecond)
+	r.Percent(150)
+	sink.close()
+
+	var got []ToolProgress
+	for msg := range out {
+		got = append(got, *msg.(*ToolProgress))
+	}
+	if len(got) != 2 {
+		t.Fatalf("delivered %d messages, want 2: %+v", len(got), got)
+	}
+	// The next message carries the state set by dropped reports
+	if got[0].Text != "one" || got[1].Text != "two" || got[1].Percent != 100 {
+		t.Errorf("messages = %+v, want one, then two at 100%%", got)
+	}
+
+	// Nothing is sent once the channel is closed
+	r.last = time.Time{}
+	r.Report("after close")
+}
+
+func TestToolProgressFilteredAndNoOp(t *testing.T) {
+	out := make(chan Message, 10)
+	sink := newProgressSink(out, newRunConfig(ExcludeMessages(MessageToolProgress)))
+	r := &ProgressReporter{agent: &Agent{}, sink: sink, name: "build"}
+	r.Report("hidden")
+	if len(out) != 0 {
+		t.Errorf("ExcludeMessages(MessageToolProgress) delivered %d messages", len(out))
+	}
+
+	// Outside a tool call the reporter is nil and does nothing
+	ProgressFromContext(context.Background()).Report("nobody listens")
+	ProgressFromContext(context.Background()).Percent(10)
+}
@@ -0,0 +1,292 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PromptCompressor shortens a prompt to fit a token budget.
+type PromptCompressor interface {
+	// Compress returns prompt shortened to at most budget tokens, or an
+	// error if it cannot be.
+	Compress(ctx context.Context, prompt string, budget int) (string, error)
+}
+
+// PromptCompressorFunc adapts a function to a PromptCompressor.
+type PromptCompressorFunc func(ctx context.Context, prompt string, budget int) (string, error)
+
+// Compress calls f.
+func (f PromptCompressorFunc) Compress(ctx context.Context, prompt string, budget int) (string, error) {
+	return f(ctx, prompt, budget)
+}
+
+// PromptBudgetOption configures PromptBudget.
+type PromptBudgetOption func(*promptBudget)
+
+// promptBudget holds the settings from PromptBudget.
+type promptBudget struct {
+	max        int
+	compressor PromptCompressor
+	count      func(text string) int // nil = the agent's TokenCounter
+}
+
+// PromptTokens replaces the agent's TokenCounter for PromptBudget and its
+// built-in compressors.
+func PromptTokens(fn func(text string) int) PromptBudgetOption {
+	return func(b *promptBudget) {
+		if fn != nil {
+			b.count = fn
+		}
+	}
+}
+
+// PromptBudget limits the estimated tokens of each prompt. A prompt over
+// maxTokens is passed to compressor before UserPromptSubmit hooks run, and
+// the run continues with the result. If the compressor fails, or its result
+// is still over the budget, the run ends with a *PromptCompressionError
+// before anything is sent to the CLI. With a nil compressor, prompts over
+// the budget are refused. A maxTokens of 0 means unlimited (default).
+//
+// The budget applies to the caller's prompt, not to context the agent adds.
+// The message.prompt audit event of a compressed prompt records the
+// original and compressed sizes under prompt_compression. The Result's
+// OriginalPrompt is the prompt before compression.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.PromptBudget(50_000, agent.TruncateMiddle("")),
+//	)
+func PromptBudget(maxTokens int, compressor PromptCompressor, opts ...PromptBudgetOption) Option {
+	b := &promptBudget{max: maxTokens, compressor: compressor}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return func(c *config) {
+		c.promptBudget = nil
+		if maxTokens > 0 {
+			c.promptBudget = b
+		}
+	}
+}
+
+// promptCounterKey is the context key of the token counter the built-in
+// compressors measure with.
+type promptCounterKey struct{}
+
+// promptCounter returns the PromptBudget's token counter from ctx, or the
+// built-in heuristic.
+func promptCounter(ctx context.Context) func(text string) int {
+	if count, ok := ctx.Value(promptCounterKey{}).(func(text string) int); ok {
+		return count
+	}
+	return heuristicTokens
+}
+
+// fit compresses prompt if it is over the budget. It returns the prompt to
+// send and, if it was compressed, the sizes for the audit event. count is
+// the agent's token counter, used unless PromptTokens set one.
+func (b *promptBudget) fit(ctx context.Context, prompt string, count func(text string) int) (string, map[string]any, error) {
+	if b.count != nil {
+		count = b.count
+	}
+	tokens := count(prompt)
+	if tokens <= b.max {
+		return prompt, nil, nil
+	}
+	perr := &PromptCompressionError{Tokens: tokens, Compressed: tokens, Budget: b.max}
+	if b.compressor == nil {
+		return "", nil, perr
+	}
+
+	compressed, err := b.compressor.Compress(context.WithValue(ctx, promptCounterKey{}, count), prompt, b.max)
+	if err != nil {
+		perr.Err = err
+		return "", nil, perr
+	}
+	perr.Compressed = count(compressed)
+	if perr.Compressed > b.max {
+		return "", nil, perr
+	}
+	return compressed, map[string]any{
+		"budget_tokens":     b.max,
+		"original_tokens":   tokens,
+		"compressed_tokens": perr.Compressed,
+		"original_bytes":    len(prompt),
+		"compressed_bytes":  len(compressed),
+	}, nil
+}
+
+// DefaultTruncateMarker replaces the middle of a prompt cut by
+// TruncateMiddle.
+const DefaultTruncateMarker = "\n\n[... truncated ...]\n\n"
+
+// TruncateMiddle returns a compressor that keeps as much of the start and
+// end of a prompt as fits, in equal parts, and replaces the middle with
+// marker, or DefaultTruncateMarker if marker is empty. It fails if the
+// marker alone is over the budget.
+//
+// Example:
+//
+//	agent.PromptBudget(50_000, agent.TruncateMiddle("\n[... log lines omitted ...]\n"))
+func TruncateMiddle(marker string) PromptCompressor {
+	if marker == "" {
+		marker = DefaultTruncateMarker
+	}
+	return truncateMiddle{marker: marker}
+}
+
+type truncateMiddle struct {
+	marker string
+}
+
+func (t truncateMiddle) Compress(ctx context.Context, prompt string, budget int) (string, error) {
+	count := promptCounter(ctx)
+	if count(prompt) <= budget {
+		return prompt, nil
+	}
+	if count(t.marker) > budget {
+		return "", fmt.Errorf("agent: budget of %d tokens is smaller than the truncation marker", budget)
+	}
+
+	// Search for the most characters to keep; keeping none always fits
+	runes := []rune(prompt)
+	keep := func(n int) string {
+		head, tail := n-n/2, n/2
+		return string(runes[:head]) + t.marker + string(runes[len(runes)-tail:])
+	}
+	lo, hi := 0, len(runes)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if count(keep(mid)) <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return keep(lo), nil
+}
+
+// DropSections returns a compressor that splits a prompt into sections at
+// its markdown headings and drops whole sections, lowest priority first,
+// until the rest fits. priority is called with each section's heading
+// text, or "" for any text before the first heading; among equal
+// priorities, later sections are dropped first. A nil priority drops
+// sections from the end. The sections left keep their order. Headings in
+// fenced code blocks do not start sections.
+//
+// The last section is never dropped: a prompt that does not fit with one
+// section left, including one without headings, is an error.
+//
+// Example:
+//
+//	agent.PromptBudget(50_000, agent.DropSections(func(heading string) int {
+//	    switch heading {
+//	    case "Task":
+//	        return 10
+//	    case "Examples":
+//	        return 1
+//	    }
+//	    return 5
+//	}))
+func DropSections(priority func(heading string) int) PromptCompressor {
+	return dropSections{priority: priority}
+}
+
+type dropSections struct {
+	priority func(heading string) int
+}
+
+func (d dropSections) Compress(ctx context.Context, prompt string, budget int) (string, error) {
+	count := promptCounter(ctx)
+	if count(prompt) <= budget {
+		return prompt, nil
+	}
+
+	sections := splitSections(prompt)
+	ranks := make([]int, len(sections))
+	order := make([]int, len(sections)) // Indexes in the order they are dropped
+	for i, s := range sections {
+		if d.priority != nil {
+			ranks[i] = d.priority(s.heading)
+		}
+		order[i] = len(sections) - 1 - i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return ranks[order[i]] < ranks[order[j]] })
+
+	dropped := make([]bool, len(sections))
+	for _, i := range order[:len(order)-1] {
+		dropped[i] = true
+		var b strings.Builder
+		for j, s := range sections {
+			if !dropped[j] {
+				b.WriteString(s.text)
+			}
+		}
+		if text := b.String(); count(text) <= budget {
+			return text, nil
+		}
+	}
+	return "", fmt.Errorf("agent: prompt does not fit %d tokens with one of its %d sections left", budget, len(sections))
+}
+
+// promptSection is part of a prompt from one markdown heading to the next.
+type promptSection struct {
+	heading string // Heading text without the #s; "" before the first heading
+	text    string // The heading line and everything to the next one
+}
+
+// splitSections splits text at its ATX headings ("# Title" to
+// "###### Title") outside fenced code blocks.
+func splitSections(text string) []promptSection {
+	var sections []promptSection
+	start, heading := 0, ""
+	fence := ""
+	for pos := 0; pos < len(text); {
+		end := strings.IndexByte(text[pos:], '\n') + pos + 1
+		if end == pos {
+			end = len(text)
+		}
+		line := strings.TrimRight(text[pos:end], "\r\n")
+		trimmed := strings.TrimLeft(line, " ")
+
+		switch {
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"):
+			fence = "```"
+		case strings.HasPrefix(trimmed, "~~~"):
+			fence = "~~~"
+		default:
+			if title, ok := atxHeading(line); ok {
+				if pos > start {
+					sections = append(sections, promptSection{heading: heading, text: text[start:pos]})
+				}
+				start, heading = pos, title
+			}
+		}
+		pos = end
+	}
+	return append(sections, promptSection{heading: heading, text: text[start:]})
+}
+
+// atxHeading returns the text of a markdown ATX heading line.
+func atxHeading(line string) (string, bool) {
+	if len(line)-len(strings.TrimLeft(line, " ")) > 3 {
+		return "", false // Indented code
+	}
+	line = strings.TrimLeft(line, " ")
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	if level < 1 || level > 6 {
+		return "", false
+	}
+	rest := line[level:]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false // "#tag", not a heading
+	}
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rest), "#")), true
+}
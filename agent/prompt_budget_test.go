@@ -0,0 +1,226 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// runeTokens counts one token per character, so budgets in tests are exact.
+func runeTokens(s string) int { return utf8.RuneCountInString(s) }
+
+// withRuneTokens is a context in which the built-in compressors count
+// characters.
+func withRuneTokens() context.Context {
+	return context.WithValue(context.Background(), promptCounterKey{}, runeTokens)
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	tests := []struct {
+		name    string
+		marker  string
+		prompt  string
+		budget  int
+		want    string
+		wantErr bool
+	}{
+		{name: "under budget", marker: "..", prompt: "short", budget: 5, want: "short"},
+		{name: "keeps both ends", marker: "..", prompt: "0123456789", budget: 8, want: "012..789"},
+		{name: "odd space favours the head", marker: "..", prompt: "0123456789", budget: 7, want: "012..89"},
+		{name: "multibyte", marker: "~", prompt: "héllo wörld", budget: 5, want: "hé~ld"},
+		{name: "only the marker fits", marker: "[cut]", prompt: "0123456789", budget: 5, want: "[cut]"},
+		{name: "budget smaller than marker", marker: "[cut]", prompt: "0123456789", budget: 4, wantErr: true},
+		{name: "default marker", prompt: strings.Repeat("x", 100), budget: 30, want: "xxxx" + DefaultTruncateMarker + "xxx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TruncateMiddle(tt.marker).Compress(withRuneTokens(), tt.prompt, tt.budget)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Compress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropSections(t *testing.T) {
+	prompt := "Intro\n" +
+		"# Task\nDo it\n" +
+		"## Examples ##\nAAAA\n" +
+		"```sh\n# not a heading\n```\n" +
+		"## Notes\nBB\n"
+	priorities := map[string]int{"": 5, "Task": 10, "Examples": 1, "Notes": 1}
+	priority := func(heading string) int {
+		p, ok := priorities[heading]
+		if !ok {
+			t.Errorf("priority(%q) called for an unknown heading", heading)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		prompt   string
+		priority func(string) int
+		budget   int
+		want     string
+		wantErr  bool
+	}{
+		{name: "under budget", prompt: prompt, priority: priority, budget: len(prompt), want: prompt},
+		// Of the two priority 1 sections, the later goes first
+		{name: "drops the later of equal priorities", prompt: prompt, priority: priority, budget: len(prompt) - 1,
+			want: "Intro\n# Task\nDo it\n## Examples ##\nAAAA\n```sh\n# not a heading\n```\n"},
+		{name: "drops by priority", prompt: prompt, priority: priority, budget: 20, want: "Intro\n# Task\nDo it\n"},
+		{name: "keeps the last section", prompt: prompt, priority: priority, budget: 13, want: "# Task\nDo it\n"},
+		{name: "last section over budget", prompt: prompt, priority: priority, budget: 5, wantErr: true},
+		{name: "nil priority drops from the end", prompt: "# A\na\n# B\nb\n# C\nc\n", budget: 12, want: "# A\na\n# B\nb\n"},
+		{name: "no headings", prompt: "#hashtag is not a heading\nplain text", budget: 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DropSections(tt.priority).Compress(withRuneTokens(), tt.prompt, tt.budget)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Compress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Compress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSections(t *testing.T) {
+	text := "# One #\n~~~\n## fenced\n~~~\n    # indented\n####### seven\n### Two\n"
+	got := splitSections(text)
+	if len(got) != 2 || got[0].heading != "One" || got[1].heading != "Two" {
+		t.Fatalf("splitSections() = %+v, want One and Two", got)
+	}
+	if got[0].text+got[1].text != text {
+		t.Errorf("sections %+v do not rebuild the text", got)
+	}
+}
+
+func TestPromptBudget(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	stdinFile := filepath.Join(tmpDir, "stdin.json")
+	script := `#!/bin/sh
+head -n 1 > "` + stdinFile + `"
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"budget-test"}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var events []map[string]any
+	var hooked string
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		PromptBudget(8, TruncateMiddle(".."), PromptTokens(runeTokens)),
+		UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+			hooked = e.Prompt
+			return PromptSubmitResult{}
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "message.prompt" {
+				mu.Lock()
+				events = append(events, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.RunReader(ctx, strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("RunReader() error = %v", err)
+	}
+	if hooked != "012..789" || result.OriginalPrompt != "0123456789" || result.Prompt != "012..789" {
+		t.Errorf("hook saw %q; OriginalPrompt, Prompt = %q, %q", hooked, result.OriginalPrompt, result.Prompt)
+	}
+	var msg userMessage
+	if err := json.Unmarshal(mustReadFile(t, stdinFile), &msg); err != nil {
+		t.Fatalf("CLI received invalid JSON: %v", err)
+	}
+	if got := msg.Message.Content[0].Text; got != "012..789" {
+		t.Errorf("CLI received %q, want the compressed prompt", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 {
+		t.Fatalf("message.prompt events = %v", events)
+	}
+	want := map[string]any{"budget_tokens": 8, "original_tokens": 10, "compressed_tokens": 8, "original_bytes": 10, "compressed_bytes": 8}
+	got, _ := events[0]["prompt_compression"].(map[string]any)
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("prompt_compression[%s] = %v, want %v", k, got[k], v)
+		}
+	}
+}
+
+func TestPromptBudgetErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	stdinFile := filepath.Join(tmpDir, "stdin.json")
+	script := `#!/bin/sh
+cat > "` + stdinFile + `"
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	failed := errors.New("summarizer unavailable")
+	tests := []struct {
+		name       string
+		compressor PromptCompressor
+		wantErr    error
+		compressed int
+		message    string
+	}{
+		{"no compressor", nil, nil, 10, "agent: prompt of 10 tokens exceeds the budget of 4"},
+		{"compressor fails", PromptCompressorFunc(func(context.Context, string, int) (string, error) {
+			return "", failed
+		}), failed, 10, "agent: compressing prompt of 10 tokens to 4: summarizer unavailable"},
+		{"result over budget", PromptCompressorFunc(func(_ context.Context, prompt string, _ int) (string, error) {
+			return prompt[:6], nil
+		}), nil, 6, "agent: prompt of 10 tokens compressed to 6, over the budget of 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, err := New(ctx, CLIPath(fakeClaude), PromptBudget(4, tt.compressor, PromptTokens(runeTokens)))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			_, err = a.Run(ctx, "0123456789")
+			var perr *PromptCompressionError
+			if !errors.As(err, &perr) || perr.Tokens != 10 || perr.Compressed != tt.compressed || perr.Budget != 4 {
+				t.Fatalf("Run() error = %#v, want *PromptCompressionError", err)
+			}
+			if perr.Error() != tt.message {
+				t.Errorf("Error() = %q, want %q", perr.Error(), tt.message)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want it to wrap %v", err, tt.wantErr)
+			}
+
+			// Nothing reached the CLI
+			mustClose(t, a)
+			if data := mustReadFile(t, stdinFile); len(data) != 0 {
+				t.Errorf("CLI received %q, want nothing", data)
+			}
+		})
+	}
+}
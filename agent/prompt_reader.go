@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxPromptBytes limits the size of a prompt. Run, Stream, RunReader, and
+// StreamReader refuse a longer prompt with a *PromptTooLargeError before
+// anything is sent to the CLI; a reader is not read past the limit. The
+// limit applies to the caller's prompt, not to context the agent adds. A
+// value of 0 means unlimited (default).
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.MaxPromptBytes(8<<20))
+func MaxPromptBytes(n int) Option {
+	return func(c *config) {
+		c.maxPromptBytes = n
+	}
+}
+
+// RunReader is like Run but reads the prompt from r, so a large prompt,
+// such as a file or a generated report, need not be built as a string
+// first. The body is escaped into the CLI message as it is read and is not
+// kept, so the Result's Prompt and OriginalPrompt are empty.
+//
+// UserPromptSubmit hooks, cost estimation, and PromptBudget need the
+// prompt text. When any is configured, r is read into a string first and the run
+// proceeds as Run would, with the Result's prompt fields set.
+//
+// Example:
+//
+//	f, _ := os.Open("report.md")
+//	defer f.Close()
+//	result, err := a.RunReader(ctx, f)
+func (a *Agent) RunReader(ctx context.Context, r io.Reader, opts ...RunOption) (*Result, error) {
+	return a.run(ctx, promptSource{body: r}, opts, nil)
+}
+
+// StreamReader is like Stream but reads the prompt from r, as RunReader
+// does. A read error or a *PromptTooLargeError is delivered as an *Error
+// before the channel closes.
+func (a *Agent) StreamReader(ctx context.Context, r io.Reader, opts ...RunOption) <-chan Message {
+	return a.stream(ctx, promptSource{body: r}, opts).Messages()
+}
+
+// promptSource is the prompt of a run: text, or a body read from a reader.
+type promptSource struct {
+	text string
+	body io.Reader
+}
+
+// checkPromptSize reports a text prompt over MaxPromptBytes. Readers are
+// checked as they are read.
+func (a *Agent) checkPromptSize(src promptSource) error {
+	max := a.cfg.maxPromptBytes
+	if src.body != nil || max <= 0 || len(src.text) <= max {
+		return nil
+	}
+	return &PromptTooLargeError{Size: len(src.text), Max: max}
+}
+
+// needsPromptText reports whether the run must see the prompt as a string:
+// UserPromptSubmit hooks may rewrite it, cost estimates and PromptBudget
+// count it, and SchemaFallbackPrompt appends to it.
+func (a *Agent) needsPromptText() bool {
+	hooks := a.promptSubmitChain != nil && len(a.cfg.userPromptSubmitHooks) > 0
+	return hooks || a.costs != nil || a.cfg.promptBudget != nil || a.cfg.schemaInPrompt()
+}
+
+// readPrompt reads r into a string, up to max bytes (0 = no limit).
+func readPrompt(r io.Reader, max int) (string, error) {
+	var b strings.Builder
+	if n, ok := readerLen(r); ok {
+		if max > 0 && n > max {
+			return "", &PromptTooLargeError{Size: n, Max: max}
+		}
+		b.Grow(n)
+	}
+	src := r
+	if max > 0 {
+		src = io.LimitReader(r, int64(max)+1)
+	}
+	if _, err := io.Copy(&b, src); err != nil {
+		return "", err
+	}
+	if max > 0 && b.Len() > max {
+		return "", &PromptTooLargeError{Size: b.Len(), Max: max}
+	}
+	return b.String(), nil
+}
+
+// readerLen returns the unread length of r, if r reports one, as
+// bytes.Reader, bytes.Buffer, and strings.Reader do.
+func readerLen(r io.Reader) (int, bool) {
+	if l, ok := r.(interface{ Len() int }); ok {
+		return l.Len(), true
+	}
+	return 0, false
+}
+
+// marshalUserMessage returns the user message line for a text prompt.
+// Every user message, including a prompt reader's, is written by
+// appending the escaped text between userMessageStart and userMessageEnd,
+// so the same prompt is the same bytes however it is sent and in every SDK
+// version; testdata/user_message.golden.jsonl pins them. A field the CLI
+// adds to user messages later must be written only for CLI versions that
+// accept it, as cliFeatures does for flags, since older CLIs may reject
+// fields they do not know.
+func marshalUserMessage(text string) []byte {
+	buf := make([]byte, 0, len(userMessageStart)+len(text)+len(text)/32+len(userMessageEnd))
+	buf = append(buf, userMessageStart...)
+	buf = appendEscaped(buf, []byte(text))
+	return append(buf, userMessageEnd...)
+}
+
+// userMessageStart and userMessageEnd enclose the escaped prompt text in
+// a user message line, with its fields in a fixed order.
+const (
+	userMessageStart = `{"type":"user","message":{"role":"user","content":[{"type":"text","text":"`
+	userMessageEnd   = `"}]}}` + "\n"
+)
+
+// promptChunkSize is how much of a prompt reader is read at a time.
+const promptChunkSize = 32 << 10
+
+// encodeUserMessage returns the user message line for a prompt of prefix
+// followed by the contents of r, and the number of bytes read from r. The
+// body is escaped into the line as it is read, so it is held once rather
+// than as a string and again as JSON. Invalid UTF-8 becomes U+FFFD, as
+// json.Marshal does. More than max bytes (0 = no limit) is a
+// *PromptTooLargeError.
+func encodeUserMessage(prefix string, r io.Reader, max int) ([]byte, int, error) {
+	size := len(userMessageStart) + len(prefix) + len(userMessageEnd)
+	if n, ok := readerLen(r); ok {
+		if max > 0 && n > max {
+			return nil, 0, &PromptTooLargeError{Size: n, Max: max}
+		}
+		// Leave a little room for escapes
+		size += n + n/32
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, userMessageStart...)
+	buf = appendEscaped(buf, []byte(prefix))
+	if max > 0 {
+		r = io.LimitReader(r, int64(max)+1)
+	}
+
+	chunk := make([]byte, promptChunkSize)
+	pending := 0 // Bytes of an incomplete rune carried into the next read
+	total := 0
+	for {
+		n, err := r.Read(chunk[pending:])
+		total += n
+		if max > 0 && total > max {
+			return nil, total, &PromptTooLargeError{Size: total, Max: max}
+		}
+		if err != nil && err != io.EOF {
+			return nil, total, err
+		}
+		eof := err == io.EOF
+		data := chunk[:pending+n]
+		done := escapedPrefixLen(data, eof)
+		buf = appendEscaped(buf, data[:done])
+		pending = copy(chunk, data[done:])
+		if eof {
+			break
+		}
+	}
+	return append(buf, userMessageEnd...), total, nil
+}
+
+// escapedPrefixLen returns how much of data can be escaped now: all of it,
+// less an incomplete rune at the end that the next read may finish.
+func escapedPrefixLen(data []byte, eof bool) int {
+	if eof {
+		return len(data)
+	}
+	// A rune is at most utf8.UTFMax bytes; look back for its start
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendEscaped appends data to buf escaped as the contents of a JSON
+// string: quotes, backslashes, control characters, <, >, &, U+2028, and
+// U+2029 are escaped, and each invalid byte is written as \ufffd. Runs of
+// bytes that need no escape are copied at once.
+func appendEscaped(buf, data []byte) []byte {
+	start := 0
+	for i := 0; i < len(data); {
+		c := data[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, data[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, n := utf8.DecodeRune(data[i:])
+		switch {
+		case r == utf8.RuneError && n == 1:
+			buf = append(buf, data[start:i]...)
+			buf = append(buf, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			// Valid in JSON strings, but line terminators to JavaScript
+			buf = append(buf, data[start:i]...)
+			buf = append(buf, `\u202`...)
+			buf = append(buf, hexDigits[r&0xf])
+		default:
+			i += n
+			continue
+		}
+		i += n
+		start = i
+	}
+	return append(buf, data[start:]...)
+}
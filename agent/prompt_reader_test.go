@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncodeUserMessage(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		body   string
+	}{
+		{"plain", "", "What is 2 + 2?"},
+		{"escapes", "", "quote \" backslash \\ newline \n tab \t bell \a nul \x00"},
+		{"multibyte", "", "héllo 世界 🎉"},
+		{"invalid utf8", "", "bad \xff\xfe byte and cut \xe4\xb8"},
+		{"html", "", "<b>a & b</b>"},
+		{"prefix", "<preserved>\nsummary\n</preserved>\n", "next step"},
+	}
+	readers := map[string]func(string) io.Reader{
+		"whole":    func(s string) io.Reader { return strings.NewReader(s) },
+		"one byte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+	}
+	for _, tt := range tests {
+		for rname, reader := range readers {
+			t.Run(tt.name+"/"+rname, func(t *testing.T) {
+				got, n, err := encodeUserMessage(tt.prefix, reader(tt.body), 0)
+				if err != nil {
+					t.Fatalf("encodeUserMessage() error = %v", err)
+				}
+				if n != len(tt.body) {
+					t.Errorf("bytes read = %d, want %d", n, len(tt.body))
+				}
+				want := marshalUserMessage(tt.prefix + tt.body)
+				if !bytes.Equal(got, want) {
+					t.Errorf("message = %s, want the text prompt's %s", got, want)
+				}
+				var gotMsg, wantMsg userMessage
+				if err := json.Unmarshal(got, &gotMsg); err != nil {
+					t.Fatalf("message %q is not valid JSON: %v", got, err)
+				}
+				if err := json.Unmarshal(want, &wantMsg); err != nil {
+					t.Fatal(err)
+				}
+				if gotText, wantText := gotMsg.Message.Content[0].Text, wantMsg.Message.Content[0].Text; gotText != wantText {
+					t.Errorf("text = %q, want %q", gotText, wantText)
+				}
+				if !bytes.HasSuffix(got, []byte("\n")) || bytes.Count(got, []byte("\n")) != 1 {
+					t.Errorf("message %q is not a single line", got)
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeUserMessageLimit(t *testing.T) {
+	body := strings.Repeat("x", 100)
+
+	// A reader that reports its length is refused without being read
+	r := strings.NewReader(body)
+	_, _, err := encodeUserMessage("", r, 50)
+	var tooLarge *PromptTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 100 || tooLarge.Max != 50 {
+		t.Errorf("error = %v, want PromptTooLargeError{Size: 100, Max: 50}", err)
+	}
+	if r.Len() != 100 {
+		t.Errorf("reader was read %d bytes, want 0", 100-r.Len())
+	}
+
+	// Other readers are read no further than one byte past the limit
+	counted := &countingReader{r: strings.NewReader(body)}
+	_, _, err = encodeUserMessage("", counted, 50)
+	if !errors.As(err, &tooLarge) || tooLarge.Size != 51 {
+		t.Errorf("error = %v, want PromptTooLargeError with Size 51", err)
+	}
+	if counted.n != 51 {
+		t.Errorf("read %d bytes, want 51", counted.n)
+	}
+
+	// The limit is inclusive
+	if _, _, err := encodeUserMessage("", &countingReader{r: strings.NewReader(body)}, 100); err != nil {
+		t.Errorf("error = %v for a prompt at the limit, want nil", err)
+	}
+}
+
+// TestEncodeUserMessageAllocations guards the point of RunReader: a large
+// prompt is held once, as the escaped message, instead of as a string, a
+// marshaled copy, and a copy with the newline.
+func TestEncodeUserMessageAllocations(t *testing.T) {
+	const size = 4 << 20
+	body := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog.\n"), size/45)
+
+	reader := allocatedBytes(func() {
+		if _, _, err := encodeUserMessage("", bytes.NewReader(body), 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	text := allocatedBytes(func() {
+		_ = marshalUserMessage(string(body))
+	})
+
+	// The message is the body plus one escape per line and a little framing
+	if limit := uint64(len(body)) * 5 / 4; reader > limit {
+		t.Errorf("reader path allocated %d bytes for a %d byte prompt, want at most %d", reader, len(body), limit)
+	}
+	if reader*10 > text*6 {
+		t.Errorf("reader path allocated %d bytes, want well under the string path's %d", reader, text)
+	}
+}
+
+// allocatedBytes returns the bytes allocated while f runs.
+func allocatedBytes(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestRunReader(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	stdinFile := filepath.Join(tmpDir, "stdin.json")
+	script := `#!/bin/sh
+head -n 1 > "` + stdinFile + `"
+echo '{"type":"system","subtype":"init","session_id":"reader-test"}'
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	body := strings.Repeat("line with \"quotes\" and ünïcode\n", 4096)
+	result, err := a.RunReader(ctx, iotest.HalfReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("RunReader() error = %v", err)
+	}
+	if result.ResultText != "Done" {
+		t.Errorf("ResultText = %q, want Done", result.ResultText)
+	}
+	if result.Prompt != "" {
+		t.Errorf("Prompt = %d bytes, want empty for a streamed body", len(result.Prompt))
+	}
+
+	var msg userMessage
+	if err := json.Unmarshal(mustReadFile(t, stdinFile), &msg); err != nil {
+		t.Fatalf("CLI received invalid JSON: %v", err)
+	}
+	if got := msg.Message.Content[0].Text; got != body {
+		t.Errorf("CLI received %d bytes of prompt, want the %d byte body", len(got), len(body))
+	}
+}
+
+func TestRunReaderWithHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	stdinFile := filepath.Join(tmpDir, "stdin.json")
+	script := `#!/bin/sh
+head -n 1 > "` + stdinFile + `"
+echo '{"type":"system","subtype":"init","session_id":"reader-test"}'
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+		return PromptSubmitResult{UpdatedPrompt: strings.ToUpper(e.Prompt)}
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.RunReader(ctx, strings.NewReader("shout this"))
+	if err != nil {
+		t.Fatalf("RunReader() error = %v", err)
+	}
+	if result.OriginalPrompt != "shout this" || result.Prompt != "SHOUT THIS" {
+		t.Errorf("OriginalPrompt, Prompt = %q, %q; want the materialized and hooked prompt", result.OriginalPrompt, result.Prompt)
+	}
+
+	var msg userMessage
+	if err := json.Unmarshal(mustReadFile(t, stdinFile), &msg); err != nil {
+		t.Fatalf("CLI received invalid JSON: %v", err)
+	}
+	if got := msg.Message.Content[0].Text; got != "SHOUT THIS" {
+		t.Errorf("CLI received %q, want the hooked prompt", got)
+	}
+}
+
+func TestMaxPromptBytes(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	stdinFile := filepath.Join(tmpDir, "stdin.json")
+	script := `#!/bin/sh
+cat > "` + stdinFile + `"
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), MaxPromptBytes(10))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var tooLarge *PromptTooLargeError
+	if _, err := a.Run(ctx, "more than ten bytes"); !errors.As(err, &tooLarge) {
+		t.Errorf("Run() error = %v, want PromptTooLargeError", err)
+	}
+	if _, err := a.RunReader(ctx, iotest.OneByteReader(strings.NewReader("more than ten bytes"))); !errors.As(err, &tooLarge) {
+		t.Errorf("RunReader() error = %v, want PromptTooLargeError", err)
+	}
+
+	var streamErr error
+	for msg := range a.StreamReader(ctx, strings.NewReader("more than ten bytes")) {
+		if e, ok := msg.(*Error); ok {
+			streamErr = e.Err
+		}
+	}
+	if !errors.As(streamErr, &tooLarge) || tooLarge.Size != 19 {
+		t.Errorf("StreamReader() error = %v, want PromptTooLargeError with Size 19", streamErr)
+	}
+
+	// Nothing reached the CLI
+	mustClose(t, a)
+	if data := mustReadFile(t, stdinFile); len(data) != 0 {
+		t.Errorf("CLI received %q, want nothing", data)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
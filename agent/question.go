@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// QuestionToolName is the name of the tool registered by QuestionTool.
+const QuestionToolName = "ask_user"
+
+// DefaultQuestionTimeout is how long QuestionTool waits for an answer.
+const DefaultQuestionTimeout = 5 * time.Minute
+
+// Question is a clarifying question Claude asks through QuestionTool.
+type Question struct {
+	Text      string   `json:"question"`
+	Choices   []string `json:"choices,omitempty"` // Suggested answers; empty for a free-form question
+	Default   string   `json:"default,omitempty"` // The answer Claude assumes if asked to choose
+	ToolUseID string   `json:"-"`
+}
+
+// Answer is the application's reply to a Question. It is sent to Claude as
+// the tool result, as JSON.
+type Answer struct {
+	Text string `json:"answer,omitempty"`
+	// Declined tells Claude no answer will be given and it should decide
+	// for itself, e.g. with the question's default.
+	Declined bool `json:"declined,omitempty"`
+}
+
+// QuestionHandler answers a Question. It may block, e.g. while a user
+// decides, but should return when ctx is done.
+type QuestionHandler func(ctx context.Context, q Question) (Answer, error)
+
+// QuestionOption configures QuestionTool.
+type QuestionOption func(*questionTool)
+
+// QuestionTimeout sets how long to wait for the handler. The default is
+// DefaultQuestionTimeout. A timeout of 0 waits indefinitely.
+func QuestionTimeout(d time.Duration) QuestionOption {
+	return func(t *questionTool) {
+		t.timeout = d
+	}
+}
+
+// QuestionTool lets Claude ask the application clarifying questions during
+// a run, such as which environment to deploy to, without ending the turn.
+// It registers a custom tool named QuestionToolName that takes the
+// question, optional choices, and an optional default, and passes them to
+// handler with the Stream context. The Answer is the tool result. A handler
+// error or a timeout is sent as an error result with the reason, so Claude
+// can carry on without an answer.
+//
+// Each question emits a question.asked audit event, followed by
+// question.answered or question.failed.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.QuestionTool(func(ctx context.Context, q agent.Question) (agent.Answer, error) {
+//	    choice, err := ui.Ask(ctx, q.Text, q.Choices)
+//	    return agent.Answer{Text: choice}, err
+//	}))
+func QuestionTool(handler QuestionHandler, opts ...QuestionOption) Option {
+	t := &questionTool{handler: handler, timeout: DefaultQuestionTimeout}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return CustomTool(t)
+}
+
+// questionTool is the tool registered by QuestionTool.
+type questionTool struct {
+	handler QuestionHandler
+	timeout time.Duration
+}
+
+func (t *questionTool) Name() string { return QuestionToolName }
+
+func (t *questionTool) Description() string {
+	return "Ask the user a clarifying question and wait for the answer. Use it when the task needs a decision " +
+		"or information you cannot find yourself, such as which environment to use. Offer choices when the " +
+		"answer is one of a few options. The result is a JSON object with the answer, or declined: true if " +
+		"the user will not answer."
+}
+
+func (t *questionTool) InputSchema() map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"question": map[string]any{
+				"type":        "string",
+				"description": "The question to ask",
+			},
+			"choices": map[string]any{
+				"type":        "array",
+				"items":       map[string]any{"type": "string"},
+				"description": "Suggested answers, if the answer is one of a few options",
+			},
+			"default": map[string]any{
+				"type":        "string",
+				"description": "The answer you will assume if the user does not choose",
+			},
+		},
+		"required": []string{"question"},
+	}
+}
+
+func (t *questionTool) Execute(ctx context.Context, input map[string]any) (any, error) {
+	q, err := parseQuestion(input)
+	if err != nil {
+		return nil, err
+	}
+	call, _ := ctx.Value(customToolKey{}).(customToolCall)
+	q.ToolUseID = call.toolUseID
+	call.emit("question.asked", map[string]any{
+		"tool_use_id": q.ToolUseID,
+		"question":    q.Text,
+		"choices":     q.Choices,
+		"default":     q.Default,
+	})
+
+	parent := ctx
+	var cancel context.CancelFunc
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(parent, t.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+
+	type reply struct {
+		answer Answer
+		err    error
+	}
+	replies := make(chan reply, 1) // Buffered so a late answer does not leak the goroutine
+	start := time.Now()
+	go func() {
+		answer, err := t.handler(ctx, q)
+		replies <- reply{answer, err}
+	}()
+
+	var r reply
+	select {
+	case r = <-replies:
+	case <-ctx.Done():
+		r.err = errors.New("question cancelled")
+		if parent.Err() == nil {
+			r.err = fmt.Errorf("no answer within %s", t.timeout)
+		}
+	}
+	duration := time.Since(start)
+
+	if r.err != nil {
+		call.emit("question.failed", map[string]any{
+			"tool_use_id": q.ToolUseID,
+			"error":       r.err.Error(),
+			"duration":    duration.String(),
+		})
+		return nil, &ToolError{ToolName: QuestionToolName, Message: "no answer", Cause: r.err}
+	}
+	call.emit("question.answered", map[string]any{
+		"tool_use_id": q.ToolUseID,
+		"answer":      r.answer.Text,
+		"declined":    r.answer.Declined,
+		"duration":    duration.String(),
+	})
+	return r.answer, nil
+}
+
+// parseQuestion reads a Question from the tool input.
+func parseQuestion(input map[string]any) (Question, error) {
+	var q Question
+	q.Text, _ = input["question"].(string)
+	if q.Text == "" {
+		return q, &ToolError{ToolName: QuestionToolName, Message: "input has no question"}
+	}
+	q.Default, _ = input["default"].(string)
+	choices, _ := input["choices"].([]any)
+	for _, c := range choices {
+		if s, ok := c.(string); ok {
+			q.Choices = append(q.Choices, s)
+		}
+	}
+	return q, nil
+}
+
+// customToolKey is the context key of the custom tool call a context
+// belongs to.
+type customToolKey struct{}
+
+// customToolCall identifies a custom tool call to the tool.
+type customToolCall struct {
+	agent     *Agent
+	toolUseID string
+}
+
+// emit emits an audit event for the call, if it belongs to an agent.
+func (c customToolCall) emit(eventType string, data map[string]any) {
+	if c.agent == nil {
+		return
+	}
+	c.agent.auditor.emit(c.agent.SessionID(), eventType, data)
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQuestionTool(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	responses := filepath.Join(tmpDir, "responses.jsonl")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"question-test"}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"ask_user","tool_input":{"question":"Which environment?","choices":["staging","production"],"default":"staging"}}'
+read response
+printf '%s\n' "$response" >> ` + responses + `
+printf '%s\n' '{"type":"control","request_id":"req-2","tool_use_id":"tu-2","tool_name":"ask_user","tool_input":{"question":"Are you sure?"}}'
+read response
+printf '%s\n' "$response" >> ` + responses + `
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var asked []Question
+	handler := func(ctx context.Context, q Question) (Answer, error) {
+		mu.Lock()
+		asked = append(asked, q)
+		mu.Unlock()
+		if q.ToolUseID == "tu-2" {
+			<-ctx.Done() // Nobody answers
+			return Answer{}, ctx.Err()
+		}
+		return Answer{Text: "production"}, nil
+	}
+
+	var events []AuditEvent
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		QuestionTool(handler, QuestionTimeout(20*time.Millisecond)),
+		Audit(func(e AuditEvent) {
+			if strings.HasPrefix(e.Type, "question.") {
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "deploy"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []Question{
+		{Text: "Which environment?", Choices: []string{"staging", "production"}, Default: "staging", ToolUseID: "tu-1"},
+		{Text: "Are you sure?", ToolUseID: "tu-2"},
+	}
+	if !reflect.DeepEqual(asked, want) {
+		t.Errorf("handler got %+v, want %+v", asked, want)
+	}
+
+	var sent []customToolResponse
+	for _, line := range strings.Split(strings.TrimSpace(string(mustReadFile(t, responses))), "\n") {
+		var resp customToolResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("response %q does not parse: %v", line, err)
+		}
+		sent = append(sent, resp)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("sent %d responses, want 2", len(sent))
+	}
+	if answer, _ := sent[0].Result.(map[string]any); sent[0].IsError || answer["answer"] != "production" {
+		t.Errorf("first response = %+v, want the answer", sent[0])
+	}
+	if reason, _ := sent[1].Result.(string); !sent[1].IsError || !strings.Contains(reason, "no answer within 20ms") {
+		t.Errorf("second response = %+v, want an error result with the timeout", sent[1])
+	}
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	wantTypes := []string{"question.asked", "question.answered", "question.asked", "question.failed"}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("audit events = %v, want %v", types, wantTypes)
+	}
+}
+
+func TestQuestionToolExecute(t *testing.T) {
+	tool := &questionTool{
+		handler: func(ctx context.Context, q Question) (Answer, error) {
+			if q.Text == "fail" {
+				return Answer{}, errors.New("user closed the window")
+			}
+			return Answer{Declined: true}, nil
+		},
+	}
+
+	if _, err := tool.Execute(context.Background(), map[string]any{"choices": []any{"a"}}); err == nil {
+		t.Error("Execute() without a question succeeded, want an error")
+	}
+	_, err := tool.Execute(context.Background(), map[string]any{"question": "fail"})
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) || !strings.Contains(err.Error(), "user closed the window") {
+		t.Errorf("Execute() error = %v, want a ToolError with the handler's reason", err)
+	}
+
+	result, err := tool.Execute(context.Background(), map[string]any{"question": "Proceed?"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	data, _ := json.Marshal(result)
+	if string(data) != `{"declined":true}` {
+		t.Errorf("result = %s, want a declined answer", data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	release := make(chan struct{})
+	defer close(release)
+	blocking := &questionTool{handler: func(ctx context.Context, q Question) (Answer, error) {
+		<-release // Ignores ctx; Execute must not wait
+		return Answer{}, nil
+	}}
+	if _, err := blocking.Execute(ctx, map[string]any{"question": "Hello?"}); err == nil || !strings.Contains(err.Error(), "question cancelled") {
+		t.Errorf("Execute() after cancel error = %v, want cancelled", err)
+	}
+}
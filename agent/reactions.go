@@ -0,0 +1,114 @@
+package agent
+
+import "strings"
+
+// DefaultMaxReactionsPerRun is how many times ReactOnToolResult matchers
+// may fire in a single run unless MaxReactionsPerRun says otherwise.
+const DefaultMaxReactionsPerRun = 3
+
+// ToolResultReaction inspects a completed tool call. Returning a hint and
+// true sends the hint to Claude as a user message.
+type ToolResultReaction func(*ToolCall, *ToolResultContext) (hint string, ok bool)
+
+// ReactOnToolResult adds a matcher that is called with each tool result,
+// after the PostToolUse hooks. When it returns (hint, true), the hint is
+// written to the CLI as a user message straight away, instead of leaving
+// Claude to work out the failure itself. Claude answers it with a turn of
+// its own after the current one, and the run ends with that turn's Result.
+// If the message cannot be written, the hint is put in front of the next
+// prompt instead.
+//
+// Matchers are called in the order added, and every one that fires adds
+// its hint; the hints for one tool result are sent together, separated by
+// blank lines. Each firing emits a hook.reaction audit event and counts
+// toward MaxReactionsPerRun, which keeps a hint that does not help from
+// firing on every retry. Matchers are called synchronously, even with
+// AsyncHooks, and must not block.
+//
+// Example:
+//
+//	agent.ReactOnToolResult(func(tc *agent.ToolCall, tr *agent.ToolResultContext) (string, bool) {
+//	    if tc.Name == agent.ToolBash && strings.Contains(fmt.Sprint(tr.Content), "go: module not found") {
+//	        return "Run `go mod tidy` before building again.", true
+//	    }
+//	    return "", false
+//	})
+func ReactOnToolResult(matcher ToolResultReaction) Option {
+	return func(c *config) {
+		c.reactions = append(c.reactions, matcher)
+	}
+}
+
+// MaxReactionsPerRun sets how many times ReactOnToolResult matchers may
+// fire in a single run. Once the limit is reached, further firings are
+// reported with a hook.reaction audit event marked capped, and their hints
+// are not sent. A value of 0 means unlimited. Default:
+// DefaultMaxReactionsPerRun.
+func MaxReactionsPerRun(n int) Option {
+	return func(c *config) {
+		c.maxReactions = n
+	}
+}
+
+// react calls the ReactOnToolResult matchers on a tool result and sends
+// the hints of those that fire within the run's limit.
+func (a *Agent) react(tc *ToolCall, tr *ToolResultContext) {
+	if len(a.cfg.reactions) == 0 {
+		return
+	}
+	var hints []string
+	for i, matcher := range a.cfg.reactions {
+		hint, ok := matcher(tc, tr)
+		if !ok {
+			continue
+		}
+		a.mu.Lock()
+		capped := a.cfg.maxReactions > 0 && a.runReactions >= a.cfg.maxReactions
+		if !capped {
+			a.runReactions++
+		}
+		fired := a.runReactions
+		a.mu.Unlock()
+
+		a.auditor.emit(a.SessionID(), "hook.reaction", map[string]any{
+			"tool":          tc.Name,
+			"tool_use_id":   tr.ToolUseID,
+			"matcher":       i,
+			"hint":          hint,
+			"capped":        capped,
+			"run_reactions": fired,
+			"max_reactions": a.cfg.maxReactions,
+		})
+		if !capped {
+			hints = append(hints, hint)
+		}
+	}
+	if len(hints) == 0 {
+		return
+	}
+
+	hint := strings.Join(hints, "\n\n")
+	proc, _, _ := a.transport()
+	if err := proc.write(marshalUserMessage(hint)); err == nil {
+		a.oweResult() // Claude answers the hint with a turn of its own
+	} else {
+		// Too late for this turn; Claude reads it with the next prompt
+		a.mu.Lock()
+		a.pendingHints = append(a.pendingHints, hint)
+		a.mu.Unlock()
+		a.auditor.emit(a.SessionID(), "hook.reaction_deferred", map[string]any{
+			"tool":        tc.Name,
+			"tool_use_id": tr.ToolUseID,
+			"error":       err.Error(),
+		})
+	}
+}
+
+// withReactionHints puts hints that could not be sent during their turn in
+// front of prompt.
+func withReactionHints(hints []string, prompt string) string {
+	if len(hints) == 0 {
+		return prompt
+	}
+	return strings.Join(hints, "\n\n") + "\n\n" + prompt
+}
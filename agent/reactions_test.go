@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReactOnToolResult(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	// Three builds fail in the first run and one in the second; the line
+	// read after a failure is the hint, if one is sent. Claude answers each
+	// hint with a turn of its own once the current turn ends.
+	useBuild := `printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"ID","name":"Bash","input":{"command":"go build"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"ID","content":"go: module not found","is_error":true}]}}'
+`
+	result := `printf '%s\n' '{"type":"result","result":"TEXT","num_turns":1}'
+`
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"react-test"}'
+` + strings.ReplaceAll(useBuild, "ID", "tu-1") + `read line
+printf '%s\n' "$line" >> ` + wire + `
+` + strings.ReplaceAll(useBuild, "ID", "tu-2") + `read line
+printf '%s\n' "$line" >> ` + wire + `
+` + strings.ReplaceAll(useBuild, "ID", "tu-3") +
+		strings.ReplaceAll(result, "TEXT", "Done") +
+		strings.ReplaceAll(result, "TEXT", "Tidied") +
+		strings.ReplaceAll(result, "TEXT", "Built") + `read line
+printf '%s\n' "$line" >> ` + wire + `
+` + strings.ReplaceAll(useBuild, "ID", "tu-4") + `read line
+printf '%s\n' "$line" >> ` + wire + `
+` + strings.ReplaceAll(result, "TEXT", "Done") +
+		strings.ReplaceAll(result, "TEXT", "Built again") + `read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var events []map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		ReactOnToolResult(func(tc *ToolCall, tr *ToolResultContext) (string, bool) {
+			if tc.Name == ToolBash && strings.Contains(fmt.Sprint(tr.Content), "go: module not found") {
+				return "Run go mod tidy first.", true
+			}
+			return "", false
+		}),
+		ReactOnToolResult(func(tc *ToolCall, tr *ToolResultContext) (string, bool) {
+			return "", false
+		}),
+		MaxReactionsPerRun(2),
+		Audit(func(e AuditEvent) {
+			if e.Type == "hook.reaction" {
+				mu.Lock()
+				events = append(events, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	// Each run ends with the reply to its last hint, counting every turn
+	first, err := a.Run(ctx, "build it")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if first.ResultText != "Built" || first.NumTurns != 3 {
+		t.Errorf("first Run() = %q in %d turns, want %q in 3", first.ResultText, first.NumTurns, "Built")
+	}
+	second, err := a.Run(ctx, "build it again")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if second.ResultText != "Built again" || second.NumTurns != 2 {
+		t.Errorf("second Run() = %q in %d turns, want %q in 2", second.ResultText, second.NumTurns, "Built again")
+	}
+
+	// The third failure is past the limit and sends nothing, so the next
+	// line read is the second prompt; the limit starts over with it
+	hint := `{"type":"user","message":{"role":"user","content":[{"type":"text","text":"Run go mod tidy first."}]}}`
+	prompt := `{"type":"user","message":{"role":"user","content":[{"type":"text","text":"build it again"}]}}`
+	want := strings.Join([]string{hint, hint, prompt, hint}, "\n") + "\n"
+	if got := string(mustReadFile(t, wire)); got != want {
+		t.Errorf("wire =\n%s\nwant\n%s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 4 {
+		t.Fatalf("got %d hook.reaction events, want 4", len(events))
+	}
+	for i, wantCapped := range []bool{false, false, true, false} {
+		if events[i]["capped"] != wantCapped || events[i]["tool"] != "Bash" || events[i]["hint"] != "Run go mod tidy first." {
+			t.Errorf("event %d = %v, want Bash hint with capped %v", i, events[i], wantCapped)
+		}
+	}
+}
+
+func TestWithReactionHints(t *testing.T) {
+	if got := withReactionHints(nil, "go on"); got != "go on" {
+		t.Errorf("withReactionHints(nil) = %q, want the prompt", got)
+	}
+	if got := withReactionHints([]string{"a", "b"}, "go on"); got != "a\n\nb\n\ngo on" {
+		t.Errorf("withReactionHints() = %q", got)
+	}
+}
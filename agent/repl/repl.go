@@ -0,0 +1,269 @@
+// Package repl provides an interactive read-eval-print loop on top of an
+// agent.Agent, for quick local experiments from a terminal.
+//
+// The loop reads a line, streams it to the agent, and renders text as it
+// arrives. Lines starting with "/" are commands:
+//
+//	/cost  show cumulative cost, turns, and tokens
+//	/help  list commands
+//	/quit  exit the loop
+//
+// An interrupt (Ctrl-C) cancels the response in progress rather than
+// exiting; while the loop waits for input, Ctrl-C exits as usual. Input and output are plain io.Reader and io.Writer values, so the
+// loop can be driven by buffers in tests.
+//
+// Example:
+//
+//	a, err := agent.New(ctx, agent.Model("sonnet"))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer a.Close()
+//
+//	if err := repl.Run(ctx, a, repl.EchoTools(true)); err != nil {
+//	    log.Fatal(err)
+//	}
+package repl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// config holds the REPL configuration.
+type config struct {
+	in         io.Reader
+	out        io.Writer
+	prompt     string
+	echoTools  bool
+	interrupts <-chan os.Signal
+}
+
+// Option configures the REPL.
+type Option func(*config)
+
+// Input sets the reader that prompts are read from (default os.Stdin).
+func Input(r io.Reader) Option {
+	return func(c *config) {
+		c.in = r
+	}
+}
+
+// Output sets the writer that responses are rendered to (default os.Stdout).
+func Output(w io.Writer) Option {
+	return func(c *config) {
+		c.out = w
+	}
+}
+
+// Prompt sets the prefix printed before each input line (default "> ").
+func Prompt(prefix string) Option {
+	return func(c *config) {
+		c.prompt = prefix
+	}
+}
+
+// EchoTools prints each tool call, with its input, as Claude makes it.
+func EchoTools(enabled bool) Option {
+	return func(c *config) {
+		c.echoTools = enabled
+	}
+}
+
+// Interrupts sets the channel that interrupts the response in progress.
+// By default the REPL listens for os.Interrupt (Ctrl-C) while a response
+// streams, and leaves it to the default handler between responses.
+func Interrupts(ch <-chan os.Signal) Option {
+	return func(c *config) {
+		c.interrupts = ch
+	}
+}
+
+// totals accumulates usage across the session.
+type totals struct {
+	runs   int
+	turns  int
+	cost   float64
+	input  int
+	output int
+}
+
+// Run reads prompts from the input and streams the agent's responses to the
+// output until /quit, end of input, or ctx is cancelled. It returns nil on
+// /quit and end of input, and ctx.Err() on cancellation. Errors from
+// individual prompts are printed and the loop continues.
+func Run(ctx context.Context, a *agent.Agent, opts ...Option) error {
+	cfg := &config{
+		in:     os.Stdin,
+		out:    os.Stdout,
+		prompt: "> ",
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	r := &repl{cfg: cfg, agent: a, in: bufio.NewReader(cfg.in)}
+	return r.loop(ctx)
+}
+
+// repl is the state of a running loop.
+type repl struct {
+	cfg   *config
+	agent *agent.Agent
+	in    *bufio.Reader
+	total totals
+	errs  []error // Errors streamed by the response in progress
+}
+
+// loop runs the read-eval-print loop.
+func (r *repl) loop(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r.printf("%s", r.cfg.prompt)
+		line, err := r.in.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		eof := err != nil
+
+		input := strings.TrimSpace(line)
+		switch {
+		case input == "":
+		case strings.HasPrefix(input, "/"):
+			if quit := r.command(input); quit {
+				return nil
+			}
+		default:
+			if err := r.send(ctx, input); err != nil {
+				return err
+			}
+		}
+
+		if eof {
+			r.printf("\n")
+			return nil
+		}
+	}
+}
+
+// command executes a slash command and reports whether the loop should exit.
+func (r *repl) command(input string) bool {
+	switch strings.Fields(input)[0] {
+	case "/quit", "/exit":
+		return true
+	case "/cost":
+		t := r.total
+		r.printf("runs: %d, turns: %d, cost: $%.4f, tokens: %d in / %d out\n",
+			t.runs, t.turns, t.cost, t.input, t.output)
+	case "/help":
+		r.printf("/cost  show cumulative cost, turns, and tokens\n")
+		r.printf("/help  list commands\n")
+		r.printf("/quit  exit\n")
+	default:
+		r.printf("unknown command %s (try /help)\n", input)
+	}
+	return false
+}
+
+// send streams a prompt and renders the response. An interrupt cancels the
+// response but not the loop. It returns an error only if ctx is cancelled.
+func (r *repl) send(ctx context.Context, prompt string) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Catch Ctrl-C only while the response streams, so it exits when idle
+	interrupts := r.cfg.interrupts
+	if interrupts == nil {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		defer signal.Stop(sig)
+		interrupts = sig
+	}
+
+	interrupted := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-interrupts:
+			close(interrupted)
+			cancel()
+		case <-stop:
+		}
+	}()
+
+	r.errs = nil
+	for msg := range r.agent.Stream(runCtx, prompt) {
+		r.render(msg)
+	}
+
+	select {
+	case <-interrupted:
+		r.printf("\ninterrupted\n")
+		return nil
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := r.agent.Err(); err != nil && !r.streamed(err) {
+		r.errs = append(r.errs, err)
+	}
+	for _, err := range r.errs {
+		r.printf("\nerror: %v\n", err)
+	}
+	return nil
+}
+
+// streamed reports whether err was already streamed as an Error message.
+func (r *repl) streamed(err error) bool {
+	for _, e := range r.errs {
+		if errors.Is(e, err) || e.Error() == err.Error() {
+			return true
+		}
+	}
+	return false
+}
+
+// render writes a single streamed message to the output.
+func (r *repl) render(msg agent.Message) {
+	switch m := msg.(type) {
+	case *agent.Text:
+		r.printf("%s", m.Text)
+	case *agent.ToolUse:
+		if r.cfg.echoTools {
+			input, _ := json.Marshal(m.Input) // Best effort; inputs come from JSON
+			r.printf("\n[tool] %s %s\n", m.Name, input)
+		}
+	case *agent.ToolResult:
+		if r.cfg.echoTools && m.IsError {
+			r.printf("[tool error] %v\n", m.Content)
+		}
+	case *agent.Error:
+		r.errs = append(r.errs, m.Err) // Printed by send with Err, once each
+	case *agent.Result:
+		r.total.runs++
+		r.total.turns += m.NumTurns
+		r.total.cost += m.CostUSD
+		r.total.input += m.Usage.InputTokens
+		r.total.output += m.Usage.OutputTokens
+		r.printf("\n(%d turns, $%.4f)\n", m.NumTurns, m.CostUSD)
+	}
+}
+
+// printf writes formatted output, ignoring write errors.
+func (r *repl) printf(format string, args ...any) {
+	_, _ = fmt.Fprintf(r.cfg.out, format, args...)
+}
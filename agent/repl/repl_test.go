@@ -0,0 +1,179 @@
+package repl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// chatScript answers every prompt with a tool call, a text reply, and a result.
+const chatScript = `#!/bin/sh
+while read line; do
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"repl-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Glob","input":{"pattern":"*.go"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello from Claude"}]}}'
+printf '%s\n' '{"type":"result","result":"Hello from Claude","num_turns":2,"total_cost_usd":0.0125,"usage":{"input_tokens":100,"output_tokens":20}}'
+done
+`
+
+// newTestAgent starts an agent backed by a fake CLI script.
+//
+//nolint:gosec // G306: Test scripts need executable permissions
+func newTestAgent(t *testing.T, script string) *agent.Agent {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	if err := os.WriteFile(fakeClaude, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	a, err := agent.New(context.Background(), agent.CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := a.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	})
+	return a
+}
+
+// runREPL runs the loop over the given input and returns the rendered output.
+func runREPL(t *testing.T, a *agent.Agent, input string, opts ...Option) string {
+	t.Helper()
+	var out bytes.Buffer
+	opts = append([]Option{
+		Input(strings.NewReader(input)),
+		Output(&out),
+		Interrupts(make(chan os.Signal)),
+	}, opts...)
+
+	if err := Run(context.Background(), a, opts...); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	return out.String()
+}
+
+func TestRunRendersResponse(t *testing.T) {
+	a := newTestAgent(t, chatScript)
+
+	out := runREPL(t, a, "hello\n/quit\nnever sent\n")
+
+	if !strings.HasPrefix(out, "> ") {
+		t.Errorf("output should start with prompt, got %q", out)
+	}
+	if !strings.Contains(out, "Hello from Claude") {
+		t.Errorf("output missing response text: %q", out)
+	}
+	if !strings.Contains(out, "(2 turns, $0.0125)") {
+		t.Errorf("output missing turn summary: %q", out)
+	}
+	if strings.Contains(out, "[tool]") {
+		t.Errorf("tool calls should not be echoed by default: %q", out)
+	}
+	if n := strings.Count(out, "Hello from Claude"); n != 1 {
+		t.Errorf("response rendered %d times, want 1 (input after /quit must be ignored)", n)
+	}
+}
+
+func TestRunEchoTools(t *testing.T) {
+	a := newTestAgent(t, chatScript)
+
+	out := runREPL(t, a, "hello\n", EchoTools(true), Prompt("you: "))
+
+	if !strings.Contains(out, `[tool] Glob {"pattern":"*.go"}`) {
+		t.Errorf("output missing tool echo: %q", out)
+	}
+	if !strings.HasPrefix(out, "you: ") {
+		t.Errorf("output should start with custom prompt, got %q", out)
+	}
+}
+
+func TestRunCostCommand(t *testing.T) {
+	a := newTestAgent(t, chatScript)
+
+	out := runREPL(t, a, "/cost\nfirst\nsecond\n/cost\n")
+
+	if !strings.Contains(out, "runs: 0, turns: 0, cost: $0.0000, tokens: 0 in / 0 out") {
+		t.Errorf("output missing initial totals: %q", out)
+	}
+	if !strings.Contains(out, "runs: 2, turns: 4, cost: $0.0250, tokens: 200 in / 40 out") {
+		t.Errorf("output missing cumulative totals: %q", out)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	a := newTestAgent(t, chatScript)
+
+	out := runREPL(t, a, "\n/help\n/bogus\n")
+
+	if !strings.Contains(out, "/quit  exit") {
+		t.Errorf("output missing help: %q", out)
+	}
+	if !strings.Contains(out, "unknown command /bogus") {
+		t.Errorf("output missing unknown command message: %q", out)
+	}
+	if strings.Contains(out, "Hello from Claude") {
+		t.Errorf("commands and blank lines must not be sent as prompts: %q", out)
+	}
+}
+
+func TestRunInterruptDoesNotExit(t *testing.T) {
+	// The CLI never answers the prompt, so only the interrupt ends it
+	a := newTestAgent(t, `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"repl-interrupt"}'
+read line
+exit 0
+`)
+
+	interrupts := make(chan os.Signal, 1)
+	interrupts <- os.Interrupt
+
+	out := runREPL(t, a, "slow question\n/cost\n", Interrupts(interrupts))
+
+	if !strings.Contains(out, "interrupted") {
+		t.Errorf("output missing interrupt notice: %q", out)
+	}
+	if !strings.Contains(out, "runs: 0") {
+		t.Errorf("loop should continue after interrupt: %q", out)
+	}
+}
+
+func TestRunContextCancelled(t *testing.T) {
+	a := newTestAgent(t, chatScript)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	err := Run(ctx, a, Input(strings.NewReader("hello\n")), Output(&out), Interrupts(make(chan os.Signal)))
+	if err != context.Canceled {
+		t.Errorf("Run() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestRunPrintsErrorOnce(t *testing.T) {
+	// Close during the response streams an Error, and Err reports it again
+	a := newTestAgent(t, `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"repl-closed"}'
+sleep 60
+`)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = a.Close()
+	}()
+
+	out := runREPL(t, a, "hello\n")
+
+	if n := strings.Count(out, "error:"); n != 1 {
+		t.Errorf("output has %d errors, want 1: %q", n, out)
+	}
+}
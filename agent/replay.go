@@ -0,0 +1,372 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Replay file operations. A replay file is JSONL with one entry per line.
+const (
+	replayOut   = "out"   // The CLI wrote Line to stdout
+	replayIn    = "in"    // The CLI read a line from stdin
+	replayDelay = "delay" // The CLI paused for MS milliseconds
+	replayExit  = "exit"  // The CLI exited with Code and Stderr
+)
+
+// replayEntry is one line of a replay file.
+type replayEntry struct {
+	Op     string `json:"op"`
+	Line   string `json:"line,omitempty"`   // Line written or read, without the newline
+	AtMS   int64  `json:"at_ms,omitempty"`  // When the entry was recorded, in ms since start
+	MS     int64  `json:"ms,omitempty"`     // Length of a delay
+	Code   int    `json:"code,omitempty"`   // Exit code
+	Stderr string `json:"stderr,omitempty"` // Standard error output at exit
+}
+
+// ReplayOption configures Replay.
+type ReplayOption func(*replayConfig)
+
+// replayConfig holds the settings from Replay.
+type replayConfig struct {
+	path   string
+	timing bool // Wait for each line's recorded time before writing it
+}
+
+// ReplayTiming plays lines back at the times they were recorded, rather
+// than as fast as the agent reads them.
+func ReplayTiming() ReplayOption {
+	return func(c *replayConfig) {
+		c.timing = true
+	}
+}
+
+// Replay plays a session recorded with RecordCLI, or converted with
+// ConvertShellFixture, instead of starting the Claude CLI. The agent sees
+// the recorded stdout lines in order; each time the recording shows the
+// CLI reading from stdin, playback waits until the agent writes a line.
+// The content of written lines is not compared. Once the agent is closed,
+// remaining output is dropped. Replays need no CLI, credentials, or shell,
+// which makes them suited to hermetic tests.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Replay("testdata/session.jsonl"))
+//	result, _ := a.Run(ctx, "list the files")
+func Replay(path string, opts ...ReplayOption) Option {
+	rc := &replayConfig{path: path}
+	for _, opt := range opts {
+		opt(rc)
+	}
+	return func(c *config) {
+		c.replay = rc
+	}
+}
+
+// RecordCLI writes every line exchanged with the CLI to a replay file at
+// path, creating or truncating it. Lines the CLI writes to stdout and
+// lines the agent writes to its stdin are recorded with their times, and
+// a non-zero exit status is recorded when the agent is closed. Thinking
+// content is masked as ThinkingPolicy requires. Play the file back with
+// Replay.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.RecordCLI("testdata/session.jsonl"))
+func RecordCLI(path string) Option {
+	return func(c *config) {
+		c.recordPath = path
+	}
+}
+
+// loadReplay reads and checks a replay file.
+func loadReplay(path string) ([]replayEntry, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- Path provided by caller
+	if err != nil {
+		return nil, &StartError{Reason: "failed to read replay file", Cause: err}
+	}
+
+	var script []replayEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e replayEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, &StartError{Reason: fmt.Sprintf("invalid replay file %s: line %d", path, n), Cause: err}
+		}
+		switch e.Op {
+		case replayOut, replayIn, replayDelay, replayExit:
+		default:
+			return nil, &StartError{Reason: fmt.Sprintf("invalid replay file %s: line %d: unknown op %q", path, n, e.Op)}
+		}
+		script = append(script, e)
+	}
+	return script, nil
+}
+
+// replayer plays a replay file in place of the CLI process.
+type replayer struct {
+	script []replayEntry
+	timing bool
+
+	lines   chan []byte // Stdout lines for the parser; closed when playback ends
+	pending []byte      // Unread rest of the current stdout line
+
+	mu       sync.Mutex
+	received int           // Stdin lines written but not yet read by the script
+	input    chan struct{} // Signalled when stdin lines are written
+
+	stdinClosed chan struct{}
+	closeOnce   sync.Once
+	done        chan struct{}
+	code        int
+	stderr      string
+}
+
+// newReplayer loads the replay file and starts playback.
+func newReplayer(cfg *replayConfig) (*replayer, error) {
+	script, err := loadReplay(cfg.path)
+	if err != nil {
+		return nil, err
+	}
+	r := &replayer{
+		script:      script,
+		timing:      cfg.timing,
+		lines:       make(chan []byte),
+		input:       make(chan struct{}, 1),
+		stdinClosed: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go r.play()
+	return r, nil
+}
+
+// play runs the script. After stdin is closed, output is dropped and only
+// the exit status is still taken from the script.
+func (r *replayer) play() {
+	defer close(r.done)
+	defer close(r.lines)
+
+	start := time.Now()
+	for _, e := range r.script {
+		switch e.Op {
+		case replayOut:
+			if r.timing {
+				r.pause(time.Until(start.Add(time.Duration(e.AtMS) * time.Millisecond)))
+			}
+			line := append([]byte(e.Line), '\n')
+			select {
+			case r.lines <- line:
+			case <-r.stdinClosed:
+			}
+		case replayIn:
+			r.readLine()
+		case replayDelay:
+			r.pause(time.Duration(e.MS) * time.Millisecond)
+		case replayExit:
+			r.code, r.stderr = e.Code, e.Stderr
+		}
+	}
+}
+
+// pause waits for d, or until stdin is closed.
+func (r *replayer) pause(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.stdinClosed:
+	}
+}
+
+// readLine waits for the agent to write a line, or for stdin to close.
+func (r *replayer) readLine() {
+	for {
+		r.mu.Lock()
+		if r.received > 0 {
+			r.received--
+			r.mu.Unlock()
+			return
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.input:
+		case <-r.stdinClosed:
+			return
+		}
+	}
+}
+
+// write counts the lines written to stdin.
+func (r *replayer) write(data []byte) error {
+	select {
+	case <-r.stdinClosed:
+		return os.ErrClosed
+	default:
+	}
+
+	r.mu.Lock()
+	r.received += bytes.Count(data, []byte{'\n'})
+	r.mu.Unlock()
+
+	select {
+	case r.input <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// reader returns the replayed stdout.
+func (r *replayer) reader() io.Reader {
+	return r
+}
+
+// Read returns replayed stdout, and io.EOF once playback has ended.
+func (r *replayer) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		line, ok := <-r.lines
+		if !ok {
+			return 0, io.EOF
+		}
+		r.pending = line
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// close closes stdin, waits for playback to end, and reports a non-zero
+// exit status like the CLI process does.
+func (r *replayer) close() error {
+	r.closeOnce.Do(func() {
+		close(r.stdinClosed)
+	})
+	<-r.done
+	if r.code > 0 {
+		return &ProcessError{ExitCode: r.code, Stderr: r.stderr}
+	}
+	return nil
+}
+
+// exitStatus waits up to d for playback to end and returns the recorded
+// exit code and stderr.
+func (r *replayer) exitStatus(d time.Duration) (code int, stderr string, exited bool) {
+	select {
+	case <-r.done:
+		return r.code, r.stderr, true
+	case <-time.After(d):
+		return 0, "", false
+	}
+}
+
+// recorder writes the lines exchanged with a transport to a replay file.
+type recorder struct {
+	cliTransport
+	out   io.Reader // Stdout, copied to the file as it is read
+	start time.Time
+	mode  ThinkingMode
+
+	mu      sync.Mutex
+	f       *os.File
+	enc     *json.Encoder
+	partial []byte // Stdout read so far past the last newline
+	closed  bool
+}
+
+// newRecorder creates the replay file at path and wraps t, masking
+// thinking content as mode requires.
+func newRecorder(t cliTransport, path string, mode ThinkingMode) (*recorder, error) {
+	f, err := os.Create(path) // #nosec G304 -- Path provided by caller
+	if err != nil {
+		return nil, &StartError{Reason: "failed to create recording", Cause: err}
+	}
+	rec := &recorder{cliTransport: t, start: time.Now(), mode: mode, f: f, enc: json.NewEncoder(f)}
+	rec.enc.SetEscapeHTML(false)
+	rec.out = io.TeeReader(t.reader(), recorderStdout{rec})
+	return rec, nil
+}
+
+// recorderStdout receives stdout as the parser reads it.
+type recorderStdout struct{ rec *recorder }
+
+func (w recorderStdout) Write(p []byte) (int, error) {
+	w.rec.mu.Lock()
+	defer w.rec.mu.Unlock()
+	w.rec.partial = append(w.rec.partial, p...)
+	for {
+		i := bytes.IndexByte(w.rec.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.rec.logLocked(replayOut, string(w.rec.partial[:i]))
+		w.rec.partial = w.rec.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// logLocked appends an entry to the file. Caller must hold rec.mu.
+func (rec *recorder) logLocked(op, line string) {
+	if rec.closed {
+		return
+	}
+	if rec.mode != ThinkingFull {
+		line = string(maskThinking([]byte(line), rec.mode))
+	}
+	_ = rec.enc.Encode(replayEntry{ // Best effort - a failed recording must not fail the run
+		Op:   op,
+		Line: line,
+		AtMS: time.Since(rec.start).Milliseconds(),
+	})
+}
+
+// write records each stdin line before passing it on.
+func (rec *recorder) write(data []byte) error {
+	rec.mu.Lock()
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'}) {
+		rec.logLocked(replayIn, string(line))
+	}
+	rec.mu.Unlock()
+	return rec.cliTransport.write(data)
+}
+
+// reader returns stdout, recording it as it is read.
+func (rec *recorder) reader() io.Reader {
+	return rec.out
+}
+
+// close closes the transport, records its exit status, and closes the file.
+func (rec *recorder) close() error {
+	err := rec.cliTransport.close()
+	code, stderr, exited := rec.cliTransport.exitStatus(time.Second)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closed {
+		return err
+	}
+	if len(rec.partial) > 0 {
+		rec.logLocked(replayOut, string(rec.partial))
+		rec.partial = nil
+	}
+	if exited && (code != 0 || stderr != "") {
+		_ = rec.enc.Encode(replayEntry{Op: replayExit, Code: code, Stderr: stderr}) // Best effort
+	}
+	rec.closed = true
+	if cerr := rec.f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shell fixture commands understood by ConvertShellFixture.
+var (
+	shellEchoPattern   = regexp.MustCompile(`^echo '([^']*)'(\s+1?>&2)?$`)
+	shellPrintfPattern = regexp.MustCompile(`^printf '%s\\n' '([^']*)'(\s+1?>&2)?$`)
+	shellReadPattern   = regexp.MustCompile(`^read(\s+-r)?(\s+\w+)?$`)
+	shellSleepPattern  = regexp.MustCompile(`^sleep\s+([0-9]*\.?[0-9]+)$`)
+	shellExitPattern   = regexp.MustCompile(`^exit\s+([0-9]+)$`)
+)
+
+// ConvertShellFixture converts a fake CLI shell script, as used in tests,
+// into a replay file for Replay. Scripts must be straight-line sh using
+// only these commands:
+//
+//	read [-r] [name]                 the CLI reads a line from stdin
+//	echo '<line>'                    the CLI writes a line to stdout
+//	printf '%s\n' '<line>'           the CLI writes a line to stdout
+//	echo '<text>' >&2                the CLI writes a line to stderr
+//	sleep <seconds>                  the CLI pauses
+//	exit <code>                      the CLI exits
+//
+// Blank lines and comments are ignored. Any other line, including loops,
+// conditionals, and variables, is an error naming its line number.
+func ConvertShellFixture(r io.Reader, w io.Writer) error {
+	var script []replayEntry
+	var stderr strings.Builder
+	code := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+scan:
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if m := shellEchoPattern.FindStringSubmatch(line); m != nil {
+			script = appendShellOutput(script, &stderr, m)
+			continue
+		}
+		if m := shellPrintfPattern.FindStringSubmatch(line); m != nil {
+			script = appendShellOutput(script, &stderr, m)
+			continue
+		}
+		switch {
+		case shellReadPattern.MatchString(line):
+			script = append(script, replayEntry{Op: replayIn})
+		case shellSleepPattern.MatchString(line):
+			secs, err := strconv.ParseFloat(shellSleepPattern.FindStringSubmatch(line)[1], 64)
+			if err != nil {
+				return fmt.Errorf("agent: shell fixture line %d: %w", n, err)
+			}
+			script = append(script, replayEntry{Op: replayDelay, MS: time.Duration(secs * float64(time.Second)).Milliseconds()})
+		case shellExitPattern.MatchString(line):
+			code, _ = strconv.Atoi(shellExitPattern.FindStringSubmatch(line)[1])
+			break scan
+		default:
+			return fmt.Errorf("agent: shell fixture line %d: unsupported command %q", n, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if code != 0 || stderr.Len() > 0 {
+		script = append(script, replayEntry{Op: replayExit, Code: code, Stderr: stderr.String()})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, e := range script {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendShellOutput records an echo or printf match: a stdout line, or
+// stderr text if the command was redirected.
+func appendShellOutput(script []replayEntry, stderr *strings.Builder, m []string) []replayEntry {
+	if m[2] != "" {
+		stderr.WriteString(m[1] + "\n")
+		return script
+	}
+	return append(script, replayEntry{Op: replayOut, Line: m[1]})
+}
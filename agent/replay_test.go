@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayFixture plays testdata/replay/<name>.jsonl.
+func replayFixture(name string, opts ...ReplayOption) Option {
+	return Replay(filepath.Join("testdata", "replay", name+".jsonl"), opts...)
+}
+
+// shellFixtures lists the shell scripts the replay fixtures are converted from.
+func shellFixtures(t *testing.T) []string {
+	t.Helper()
+	scripts, err := filepath.Glob(filepath.Join("testdata", "replay", "*.sh"))
+	if err != nil || len(scripts) == 0 {
+		t.Fatalf("Glob() = %v, %v; want shell fixtures", scripts, err)
+	}
+	return scripts
+}
+
+func TestConvertShellFixtures(t *testing.T) {
+	for _, script := range shellFixtures(t) {
+		script := script
+		t.Run(filepath.Base(script), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ConvertShellFixture(bytes.NewReader(mustReadFile(t, script)), &buf); err != nil {
+				t.Fatalf("ConvertShellFixture() error = %v", err)
+			}
+
+			golden := strings.TrimSuffix(script, ".sh") + ".jsonl"
+			if *updateGolden {
+				if err := os.WriteFile(golden, buf.Bytes(), 0600); err != nil {
+					t.Fatalf("WriteFile() error = %v", err)
+				}
+			}
+			if want := mustReadFile(t, golden); buf.String() != string(want) {
+				t.Errorf("converted %s =\n%s\nwant (run with -update to refresh):\n%s", script, buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestConvertShellFixtureRejectsUnsupported(t *testing.T) {
+	script := "#!/bin/sh\nwhile read line; do\necho '{}'\ndone\n"
+	err := ConvertShellFixture(strings.NewReader(script), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ConvertShellFixture() error = %v, want unsupported command on line 2", err)
+	}
+}
+
+// replayRun streams a prompt and closes the agent, returning the messages
+// with per-run fields cleared, and the error from Close.
+func replayRun(t *testing.T, opts ...Option) ([]Message, error) {
+	t.Helper()
+	ctx := context.Background()
+	a, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var messages []Message
+	for msg := range a.Stream(ctx, "go") {
+		messageMeta(msg).Timestamp = time.Time{}
+		if result, ok := msg.(*Result); ok {
+			result.RunID = ""
+		}
+		messages = append(messages, msg)
+	}
+	if err := a.Err(); err != nil {
+		t.Errorf("Err() = %v", err)
+	}
+	return messages, a.Close()
+}
+
+func TestReplayMatchesShellFixtures(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	for _, script := range shellFixtures(t) {
+		script := script
+		name := strings.TrimSuffix(filepath.Base(script), ".sh")
+		if name == "stream_hang" {
+			continue // Never finishes; covered by TestStreamContextCancellation
+		}
+		t.Run(name, func(t *testing.T) {
+			fakeClaude := filepath.Join(t.TempDir(), "claude")
+			body := strings.Replace(string(mustReadFile(t, script)), "#!/bin/sh", "#!"+sh, 1)
+			mustWriteFile(t, fakeClaude, []byte(body), 0755)
+
+			want, wantErr := replayRun(t, CLIPath(fakeClaude))
+			got, gotErr := replayRun(t, replayFixture(name))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed messages =\n%+v\nwant\n%+v", got, want)
+			}
+			if !reflect.DeepEqual(gotErr, wantErr) {
+				t.Errorf("replayed Close() error = %v, want %v", gotErr, wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordCLI(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "session.jsonl")
+	want, wantErr := replayRun(t, replayFixture("tool_control"), RecordCLI(recording))
+
+	// The recording replays to the same session
+	got, gotErr := replayRun(t, Replay(recording))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed messages =\n%+v\nwant\n%+v", got, want)
+	}
+	var procErr *ProcessError
+	if !errors.As(gotErr, &procErr) || procErr.ExitCode != 3 || !reflect.DeepEqual(gotErr, wantErr) {
+		t.Errorf("replayed Close() error = %v, want %v", gotErr, wantErr)
+	}
+
+	// Both the prompt and the permission response were recorded as stdin lines
+	script, err := loadReplay(recording)
+	if err != nil {
+		t.Fatalf("loadReplay() error = %v", err)
+	}
+	var in []string
+	for _, e := range script {
+		if e.Op == replayIn {
+			in = append(in, e.Line)
+		}
+	}
+	if len(in) != 2 || !strings.Contains(in[0], `"text":"go"`) || !strings.Contains(in[1], `"request_id":"req-1"`) {
+		t.Errorf("recorded stdin = %q, want the prompt and the control response", in)
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slow.jsonl")
+	script := `{"op":"in"}
+{"op":"out","line":"{\"type\":\"system\",\"subtype\":\"init\",\"session_id\":\"timing\"}","at_ms":1}
+{"op":"out","line":"{\"type\":\"result\",\"result\":\"Done\",\"num_turns\":1}","at_ms":200}
+`
+	mustWriteFile(t, path, []byte(script), 0600)
+
+	for _, tt := range []struct {
+		name    string
+		opts    []ReplayOption
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{name: "as fast as read", maxWait: 150 * time.Millisecond},
+		{name: "recorded timing", opts: []ReplayOption{ReplayTiming()}, minWait: 200 * time.Millisecond, maxWait: 5 * time.Second},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			if _, err := replayRun(t, Replay(path, tt.opts...)); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minWait || elapsed > tt.maxWait {
+				t.Errorf("replay took %v, want between %v and %v", elapsed, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
+
+func TestReplayInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.jsonl")
+	mustWriteFile(t, path, []byte(`{"op":"out","line":"{}"}`+"\n"+`{"op":"teleport"}`+"\n"), 0600)
+
+	_, err := New(context.Background(), Replay(path))
+	var startErr *StartError
+	if !errors.As(err, &startErr) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("New() error = %v, want *StartError naming line 2", err)
+	}
+
+	_, err = New(context.Background(), Replay(filepath.Join(t.TempDir(), "missing.jsonl")))
+	if !errors.As(err, &startErr) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("New() error = %v, want *StartError wrapping os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,305 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RestartPolicy limits how often AutoRestart restarts the CLI.
+type RestartPolicy struct {
+	// MaxRestarts is how many restarts are allowed within Window. Once
+	// they are used up, a run started after the CLI exits fails with the
+	// error of the exit, such as a *ProcessError.
+	MaxRestarts int
+	// Window is the period MaxRestarts counts restarts over. 0 counts
+	// them over the agent's lifetime.
+	Window time.Duration
+	// ResumeSession resumes the previous session in the new process. If
+	// the CLI cannot resume it, a new session is started and a
+	// process.resume_failed audit event is emitted.
+	ResumeSession bool
+}
+
+// RestartEvent describes a restart of the CLI by AutoRestart; see
+// OnRestart.
+type RestartEvent struct {
+	// SessionID is the session the new process continues: the previous
+	// session if it was resumed, otherwise "" until the new session's init
+	// message arrives.
+	SessionID         string
+	PreviousSessionID string
+	Resumed           bool
+	Restarts          int   // Restarts within the policy's Window, including this one
+	Cause             error // The exit being healed, such as a *ProcessError
+}
+
+// RestartHook is called after AutoRestart restarts the CLI; see OnRestart.
+type RestartHook func(e *RestartEvent)
+
+// resumeCheckWait is how long a restarted CLI must keep running for
+// resuming the session to count as successful.
+var resumeCheckWait = 250 * time.Millisecond
+
+// AutoRestart starts a new CLI process when the previous one has exited
+// unexpectedly, as after a crash, so that a long-lived agent keeps
+// working. The exit is noticed when the next run starts, whether the
+// process died between runs or during a run, which failed with its
+// *ProcessError. The new process is started before the run's prompt is
+// sent. A run in flight when the process exits is not retried.
+//
+// Everything held by the Agent, such as its turn and cost totals, carries
+// over to the new process. With ResumeSession, the new process resumes the
+// previous session; otherwise, or if resuming fails, it starts a new one.
+// MCPManaged servers keep running. RecordCLI records only the first
+// process, and a Replay is never restarted.
+//
+// Each restart is reported to OnRestart hooks and as a process.restarted
+// audit event. When the policy's restarts are used up, the run fails with
+// the error of the exit, and process.restart_exhausted is emitted.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.AutoRestart(agent.RestartPolicy{MaxRestarts: 3, Window: time.Hour, ResumeSession: true}),
+//	    agent.OnRestart(func(e *agent.RestartEvent) {
+//	        log.Printf("CLI restarted (%d in the last hour): %v", e.Restarts, e.Cause)
+//	    }),
+//	)
+func AutoRestart(policy RestartPolicy) Option {
+	return func(c *config) {
+		c.restartPolicy = &policy
+	}
+}
+
+// OnRestart adds hooks called after AutoRestart restarts the CLI, before
+// the run that noticed the exit sends its prompt.
+func OnRestart(hooks ...RestartHook) Option {
+	return func(c *config) {
+		c.restartHooks = append(c.restartHooks, hooks...)
+	}
+}
+
+// validateRestartPolicy checks the AutoRestart policy.
+func validateRestartPolicy(cfg *config) error {
+	p := cfg.restartPolicy
+	if p == nil {
+		return nil
+	}
+	if p.MaxRestarts < 0 {
+		return &ConfigError{Option: "AutoRestart", Value: fmt.Sprint(p.MaxRestarts), Reason: "MaxRestarts must not be negative"}
+	}
+	if p.Window < 0 {
+		return &ConfigError{Option: "AutoRestart", Value: p.Window.String(), Reason: "Window must not be negative"}
+	}
+	return nil
+}
+
+// restartIfExited starts a new CLI process if AutoRestart is set and the
+// current one has exited. It returns the error of the exit if the policy
+// allows no more restarts, or the error starting the new process.
+func (a *Agent) restartIfExited() error {
+	policy := a.cfg.restartPolicy
+	if policy == nil || a.cfg.replay != nil {
+		return nil
+	}
+	a.restartMu.Lock()
+	defer a.restartMu.Unlock()
+
+	a.mu.Lock()
+	if a.closed || a.evicted != nil || a.runID != "" {
+		a.mu.Unlock()
+		return nil
+	}
+	oldProc, bridge, sessionID := a.proc, a.bridge, a.sessionID
+	a.mu.Unlock()
+	select {
+	case <-bridge.finished:
+	default:
+		return nil // The CLI's output is open, so it is running
+	}
+
+	// The old process is no longer used once this returns
+	cause := a.exitError()
+	now := time.Now()
+	if policy.Window > 0 {
+		kept := a.restarts[:0]
+		for _, t := range a.restarts {
+			if now.Sub(t) < policy.Window {
+				kept = append(kept, t)
+			}
+		}
+		a.restarts = kept
+	}
+	if a.startCtx.Err() != nil {
+		return cause // New's context has ended, and a process would be killed at once
+	}
+	if len(a.restarts) >= policy.MaxRestarts {
+		a.auditor.emit(sessionID, "process.restart_exhausted", map[string]any{
+			"restarts":     len(a.restarts),
+			"max_restarts": policy.MaxRestarts,
+			"window":       policy.Window.String(),
+			"cause":        cause.Error(),
+		})
+		return cause
+	}
+	a.restarts = append(a.restarts, now)
+
+	proc, resumed, err := a.startRestartedProcess(oldProc, sessionID, policy.ResumeSession)
+	if err != nil {
+		err = a.scrub.scrubError(err)
+		a.auditor.emit(sessionID, "process.restart_failed", map[string]any{
+			"restarts": len(a.restarts),
+			"cause":    cause.Error(),
+			"error":    err.Error(),
+		})
+		return err
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		detachMCP(proc)  // Close stops them with the old process
+		_ = proc.close() // Best effort; Close already stopped the agent
+		return nil
+	}
+	old, oldBridge := a.proc, a.bridge
+	a.proc = proc
+	a.bridge = newAgentBridge(a.cfg, a.auditor, proc, a.controls)
+	a.awaitingResult = false
+	a.owedResults = 0
+	a.initSeen = false
+	if !resumed {
+		a.sessionID = ""
+		a.treePrimed = false // A fresh session gets its own file tree
+	}
+	a.mu.Unlock()
+	oldBridge.close()
+	_ = closeExited(old) // The exit was reported as cause
+
+	event := &RestartEvent{
+		PreviousSessionID: sessionID,
+		Resumed:           resumed,
+		Restarts:          len(a.restarts),
+		Cause:             cause,
+	}
+	if resumed {
+		event.SessionID = sessionID
+	}
+	a.auditor.emit(sessionID, "process.restarted", map[string]any{
+		"previous_session_id": sessionID,
+		"session_id":          event.SessionID,
+		"resumed":             resumed,
+		"restarts":            event.Restarts,
+		"cause":               cause.Error(),
+	})
+	if hooks := a.cfg.restartHooks; len(hooks) > 0 {
+		a.runHook(func() {
+			for _, hook := range hooks {
+				hook(event)
+			}
+		})
+	}
+	return nil
+}
+
+// transport returns the CLI process, its bridge, and the session ID.
+// AutoRestart replaces them between runs, so code that may outlive a run,
+// such as a custom tool's goroutine, takes them once and keeps using what
+// it took.
+func (a *Agent) transport() (cliTransport, *bridge, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.proc, a.bridge, a.sessionID
+}
+
+// startRestartedProcess starts a CLI process to replace old, which has
+// exited, resuming sessionID if resume is set and the session is the
+// CLI's. It reports whether the session was resumed. The MCPManaged
+// servers of old are handed to the new process.
+func (a *Agent) startRestartedProcess(old cliTransport, sessionID string, resume bool) (cliTransport, bool, error) {
+	cfg := *a.cfg
+	cfg.resume, cfg.fork, cfg.forkFrom = "", false, nil
+	cfg.recordPath = ""
+	mcp := managedMCPOf(old)
+
+	resumed := resume && sessionID != "" && !isSyntheticSessionID(sessionID)
+	if resumed {
+		cfg.resume = sessionID
+		proc, err := startRestartTransport(a.startCtx, &cfg, mcp)
+		if err != nil {
+			return nil, false, err
+		}
+		code, stderr, exited := proc.exitStatus(resumeCheckWait)
+		if !exited {
+			return proc, true, nil
+		}
+		detachMCP(proc)
+		_ = proc.close() // Its exit is reported below
+		a.auditor.emit(sessionID, "process.resume_failed", map[string]any{
+			"session_id": sessionID,
+			"exit_code":  code,
+			"stderr":     a.scrub.scrub(stderr),
+		})
+		cfg.resume = ""
+	}
+	proc, err := startRestartTransport(a.startCtx, &cfg, mcp)
+	return proc, false, err
+}
+
+// startRestartTransport is startTransport for a restarted CLI, which uses
+// MCPManaged servers that are already running.
+func startRestartTransport(ctx context.Context, cfg *config, mcp *managedMCP) (cliTransport, error) {
+	p, err := startProcess(ctx, mcp.configFor(cfg))
+	if err != nil {
+		return nil, err
+	}
+	p.mcp = mcp
+	if cfg.debugWire != nil {
+		return newWireTransport(p, cfg.debugWire, newScrubber(cfg.scrubPatterns), cfg.thinkingMode), nil
+	}
+	return p, nil
+}
+
+// baseProcess returns the CLI process under t's recorder and wire log, or
+// nil for a replay.
+func baseProcess(t cliTransport) *process {
+	for {
+		switch v := t.(type) {
+		case *process:
+			return v
+		case *recorder:
+			t = v.cliTransport
+		case *wireTransport:
+			t = v.cliTransport
+		default:
+			return nil
+		}
+	}
+}
+
+// managedMCPOf returns the MCPManaged servers run for t's process.
+func managedMCPOf(t cliTransport) *managedMCP {
+	if p := baseProcess(t); p != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.mcp
+	}
+	return nil
+}
+
+// detachMCP keeps closing t from stopping its MCPManaged servers.
+func detachMCP(t cliTransport) {
+	if p := baseProcess(t); p != nil {
+		p.mu.Lock()
+		p.mcp = nil
+		p.mu.Unlock()
+	}
+}
+
+// closeExited closes the transport of a process that has exited, leaving
+// its MCPManaged servers to the process that replaced it.
+func closeExited(t cliTransport) error {
+	detachMCP(t)
+	return t.close()
+}
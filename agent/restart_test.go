@@ -0,0 +1,360 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// restartCLI writes a fake CLI that records its arguments, takes the
+// session it is asked to resume or "sess-N" on its Nth start, and then runs
+// body. The script is in dir with the files it writes.
+func restartCLI(t *testing.T, dir, body string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(dir, "claude")
+	script := `#!/bin/sh
+dir=$(dirname "$0")
+n=$(cat "$dir/count" 2>/dev/null || echo 0)
+n=$((n+1))
+echo $n > "$dir/count"
+echo "$@" >> "$dir/args"
+session="sess-$n"
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--resume" ]; then session="$2"; fi
+	shift
+done
+if [ "$session" != "sess-$n" ] && [ -f "$dir/no-resume" ]; then
+	echo "No conversation found with session ID: $session" >&2
+	exit 1
+fi
+init="{\"type\":\"system\",\"subtype\":\"init\",\"session_id\":\"$session\"}"
+` + body
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+// crashAfterResult answers one prompt, then exits as if it crashed.
+const crashAfterResult = `read line || exit 0
+printf '%s\n' "$init"
+printf '%s\n' '{"type":"result","result":"ok","num_turns":1,"total_cost_usd":0.5}'
+echo "fatal: out of memory" >&2
+exit 1
+`
+
+// restartEvents records OnRestart events and restart audit events.
+type restartEvents struct {
+	mu       sync.Mutex
+	restarts []*RestartEvent
+	audit    []AuditEvent
+}
+
+func (r *restartEvents) options() []Option {
+	return []Option{
+		OnRestart(func(e *RestartEvent) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.restarts = append(r.restarts, e)
+		}),
+		Audit(func(e AuditEvent) {
+			if strings.HasPrefix(e.Type, "process.") {
+				r.mu.Lock()
+				defer r.mu.Unlock()
+				r.audit = append(r.audit, e)
+			}
+		}),
+	}
+}
+
+func (r *restartEvents) auditTypes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, e := range r.audit {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// waitExited waits until the agent's CLI output has ended.
+func waitExited(t *testing.T, a *Agent) {
+	t.Helper()
+	a.mu.Lock()
+	b := a.bridge
+	a.mu.Unlock()
+	select {
+	case <-b.finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CLI did not exit")
+	}
+}
+
+func readArgs(t *testing.T, dir string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestAutoRestartBetweenRuns(t *testing.T) {
+	dir := t.TempDir()
+	var events restartEvents
+	ctx := context.Background()
+	a, err := New(ctx, append(events.options(),
+		CLIPath(restartCLI(t, dir, crashAfterResult)),
+		AutoRestart(RestartPolicy{MaxRestarts: 2, Window: time.Hour, ResumeSession: true}),
+	)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			waitExited(t, a)
+		}
+		if _, err := a.Run(ctx, "hello"); err != nil {
+			t.Fatalf("Run() %d error = %v", i+1, err)
+		}
+	}
+
+	// Counters carry over and the session is resumed
+	if stats := a.Stats(); stats.Runs != 3 || stats.CostUSD != 1.5 {
+		t.Errorf("Stats() = %+v, want 3 runs costing $1.50", stats)
+	}
+	if a.SessionID() != "sess-1" {
+		t.Errorf("SessionID() = %q, want the resumed sess-1", a.SessionID())
+	}
+	args := readArgs(t, dir)
+	if len(args) != 3 || strings.Contains(args[0], "--resume") || !strings.Contains(args[1], "--resume sess-1") || !strings.Contains(args[2], "--resume sess-1") {
+		t.Errorf("CLI args = %q, want restarts resuming sess-1", args)
+	}
+
+	events.mu.Lock()
+	restarts := events.restarts
+	events.mu.Unlock()
+	if len(restarts) != 2 {
+		t.Fatalf("OnRestart calls = %d, want 2", len(restarts))
+	}
+	e := restarts[1]
+	var perr *ProcessError
+	if e.Restarts != 2 || !e.Resumed || e.SessionID != "sess-1" || e.PreviousSessionID != "sess-1" || !errors.As(e.Cause, &perr) || perr.ExitCode != 1 {
+		t.Errorf("RestartEvent = %+v, want the second resume of sess-1 caused by exit 1", e)
+	}
+	if got := strings.Join(events.auditTypes(), ","); got != "process.restarted,process.restarted" {
+		t.Errorf("audit events = %s, want two process.restarted", got)
+	}
+
+	// The policy is used up: the exit's error is returned
+	waitExited(t, a)
+	_, err = a.Run(ctx, "hello")
+	if !errors.As(err, &perr) || perr.ExitCode != 1 || !strings.Contains(perr.Stderr, "out of memory") {
+		t.Fatalf("Run() error = %v, want the *ProcessError of the exit", err)
+	}
+	if got := events.auditTypes(); got[len(got)-1] != "process.restart_exhausted" {
+		t.Errorf("audit events = %v, want process.restart_exhausted last", got)
+	}
+}
+
+func TestAutoRestartDuringRun(t *testing.T) {
+	dir := t.TempDir()
+	body := `read line || exit 0
+printf '%s\n' "$init"
+if [ $n -eq 1 ]; then
+	printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Working"}]}}'
+	echo "segfault" >&2
+	exit 2
+fi
+printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+while read line; do
+	printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+done
+`
+	var events restartEvents
+	ctx := context.Background()
+	a, err := New(ctx, append(events.options(),
+		CLIPath(restartCLI(t, dir, body)),
+		AutoRestart(RestartPolicy{MaxRestarts: 1}),
+	)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	// The run in flight fails and is not retried
+	if _, err := a.Run(ctx, "hello"); err == nil {
+		t.Fatal("Run() of the crashed run succeeded")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "count")); strings.TrimSpace(string(data)) != "1" {
+		t.Errorf("CLI started %s times before the next run, want 1", data)
+	}
+
+	// The next run starts a new session without ResumeSession
+	result, err := a.Run(ctx, "again")
+	if err != nil {
+		t.Fatalf("Run() after the crash error = %v", err)
+	}
+	if result.SessionID != "sess-2" || a.SessionID() != "sess-2" {
+		t.Errorf("SessionID = %q, Result.SessionID = %q, want the new sess-2", a.SessionID(), result.SessionID)
+	}
+	if args := readArgs(t, dir); len(args) != 2 || strings.Contains(args[1], "--resume") {
+		t.Errorf("CLI args = %q, want a restart without --resume", args)
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if len(events.restarts) != 1 || events.restarts[0].Resumed || events.restarts[0].PreviousSessionID != "sess-1" || events.restarts[0].SessionID != "" {
+		t.Errorf("OnRestart events = %+v, want one fresh restart from sess-1", events.restarts)
+	}
+}
+
+// TestAutoRestartCustomToolOutlivesProcess runs a custom tool that is still
+// running when its CLI exits and the next run restarts it; the tool's
+// result must not reach the new process. Run it with -race.
+func TestAutoRestartCustomToolOutlivesProcess(t *testing.T) {
+	dir := t.TempDir()
+	body := `read line || exit 0
+printf '%s\n' "$init"
+if [ $n -eq 1 ]; then
+	printf '%s\n' '{"type":"control","request_id":"req-stale","tool_use_id":"tu-1","tool_name":"slow","tool_input":{}}'
+	while [ ! -f "$dir/started" ]; do sleep 0.01; done
+	printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+	exit 1
+fi
+printf '%s\n' "$line" >> "$dir/read-$n"
+printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+while read line; do
+	printf '%s\n' "$line" >> "$dir/read-$n"
+	printf '%s\n' '{"type":"result","result":"ok","num_turns":1}'
+done
+`
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	slow := NewFuncTool("slow", "Waits to be released", nil, func(ctx context.Context, input map[string]any) (any, error) {
+		if err := os.WriteFile(filepath.Join(dir, "started"), nil, 0600); err != nil {
+			return nil, err
+		}
+		<-release
+		return "stale", nil
+	})
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(restartCLI(t, dir, body)),
+		CustomTool(slow),
+		AutoRestart(RestartPolicy{MaxRestarts: 1}),
+		// The tool finishes once the new process is in place, before the
+		// next run's prompt is sent
+		OnRestart(func(e *RestartEvent) {
+			close(release)
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "tool.custom.complete" {
+				close(finished)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := a.Run(ctx, "start the tool"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	waitExited(t, a)
+
+	runErr := make(chan error, 1)
+	go func() {
+		_, err := a.Run(ctx, "again")
+		runErr <- err
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("custom tool did not finish")
+	}
+	if err := <-runErr; err != nil {
+		t.Fatalf("Run() after the restart error = %v", err)
+	}
+	mustClose(t, a)
+
+	data, err := os.ReadFile(filepath.Join(dir, "read-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "req-stale") {
+		t.Errorf("new process read the stale tool result:\n%s", data)
+	}
+}
+
+func TestAutoRestartResumeFails(t *testing.T) {
+	dir := t.TempDir()
+	mustWriteFile(t, filepath.Join(dir, "no-resume"), nil, 0600)
+	var events restartEvents
+	ctx := context.Background()
+	a, err := New(ctx, append(events.options(),
+		CLIPath(restartCLI(t, dir, crashAfterResult)),
+		AutoRestart(RestartPolicy{MaxRestarts: 1, ResumeSession: true}),
+	)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	waitExited(t, a)
+	result, err := a.Run(ctx, "hello")
+	if err != nil {
+		t.Fatalf("Run() after the crash error = %v", err)
+	}
+	if result.SessionID != "sess-3" {
+		t.Errorf("Result.SessionID = %q, want the fresh sess-3", result.SessionID)
+	}
+	args := readArgs(t, dir)
+	if len(args) != 3 || !strings.Contains(args[1], "--resume sess-1") || strings.Contains(args[2], "--resume") {
+		t.Errorf("CLI args = %q, want a failed resume, then a fresh start", args)
+	}
+	if got := strings.Join(events.auditTypes(), ","); got != "process.resume_failed,process.restarted" {
+		t.Errorf("audit events = %s, want process.resume_failed, then process.restarted", got)
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if data := events.audit[0].Data.(map[string]any); data["exit_code"] != 1 || !strings.Contains(data["stderr"].(string), "No conversation found") {
+		t.Errorf("process.resume_failed data = %v, want the exit and stderr", data)
+	}
+	if len(events.restarts) != 1 || events.restarts[0].Resumed {
+		t.Errorf("OnRestart events = %+v, want one restart that did not resume", events.restarts)
+	}
+}
+
+func TestAutoRestartPolicy(t *testing.T) {
+	var cerr *ConfigError
+	for _, policy := range []RestartPolicy{{MaxRestarts: -1}, {MaxRestarts: 1, Window: -time.Second}} {
+		if _, err := New(context.Background(), AutoRestart(policy)); !errors.As(err, &cerr) || cerr.Option != "AutoRestart" {
+			t.Errorf("New(AutoRestart(%+v)) error = %v, want a *ConfigError", policy, err)
+		}
+	}
+
+	// Without AutoRestart, a crash between runs fails the next run
+	dir := t.TempDir()
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(restartCLI(t, dir, crashAfterResult)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+	if _, err := a.Run(ctx, "hello"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	waitExited(t, a)
+	if _, err := a.Run(ctx, "hello"); err == nil {
+		t.Error("Run() after the crash succeeded without AutoRestart")
+	}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import "strings"
+
+// RequireNonEmptyResult makes Run return an *EmptyResultError, with the
+// Result, when a run's ResultText is empty or only whitespace, as when
+// the model ends its turn without answering. Stream still delivers the
+// Result, and Err reports the error. It is off by default.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.RequireNonEmptyResult(true))
+//	result, err := a.Run(ctx, "Summarize the changelog")
+//	var empty *agent.EmptyResultError
+//	if errors.As(err, &empty) {
+//	    log.Printf("no answer; spent $%.4f", empty.CostUSD)
+//	}
+func RequireNonEmptyResult(require bool) Option {
+	return func(c *config) {
+		c.requireNonEmptyResult = require
+	}
+}
+
+// RefusalDetector makes Run return a *RefusalError, with the Result, when
+// fn reports that a run's ResultText is a refusal. A nil fn uses
+// IsRefusal. Stream still delivers the Result, and Err reports the error.
+// The check is off by default, and an error Result (IsError) is not
+// checked. RunWithSchema and RunStructured return the error before
+// unmarshaling.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.RefusalDetector(func(text string) bool {
+//	    return agent.IsRefusal(text) || strings.HasPrefix(text, "As a policy")
+//	}))
+func RefusalDetector(fn func(text string) bool) Option {
+	return func(c *config) {
+		if fn == nil {
+			fn = IsRefusal
+		}
+		c.refusalDetector = fn
+	}
+}
+
+// refusalPhrases open the common refusal replies, in lower case with
+// straight apostrophes.
+var refusalPhrases = []string{
+	"i can't help with",
+	"i cannot help with",
+	"i can't assist with",
+	"i cannot assist with",
+	"i can't do that",
+	"i cannot do that",
+	"i can't provide",
+	"i cannot provide",
+	"i can't comply",
+	"i cannot comply",
+	"i won't be able to",
+	"i'm not able to help",
+	"i am not able to help",
+	"i'm unable to help",
+	"i am unable to help",
+	"i'm unable to assist",
+	"i am unable to assist",
+	"i'm not comfortable",
+	"i must decline",
+	"i have to decline",
+}
+
+// maxRefusalBytes bounds the text IsRefusal considers a refusal. Refusals
+// are short; a long answer that opens with one of the phrases has likely
+// gone on to do the work.
+const maxRefusalBytes = 500
+
+// IsRefusal is the default RefusalDetector. It reports whether text is a
+// short reply whose first sentence declines the task, such as "I'm sorry,
+// but I can't help with that." Longer text is never a refusal.
+func IsRefusal(text string) bool {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > maxRefusalBytes {
+		return false
+	}
+	text = strings.ToLower(strings.ReplaceAll(text, "’", "'"))
+	if end := strings.IndexAny(text, ".!\n"); end >= 0 {
+		text = text[:end]
+	}
+	for _, phrase := range refusalPhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkResult returns the error for a Result rejected by
+// RequireNonEmptyResult or RefusalDetector, or nil.
+func (a *Agent) checkResult(r *Result) error {
+	if r.IsError {
+		return nil
+	}
+	if a.cfg.requireNonEmptyResult && strings.TrimSpace(r.ResultText) == "" {
+		return &EmptyResultError{SessionID: r.SessionID, Text: r.ResultText, CostUSD: r.CostUSD}
+	}
+	if a.cfg.refusalDetector != nil && a.cfg.refusalDetector(r.ResultText) {
+		return &RefusalError{SessionID: r.SessionID, Text: r.ResultText, CostUSD: r.CostUSD}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resultCLI writes a fake CLI that answers each prompt with a result
+// carrying text.
+func resultCLI(t *testing.T, text string) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"checked"}'
+while read line; do
+	printf '%s\n' '{"type":"result","result":"` + text + `","num_turns":1,"total_cost_usd":0.25}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestResultChecks(t *testing.T) {
+	const refusal = "Sorry, but I cannot help with that."
+	checks := []Option{RequireNonEmptyResult(true), RefusalDetector(nil)}
+	tests := []struct {
+		name string
+		text string
+		opts []Option
+		want string // "", "empty", or "refusal"
+	}{
+		{"empty off", "", nil, ""},
+		{"refusal off", refusal, nil, ""},
+		{"normal", "The answer is 4.", checks, ""},
+		{"empty", "", checks, "empty"},
+		{"whitespace", `  \n\t`, checks, "empty"},
+		{"refusal", refusal, checks, "refusal"},
+		{"refusal without empty check", refusal, []Option{RefusalDetector(nil)}, "refusal"},
+		{"empty without refusal check", "", []Option{RefusalDetector(nil)}, ""},
+		{"custom detector", "NO.", []Option{RefusalDetector(func(s string) bool { return s == "NO." })}, "refusal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			a, err := New(ctx, append([]Option{CLIPath(resultCLI(t, tt.text))}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer mustClose(t, a)
+
+			result, err := a.Run(ctx, "question")
+			if result == nil {
+				t.Fatalf("Run() result = nil, error = %v", err)
+			}
+			checkResultErr(t, "Run()", err, tt.want, result.ResultText)
+
+			// Stream delivers the Result and reports the error through Err
+			var streamed *Result
+			for msg := range a.Stream(ctx, "again") {
+				if r, ok := msg.(*Result); ok {
+					streamed = r
+				}
+			}
+			if streamed == nil || streamed.ResultText != result.ResultText {
+				t.Fatalf("Stream() Result = %+v, want the result unchanged", streamed)
+			}
+			checkResultErr(t, "Err()", a.Err(), tt.want, result.ResultText)
+
+			run := a.StreamRun(ctx, "once more")
+			for range run.Messages() {
+			}
+			<-run.Done()
+			checkResultErr(t, "Run.Err()", run.Err(), tt.want, result.ResultText)
+		})
+	}
+}
+
+// checkResultErr checks err is the error want names, carrying the
+// result's text and cost.
+func checkResultErr(t *testing.T, name string, err error, want, text string) {
+	t.Helper()
+	var empty *EmptyResultError
+	var refused *RefusalError
+	switch want {
+	case "":
+		if err != nil {
+			t.Errorf("%s error = %v, want nil", name, err)
+		}
+	case "empty":
+		if !errors.As(err, &empty) || empty.Text != text || empty.CostUSD != 0.25 || empty.SessionID != "checked" {
+			t.Errorf("%s error = %#v, want an *EmptyResultError with the text and cost", name, err)
+		}
+	case "refusal":
+		if !errors.As(err, &refused) || refused.Text != text || refused.CostUSD != 0.25 || refused.SessionID != "checked" {
+			t.Errorf("%s error = %#v, want a *RefusalError with the text and cost", name, err)
+		}
+	}
+}
+
+func TestResultChecksBeforeUnmarshal(t *testing.T) {
+	type Answer struct {
+		Value int `json:"value"`
+	}
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(resultCLI(t, "")), WithSchema(Answer{}), RequireNonEmptyResult(true))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var answer Answer
+	_, err = a.RunWithSchema(ctx, "What is 2+2?", &answer)
+	var empty *EmptyResultError
+	if !errors.As(err, &empty) {
+		t.Errorf("RunWithSchema() error = %v, want an *EmptyResultError rather than an unmarshal error", err)
+	}
+}
+
+func TestIsRefusal(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"I can't help with that.", true},
+		{"I’m unable to help with this request, as it involves credentials.", true},
+		{"Sorry, I cannot assist with creating malware.", true},
+		{"I must decline.", true},
+		{"The refactor is done. I can't help with the deployment step, though.", false},
+		{"Here is the summary you asked for.", false},
+		{"I can't help with that in one step, but here is a plan: " + strings.Repeat("step. ", 100), false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsRefusal(tt.text); got != tt.want {
+			t.Errorf("IsRefusal(%q) = %v, want %v", truncateText(tt.text, 40), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package review runs a code review of a unified diff with an agent.Agent
+// and collects the findings as structured data, optionally asking the
+// agent to fix them one at a time.
+//
+// Run sends the diff to a clone of the agent configured with a findings
+// schema, so the caller's agent and its conversation are left untouched.
+// A diff too large for one prompt is split at file and hunk boundaries,
+// each chunk is reviewed on its own, and the findings are merged, with
+// findings reported by more than one chunk kept once.
+//
+// Report.ApplyFix runs another clone with the finding's fix as the task
+// and a PreToolUse hook that confines file tools to the finding's file.
+//
+// Example:
+//
+//	a, err := agent.New(ctx, agent.WorkDir(repo))
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer a.Close()
+//
+//	report, err := review.Run(ctx, a, diff)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	for _, f := range report.Findings {
+//	    fmt.Printf("%s:%d [%s] %s\n", f.File, f.StartLine, f.Severity, f.Title)
+//	    if f.Severity == review.SeverityHigh {
+//	        if _, err := report.ApplyFix(ctx, f); err != nil {
+//	            log.Printf("fixing %s: %v", f.Title, err)
+//	        }
+//	    }
+//	}
+package review
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// DefaultChunkBytes is the largest part of a diff sent in one review
+// prompt, roughly 25,000 tokens.
+const DefaultChunkBytes = 100_000
+
+// Severity ranks a finding.
+type Severity string
+
+// Severities, from most to least serious.
+const (
+	SeverityHigh   Severity = "high"
+	SeverityMedium Severity = "medium"
+	SeverityLow    Severity = "low"
+	SeverityInfo   Severity = "info"
+)
+
+// rank orders severities for sorting; unknown severities rank lowest.
+func (s Severity) rank() int {
+	switch Severity(strings.ToLower(string(s))) {
+	case SeverityHigh:
+		return 3
+	case SeverityMedium:
+		return 2
+	case SeverityLow:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Finding is one problem found in the diff.
+type Finding struct {
+	File         string   `json:"file" desc:"Path of the file, as it appears in the diff"`
+	StartLine    int      `json:"start_line" desc:"First line of the problem in the new version of the file"`
+	EndLine      int      `json:"end_line" desc:"Last line of the problem in the new version of the file"`
+	Severity     Severity `json:"severity" desc:"One of high, medium, low, or info"`
+	Title        string   `json:"title" desc:"One-line summary of the problem"`
+	Explanation  string   `json:"explanation" desc:"Why this is a problem"`
+	SuggestedFix string   `json:"suggested_fix,omitempty" desc:"How to fix it, as prose or a code snippet"`
+}
+
+// response is the structured response of one review prompt.
+type response struct {
+	Findings []Finding `json:"findings" desc:"Problems found in the diff; empty if there are none"`
+}
+
+// Report is the result of a review.
+type Report struct {
+	// Findings are sorted by severity, then file and line.
+	Findings []Finding
+
+	Chunks  int     // Number of prompts the diff was split into
+	CostUSD float64 // Total cost of the review prompts
+
+	agent *agent.Agent // Cloned for fixes
+	cfg   *config
+}
+
+// config holds the review settings.
+type config struct {
+	chunkBytes   int
+	instructions string
+}
+
+// Option configures Run.
+type Option func(*config)
+
+// ChunkBytes sets the largest part of the diff sent in one prompt. The
+// default is DefaultChunkBytes. A single hunk larger than n is sent whole.
+func ChunkBytes(n int) Option {
+	return func(c *config) {
+		c.chunkBytes = n
+	}
+}
+
+// Instructions adds review guidance to every prompt, such as the
+// project's conventions or what to focus on.
+func Instructions(text string) Option {
+	return func(c *config) {
+		c.instructions = text
+	}
+}
+
+// Run reviews diff, a unified diff such as the output of git diff, with a
+// clone of a. An empty diff has no findings and sends no prompt. If a
+// chunk fails, Run returns the error and no report.
+func Run(ctx context.Context, a *agent.Agent, diff string, opts ...Option) (*Report, error) {
+	cfg := &config{chunkBytes: DefaultChunkBytes}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	report := &Report{agent: a, cfg: cfg}
+
+	chunks := splitDiff(diff, cfg.chunkBytes)
+	if len(chunks) == 0 {
+		return report, nil
+	}
+
+	reviewer, err := a.Clone(ctx, agent.WithSchema(response{}))
+	if err != nil {
+		return nil, fmt.Errorf("review: %w", err)
+	}
+	defer func() { _ = reviewer.Close() }()
+
+	var findings []Finding
+	for i, chunk := range chunks {
+		var resp response
+		result, err := reviewer.RunWithSchema(ctx, reviewPrompt(cfg, chunk, i, len(chunks)), &resp)
+		if result != nil {
+			report.CostUSD += result.CostUSD
+		}
+		if err != nil {
+			return nil, fmt.Errorf("review: chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		findings = append(findings, resp.Findings...)
+	}
+	report.Chunks = len(chunks)
+	report.Findings = mergeFindings(findings)
+	return report, nil
+}
+
+// reviewPrompt is the prompt for one chunk of the diff.
+func reviewPrompt(cfg *config, chunk string, i, n int) string {
+	var b strings.Builder
+	b.WriteString("Review the following diff as a careful code reviewer. Report bugs, security problems, ")
+	b.WriteString("and clear maintainability issues in the changed lines; do not report style preferences. ")
+	b.WriteString("Give line numbers from the new version of each file. Do not modify any files.\n")
+	if n > 1 {
+		fmt.Fprintf(&b, "The diff is split into %d parts; this is part %d. Review only this part.\n", n, i+1)
+	}
+	if cfg.instructions != "" {
+		b.WriteString("\n")
+		b.WriteString(cfg.instructions)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n```diff\n")
+	b.WriteString(chunk)
+	if !strings.HasSuffix(chunk, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString("```\n")
+	return b.String()
+}
+
+// ApplyFix asks a clone of the reviewed agent to fix f. File tools are
+// confined to f.File with agent.AllowPaths, and Bash is denied, so the fix
+// cannot change other files. The restriction is a PreToolUse hook added
+// after the agent's own, so a hook of the agent that returns Allow takes
+// precedence over it.
+func (r *Report) ApplyFix(ctx context.Context, f Finding) (*agent.Result, error) {
+	if r.agent == nil {
+		return nil, errors.New("review: report has no agent")
+	}
+	if f.File == "" {
+		return nil, errors.New("review: finding has no file")
+	}
+	fixer, err := r.agent.Clone(ctx, agent.PreToolUse(fixScope(f.File)))
+	if err != nil {
+		return nil, fmt.Errorf("review: %w", err)
+	}
+	defer func() { _ = fixer.Close() }()
+	return fixer.Run(ctx, fixPrompt(r.cfg, f))
+}
+
+// fixScope confines a fix to one file.
+func fixScope(file string) agent.PreToolUseHook {
+	allow := agent.AllowPaths(file)
+	return func(tc *agent.ToolCall) agent.HookResult {
+		if tc.Name == "Bash" {
+			return agent.HookResult{Decision: agent.Deny, Reason: "review fixes may only edit " + file}
+		}
+		return allow.Evaluate(tc)
+	}
+}
+
+// fixPrompt is the prompt that fixes f.
+func fixPrompt(cfg *config, f Finding) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Fix the following code review finding in %s", f.File)
+	if f.StartLine > 0 {
+		fmt.Fprintf(&b, " at lines %d-%d", f.StartLine, max(f.EndLine, f.StartLine))
+	}
+	b.WriteString(". Change only that file, and only as much as the fix needs.\n\n")
+	fmt.Fprintf(&b, "Title: %s\nSeverity: %s\n", f.Title, f.Severity)
+	if f.Explanation != "" {
+		fmt.Fprintf(&b, "Explanation: %s\n", f.Explanation)
+	}
+	if f.SuggestedFix != "" {
+		fmt.Fprintf(&b, "Suggested fix: %s\n", f.SuggestedFix)
+	}
+	if cfg != nil && cfg.instructions != "" {
+		b.WriteString("\n")
+		b.WriteString(cfg.instructions)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// mergeFindings drops findings reported more than once, such as by two
+// chunks, keeping the first with the highest severity reported, and sorts
+// the rest.
+func mergeFindings(findings []Finding) []Finding {
+	type key struct {
+		file       string
+		start, end int
+		title      string
+	}
+	index := make(map[key]int)
+	var merged []Finding
+	for _, f := range findings {
+		k := key{f.File, f.StartLine, f.EndLine, strings.ToLower(strings.Join(strings.Fields(f.Title), " "))}
+		if i, ok := index[k]; ok {
+			if f.Severity.rank() > merged[i].Severity.rank() {
+				merged[i].Severity = f.Severity
+			}
+			continue
+		}
+		index[k] = len(merged)
+		merged = append(merged, f)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if a.Severity.rank() != b.Severity.rank() {
+			return a.Severity.rank() > b.Severity.rank()
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.StartLine < b.StartLine
+	})
+	return merged
+}
+
+// splitDiff splits a unified diff into chunks of at most limit bytes,
+// breaking between files, or between hunks of a file too large for one
+// chunk; hunks of a split file repeat its header. A hunk larger than limit
+// is a chunk of its own.
+func splitDiff(diff string, limit int) []string {
+	if strings.TrimSpace(diff) == "" {
+		return nil
+	}
+	if limit <= 0 || len(diff) <= limit {
+		return []string{diff}
+	}
+
+	var chunks []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			chunks = append(chunks, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, file := range splitLines(diff, "diff --git ") {
+		if len(file) <= limit {
+			if cur.Len()+len(file) > limit {
+				flush()
+			}
+			cur.WriteString(file)
+			continue
+		}
+		// Too large for one chunk: split between hunks, starting each
+		// chunk with the file's header
+		header, hunks := splitHunks(file)
+		flush()
+		for _, hunk := range hunks {
+			if cur.Len() > len(header) && cur.Len()+len(hunk) > limit {
+				flush()
+			}
+			if cur.Len() == 0 {
+				cur.WriteString(header)
+			}
+			cur.WriteString(hunk)
+		}
+		flush()
+	}
+	flush()
+	return chunks
+}
+
+// splitHunks splits one file's diff into its header and hunks.
+func splitHunks(file string) (string, []string) {
+	parts := splitLines(file, "@@ ")
+	if len(parts) < 2 || strings.HasPrefix(parts[0], "@@ ") {
+		return "", parts
+	}
+	return parts[0], parts[1:]
+}
+
+// splitLines splits s before each line that starts with prefix. Text before
+// the first such line is the first part.
+func splitLines(s, prefix string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); {
+		end := strings.IndexByte(s[i:], '\n')
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += i + 1
+		}
+		if i > start && strings.HasPrefix(s[i:], prefix) {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+		i = end
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,225 @@
+package review
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+)
+
+// resultLine is a CLI result line whose text is the JSON of findings.
+func resultLine(t *testing.T, findings ...Finding) string {
+	t.Helper()
+	text, err := json.Marshal(response{Findings: findings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := json.Marshal(map[string]any{"type": "result", "result": string(text), "num_turns": 1, "total_cost_usd": 0.01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line)
+}
+
+// fakeCLI writes a script that answers review prompts by the hunk they
+// contain and fix prompts with two edits, recording its responses to
+// control requests in responses.jsonl. It returns the script's directory.
+//
+//nolint:gosec // G306: Test scripts need executable permissions
+func fakeCLI(t *testing.T) string {
+	t.Helper()
+	dup := Finding{File: "a.go", StartLine: 3, EndLine: 3, Severity: SeverityLow, Title: "Missing error check", Explanation: "Close can fail"}
+	louder := dup
+	louder.Severity = SeverityHigh
+	louder.Title = "missing  error check"
+	first := Finding{File: "a.go", StartLine: 2, EndLine: 2, Severity: SeverityMedium, Title: "Shadowed err"}
+	second := Finding{File: "a.go", StartLine: 40, EndLine: 42, Severity: SeverityHigh, Title: "Nil dereference", SuggestedFix: "Check p before use"}
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+cd "$(dirname "$0")"
+while read line; do
+  printf '%s\n' '{"type":"system","subtype":"init","session_id":"review-test"}'
+  echo "$line" >> prompts.jsonl
+  case "$line" in
+  *"Fix the following"*)
+    printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"Edit","tool_input":{"file_path":"other.go","old_string":"a","new_string":"b"}}'
+    read response
+    echo "$response" >> responses.jsonl
+    printf '%s\n' '{"type":"control","request_id":"req-2","tool_use_id":"tu-2","tool_name":"Bash","tool_input":{"command":"sed -i s/a/b/ other.go"}}'
+    read response
+    echo "$response" >> responses.jsonl
+    printf '%s\n' '{"type":"control","request_id":"req-3","tool_use_id":"tu-3","tool_name":"Edit","tool_input":{"file_path":"a.go","old_string":"a","new_string":"b"}}'
+    read response
+    echo "$response" >> responses.jsonl
+    printf '%s\n' '{"type":"result","result":"Fixed","num_turns":2}'
+    ;;
+  *"+first"*)
+    printf '%s\n' '` + resultLine(t, first, dup) + `'
+    ;;
+  *"+second"*)
+    printf '%s\n' '` + resultLine(t, second, louder) + `'
+    ;;
+  *)
+    printf '%s\n' '` + resultLine(t) + `'
+    ;;
+  esac
+done
+`
+	if err := os.WriteFile(filepath.Join(dir, "claude"), []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return dir
+}
+
+// bigDiff changes one file in two hunks far enough apart that each needs
+// its own chunk.
+var bigDiff = `diff --git a/a.go b/a.go
+--- a/a.go
++++ b/a.go
+@@ -1,3 +1,3 @@
+ package a
+-old
++first
+` + strings.Repeat(" context\n", 20) + `@@ -40,3 +40,3 @@
+ func f() {
+-old
++second
+` + strings.Repeat(" context\n", 20)
+
+func TestRunChunkedDiff(t *testing.T) {
+	dir := fakeCLI(t)
+	ctx := context.Background()
+	a, err := agent.New(ctx, agent.CLIPath(filepath.Join(dir, "claude")), agent.WorkDir(dir))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	report, err := Run(ctx, a, bigDiff, ChunkBytes(300), Instructions("We wrap errors with %w."))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Chunks != 2 {
+		t.Errorf("Chunks = %d, want 2", report.Chunks)
+	}
+	var titles []string
+	for _, f := range report.Findings {
+		titles = append(titles, string(f.Severity)+" "+f.Title)
+	}
+	// The duplicate is kept once, with the higher severity, and findings
+	// are sorted by severity, file, and line
+	want := []string{"high Missing error check", "high Nil dereference", "medium Shadowed err"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("findings = %v, want %v", titles, want)
+	}
+	if report.CostUSD < 0.019 || report.CostUSD > 0.021 {
+		t.Errorf("CostUSD = %v, want 0.02", report.CostUSD)
+	}
+
+	prompts, err := os.ReadFile(filepath.Join(dir, "prompts.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, prompt := range strings.Split(strings.TrimSpace(string(prompts)), "\n") {
+		// Each chunk repeats the file header and carries the instructions
+		if !strings.Contains(prompt, "+++ b/a.go") || !strings.Contains(prompt, "We wrap errors") {
+			t.Errorf("prompt %d = %s, want the header and instructions", i+1, prompt)
+		}
+	}
+}
+
+func TestApplyFixConfinedToFile(t *testing.T) {
+	dir := fakeCLI(t)
+	ctx := context.Background()
+	a, err := agent.New(ctx, agent.CLIPath(filepath.Join(dir, "claude")), agent.WorkDir(dir))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	report, err := Run(ctx, a, bigDiff)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.Chunks != 1 {
+		t.Fatalf("Chunks = %d, want 1", report.Chunks)
+	}
+	result, err := report.ApplyFix(ctx, report.Findings[0])
+	if err != nil {
+		t.Fatalf("ApplyFix() error = %v", err)
+	}
+	if result.ResultText != "Fixed" {
+		t.Errorf("ResultText = %q, want Fixed", result.ResultText)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "responses.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decisions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var resp struct {
+			Decision string `json:"decision"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("response %q does not parse: %v", line, err)
+		}
+		decisions = append(decisions, resp.Decision)
+	}
+	if want := []string{"deny", "deny", "allow"}; !reflect.DeepEqual(decisions, want) {
+		t.Errorf("decisions for other.go, Bash, a.go = %v, want %v", decisions, want)
+	}
+
+	if _, err := report.ApplyFix(ctx, Finding{Title: "No file"}); err == nil {
+		t.Error("ApplyFix() without a file succeeded, want an error")
+	}
+}
+
+func TestRunEmptyDiff(t *testing.T) {
+	dir := fakeCLI(t)
+	ctx := context.Background()
+	a, err := agent.New(ctx, agent.CLIPath(filepath.Join(dir, "claude")))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	report, err := Run(ctx, a, "  \n")
+	if err != nil || report.Chunks != 0 || len(report.Findings) != 0 {
+		t.Errorf("Run(empty) = %+v, %v; want an empty report", report, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prompts.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("a prompt was sent for an empty diff")
+	}
+}
+
+func TestSplitDiff(t *testing.T) {
+	small := "diff --git a/x b/x\n--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n"
+	other := strings.Replace(small, "x", "y", -1)
+	if got := splitDiff(small+other, 1000); !reflect.DeepEqual(got, []string{small + other}) {
+		t.Errorf("a diff under the limit was split: %q", got)
+	}
+	if got := splitDiff(small+other, len(small)+5); !reflect.DeepEqual(got, []string{small, other}) {
+		t.Errorf("files were not split apart: %q", got)
+	}
+
+	chunks := splitDiff(bigDiff, 300)
+	if len(chunks) != 2 || strings.Join(chunks, "") == bigDiff {
+		t.Fatalf("splitDiff() = %q, want two chunks each with the header", chunks)
+	}
+	header := "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n"
+	for i, chunk := range chunks {
+		if !strings.HasPrefix(chunk, header) || strings.Count(chunk, header) != 1 {
+			t.Errorf("chunk %d = %q, want one header", i+1, chunk)
+		}
+	}
+	if chunks[0]+strings.TrimPrefix(chunks[1], header) != bigDiff {
+		t.Error("chunks do not add up to the diff")
+	}
+}
@@ -0,0 +1,61 @@
+package agent
+
+// DefaultRunOptions sets run options applied to every Run and Stream call
+// before the call's own options, so a call can override any of them. Use
+// it for settings every run needs, such as a Timeout.
+//
+// Options are layered in one order: agent Options such as MaxTurns, then
+// the default run options, then the call's. Later layers win, so
+// MaxTurnsRun in a call beats MaxTurnsRun here, which beats MaxTurns.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.MaxTurns(50),
+//	    agent.DefaultRunOptions(agent.Timeout(2*time.Minute), agent.MaxTurnsRun(20)),
+//	)
+//	a.Run(ctx, prompt)                       // 2 minutes, 20 turns
+//	a.Run(ctx, prompt, agent.MaxTurnsRun(5)) // 2 minutes, 5 turns
+func DefaultRunOptions(opts ...RunOption) Option {
+	return func(c *config) {
+		c.runDefaults = append(c.runDefaults, opts...)
+	}
+}
+
+// WithRunDefaults adds run options applied to every later Run and Stream
+// call, after those given to DefaultRunOptions and before the call's own.
+// It does not affect a run already in progress. It is safe to call
+// concurrently with Run.
+func (a *Agent) WithRunDefaults(opts ...RunOption) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Replace rather than append in place so runConfig snapshots stay unchanged
+	defaults := make([]RunOption, 0, len(a.runDefaults)+len(opts))
+	defaults = append(defaults, a.runDefaults...)
+	a.runDefaults = append(defaults, opts...)
+}
+
+// runDefaultOptions returns a copy of the agent's default run options.
+func (a *Agent) runDefaultOptions() []RunOption {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]RunOption(nil), a.runDefaults...)
+}
+
+// runConfig returns the configuration for a run: the agent's MaxTurns,
+// overridden by its default run options, overridden by opts.
+func (a *Agent) runConfig(opts []RunOption) *runConfig {
+	a.mu.Lock()
+	defaults := a.runDefaults
+	a.mu.Unlock()
+
+	rc := &runConfig{maxTurns: a.cfg.maxTurns}
+	for _, opt := range defaults {
+		opt(rc)
+	}
+	for _, opt := range opts {
+		opt(rc)
+	}
+	return rc
+}
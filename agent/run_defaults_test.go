@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunOptionPrecedence(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxTurns    int         // MaxTurns agent Option
+		defaults    []RunOption // DefaultRunOptions
+		added       []RunOption // WithRunDefaults
+		call        []RunOption // Per-call options
+		wantTurns   int
+		wantTimeout time.Duration
+	}{
+		{name: "nothing set"},
+		{name: "agent option", maxTurns: 10, wantTurns: 10},
+		{name: "default only", defaults: []RunOption{MaxTurnsRun(20)}, wantTurns: 20},
+		{name: "call only", call: []RunOption{MaxTurnsRun(5)}, wantTurns: 5},
+		{name: "default beats agent option", maxTurns: 10, defaults: []RunOption{MaxTurnsRun(20)}, wantTurns: 20},
+		{name: "call beats agent option", maxTurns: 10, call: []RunOption{MaxTurnsRun(5)}, wantTurns: 5},
+		{name: "call beats default", defaults: []RunOption{MaxTurnsRun(20)}, call: []RunOption{MaxTurnsRun(5)}, wantTurns: 5},
+		{
+			name:      "call beats default beats agent option",
+			maxTurns:  10,
+			defaults:  []RunOption{MaxTurnsRun(20)},
+			call:      []RunOption{MaxTurnsRun(5)},
+			wantTurns: 5,
+		},
+		{name: "zero call keeps default", defaults: []RunOption{MaxTurnsRun(20)}, call: []RunOption{MaxTurnsRun(0)}, wantTurns: 20},
+		{name: "zero default keeps agent option", maxTurns: 10, defaults: []RunOption{MaxTurnsRun(0)}, wantTurns: 10},
+		{name: "added beats default", defaults: []RunOption{MaxTurnsRun(20)}, added: []RunOption{MaxTurnsRun(30)}, wantTurns: 30},
+		{name: "call beats added", added: []RunOption{MaxTurnsRun(30)}, call: []RunOption{MaxTurnsRun(5)}, wantTurns: 5},
+		{name: "default timeout", defaults: []RunOption{Timeout(time.Minute)}, wantTimeout: time.Minute},
+		{
+			name:        "call timeout beats default",
+			defaults:    []RunOption{Timeout(time.Minute)},
+			call:        []RunOption{Timeout(time.Second)},
+			wantTimeout: time.Second,
+		},
+		{
+			name:        "call disables default timeout",
+			defaults:    []RunOption{Timeout(time.Minute)},
+			call:        []RunOption{Timeout(0)},
+			wantTimeout: 0,
+		},
+		{
+			name:        "options layer independently",
+			maxTurns:    10,
+			defaults:    []RunOption{Timeout(time.Minute), MaxTurnsRun(20)},
+			call:        []RunOption{MaxTurnsRun(5)},
+			wantTurns:   5,
+			wantTimeout: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig(MaxTurns(tt.maxTurns), DefaultRunOptions(tt.defaults...))
+			a := &Agent{cfg: cfg, runDefaults: cfg.runDefaults}
+			a.WithRunDefaults(tt.added...)
+
+			rc := a.runConfig(tt.call)
+			if rc.maxTurns != tt.wantTurns || rc.timeout != tt.wantTimeout {
+				t.Errorf("maxTurns = %d, timeout = %v; want %d and %v", rc.maxTurns, rc.timeout, tt.wantTurns, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestDefaultRunOptionsTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"defaults-test"}'
+sleep 0.5
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), DefaultRunOptions(Timeout(100*time.Millisecond)))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	start := time.Now()
+	if _, err := a.Run(ctx, "test"); err == nil {
+		t.Error("Run() error = nil, want the default timeout to cut the run short")
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Run() took %v, want the 100ms default timeout to apply", elapsed)
+	}
+}
+
+func TestWithRunDefaultsMaxTurns(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"defaults-test"}'
+echo '{"type":"result","result":"Done","num_turns":3,"total_cost_usd":0.001}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), MaxTurns(10))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	a.WithRunDefaults(MaxTurnsRun(2))
+	_, err = a.Run(ctx, "test")
+	var maxErr *MaxTurnsError
+	if !errors.As(err, &maxErr) || maxErr.MaxAllowed != 2 {
+		t.Errorf("Run() error = %v, want MaxTurnsError with MaxAllowed 2", err)
+	}
+
+	// A clone keeps the defaults added at runtime
+	clone, err := a.Clone(ctx)
+	if err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	defer mustClose(t, clone)
+	if rc := clone.runConfig(nil); rc.maxTurns != 2 {
+		t.Errorf("clone maxTurns = %d, want 2", rc.maxTurns)
+	}
+}
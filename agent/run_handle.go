@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Run is a handle on a run started by StreamRun. It carries the run's own
+// outcome, so a supervisor can wait on several runs and tell them apart
+// without consuming their messages.
+type Run struct {
+	id        string
+	startedAt time.Time
+	messages  <-chan Message
+	done      chan struct{}
+
+	mu     sync.Mutex
+	err    error
+	result *Result
+}
+
+// newRun returns a handle on a run that delivers messages on out.
+func newRun(out <-chan Message) *Run {
+	return &Run{
+		id:        newRunID(),
+		startedAt: time.Now(),
+		messages:  out,
+		done:      make(chan struct{}),
+	}
+}
+
+// StreamRun is like Stream but returns a handle on the run. Its Messages
+// channel delivers the messages Stream would, and Err reports how this
+// run ended, unaffected by other runs of the agent.
+//
+// Example:
+//
+//	run := a.StreamRun(ctx, "refactor the parser")
+//	log.Printf("run %s started at %s", run.RunID(), run.StartedAt())
+//	go func() {
+//	    for msg := range run.Messages() {
+//	        render(msg)
+//	    }
+//	}()
+//	select {
+//	case <-run.Done():
+//	    if err := run.Err(); err != nil {
+//	        log.Printf("run %s failed: %v", run.RunID(), err)
+//	    }
+//	case <-time.After(time.Hour):
+//	    a.Cancel("supervisor timeout")
+//	}
+func (a *Agent) StreamRun(ctx context.Context, prompt string, opts ...RunOption) *Run {
+	return a.stream(ctx, promptSource{text: prompt}, opts)
+}
+
+// RunID returns the ID of the run, as carried by its audit events and
+// Result.
+func (r *Run) RunID() string {
+	return r.id
+}
+
+// StartedAt returns when the run was started.
+func (r *Run) StartedAt() time.Time {
+	return r.startedAt
+}
+
+// Messages returns the channel of the run's messages. It closes when the
+// run ends.
+func (r *Run) Messages() <-chan Message {
+	return r.messages
+}
+
+// Done returns a channel that is closed when the run has ended: after its
+// Result, cancellation, Close, or the CLI exiting. A run ends only once its
+// final message has been delivered or dropped, so the Messages channel must
+// be read for Done to close after a Result.
+func (r *Run) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error that ended the run, or nil if the run ended with a
+// Result or has not ended. A Result reporting an error, such as one with
+// IsError set, is not an error of the run; Run converts it into one. The
+// error is:
+//
+//   - a *CancelledError if the run's context ended or Cancel was called
+//   - a *CancelledError wrapping ErrAgentClosed if Close cut the run short,
+//     or ErrAgentClosed if the agent was already closed
+//   - the error refusing the prompt, such as a *PromptTooLargeError
+//   - the error reading CLI output, a classified exit error such as a
+//     *ProcessError, or a *TaskError if the CLI exited without a result
+//   - an *EmptyResultError or *RefusalError if RequireNonEmptyResult or
+//     RefusalDetector rejected the Result, which is still delivered
+func (r *Run) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Result returns the run's Result and true once it has been received.
+func (r *Run) Result() (*Result, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.result, r.result != nil
+}
+
+// setResult records the run's Result.
+func (r *Run) setResult(result *Result) {
+	r.mu.Lock()
+	r.result = result
+	r.mu.Unlock()
+}
+
+// finish records err as the run's error and closes Done.
+func (r *Run) finish(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+	close(r.done)
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitDone fails the test if run does not end within a few seconds.
+func waitDone(t *testing.T, run *Run) {
+	t.Helper()
+	select {
+	case <-run.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Done() not closed")
+	}
+}
+
+func TestStreamRunCompletes(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"run-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hi"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	before := time.Now()
+	run := a.StreamRun(ctx, "hello")
+	if run.RunID() == "" || run.StartedAt().Before(before) {
+		t.Errorf("RunID() = %q, StartedAt() = %v, want an ID and a start after %v", run.RunID(), run.StartedAt(), before)
+	}
+	if _, ok := run.Result(); ok {
+		t.Error("Result() ok before the run ended")
+	}
+
+	var n int
+	for range run.Messages() {
+		n++
+	}
+	waitDone(t, run)
+	if n != 2 {
+		t.Errorf("received %d messages, want 2", n)
+	}
+	if err := run.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+	result, ok := run.Result()
+	if !ok || result.ResultText != "Done" || result.RunID != run.RunID() {
+		t.Errorf("Result() = %+v, %v, want the Result with RunID %s", result, ok, run.RunID())
+	}
+}
+
+func TestStreamRunCancelled(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"run-test"}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	a, err := New(context.Background(), CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run := a.StreamRun(ctx, "hello")
+	cancel()
+	waitDone(t, run)
+	for range run.Messages() {
+	}
+
+	var cerr *CancelledError
+	if !errors.As(run.Err(), &cerr) || cerr.Reason != StopCancelled {
+		t.Errorf("Err() = %v, want a *CancelledError with StopCancelled", run.Err())
+	}
+	if _, ok := run.Result(); ok {
+		t.Error("Result() ok for a cancelled run")
+	}
+}
+
+func TestStreamRunProcessDeath(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"run-test"}'
+echo "fatal: segmentation fault" >&2
+exit 3
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }() // Close reports the nonzero exit
+
+	run := a.StreamRun(ctx, "hello")
+	for range run.Messages() {
+	}
+	waitDone(t, run)
+
+	var perr *ProcessError
+	if !errors.As(run.Err(), &perr) || perr.ExitCode != 3 {
+		t.Errorf("Err() = %v, want a *ProcessError with exit code 3", run.Err())
+	}
+}
+
+func TestStreamRunErrIsolation(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"run-test"}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), MaxPromptBytes(10))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	first := a.StreamRun(ctx, "a prompt over the limit")
+	for range first.Messages() {
+	}
+	waitDone(t, first)
+
+	second := a.StreamRun(ctx, "short")
+	for range second.Messages() {
+	}
+	waitDone(t, second)
+
+	if second.RunID() == first.RunID() {
+		t.Errorf("both runs have ID %s", first.RunID())
+	}
+	var tooLarge *PromptTooLargeError
+	if !errors.As(first.Err(), &tooLarge) {
+		t.Errorf("first Err() = %v, want a *PromptTooLargeError", first.Err())
+	}
+	if err := second.Err(); err != nil {
+		t.Errorf("second Err() = %v, want nil", err)
+	}
+	if _, ok := second.Result(); !ok {
+		t.Error("second Result() not ok")
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Stats holds totals across all of an agent's completed runs, for capacity
+// planning. The size fields are sums of the Result fields of the same name.
+type Stats struct {
+	Runs    int     // Runs that ended with a Result
+	CostUSD float64 // Total CostUSD
+
+	PromptBytes     int64
+	ResponseBytes   int64
+	ToolOutputBytes int64
+
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+// Stats returns the agent's totals so far.
+func (a *Agent) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	stats := a.stats
+	stats.CostUSD = a.totalCost
+	return stats
+}
+
+// LabeledStats holds the totals of the completed runs that ended with one
+// set of labels.
+type LabeledStats struct {
+	Labels map[string]string // The agent's labels merged with the run's, as on its audit events
+	Stats
+}
+
+// StatsByLabels returns the agent's totals grouped by the labels each run
+// ended with, such as one group per tenant when SetLabel switches tenants
+// between runs. Labels added during a run count for the whole run. The
+// groups are sorted by their labels, and their totals add up to Stats.
+func (a *Agent) StatsByLabels() []LabeledStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := make([]string, 0, len(a.labeledStats))
+	for key := range a.labeledStats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make([]LabeledStats, len(keys))
+	for i, key := range keys {
+		g := a.labeledStats[key]
+		groups[i] = LabeledStats{Labels: copyLabels(g.Labels), Stats: g.Stats}
+	}
+	return groups
+}
+
+// addLabeledStats adds a finished run's Result to the totals of its label
+// set. a.mu must be held.
+func (a *Agent) addLabeledStats(m *Result) {
+	labels := a.labels
+	if len(a.runLabels) > 0 {
+		labels = make(map[string]string, len(a.labels)+len(a.runLabels))
+		for k, v := range a.labels {
+			labels[k] = v
+		}
+		for k, v := range a.runLabels {
+			labels[k] = v
+		}
+	}
+	key := labelSetKey(labels)
+	g := a.labeledStats[key]
+	if g == nil {
+		if a.labeledStats == nil {
+			a.labeledStats = make(map[string]*LabeledStats)
+		}
+		g = &LabeledStats{Labels: copyLabels(labels)}
+		a.labeledStats[key] = g
+	}
+	g.CostUSD += m.CostUSD
+	g.add(m)
+}
+
+// labelSetKey returns a key that is equal for equal label sets.
+func labelSetKey(labels map[string]string) string {
+	data, _ := json.Marshal(labels) // Sorted by key; a string map always encodes
+	return string(data)
+}
+
+// runSizes accumulates the sizes of the run in progress.
+type runSizes struct {
+	prompt     int
+	response   int
+	toolOutput int
+}
+
+// add records the size of a message of the run. a.mu must be held.
+func (s *runSizes) add(msg Message) {
+	switch m := msg.(type) {
+	case *Text:
+		s.response += len(m.Text)
+	case *ToolResult:
+		s.toolOutput += contentSize(m.Content)
+	}
+}
+
+// finish sets the size fields of the run's Result and adds them to stats.
+// a.mu must be held.
+func (s *runSizes) finish(m *Result, stats *Stats) {
+	m.PromptBytes = s.prompt
+	m.ResponseBytes = s.response
+	m.ToolOutputBytes = s.toolOutput
+	m.PromptTokens = m.Usage.InputTokens
+	m.CompletionTokens = m.Usage.OutputTokens
+	stats.add(m)
+}
+
+// add adds a finished run's sizes to the totals. CostUSD is kept apart.
+func (stats *Stats) add(m *Result) {
+	stats.Runs++
+	stats.PromptBytes += int64(m.PromptBytes)
+	stats.ResponseBytes += int64(m.ResponseBytes)
+	stats.ToolOutputBytes += int64(m.ToolOutputBytes)
+	stats.PromptTokens += int64(m.PromptTokens)
+	stats.CompletionTokens += int64(m.CompletionTokens)
+}
+
+// contentSize is the size in bytes of tool result content: the length of
+// text content, or of the JSON encoding of structured content.
+func contentSize(content any) int {
+	switch c := content.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(c)
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunSizes(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	// The first run has 12 bytes of text and 18 of tool output; the second
+	// 3 and 0
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"sizes-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Read","input":{"file_path":"main.go"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"package main"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-2","name":"Bash","input":{"command":"git status"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-2","content":"clean!"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":" world!"}]}}'
+printf '%s\n' '{"type":"result","result":"Hello world!","num_turns":1,"usage":{"input_tokens":100,"output_tokens":20}}'
+read line
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Bye"}]}}'
+printf '%s\n' '{"type":"result","result":"Bye","num_turns":1,"usage":{"input_tokens":50,"output_tokens":5}}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	var audited map[string]any
+	a, err := New(ctx, CLIPath(fakeClaude), Audit(func(e AuditEvent) {
+		if e.Type == "message.result" && audited == nil {
+			audited = e.Data.(map[string]any)
+		}
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	first, err := a.Run(ctx, "count me")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if first.PromptBytes != 8 || first.ResponseBytes != 12 || first.ToolOutputBytes != 18 {
+		t.Errorf("first run sizes = %d/%d/%d, want 8/12/18",
+			first.PromptBytes, first.ResponseBytes, first.ToolOutputBytes)
+	}
+	if first.PromptTokens != 100 || first.CompletionTokens != 20 {
+		t.Errorf("first run tokens = %d/%d, want 100/20", first.PromptTokens, first.CompletionTokens)
+	}
+	if audited["response_bytes"] != 12 || audited["tool_output_bytes"] != 18 {
+		t.Errorf("message.result sizes = %v/%v, want 12/18", audited["response_bytes"], audited["tool_output_bytes"])
+	}
+
+	// Sizes reset for each run
+	second, err := a.Run(ctx, "hi")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if second.PromptBytes != 2 || second.ResponseBytes != 3 || second.ToolOutputBytes != 0 {
+		t.Errorf("second run sizes = %d/%d/%d, want 2/3/0",
+			second.PromptBytes, second.ResponseBytes, second.ToolOutputBytes)
+	}
+
+	want := Stats{
+		Runs:             2,
+		PromptBytes:      10,
+		ResponseBytes:    15,
+		ToolOutputBytes:  18,
+		PromptTokens:     150,
+		CompletionTokens: 25,
+	}
+	if got := a.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestContentSize(t *testing.T) {
+	tests := []struct {
+		content any
+		want    int
+	}{
+		{nil, 0},
+		{"", 0},
+		{"héllo", 6},
+		{[]any{map[string]any{"type": "text", "text": "ok"}}, len(`[{"text":"ok","type":"text"}]`)},
+	}
+	for _, tt := range tests {
+		if got := contentSize(tt.content); got != tt.want {
+			t.Errorf("contentSize(%v) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToolsRun limits a single Run or Stream call to the named tools, for
+// example a read-only run on an agent that can otherwise edit files:
+//
+//	result, err := a.Run(ctx, "Review main.go; do not change it",
+//	    agent.ToolsRun(agent.ToolRead, agent.ToolGrep, agent.ToolGlob))
+//
+// The CLI's tool flags cannot change during a session, so the limit is
+// enforced by a PreToolUse policy that denies any other tool with a reason
+// naming the restriction. The policy is evaluated before the agent's
+// PreToolUse hooks, which see only the calls it allows, and applies only
+// to tool calls of the run; the next run has the agent's tools again. It
+// can only narrow the tools set with Tools, not add to them. Names are
+// matched as given to Tools, so an MCP tool is named with MCPTool; a
+// permission pattern such as "Bash(git:*)" matches the whole tool. No
+// names denies every tool. A later ToolsRun replaces an earlier one.
+func ToolsRun(names ...string) RunOption {
+	return func(rc *runConfig) {
+		rc.tools = rc.tools.withAllowed(names)
+	}
+}
+
+// DisallowToolsRun denies the named tools for a single Run or Stream call,
+// in the same way as ToolsRun. It can be combined with ToolsRun; a tool
+// named by both is denied.
+func DisallowToolsRun(names ...string) RunOption {
+	return func(rc *runConfig) {
+		rc.tools = rc.tools.withDisallowed(names)
+	}
+}
+
+// runToolPolicy is the ToolsRun and DisallowToolsRun restriction of a run.
+// A policy is never modified once built, so tool calls of the run can share
+// it.
+type runToolPolicy struct {
+	allowed    []string // nil = any tool
+	disallowed []string
+}
+
+// withAllowed returns a copy of p that allows only names.
+func (p *runToolPolicy) withAllowed(names []string) *runToolPolicy {
+	out := p.copy()
+	out.allowed = make([]string, 0, len(names))
+	for _, name := range names {
+		out.allowed = append(out.allowed, toolRuleName(name))
+	}
+	return out
+}
+
+// withDisallowed returns a copy of p that also denies names.
+func (p *runToolPolicy) withDisallowed(names []string) *runToolPolicy {
+	out := p.copy()
+	disallowed := make([]string, 0, len(out.disallowed)+len(names))
+	disallowed = append(disallowed, out.disallowed...)
+	for _, name := range names {
+		disallowed = append(disallowed, toolRuleName(name))
+	}
+	out.disallowed = disallowed
+	return out
+}
+
+// copy returns a shallow copy of p, or an empty policy if p is nil.
+func (p *runToolPolicy) copy() *runToolPolicy {
+	if p == nil {
+		return &runToolPolicy{}
+	}
+	out := *p
+	return &out
+}
+
+// check returns the denial of a call to tool, or Continue if the policy
+// allows it or is nil.
+func (p *runToolPolicy) check(tool string) HookResult {
+	if p == nil {
+		return HookResult{Decision: Continue}
+	}
+	for _, name := range p.disallowed {
+		if name == tool {
+			return HookResult{
+				Decision: Deny,
+				Reason:   fmt.Sprintf("tool %s is disallowed for this run (DisallowToolsRun)", tool),
+			}
+		}
+	}
+	if p.allowed == nil {
+		return HookResult{Decision: Continue}
+	}
+	for _, name := range p.allowed {
+		if name == tool {
+			return HookResult{Decision: Continue}
+		}
+	}
+	allowed := "no tools"
+	if len(p.allowed) > 0 {
+		allowed = "only " + strings.Join(p.allowed, ", ")
+	}
+	return HookResult{
+		Decision: Deny,
+		Reason:   fmt.Sprintf("tool %s is not allowed in this run (ToolsRun allows %s)", tool, allowed),
+	}
+}
+
+// auditData adds the policy to hook.pre_tool_use audit data, if there is
+// one.
+func (p *runToolPolicy) auditData(data map[string]any, denied bool) {
+	if p == nil {
+		return
+	}
+	if p.allowed != nil {
+		data["run_tools"] = p.allowed
+	}
+	if len(p.disallowed) > 0 {
+		data["run_disallowed_tools"] = p.disallowed
+	}
+	data["run_tools_denied"] = denied
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestToolsRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	// A read-only run tries Write and Bash, then a normal run tries Write
+	script := `#!/bin/sh
+read -r line
+echo '{"type":"system","subtype":"init","session_id":"run-tools"}'
+echo '{"type":"control","request_id":"req_1","tool_name":"Write","tool_input":{"file_path":"/a","content":"x"}}'
+read -r response
+printf '%s\n' "$response" >> ` + wire + `
+echo '{"type":"control","request_id":"req_2","tool_name":"Bash","tool_input":{"command":"ls"}}'
+read -r response
+printf '%s\n' "$response" >> ` + wire + `
+echo '{"type":"result","result":"Done","num_turns":1}'
+read -r line
+echo '{"type":"control","request_id":"req_3","tool_name":"Write","tool_input":{"file_path":"/a","content":"x"}}'
+read -r response
+printf '%s\n' "$response" >> ` + wire + `
+echo '{"type":"result","result":"Done","num_turns":1}'
+read -r line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var hookTools []string
+	var audited []map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Tools(ToolBash, ToolWrite),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			mu.Lock()
+			hookTools = append(hookTools, tc.Name)
+			mu.Unlock()
+			return HookResult{Decision: Continue}
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "hook.pre_tool_use" {
+				mu.Lock()
+				audited = append(audited, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	result, err := a.Run(ctx, "analyze", ToolsRun(ToolBash, "Read"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(result.Denials) != 1 || result.Denials[0].Tool != ToolWrite {
+		t.Errorf("Denials = %+v, want the Write call", result.Denials)
+	}
+	if _, err := a.Run(ctx, "now fix it"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := `{"request_id":"req_1","decision":"deny","reason":"tool Write is not allowed in this run (ToolsRun allows only Bash, Read)"}
+{"request_id":"req_2","decision":"allow"}
+{"request_id":"req_3","decision":"allow"}
+`
+	if got := string(mustReadFile(t, wire)); got != want {
+		t.Errorf("wire =\n%s\nwant\n%s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The restriction is decided before the hooks, which see only the
+	// calls it allows
+	if strings.Join(hookTools, ",") != "Bash,Write" {
+		t.Errorf("PreToolUse saw %v, want Bash,Write", hookTools)
+	}
+	if len(audited) != 3 {
+		t.Fatalf("got %d hook.pre_tool_use events, want 3", len(audited))
+	}
+	if audited[0]["run_tools_denied"] != true || !reflect.DeepEqual(audited[0]["run_tools"], []string{"Bash", "Read"}) {
+		t.Errorf("denied call audit = %v, want the run's tools and run_tools_denied", audited[0])
+	}
+	if audited[1]["run_tools_denied"] != false {
+		t.Errorf("allowed call audit = %v, want run_tools_denied false", audited[1])
+	}
+	if _, ok := audited[2]["run_tools"]; ok {
+		t.Errorf("unrestricted run audit = %v, want no run_tools", audited[2])
+	}
+}
+
+func TestRunToolPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []RunOption
+		tool string
+		want string // Reason, or "" if allowed
+	}{
+		{"none", nil, "Write", ""},
+		{"allowed", []RunOption{ToolsRun("Read", "Bash(git:*)")}, "Bash", ""},
+		{"not allowed", []RunOption{ToolsRun("Read")}, "Write", "tool Write is not allowed in this run (ToolsRun allows only Read)"},
+		{"no tools", []RunOption{ToolsRun()}, "Read", "tool Read is not allowed in this run (ToolsRun allows no tools)"},
+		{"disallowed", []RunOption{DisallowToolsRun("Write")}, "Write", "tool Write is disallowed for this run (DisallowToolsRun)"},
+		{"both", []RunOption{ToolsRun("Read", "Write"), DisallowToolsRun("Write")}, "Write", "tool Write is disallowed for this run (DisallowToolsRun)"},
+		{"replaced", []RunOption{ToolsRun("Read"), ToolsRun("Write")}, "Write", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &runConfig{}
+			for _, opt := range tt.opts {
+				opt(rc)
+			}
+			got := rc.tools.check(tt.tool)
+			if (got.Decision == Deny) != (tt.want != "") || got.Reason != tt.want {
+				t.Errorf("check(%s) = %v %q, want %q", tt.tool, got.Decision, got.Reason, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import "reflect"
+
+// RunValue attaches a value to a single Run or Stream call, for hooks that
+// need request-scoped data such as a user or tenant ID. Hooks read it with
+// ToolCall.Value, ToolResultContext.Value, PromptSubmitEvent.Value, and
+// StopEvent.Value; the Stop hook sees the values of the agent's last run.
+//
+// As with context.WithValue, key must be comparable and should be of an
+// unexported type to avoid collisions. Values are fixed when the run
+// starts and are not shared with other runs.
+//
+// Example:
+//
+//	type tenantKey struct{}
+//
+//	a, _ := agent.New(ctx, agent.PreToolUse(func(tc *agent.ToolCall) agent.HookResult {
+//	    tenant, _ := tc.Value(tenantKey{}).(string)
+//	    return policyFor(tenant).Check(tc)
+//	}))
+//	result, err := a.Run(ctx, prompt, agent.RunValue(tenantKey{}, "acme"))
+func RunValue(key, val any) RunOption {
+	if key == nil {
+		panic("agent: RunValue key is nil")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("agent: RunValue key is not comparable")
+	}
+	return func(rc *runConfig) {
+		rc.values = rc.values.with(key, val)
+	}
+}
+
+// runValues holds the values attached to a run with RunValue. A map is
+// never modified once built, so hooks and later runs can share it.
+type runValues map[any]any
+
+// with returns a copy of v with key set to val.
+func (v runValues) with(key, val any) runValues {
+	out := make(runValues, len(v)+1)
+	for k, x := range v {
+		out[k] = x
+	}
+	out[key] = val
+	return out
+}
+
+// Value returns the value attached to the run for key with RunValue, or
+// nil if there is none.
+func (tc *ToolCall) Value(key any) any {
+	return tc.values[key]
+}
+
+// Value returns the value attached to the run for key with RunValue, or
+// nil if there is none.
+func (tr *ToolResultContext) Value(key any) any {
+	return tr.values[key]
+}
+
+// Value returns the value attached to the run for key with RunValue, or
+// nil if there is none.
+func (e *PromptSubmitEvent) Value(key any) any {
+	return e.values[key]
+}
+
+// Value returns the value attached to the agent's last run for key with
+// RunValue, or nil if there is none.
+func (e *StopEvent) Value(key any) any {
+	return e.values[key]
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type tenantKey struct{}
+type userKey struct{}
+
+func TestRunValuesInHooks(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+while read line; do
+echo '{"type":"system","subtype":"init","session_id":"values-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"toolu_1","tool_name":"Read","tool_input":{"file_path":"/a"}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	seen := make(map[string][]any)
+	record := func(hook string, tenant, user any) {
+		mu.Lock()
+		seen[hook] = append(seen[hook], tenant, user)
+		mu.Unlock()
+	}
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		UserPromptSubmit(func(e *PromptSubmitEvent) PromptSubmitResult {
+			record("prompt", e.Value(tenantKey{}), e.Value(userKey{}))
+			return PromptSubmitResult{}
+		}),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			record("pre", tc.Value(tenantKey{}), tc.Value(userKey{}))
+			return HookResult{Decision: Continue}
+		}),
+		PostToolUse(func(tc *ToolCall, tr *ToolResultContext) HookResult {
+			record("post", tr.Value(tenantKey{}), tc.Value(userKey{}))
+			return HookResult{Decision: Continue}
+		}),
+		OnStop(func(e *StopEvent) {
+			record("stop", e.Value(tenantKey{}), e.Value(userKey{}))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := a.Run(ctx, "first", RunValue(tenantKey{}, "acme"), RunValue(userKey{}, "u-1")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// The second run sets only the tenant; nothing carries over
+	if _, err := a.Run(ctx, "second", RunValue(tenantKey{}, "globex")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mustClose(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string][]any{
+		"prompt": {"acme", "u-1", "globex", nil},
+		"pre":    {"acme", "u-1", "globex", nil},
+		"post":   {"acme", "u-1", "globex", nil},
+		"stop":   {"globex", nil},
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("hooks saw %v, want %v", seen, want)
+	}
+}
+
+func TestRunValueDefaultsAndOverrides(t *testing.T) {
+	shared := DefaultRunOptions(RunValue(tenantKey{}, "default"))
+	cfg := newConfig(shared)
+	a := &Agent{cfg: cfg, runDefaults: cfg.runDefaults}
+
+	first := a.runConfig([]RunOption{RunValue(tenantKey{}, "override"), RunValue(userKey{}, "u-1")})
+	second := a.runConfig(nil)
+
+	if got := first.values[tenantKey{}]; got != "override" {
+		t.Errorf("tenant = %v, want the per-run value to win", got)
+	}
+	if got := second.values[tenantKey{}]; got != "default" {
+		t.Errorf("tenant = %v, want the default unaffected by the earlier override", got)
+	}
+	if got := second.values[userKey{}]; got != nil {
+		t.Errorf("user = %v, want nil in a run that did not set it", got)
+	}
+}
+
+func TestRunValueAbsent(t *testing.T) {
+	tc := &ToolCall{}
+	if got := tc.Value(tenantKey{}); got != nil {
+		t.Errorf("Value() = %v, want nil without RunValue", got)
+	}
+	if got := (&StopEvent{}).Value(tenantKey{}); got != nil {
+		t.Errorf("StopEvent.Value() = %v, want nil without RunValue", got)
+	}
+}
+
+func TestRunValueInvalidKey(t *testing.T) {
+	for name, key := range map[string]any{"nil": nil, "slice": []string{"a"}} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("RunValue() did not panic")
+				}
+			}()
+			RunValue(key, "v")
+		})
+	}
+}
@@ -1,45 +1,246 @@
 package agent
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"unicode/utf8"
 )
 
-const maxSchemaDepth = 10
+// maxRawTextLen bounds how much response text is kept in a SchemaError.
+const maxRawTextLen = 500
+
+// SchemaFor returns the JSON Schema that WithSchema generates for v with
+// the same options. The output is deterministic: object keys are sorted,
+// so the same type always produces byte-identical output. Applications can
+// snapshot it in tests and pass an approved copy to WithSchemaString.
+func SchemaFor(v any, opts ...SchemaOption) (string, error) {
+	schema, err := schemaFromValue(v, opts...)
+	if err != nil {
+		return "", err
+	}
+	data, err := marshalSchema(schema)
+	if err != nil {
+		return "", &SchemaError{
+			Type:   reflect.TypeOf(v).String(),
+			Reason: "failed to marshal schema",
+			Cause:  err,
+		}
+	}
+	return string(data), nil
+}
+
+// marshalSchema encodes a schema. encoding/json sorts map keys, so a
+// schema built from maps, as the generator builds them, encodes the same
+// way every time.
+func marshalSchema(schema any) ([]byte, error) {
+	return json.Marshal(schema)
+}
+
+// SchemaOption configures how SchemaFor and WithSchema generate a schema.
+type SchemaOption func(*schemaConfig)
+
+// schemaConfig holds schema generation settings.
+type schemaConfig struct {
+	useDefs bool // Emit shared and recursive struct types under $defs
+}
+
+// UseDefs emits each struct type that is reached more than once, such as
+// a Money type used by several fields, once under $defs and refers to it
+// with $ref. Recursive types become $ref cycles instead of an error; a
+// reference back to the top-level type is "#". Types are keyed by name,
+// qualified with their package path only when two types share a name.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.WithSchema(Invoice{}, agent.UseDefs(true)))
+func UseDefs(on bool) SchemaOption {
+	return func(c *schemaConfig) {
+		c.useDefs = on
+	}
+}
 
 // schemaFromValue generates a JSON Schema from a Go value.
 // The value should be a struct or pointer to struct.
-func schemaFromValue(v any) (map[string]any, error) {
+func schemaFromValue(v any, opts ...SchemaOption) (map[string]any, error) {
 	if v == nil {
 		return nil, &SchemaError{Type: "nil", Reason: "cannot generate schema from nil value"}
 	}
-	return schemaFromType(reflect.TypeOf(v))
+	return schemaFromType(reflect.TypeOf(v), opts...)
 }
 
 // schemaFromType generates a JSON Schema from a Go type.
 // The type must be a struct (or pointer to struct).
-func schemaFromType(t reflect.Type) (map[string]any, error) {
-	return schemaFromTypeWithDepth(t, 0)
+func schemaFromType(t reflect.Type, opts ...SchemaOption) (map[string]any, error) {
+	var cfg schemaConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	g := newSchemaGenerator(derefType(t), cfg)
+
+	schema, err := g.schema(t, rootPath(t))
+	if err != nil {
+		return nil, err
+	}
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+	return schema, nil
 }
 
-// schemaFromTypeWithDepth generates schema with depth tracking to prevent infinite recursion.
-func schemaFromTypeWithDepth(t reflect.Type, depth int) (map[string]any, error) {
-	if depth > maxSchemaDepth {
-		return nil, &SchemaError{
-			Type:   t.String(),
-			Reason: "maximum nesting depth exceeded (possible circular reference)",
-		}
+// rootPath returns the name used as the first element of field paths.
+func rootPath(t reflect.Type) string {
+	t = derefType(t)
+	if t.Name() != "" {
+		return t.Name()
 	}
+	return t.String()
+}
 
-	// Unwrap pointers
+// derefType unwraps pointer types.
+func derefType(t reflect.Type) reflect.Type {
 	for t.Kind() == reflect.Ptr {
 		t = t.Elem()
 	}
+	return t
+}
+
+// schemaGenerator builds the schema for one root type. Instead of limiting
+// nesting depth, it tracks the struct types whose schemas are being built,
+// so a type graph of any depth is accepted and only true recursion is
+// detected.
+type schemaGenerator struct {
+	cfg      schemaConfig
+	root     reflect.Type
+	building map[reflect.Type]bool // Struct types on the current path
+	refs     map[reflect.Type]int  // Times each named struct type is reached
+	names    map[reflect.Type]string
+	defs     map[string]any // $defs entries by name
+}
+
+// newSchemaGenerator creates a generator for root. In defs mode it walks
+// the type graph first to find the struct types reached more than once and
+// name them.
+func newSchemaGenerator(root reflect.Type, cfg schemaConfig) *schemaGenerator {
+	g := &schemaGenerator{
+		cfg:      cfg,
+		root:     root,
+		building: make(map[reflect.Type]bool),
+	}
+	if cfg.useDefs {
+		g.refs = make(map[reflect.Type]int)
+		var order []reflect.Type
+		g.countRefs(root, &order, make(map[reflect.Type]bool))
+		g.nameDefs(order)
+	}
+	return g
+}
+
+// countRefs counts how often each named struct type is reached from t,
+// appending types to order when first seen. A type's fields are walked
+// only the first time it is reached.
+func (g *schemaGenerator) countRefs(t reflect.Type, order *[]reflect.Type, flattening map[reflect.Type]bool) {
+	t = derefType(t)
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() != "" {
+			g.refs[t]++
+			if g.refs[t] > 1 {
+				return
+			}
+			*order = append(*order, t)
+		}
+		g.countFieldRefs(t, order, flattening)
+	case reflect.Slice, reflect.Array, reflect.Map:
+		g.countRefs(t.Elem(), order, flattening)
+	}
+}
+
+// countFieldRefs counts the struct types reached from t's fields,
+// including the fields of embedded structs.
+func (g *schemaGenerator) countFieldRefs(t reflect.Type, order *[]reflect.Type, flattening map[reflect.Type]bool) {
+	if flattening[t] {
+		return // Recursive embedding is reported during generation
+	}
+	flattening[t] = true
+	defer delete(flattening, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous {
+			if ft := derefType(field.Type); ft.Kind() == reflect.Struct {
+				g.countFieldRefs(ft, order, flattening)
+			}
+			continue
+		}
+		if _, _, skip := parseJSONTag(field.Tag.Get("json")); skip {
+			continue
+		}
+		g.countRefs(field.Type, order, flattening)
+	}
+}
+
+// nameDefs assigns $defs names to the types in order that are reached more
+// than once, other than the root. A name shared by several types is
+// qualified with the package path; types that still collide, such as
+// types declared in different functions, get a numeric suffix.
+func (g *schemaGenerator) nameDefs(order []reflect.Type) {
+	var shared []reflect.Type
+	byName := make(map[string]int)
+	for _, t := range order {
+		if t != g.root && g.refs[t] > 1 {
+			shared = append(shared, t)
+			byName[t.Name()]++
+		}
+	}
+
+	g.names = make(map[reflect.Type]string, len(shared))
+	taken := make(map[string]bool, len(shared))
+	for _, t := range shared {
+		name := t.Name()
+		if byName[name] > 1 && t.PkgPath() != "" {
+			name = t.PkgPath() + "." + name
+		}
+		name = defName(name)
+		unique := name
+		for n := 2; taken[unique]; n++ {
+			unique = fmt.Sprintf("%s_%d", name, n)
+		}
+		taken[unique] = true
+		g.names[t] = unique
+	}
+}
+
+// defName makes a type name safe to use in a $ref JSON pointer: '/' and
+// '~' would need escaping, and generic type arguments add brackets and
+// spaces.
+func defName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		case r == '/':
+			return '.'
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// schema generates the schema for t. The path identifies the current
+// position, e.g. "Recipe.Ingredients[].Amount", and is reported in any
+// SchemaError.
+func (g *schemaGenerator) schema(t reflect.Type, path string) (map[string]any, error) {
+	t = derefType(t)
 
 	switch t.Kind() {
 	case reflect.Struct:
-		return buildStructSchema(t, depth)
+		return g.structRef(t, path)
 	case reflect.String:
 		return map[string]any{"type": "string"}, nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
@@ -50,27 +251,66 @@ func schemaFromTypeWithDepth(t reflect.Type, depth int) (map[string]any, error)
 	case reflect.Bool:
 		return map[string]any{"type": "boolean"}, nil
 	case reflect.Slice, reflect.Array:
-		return buildArraySchema(t, depth)
+		return g.arraySchema(t, path)
 	case reflect.Map:
-		return buildMapSchema(t, depth)
+		return g.mapSchema(t, path)
 	case reflect.Interface:
 		// any/interface{} - no type constraint
 		return map[string]any{}, nil
-	case reflect.Func, reflect.Chan, reflect.Complex64, reflect.Complex128:
+	default:
 		return nil, &SchemaError{
 			Type:   t.String(),
+			Path:   path,
 			Reason: fmt.Sprintf("unsupported type kind: %s", t.Kind()),
 		}
-	default:
+	}
+}
+
+// structRef returns the schema for a struct type: a $ref to its $defs
+// entry or to the root in defs mode, and the inline schema otherwise.
+func (g *schemaGenerator) structRef(t reflect.Type, path string) (map[string]any, error) {
+	if g.building[t] && !g.cfg.useDefs {
 		return nil, &SchemaError{
 			Type:   t.String(),
-			Reason: fmt.Sprintf("unsupported type kind: %s", t.Kind()),
+			Path:   path,
+			Reason: fmt.Sprintf("recursive type %s (use UseDefs to generate a $ref)", t),
 		}
 	}
+	if !g.cfg.useDefs {
+		return g.buildStruct(t, path)
+	}
+
+	if t == g.root {
+		if g.building[t] {
+			return map[string]any{"$ref": "#"}, nil
+		}
+		return g.buildStruct(t, path)
+	}
+	name, shared := g.names[t]
+	if !shared {
+		return g.buildStruct(t, path)
+	}
+
+	ref := map[string]any{"$ref": "#/$defs/" + name}
+	if _, done := g.defs[name]; done || g.building[t] {
+		return ref, nil
+	}
+	schema, err := g.buildStruct(t, path)
+	if err != nil {
+		return nil, err
+	}
+	if g.defs == nil {
+		g.defs = make(map[string]any)
+	}
+	g.defs[name] = schema
+	return ref, nil
 }
 
-// buildStructSchema creates a JSON Schema for a struct type.
-func buildStructSchema(t reflect.Type, depth int) (map[string]any, error) {
+// buildStruct creates the inline JSON Schema for a struct type.
+func (g *schemaGenerator) buildStruct(t reflect.Type, path string) (map[string]any, error) {
+	g.building[t] = true
+	defer delete(g.building, t)
+
 	properties := make(map[string]any)
 	var required []string
 
@@ -84,7 +324,7 @@ func buildStructSchema(t reflect.Type, depth int) (map[string]any, error) {
 
 		// Handle embedded structs
 		if field.Anonymous {
-			embeddedProps, embeddedRequired, err := flattenEmbeddedStruct(field, depth)
+			embeddedProps, embeddedRequired, err := g.flattenEmbedded(field, path)
 			if err != nil {
 				return nil, err
 			}
@@ -105,7 +345,7 @@ func buildStructSchema(t reflect.Type, depth int) (map[string]any, error) {
 		}
 
 		// Build field schema
-		fieldSchema, err := schemaFromTypeWithDepth(field.Type, depth+1)
+		fieldSchema, err := g.schema(field.Type, path+"."+field.Name)
 		if err != nil {
 			return nil, err
 		}
@@ -135,8 +375,9 @@ func buildStructSchema(t reflect.Type, depth int) (map[string]any, error) {
 	return schema, nil
 }
 
-// flattenEmbeddedStruct extracts properties from an embedded struct.
-func flattenEmbeddedStruct(field reflect.StructField, depth int) (map[string]any, []string, error) {
+// flattenEmbedded extracts properties from an embedded struct.
+// Promoted fields are reported under the outer struct's path.
+func (g *schemaGenerator) flattenEmbedded(field reflect.StructField, path string) (map[string]any, []string, error) {
 	t := field.Type
 
 	// Unwrap pointer for embedded struct
@@ -149,6 +390,15 @@ func flattenEmbeddedStruct(field reflect.StructField, depth int) (map[string]any
 		// Not a struct, treat as regular field
 		return nil, nil, nil
 	}
+	if g.building[t] {
+		return nil, nil, &SchemaError{
+			Type:   t.String(),
+			Path:   path,
+			Reason: fmt.Sprintf("recursive embedded type %s", t),
+		}
+	}
+	g.building[t] = true
+	defer delete(g.building, t)
 
 	properties := make(map[string]any)
 	var required []string
@@ -168,7 +418,7 @@ func flattenEmbeddedStruct(field reflect.StructField, depth int) (map[string]any
 			name = f.Name
 		}
 
-		fieldSchema, err := schemaFromTypeWithDepth(f.Type, depth+1)
+		fieldSchema, err := g.schema(f.Type, path+"."+f.Name)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -189,9 +439,9 @@ func flattenEmbeddedStruct(field reflect.StructField, depth int) (map[string]any
 	return properties, required, nil
 }
 
-// buildArraySchema creates a JSON Schema for a slice/array type.
-func buildArraySchema(t reflect.Type, depth int) (map[string]any, error) {
-	elemSchema, err := schemaFromTypeWithDepth(t.Elem(), depth+1)
+// arraySchema creates a JSON Schema for a slice/array type.
+func (g *schemaGenerator) arraySchema(t reflect.Type, path string) (map[string]any, error) {
+	elemSchema, err := g.schema(t.Elem(), path+"[]")
 	if err != nil {
 		return nil, err
 	}
@@ -202,17 +452,19 @@ func buildArraySchema(t reflect.Type, depth int) (map[string]any, error) {
 	}, nil
 }
 
-// buildMapSchema creates a JSON Schema for a map type.
-func buildMapSchema(t reflect.Type, depth int) (map[string]any, error) {
+// mapSchema creates a JSON Schema for a map type.
+// Map values are reported with a "{}" path suffix.
+func (g *schemaGenerator) mapSchema(t reflect.Type, path string) (map[string]any, error) {
 	// Only support string keys
 	if t.Key().Kind() != reflect.String {
 		return nil, &SchemaError{
 			Type:   t.String(),
+			Path:   path,
 			Reason: "only maps with string keys are supported",
 		}
 	}
 
-	valueSchema, err := schemaFromTypeWithDepth(t.Elem(), depth+1)
+	valueSchema, err := g.schema(t.Elem(), path+"{}")
 	if err != nil {
 		return nil, err
 	}
@@ -249,3 +501,85 @@ func parseJSONTag(tag string) (string, bool, bool) {
 
 	return name, omitempty, false
 }
+
+// newUnmarshalError builds a SchemaError for a response that could not be
+// unmarshaled into ptr. It records the JSON path and offset of the mismatch
+// when available, plus the leading portion of the response text.
+// For unmarshal failures the path uses JSON field names.
+// checkSchemaTarget reports whether ptr can receive a response described
+// by the schema type. A nil ptr, typed or untyped, is always accepted, as
+// is any pointer when the schema was not derived from a type.
+func checkSchemaTarget(caller string, ptr any, schemaType reflect.Type) error {
+	t := reflect.TypeOf(ptr)
+	if t == nil {
+		return nil
+	}
+	if t.Kind() != reflect.Ptr {
+		return &SchemaError{
+			Type:   t.String(),
+			Reason: fmt.Sprintf("%s requires a pointer to the result type, got %s", caller, t),
+		}
+	}
+	if isNilPointer(ptr) || schemaType == nil {
+		return nil
+	}
+
+	elem := t
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem != schemaType {
+		return &SchemaError{
+			Type:   rootPath(t),
+			Reason: fmt.Sprintf("%s target %s does not match the WithSchema type %s", caller, t, schemaType),
+		}
+	}
+	return nil
+}
+
+// isNilPointer reports whether v is nil or a nil pointer.
+func isNilPointer(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Ptr && rv.IsNil()
+}
+
+func newUnmarshalError(ptr any, text string, err error) *SchemaError {
+	typeName := "nil"
+	if t := reflect.TypeOf(ptr); t != nil {
+		typeName = rootPath(t)
+	}
+
+	se := &SchemaError{
+		Type:    typeName,
+		Reason:  "failed to unmarshal response",
+		RawText: truncateText(text, maxRawTextLen),
+		Cause:   err,
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		se.Path = typeErr.Field
+		se.Reason = fmt.Sprintf("failed to unmarshal response: JSON %s at offset %d cannot be stored as %s",
+			typeErr.Value, typeErr.Offset, typeErr.Type)
+	case errors.As(err, &syntaxErr):
+		se.Reason = fmt.Sprintf("failed to unmarshal response: invalid JSON at offset %d", syntaxErr.Offset)
+	}
+
+	return se
+}
+
+// truncateText shortens s to at most n bytes without splitting a UTF-8 rune.
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
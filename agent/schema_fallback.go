@@ -0,0 +1,68 @@
+package agent
+
+import "strings"
+
+// SchemaFallbackPrompt sends the schema of WithSchema, WithSchemaRaw, or
+// RunStructured in the prompt rather than with the --json-schema flag, for
+// CLI versions that reject the flag with an *UnsupportedFeatureError. Each
+// prompt ends with the schema and an instruction to answer with matching
+// JSON only, and RunWithSchema takes the JSON from the response, ignoring
+// code fences or text around it.
+//
+// The CLI does not enforce the schema in this mode, so pair it with
+// StrictSchema(true) to reject responses that do not match.
+// Result.ResultText holds the response as Claude wrote it.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.WithSchema(Report{}),
+//	    agent.SchemaFallbackPrompt(true),
+//	    agent.StrictSchema(true),
+//	)
+func SchemaFallbackPrompt(on bool) Option {
+	return func(c *config) {
+		c.schemaFallback = on
+	}
+}
+
+// schemaInPrompt reports whether the schema is sent in the prompt.
+func (c *config) schemaInPrompt() bool {
+	return c.schemaFallback && c.jsonSchema != ""
+}
+
+// withSchemaInstructions appends the schema and an instruction to answer
+// with matching JSON to prompt.
+func withSchemaInstructions(prompt, schema string) string {
+	return prompt + "\n\nRespond with only a JSON value that matches this JSON Schema. " +
+		"Do not add any other text or code fences.\n\n" + schema
+}
+
+// extractJSON returns the JSON value in a response written without the
+// --json-schema flag: the contents of a code fence, or the text from the
+// first '{' or '[' to the last matching bracket. Other text is returned
+// trimmed.
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		if nl := strings.IndexByte(body, '\n'); nl >= 0 {
+			body = body[nl+1:] // Skip the language tag
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			return strings.TrimSpace(body[:end])
+		}
+	}
+	open := strings.IndexAny(text, "{[")
+	if open < 0 {
+		return text
+	}
+	closing := byte('}')
+	if text[open] == '[' {
+		closing = ']'
+	}
+	if end := strings.LastIndexByte(text, closing); end > open {
+		return text[open : end+1]
+	}
+	return text
+}
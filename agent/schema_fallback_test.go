@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// oldCLIScript rejects --json-schema like CLI versions that predate it, and
+// otherwise answers with fenced JSON after recording the prompt.
+const oldCLIScript = `#!/bin/sh
+for arg in "$@"; do
+  if [ "$arg" = "--json-schema" ]; then
+    echo "error: unknown option '--json-schema'" >&2
+    exit 1
+  fi
+done
+head -n 1 > "$(dirname "$0")/stdin.json"
+echo '{"type":"system","subtype":"init","session_id":"old-cli"}'
+printf '%s\n' '{"type":"result","result":"Here you go:\n` + "```json" + `\n{\"value\":\"four\"}\n` + "```" + `","num_turns":1}'
+`
+
+type fallbackAnswer struct {
+	Value string `json:"value"`
+}
+
+func TestUnsupportedSchemaFlag(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	mustWriteFile(t, fakeClaude, []byte(oldCLIScript), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), WithSchema(fallbackAnswer{}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer func() { _ = a.Close() }()
+
+	var answer fallbackAnswer
+	_, err = a.RunWithSchema(ctx, "What is 2+2?", &answer)
+	var unsupported *UnsupportedFeatureError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("RunWithSchema() error = %v, want UnsupportedFeatureError", err)
+	}
+	if unsupported.Flag != "--json-schema" || unsupported.Option != "WithSchema" || unsupported.MinVersion == "" {
+		t.Errorf("error = %+v, want the flag, option, and minimum version", unsupported)
+	}
+	if !strings.Contains(err.Error(), "SchemaFallbackPrompt(true)") {
+		t.Errorf("Error() = %q, want the fallback suggested", err.Error())
+	}
+	var procErr *ProcessError
+	if !errors.As(err, &procErr) || procErr.ExitCode != 1 {
+		t.Errorf("error does not wrap the ProcessError: %v", err)
+	}
+}
+
+func TestSchemaFallbackPrompt(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte(oldCLIScript), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		WithSchema(fallbackAnswer{}),
+		SchemaFallbackPrompt(true),
+		StrictSchema(true),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var answer fallbackAnswer
+	result, err := a.RunWithSchema(ctx, "What is 2+2?", &answer)
+	if err != nil {
+		t.Fatalf("RunWithSchema() error = %v", err)
+	}
+	if answer.Value != "four" {
+		t.Errorf("answer = %+v, want the JSON taken from the fenced response", answer)
+	}
+	if !strings.HasPrefix(result.ResultText, "Here you go:") {
+		t.Errorf("ResultText = %q, want the response as written", result.ResultText)
+	}
+
+	var msg userMessage
+	if err := json.Unmarshal(mustReadFile(t, filepath.Join(tmpDir, "stdin.json")), &msg); err != nil {
+		t.Fatalf("CLI received invalid JSON: %v", err)
+	}
+	prompt := msg.Message.Content[0].Text
+	if !strings.HasPrefix(prompt, "What is 2+2?") || !strings.Contains(prompt, a.cfg.jsonSchema) {
+		t.Errorf("prompt = %q, want the question followed by the schema", prompt)
+	}
+}
+
+func TestClassifyUnknownFlag(t *testing.T) {
+	tests := []struct {
+		text       string
+		flag       string
+		minVersion string
+	}{
+		{"error: unknown option '--json-schema'", "--json-schema", "2.0.42"},
+		{"Error: Unknown option: --setting-sources\nUsage: claude [options]", "--setting-sources", "2.0.0"},
+		{`error: unrecognized flag "--brand-new"`, "--brand-new", ""},
+	}
+	for _, tt := range tests {
+		err := classifyError(tt.text, &ProcessError{ExitCode: 1, Stderr: tt.text})
+		var unsupported *UnsupportedFeatureError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("classifyError(%q) = %v, want UnsupportedFeatureError", tt.text, err)
+			continue
+		}
+		if unsupported.Flag != tt.flag || unsupported.MinVersion != tt.minVersion {
+			t.Errorf("classifyError(%q) = %+v, want flag %s and version %q", tt.text, unsupported, tt.flag, tt.minVersion)
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"a":1}`, `{"a":1}`},
+		{"```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"Sure!\n```\n[1, 2]\n```\nAnything else?", `[1, 2]`},
+		{`The answer is {"a":{"b":2}}. Done.`, `{"a":{"b":2}}`},
+		{"no json here", "no json here"},
+	}
+	for _, tt := range tests {
+		if got := extractJSON(tt.in); got != tt.want {
+			t.Errorf("extractJSON(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SchemaIssue is a likely mistake ValidateSchema found in a JSON Schema.
+type SchemaIssue struct {
+	Path   string // JSON Pointer to the offending schema or keyword, e.g. "#/properties/tags"
+	Reason string
+}
+
+func (i SchemaIssue) String() string {
+	return i.Path + ": " + i.Reason
+}
+
+// maxSchemaDepth is how many levels of nested schemas ValidateSchema
+// accepts, counting the top level.
+const maxSchemaDepth = 32
+
+// schemaKeywords are the JSON Schema keywords that hold no subschemas.
+// Keywords with subschemas are listed with compactSchema.
+var schemaKeywords = []string{
+	"$schema", "$id", "$ref", "$comment", "$anchor",
+	"title", "description", "default", "examples", "deprecated", "readOnly", "writeOnly",
+	"type", "enum", "const", "format",
+	"required", "minProperties", "maxProperties", "dependentRequired",
+	"minItems", "maxItems", "uniqueItems", "minContains", "maxContains",
+	"minLength", "maxLength", "pattern",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+}
+
+// schemaTypeNames are the values of the "type" keyword.
+var schemaTypeNames = []string{"array", "boolean", "integer", "null", "number", "object", "string"}
+
+// schemaTypeAliases maps type names from other languages to JSON Schema's.
+var schemaTypeAliases = map[string]string{
+	"int": "integer", "long": "integer", "float": "number", "double": "number",
+	"str": "string", "bool": "boolean", "dict": "object", "map": "object", "list": "array",
+}
+
+// ValidateSchema checks a JSON Schema, such as one for WithSchemaRaw or a
+// tool's InputSchema, for mistakes that the CLI would not report and that
+// would only show up as the model misbehaving. It returns every issue
+// found, in order of their paths:
+//
+//   - The top level does not have type "object"
+//   - A keyword is unknown, such as "propeties"; "x-" extensions are allowed
+//   - A type name is unknown, such as "int"
+//   - A required property is not in properties
+//   - An array schema has no items
+//   - An enum is empty, or its values do not match the schema's type or
+//     each other's
+//   - Schemas nest more than 32 levels deep
+//
+// Example:
+//
+//	for _, issue := range agent.ValidateSchema(schema) {
+//	    log.Printf("schema %s: %s", issue.Path, issue.Reason)
+//	}
+func ValidateSchema(schema map[string]any) []SchemaIssue {
+	data, err := marshalSchema(schema)
+	if err != nil {
+		return []SchemaIssue{{Path: "#", Reason: "schema is not JSON: " + err.Error()}}
+	}
+	var root map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // Tell integers from other numbers
+	if err := dec.Decode(&root); err != nil || root == nil {
+		return []SchemaIssue{{Path: "#", Reason: "schema is not a JSON object"}}
+	}
+
+	l := &schemaLinter{root: root}
+	top := root
+	if ref, ok := root["$ref"].(string); ok {
+		if target, found := resolveRef(root, ref); found {
+			top = target
+		}
+	}
+	if types := schemaTypes(top["type"]); len(types) != 1 || types[0] != "object" {
+		l.add("#", `top level must have type "object"`)
+	}
+	l.lint(root, "#", 1)
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		return l.issues[i].Path < l.issues[j].Path
+	})
+	return l.issues
+}
+
+// schemaLinter collects the issues ValidateSchema finds.
+type schemaLinter struct {
+	root   map[string]any
+	issues []SchemaIssue
+}
+
+func (l *schemaLinter) add(path, reason string) {
+	l.issues = append(l.issues, SchemaIssue{Path: path, Reason: reason})
+}
+
+// lint checks schema, found at path, and the schemas within it.
+func (l *schemaLinter) lint(schema map[string]any, path string, depth int) {
+	if depth > maxSchemaDepth {
+		l.add(path, fmt.Sprintf("schemas nest more than %d levels deep", maxSchemaDepth))
+		return
+	}
+	for _, k := range sortedKeys(schema) {
+		v, p := schema[k], pointerPath(path, k)
+		switch {
+		case hasString(schemaMapKeywords, k):
+			m, ok := v.(map[string]any)
+			if !ok {
+				l.add(p, fmt.Sprintf("%s must be an object of schemas", k))
+				continue
+			}
+			for _, name := range sortedKeys(m) {
+				l.sub(m[name], pointerPath(p, name), depth)
+			}
+		case hasString(schemaListKeywords, k):
+			list, ok := v.([]any)
+			if !ok || len(list) == 0 {
+				l.add(p, fmt.Sprintf("%s must be a non-empty array of schemas", k))
+				continue
+			}
+			for i, s := range list {
+				l.sub(s, pointerPath(p, fmt.Sprint(i)), depth)
+			}
+		case hasString(schemaValueKeywords, k):
+			if list, ok := v.([]any); ok && k == "items" {
+				for i, s := range list { // A tuple, as written before prefixItems
+					l.sub(s, pointerPath(p, fmt.Sprint(i)), depth)
+				}
+				continue
+			}
+			l.sub(v, p, depth)
+		case hasString(schemaKeywords, k), strings.HasPrefix(k, "x-"):
+		default:
+			reason := fmt.Sprintf("unknown keyword %q", k)
+			if s := closestKeyword(k); s != "" {
+				reason += fmt.Sprintf(" (did you mean %q?)", s)
+			}
+			l.add(p, reason)
+		}
+	}
+	types := l.lintType(schema, path)
+	l.lintRequired(schema, path)
+	if hasString(types, "array") && schema["items"] == nil && schema["prefixItems"] == nil {
+		l.add(path, "array schema has no items")
+	}
+	l.lintEnum(schema, path, types)
+}
+
+// sub checks a value that must be a schema.
+func (l *schemaLinter) sub(v any, path string, depth int) {
+	switch v := v.(type) {
+	case map[string]any:
+		l.lint(v, path, depth+1)
+	case bool:
+		// true and false are schemas that accept anything and nothing
+	default:
+		l.add(path, "not a schema")
+	}
+}
+
+// lintType checks the type keyword and returns the types it names.
+func (l *schemaLinter) lintType(schema map[string]any, path string) []string {
+	t, ok := schema["type"]
+	if !ok {
+		return nil
+	}
+	p := pointerPath(path, "type")
+	types := schemaTypes(t)
+	if len(types) == 0 {
+		l.add(p, "type must be a type name or an array of them")
+		return nil
+	}
+	for _, name := range types {
+		if hasString(schemaTypeNames, name) {
+			continue
+		}
+		reason := fmt.Sprintf("unknown type %q", name)
+		if alias, ok := schemaTypeAliases[strings.ToLower(name)]; ok {
+			reason += fmt.Sprintf(" (did you mean %q?)", alias)
+		}
+		l.add(p, reason)
+	}
+	return types
+}
+
+// lintRequired checks that each required property is in properties.
+func (l *schemaLinter) lintRequired(schema map[string]any, path string) {
+	r, ok := schema["required"]
+	if !ok {
+		return
+	}
+	p := pointerPath(path, "required")
+	required, ok := r.([]any)
+	if !ok {
+		l.add(p, "required must be an array of property names")
+		return
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	for i, name := range required {
+		s, ok := name.(string)
+		switch {
+		case !ok:
+			l.add(pointerPath(p, fmt.Sprint(i)), "required must be an array of property names")
+		case properties[s] == nil:
+			l.add(pointerPath(p, fmt.Sprint(i)), fmt.Sprintf("required property %q is not in properties", s))
+		}
+	}
+}
+
+// lintEnum checks that an enum's values match the schema's types, or,
+// without a type, each other's.
+func (l *schemaLinter) lintEnum(schema map[string]any, path string, types []string) {
+	e, ok := schema["enum"]
+	if !ok {
+		return
+	}
+	p := pointerPath(path, "enum")
+	enum, ok := e.([]any)
+	if !ok || len(enum) == 0 {
+		l.add(p, "enum must be a non-empty array")
+		return
+	}
+	first := ""
+	for i, v := range enum {
+		got := jsonTypeName(v)
+		if len(types) > 0 {
+			if !matchesType(v, types) {
+				l.add(pointerPath(p, fmt.Sprint(i)), fmt.Sprintf("enum value %s is %s, not %s", compactJSON(v), got, strings.Join(types, " or ")))
+			}
+			continue
+		}
+		if got == "integer" {
+			got = "number"
+		}
+		switch {
+		case got == "null":
+		case first == "":
+			first = got
+		case got != first:
+			l.add(pointerPath(p, fmt.Sprint(i)), fmt.Sprintf("enum value %s is %s, unlike the %s values before it", compactJSON(v), got, first))
+		}
+	}
+}
+
+// closestKeyword returns the keyword nearest to k, or "" if none is within
+// two edits.
+func closestKeyword(k string) string {
+	best, bestDistance := "", 3
+	for _, list := range [][]string{schemaKeywords, schemaMapKeywords, schemaValueKeywords, schemaListKeywords} {
+		for _, known := range list {
+			if d := editDistance(strings.ToLower(k), strings.ToLower(known)); d < bestDistance {
+				best, bestDistance = known, d
+			}
+		}
+	}
+	return best
+}
+
+// pointerPath appends a JSON Pointer token to path, escaping "~" and "/".
+func pointerPath(path, token string) string {
+	return path + "/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lintSchemas checks the schemas New is asked to lint: a WithSchemaRaw
+// schema unless LintSchemaRaw(false) is set, and custom tools' input
+// schemas with LintToolSchemas. It returns a *ConfigError naming every
+// issue of the first schema that has any.
+func lintSchemas(cfg *config) error {
+	if cfg.rawSchema != nil && !cfg.looseSchemaRaw {
+		if err := schemaIssuesError("WithSchemaRaw", "", ValidateSchema(cfg.rawSchema)); err != nil {
+			return err
+		}
+	}
+	if !cfg.lintToolSchemas {
+		return nil
+	}
+	names := make([]string, 0, len(cfg.customTools))
+	for name := range cfg.customTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		schema := cfg.customTools[name].InputSchema()
+		if schema == nil {
+			continue // The tool takes no input
+		}
+		if err := schemaIssuesError("CustomTool", name+" ", ValidateSchema(schema)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaIssuesError returns a *ConfigError for option listing issues, or
+// nil if there are none. The Value is the first issue's path, after
+// prefix.
+func schemaIssuesError(option, prefix string, issues []SchemaIssue) error {
+	if len(issues) == 0 {
+		return nil
+	}
+	reasons := []string{issues[0].Reason}
+	for _, issue := range issues[1:] {
+		reasons = append(reasons, issue.String())
+	}
+	return &ConfigError{
+		Option: option,
+		Value:  prefix + issues[0].Path,
+		Reason: strings.Join(reasons, "; "),
+	}
+}
@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// objectSchema returns an object schema with the given properties.
+func objectSchema(properties map[string]any) map[string]any {
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+func TestValidateSchema(t *testing.T) {
+	deep := map[string]any{"type": "string"}
+	for i := 0; i < maxSchemaDepth; i++ {
+		deep = objectSchema(map[string]any{"next": deep})
+	}
+
+	tests := []struct {
+		name   string
+		schema map[string]any
+		want   []string // "path: reason" substrings, in order
+	}{
+		{"array top level", map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			[]string{`#: top level must have type "object"`}},
+		{"missing top-level type", map[string]any{"properties": map[string]any{}},
+			[]string{`#: top level must have type "object"`}},
+		{"unknown keyword", map[string]any{"type": "object", "propeties": map[string]any{}},
+			[]string{`#/propeties: unknown keyword "propeties" (did you mean "properties"?)`}},
+		{"unknown nested keyword", objectSchema(map[string]any{"age": map[string]any{"type": "integer", "minimun": 0}}),
+			[]string{`#/properties/age/minimun: unknown keyword "minimun" (did you mean "minimum"?)`}},
+		{"unknown type", objectSchema(map[string]any{"age": map[string]any{"type": "int"}}),
+			[]string{`#/properties/age/type: unknown type "int" (did you mean "integer"?)`}},
+		{"required not in properties", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"name": map[string]any{"type": "string"}},
+			"required":   []string{"name", "nmae"},
+		}, []string{`#/required/1: required property "nmae" is not in properties`}},
+		{"required not names", map[string]any{"type": "object", "required": "name"},
+			[]string{`#/required: required must be an array of property names`}},
+		{"array without items", objectSchema(map[string]any{"tags": map[string]any{"type": "array"}}),
+			[]string{`#/properties/tags: array schema has no items`}},
+		{"enum off type", objectSchema(map[string]any{"size": map[string]any{"type": "string", "enum": []any{"S", 2, "L"}}}),
+			[]string{`#/properties/size/enum/1: enum value 2 is integer, not string`}},
+		{"enum mixed", objectSchema(map[string]any{"size": map[string]any{"enum": []any{1, 2.5, "L", nil}}}),
+			[]string{`#/properties/size/enum/2: enum value "L" is string, unlike the number values before it`}},
+		{"enum empty", objectSchema(map[string]any{"size": map[string]any{"enum": []any{}}}),
+			[]string{`#/properties/size/enum: enum must be a non-empty array`}},
+		{"too deep", deep,
+			[]string{`#` + strings.Repeat("/properties/next", maxSchemaDepth) + `: schemas nest more than 32 levels deep`}},
+		{"not a schema", objectSchema(map[string]any{"name": "string"}),
+			[]string{`#/properties/name: not a schema`}},
+		{"escaped path", objectSchema(map[string]any{"a/b": map[string]any{"type": "float"}}),
+			[]string{`#/properties/a~1b/type: unknown type "float" (did you mean "number"?)`}},
+		{"several issues", map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"tags": map[string]any{"type": "array"}},
+			"required":   []any{"id"},
+			"titel":      "Order",
+		}, []string{
+			`#/properties/tags: array schema has no items`,
+			`#/required/0: required property "id" is not in properties`,
+			`#/titel: unknown keyword "titel" (did you mean "title"?)`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := ValidateSchema(tt.schema)
+			if len(issues) != len(tt.want) {
+				t.Fatalf("ValidateSchema() = %v, want %d issues", issues, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got := issues[i].String(); got != want {
+					t.Errorf("issue %d = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSchemaValid(t *testing.T) {
+	const schemaJSON = `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "Order",
+		"type": "object",
+		"$defs": {
+			"money": {"type": "object", "properties": {"amount": {"type": "number", "minimum": 0}, "currency": {"type": "string", "enum": ["USD", "EUR"]}}, "required": ["amount", "currency"], "additionalProperties": false}
+		},
+		"properties": {
+			"id": {"type": "string", "pattern": "^ord_[a-z0-9]+$", "description": "Order ID"},
+			"total": {"$ref": "#/$defs/money"},
+			"lines": {"type": "array", "minItems": 1, "items": {"type": "object", "properties": {"sku": {"type": "string"}, "qty": {"type": "integer", "minimum": 1}}, "required": ["sku", "qty"]}},
+			"status": {"type": ["string", "null"], "enum": ["open", "shipped", null]},
+			"priority": {"enum": [1, 2.5, 3]},
+			"coupon": {"anyOf": [{"type": "string"}, {"type": "null"}]},
+			"point": {"type": "array", "prefixItems": [{"type": "number"}, {"type": "number"}]},
+			"meta": {"type": "object", "additionalProperties": {"type": "string"}, "x-internal": true},
+			"anything": true
+		},
+		"required": ["id", "total", "lines"]
+	}`
+	var schema, before map[string]any
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		t.Fatal(err)
+	}
+	_ = json.Unmarshal([]byte(schemaJSON), &before)
+	if issues := ValidateSchema(schema); len(issues) != 0 {
+		t.Errorf("ValidateSchema() = %v, want no issues", issues)
+	}
+	if !reflect.DeepEqual(schema, before) {
+		t.Error("ValidateSchema() modified the schema")
+	}
+
+	// Schemas written in Go and generated ones pass too
+	generated, err := SchemaFor(struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gen map[string]any
+	_ = json.Unmarshal([]byte(generated), &gen)
+	if issues := ValidateSchema(gen); len(issues) != 0 {
+		t.Errorf("ValidateSchema(SchemaFor()) = %v, want no issues", issues)
+	}
+	if issues := ValidateSchema(nil); len(issues) != 1 || issues[0].Path != "#" {
+		t.Errorf("ValidateSchema(nil) = %v, want one top-level issue", issues)
+	}
+}
+
+func TestLintSchemasInNew(t *testing.T) {
+	ctx := context.Background()
+	cli := resultCLI(t, "ok")
+	typo := map[string]any{"type": "object", "propeties": map[string]any{}, "required": []string{"name"}}
+
+	_, err := New(ctx, CLIPath(cli), WithSchemaRaw(typo))
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Option != "WithSchemaRaw" || cerr.Value != "#/propeties" ||
+		!strings.Contains(cerr.Reason, `#/required/0: required property "name"`) {
+		t.Fatalf("New(WithSchemaRaw) error = %v, want a *ConfigError listing both issues", err)
+	}
+
+	// Opting out, or replacing the raw schema, skips the check
+	for _, opts := range [][]Option{
+		{WithSchemaRaw(typo), LintSchemaRaw(false)},
+		{WithSchemaRaw(typo), WithSchemaString(`{"type":"object"}`)},
+	} {
+		a, err := New(ctx, append([]Option{CLIPath(cli)}, opts...)...)
+		if err != nil {
+			t.Fatalf("New() error = %v, want the schema accepted", err)
+		}
+		mustClose(t, a)
+	}
+
+	// Tool schemas are checked only when asked
+	loose := NewFuncTool("lookup", "Looks up a record", map[string]any{"type": "object", "properties": map[string]any{"id": map[string]any{"type": "str"}}}, nil)
+	plain := NewFuncTool("ping", "Takes no input", nil, nil)
+	a, err := New(ctx, CLIPath(cli), CustomTool(loose, plain))
+	if err != nil {
+		t.Fatalf("New() with a loose tool schema error = %v", err)
+	}
+	mustClose(t, a)
+	_, err = New(ctx, CLIPath(cli), CustomTool(loose, plain), LintToolSchemas(true))
+	if !errors.As(err, &cerr) || cerr.Option != "CustomTool" || cerr.Value != "lookup #/properties/id/type" {
+		t.Errorf("New(LintToolSchemas) error = %v, want a *ConfigError naming the tool", err)
+	}
+}
@@ -1,11 +1,20 @@
 package agent
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"flag"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
 func TestSchemaFromType_BasicTypes(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -328,7 +337,10 @@ func TestSchemaFromType_UnsupportedType_Func(t *testing.T) {
 
 	var schemaErr *SchemaError
 	if !errors.As(err, &schemaErr) {
-		t.Errorf("error type = %T, want *SchemaError", err)
+		t.Fatalf("error type = %T, want *SchemaError", err)
+	}
+	if schemaErr.Path != "Example.Fn" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "Example.Fn")
 	}
 }
 
@@ -344,7 +356,10 @@ func TestSchemaFromType_UnsupportedType_Chan(t *testing.T) {
 
 	var schemaErr *SchemaError
 	if !errors.As(err, &schemaErr) {
-		t.Errorf("error type = %T, want *SchemaError", err)
+		t.Fatalf("error type = %T, want *SchemaError", err)
+	}
+	if schemaErr.Path != "Example.Ch" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "Example.Ch")
 	}
 }
 
@@ -360,7 +375,94 @@ func TestSchemaFromType_UnsupportedType_MapIntKey(t *testing.T) {
 
 	var schemaErr *SchemaError
 	if !errors.As(err, &schemaErr) {
-		t.Errorf("error type = %T, want *SchemaError", err)
+		t.Fatalf("error type = %T, want *SchemaError", err)
+	}
+	if schemaErr.Path != "Example.Data" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "Example.Data")
+	}
+}
+
+func TestSchemaFromType_NestedErrorPath(t *testing.T) {
+	type Amount struct {
+		Units map[int]string `json:"units"`
+	}
+	type Ingredient struct {
+		Amount Amount `json:"amount"`
+	}
+	type Recipe struct {
+		Ingredients []Ingredient        `json:"ingredients"`
+		Notes       map[string][]Amount `json:"notes"`
+	}
+
+	_, err := schemaFromValue(&Recipe{})
+
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("error type = %T, want *SchemaError", err)
+	}
+	want := "Recipe.Ingredients[].Amount.Units"
+	if schemaErr.Path != want {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("Error() = %q, should contain path %q", err.Error(), want)
+	}
+}
+
+func TestSchemaFromType_MapValueErrorPath(t *testing.T) {
+	type Example struct {
+		Handlers map[string]func() `json:"handlers"`
+	}
+
+	_, err := schemaFromValue(Example{})
+
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("error type = %T, want *SchemaError", err)
+	}
+	if schemaErr.Path != "Example.Handlers{}" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "Example.Handlers{}")
+	}
+}
+
+func TestNewUnmarshalError(t *testing.T) {
+	type Recipe struct {
+		Servings int `json:"servings"`
+	}
+
+	text := `{"servings":"four"}`
+	var r Recipe
+	err := json.Unmarshal([]byte(text), &r)
+
+	se := newUnmarshalError(&r, text, err)
+	if se.Type != "Recipe" {
+		t.Errorf("Type = %q, want %q", se.Type, "Recipe")
+	}
+	if se.Path != "servings" {
+		t.Errorf("Path = %q, want %q", se.Path, "servings")
+	}
+	if !strings.Contains(se.Reason, "offset") {
+		t.Errorf("Reason = %q, should mention offset", se.Reason)
+	}
+	if se.RawText != text {
+		t.Errorf("RawText = %q, want %q", se.RawText, text)
+	}
+	if strings.Contains(se.Error(), "four") {
+		t.Errorf("Error() = %q, want the response text left out", se.Error())
+	}
+}
+
+func TestNewUnmarshalErrorTruncatesRawText(t *testing.T) {
+	text := strings.Repeat("é", 400) // 800 bytes
+	var v map[string]any
+	err := json.Unmarshal([]byte(text), &v)
+
+	se := newUnmarshalError(&v, text, err)
+	if len(se.RawText) > maxRawTextLen {
+		t.Errorf("len(RawText) = %d, want <= %d", len(se.RawText), maxRawTextLen)
+	}
+	if !utf8.ValidString(se.RawText) {
+		t.Error("RawText should not split a UTF-8 rune")
 	}
 }
 
@@ -501,3 +603,314 @@ func TestSchemaFromType_ComplexNested(t *testing.T) {
 		t.Errorf("ingredients.description = %v, want 'List of ingredients'", ingredients["description"])
 	}
 }
+
+// goldenRecipe is a representative nested type for golden-file schema tests.
+type goldenRecipe struct {
+	Name        string             `json:"name" desc:"Recipe name"`
+	Servings    int                `json:"servings"`
+	Ingredients []goldenIngredient `json:"ingredients" desc:"List of ingredients"`
+	Nutrition   map[string]float64 `json:"nutrition,omitempty"`
+	Tags        []string           `json:"tags,omitempty"`
+	Notes       *string            `json:"notes,omitempty"`
+	GoldenMeta
+}
+
+type goldenIngredient struct {
+	Item     string  `json:"item"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit,omitempty" desc:"Unit of measure, e.g. <g> & <ml>"`
+}
+
+type GoldenMeta struct {
+	Author string `json:"author"`
+	Source any    `json:"source"`
+}
+
+func TestSchemaForDeterministic(t *testing.T) {
+	first, err := SchemaFor(goldenRecipe{})
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		got, err := SchemaFor(goldenRecipe{})
+		if err != nil {
+			t.Fatalf("SchemaFor() error = %v", err)
+		}
+		if got != first {
+			t.Fatalf("SchemaFor() run %d differs:\n%s\nwant:\n%s", i, got, first)
+		}
+	}
+}
+
+func TestSchemaForGolden(t *testing.T) {
+	got, err := SchemaFor(&goldenRecipe{})
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+
+	golden := filepath.Join("testdata", "recipe.schema.json")
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got+"\n"), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	want := mustReadFile(t, golden)
+	if got != string(bytes.TrimSpace(want)) {
+		t.Errorf("SchemaFor() = \n%s\nwant (run with -update to refresh):\n%s", got, want)
+	}
+}
+
+func TestSchemaForMatchesWithSchema(t *testing.T) {
+	want, err := SchemaFor(goldenRecipe{})
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+
+	cfg := newConfig(WithSchema(goldenRecipe{}))
+	if cfg.jsonSchema != want {
+		t.Errorf("WithSchema schema = %s, want %s", cfg.jsonSchema, want)
+	}
+}
+
+func TestSchemaForError(t *testing.T) {
+	type Bad struct {
+		Callback func() `json:"callback"`
+	}
+
+	_, err := SchemaFor(Bad{})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("SchemaFor() error = %v, want *SchemaError", err)
+	}
+	if schemaErr.Path != "Bad.Callback" {
+		t.Errorf("Path = %q, want %q", schemaErr.Path, "Bad.Callback")
+	}
+}
+
+func TestWithSchemaValueAndPointerEquivalent(t *testing.T) {
+	want := newConfig(WithSchema(goldenRecipe{}))
+	if want.schemaError != nil {
+		t.Fatalf("WithSchema(value) error = %v", want.schemaError)
+	}
+
+	for name, example := range map[string]any{
+		"pointer":           &goldenRecipe{},
+		"nil typed pointer": (*goldenRecipe)(nil),
+		"pointer pointer":   new(*goldenRecipe),
+	} {
+		got := newConfig(WithSchema(example))
+		if got.schemaError != nil {
+			t.Errorf("WithSchema(%s) error = %v", name, got.schemaError)
+			continue
+		}
+		if got.jsonSchema != want.jsonSchema || got.schemaType != want.schemaType {
+			t.Errorf("WithSchema(%s) = %s (%v), want %s (%v)", name, got.jsonSchema, got.schemaType, want.jsonSchema, want.schemaType)
+		}
+	}
+}
+
+func TestWithSchemaRawClearsSchemaType(t *testing.T) {
+	c := newConfig(WithSchema(goldenRecipe{}), WithSchemaString(`{"type":"object"}`))
+	if c.schemaType != nil {
+		t.Errorf("schemaType = %v after WithSchemaString, want nil", c.schemaType)
+	}
+}
+
+func TestCheckSchemaTarget(t *testing.T) {
+	recipe := reflect.TypeOf(goldenRecipe{})
+	var nilRecipe *goldenRecipe
+	var recipePtr *goldenRecipe = &goldenRecipe{}
+
+	tests := []struct {
+		name       string
+		ptr        any
+		schemaType reflect.Type
+		wantErr    string
+	}{
+		{"matching pointer", &goldenRecipe{}, recipe, ""},
+		{"pointer to pointer", &recipePtr, recipe, ""},
+		{"untyped nil", nil, recipe, ""},
+		{"nil typed pointer", nilRecipe, recipe, ""},
+		{"raw schema", &goldenIngredient{}, nil, ""},
+		{"mismatched pointer", &goldenIngredient{}, recipe,
+			"RunWithSchema target *agent.goldenIngredient does not match the WithSchema type agent.goldenRecipe"},
+		{"value", goldenRecipe{}, recipe, "RunWithSchema requires a pointer to the result type, got agent.goldenRecipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchemaTarget("RunWithSchema", tt.ptr, tt.schemaType)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkSchemaTarget() error = %v, want nil", err)
+				}
+				return
+			}
+			var schemaErr *SchemaError
+			if !errors.As(err, &schemaErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkSchemaTarget() error = %v, want *SchemaError containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// defsOrder shares defsMoney between fields without recursion.
+type defsOrder struct {
+	ID    string     `json:"id"`
+	Total defsMoney  `json:"total" desc:"Order total"`
+	Lines []defsLine `json:"lines"`
+}
+
+type defsLine struct {
+	Item     string     `json:"item"`
+	Price    defsMoney  `json:"price"`
+	Discount *defsMoney `json:"discount,omitempty"`
+}
+
+type defsMoney struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// defsInvoice adds a recursive type and a reference back to the root.
+type defsInvoice struct {
+	Order       defsOrder     `json:"order"`
+	Customer    defsCustomer  `json:"customer"`
+	Corrections []defsInvoice `json:"corrections,omitempty"`
+}
+
+type defsCustomer struct {
+	Name     string        `json:"name"`
+	Credit   defsMoney     `json:"credit"`
+	Referrer *defsCustomer `json:"referrer,omitempty"`
+}
+
+// checkSchemaGolden compares a generated schema with a file in testdata.
+func checkSchemaGolden(t *testing.T, got, name string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(golden, []byte(got+"\n"), 0600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	want := mustReadFile(t, golden)
+	if got != string(bytes.TrimSpace(want)) {
+		t.Errorf("schema = \n%s\nwant %s (run with -update to refresh):\n%s", got, name, want)
+	}
+}
+
+func TestSchemaForSharedTypesGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []SchemaOption
+		golden string
+	}{
+		{"inline", nil, "order.schema.json"},
+		{"defs", []SchemaOption{UseDefs(true)}, "order_defs.schema.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SchemaFor(defsOrder{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("SchemaFor() error = %v", err)
+			}
+			checkSchemaGolden(t, got, tt.golden)
+		})
+	}
+}
+
+func TestSchemaForRecursiveTypes(t *testing.T) {
+	got, err := SchemaFor(defsInvoice{}, UseDefs(true))
+	if err != nil {
+		t.Fatalf("SchemaFor(UseDefs) error = %v", err)
+	}
+	checkSchemaGolden(t, got, "invoice_defs.schema.json")
+
+	// Without $defs, recursion is an error naming where it was found
+	_, err = SchemaFor(defsInvoice{})
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("SchemaFor() error = %v, want *SchemaError", err)
+	}
+	if schemaErr.Path != "defsInvoice.Customer.Referrer" || !strings.Contains(schemaErr.Reason, "UseDefs") {
+		t.Errorf("SchemaError = %+v, want the recursive Referrer field and a UseDefs hint", schemaErr)
+	}
+}
+
+func TestSchemaForDeepTypes(t *testing.T) {
+	// Twelve levels of nesting without recursion
+	type L12 struct {
+		Leaf string `json:"leaf"`
+	}
+	type L11 struct{ Next L12 }
+	type L10 struct{ Next L11 }
+	type L9 struct{ Next L10 }
+	type L8 struct{ Next L9 }
+	type L7 struct{ Next L8 }
+	type L6 struct{ Next L7 }
+	type L5 struct{ Next []L6 }
+	type L4 struct{ Next map[string]L5 }
+	type L3 struct{ Next *L4 }
+	type L2 struct{ Next L3 }
+	type L1 struct{ Next L2 }
+
+	for _, opts := range [][]SchemaOption{nil, {UseDefs(true)}} {
+		if _, err := SchemaFor(L1{}, opts...); err != nil {
+			t.Errorf("SchemaFor(%d options) error = %v, want deep types to be accepted", len(opts), err)
+		}
+	}
+}
+
+// Two distinct types named Money, declared in different functions.
+func centsMoney() reflect.Type {
+	type Money struct {
+		Cents int `json:"cents"`
+	}
+	return reflect.TypeOf(Money{})
+}
+
+func unitsMoney() reflect.Type {
+	type Money struct {
+		Units float64 `json:"units"`
+	}
+	return reflect.TypeOf(Money{})
+}
+
+func TestSchemaDefsNameCollisions(t *testing.T) {
+	cents, units := centsMoney(), unitsMoney()
+	root := reflect.StructOf([]reflect.StructField{
+		{Name: "A", Type: cents, Tag: `json:"a"`},
+		{Name: "B", Type: cents, Tag: `json:"b"`},
+		{Name: "C", Type: units, Tag: `json:"c"`},
+		{Name: "D", Type: units, Tag: `json:"d"`},
+	})
+
+	schema, err := schemaFromType(root, UseDefs(true))
+	if err != nil {
+		t.Fatalf("schemaFromType() error = %v", err)
+	}
+	defs, _ := schema["$defs"].(map[string]any)
+	properties := schema["properties"].(map[string]any)
+
+	refs := make(map[string]string)
+	for _, field := range []string{"a", "b", "c", "d"} {
+		ref, _ := properties[field].(map[string]any)["$ref"].(string)
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		if _, ok := defs[name]; !ok {
+			t.Errorf("property %s $ref = %q, want a $defs entry", field, ref)
+		}
+		if strings.ContainsAny(name, "/~[] ") {
+			t.Errorf("$defs name %q needs escaping in a $ref", name)
+		}
+		refs[field] = ref
+	}
+	if len(defs) != 2 || refs["a"] != refs["b"] || refs["c"] != refs["d"] || refs["a"] == refs["c"] {
+		t.Errorf("$refs = %v with $defs %v, want one entry per Money type", refs, defs)
+	}
+	want := "github.com.wernerstrydom.claude-agent-sdk-go.agent.Money"
+	if refs["a"] != "#/$defs/"+want || refs["c"] != "#/$defs/"+want+"_2" {
+		t.Errorf("$refs = %v, want package-qualified names with a suffix for the second", refs)
+	}
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SchemaViolation describes one way a response does not match the schema.
+type SchemaViolation struct {
+	Path   string // JSON path of the offending value, e.g. "$.items[2].name"
+	Reason string
+}
+
+// validateResponse decodes text as JSON and checks it against the JSON
+// Schema in schemaJSON. It returns a *SchemaError if text is not valid JSON
+// and a *SchemaValidationError listing every violation otherwise.
+func validateResponse(typeName, schemaJSON, text string) error {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		return &SchemaError{Type: typeName, Reason: "invalid JSON Schema", Cause: err}
+	}
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber() // Tell integers from other numbers
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		se := newUnmarshalError(nil, text, err)
+		se.Type = typeName
+		return se
+	}
+	if dec.More() {
+		return &SchemaError{
+			Type:    typeName,
+			Reason:  "response has data after the JSON value",
+			RawText: truncateText(text, maxRawTextLen),
+		}
+	}
+
+	violations := validateSchema(schema, schema, value, "$")
+	if len(violations) == 0 {
+		return nil
+	}
+	return &SchemaValidationError{
+		Type:       typeName,
+		Violations: violations,
+		RawText:    truncateText(text, maxRawTextLen),
+	}
+}
+
+// validateSchema checks a decoded JSON value against a JSON Schema and
+// returns every violation found. It supports the keywords that SchemaFor
+// generates: type, properties, required, additionalProperties, items,
+// enum, and $ref to a location in root, the top-level schema. Other
+// keywords are ignored. Numbers must be decoded as json.Number.
+func validateSchema(root, schema map[string]any, value any, path string) []SchemaViolation {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, found := resolveRef(root, ref)
+		if !found {
+			return []SchemaViolation{{Path: path, Reason: fmt.Sprintf("unresolvable $ref %q", ref)}}
+		}
+		schema = target
+	}
+	if len(schema) == 0 {
+		return nil // Accepts anything
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchesType(value, types) {
+		return []SchemaViolation{{
+			Path:   path,
+			Reason: fmt.Sprintf("expected %s, got %s", strings.Join(types, " or "), jsonTypeName(value)),
+		}}
+	}
+
+	var violations []SchemaViolation
+	if enum, ok := schema["enum"].([]any); ok && !inEnum(value, enum) {
+		violations = append(violations, SchemaViolation{
+			Path:   path,
+			Reason: fmt.Sprintf("value %s is not one of %s", compactJSON(value), compactJSON(enum)),
+		})
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		violations = append(violations, validateObject(root, schema, v, path)...)
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, elem := range v {
+				violations = append(violations, validateSchema(root, items, elem, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return violations
+}
+
+// validateObject checks required fields, declared properties, and
+// additional properties of an object, in sorted key order.
+func validateObject(root, schema map[string]any, obj map[string]any, path string) []SchemaViolation {
+	var violations []SchemaViolation
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, present := obj[name]; !present {
+				violations = append(violations, SchemaViolation{
+					Path:   jsonPathKey(path, name),
+					Reason: "required field is missing",
+				})
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if prop, ok := properties[k].(map[string]any); ok {
+			violations = append(violations, validateSchema(root, prop, obj[k], jsonPathKey(path, k))...)
+			continue
+		}
+		if _, declared := properties[k]; declared {
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				violations = append(violations, SchemaViolation{
+					Path:   jsonPathKey(path, k),
+					Reason: "unexpected field",
+				})
+			}
+		case map[string]any:
+			violations = append(violations, validateSchema(root, extra, obj[k], jsonPathKey(path, k))...)
+		}
+	}
+	return violations
+}
+
+// resolveRef returns the schema a local $ref such as "#" or
+// "#/$defs/Money" points to within root.
+func resolveRef(root map[string]any, ref string) (map[string]any, bool) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, false // Only references within the schema are supported
+	}
+	schema := root
+	for _, token := range strings.Split(pointer, "/")[1:] {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		next, ok := schema[token].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		schema = next
+	}
+	return schema, true
+}
+
+// schemaTypes returns the types allowed by a schema's "type" keyword,
+// which may be a single name or a list.
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, name := range t {
+			if s, ok := name.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// matchesType reports whether a decoded JSON value has one of the types.
+func matchesType(value any, types []string) bool {
+	got := jsonTypeName(value)
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type of a decoded JSON value.
+// Numbers without a fraction or exponent are reported as "integer".
+func jsonTypeName(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		if !strings.ContainsAny(v.String(), ".eE") {
+			return "integer" // Too large for int64, still whole
+		}
+		return "number"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// inEnum reports whether value equals one of the enum members, comparing
+// their JSON encodings with object keys sorted.
+func inEnum(value any, enum []any) bool {
+	want := compactJSON(value)
+	for _, member := range enum {
+		if compactJSON(member) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// compactJSON encodes v with sorted keys and no insignificant whitespace.
+func compactJSON(v any) string {
+	data, err := marshalSchema(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
+
+// identPattern matches object keys that can appear in dot notation.
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPathKey appends an object key to a JSON path, quoting keys that are
+// not identifiers: $.name, $["first name"].
+func jsonPathKey(path, key string) string {
+	if identPattern.MatchString(key) {
+		return path + "." + key
+	}
+	quoted, _ := json.Marshal(key)
+	return path + "[" + string(quoted) + "]"
+}
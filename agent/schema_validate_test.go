@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validationRecipe exercises nested structs, arrays of structs, and maps.
+type validationRecipe struct {
+	Name        string                    `json:"name"`
+	Servings    int                       `json:"servings"`
+	Ingredients []goldenIngredient        `json:"ingredients"`
+	Nutrition   map[string]float64        `json:"nutrition,omitempty"`
+	Steps       map[string]validationStep `json:"steps,omitempty"`
+	Author      validationAuthor          `json:"author"`
+	Notes       *string                   `json:"notes,omitempty"`
+}
+
+type validationStep struct {
+	Minutes int `json:"minutes"`
+}
+
+type validationAuthor struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+}
+
+func TestValidateResponse(t *testing.T) {
+	schema, err := SchemaFor(validationRecipe{})
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want []SchemaViolation
+	}{
+		{
+			name: "complete document",
+			text: `{"name":"Soup","servings":4,
+				"ingredients":[{"item":"leek","quantity":2,"unit":"pc"},{"item":"stock","quantity":1.5}],
+				"nutrition":{"kcal":120.5,"protein":4},
+				"steps":{"chop":{"minutes":5},"simmer":{"minutes":20}},
+				"author":{"name":"Ada","email":"ada@example.com"},
+				"notes":"serve hot"}`,
+		},
+		{
+			name: "missing top-level fields",
+			text: `{"name":"Soup","author":{"name":"Ada"}}`,
+			want: []SchemaViolation{
+				{"$.servings", "required field is missing"},
+				{"$.ingredients", "required field is missing"},
+			},
+		},
+		{
+			name: "missing nested field",
+			text: `{"name":"Soup","servings":4,"ingredients":[],"author":{}}`,
+			want: []SchemaViolation{{"$.author.name", "required field is missing"}},
+		},
+		{
+			name: "array of structs",
+			text: `{"name":"Soup","servings":4,"author":{"name":"Ada"},
+				"ingredients":[{"item":"leek","quantity":2},{"quantity":"a lot"}]}`,
+			want: []SchemaViolation{
+				{"$.ingredients[1].item", "required field is missing"},
+				{"$.ingredients[1].quantity", "expected number, got string"},
+			},
+		},
+		{
+			name: "map values",
+			text: `{"name":"Soup","servings":4,"ingredients":[],"author":{"name":"Ada"},
+				"nutrition":{"kcal":"high","total fat":1},
+				"steps":{"chop":{},"stir well":{"minutes":1.5}}}`,
+			want: []SchemaViolation{
+				{"$.nutrition.kcal", "expected number, got string"},
+				{"$.steps.chop.minutes", "required field is missing"},
+				{`$.steps["stir well"].minutes`, "expected integer, got number"},
+			},
+		},
+		{
+			name: "wrong types",
+			text: `{"name":7,"servings":4.5,"ingredients":{},"author":"Ada","notes":null}`,
+			want: []SchemaViolation{
+				{"$.author", "expected object, got string"},
+				{"$.ingredients", "expected array, got object"},
+				{"$.name", "expected string, got integer"},
+				{"$.notes", "expected string, got null"},
+				{"$.servings", "expected integer, got number"},
+			},
+		},
+		{
+			name: "not an object",
+			text: `["Soup"]`,
+			want: []SchemaViolation{{"$", "expected object, got array"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateResponse("validationRecipe", schema, tt.text)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("validateResponse() error = %v, want nil", err)
+				}
+				return
+			}
+			var verr *SchemaValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("validateResponse() error = %v, want *SchemaValidationError", err)
+			}
+			if !reflect.DeepEqual(verr.Violations, tt.want) {
+				t.Errorf("Violations =\n%v\nwant\n%v", verr.Violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateResponseRawSchemaKeywords(t *testing.T) {
+	schema := `{"type":"object","required":["status"],"additionalProperties":false,
+		"properties":{
+			"status":{"type":"string","enum":["open","closed"]},
+			"priority":{"type":["integer","null"],"enum":[1,2,3,null]},
+			"meta":{"type":"object","additionalProperties":{"type":"boolean"}}
+		}}`
+
+	tests := []struct {
+		text string
+		want []SchemaViolation
+	}{
+		{`{"status":"open","priority":2,"meta":{"a":true}}`, nil},
+		{`{"status":"open","priority":null}`, nil},
+		{`{"status":"pending"}`, []SchemaViolation{{"$.status", `value "pending" is not one of ["open","closed"]`}}},
+		{`{"status":"open","priority":4}`, []SchemaViolation{{"$.priority", `value 4 is not one of [1,2,3,null]`}}},
+		{`{"status":"open","owner":"me"}`, []SchemaViolation{{"$.owner", "unexpected field"}}},
+		{`{"status":"open","meta":{"a":1}}`, []SchemaViolation{{"$.meta.a", "expected boolean, got integer"}}},
+	}
+	for _, tt := range tests {
+		err := validateResponse("schema", schema, tt.text)
+		var verr *SchemaValidationError
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("validateResponse(%s) error = %v, want nil", tt.text, err)
+		case tt.want != nil && !errors.As(err, &verr):
+			t.Errorf("validateResponse(%s) error = %v, want *SchemaValidationError", tt.text, err)
+		case tt.want != nil && !reflect.DeepEqual(verr.Violations, tt.want):
+			t.Errorf("validateResponse(%s) Violations = %v, want %v", tt.text, verr.Violations, tt.want)
+		}
+	}
+}
+
+func TestValidateResponseDefsRefs(t *testing.T) {
+	schema, err := SchemaFor(defsInvoice{}, UseDefs(true))
+	if err != nil {
+		t.Fatalf("SchemaFor() error = %v", err)
+	}
+
+	text := `{"order":{"id":"A1","total":{"amount":100,"currency":"EUR"},"lines":[]},
+		"customer":{"name":"Ada","credit":{"amount":0,"currency":"EUR"},
+			"referrer":{"name":"Bob","credit":{"amount":"none","currency":"EUR"}}},
+		"corrections":[{"order":{"id":"A2","lines":[]},"customer":{"name":"Ada","credit":{"amount":1,"currency":"EUR"}}}]}`
+	want := []SchemaViolation{
+		{"$.corrections[0].order.total", "required field is missing"},
+		{"$.customer.referrer.credit.amount", "expected integer, got string"},
+	}
+
+	err = validateResponse("defsInvoice", schema, text)
+	var verr *SchemaValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("validateResponse() error = %v, want *SchemaValidationError", err)
+	}
+	if !reflect.DeepEqual(verr.Violations, want) {
+		t.Errorf("Violations = %v, want %v", verr.Violations, want)
+	}
+
+	err = validateResponse("schema", `{"properties":{"a":{"$ref":"#/$defs/Missing"}}}`, `{"a":1}`)
+	if !errors.As(err, &verr) || verr.Violations[0].Reason != `unresolvable $ref "#/$defs/Missing"` {
+		t.Errorf("validateResponse() error = %v, want an unresolvable $ref violation", err)
+	}
+}
+
+func TestValidateResponseNotJSON(t *testing.T) {
+	for _, text := range []string{"The answer is four.", `{"value":4} and some prose`} {
+		err := validateResponse("Answer", `{"type":"object"}`, text)
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) || schemaErr.Type != "Answer" {
+			t.Errorf("validateResponse(%q) error = %v, want *SchemaError for Answer", text, err)
+		}
+	}
+}
+
+func TestSchemaValidationErrorMessage(t *testing.T) {
+	err := &SchemaValidationError{Type: "Answer", Violations: []SchemaViolation{
+		{"$.value", "required field is missing"},
+		{"$.extra", "unexpected field"},
+	}}
+	want := "agent: response does not match schema for type Answer: $.value: required field is missing; $.extra: unexpected field"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestRunWithSchemaStrict(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"schema-strict"}'
+echo '{"type":"result","result":"{\"author\":{}}","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	type Author struct {
+		Name string `json:"name"`
+	}
+	type Answer struct {
+		Value  int    `json:"value"`
+		Author Author `json:"author"`
+	}
+
+	ctx := context.Background()
+	for _, strict := range []bool{false, true} {
+		a, err := New(ctx, CLIPath(fakeClaude), WithSchema(Answer{}), StrictSchema(strict))
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+
+		answer := Answer{Value: -1}
+		result, err := a.RunWithSchema(ctx, "What is 2+2?", &answer)
+		mustClose(t, a)
+
+		if !strict {
+			// Missing fields are silently left as they were
+			if err != nil || answer.Value != -1 {
+				t.Errorf("non-strict RunWithSchema() = %+v, %v; want a partial result and no error", answer, err)
+			}
+			continue
+		}
+		var verr *SchemaValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("strict RunWithSchema() error = %v, want *SchemaValidationError", err)
+		}
+		if result == nil {
+			t.Error("strict RunWithSchema() should return the result alongside the error")
+		}
+		if answer.Value != -1 {
+			t.Errorf("strict RunWithSchema() modified ptr: %+v", answer)
+		}
+		if verr.Type != "Answer" || len(verr.Violations) != 2 || !strings.Contains(err.Error(), "$.author.name") {
+			t.Errorf("SchemaValidationError = %v, want $.value and $.author.name missing", err)
+		}
+	}
+}
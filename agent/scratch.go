@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ScratchDir gives the agent a temporary directory of its own, created by
+// New with a unique name in the system temp directory, so that concurrent
+// agents do not collide on the same files. File tool paths under /tmp are
+// rewritten into it, the CLI is allowed to access it with --add-dir, and
+// Close removes it, even when the CLI had to be killed. ScratchPath returns
+// its path.
+//
+// The rewrite runs before the PreToolUse hooks, which see the rewritten
+// path and can still deny the call. Each subagent run gets a subdirectory
+// of its own unless the subagent is configured with
+// SubagentShareScratch(true). A clone gets a new scratch directory rather
+// than sharing the original's.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.ScratchDir(), agent.KeepScratch(debug))
+//	defer a.Close()
+//	log.Printf("scratch files in %s", a.ScratchPath())
+func ScratchDir() Option {
+	return func(c *config) {
+		c.scratch = true
+	}
+}
+
+// ScratchDirIn is like ScratchDir but creates the directory in parent,
+// which must exist. A relative parent is resolved against WorkDir.
+func ScratchDirIn(parent string) Option {
+	return func(c *config) {
+		c.scratch = true
+		c.scratchParent = parent
+	}
+}
+
+// KeepScratch leaves the ScratchDir in place at Close, for inspecting what
+// the agent wrote there.
+func KeepScratch(keep bool) Option {
+	return func(c *config) {
+		c.keepScratch = keep
+	}
+}
+
+// ScratchPath returns the absolute path of the agent's ScratchDir, or ""
+// if it has none.
+func (a *Agent) ScratchPath() string {
+	return a.cfg.scratchPath
+}
+
+// scratchTmp is the directory whose paths are rewritten into the scratch
+// directory.
+const scratchTmp = "/tmp"
+
+// scratchNameChars matches characters not used in subagent scratch
+// directory names.
+var scratchNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// createScratch creates the scratch directory, if enabled, and records its
+// path in cfg.
+func createScratch(cfg *config) error {
+	if !cfg.scratch {
+		return nil
+	}
+	parent := cfg.scratchParent
+	if parent != "" && !filepath.IsAbs(parent) {
+		parent = filepath.Join(cfg.workDir, parent)
+	}
+	dir, err := os.MkdirTemp(parent, "claude-agent-scratch-")
+	if err != nil {
+		return &StartError{Reason: "ScratchDir: failed to create scratch directory", Cause: err}
+	}
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	cfg.scratchPath = dir
+	return nil
+}
+
+// removeScratch removes the scratch directory, unless there is none or
+// KeepScratch is set.
+func removeScratch(cfg *config) error {
+	if cfg.scratchPath == "" || cfg.keepScratch {
+		return nil
+	}
+	return os.RemoveAll(cfg.scratchPath)
+}
+
+// scratchRedirect returns the hook that rewrites /tmp paths into the
+// scratch directory, or into a subdirectory of it for a subagent that does
+// not share it, with RedirectPath, or nil if there is no scratch
+// directory. Unlike RedirectPath, it returns Continue so that the agent's
+// own hooks still decide.
+func scratchRedirect(cfg *config) PreToolUseHook {
+	dir, subagents := cfg.scratchPath, cfg.subagents
+	if dir == "" {
+		return nil
+	}
+	return func(tc *ToolCall) HookResult {
+		// Paths already in the scratch directory, which may itself be
+		// under /tmp, are left alone
+		path, ok := toolPath(tc)
+		if !ok || withinPath(path, normalizePath(dir, tc.workDir, tc.resolveSymlinks)) {
+			return HookResult{Decision: Continue}
+		}
+
+		to := dir
+		if tc.AgentKind == AgentSubagent {
+			if sub := subagents[tc.SubagentType]; sub == nil || !sub.ShareScratch {
+				to = filepath.Join(dir, "subagents", subagentScratchName(tc))
+			}
+		}
+		result := RedirectPath(scratchTmp, to).Evaluate(tc)
+		if result.UpdatedInput == nil {
+			return HookResult{Decision: Continue}
+		}
+		if to != dir {
+			if err := os.MkdirAll(to, 0o700); err != nil {
+				return HookResult{Decision: Deny, Reason: "scratch directory unavailable: " + err.Error()}
+			}
+		}
+		return HookResult{Decision: Continue, UpdatedInput: result.UpdatedInput}
+	}
+}
+
+// subagentScratchName names the scratch subdirectory of the subagent run
+// that made tc, after the Task tool_use that started it.
+func subagentScratchName(tc *ToolCall) string {
+	name := tc.ParentToolUseID
+	if name == "" {
+		name = tc.SubagentType
+	}
+	name = scratchNameChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "subagent"
+	}
+	return name
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestScratchDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	argsFile := filepath.Join(tmpDir, "args")
+	wire := filepath.Join(tmpDir, "wire.jsonl")
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	// Writes to /tmp by the agent, a subagent, and a subagent that shares
+	// the scratch directory, and one already in the scratch directory
+	script := `#!/bin/sh
+for arg in "$@"; do printf '%s\n' "$arg"; done > ` + argsFile + `
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"scratch-test"}'
+printf '%s\n' '{"type":"control","request_id":"req_1","tool_name":"Write","tool_input":{"file_path":"/tmp/notes.txt","content":"x"}}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"control","request_id":"req_2","tool_name":"Write","tool_input":{"file_path":"/tmp/notes.txt","content":"y"},"parent_tool_use_id":"tu-1","subagent_type":"explorer"}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"control","request_id":"req_3","tool_name":"Read","tool_input":{"file_path":"/tmp/notes.txt"},"parent_tool_use_id":"tu-2","subagent_type":"helper"}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"control","request_id":"req_4","tool_name":"Read","tool_input":{"file_path":"/etc/hosts"}}'
+read response
+printf '%s\n' "$response" >> ` + wire + `
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var mu sync.Mutex
+	var seen []string
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		ScratchDirIn(tmpDir),
+		Subagent("explorer", SubagentDescription("Explores")),
+		Subagent("helper", SubagentDescription("Helps"), SubagentShareScratch(true)),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			mu.Lock()
+			seen = append(seen, tc.Input["file_path"].(string))
+			mu.Unlock()
+			return HookResult{Decision: Continue}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	scratch := a.ScratchPath()
+	if filepath.Dir(scratch) != tmpDir {
+		t.Fatalf("ScratchPath() = %q, want a directory in %s", scratch, tmpDir)
+	}
+	if info, err := os.Stat(scratch); err != nil || !info.IsDir() {
+		t.Fatalf("scratch directory not created: %v", err)
+	}
+
+	if _, err := a.Run(ctx, "go"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if args := string(mustReadFile(t, argsFile)); !strings.Contains(args, "--add-dir\n"+scratch+"\n") {
+		t.Errorf("args = %q, want --add-dir %s", args, scratch)
+	}
+
+	subagentDir := filepath.Join(scratch, "subagents", "tu-1")
+	want := []string{
+		filepath.Join(scratch, "notes.txt"),
+		filepath.Join(subagentDir, "notes.txt"),
+		filepath.Join(scratch, "notes.txt"),
+		"/etc/hosts",
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(mustReadFile(t, wire))), "\n") {
+		var resp controlResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("bad response %q: %v", line, err)
+		}
+		path, _ := resp.UpdatedInput["file_path"].(string)
+		if path == "" {
+			path = "/etc/hosts"
+		}
+		got = append(got, path)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("rewritten paths = %v, want %v", got, want)
+	}
+	mu.Lock()
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("PreToolUse saw %v, want %v", seen, want)
+	}
+	mu.Unlock()
+	if _, err := os.Stat(subagentDir); err != nil {
+		t.Errorf("subagent scratch directory not created: %v", err)
+	}
+
+	mustClose(t, a)
+	if _, err := os.Stat(scratch); !os.IsNotExist(err) {
+		t.Errorf("scratch directory still exists after Close: %v", err)
+	}
+}
+
+func TestKeepScratch(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	mustWriteFile(t, fakeClaude, []byte("#!/bin/sh\nwhile read line; do :; done\n"), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), ScratchDirIn(tmpDir), KeepScratch(true))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clone, err := a.Clone(ctx, KeepScratch(false))
+	if err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	if clone.ScratchPath() == "" || clone.ScratchPath() == a.ScratchPath() {
+		t.Errorf("clone ScratchPath() = %q, want a directory other than %q", clone.ScratchPath(), a.ScratchPath())
+	}
+
+	mustClose(t, a)
+	mustClose(t, clone)
+	if _, err := os.Stat(a.ScratchPath()); err != nil {
+		t.Errorf("scratch directory removed despite KeepScratch: %v", err)
+	}
+	if _, err := os.Stat(clone.ScratchPath()); !os.IsNotExist(err) {
+		t.Errorf("clone scratch directory still exists after Close: %v", err)
+	}
+}
+
+func TestScratchDirRemovedOnFailedStart(t *testing.T) {
+	tmpDir := t.TempDir()
+	_, err := New(context.Background(),
+		CLIPath(filepath.Join(tmpDir, "missing")),
+		ScratchDirIn(tmpDir),
+	)
+	if err == nil {
+		t.Fatal("New() error = nil, want an error")
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	if len(entries) != 0 {
+		t.Errorf("%s has %d entries after a failed start, want none", tmpDir, len(entries))
+	}
+}
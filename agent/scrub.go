@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces secret values in error messages and audit events.
+const redacted = "[REDACTED]"
+
+// defaultScrubPatterns match credentials the CLI or a failing command may
+// echo: Anthropic keys and tokens, Authorization headers, bearer tokens,
+// and AWS credentials. A leading capture group is kept, so the name of a
+// variable or header stays readable and only its value is replaced.
+var defaultScrubPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)((?:ANTHROPIC_API_KEY|ANTHROPIC_AUTH_TOKEN|CLAUDE_CODE_OAUTH_TOKEN)["']?\s*[=:]\s*["']?)[^\s"',;]+`),
+	regexp.MustCompile(`sk-ant-[A-Za-z0-9_-]{8,}`),
+	regexp.MustCompile(`(?i)(\bauthorization["']?\s*[=:]\s*["']?(?:(?:bearer|basic|token)\s+)?)[^\s"',;]+`),
+	regexp.MustCompile(`(?i)(\bbearer\s+)[A-Za-z0-9._~+/=-]{8,}`),
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`(?i)((?:AWS_SECRET_ACCESS_KEY|AWS_SESSION_TOKEN)["']?\s*[=:]\s*["']?)[^\s"',;]+`),
+}
+
+// defaultScrubber applies the default patterns. It renders errors that
+// were not created by an agent.
+var defaultScrubber = &scrubber{patterns: defaultScrubPatterns}
+
+// Scrub adds regular expressions whose matches are replaced with
+// "[REDACTED]" in audit events and in the messages of *ProcessError and
+// *StartError, including captured stderr. They apply on top of a default
+// set covering Anthropic API keys and tokens, Authorization headers, bearer
+// tokens, and AWS credentials. If a pattern starts with a capture group,
+// the text it matches is kept, so `(token=)\S+` redacts only the value. An
+// invalid pattern makes New return a *ConfigError. Multiple calls add to
+// the patterns.
+//
+// In audit events, string values of the event data are scrubbed, including
+// those inside maps and slices such as tool inputs.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.Scrub(`(DATABASE_URL=)\S+`, `ghp_[A-Za-z0-9]{36}`))
+func Scrub(patterns ...string) Option {
+	return func(c *config) {
+		for _, p := range patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				// Reported by New; the first invalid pattern wins
+				if c.scrubErr == nil {
+					c.scrubErr = &ConfigError{Option: "Scrub", Value: p, Reason: err.Error()}
+				}
+				continue
+			}
+			c.scrubPatterns = append(c.scrubPatterns, re)
+		}
+	}
+}
+
+// validateScrub reports the first Scrub pattern that does not compile.
+func validateScrub(cfg *config) error {
+	return cfg.scrubErr
+}
+
+// scrubber redacts secrets from text. A nil scrubber applies the default
+// patterns.
+type scrubber struct {
+	patterns []*regexp.Regexp
+}
+
+// newScrubber returns a scrubber for the default patterns plus extra.
+func newScrubber(extra []*regexp.Regexp) *scrubber {
+	if len(extra) == 0 {
+		return defaultScrubber
+	}
+	patterns := make([]*regexp.Regexp, 0, len(defaultScrubPatterns)+len(extra))
+	patterns = append(patterns, defaultScrubPatterns...)
+	return &scrubber{patterns: append(patterns, extra...)}
+}
+
+// scrub returns text with every match redacted.
+func (s *scrubber) scrub(text string) string {
+	if s == nil {
+		s = defaultScrubber
+	}
+	for _, re := range s.patterns {
+		text = scrubMatches(re, text)
+	}
+	return text
+}
+
+// scrubMatches redacts the matches of re in text, keeping a capture group
+// that starts the match.
+func scrubMatches(re *regexp.Regexp, text string) string {
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m[0]])
+		if len(m) > 2 && m[2] == m[0] {
+			b.WriteString(text[m[2]:m[3]])
+		}
+		b.WriteString(redacted)
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// scrubData returns audit event data with its string values scrubbed.
+// Maps and slices are copied rather than modified, since they may be
+// shared with hooks. Values of other types are returned as is.
+func (s *scrubber) scrubData(v any) any {
+	switch v := v.(type) {
+	case string:
+		return s.scrub(v)
+	case []string:
+		out := make([]string, len(v))
+		for i, elem := range v {
+			out[i] = s.scrub(elem)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, elem := range v {
+			out[i] = s.scrubData(elem)
+		}
+		return out
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, elem := range v {
+			out[k] = s.scrubData(elem)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for k, elem := range v {
+			out[k] = s.scrub(elem)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// scrubError arranges for the *StartError and *ProcessError in err's chain
+// to render with s, and scrubs captured stderr. It returns err.
+func (s *scrubber) scrubError(err error) error {
+	var startErr *StartError
+	if errors.As(err, &startErr) {
+		startErr.scrub = s
+	}
+	var procErr *ProcessError
+	if errors.As(err, &procErr) {
+		procErr.Stderr = s.scrub(procErr.Stderr)
+		procErr.scrub = s
+	}
+	return err
+}
+
+// scrubMCPConfig redacts every env and header value of an --mcp-config
+// argument, since they commonly hold credentials that match no pattern.
+func scrubMCPConfig(arg string) string {
+	var servers map[string]map[string]any
+	if err := json.Unmarshal([]byte(arg), &servers); err != nil {
+		return redacted
+	}
+	for _, server := range servers {
+		for _, key := range []string{"env", "headers"} {
+			values, ok := server[key].(map[string]any)
+			if !ok {
+				continue
+			}
+			for k := range values {
+				values[k] = redacted
+			}
+		}
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return redacted
+	}
+	return string(data)
+}
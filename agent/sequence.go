@@ -0,0 +1,62 @@
+package agent
+
+// Gap is a run of messages missing from a sequence checked by
+// ValidateSequence.
+type Gap struct {
+	Index int // Index of the first message after the gap
+	From  int // First missing DeliveredSequence
+	To    int // Last missing DeliveredSequence
+}
+
+// ValidateSequence reports the gaps in the DeliveredSequence numbers of
+// msgs, such as messages read back from a store, to tell lost messages
+// from those the SDK consumed itself. msgs should be in the order they
+// were delivered. Messages without a DeliveredSequence are skipped, and a
+// number at or below the previous one starts a new sequence, as messages
+// from a new Agent do. The first number is not checked, so msgs may start
+// at any run.
+//
+// Example:
+//
+//	for _, gap := range agent.ValidateSequence(stored) {
+//	    log.Printf("messages %d to %d lost before index %d", gap.From, gap.To, gap.Index)
+//	}
+func ValidateSequence(msgs []Message) []Gap {
+	var gaps []Gap
+	prev := 0
+	for i, msg := range msgs {
+		meta := messageMeta(msg)
+		if meta == nil || meta.DeliveredSequence == 0 {
+			continue
+		}
+		seq := meta.DeliveredSequence
+		if prev > 0 && seq > prev+1 {
+			gaps = append(gaps, Gap{Index: i, From: prev + 1, To: seq - 1})
+		}
+		prev = seq
+	}
+	return gaps
+}
+
+// numberDelivered sets the DeliveredSequence of a message about to be
+// delivered and returns it.
+func (a *Agent) numberDelivered(msg Message) int {
+	meta := messageMeta(msg)
+	if meta == nil {
+		return 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.delivered++
+	meta.DeliveredSequence = a.delivered
+	return a.delivered
+}
+
+// undeliver gives back the number of a message that was not delivered.
+func (a *Agent) undeliver(seq int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if seq != 0 && a.delivered == seq {
+		a.delivered--
+	}
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDeliveredSequence(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	// Internal messages (init, control, compaction) are interleaved with
+	// delivered ones
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"sequence-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Reading"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Read","input":{"file_path":"go.mod"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"Read","tool_input":{"file_path":"go.mod"}}'
+read response
+printf '%s\n' '{"type":"system","subtype":"compact","trigger":"auto","token_count":95000}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"module x"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Hmm"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Again"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var msgs []Message
+	for msg := range a.Stream(ctx, "first") {
+		msgs = append(msgs, msg)
+	}
+	// Thinking is filtered out of the second run
+	for msg := range a.Stream(ctx, "second", ExcludeMessages(MessageThinking)) {
+		msgs = append(msgs, msg)
+	}
+
+	var raw, delivered []int
+	for _, msg := range msgs {
+		meta := messageMeta(msg)
+		raw = append(raw, meta.Sequence)
+		delivered = append(delivered, meta.DeliveredSequence)
+	}
+	if want := []int{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(delivered, want) {
+		t.Errorf("DeliveredSequence = %v, want %v", delivered, want)
+	}
+	// The raw Sequence counts the messages the SDK consumed
+	if want := []int{2, 3, 6, 7, 9, 10}; !reflect.DeepEqual(raw, want) {
+		t.Errorf("Sequence = %v, want %v", raw, want)
+	}
+	if gaps := ValidateSequence(msgs); len(gaps) != 0 {
+		t.Errorf("ValidateSequence() = %+v, want no gaps", gaps)
+	}
+
+	lost := append(append([]Message{}, msgs[:2]...), msgs[4:]...)
+	if gaps := ValidateSequence(lost); !reflect.DeepEqual(gaps, []Gap{{Index: 2, From: 3, To: 4}}) {
+		t.Errorf("ValidateSequence() with messages 3 and 4 missing = %+v", gaps)
+	}
+}
+
+func TestValidateSequence(t *testing.T) {
+	msg := func(seq int) Message {
+		return &Text{MessageMeta: MessageMeta{DeliveredSequence: seq}}
+	}
+	tests := []struct {
+		name string
+		msgs []Message
+		want []Gap
+	}{
+		{"empty", nil, nil},
+		{"contiguous from a later run", []Message{msg(41), msg(42), msg(43)}, nil},
+		{"unnumbered messages skipped", []Message{msg(1), &ToolProgress{}, &Error{}, msg(2)}, nil},
+		{"gaps", []Message{msg(1), msg(3), msg(4), msg(9)}, []Gap{{Index: 1, From: 2, To: 2}, {Index: 3, From: 5, To: 8}}},
+		{"new agent restarts", []Message{msg(7), msg(8), msg(1), msg(2)}, nil},
+	}
+	for _, tt := range tests {
+		if got := ValidateSequence(tt.msgs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ValidateSequence() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// syntheticSessionPrefix starts the session ID the SDK assigns when the
+// CLI's output starts without an init message.
+const syntheticSessionPrefix = "unknown-"
+
+// newSyntheticSessionID returns a session ID for a session whose init
+// message has not arrived.
+func newSyntheticSessionID() string {
+	return syntheticSessionPrefix + newRunID()
+}
+
+// isSyntheticSessionID reports whether id was assigned by the SDK rather
+// than the CLI.
+func isSyntheticSessionID(id string) bool {
+	return strings.HasPrefix(id, syntheticSessionPrefix)
+}
+
+// adoptSessionID takes the synthetic session ID the parser stamped on msg
+// when no init message has arrived, reporting it as session.init_missing.
+func (a *Agent) adoptSessionID(msg Message) {
+	meta := messageMeta(msg)
+	if ctrl, ok := msg.(*ControlRequestMsg); ok {
+		meta = &ctrl.MessageMeta // A permission request can come first
+	}
+	if meta == nil || !isSyntheticSessionID(meta.SessionID) {
+		return
+	}
+	a.mu.Lock()
+	if a.sessionID != "" {
+		a.mu.Unlock()
+		return
+	}
+	a.sessionID = meta.SessionID
+	a.mu.Unlock()
+
+	a.state.setSession(meta.SessionID)
+	a.auditor.emit(meta.SessionID, "session.init_missing", map[string]any{
+		"session_id":   meta.SessionID,
+		"message_type": messageTypeName(msg),
+	})
+}
+
+// initSession records the session ID of an init message and returns the
+// agent's session ID. The first init message sets it, except that one
+// replaces a synthetic ID, which is reported as session.id_changed.
+func (a *Agent) initSession(init *SystemInit) string {
+	a.mu.Lock()
+	previous := ""
+	switch {
+	case a.sessionID == "":
+		a.sessionID = init.SessionID
+	case init.SessionID != "" && isSyntheticSessionID(a.sessionID):
+		previous = a.sessionID
+		a.sessionID = init.SessionID
+	}
+	sessionID := a.sessionID
+	a.mu.Unlock()
+
+	a.state.setSession(sessionID)
+	if previous != "" {
+		a.auditor.emit(sessionID, "session.id_changed", map[string]any{
+			"previous_session_id": previous,
+			"session_id":          sessionID,
+		})
+	}
+	return sessionID
+}
+
+// messageTypeName returns the MessageType of msg, or its Go type for
+// internal messages.
+func messageTypeName(msg Message) string {
+	if t := messageTypeOf(msg); t != "" {
+		return string(t)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T", msg), "*agent.")
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sessionEvents records the audit events of the given types.
+type sessionEvents struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (s *sessionEvents) handler(types ...string) Option {
+	return Audit(func(e AuditEvent) {
+		for _, t := range types {
+			if e.Type == t {
+				s.mu.Lock()
+				s.events = append(s.events, e)
+				s.mu.Unlock()
+			}
+		}
+	})
+}
+
+func (s *sessionEvents) list() []AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEvent(nil), s.events...)
+}
+
+func TestSessionIDWithoutInit(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hi"}]}}'
+printf '%s\n' '{"type":"result","result":"First","num_turns":1}'
+read line || exit 0
+printf '%s\n' '{"type":"result","result":"Second","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var events sessionEvents
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), events.handler("session.init", "session.init_missing", "session.id_changed"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	first, err := a.Run(ctx, "one")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	id := a.SessionID()
+	if !strings.HasPrefix(id, "unknown-") || first.SessionID != id {
+		t.Fatalf("SessionID() = %q, Result.SessionID = %q, want the same synthetic ID", id, first.SessionID)
+	}
+	second, err := a.Run(ctx, "two")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if a.SessionID() != id || second.SessionID != id {
+		t.Errorf("second run SessionID() = %q, Result.SessionID = %q, want %q", a.SessionID(), second.SessionID, id)
+	}
+
+	got := events.list()
+	if len(got) != 1 || got[0].Type != "session.init_missing" || got[0].SessionID != id {
+		t.Fatalf("session events = %+v, want one session.init_missing", got)
+	}
+	if data := got[0].Data.(map[string]any); data["session_id"] != id || data["message_type"] != "text" {
+		t.Errorf("session.init_missing data = %v, want the ID and the text message", data)
+	}
+
+	// Two agents never share the synthetic ID
+	b, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, b)
+	if _, err := b.Run(ctx, "one"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if b.SessionID() == id || b.SessionID() == "" {
+		t.Errorf("second agent SessionID() = %q, want a different synthetic ID", b.SessionID())
+	}
+}
+
+func TestSessionIDInitAfterResult(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"result","result":"First","num_turns":1}'
+read line || exit 0
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"real-session"}'
+printf '%s\n' '{"type":"result","result":"Second","num_turns":1}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var events sessionEvents
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), events.handler("session.init", "session.init_missing", "session.id_changed"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	first, err := a.Run(ctx, "one")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	synthetic := a.SessionID()
+	if !strings.HasPrefix(synthetic, "unknown-") || first.SessionID != synthetic {
+		t.Fatalf("SessionID() = %q, Result.SessionID = %q, want the same synthetic ID", synthetic, first.SessionID)
+	}
+
+	second, err := a.Run(ctx, "two")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if a.SessionID() != "real-session" || second.SessionID != "real-session" {
+		t.Errorf("SessionID() = %q, Result.SessionID = %q, want the init message's", a.SessionID(), second.SessionID)
+	}
+
+	got := events.list()
+	var types []string
+	for _, e := range got {
+		types = append(types, e.Type)
+	}
+	if strings.Join(types, ",") != "session.init_missing,session.id_changed,session.init" {
+		t.Fatalf("session events = %v, want init_missing, id_changed, then init", types)
+	}
+	changed := got[1].Data.(map[string]any)
+	if got[1].SessionID != "real-session" || changed["previous_session_id"] != synthetic || changed["session_id"] != "real-session" {
+		t.Errorf("session.id_changed = %+v, want %s replaced by real-session", got[1], synthetic)
+	}
+}
+
+func TestDecoderSyntheticSessionID(t *testing.T) {
+	d := NewDecoder(strings.NewReader(`{"type":"system","subtype":"compact","trigger":"auto"}
+{"type":"result","result":"Done","num_turns":1}
+{"type":"system","subtype":"init","session_id":"real-session"}
+{"type":"result","result":"Again","num_turns":1}
+`))
+	var ids []string
+	for {
+		msg, err := d.Next()
+		if err != nil {
+			break
+		}
+		switch m := msg.(type) {
+		case *CompactMsg:
+			ids = append(ids, m.SessionID)
+		case *SystemInit:
+			ids = append(ids, m.SessionID)
+		default:
+			ids = append(ids, messageMeta(msg).SessionID)
+		}
+	}
+	if len(ids) != 4 || ids[0] != "" || !strings.HasPrefix(ids[1], "unknown-") || ids[2] != "real-session" || ids[3] != "real-session" {
+		t.Errorf("session IDs = %q, want none before the result, a synthetic ID, then the init message's", ids)
+	}
+}
@@ -1,11 +1,29 @@
 package agent
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+// SkillMode controls how configured skills are delivered to the CLI.
+type SkillMode int
+
+const (
+	// SkillModeSystemPrompt appends skill content to the system prompt via
+	// --append-system-prompt. This is the default.
+	SkillModeSystemPrompt SkillMode = iota
+	// SkillModeNative writes each skill as a SKILL.md file under
+	// <workDir>/.claude/skills so the CLI loads it natively (on demand rather
+	// than always in context). The files are removed when the agent closes.
+	// The CLI only discovers these skills when project settings are loaded.
+	SkillModeNative
+	// SkillModeOff ignores all configured skills.
+	SkillModeOff
+)
+
 // SkillConfig holds a skill definition.
 // Skills are markdown instructions that are loaded into Claude's context.
 type SkillConfig struct {
@@ -62,3 +80,208 @@ func loadSkillsFromDir(path string) (map[string]*SkillConfig, error) {
 
 	return skills, nil
 }
+
+// collectSkills merges inline skills with skills loaded from directories.
+// Directory skills override inline skills with the same name.
+func collectSkills(cfg *config) (map[string]*SkillConfig, error) {
+	allSkills := make(map[string]*SkillConfig)
+	for name, skill := range cfg.skills {
+		allSkills[name] = skill
+	}
+	for _, dir := range cfg.skillDirs {
+		dirSkills, err := loadSkillsFromDir(dir)
+		if err != nil {
+			return nil, &StartError{Reason: "failed to load skills from " + dir, Cause: err}
+		}
+		for name, skill := range dirSkills {
+			allSkills[name] = skill
+		}
+	}
+	return allSkills, nil
+}
+
+// applySkills delivers configured skills according to the skill mode.
+// It returns the updated CLI arguments and a cleanup function that removes
+// any files written for the skills. The cleanup function is never nil.
+func applySkills(cfg *config, args []string) ([]string, func() error, error) {
+	noop := func() error { return nil }
+
+	if cfg.skillMode == SkillModeOff {
+		return args, noop, nil
+	}
+
+	allSkills, err := collectSkills(cfg)
+	if err != nil {
+		return nil, noop, err
+	}
+	if len(allSkills) == 0 {
+		return args, noop, nil
+	}
+
+	if cfg.skillMode == SkillModeNative {
+		cleanup, err := writeNativeSkills(cfg.workDir, allSkills)
+		if err != nil {
+			return nil, noop, &StartError{Reason: "failed to write native skills", Cause: err}
+		}
+		return args, cleanup, nil
+	}
+
+	// Append skill content to the system prompt, after any explicit
+	// SystemPromptAppend text
+	var skillContent strings.Builder
+	skillContent.WriteString(cfg.systemPromptAppend)
+	for _, name := range sortedSkillNames(allSkills) {
+		skillContent.WriteString("\n\n## Skill: ")
+		skillContent.WriteString(name)
+		skillContent.WriteString("\n")
+		skillContent.WriteString(allSkills[name].Content)
+	}
+
+	if cfg.systemPromptAppend == "" {
+		return append(args, "--append-system-prompt", skillContent.String()), noop, nil
+	}
+
+	// Replace the existing --append-system-prompt value
+	for i, arg := range args {
+		if arg == "--append-system-prompt" && i+1 < len(args) {
+			args[i+1] = skillContent.String()
+			break
+		}
+	}
+	return args, noop, nil
+}
+
+// sortedSkillNames returns skill names in a stable order.
+func sortedSkillNames(skills map[string]*SkillConfig) []string {
+	names := make([]string, 0, len(skills))
+	for name := range skills {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeNativeSkills writes skills to <workDir>/.claude/skills in the layout
+// the CLI expects: one directory per skill containing a SKILL.md file with
+// YAML frontmatter. Each skill directory gets a unique suffix so it never
+// collides with existing skills or with other agents sharing the workDir.
+// The returned cleanup function removes everything that was created.
+func writeNativeSkills(workDir string, skills map[string]*SkillConfig) (func() error, error) {
+	claudeDir := filepath.Join(workDir, ".claude")
+	skillsRoot := filepath.Join(claudeDir, "skills")
+
+	// Track which parent directories we create so cleanup only removes those
+	var created []string
+	for _, dir := range []string{claudeDir, skillsRoot} {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.Mkdir(dir, 0o750); err != nil {
+				return nil, err
+			}
+			created = append(created, dir)
+		}
+	}
+
+	var skillDirs []string
+	cleanup := func() error {
+		var firstErr error
+		for _, dir := range skillDirs {
+			if err := os.RemoveAll(dir); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		// Remove parents we created, innermost first, only if now empty
+		for i := len(created) - 1; i >= 0; i-- {
+			_ = os.Remove(created[i]) // Fails harmlessly if not empty
+		}
+		return firstErr
+	}
+
+	for _, name := range sortedSkillNames(skills) {
+		dir, err := os.MkdirTemp(skillsRoot, skillDirName(name)+"-")
+		if err != nil {
+			_ = cleanup()
+			return nil, err
+		}
+		skillDirs = append(skillDirs, dir)
+
+		content := nativeSkillContent(name, skills[name].Content)
+		if err := os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(content), 0o600); err != nil {
+			_ = cleanup()
+			return nil, err
+		}
+	}
+
+	return cleanup, nil
+}
+
+// skillDirName converts a skill name into a safe directory name.
+func skillDirName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '-'
+		}
+	}, name)
+	if safe == "" {
+		return "skill"
+	}
+	return safe
+}
+
+// nativeSkillContent returns SKILL.md content with YAML frontmatter.
+// Content that already starts with frontmatter is returned unchanged.
+// Otherwise the description is taken from the first non-empty line.
+func nativeSkillContent(name, content string) string {
+	if strings.HasPrefix(content, "---\n") {
+		return content
+	}
+
+	description := name
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(strings.TrimLeft(line, "# "))
+		if line != "" {
+			description = line
+			break
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	b.WriteString("name: " + yamlQuote(name) + "\n")
+	b.WriteString("description: " + yamlQuote(description) + "\n")
+	b.WriteString("---\n\n")
+	b.WriteString(content)
+	return b.String()
+}
+
+// yamlQuote returns s as a YAML double-quoted scalar. Characters outside
+// YAML's printable set, and the Unicode line and paragraph separators, are
+// written as YAML escapes; Go's quoting differs for some of them. Invalid
+// UTF-8 becomes U+FFFD, since YAML cannot carry raw bytes.
+func yamlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0):
+			fmt.Fprintf(&b, `\x%02x`, r)
+		case r == 0x2028 || r == 0x2029 || r == 0xfeff || r == 0xfffe || r == 0xffff:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
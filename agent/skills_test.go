@@ -1,7 +1,10 @@
 package agent
 
 import (
+	"context"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -63,3 +66,194 @@ func TestLoadSkillsFromDir_NonExistent(t *testing.T) {
 		t.Error("expected error for non-existent directory")
 	}
 }
+
+func TestNativeSkillContentAddsFrontmatter(t *testing.T) {
+	got := nativeSkillContent("go-dev", "# Go Development\nUse gofmt.")
+	want := "---\nname: \"go-dev\"\ndescription: \"Go Development\"\n---\n\n# Go Development\nUse gofmt."
+	if got != want {
+		t.Errorf("nativeSkillContent() = %q, want %q", got, want)
+	}
+}
+
+func TestYAMLQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`Say "hi" \ bye`, `"Say \"hi\" \\ bye"`},
+		{"tab\tbell\aesc\x1bdel\x7f", `"tab\tbell\x07esc\x1bdel\x7f"`},
+		{"nel\u0085 sep\u2028 bom\ufeff", `"nel\x85 sep\u2028 bom\ufeff"`},
+		{"caf\u00e9 \u65e5\u672c", "\"caf\u00e9 \u65e5\u672c\""},
+		{"bad\xffbyte", "\"bad\ufffdbyte\""},
+	}
+	for _, tt := range tests {
+		if got := yamlQuote(tt.in); got != tt.want {
+			t.Errorf("yamlQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNativeSkillContentKeepsExistingFrontmatter(t *testing.T) {
+	content := "---\nname: custom\ndescription: Mine\n---\nBody"
+	if got := nativeSkillContent("other", content); got != content {
+		t.Errorf("nativeSkillContent() = %q, want unchanged", got)
+	}
+}
+
+func TestSkillDirName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"go-dev", "go-dev"},
+		{"../escape", "---escape"},
+		{"with space", "with-space"},
+		{"", "skill"},
+	}
+	for _, tt := range tests {
+		if got := skillDirName(tt.name); got != tt.want {
+			t.Errorf("skillDirName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteNativeSkillsLayout(t *testing.T) {
+	workDir := t.TempDir()
+	skills := map[string]*SkillConfig{
+		"go":      {Name: "go", Content: "# Go\nUse gofmt."},
+		"testing": {Name: "testing", Content: "# Testing\nWrite tests first."},
+	}
+
+	cleanup, err := writeNativeSkills(workDir, skills)
+	if err != nil {
+		t.Fatalf("writeNativeSkills() error = %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(workDir, ".claude", "skills", "*", "SKILL.md"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("found %d SKILL.md files, want 2", len(matches))
+	}
+	for _, m := range matches {
+		content := string(mustReadFile(t, m))
+		if !strings.HasPrefix(content, "---\nname: ") {
+			t.Errorf("%s should start with frontmatter, got %q", m, content)
+		}
+	}
+
+	if err := cleanup(); err != nil {
+		t.Fatalf("cleanup() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, ".claude")); !os.IsNotExist(err) {
+		t.Error(".claude directory should be removed when created by the SDK")
+	}
+}
+
+func TestWriteNativeSkillsCollisionSafe(t *testing.T) {
+	workDir := t.TempDir()
+
+	// A user-owned skill with the same name must survive
+	userSkill := filepath.Join(workDir, ".claude", "skills", "go")
+	mustMkdirAll(t, userSkill, 0o755)
+	mustWriteFile(t, filepath.Join(userSkill, "SKILL.md"), []byte("user skill"), 0o644)
+
+	skills := map[string]*SkillConfig{"go": {Name: "go", Content: "sdk skill"}}
+
+	cleanup1, err := writeNativeSkills(workDir, skills)
+	if err != nil {
+		t.Fatalf("first writeNativeSkills() error = %v", err)
+	}
+	cleanup2, err := writeNativeSkills(workDir, skills)
+	if err != nil {
+		t.Fatalf("second writeNativeSkills() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(workDir, ".claude", "skills"))
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("skills directory has %d entries, want 3", len(entries))
+	}
+
+	_ = cleanup1()
+	_ = cleanup2()
+
+	if got := string(mustReadFile(t, filepath.Join(userSkill, "SKILL.md"))); got != "user skill" {
+		t.Errorf("user skill content = %q, want unchanged", got)
+	}
+	entries, _ = os.ReadDir(filepath.Join(workDir, ".claude", "skills"))
+	if len(entries) != 1 {
+		t.Errorf("after cleanup skills directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestApplySkillsOff(t *testing.T) {
+	cfg := &config{
+		skills:    map[string]*SkillConfig{"go": {Name: "go", Content: "x"}},
+		skillMode: SkillModeOff,
+	}
+	args, cleanup, err := applySkills(cfg, []string{"--print"})
+	if err != nil {
+		t.Fatalf("applySkills() error = %v", err)
+	}
+	defer func() { _ = cleanup() }()
+
+	if len(args) != 1 {
+		t.Errorf("args = %v, want no skill arguments", args)
+	}
+}
+
+func TestApplySkillsSystemPromptKeepsAppendFirst(t *testing.T) {
+	cfg := &config{
+		skills:             map[string]*SkillConfig{"go": {Name: "go", Content: "Use gofmt."}},
+		systemPromptAppend: "Be brief.",
+	}
+	args, _, err := applySkills(cfg, []string{"--append-system-prompt", "Be brief."})
+	if err != nil {
+		t.Fatalf("applySkills() error = %v", err)
+	}
+	if len(args) != 2 {
+		t.Fatalf("args = %v, want a single --append-system-prompt", args)
+	}
+	if !strings.HasPrefix(args[1], "Be brief.") || !strings.Contains(args[1], "## Skill: go") {
+		t.Errorf("append value = %q, want explicit text followed by skill", args[1])
+	}
+}
+
+func TestStartProcessNativeSkillsCleanedUpAfterKill(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Ignores stdin EOF so close() must kill it
+	script := `#!/bin/sh
+ls .claude/skills > skills.txt
+sleep 10
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	cfg := &config{
+		workDir:   tmpDir,
+		cliPath:   fakeClaude,
+		skills:    map[string]*SkillConfig{"go": {Name: "go", Content: "# Go"}},
+		skillMode: SkillModeNative,
+	}
+
+	p, err := startProcess(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("startProcess() error = %v", err)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(tmpDir, ".claude", "skills", "go-*", "SKILL.md"))
+	if len(matches) != 1 {
+		t.Errorf("found %d native skill files while running, want 1", len(matches))
+	}
+
+	_ = p.close()
+
+	if _, err := os.Stat(filepath.Join(tmpDir, ".claude")); !os.IsNotExist(err) {
+		t.Error(".claude directory should be removed after close")
+	}
+}
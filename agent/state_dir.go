@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateDir keeps a small JSON state file for the agent in dir, so that
+// after a crash the application can find the sessions that were in flight
+// with RecoverSessions and Resume them. The file records the session ID,
+// the run in progress, a hash of its prompt, the turns and cost so far,
+// and the tool calls awaiting results. It is written when a prompt is
+// submitted, when the session ID arrives, as tool calls start and finish,
+// and at each Result, and removed by Close.
+//
+// Each write replaces the file atomically. A failure to write is not
+// fatal: the run continues and a state.write_failed audit event reports
+// the error.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx, agent.StateDir("/var/lib/myapp/agents"))
+func StateDir(dir string) Option {
+	return func(c *config) {
+		c.stateDir = dir
+	}
+}
+
+// RecoveredSession is the state of an agent that was not closed, as read
+// by RecoverSessions.
+type RecoveredSession struct {
+	// Path is the state file. Remove it once the session is handled.
+	Path string `json:"-"`
+
+	SessionID    string            `json:"session_id,omitempty"`    // Empty if the CLI never reported it
+	RunID        string            `json:"run_id,omitempty"`        // The run in progress; empty between runs
+	PromptSHA256 string            `json:"prompt_sha256,omitempty"` // Hex SHA-256 of the last prompt sent; empty for a reader prompt
+	Turns        int               `json:"turns"`                   // Turns of completed runs
+	CostUSD      float64           `json:"cost_usd"`                // Cost of completed runs
+	PendingTools []string          `json:"pending_tool_use_ids,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"` // The agent's labels when it was created
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// RecoverSessions returns the state left in dir by agents that were not
+// closed, oldest first. Call it at startup, before agents using the same
+// StateDir are created, since a running agent's file looks the same. A
+// missing dir holds no sessions. Files that cannot be read are reported
+// in the error, together with the sessions that could.
+//
+// Example:
+//
+//	sessions, err := agent.RecoverSessions(stateDir)
+//	if err != nil {
+//	    log.Printf("recovering sessions: %v", err)
+//	}
+//	for _, s := range sessions {
+//	    if s.SessionID != "" {
+//	        a, _ := agent.New(ctx, agent.Resume(s.SessionID), agent.StateDir(stateDir))
+//	        // ...
+//	    }
+//	    _ = os.Remove(s.Path)
+//	}
+func RecoverSessions(dir string) ([]RecoveredSession, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []RecoveredSession
+	var errs []error
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasPrefix(name, ".") {
+			continue // Temporary files start with a dot
+		}
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path) // #nosec G304 -- Path within the caller's state directory
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var s RecoveredSession
+		if err := json.Unmarshal(data, &s); err != nil {
+			errs = append(errs, fmt.Errorf("agent: state file %s: %w", path, err))
+			continue
+		}
+		s.Path = path
+		sessions = append(sessions, s)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].UpdatedAt.Before(sessions[j].UpdatedAt) })
+	return sessions, errors.Join(errs...)
+}
+
+// stateFile keeps an agent's state in its StateDir. A nil *stateFile does
+// nothing.
+type stateFile struct {
+	dir     string
+	path    string
+	auditor *auditor
+
+	mu      sync.Mutex
+	state   RecoveredSession
+	written bool // The file exists
+	closed  bool
+}
+
+// newStateFile returns the state file for a new agent, or nil if dir is
+// empty. The file is first written when a prompt is submitted.
+func newStateFile(dir string, aud *auditor, labels map[string]string) *stateFile {
+	if dir == "" {
+		return nil
+	}
+	return &stateFile{
+		dir:     dir,
+		path:    filepath.Join(dir, newRunID()+".json"),
+		auditor: aud,
+		state:   RecoveredSession{Labels: labels},
+	}
+}
+
+// startRun records a submitted prompt.
+func (s *stateFile) startRun(sessionID, runID, prompt string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sessionID != "" {
+		s.state.SessionID = sessionID
+	}
+	s.state.RunID = runID
+	s.state.PromptSHA256 = ""
+	if prompt != "" {
+		sum := sha256.Sum256([]byte(prompt))
+		s.state.PromptSHA256 = hex.EncodeToString(sum[:])
+	}
+	s.state.PendingTools = nil
+	s.writeLocked()
+}
+
+// setSession records the session ID once the CLI reports it.
+func (s *stateFile) setSession(sessionID string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.SessionID == sessionID {
+		return
+	}
+	s.state.SessionID = sessionID
+	s.writeLocked()
+}
+
+// setPending records the tool calls awaiting results.
+func (s *stateFile) setPending(ids []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.PendingTools = ids
+	s.writeLocked()
+}
+
+// finishRun records a Result; no run is then in progress.
+func (s *stateFile) finishRun(result *Result, pending []string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.RunID = ""
+	s.state.Turns += result.NumTurns
+	s.state.CostUSD += result.CostUSD
+	s.state.PendingTools = pending
+	s.writeLocked()
+}
+
+// close removes the file; the agent ended cleanly.
+func (s *stateFile) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if !s.written {
+		return
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.failedLocked(err)
+	}
+}
+
+// writeLocked replaces the file with the current state. Caller must hold
+// s.mu.
+func (s *stateFile) writeLocked() {
+	if s.closed {
+		return
+	}
+	s.state.UpdatedAt = time.Now()
+	data, err := json.Marshal(s.state)
+	if err == nil {
+		err = writeFileAtomic(s.dir, s.path, data)
+	}
+	if err != nil {
+		s.failedLocked(err)
+		return
+	}
+	s.written = true
+}
+
+// failedLocked reports a state file error. Caller must hold s.mu.
+func (s *stateFile) failedLocked(err error) {
+	s.auditor.emit(s.state.SessionID, "state.write_failed", map[string]any{
+		"path":  s.path,
+		"error": err.Error(),
+	})
+}
+
+// writeFileAtomic writes data to a temporary file in dir and renames it to
+// path, so readers see the old or the new content, never part of it.
+func writeFileAtomic(dir, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".state-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name()) // Best effort; RecoverSessions skips temporary files
+	}
+	return err
+}
+
+// pendingToolUseIDs returns the IDs of tool calls awaiting results, sorted.
+func (a *Agent) pendingToolUseIDs() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.pendingToolCalls) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(a.pendingToolCalls))
+	for id := range a.pendingToolCalls {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStateDirRecoversCrashedSession(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"state-test"}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":2,"total_cost_usd":0.25}'
+read line
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Bash","input":{"command":"make"}}]}}'
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	stateDir := filepath.Join(t.TempDir(), "state")
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), StateDir(stateDir), Labels(map[string]string{"worker": "7"}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := a.Run(ctx, "first task"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	sessions, err := RecoverSessions(stateDir)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("RecoverSessions() = %+v, %v; want one session", sessions, err)
+	}
+	s := sessions[0]
+	if s.SessionID != "state-test" || s.RunID != "" || s.Turns != 2 || s.CostUSD != 0.25 || len(s.PendingTools) != 0 {
+		t.Errorf("between runs, RecoveredSession = %+v", s)
+	}
+	if s.Labels["worker"] != "7" || s.UpdatedAt.IsZero() || filepath.Dir(s.Path) != stateDir {
+		t.Errorf("RecoveredSession = %+v, want labels, a timestamp, and the path", s)
+	}
+
+	// Crash while a tool call is in flight: the agent is never closed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for msg := range a.Stream(ctx, "second task") {
+		if _, ok := msg.(*ToolUse); ok {
+			break
+		}
+	}
+	sessions, err = RecoverSessions(stateDir)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("RecoverSessions() = %+v, %v; want one session", sessions, err)
+	}
+	s = sessions[0]
+	sum := sha256.Sum256([]byte("second task"))
+	if s.RunID == "" || s.PromptSHA256 != hex.EncodeToString(sum[:]) || !reflect.DeepEqual(s.PendingTools, []string{"tu-1"}) {
+		t.Errorf("mid-run, RecoveredSession = %+v, want the run, prompt hash, and tu-1 pending", s)
+	}
+
+	// Close ends the agent cleanly and removes its state
+	cancel()
+	mustClose(t, a)
+	if sessions, err := RecoverSessions(stateDir); err != nil || len(sessions) != 0 {
+		t.Errorf("after Close, RecoverSessions() = %+v, %v; want none", sessions, err)
+	}
+}
+
+func TestStateDirWriteFailureIsNotFatal(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"state-test"}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	notADir := filepath.Join(t.TempDir(), "file")
+	mustWriteFile(t, notADir, nil, 0600)
+
+	var mu sync.Mutex
+	var failures []map[string]any
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		StateDir(filepath.Join(notADir, "state")),
+		Audit(func(e AuditEvent) {
+			if e.Type == "state.write_failed" {
+				mu.Lock()
+				failures = append(failures, e.Data.(map[string]any))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "task"); err != nil {
+		t.Fatalf("Run() error = %v, want the run to continue", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) == 0 || !strings.Contains(failures[0]["error"].(string), "not a directory") {
+		t.Errorf("state.write_failed events = %v", failures)
+	}
+}
+
+func TestRecoverSessions(t *testing.T) {
+	if sessions, err := RecoverSessions(filepath.Join(t.TempDir(), "missing")); err != nil || sessions != nil {
+		t.Errorf("RecoverSessions(missing) = %v, %v; want nothing", sessions, err)
+	}
+
+	dir := t.TempDir()
+	mustWriteFile(t, filepath.Join(dir, "b.json"), []byte(`{"session_id":"newer","updated_at":"2026-01-02T00:00:00Z"}`), 0600)
+	mustWriteFile(t, filepath.Join(dir, "a.json"), []byte(`{"session_id":"older","updated_at":"2026-01-01T00:00:00Z"}`), 0600)
+	mustWriteFile(t, filepath.Join(dir, "torn.json"), []byte(`{"session_id":`), 0600)
+	mustWriteFile(t, filepath.Join(dir, ".state-123.tmp"), []byte(`{}`), 0600)
+	mustWriteFile(t, filepath.Join(dir, "notes.txt"), []byte(`{}`), 0600)
+	if err := os.Mkdir(filepath.Join(dir, "sub.json"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := RecoverSessions(dir)
+	if err == nil || !strings.Contains(err.Error(), "torn.json") {
+		t.Errorf("RecoverSessions() error = %v, want the torn file", err)
+	}
+	if len(sessions) != 2 || sessions[0].SessionID != "older" || sessions[1].SessionID != "newer" {
+		t.Errorf("RecoverSessions() = %+v, want older then newer", sessions)
+	}
+}
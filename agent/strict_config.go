@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StrictConfig makes New fail with a *ConfigError when options contradict
+// each other, instead of reporting each contradiction as a config.warning
+// audit event and in Agent.ConfigWarnings. The checks find setups that
+// can only fail at run time:
+//
+//   - a tool enabled by Tools and disallowed by DisallowedTools, or a
+//     pattern both in AllowedTools and DisallowedTools
+//   - an AllowedTools pattern for a built-in tool that Tools does not enable
+//   - an AllowPaths directory outside WorkDir and every AddDir, likely a typo
+//   - a SubagentTools tool that the parent agent does not have
+//   - a RedirectPath target inside a DenyPaths directory
+//
+// The error names the first contradiction and its Reason lists them all.
+//
+// Example:
+//
+//	a, err := agent.New(ctx,
+//	    agent.StrictConfig(true),
+//	    agent.Tools(agent.ToolRead, agent.ToolWrite),
+//	    agent.DisallowedTools(agent.ToolWrite), // New fails: Write can never be used
+//	)
+func StrictConfig(strict bool) Option {
+	return func(c *config) {
+		c.strictConfig = strict
+	}
+}
+
+// configConflict is one contradiction between options, reported against
+// the option and value that are most likely wrong.
+type configConflict struct {
+	option string
+	value  string
+	reason string
+}
+
+func (c configConflict) String() string {
+	return fmt.Sprintf("%s: %q %s", c.option, c.value, c.reason)
+}
+
+// checkConflicts cross-checks the options. With StrictConfig it returns a
+// *ConfigError listing the contradictions; otherwise it returns them as
+// warnings.
+func checkConflicts(cfg *config) ([]string, error) {
+	conflicts := cfg.conflicts()
+	if len(conflicts) == 0 {
+		return nil, nil
+	}
+	list := make([]string, len(conflicts))
+	for i, c := range conflicts {
+		list[i] = c.String()
+	}
+	if cfg.strictConfig {
+		return nil, &ConfigError{Option: conflicts[0].option, Value: conflicts[0].value, Reason: strings.Join(list, "; ")}
+	}
+	return list, nil
+}
+
+// conflicts returns the contradictions between options, in the order
+// StrictConfig lists its checks.
+func (c *config) conflicts() []configConflict {
+	var found []configConflict
+	add := func(option, value, format string, args ...any) {
+		found = append(found, configConflict{option: option, value: value, reason: fmt.Sprintf(format, args...)})
+	}
+
+	// A bare name disallows the whole tool; "Bash(rm:*)" only some calls
+	disallowed := make(map[string]bool, len(c.disallowedTools))
+	for _, pattern := range c.disallowedTools {
+		if toolRuleName(pattern) == pattern {
+			disallowed[pattern] = true
+		}
+	}
+	for _, name := range c.tools {
+		if disallowed[name] {
+			add("DisallowedTools", name, "is also enabled by Tools, so it can never be used")
+		}
+	}
+	for _, pattern := range c.allowedTools {
+		if hasString(c.disallowedTools, pattern) {
+			add("AllowedTools", pattern, "is also in DisallowedTools, which takes precedence")
+		}
+	}
+
+	// Tools restricts the built-in tools; MCP and custom tools are not checked
+	restricted := len(c.tools) > 0 || c.noTools
+	enabled := func(name string) bool {
+		return !restricted || hasString(c.tools, name) || !hasString(builtinTools, name)
+	}
+	for _, pattern := range c.allowedTools {
+		if name := toolRuleName(pattern); !enabled(name) {
+			add("AllowedTools", pattern, "allows %s, which Tools does not enable", name)
+		}
+	}
+
+	paths := c.pathHooks
+	workDir := normalizePath(c.workDir, "", false)
+	roots := []string{workDir}
+	for _, dir := range c.addDirs {
+		roots = append(roots, normalizePath(dir, workDir, false))
+	}
+	for _, allowed := range paths.allow {
+		p := normalizePath(allowed, workDir, false)
+		reachable := false
+		for _, root := range roots {
+			if withinPath(p, root) || withinPath(root, p) {
+				reachable = true
+				break
+			}
+		}
+		if !reachable {
+			add("AllowPaths", allowed, "is outside WorkDir and every AddDir, so it is likely a typo")
+		}
+	}
+
+	subagents := make([]string, 0, len(c.subagents))
+	for name := range c.subagents {
+		subagents = append(subagents, name)
+	}
+	sort.Strings(subagents)
+	// validateSubagents already rejects tools missing from a Tools list
+	for _, name := range subagents {
+		for _, tool := range c.subagents[name].Tools {
+			switch {
+			case disallowed[toolRuleName(tool)]:
+				add("SubagentTools", tool, "of subagent %q is disallowed for the parent by DisallowedTools", name)
+			case !enabled(toolRuleName(tool)):
+				add("SubagentTools", tool, "of subagent %q is not enabled for the parent by Tools", name)
+			}
+		}
+	}
+
+	for _, r := range c.preToolUseRedirects {
+		if r == nil {
+			continue
+		}
+		to := normalizePath(r.to, workDir, false)
+		for _, denied := range paths.deny {
+			if withinPath(to, normalizePath(denied, workDir, false)) {
+				add("RedirectPath", r.to, "is inside DenyPaths %q, so calls redirected from %q are denied", denied, r.from)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// pathHookArgs collects the paths given to the AllowPaths and DenyPaths
+// hooks registered with PreToolUse or PreToolUsePriority. Hooks that wrap
+// them are not included.
+type pathHookArgs struct {
+	allow []string
+	deny  []string
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStrictConfig(t *testing.T) {
+	ctx := context.Background()
+	cli := resultCLI(t, "ok")
+	work := t.TempDir()
+	sandbox := filepath.Join(work, "sandbox")
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string // The one conflict, "" for none
+	}{
+		{"tool enabled and disallowed",
+			[]Option{Tools(ToolRead, ToolWrite), DisallowedTools(ToolWrite)},
+			`DisallowedTools: "Write" is also enabled by Tools, so it can never be used`},
+		{"tool partly disallowed",
+			[]Option{Tools(ToolBash), DisallowedTools("Bash(rm:*)")}, ""},
+		{"pattern allowed and disallowed",
+			[]Option{AllowedTools("Bash(git:*)"), DisallowedTools("Bash(git:*)")},
+			`AllowedTools: "Bash(git:*)" is also in DisallowedTools, which takes precedence`},
+		{"allowed tool not enabled",
+			[]Option{Tools(ToolRead), AllowedTools("Bash(go test:*)", ToolRead, "mcp__docs__search")},
+			`AllowedTools: "Bash(go test:*)" allows Bash, which Tools does not enable`},
+		{"allowed tool with all tools enabled",
+			[]Option{AllowedTools("Bash(go test:*)")}, ""},
+		{"allowed path outside work dir",
+			[]Option{PreToolUse(AllowPaths("sandbox", "/nonexistent/sandbx"))},
+			`AllowPaths: "/nonexistent/sandbx" is outside WorkDir and every AddDir, so it is likely a typo`},
+		{"allowed path in add dir or above work dir",
+			[]Option{AddDir("/opt/shared"), PreToolUse(AllowPaths("/opt/shared/data", "/"))}, ""},
+		{"subagent tool not enabled",
+			[]Option{Tools(), Subagent("tester", SubagentDescription("Runs tests"), SubagentTools(ToolBash))},
+			`SubagentTools: "Bash" of subagent "tester" is not enabled for the parent by Tools`},
+		{"subagent tool disallowed",
+			[]Option{DisallowedTools(ToolWrite), Subagent("writer", SubagentDescription("Writes files"), SubagentTools(ToolWrite))},
+			`SubagentTools: "Write" of subagent "writer" is disallowed for the parent by DisallowedTools`},
+		{"redirect into denied path",
+			[]Option{PreToolUse(DenyPaths(sandbox), RedirectPath("/tmp", filepath.Join(sandbox, "tmp")))},
+			`RedirectPath: "` + filepath.Join(sandbox, "tmp") + `" is inside DenyPaths "` + sandbox + `", so calls redirected from "/tmp" are denied`},
+		{"redirect with explicit priority into denied path",
+			[]Option{PreToolUse(DenyPaths(sandbox)), PreToolUsePriority(PrioritySecurity+1, RedirectPath("/tmp", filepath.Join(sandbox, "tmp")))},
+			`RedirectPath: "` + filepath.Join(sandbox, "tmp") + `" is inside DenyPaths "` + sandbox + `", so calls redirected from "/tmp" are denied`},
+		{"redirect beside denied path",
+			[]Option{PreToolUse(DenyPaths(sandbox+"/secrets"), RedirectPathCreate("/tmp", "sandbox/tmp"))}, ""},
+		{"wrapped hooks are not called",
+			[]Option{PreToolUse(func(tc *ToolCall) HookResult {
+				t.Error("New called a hook")
+				return DenyPaths(sandbox).Evaluate(tc)
+			}), PreToolUse(RedirectPath("/tmp", sandbox))}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{CLIPath(cli), WorkDir(work), StrictConfig(true)}, tt.opts...)
+			a, err := New(ctx, opts...)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("New() error = %v, want none", err)
+				}
+				mustClose(t, a)
+				return
+			}
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) || cerr.Reason != tt.want {
+				t.Fatalf("New() error = %v, want a *ConfigError with reason %s", err, tt.want)
+			}
+			if want := tt.want[:strings.Index(tt.want, ":")]; cerr.Option != want {
+				t.Errorf("Option = %q, want %q", cerr.Option, want)
+			}
+		})
+	}
+}
+
+func TestStrictConfigSeveral(t *testing.T) {
+	ctx := context.Background()
+	work := t.TempDir()
+	opts := []Option{
+		CLIPath(resultCLI(t, "ok")),
+		WorkDir(work),
+		Tools(ToolRead, ToolWrite, ToolEdit),
+		DisallowedTools(ToolWrite),
+		AllowedTools("Bash(git:*)"),
+		Subagent("writer", SubagentDescription("Writes files"), SubagentTools(ToolRead, ToolWrite)),
+		PreToolUse(
+			AllowPaths("/nonexistent/sandbx"),
+			DenyPaths(filepath.Join(work, "out")),
+			RedirectPath("/tmp", filepath.Join(work, "out", "tmp")),
+		),
+	}
+	want := []string{
+		`DisallowedTools: "Write" is also enabled by Tools, so it can never be used`,
+		`AllowedTools: "Bash(git:*)" allows Bash, which Tools does not enable`,
+		`AllowPaths: "/nonexistent/sandbx" is outside WorkDir and every AddDir, so it is likely a typo`,
+		`SubagentTools: "Write" of subagent "writer" is disallowed for the parent by DisallowedTools`,
+		`RedirectPath: "` + filepath.Join(work, "out", "tmp") + `" is inside DenyPaths "` + filepath.Join(work, "out") + `", so calls redirected from "/tmp" are denied`,
+	}
+
+	_, err := New(ctx, append(opts, StrictConfig(true))...)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("New() error = %v, want a *ConfigError", err)
+	}
+	if got := strings.Split(cerr.Reason, "; "); !reflect.DeepEqual(got, want) {
+		t.Errorf("Reason lists:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if cerr.Option != "DisallowedTools" || cerr.Value != ToolWrite {
+		t.Errorf("ConfigError names %s %q, want the first conflict", cerr.Option, cerr.Value)
+	}
+
+	// Without StrictConfig each is a warning
+	var audited []string
+	a, err := New(ctx, append(opts, Audit(func(e AuditEvent) {
+		if e.Type == "config.warning" {
+			audited = append(audited, e.Data.(map[string]any)["warning"].(string))
+		}
+	}))...)
+	if err != nil {
+		t.Fatalf("New() error = %v, want warnings only", err)
+	}
+	defer mustClose(t, a)
+	if got := a.ConfigWarnings(); !reflect.DeepEqual(got, want) || !reflect.DeepEqual(audited, want) {
+		t.Errorf("ConfigWarnings() = %q, audited %q, want %q", got, audited, want)
+	}
+}
@@ -1,5 +1,12 @@
 package agent
 
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
 // SubagentConfig defines configuration for a subagent that can be spawned by the Task tool.
 // Subagents are child agents that run autonomously with their own configuration.
 type SubagentConfig struct {
@@ -8,6 +15,10 @@ type SubagentConfig struct {
 	Prompt      string   // System prompt or instructions for the subagent
 	Tools       []string // Tools available to the subagent
 	Model       string   // Model override for the subagent (empty = inherit from parent)
+
+	// ShareScratch redirects the subagent's /tmp paths into the agent's
+	// ScratchDir itself rather than a subdirectory of its own.
+	ShareScratch bool
 }
 
 // SubagentOption configures a subagent.
@@ -38,9 +49,83 @@ func SubagentTools(tools ...string) SubagentOption {
 
 // SubagentModel sets the model for the subagent.
 // Common values: "haiku" for fast/cheap tasks, "sonnet" for balanced tasks.
-// If not set, the subagent inherits the model from the parent agent.
+// If not set, or set to "inherit", the subagent inherits the model from the
+// parent agent. New checks the name as it does for Model.
 func SubagentModel(model string) SubagentOption {
 	return func(c *SubagentConfig) {
 		c.Model = model
 	}
 }
+
+// SubagentShareScratch lets the subagent use the agent's ScratchDir
+// directly. By default each subagent run gets a subdirectory of its own,
+// so that it does not see or overwrite the agent's scratch files.
+func SubagentShareScratch(share bool) SubagentOption {
+	return func(c *SubagentConfig) {
+		c.ShareScratch = share
+	}
+}
+
+// subagentNamePattern matches valid subagent names, such as "code-reviewer".
+var subagentNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// Validate checks the parts of a subagent definition that do not depend on
+// the agent: the name must be lowercase letters, digits, and hyphens,
+// starting with a letter, and the description, which Claude reads to choose
+// a subagent, is required. New calls it for each Subagent and also checks
+// the model and tools against the agent's options. Errors are
+// *ConfigError values.
+func (s *SubagentConfig) Validate() error {
+	if !subagentNamePattern.MatchString(s.Name) {
+		return &ConfigError{
+			Option: "Subagent",
+			Value:  s.Name,
+			Reason: "name must be lowercase letters, digits, and hyphens, starting with a letter",
+		}
+	}
+	if strings.TrimSpace(s.Description) == "" {
+		return &ConfigError{
+			Option: "SubagentDescription",
+			Value:  s.Description,
+			Reason: fmt.Sprintf("subagent %q needs a description for Claude to choose it by", s.Name),
+		}
+	}
+	return nil
+}
+
+// validateSubagents checks each subagent definition. When Tools limits the
+// agent's tools, a subagent may only use tools from that list.
+func validateSubagents(cfg *config) error {
+	names := make([]string, 0, len(cfg.subagents))
+	for name := range cfg.subagents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var allowed map[string]bool
+	if len(cfg.tools) > 0 {
+		allowed = make(map[string]bool, len(cfg.tools))
+		for _, tool := range cfg.tools {
+			allowed[toolRuleName(tool)] = true
+		}
+	}
+	for _, name := range names {
+		sub := cfg.subagents[name]
+		if err := sub.Validate(); err != nil {
+			return err
+		}
+		if allowed == nil {
+			continue
+		}
+		for _, tool := range sub.Tools {
+			if !allowed[toolRuleName(tool)] {
+				return &ConfigError{
+					Option: "SubagentTools",
+					Value:  tool,
+					Reason: fmt.Sprintf("subagent %q uses a tool not in Tools", name),
+				}
+			}
+		}
+	}
+	return nil
+}
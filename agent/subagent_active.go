@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"sort"
+	"time"
+)
+
+// taskToolName is the built-in tool that starts subagents.
+const taskToolName = "Task"
+
+// ActiveSubagent is a configured subagent that Claude started and that has
+// not yet finished.
+type ActiveSubagent struct {
+	SubagentType    string    // Name of the configured subagent
+	ParentToolUseID string    // ID of the Task tool_use that started it
+	Description     string    // The Task's short description of the work
+	StartedAt       time.Time // When the Task tool_use arrived
+}
+
+// ActiveSubagents returns the configured subagents running in the current
+// run, oldest first. A subagent is added when a Task tool_use names it as
+// its subagent_type, and removed when its SubagentResultMsg or the Task's
+// ToolResult arrives. Subagents still listed when the run ends are removed
+// with a subagent.abandoned audit event. Subagents not configured with
+// Subagent are not tracked.
+//
+// Example:
+//
+//	for _, s := range a.ActiveSubagents() {
+//	    fmt.Printf("%s running for %v\n", s.SubagentType, time.Since(s.StartedAt))
+//	}
+func (a *Agent) ActiveSubagents() []ActiveSubagent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.activeSubagents) == 0 {
+		return nil
+	}
+	active := make([]ActiveSubagent, 0, len(a.activeSubagents))
+	for _, s := range a.activeSubagents {
+		active = append(active, s)
+	}
+	sortSubagents(active)
+	return active
+}
+
+// sortSubagents orders subagents by start time, then Task tool_use ID.
+func sortSubagents(s []ActiveSubagent) {
+	sort.Slice(s, func(i, j int) bool {
+		if !s[i].StartedAt.Equal(s[j].StartedAt) {
+			return s[i].StartedAt.Before(s[j].StartedAt)
+		}
+		return s[i].ParentToolUseID < s[j].ParentToolUseID
+	})
+}
+
+// startSubagent records a Task tool_use that starts a configured subagent
+// and calls the SubagentStart hooks.
+func (a *Agent) startSubagent(use *ToolUse) {
+	if use.Name != taskToolName || use.ID == "" {
+		return
+	}
+	name, _ := use.Input["subagent_type"].(string)
+	if _, ok := a.cfg.subagents[name]; !ok {
+		return
+	}
+	description, _ := use.Input["description"].(string)
+	started := ActiveSubagent{
+		SubagentType:    name,
+		ParentToolUseID: use.ID,
+		Description:     description,
+		StartedAt:       use.Timestamp,
+	}
+	if started.StartedAt.IsZero() {
+		started.StartedAt = time.Now()
+	}
+
+	a.mu.Lock()
+	if a.activeSubagents == nil {
+		a.activeSubagents = make(map[string]ActiveSubagent)
+	}
+	a.activeSubagents[use.ID] = started
+	sessionID := a.sessionID
+	a.mu.Unlock()
+
+	event := &SubagentStartEvent{
+		SessionID:       sessionID,
+		SubagentType:    started.SubagentType,
+		ParentToolUseID: started.ParentToolUseID,
+		Description:     started.Description,
+		StartedAt:       started.StartedAt,
+	}
+	if len(a.cfg.subagentStartHooks) > 0 {
+		a.runHook(func() {
+			for _, hook := range a.cfg.subagentStartHooks {
+				hook(event)
+			}
+		})
+	}
+	a.auditor.emit(sessionID, "hook.subagent_start", map[string]any{
+		"subagent_type":      started.SubagentType,
+		"parent_tool_use_id": started.ParentToolUseID,
+		"description":        started.Description,
+	})
+}
+
+// stopSubagent removes the subagent started by a Task tool_use, if any.
+func (a *Agent) stopSubagent(taskToolUseID string) {
+	if taskToolUseID == "" {
+		return
+	}
+	a.mu.Lock()
+	delete(a.activeSubagents, taskToolUseID)
+	a.mu.Unlock()
+}
+
+// abandonSubagents removes the subagents still running when a run ends,
+// reporting each with a subagent.abandoned audit event.
+func (a *Agent) abandonSubagents() {
+	a.mu.Lock()
+	abandoned := make([]ActiveSubagent, 0, len(a.activeSubagents))
+	for _, s := range a.activeSubagents {
+		abandoned = append(abandoned, s)
+	}
+	a.activeSubagents = nil
+	sessionID := a.sessionID
+	a.mu.Unlock()
+
+	sortSubagents(abandoned)
+	for _, s := range abandoned {
+		a.auditor.emit(sessionID, "subagent.abandoned", map[string]any{
+			"subagent_type":      s.SubagentType,
+			"parent_tool_use_id": s.ParentToolUseID,
+			"description":        s.Description,
+			"duration":           time.Since(s.StartedAt).String(),
+		})
+	}
+}
@@ -1,6 +1,13 @@
 package agent
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
 
 func TestSubagentConfig(t *testing.T) {
 	cfg := &SubagentConfig{Name: "test"}
@@ -49,3 +56,126 @@ func TestSubagentOptionsCompose(t *testing.T) {
 		t.Errorf("expected model 'haiku', got %q", cfg.Model)
 	}
 }
+
+func TestSubagentValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []Option
+		option string // ConfigError.Option; "" for a valid configuration
+	}{
+		{"valid", []Option{Subagent("test-runner", SubagentDescription("Runs tests"))}, ""},
+		{"uppercase name", []Option{Subagent("Tester", SubagentDescription("Runs tests"))}, "Subagent"},
+		{"space in name", []Option{Subagent("test runner", SubagentDescription("Runs tests"))}, "Subagent"},
+		{"no description", []Option{Subagent("tester")}, "SubagentDescription"},
+		{"tools within Tools", []Option{Tools("Bash", "Read"), Subagent("tester", SubagentDescription("Runs tests"), SubagentTools("Bash(go test:*)"))}, ""},
+		{"tool outside Tools", []Option{Tools("Read"), Subagent("tester", SubagentDescription("Runs tests"), SubagentTools("Read", "Bash"))}, "SubagentTools"},
+		{"any tool without Tools", []Option{Subagent("tester", SubagentDescription("Runs tests"), SubagentTools("Bash"))}, ""},
+		{"unknown model", []Option{Subagent("tester", SubagentDescription("Runs tests"), SubagentModel("haiku-5000"))}, "SubagentModel"},
+	}
+	for _, tt := range tests {
+		err := validateSubagents(newConfig(tt.opts...))
+		if err == nil {
+			err = normalizeModels(newConfig(tt.opts...))
+		}
+		var cfgErr *ConfigError
+		switch {
+		case tt.option == "" && err != nil:
+			t.Errorf("%s: error = %v, want nil", tt.name, err)
+		case tt.option != "" && (!errors.As(err, &cfgErr) || cfgErr.Option != tt.option):
+			t.Errorf("%s: error = %v, want a ConfigError for %s", tt.name, err, tt.option)
+		}
+	}
+}
+
+func TestActiveSubagents(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"subagent-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"Task","input":{"subagent_type":"tester","description":"Run tests","prompt":"go test ./..."}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-2","name":"Task","input":{"subagent_type":"reviewer","description":"Review diff","prompt":"Review it"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-3","name":"Task","input":{"subagent_type":"general-purpose","description":"Look around","prompt":"Explore"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-read-1","tool_name":"Read","tool_input":{"file_path":"go.mod"}}'
+read response
+printf '%s\n' '{"type":"system","subtype":"subagent_result","subagent_id":"sub-1","subagent_type":"tester","parent_tool_use_id":"tu-1","num_turns":3,"subagent_cost":0.01}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-2","content":"Looks good"}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-4","name":"Task","input":{"subagent_type":"tester","description":"Run tests again","prompt":"go test ./..."}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-2","tool_use_id":"tu-read-2","tool_name":"Read","tool_input":{"file_path":"go.mod"}}'
+read response
+printf '%s\n' '{"type":"result","result":"Done","num_turns":2}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	var a *Agent
+	var mu sync.Mutex
+	var snapshots [][]string
+	var started, stopped, abandoned []string
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		Subagent("tester", SubagentDescription("Runs tests")),
+		Subagent("reviewer", SubagentDescription("Reviews changes")),
+		PreToolUse(func(tc *ToolCall) HookResult {
+			var running []string
+			for _, s := range a.ActiveSubagents() {
+				if s.StartedAt.IsZero() {
+					t.Errorf("ActiveSubagent %+v has no start time", s)
+				}
+				running = append(running, s.SubagentType+"/"+s.ParentToolUseID+"/"+s.Description)
+			}
+			mu.Lock()
+			snapshots = append(snapshots, running)
+			mu.Unlock()
+			return HookResult{Decision: Allow}
+		}),
+		SubagentStart(func(e *SubagentStartEvent) {
+			mu.Lock()
+			started = append(started, e.SubagentType+"/"+e.ParentToolUseID)
+			mu.Unlock()
+		}),
+		SubagentStop(func(e *SubagentStopEvent) {
+			mu.Lock()
+			stopped = append(stopped, e.ParentToolUseID)
+			mu.Unlock()
+		}),
+		Audit(func(e AuditEvent) {
+			if e.Type == "subagent.abandoned" {
+				mu.Lock()
+				abandoned = append(abandoned, e.Data.(map[string]any)["parent_tool_use_id"].(string))
+				mu.Unlock()
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "test and review"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The general-purpose subagent is not configured, so it is not tracked
+	wantSnapshots := [][]string{
+		{"tester/tu-1/Run tests", "reviewer/tu-2/Review diff"},
+		{"tester/tu-4/Run tests again"},
+	}
+	if !reflect.DeepEqual(snapshots, wantSnapshots) {
+		t.Errorf("ActiveSubagents() during the run = %v, want %v", snapshots, wantSnapshots)
+	}
+	if want := []string{"tester/tu-1", "reviewer/tu-2", "tester/tu-4"}; !reflect.DeepEqual(started, want) {
+		t.Errorf("SubagentStart hooks = %v, want %v", started, want)
+	}
+	if want := []string{"tu-1"}; !reflect.DeepEqual(stopped, want) {
+		t.Errorf("SubagentStop hooks = %v, want %v", stopped, want)
+	}
+	// The second tester never reported completion
+	if want := []string{"tu-4"}; !reflect.DeepEqual(abandoned, want) {
+		t.Errorf("subagent.abandoned events = %v, want %v", abandoned, want)
+	}
+	if active := a.ActiveSubagents(); len(active) != 0 {
+		t.Errorf("ActiveSubagents() after the run = %+v, want none", active)
+	}
+}
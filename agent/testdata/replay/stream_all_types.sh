@@ -0,0 +1,7 @@
+#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-types"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Thinking..."}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Let me analyze","signature":"sig1"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tool-1","name":"Bash","input":{"command":"ls"}}]}}'
+echo '{"type":"result","result":"Complete","num_turns":1,"total_cost_usd":0.002}'
@@ -0,0 +1,9 @@
+#!/bin/sh
+# One message of each kind, including a tool call and its result
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-filter"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Let me look","signature":"sig1"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tool-1","name":"Bash","input":{"command":"ls"}}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tool-1","content":"a.go"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"One file."}]}}'
+echo '{"type":"result","result":"One file.","num_turns":1}'
@@ -0,0 +1,5 @@
+#!/bin/sh
+# Starts a session, then never answers
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-stream-cancel"}'
+sleep 60
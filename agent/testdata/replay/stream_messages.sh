@@ -0,0 +1,6 @@
+#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-stream"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Hello!"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":" World!"}]}}'
+echo '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.001}'
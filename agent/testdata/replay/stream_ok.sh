@@ -0,0 +1,4 @@
+#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-err-nil"}'
+echo '{"type":"result","result":"OK","num_turns":1,"total_cost_usd":0.001}'
@@ -0,0 +1,9 @@
+#!/bin/sh
+# Two turns of extended thinking around a text answer
+read line
+echo '{"type":"system","subtype":"init","session_id":"test-thinking"}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"The user wants a haiku.","signature":"sig-1"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Five, seven, five.","signature":"sig-2"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Autumn moonlight"}]}}'
+echo '{"type":"assistant","message":{"role":"assistant","content":[{"type":"thinking","thinking":"Check the syllables.","signature":"sig-3"}]}}'
+echo '{"type":"result","result":"Autumn moonlight","num_turns":1}'
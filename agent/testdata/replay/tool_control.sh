@@ -0,0 +1,11 @@
+#!/bin/sh
+# A Read call that needs permission, then a failed exit
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"test-control"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"toolu_1","tool_name":"Read","tool_input":{"file_path":"/a"}}'
+read response
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+echo 'warning: session not saved' >&2
+exit 3
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ThinkingOption configures ThinkingToFile and ThinkingToWriter.
+type ThinkingOption func(*thinkingConfig)
+
+// thinkingConfig holds the settings from ThinkingToFile or ThinkingToWriter.
+type thinkingConfig struct {
+	path     string    // File to append to, opened on the first Thinking message
+	w        io.Writer // Writer to use instead of a file
+	suppress bool      // Leave Thinking messages out of Stream
+}
+
+// SuppressThinking leaves Thinking messages out of the Stream channel once
+// they have been written to the sink. They still reach hooks, audit
+// events, and cost estimates.
+func SuppressThinking() ThinkingOption {
+	return func(c *thinkingConfig) {
+		c.suppress = true
+	}
+}
+
+// ThinkingToFile appends the content of every Thinking message to a JSONL
+// file, one object per block with the session ID, run ID, turn, sequence,
+// and signature. The file is opened on the first Thinking message and
+// closed by Close. Thinking messages stay in the Stream channel unless
+// SuppressThinking is given, and message.thinking audit events are
+// unaffected. If the file cannot be opened or written, a thinking.error
+// audit event is emitted and later blocks are not written; the run
+// continues.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.ThinkingToFile("thinking.jsonl", agent.SuppressThinking()),
+//	)
+func ThinkingToFile(path string, opts ...ThinkingOption) Option {
+	return thinkingOption(&thinkingConfig{path: path}, opts)
+}
+
+// ThinkingToWriter is like ThinkingToFile but writes to w, which the agent
+// does not close.
+func ThinkingToWriter(w io.Writer, opts ...ThinkingOption) Option {
+	return thinkingOption(&thinkingConfig{w: w}, opts)
+}
+
+// thinkingOption applies opts to tc and returns an Option setting it.
+func thinkingOption(tc *thinkingConfig, opts []ThinkingOption) Option {
+	for _, opt := range opts {
+		opt(tc)
+	}
+	return func(c *config) {
+		c.thinking = tc
+	}
+}
+
+// thinkingRecord is one line written by a thinking sink.
+type thinkingRecord struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id"`
+	RunID     string    `json:"run_id,omitempty"`
+	Turn      int       `json:"turn"`
+	Sequence  int       `json:"sequence"`
+	Signature string    `json:"signature,omitempty"`
+	Thinking  string    `json:"thinking"`
+}
+
+// thinkingSink writes Thinking content for one agent.
+type thinkingSink struct {
+	cfg    *thinkingConfig
+	mu     sync.Mutex
+	file   *os.File      // Opened lazily for ThinkingToFile
+	enc    *json.Encoder // Nil until the first write
+	failed bool          // Set after a write error; later blocks are dropped
+}
+
+// newThinkingSink returns a sink for cfg, or nil if cfg is nil.
+func newThinkingSink(cfg *thinkingConfig) *thinkingSink {
+	if cfg == nil {
+		return nil
+	}
+	return &thinkingSink{cfg: cfg}
+}
+
+// suppressed reports whether Thinking messages are kept out of Stream.
+func (s *thinkingSink) suppressed() bool {
+	return s != nil && s.cfg.suppress
+}
+
+// write appends a record, opening the file first if needed. It returns an
+// error only for the first failure; after that the sink is disabled.
+func (s *thinkingSink) write(rec thinkingRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed {
+		return nil
+	}
+
+	if s.enc == nil {
+		w := s.cfg.w
+		if w == nil {
+			f, err := os.OpenFile(s.cfg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) // #nosec G304 -- Path provided by caller
+			if err != nil {
+				s.failed = true
+				return err
+			}
+			s.file = f
+			w = f
+		}
+		s.enc = json.NewEncoder(w)
+	}
+
+	if err := s.enc.Encode(rec); err != nil {
+		s.failed = true
+		return err
+	}
+	return nil
+}
+
+// close closes the file opened by ThinkingToFile, if any.
+func (s *thinkingSink) close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	s.failed = true // Late blocks must not reopen the file
+	return err
+}
+
+// divertThinking writes a Thinking message to the sink, reporting the first
+// failure as a thinking.error audit event.
+func (a *Agent) divertThinking(m *Thinking, runID string) {
+	err := a.thinking.write(thinkingRecord{
+		Time:      m.Timestamp,
+		SessionID: m.SessionID,
+		RunID:     runID,
+		Turn:      m.Turn,
+		Sequence:  m.Sequence,
+		Signature: m.Signature,
+		Thinking:  m.Thinking,
+	})
+	if err != nil {
+		a.auditor.emit(a.SessionID(), "thinking.error", map[string]any{
+			"error": err.Error(),
+		})
+	}
+}
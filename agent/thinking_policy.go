@@ -0,0 +1,254 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ThinkingMode is how much of a Thinking message's content the agent
+// persists; see ThinkingPolicy.
+type ThinkingMode int
+
+const (
+	// ThinkingFull persists thinking content as it arrives (default).
+	ThinkingFull ThinkingMode = iota
+	// ThinkingRedacted replaces thinking content with a marker giving its
+	// length, keeping the signature.
+	ThinkingRedacted
+	// ThinkingDropped leaves thinking content out entirely.
+	ThinkingDropped
+)
+
+// String returns a string representation of the ThinkingMode.
+func (m ThinkingMode) String() string {
+	switch m {
+	case ThinkingFull:
+		return "full"
+	case ThinkingRedacted:
+		return "redacted"
+	case ThinkingDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// ThinkingPolicy sets how much thinking content the agent persists, for
+// data policies that forbid storing the model's reasoning. It applies to
+// every place the agent writes messages:
+//
+//   - message.thinking audit events. ThinkingRedacted replaces the
+//     "thinking" field with a marker such as "[thinking redacted: 812
+//     bytes]" and adds "length" and "signature" fields; ThinkingDropped
+//     emits no message.thinking events.
+//   - RecordCLI recordings, transcripts archived by PreCompact hooks, and
+//     the DebugWire log. The "thinking" field of each line is replaced by
+//     the marker with ThinkingRedacted and by "" with ThinkingDropped, so
+//     the lines keep their structure and signatures.
+//
+// Delivery of Thinking messages on the Stream channel is unaffected, so a
+// live UI can show them without persisting them; use SuppressThinking to
+// keep them off the channel. ThinkingToFile and ThinkingToWriter are
+// explicit destinations for thinking content and are unaffected too.
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.AuditToFile("audit.jsonl"),
+//	    agent.ThinkingPolicy(agent.ThinkingRedacted),
+//	)
+func ThinkingPolicy(mode ThinkingMode) Option {
+	return func(c *config) {
+		c.thinkingMode = mode
+	}
+}
+
+// validateThinkingMode checks the ThinkingPolicy mode.
+func validateThinkingMode(cfg *config) error {
+	switch cfg.thinkingMode {
+	case ThinkingFull, ThinkingRedacted, ThinkingDropped:
+		return nil
+	}
+	return &ConfigError{
+		Option: "ThinkingPolicy",
+		Value:  strconv.Itoa(int(cfg.thinkingMode)),
+		Reason: "unknown mode",
+	}
+}
+
+// redactedThinking returns the marker that replaces n bytes of thinking.
+func redactedThinking(n int) string {
+	return "[thinking redacted: " + strconv.Itoa(n) + " bytes]"
+}
+
+// emitThinkingEvent emits the message.thinking audit event for m as the
+// ThinkingPolicy allows.
+func (a *Agent) emitThinkingEvent(m *Thinking) {
+	switch a.cfg.thinkingMode {
+	case ThinkingRedacted:
+		a.auditor.emit(a.sessionID, "message.thinking", withLinkage(map[string]any{
+			"thinking":  redactedThinking(len(m.Thinking)),
+			"length":    len(m.Thinking),
+			"signature": m.Signature,
+		}, &m.MessageMeta))
+	case ThinkingDropped:
+	default:
+		a.auditor.emit(a.sessionID, "message.thinking", withLinkage(map[string]any{
+			"thinking": m.Thinking,
+		}, &m.MessageMeta))
+	}
+}
+
+// thinkingKey is the field holding thinking content, in thinking blocks and
+// thinking deltas alike.
+var thinkingKey = []byte(`"thinking"`)
+
+// maskThinking returns line, a line of JSON, with the string value of each
+// "thinking" field replaced as mode requires. The rest of the line is left
+// byte for byte, and line itself is returned when nothing is replaced.
+func maskThinking(line []byte, mode ThinkingMode) []byte {
+	if mode == ThinkingFull || !bytes.Contains(line, thinkingKey) {
+		return line
+	}
+	var out []byte
+	last := 0
+	for i := 0; ; {
+		k := bytes.Index(line[i:], thinkingKey)
+		if k < 0 {
+			break
+		}
+		k += i
+		i = k + len(thinkingKey)
+		// A key is followed by a colon; "thinking" as a value, such as a
+		// block's type, is not. An escaped quote is inside a string.
+		if k > 0 && line[k-1] == '\\' {
+			continue
+		}
+		j := skipJSONSpace(line, i)
+		if j == len(line) || line[j] != ':' {
+			continue
+		}
+		j = skipJSONSpace(line, j+1)
+		if j == len(line) || line[j] != '"' {
+			continue // Not a string, e.g. the init message's settings
+		}
+		end := jsonStringEnd(line, j)
+		if end < 0 {
+			break
+		}
+		i = end
+		var text string
+		if err := json.Unmarshal(line[j:end], &text); err != nil || text == "" {
+			continue
+		}
+		out = append(out, line[last:j]...)
+		if mode == ThinkingRedacted {
+			out = append(out, '"')
+			out = append(out, redactedThinking(len(text))...)
+			out = append(out, '"')
+		} else {
+			out = append(out, `""`...)
+		}
+		last = end
+	}
+	if out == nil {
+		return line
+	}
+	return append(out, line[last:]...)
+}
+
+// skipJSONSpace returns the index of the first byte at or after i that is
+// not JSON whitespace.
+func skipJSONSpace(b []byte, i int) int {
+	for i < len(b) && (b[i] == ' ' || b[i] == '\t' || b[i] == '\n' || b[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// jsonStringEnd returns the index just past the end of the JSON string
+// starting at b[i], or -1 if it is not terminated.
+func jsonStringEnd(b []byte, i int) int {
+	for i++; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// archiveTranscript copies the transcript at src to dst, creating dst's
+// directory, with thinking masked as mode requires.
+func archiveTranscript(src, dst string, mode ThinkingMode) (err error) {
+	in, err := os.Open(src) // #nosec G304 -- Path from the CLI
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }() // Read only
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600) // #nosec G304 -- Path provided by caller
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	r := bufio.NewReader(in)
+	w := bufio.NewWriter(out)
+	for {
+		line, rerr := r.ReadBytes('\n') // Transcript entries can hold large tool results
+		if _, err := w.Write(maskThinking(line, mode)); err != nil {
+			return err
+		}
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return w.Flush()
+}
+
+// archiveOnCompact archives the transcript to each ArchiveTo path that a
+// PreCompact hook asked for. Each copy is reported as a compact.archived
+// audit event, and failures as compact.archive_failed; neither ends the
+// run.
+func (a *Agent) archiveOnCompact(sessionID, transcriptPath string, results []PreCompactResult) {
+	if transcriptPath == "" {
+		return
+	}
+	for _, r := range results {
+		if !r.Archive || r.ArchiveTo == "" {
+			continue
+		}
+		if err := archiveTranscript(transcriptPath, r.ArchiveTo, a.cfg.thinkingMode); err != nil {
+			a.auditor.emit(sessionID, "compact.archive_failed", map[string]any{
+				"transcript_path": transcriptPath,
+				"archive_path":    r.ArchiveTo,
+				"error":           err.Error(),
+			})
+			continue
+		}
+		a.auditor.emit(sessionID, "compact.archived", map[string]any{
+			"transcript_path": transcriptPath,
+			"archive_path":    r.ArchiveTo,
+			"thinking":        a.cfg.thinkingMode.String(),
+		})
+	}
+}
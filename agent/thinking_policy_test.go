@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMaskThinking(t *testing.T) {
+	block := `{"type":"assistant","message":{"content":[{"type":"thinking","thinking":"plan \"a\"\nthen b","signature":"sig-1"},{"type":"text","text":"Done"}]}}`
+	tests := []struct {
+		name string
+		line string
+		mode ThinkingMode
+		want string
+	}{
+		{"full", block, ThinkingFull, block},
+		{"redacted", block, ThinkingRedacted, strings.Replace(block, `"plan \"a\"\nthen b"`, `"[thinking redacted: 15 bytes]"`, 1)},
+		{"dropped", block, ThinkingDropped, strings.Replace(block, `"plan \"a\"\nthen b"`, `""`, 1)},
+		{
+			"delta",
+			`{"type":"stream_event","event":{"delta":{"type":"thinking_delta", "thinking" : "abc"}}}`,
+			ThinkingRedacted,
+			`{"type":"stream_event","event":{"delta":{"type":"thinking_delta", "thinking" : "[thinking redacted: 3 bytes]"}}}`,
+		},
+		{
+			"mentioned in text",
+			`{"type":"text","text":"the \"thinking\":\"x\" field"}`,
+			ThinkingRedacted,
+			`{"type":"text","text":"the \"thinking\":\"x\" field"}`,
+		},
+		{
+			"not a string",
+			`{"type":"system","thinking":{"budget":1024},"tags":["thinking"]}`,
+			ThinkingDropped,
+			`{"type":"system","thinking":{"budget":1024},"tags":["thinking"]}`,
+		},
+		{"empty", `{"type":"thinking","thinking":""}`, ThinkingRedacted, `{"type":"thinking","thinking":""}`},
+		{"unterminated", `{"type":"thinking","thinking":"abc`, ThinkingRedacted, `{"type":"thinking","thinking":"abc`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(maskThinking([]byte(tt.line), tt.mode)); got != tt.want {
+				t.Errorf("maskThinking() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThinkingPolicy(t *testing.T) {
+	const secret = "the secret plan"
+
+	for _, mode := range []ThinkingMode{ThinkingFull, ThinkingRedacted, ThinkingDropped} {
+		t.Run(mode.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			transcript := filepath.Join(dir, "transcript.jsonl")
+			mustWriteFile(t, transcript, []byte(`{"type":"user","message":{"content":"go"}}`+"\n"+
+				`{"type":"assistant","message":{"content":[{"type":"thinking","thinking":"`+secret+`","signature":"sig-1"}]}}`+"\n"), 0600)
+			script := filepath.Join(dir, "script.jsonl")
+			mustWriteFile(t, script, []byte(`{"op":"in"}
+{"op":"out","line":"{\"type\":\"system\",\"subtype\":\"init\",\"session_id\":\"thinking\"}"}
+{"op":"out","line":"{\"type\":\"assistant\",\"message\":{\"role\":\"assistant\",\"content\":[{\"type\":\"thinking\",\"thinking\":\"`+secret+`\",\"signature\":\"sig-1\"}]}}"}
+{"op":"out","line":"{\"type\":\"system\",\"subtype\":\"compact\",\"trigger\":\"auto\",\"transcript_path\":\"`+transcript+`\",\"token_count\":95000}"}
+{"op":"out","line":"{\"type\":\"result\",\"result\":\"Done\",\"num_turns\":1}"}
+`), 0600)
+
+			var mu sync.Mutex
+			var events []AuditEvent
+			var wire syncBuffer
+			recording := filepath.Join(dir, "recording.jsonl")
+			archive := filepath.Join(dir, "archives", "1.jsonl")
+			messages, err := replayRun(t,
+				Replay(script),
+				ThinkingPolicy(mode),
+				RecordCLI(recording),
+				DebugWire(&wire),
+				PreCompact(func(*PreCompactEvent) PreCompactResult {
+					return PreCompactResult{Archive: true, ArchiveTo: archive}
+				}),
+				Audit(func(e AuditEvent) {
+					mu.Lock()
+					defer mu.Unlock()
+					events = append(events, e)
+				}),
+			)
+			if err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			// Stream delivery does not depend on the policy
+			var streamed []string
+			for _, msg := range messages {
+				if th, ok := msg.(*Thinking); ok {
+					streamed = append(streamed, th.Thinking)
+				}
+			}
+			if len(streamed) != 1 || streamed[0] != secret {
+				t.Errorf("streamed thinking = %q, want the content", streamed)
+			}
+
+			// Audit events
+			mu.Lock()
+			var thinking []map[string]any
+			archived := false
+			for _, e := range events {
+				switch e.Type {
+				case "message.thinking":
+					thinking = append(thinking, e.Data.(map[string]any))
+				case "compact.archived":
+					archived = true
+				}
+			}
+			mu.Unlock()
+			marker := redactedThinking(len(secret))
+			switch mode {
+			case ThinkingFull:
+				if len(thinking) != 1 || thinking[0]["thinking"] != secret {
+					t.Errorf("message.thinking events = %v, want the content", thinking)
+				}
+			case ThinkingRedacted:
+				if len(thinking) != 1 || thinking[0]["thinking"] != marker || thinking[0]["length"] != len(secret) || thinking[0]["signature"] != "sig-1" {
+					t.Errorf("message.thinking events = %v, want the marker, length, and signature", thinking)
+				}
+			case ThinkingDropped:
+				if len(thinking) != 0 {
+					t.Errorf("message.thinking events = %v, want none", thinking)
+				}
+			}
+			if !archived {
+				t.Error("no compact.archived event")
+			}
+
+			// Recording, archived transcript, and wire log
+			want := map[ThinkingMode]string{
+				ThinkingFull:     secret,
+				ThinkingRedacted: marker,
+				ThinkingDropped:  `\"thinking\":\"\"`,
+			}[mode]
+			archiveData, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordData, err := os.ReadFile(recording)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, got := range map[string]string{
+				"recording": string(recordData),
+				"archive":   strings.ReplaceAll(string(archiveData), `"`, `\"`),
+				"wire log":  strings.ReplaceAll(wire.String(), `"`, `\"`),
+			} {
+				if !strings.Contains(got, want) || !strings.Contains(got, "sig-1") {
+					t.Errorf("%s = %s, want %s and the signature", name, got, want)
+				}
+				if mode != ThinkingFull && strings.Contains(got, secret) {
+					t.Errorf("%s holds the thinking content: %s", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestThinkingPolicyArchiveFailure(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	mustWriteFile(t, blocker, nil, 0600)
+	if err := archiveTranscript(filepath.Join(dir, "missing.jsonl"), filepath.Join(dir, "a.jsonl"), ThinkingFull); err == nil {
+		t.Error("archiveTranscript() of a missing transcript succeeded")
+	}
+	transcript := filepath.Join(dir, "transcript.jsonl")
+	mustWriteFile(t, transcript, []byte("{}\n"), 0600)
+	if err := archiveTranscript(transcript, filepath.Join(blocker, "a.jsonl"), ThinkingFull); err == nil {
+		t.Error("archiveTranscript() under a file succeeded")
+	}
+
+	var cerr *ConfigError
+	if _, err := New(context.Background(), ThinkingPolicy(ThinkingMode(7))); !errors.As(err, &cerr) || cerr.Option != "ThinkingPolicy" {
+		t.Errorf("New() error = %v, want a *ConfigError for ThinkingPolicy", err)
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// thinkingRun streams the thinking fixture and returns the delivered
+// message types and the audit event types.
+func thinkingRun(t *testing.T, opts ...Option) ([]MessageType, map[string]int) {
+	t.Helper()
+	var mu sync.Mutex
+	audited := map[string]int{}
+	opts = append(opts, replayFixture("thinking"), Audit(func(e AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		audited[e.Type]++
+	}))
+
+	ctx := context.Background()
+	a, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var got []MessageType
+	for msg := range a.Stream(ctx, "write a haiku") {
+		got = append(got, messageTypeOf(msg))
+	}
+	mustClose(t, a)
+
+	mu.Lock()
+	defer mu.Unlock()
+	return got, audited
+}
+
+// readThinkingRecords decodes the lines written by a thinking sink.
+func readThinkingRecords(t *testing.T, data []byte) []thinkingRecord {
+	t.Helper()
+	var records []thinkingRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var rec thinkingRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("Unmarshal(%s) error = %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestThinkingToFile(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []ThinkingOption
+		want string
+	}{
+		{name: "kept in stream", want: "thinking thinking text thinking result"},
+		{name: "suppressed", opts: []ThinkingOption{SuppressThinking()}, want: "text result"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "thinking.jsonl")
+			got, audited := thinkingRun(t, ThinkingToFile(path, tt.opts...))
+
+			if s := strings.Join(messageTypeStrings(got), " "); s != tt.want {
+				t.Errorf("delivered %q, want %q", s, tt.want)
+			}
+			if audited["message.thinking"] != 3 {
+				t.Errorf("got %d message.thinking audit events, want 3", audited["message.thinking"])
+			}
+
+			records := readThinkingRecords(t, mustReadFile(t, path))
+			want := []struct {
+				thinking, signature string
+				sequence            int
+			}{
+				{"The user wants a haiku.", "sig-1", 2}, // The init message is sequence 1
+				{"Five, seven, five.", "sig-2", 3},
+				{"Check the syllables.", "sig-3", 5},
+			}
+			if len(records) != len(want) {
+				t.Fatalf("wrote %d records, want %d", len(records), len(want))
+			}
+			for i, w := range want {
+				rec := records[i]
+				if rec.Thinking != w.thinking || rec.Signature != w.signature || rec.Sequence != w.sequence ||
+					rec.SessionID != "test-thinking" || rec.RunID == "" || rec.Time.IsZero() {
+					t.Errorf("record %d = %+v, want %q (%s) at sequence %d", i, rec, w.thinking, w.signature, w.sequence)
+				}
+			}
+		})
+	}
+}
+
+// messageTypeStrings converts message types to strings.
+func messageTypeStrings(types []MessageType) []string {
+	out := make([]string, len(types))
+	for i, mt := range types {
+		out[i] = string(mt)
+	}
+	return out
+}
+
+func TestThinkingToFileIsLazy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thinking.jsonl")
+	ctx := context.Background()
+	a, err := New(ctx, replayFixture("stream_ok"), ThinkingToFile(path))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := a.Run(ctx, "go"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mustClose(t, a)
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat() error = %v, want no file for a run without thinking", err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestThinkingWriteFailureKeepsStream(t *testing.T) {
+	got, audited := thinkingRun(t, ThinkingToWriter(failingWriter{}))
+
+	if len(got) != 5 || got[len(got)-1] != MessageResult {
+		t.Errorf("delivered %v, want all five messages ending in a result", got)
+	}
+	if audited["thinking.error"] != 1 {
+		t.Errorf("got %d thinking.error events, want 1", audited["thinking.error"])
+	}
+}
+
+func TestThinkingToWriter(t *testing.T) {
+	var buf bytes.Buffer
+	thinkingRun(t, ThinkingToWriter(&buf, SuppressThinking()))
+
+	if records := readThinkingRecords(t, buf.Bytes()); len(records) != 3 {
+		t.Errorf("wrote %d records, want 3", len(records))
+	}
+}
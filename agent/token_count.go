@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// TokenCounter counts the tokens in text as a model's tokenizer would. The
+// SDK's token estimates, for PromptBudget, CostEstimator, and Describe's
+// advertised tools, go through the agent's TokenCounter; see
+// WithTokenCounter.
+type TokenCounter interface {
+	// CountTokens returns the number of tokens in text for model, which
+	// may be "" when no model is configured.
+	CountTokens(text, model string) (int, error)
+}
+
+// TokenCounterFunc adapts a function to a TokenCounter.
+type TokenCounterFunc func(text, model string) (int, error)
+
+// CountTokens calls f.
+func (f TokenCounterFunc) CountTokens(text, model string) (int, error) {
+	return f(text, model)
+}
+
+// CountTokens estimates the number of tokens in text with the SDK's
+// built-in heuristic, which is the default TokenCounter. Rather than one
+// token per four characters, which undercounts code and data badly, it
+// counts words, identifier parts split at case changes, digit groups,
+// runs of punctuation, line breaks, and indentation, the units a
+// byte-pair tokenizer splits text into. Letters of scripts without spaces,
+// such as Chinese and Japanese, count one token each.
+//
+// The heuristic is the same for every model. The error is always nil; it
+// gives CountTokens TokenCounter's signature, so TokenCounterFunc(CountTokens)
+// can serve as the fallback of a custom counter.
+func CountTokens(text, model string) (int, error) {
+	return heuristicTokens(text), nil
+}
+
+// WithTokenCounter sets the TokenCounter behind the agent's token
+// estimates. It is called with the agent's model, and when it returns an
+// error the built-in heuristic counts that text instead. The
+// per-feature counters set with PromptTokens and EstimateTokens take
+// precedence. ContextUsage reports the token counts of each Result rather
+// than estimates, so it does not use the counter. A nil counter restores
+// the built-in heuristic (default).
+//
+// Example:
+//
+//	a, _ := agent.New(ctx,
+//	    agent.WithTokenCounter(agent.TokenCounterFunc(tokenizer.Count)),
+//	    agent.PromptBudget(50_000, agent.TruncateMiddle("")),
+//	)
+func WithTokenCounter(tc TokenCounter) Option {
+	return func(c *config) {
+		c.tokenCounter = tc
+	}
+}
+
+// countTokens returns the configured token counter bound to the model.
+func (c *config) countTokens() func(text string) int {
+	tc, model := c.tokenCounter, c.model
+	if tc == nil {
+		return heuristicTokens
+	}
+	return func(text string) int {
+		n, err := tc.CountTokens(text, model)
+		if err != nil {
+			return heuristicTokens(text)
+		}
+		return n
+	}
+}
+
+// Characters per token of the units the heuristic counts. A longer word
+// or identifier part is split into pieces of wordPiece letters, as a
+// tokenizer splits rare words. Indentation takes a token per
+// indentPiece columns, with tabs tabWidth columns wide.
+const (
+	wordPiece   = 8
+	digitPiece  = 3
+	punctPiece  = 3
+	indentPiece = 8
+	tabWidth    = 4
+)
+
+// heuristicTokens implements CountTokens. It makes a single pass over text
+// without allocating, as it runs on every streamed message when cost is
+// estimated.
+func heuristicTokens(text string) int {
+	tokens := 0
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case isASCIILetter(c):
+			// A word, split into parts where lower case turns to upper case
+			part := 0
+			for ; i < len(text) && isASCIILetter(text[i]); i++ {
+				if part > 0 && isUpper(text[i]) && !isUpper(text[i-1]) {
+					tokens += pieces(part, wordPiece)
+					part = 0
+				}
+				part++
+			}
+			tokens += pieces(part, wordPiece)
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(text) && text[j] >= '0' && text[j] <= '9' {
+				j++
+			}
+			tokens += pieces(j-i, digitPiece)
+			i = j
+		case c == '\n' || c == '\r':
+			// Line breaks take a token with the indentation that follows
+			for i < len(text) && (text[i] == '\n' || text[i] == '\r') {
+				i++
+			}
+			var width int
+			i, width = skipSpace(text, i)
+			tokens++
+			if width > indentPiece {
+				tokens += pieces(width-indentPiece, indentPiece)
+			}
+		case c == ' ' || c == '\t':
+			// A single space joins the following word; longer runs are
+			// alignment
+			var width int
+			i, width = skipSpace(text, i)
+			if width > 1 {
+				tokens += pieces(width, indentPiece)
+			}
+		case c < utf8.RuneSelf:
+			j := i
+			for j < len(text) && text[j] < utf8.RuneSelf && isASCIIPunct(text[j]) {
+				if text[j] == '\\' && j+1 < len(text) && isEscapeLetter(text[j+1]) {
+					j++ // An escape such as \n in JSON is punctuation
+				}
+				j++
+			}
+			if j == i {
+				j++ // A control character
+			}
+			tokens += pieces(j-i, punctPiece)
+			i = j
+		default:
+			r, size := utf8.DecodeRuneInString(text[i:])
+			switch {
+			case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+				tokens++
+			case unicode.IsLetter(r) || unicode.IsMark(r):
+				// Accented and other alphabetic letters take about two
+				// per token
+				j := i
+				n := 0
+				for j < len(text) {
+					r, size := utf8.DecodeRuneInString(text[j:])
+					if !(unicode.IsLetter(r) || unicode.IsMark(r)) || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+						break
+					}
+					j += size
+					n++
+				}
+				tokens += pieces(n, 2)
+				size = j - i
+			default:
+				// Symbols and emoji take a token per two bytes
+				tokens += pieces(size, 2)
+			}
+			i += size
+		}
+	}
+	return tokens
+}
+
+// skipSpace returns the index of the first character at or after i that
+// is not a space or tab, and the width of those skipped.
+func skipSpace(text string, i int) (int, int) {
+	width := 0
+	for ; i < len(text) && (text[i] == ' ' || text[i] == '\t'); i++ {
+		if text[i] == '\t' {
+			width += tabWidth
+		} else {
+			width++
+		}
+	}
+	return i, width
+}
+
+// pieces returns the number of pieces of at most size units in n units.
+func pieces(n, size int) int {
+	return (n + size - 1) / size
+}
+
+func isASCIILetter(c byte) bool { return c|0x20 >= 'a' && c|0x20 <= 'z' }
+
+func isEscapeLetter(c byte) bool { return c == 'n' || c == 't' || c == 'r' || c == 'u' }
+
+func isUpper(c byte) bool { return c >= 'A' && c <= 'Z' }
+
+func isASCIIPunct(c byte) bool {
+	return c > ' ' && c < 0x7f && !isASCIILetter(c) && (c < '0' || c > '9')
+}
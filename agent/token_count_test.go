@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := map[string]int{
+		"":                      0,
+		"hello":                 1,
+		"The quick brown fox.":  5,
+		"internationalization":  3, // Split into pieces
+		"parseHTTPResponse":     3, // Split at case changes
+		"2024":                  2, // Digit groups
+		"if err != nil {":       5, // Runs of punctuation
+		"a\n\t\tb":              3, // Indentation joins the line break
+		"a       b":             3, // Alignment
+		`{"text":"a\nb"}`:       7, // JSON escapes are punctuation
+		"日本語の文":                 5, // A token per character
+		"héllo wörld":           6, // Accented letters split words
+		"👍":                     2, // Symbols by bytes
+		"func main() {\n}\n":    7,
+		"\x00":                  1,
+		"ends with a space ":    4,
+		"--- PASS: TestParse\n": 6,
+		"mixed 日本 and English":  5,
+	}
+	for text, want := range tests {
+		got, err := CountTokens(text, "")
+		if err != nil || got != want {
+			t.Errorf("CountTokens(%q) = %d, %v, want %d", text, got, err, want)
+		}
+	}
+}
+
+// calibrationSample is a text from a fixture session with the output
+// tokens its result reported.
+type calibrationSample struct {
+	name   string
+	text   string
+	tokens int
+}
+
+// calibrationSamples reads the fixture sessions in testdata/tokens, each a
+// single text response and its result's Usage.
+func calibrationSamples(t testing.TB) []calibrationSample {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("testdata", "tokens", "*.jsonl"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no calibration fixtures: %v", err)
+	}
+	var samples []calibrationSample
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample := calibrationSample{name: strings.TrimSuffix(filepath.Base(file), ".jsonl")}
+		d := NewDecoder(f)
+		for {
+			msg, err := d.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", file, err)
+			}
+			switch m := msg.(type) {
+			case *Text:
+				sample.text += m.Text
+			case *Result:
+				sample.tokens = m.Usage.OutputTokens
+			}
+		}
+		_ = f.Close() // Read only
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func TestCountTokensCalibration(t *testing.T) {
+	const tolerance = 0.15
+
+	var heuristicErr, quarterErr float64
+	for _, s := range calibrationSamples(t) {
+		got, _ := CountTokens(s.text, "")
+		quarter := (utf8.RuneCountInString(s.text) + 3) / 4 // The previous heuristic
+		ratio := float64(got-s.tokens) / float64(s.tokens)
+		if math.Abs(ratio) > tolerance {
+			t.Errorf("%s: CountTokens = %d, recorded %d (%+.0f%%), want within %.0f%%", s.name, got, s.tokens, ratio*100, tolerance*100)
+		}
+		heuristicErr += math.Abs(ratio)
+		quarterErr += math.Abs(float64(quarter-s.tokens) / float64(s.tokens))
+	}
+	if heuristicErr >= quarterErr/2 {
+		t.Errorf("total relative error = %.2f, want under half that of four characters per token (%.2f)", heuristicErr, quarterErr)
+	}
+}
+
+// fixedCounter counts every text as n tokens and records the model.
+type fixedCounter struct {
+	n     int
+	err   error
+	model string
+}
+
+func (c *fixedCounter) CountTokens(text, model string) (int, error) {
+	c.model = model
+	return c.n, c.err
+}
+
+func TestWithTokenCounter(t *testing.T) {
+	counter := &fixedCounter{n: 1000}
+	cfg := newConfig(
+		Model("claude-sonnet-4-5"),
+		WithTokenCounter(counter),
+		PromptBudget(500, nil),
+		CostEstimator(nil),
+	)
+
+	// PromptBudget
+	var perr *PromptCompressionError
+	if _, _, err := cfg.promptBudget.fit(context.Background(), "short", cfg.countTokens()); !errors.As(err, &perr) || perr.Tokens != 1000 {
+		t.Errorf("fit() error = %v, want a *PromptCompressionError for 1000 tokens", err)
+	}
+	if counter.model != "claude-sonnet-4-5" {
+		t.Errorf("counter called with model %q, want the agent's", counter.model)
+	}
+
+	// CostEstimator
+	costs := newCostEstimator(cfg)
+	costs.startRun("short")
+	if costs.inputTokens != 1000 {
+		t.Errorf("estimated input tokens = %d, want 1000", costs.inputTokens)
+	}
+
+	// Describe
+	_, ping := schemaFixtureTools()
+	if d := Describe(WithTokenCounter(counter), CustomTool(ping)); d.ToolTokens != 1000 {
+		t.Errorf("ToolTokens = %d, want 1000", d.ToolTokens)
+	}
+
+	// A per-feature counter takes precedence, and errors fall back to the
+	// built-in heuristic
+	cfg = newConfig(
+		WithTokenCounter(&fixedCounter{err: errors.New("tokenizer unavailable")}),
+		PromptBudget(500, nil, PromptTokens(func(string) int { return 7 })),
+		CostEstimator(nil),
+	)
+	if _, _, err := cfg.promptBudget.fit(context.Background(), "short", cfg.countTokens()); err != nil {
+		t.Errorf("fit() error = %v, want PromptTokens to count 7", err)
+	}
+	costs = newCostEstimator(cfg)
+	costs.startRun("The quick brown fox.")
+	if costs.inputTokens != 5 {
+		t.Errorf("estimated input tokens = %d, want 5 from the heuristic", costs.inputTokens)
+	}
+}
+
+func BenchmarkCountTokens(b *testing.B) {
+	for _, s := range calibrationSamples(b) {
+		text := strings.Repeat(s.text, 100)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = CountTokens(text, "")
+			}
+		})
+	}
+}
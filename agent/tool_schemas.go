@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CompactEnumLimit is the number of values above which CompactToolSchemas
+// collapses an enum.
+const CompactEnumLimit = 10
+
+// Summarized is implemented by tools with a short description to advertise
+// in place of the full one. FuncTool implements it with WithSummary.
+type Summarized interface {
+	// Summary returns the short description; "" advertises Description.
+	Summary() string
+}
+
+// Compile-time check that FuncTool implements Summarized.
+var _ Summarized = (*FuncTool)(nil)
+
+// WithSummary sets a short description that is advertised to Claude in
+// place of the tool's full description, so that rarely used tools cost
+// fewer tokens in every session. It returns the tool for chaining.
+//
+// Example:
+//
+//	report := agent.NewFuncTool("quarterly_report", longDescription, schema, fn).
+//	    WithSummary("Builds the quarterly sales report")
+func (t *FuncTool) WithSummary(short string) *FuncTool {
+	t.summary = short
+	return t
+}
+
+// Summary returns the tool's short description, or "" if none was set.
+func (t *FuncTool) Summary() string {
+	return t.summary
+}
+
+// CompactToolSchemas shrinks the input schemas of custom tools as
+// advertised to Claude: descriptions below the top level of each schema
+// are removed, and an enum of more than CompactEnumLimit values is replaced
+// by a description naming the number of values and the first few. The
+// top-level description is kept, and Execute still receives the input
+// Claude sends. The CLI protocol has no way to fetch a tool's full
+// schema on demand, so compaction trades the detail Claude sees for tokens in every
+// session. Describe reports the estimated cost. The default is false.
+func CompactToolSchemas(enabled bool) Option {
+	return func(c *config) {
+		c.compactToolSchemas = enabled
+	}
+}
+
+// AdvertisedTool is a custom tool as advertised to Claude, after
+// WithSummary and CompactToolSchemas are applied, as reported by Describe.
+// The CLI does not yet accept tool definitions from the SDK, so Describe is
+// where the advertisement and its cost can be reviewed ahead of that.
+type AdvertisedTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema,omitempty"`
+	Tokens      int            `json:"-"` // Estimated tokens of the serialized tool
+}
+
+// advertiseTools returns the custom tools of cfg as advertised to Claude,
+// sorted by name.
+func advertiseTools(cfg *config) []AdvertisedTool {
+	names := make([]string, 0, len(cfg.customTools))
+	for name := range cfg.customTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	count := cfg.countTokens()
+	tools := make([]AdvertisedTool, 0, len(names))
+	for _, name := range names {
+		tool := cfg.customTools[name]
+		ad := AdvertisedTool{Name: name, Description: tool.Description(), InputSchema: tool.InputSchema()}
+		if s, ok := tool.(Summarized); ok && s.Summary() != "" {
+			ad.Description = s.Summary()
+		}
+		if cfg.compactToolSchemas && ad.InputSchema != nil {
+			ad.InputSchema = compactSchema(ad.InputSchema, true)
+		}
+		data, _ := json.Marshal(ad)
+		ad.Tokens = count(string(data))
+		tools = append(tools, ad)
+	}
+	return tools
+}
+
+// Schema keywords whose values are maps of schemas, schemas, or lists of
+// schemas.
+var (
+	schemaMapKeywords   = []string{"properties", "patternProperties", "$defs", "definitions", "dependentSchemas"}
+	schemaValueKeywords = []string{"items", "additionalProperties", "additionalItems", "contains", "not", "if", "then", "else", "propertyNames", "unevaluatedItems", "unevaluatedProperties"}
+	schemaListKeywords  = []string{"allOf", "anyOf", "oneOf", "prefixItems"}
+)
+
+// compactEnumExamples is the number of values named in place of a
+// collapsed enum.
+const compactEnumExamples = 3
+
+// compactSchema returns a copy of schema without descriptions below the
+// top level and with long enums collapsed. Keywords are recognized by
+// position, so a property named "description" is kept.
+func compactSchema(schema map[string]any, top bool) map[string]any {
+	out := make(map[string]any, len(schema))
+	for k, v := range schema {
+		switch {
+		case k == "description" && !top:
+			continue
+		case hasString(schemaMapKeywords, k):
+			if m, ok := v.(map[string]any); ok {
+				sub := make(map[string]any, len(m))
+				for name, s := range m {
+					sub[name] = compactSubschema(s)
+				}
+				v = sub
+			}
+		case hasString(schemaValueKeywords, k):
+			v = compactSubschema(v)
+		case hasString(schemaListKeywords, k):
+			if list, ok := anySlice(v); ok {
+				sub := make([]any, len(list))
+				for i, s := range list {
+					sub[i] = compactSubschema(s)
+				}
+				v = sub
+			}
+		}
+		out[k] = v
+	}
+	if enum, ok := anySlice(out["enum"]); ok && len(enum) > CompactEnumLimit {
+		delete(out, "enum")
+		examples := make([]string, 0, compactEnumExamples)
+		for _, e := range enum[:compactEnumExamples] {
+			data, _ := json.Marshal(e)
+			examples = append(examples, string(data))
+		}
+		note := fmt.Sprintf("One of %d values, such as %s", len(enum), strings.Join(examples, ", "))
+		if d, ok := out["description"].(string); ok && d != "" {
+			note = d + ". " + note
+		}
+		out["description"] = note
+	}
+	return out
+}
+
+// compactSubschema compacts v if it is a schema object.
+func compactSubschema(v any) any {
+	if m, ok := v.(map[string]any); ok {
+		return compactSchema(m, false)
+	}
+	return v
+}
+
+// anySlice returns v as a []any if it is a slice of any element type, as
+// schemas written in Go often use []string.
+func anySlice(v any) ([]any, bool) {
+	if list, ok := v.([]any); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]any, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// hasString reports whether list holds s.
+func hasString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
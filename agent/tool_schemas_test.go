@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schemaFixtureTools returns a verbose tool and a small one.
+func schemaFixtureTools() (report, ping *FuncTool) {
+	countries := make([]string, 30)
+	for i := range countries {
+		countries[i] = fmt.Sprintf("C%02d", i)
+	}
+	report = NewFuncTool("quarterly_report",
+		"Builds the quarterly sales report for a region, broken down by product line and sales channel, with forecasts.",
+		map[string]any{
+			"type":        "object",
+			"description": "Report parameters",
+			"properties": map[string]any{
+				"country": map[string]any{
+					"type":        "string",
+					"description": "ISO country code of the region whose sales are reported",
+					"enum":        countries,
+				},
+				"quarter": map[string]any{
+					"type":        "string",
+					"description": "Quarter to report, such as 2024-Q3",
+					"enum":        []any{"Q1", "Q2", "Q3", "Q4"},
+				},
+				"description": map[string]any{
+					"type":        "string",
+					"description": "Free text placed at the top of the report",
+				},
+				"lines": map[string]any{
+					"type":        "array",
+					"description": "Product lines to include; all lines if empty",
+					"items": map[string]any{
+						"type":        "object",
+						"description": "A product line and its weighting",
+						"properties": map[string]any{
+							"name":   map[string]any{"type": "string", "description": "Product line name"},
+							"weight": map[string]any{"type": "number", "description": "Weight between 0 and 1"},
+						},
+					},
+				},
+			},
+			"required": []string{"country", "quarter"},
+		}, nil)
+	ping = NewFuncTool("ping", "Checks that the service is up", nil, nil)
+	return report, ping
+}
+
+func TestCompactToolSchemasSize(t *testing.T) {
+	report, ping := schemaFixtureTools()
+	full := Describe(CustomTool(report, ping))
+	compact := Describe(CustomTool(report, ping), CompactToolSchemas(true))
+
+	size := func(tools []AdvertisedTool) int {
+		data, err := json.Marshal(tools)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(data)
+	}
+	fullSize, compactSize := size(full.AdvertisedTools), size(compact.AdvertisedTools)
+	if fullSize != 1121 || compactSize != 636 {
+		t.Errorf("advertisement = %d bytes, compacted %d, want 1121 and 636", fullSize, compactSize)
+	}
+	if full.ToolTokens != 331 || compact.ToolTokens != 178 {
+		t.Errorf("ToolTokens = %d, compacted %d, want 331 and 178", full.ToolTokens, compact.ToolTokens)
+	}
+
+	schema := compact.AdvertisedTools[1].InputSchema
+	want := map[string]any{
+		"type":        "object",
+		"description": "Report parameters", // Top level kept
+		"properties": map[string]any{
+			"country": map[string]any{
+				"type":        "string",
+				"description": `One of 30 values, such as "C00", "C01", "C02"`,
+			},
+			"quarter":     map[string]any{"type": "string", "enum": []any{"Q1", "Q2", "Q3", "Q4"}},
+			"description": map[string]any{"type": "string"}, // A property, not the keyword
+			"lines": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"name":   map[string]any{"type": "string"},
+						"weight": map[string]any{"type": "number"},
+					},
+				},
+			},
+		},
+		"required": []string{"country", "quarter"},
+	}
+	if !reflect.DeepEqual(schema, want) {
+		t.Errorf("compacted schema =\n%v\nwant\n%v", schema, want)
+	}
+
+	// The tool's own schema is unchanged
+	if !strings.Contains(fmt.Sprint(report.InputSchema()), "ISO country code") {
+		t.Error("compaction modified the tool's schema")
+	}
+}
+
+func TestAdvertisedToolsLossless(t *testing.T) {
+	report, ping := schemaFixtureTools()
+	report.WithSummary("Builds the quarterly sales report")
+
+	d := Describe(CustomTool(report, ping))
+	if len(d.AdvertisedTools) != 2 {
+		t.Fatalf("AdvertisedTools = %d tools, want 2", len(d.AdvertisedTools))
+	}
+	ad := d.AdvertisedTools[0]
+	if ad.Name != "ping" || ad.Description != ping.Description() || ad.InputSchema != nil {
+		t.Errorf("ping = %+v, want it advertised as defined", ad)
+	}
+	ad = d.AdvertisedTools[1]
+	if ad.Description != "Builds the quarterly sales report" {
+		t.Errorf("Description = %q, want the summary", ad.Description)
+	}
+	if !reflect.DeepEqual(ad.InputSchema, report.InputSchema()) {
+		t.Errorf("InputSchema changed without CompactToolSchemas")
+	}
+	if ad.Tokens == 0 || d.ToolTokens != d.AdvertisedTools[0].Tokens+ad.Tokens {
+		t.Errorf("ToolTokens = %d, want the sum of %d and %d", d.ToolTokens, d.AdvertisedTools[0].Tokens, ad.Tokens)
+	}
+	if !strings.Contains(d.String(), "quarterly_report: ~") {
+		t.Errorf("String() =\n%s\nwant the tool's token estimate", d)
+	}
+}
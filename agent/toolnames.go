@@ -0,0 +1,94 @@
+package agent
+
+import "strings"
+
+// Names of the tools built into the Claude Code CLI. They are plain
+// strings and can be passed anywhere a tool name is accepted.
+const (
+	ToolBash         = "Bash"
+	ToolBashOutput   = "BashOutput"
+	ToolKillShell    = "KillShell"
+	ToolRead         = "Read"
+	ToolWrite        = "Write"
+	ToolEdit         = "Edit"
+	ToolMultiEdit    = "MultiEdit"
+	ToolNotebookEdit = "NotebookEdit"
+	ToolGlob         = "Glob"
+	ToolGrep         = "Grep"
+	ToolTask         = "Task"
+	ToolTodoWrite    = "TodoWrite"
+	ToolWebFetch     = "WebFetch"
+	ToolWebSearch    = "WebSearch"
+	ToolSkill        = "Skill"
+	ToolSlashCommand = "SlashCommand"
+	ToolExitPlanMode = "ExitPlanMode"
+)
+
+// builtinTools lists the built-in tool names.
+var builtinTools = []string{
+	ToolBash, ToolBashOutput, ToolKillShell, ToolRead, ToolWrite, ToolEdit,
+	ToolMultiEdit, ToolNotebookEdit, ToolGlob, ToolGrep, ToolTask, ToolTodoWrite,
+	ToolWebFetch, ToolWebSearch, ToolSkill, ToolSlashCommand, ToolExitPlanMode,
+}
+
+// mcpToolPrefix starts the name of every tool provided by an MCP server.
+const mcpToolPrefix = "mcp__"
+
+// mcpToolSeparator separates the server and tool in an MCP tool name.
+const mcpToolSeparator = "__"
+
+// ToolKind classifies a tool name.
+type ToolKind string
+
+const (
+	// ToolKindBuiltin is a tool built into the CLI, such as ToolBash.
+	ToolKindBuiltin ToolKind = "builtin"
+	// ToolKindMCP is a tool provided by an MCP server.
+	ToolKindMCP ToolKind = "mcp"
+	// ToolKindOther is any other name, such as a CustomTool.
+	ToolKindOther ToolKind = "other"
+)
+
+// MCPTool returns the name Claude uses for tool on the given MCP server,
+// e.g. MCPTool("github", "create_issue") is "mcp__github__create_issue".
+// Use "*" as the tool to match every tool on the server in AllowedTools.
+func MCPTool(server, tool string) string {
+	return mcpToolPrefix + server + mcpToolSeparator + tool
+}
+
+// ParseToolName classifies a tool name. For MCP tools it also returns the
+// server and the tool's name on that server; for other tools, server is
+// empty and tool is name. A name with the mcp__ prefix but no server or
+// tool part is ToolKindOther.
+//
+// Example:
+//
+//	agent.PreToolUse(func(tc *agent.ToolCall) agent.HookResult {
+//	    if kind, server, _ := agent.ParseToolName(tc.Name); kind == agent.ToolKindMCP && server == "prod-db" {
+//	        return agent.HookResult{Decision: agent.Deny, Reason: "production database is read-only"}
+//	    }
+//	    return agent.HookResult{Decision: agent.Continue}
+//	})
+func ParseToolName(name string) (kind ToolKind, server, tool string) {
+	if rest, ok := strings.CutPrefix(name, mcpToolPrefix); ok {
+		server, tool, found := strings.Cut(rest, mcpToolSeparator)
+		if found && server != "" && tool != "" {
+			return ToolKindMCP, server, tool
+		}
+		return ToolKindOther, "", name
+	}
+	for _, t := range builtinTools {
+		if name == t {
+			return ToolKindBuiltin, "", name
+		}
+	}
+	return ToolKindOther, "", name
+}
+
+// DeclareTools marks tool names as intentional so New does not warn about
+// them, for example tools added by a newer CLI than this SDK knows.
+func DeclareTools(names ...string) Option {
+	return func(c *config) {
+		c.declaredTools = append(c.declaredTools, names...)
+	}
+}
@@ -0,0 +1,44 @@
+package agent
+
+import "testing"
+
+func TestMCPTool(t *testing.T) {
+	if got := MCPTool("github", "create_issue"); got != "mcp__github__create_issue" {
+		t.Errorf("MCPTool() = %q, want mcp__github__create_issue", got)
+	}
+	if got := MCPTool("github", "*"); got != "mcp__github__*" {
+		t.Errorf("MCPTool() = %q, want mcp__github__*", got)
+	}
+}
+
+func TestParseToolName(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantKind   ToolKind
+		wantServer string
+		wantTool   string
+	}{
+		{ToolBash, ToolKindBuiltin, "", "Bash"},
+		{ToolWebFetch, ToolKindBuiltin, "", "WebFetch"},
+		{"mcp__github__create_issue", ToolKindMCP, "github", "create_issue"},
+		{MCPTool("prod_db", "run__query"), ToolKindMCP, "prod_db", "run__query"},
+		{"mcp__github", ToolKindOther, "", "mcp__github"},
+		{"mcp____tool", ToolKindOther, "", "mcp____tool"},
+		{"bash", ToolKindOther, "", "bash"},
+		{"calculator", ToolKindOther, "", "calculator"},
+	}
+	for _, tt := range tests {
+		kind, server, tool := ParseToolName(tt.name)
+		if kind != tt.wantKind || server != tt.wantServer || tool != tt.wantTool {
+			t.Errorf("ParseToolName(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tt.name, kind, server, tool, tt.wantKind, tt.wantServer, tt.wantTool)
+		}
+	}
+}
+
+func TestParseToolNameRoundTrip(t *testing.T) {
+	kind, server, tool := ParseToolName(MCPTool("files", "read"))
+	if kind != ToolKindMCP || MCPTool(server, tool) != "mcp__files__read" {
+		t.Errorf("ParseToolName(MCPTool()) = (%q, %q, %q), want the original parts", kind, server, tool)
+	}
+}
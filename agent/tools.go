@@ -24,8 +24,11 @@ type Tool interface {
 	Execute(ctx context.Context, input map[string]any) (any, error)
 }
 
-// Compile-time check that FuncTool implements Tool.
-var _ Tool = (*FuncTool)(nil)
+// Compile-time checks that FuncTool implements Tool and ConcurrencyLimited.
+var (
+	_ Tool               = (*FuncTool)(nil)
+	_ ConcurrencyLimited = (*FuncTool)(nil)
+)
 
 // FuncTool is a function-based implementation of Tool.
 // Use NewFuncTool to create instances.
@@ -34,6 +37,8 @@ type FuncTool struct {
 	description string
 	schema      map[string]any
 	fn          func(context.Context, map[string]any) (any, error)
+	maxConc     int
+	summary     string // Advertised in place of description, if set
 }
 
 // NewFuncTool creates a new Tool from a function.
@@ -87,6 +92,23 @@ func (t *FuncTool) InputSchema() map[string]any {
 	return t.schema
 }
 
+// WithMaxConcurrency limits how many invocations of this tool may execute
+// at the same time. Calls beyond the limit wait in message order.
+// A value of 0 or less removes the limit. It returns the tool for chaining.
+//
+// Example:
+//
+//	dbTool := agent.NewFuncTool("query", "Runs a query", schema, fn).WithMaxConcurrency(1)
+func (t *FuncTool) WithMaxConcurrency(n int) *FuncTool {
+	t.maxConc = n
+	return t
+}
+
+// MaxConcurrency returns the tool's concurrency limit (0 = unlimited).
+func (t *FuncTool) MaxConcurrency() int {
+	return t.maxConc
+}
+
 // Execute runs the tool function.
 func (t *FuncTool) Execute(ctx context.Context, input map[string]any) (any, error) {
 	if t.fn == nil {
@@ -0,0 +1,154 @@
+package agent
+
+import "sync"
+
+// ConcurrencyLimited is implemented by tools that cap how many of their
+// invocations may execute at the same time. A value of 0 or less means
+// the tool imposes no limit of its own.
+type ConcurrencyLimited interface {
+	MaxConcurrency() int
+}
+
+// semaphore is a counting semaphore that grants slots in reservation order.
+// Ordering matters because parallel tool calls within a single assistant
+// message must execute in message order when a limit forces serialization.
+type semaphore struct {
+	mu      sync.Mutex
+	size    int
+	held    int
+	waiters []chan struct{}
+}
+
+// newSemaphore creates a semaphore with the given number of slots.
+// It returns nil for size <= 0, which acts as an unlimited semaphore.
+func newSemaphore(size int) *semaphore {
+	if size <= 0 {
+		return nil
+	}
+	return &semaphore{size: size}
+}
+
+// reserve queues for a slot and returns a channel that is closed once the
+// slot is granted. Reservations are granted strictly in FIFO order.
+func (s *semaphore) reserve() <-chan struct{} {
+	ch := make(chan struct{})
+	if s == nil {
+		close(ch)
+		return ch
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.held < s.size && len(s.waiters) == 0 {
+		s.held++
+		close(ch)
+		return ch
+	}
+	s.waiters = append(s.waiters, ch)
+	return ch
+}
+
+// release returns a slot and hands it to the oldest waiter, if any.
+func (s *semaphore) release() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.waiters) > 0 {
+		next := s.waiters[0]
+		s.waiters = s.waiters[1:]
+		close(next)
+		return
+	}
+	s.held--
+}
+
+// cancel withdraws a reservation that was never used. If the slot was
+// already granted it is released; otherwise the waiter is removed.
+func (s *semaphore) cancel(ch <-chan struct{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	for i, w := range s.waiters {
+		if w == ch {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	// Not queued, so the slot was granted
+	s.release()
+}
+
+// toolLimiter bounds concurrent custom tool executions, both globally and
+// per tool name.
+type toolLimiter struct {
+	global  *semaphore
+	perTool map[string]*semaphore
+}
+
+// toolSlot is a pending reservation on a toolLimiter.
+type toolSlot struct {
+	limiter *toolLimiter
+	name    string
+	global  <-chan struct{}
+	tool    <-chan struct{}
+}
+
+// newToolLimiter creates a limiter from the global limit and the
+// per-tool limits advertised by custom tools.
+func newToolLimiter(maxConcurrent int, tools map[string]Tool) *toolLimiter {
+	l := &toolLimiter{
+		global:  newSemaphore(maxConcurrent),
+		perTool: make(map[string]*semaphore),
+	}
+	for name, tool := range tools {
+		if limited, ok := tool.(ConcurrencyLimited); ok {
+			if sem := newSemaphore(limited.MaxConcurrency()); sem != nil {
+				l.perTool[name] = sem
+			}
+		}
+	}
+	return l
+}
+
+// reserve queues for both the global slot and the tool's own slot.
+// It must be called in message order; waiting happens in toolSlot.wait.
+func (l *toolLimiter) reserve(name string) *toolSlot {
+	return &toolSlot{
+		limiter: l,
+		name:    name,
+		global:  l.global.reserve(),
+		tool:    l.perTool[name].reserve(),
+	}
+}
+
+// wait blocks until both slots are granted or done is closed.
+// It returns false if done was closed first, in which case the
+// reservations have been withdrawn.
+func (s *toolSlot) wait(done <-chan struct{}) bool {
+	for _, ch := range []<-chan struct{}{s.global, s.tool} {
+		select {
+		case <-ch:
+		case <-done:
+			s.limiter.global.cancel(s.global)
+			s.limiter.perTool[s.name].cancel(s.tool)
+			return false
+		}
+	}
+	return true
+}
+
+// release returns both slots.
+func (s *toolSlot) release() {
+	s.limiter.perTool[s.name].release()
+	s.limiter.global.release()
+}
@@ -0,0 +1,305 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreNilIsUnlimited(t *testing.T) {
+	sem := newSemaphore(0)
+	if sem != nil {
+		t.Fatal("newSemaphore(0) should return nil")
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-sem.reserve():
+		default:
+			t.Fatal("nil semaphore should grant immediately")
+		}
+	}
+	sem.release() // must not panic
+}
+
+func TestSemaphoreGrantsInOrder(t *testing.T) {
+	sem := newSemaphore(1)
+
+	first := sem.reserve()
+	second := sem.reserve()
+	third := sem.reserve()
+
+	select {
+	case <-first:
+	default:
+		t.Fatal("first reservation should be granted immediately")
+	}
+	select {
+	case <-second:
+		t.Fatal("second reservation should wait")
+	default:
+	}
+
+	sem.release()
+	select {
+	case <-second:
+	default:
+		t.Fatal("second reservation should be granted after release")
+	}
+	select {
+	case <-third:
+		t.Fatal("third reservation should still wait")
+	default:
+	}
+
+	sem.release()
+	<-third
+	sem.release()
+
+	if sem.held != 0 {
+		t.Errorf("held = %d, want 0", sem.held)
+	}
+}
+
+func TestSemaphoreCancel(t *testing.T) {
+	sem := newSemaphore(1)
+
+	first := sem.reserve()
+	second := sem.reserve()
+	third := sem.reserve()
+	<-first
+
+	// Withdraw a queued reservation
+	sem.cancel(second)
+	if len(sem.waiters) != 1 {
+		t.Fatalf("waiters = %d, want 1", len(sem.waiters))
+	}
+
+	// Withdraw a granted reservation, which hands the slot on
+	sem.cancel(first)
+	select {
+	case <-third:
+	default:
+		t.Fatal("third reservation should be granted after cancel")
+	}
+}
+
+func TestFuncToolWithMaxConcurrency(t *testing.T) {
+	tool := NewFuncTool("db", "Queries the database", nil, nil)
+	if tool.MaxConcurrency() != 0 {
+		t.Errorf("default MaxConcurrency() = %d, want 0", tool.MaxConcurrency())
+	}
+
+	if got := tool.WithMaxConcurrency(2); got != tool {
+		t.Error("WithMaxConcurrency should return the same tool")
+	}
+	if tool.MaxConcurrency() != 2 {
+		t.Errorf("MaxConcurrency() = %d, want 2", tool.MaxConcurrency())
+	}
+}
+
+func TestMaxConcurrentToolsOption(t *testing.T) {
+	c := newConfig(MaxConcurrentTools(3))
+	if c.maxConcurrentTools != 3 {
+		t.Errorf("maxConcurrentTools = %d, want 3", c.maxConcurrentTools)
+	}
+}
+
+func TestNewToolLimiterPerTool(t *testing.T) {
+	limited := NewFuncTool("limited", "", nil, nil).WithMaxConcurrency(1)
+	open := NewFuncTool("open", "", nil, nil)
+
+	l := newToolLimiter(0, map[string]Tool{
+		"limited": limited,
+		"open":    open,
+	})
+
+	if l.global != nil {
+		t.Error("global semaphore should be nil when unlimited")
+	}
+	if l.perTool["limited"] == nil {
+		t.Error("limited tool should have a semaphore")
+	}
+	if _, ok := l.perTool["open"]; ok {
+		t.Error("unlimited tool should not have a semaphore")
+	}
+}
+
+func TestMaxConcurrentToolsSerializesCalls(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+
+	// Three parallel tool calls in one assistant message; the CLI waits for
+	// all three control responses before reporting the tool results.
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"limit-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_use","id":"tu-1","name":"slow","input":{"n":1}},{"type":"tool_use","id":"tu-2","name":"slow","input":{"n":2}},{"type":"tool_use","id":"tu-3","name":"slow","input":{"n":3}}]}}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"slow","tool_input":{"n":1}}'
+printf '%s\n' '{"type":"control","request_id":"req-2","tool_use_id":"tu-2","tool_name":"slow","tool_input":{"n":2}}'
+printf '%s\n' '{"type":"control","request_id":"req-3","tool_use_id":"tu-3","tool_name":"slow","tool_input":{"n":3}}'
+read r1
+read r2
+read r3
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"1","duration_ms":999}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-2","content":"2","duration_ms":999}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-3","content":"3","duration_ms":999}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	const toolDelay = 50 * time.Millisecond
+
+	var mu sync.Mutex
+	var order []float64
+	running, maxRunning := 0, 0
+
+	slow := NewFuncTool("slow", "Sleeps", nil, func(ctx context.Context, input map[string]any) (any, error) {
+		mu.Lock()
+		order = append(order, input["n"].(float64))
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(toolDelay)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "ok", nil
+	})
+
+	results := make(map[string]*ToolResultContext)
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(fakeClaude),
+		CustomTool(slow),
+		MaxConcurrentTools(1),
+		PostToolUse(func(tc *ToolCall, tr *ToolResultContext) HookResult {
+			results[tr.ToolUseID] = tr
+			return HookResult{Decision: Continue}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "run the slow tool three times"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if maxRunning != 1 {
+		t.Errorf("max concurrent executions = %d, want 1", maxRunning)
+	}
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 3 {
+		t.Errorf("execution order = %v, want [1 2 3]", order)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("PostToolUse called %d times, want 3", len(results))
+	}
+	for _, id := range []string{"tu-1", "tu-2", "tu-3"} {
+		tr := results[id]
+		if tr.Duration < toolDelay || tr.Duration >= 500*time.Millisecond {
+			t.Errorf("%s Duration = %v, want execution time near %v", id, tr.Duration, toolDelay)
+		}
+	}
+	if results["tu-1"].QueueDuration >= toolDelay {
+		t.Errorf("tu-1 QueueDuration = %v, want < %v", results["tu-1"].QueueDuration, toolDelay)
+	}
+	if results["tu-3"].QueueDuration < 2*toolDelay {
+		t.Errorf("tu-3 QueueDuration = %v, want >= %v", results["tu-3"].QueueDuration, 2*toolDelay)
+	}
+}
+
+func TestCustomToolUnsendableResult(t *testing.T) {
+	tmpDir := t.TempDir()
+	fakeClaude := filepath.Join(tmpDir, "claude")
+	responses := filepath.Join(tmpDir, "responses.jsonl")
+	script := `#!/bin/sh
+read line
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"unsendable-test"}'
+printf '%s\n' '{"type":"control","request_id":"req-1","tool_use_id":"tu-1","tool_name":"broken","tool_input":{}}'
+read r1
+printf '%s\n' "$r1" > ` + responses + `
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	broken := NewFuncTool("broken", "Returns a value JSON cannot encode", nil, func(ctx context.Context, input map[string]any) (any, error) {
+		return func() {}, nil
+	})
+	var mu sync.Mutex
+	events := map[string]int{}
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude), CustomTool(broken), Audit(func(e AuditEvent) {
+		mu.Lock()
+		events[e.Type]++
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	if _, err := a.Run(ctx, "call the broken tool"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	mu.Lock()
+	if events["tool.custom.error"] != 1 || events["tool.custom.complete"] != 0 {
+		t.Errorf("emitted %d tool.custom.error and %d tool.custom.complete events, want one error only",
+			events["tool.custom.error"], events["tool.custom.complete"])
+	}
+	mu.Unlock()
+	var resp customToolResponse
+	if err := json.Unmarshal(mustReadFile(t, responses), &resp); err != nil {
+		t.Fatalf("CLI read %s: %v", mustReadFile(t, responses), err)
+	}
+	msg, _ := resp.Result.(string)
+	if resp.RequestID != "req-1" || !resp.IsError || !strings.Contains(msg, "json: unsupported type") {
+		t.Errorf("CLI read %+v, want an error result naming the encoding failure", resp)
+	}
+}
+
+// failingTransport is a cliTransport whose writes fail.
+type failingTransport struct {
+	cliTransport
+	writes int
+}
+
+func (f *failingTransport) write([]byte) error {
+	f.writes++
+	return errors.New("broken pipe")
+}
+
+func TestCustomToolResultWriteFailure(t *testing.T) {
+	var events []string
+	a := &Agent{
+		auditor:     newAuditor([]AuditHandler{func(e AuditEvent) { events = append(events, e.Type) }}),
+		toolTimings: make(map[string]toolTiming),
+	}
+	failing := NewFuncTool("failing", "Always fails", nil, func(ctx context.Context, input map[string]any) (any, error) {
+		return nil, errors.New("tool failed")
+	})
+	proc := &failingTransport{}
+	req := &ControlRequest{RequestID: "req-1", Tool: &ToolCall{Name: "failing"}}
+
+	if err := a.executeCustomTool(context.Background(), proc, "s", req, failing, nil, 0); err == nil {
+		t.Fatal("executeCustomTool() error = nil, want the write failure")
+	}
+	if proc.writes != 1 {
+		t.Errorf("wrote %d times, want 1; a failed transport is not written again", proc.writes)
+	}
+	if want := []string{"tool.custom.start", "tool.custom.error"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
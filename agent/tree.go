@@ -0,0 +1,144 @@
+package agent
+
+import "sort"
+
+// ConversationTree holds messages organized by their UUID and ParentUUID
+// linkage, as returned by BuildTree.
+type ConversationTree struct {
+	// Root is a synthetic node with no messages. Its children are the
+	// nodes without a parent and the orphans.
+	Root *TreeNode
+	// Unlinked holds the messages without a UUID, such as those the SDK
+	// creates itself, in their original order.
+	Unlinked []Message
+
+	nodes map[string]*TreeNode
+}
+
+// TreeNode is a CLI line in a ConversationTree: the messages parsed from
+// it and the lines that follow from it.
+type TreeNode struct {
+	UUID       string
+	ParentUUID string
+	// Messages holds the messages with the node's UUID, such as the blocks
+	// of one assistant message, by Sequence.
+	Messages []Message
+	Parent   *TreeNode
+	// Children are ordered by the Sequence of their first message. A
+	// parent with several children branched, as when a turn was retried
+	// or subagents ran side by side.
+	Children []*TreeNode
+	// Orphan is set when the node's ParentUUID names no message in the
+	// tree, and the node was attached to the root instead.
+	Orphan bool
+}
+
+// Node returns the node with the given UUID, or nil if there is none.
+func (t *ConversationTree) Node(uuid string) *TreeNode {
+	return t.nodes[uuid]
+}
+
+// BuildTree organizes msgs, such as the messages of a Stream or those read
+// back with a Decoder, into a tree using their UUID and ParentUUID. A
+// message whose parent is not in msgs is attached to the root as an
+// orphan, so a partial history still builds. It returns a
+// *TreeCycleError if the linkage loops.
+//
+// Example:
+//
+//	tree, err := agent.BuildTree(msgs)
+//	if err != nil {
+//	    return err
+//	}
+//	var walk func(n *agent.TreeNode, depth int)
+//	walk = func(n *agent.TreeNode, depth int) {
+//	    for _, c := range n.Children {
+//	        fmt.Printf("%*s%s (%d messages)\n", depth*2, "", c.UUID, len(c.Messages))
+//	        walk(c, depth+1)
+//	    }
+//	}
+//	walk(tree.Root, 0)
+func BuildTree(msgs []Message) (*ConversationTree, error) {
+	t := &ConversationTree{Root: &TreeNode{}, nodes: make(map[string]*TreeNode)}
+	var order []*TreeNode // Nodes in the order they first appear
+	for _, msg := range msgs {
+		meta := messageMeta(msg)
+		if meta == nil || meta.UUID == "" {
+			t.Unlinked = append(t.Unlinked, msg)
+			continue
+		}
+		n := t.nodes[meta.UUID]
+		if n == nil {
+			n = &TreeNode{UUID: meta.UUID, ParentUUID: meta.ParentUUID}
+			t.nodes[meta.UUID] = n
+			order = append(order, n)
+		}
+		n.Messages = append(n.Messages, msg)
+	}
+
+	for _, n := range order {
+		sort.SliceStable(n.Messages, func(i, j int) bool {
+			return messageMeta(n.Messages[i]).Sequence < messageMeta(n.Messages[j]).Sequence
+		})
+		parent := t.Root
+		if n.ParentUUID != "" {
+			if p := t.nodes[n.ParentUUID]; p != nil {
+				parent = p
+			} else {
+				n.Orphan = true
+			}
+		}
+		n.Parent = parent
+		parent.Children = append(parent.Children, n)
+	}
+
+	// Every node is reachable from the root unless the linkage loops
+	reached := 0
+	var visit func(n *TreeNode)
+	visit = func(n *TreeNode) {
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			return firstSequence(n.Children[i]) < firstSequence(n.Children[j])
+		})
+		for _, c := range n.Children {
+			reached++
+			visit(c)
+		}
+	}
+	visit(t.Root)
+	if reached < len(order) {
+		return nil, &TreeCycleError{UUID: cycleMember(t, order)}
+	}
+	return t, nil
+}
+
+// firstSequence returns the Sequence of a node's first message.
+func firstSequence(n *TreeNode) int {
+	return messageMeta(n.Messages[0]).Sequence
+}
+
+// cycleMember returns the UUID of the first node, in order, that cannot be
+// reached from the root.
+func cycleMember(t *ConversationTree, order []*TreeNode) string {
+	for _, n := range order {
+		seen := make(map[*TreeNode]bool)
+		for p := n; p != t.Root; p = p.Parent {
+			if seen[p] {
+				return n.UUID
+			}
+			seen[p] = true
+		}
+	}
+	return ""
+}
+
+// withLinkage adds the UUID and ParentUUID of meta to the data of a
+// message's audit event, when the CLI sent them.
+func withLinkage(data map[string]any, meta *MessageMeta) map[string]any {
+	if meta.UUID != "" {
+		data["uuid"] = meta.UUID
+	}
+	if meta.ParentUUID != "" {
+		data["parent_uuid"] = meta.ParentUUID
+	}
+	return data
+}
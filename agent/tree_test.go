@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// treeShape renders the nodes under n as "uuid(children...)", marking
+// orphans with a "?".
+func treeShape(n *TreeNode) string {
+	var parts []string
+	for _, c := range n.Children {
+		s := c.UUID
+		if c.Orphan {
+			s += "?"
+		}
+		if len(c.Children) > 0 {
+			s += "(" + treeShape(c) + ")"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func decodeFixture(t *testing.T, name string) []Message {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	var msgs []Message
+	d := NewDecoder(f)
+	for {
+		msg, err := d.Next()
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestBuildTree(t *testing.T) {
+	msgs := decodeFixture(t, "conversation_tree.jsonl")
+	tree, err := BuildTree(msgs)
+	if err != nil {
+		t.Fatalf("BuildTree() error = %v", err)
+	}
+	if got, want := treeShape(tree.Root), "u1(s1(s2 s3) u2(r1)) o1?"; got != want {
+		t.Errorf("tree = %s, want %s", got, want)
+	}
+
+	// Both blocks of the first assistant line share its node
+	u1 := tree.Node("u1")
+	if len(u1.Messages) != 2 || u1.Parent != tree.Root || u1.Orphan {
+		t.Fatalf("u1 = %+v, want a root with two messages", u1)
+	}
+	if _, ok := u1.Messages[1].(*ToolUse); !ok {
+		t.Errorf("u1 messages = %T, %T, want the text, then the Task call", u1.Messages[0], u1.Messages[1])
+	}
+
+	// The parentUuid spelling of transcripts links the same way
+	if s2 := tree.Node("s2"); s2 == nil || s2.ParentUUID != "s1" || s2.Parent != tree.Node("s1") {
+		t.Errorf("s2 = %+v, want a child of s1", s2)
+	}
+	if o1 := tree.Node("o1"); o1.ParentUUID != "gone" || o1.Parent != tree.Root {
+		t.Errorf("o1 = %+v, want an orphan on the root", o1)
+	}
+	if len(tree.Unlinked) != 1 {
+		t.Errorf("Unlinked = %v, want the init message", tree.Unlinked)
+	}
+	if tree.Node("gone") != nil {
+		t.Error("Node() returned a node for a missing parent")
+	}
+
+	// Sibling order follows Sequence, not input order
+	reversed := make([]Message, len(msgs))
+	for i, msg := range msgs {
+		reversed[len(msgs)-1-i] = msg
+	}
+	again, err := BuildTree(reversed)
+	if err != nil {
+		t.Fatalf("BuildTree() of reversed messages error = %v", err)
+	}
+	if got, want := treeShape(again.Root), treeShape(tree.Root); got != want {
+		t.Errorf("reversed tree = %s, want %s", got, want)
+	}
+}
+
+func TestBuildTreeCycle(t *testing.T) {
+	msgs := []Message{
+		&Text{MessageMeta: MessageMeta{UUID: "root", Sequence: 1}},
+		&Text{MessageMeta: MessageMeta{UUID: "a", ParentUUID: "b", Sequence: 2}},
+		&Text{MessageMeta: MessageMeta{UUID: "b", ParentUUID: "a", Sequence: 3}},
+	}
+	_, err := BuildTree(msgs)
+	var cerr *TreeCycleError
+	if !errors.As(err, &cerr) || cerr.UUID != "a" {
+		t.Errorf("BuildTree() error = %v, want a *TreeCycleError through a", err)
+	}
+
+	tree, err := BuildTree(nil)
+	if err != nil || len(tree.Root.Children) != 0 {
+		t.Errorf("BuildTree(nil) = %+v, %v, want an empty tree", tree, err)
+	}
+}
+
+func TestTreeLinkageAudit(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "conversation_tree.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var script strings.Builder
+	script.WriteString(`{"op":"in"}` + "\n")
+	enc := json.NewEncoder(&script)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if err := enc.Encode(replayEntry{Op: replayOut, Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "script.jsonl")
+	mustWriteFile(t, path, []byte(script.String()), 0600)
+
+	var mu sync.Mutex
+	linkage := map[string]string{}
+	messages, err := replayRun(t, Replay(path), Audit(func(e AuditEvent) {
+		if d, ok := e.Data.(map[string]any); ok && strings.HasPrefix(e.Type, "message.") {
+			if uuid, ok := d["uuid"].(string); ok {
+				mu.Lock()
+				parent, _ := d["parent_uuid"].(string)
+				linkage[uuid] = parent
+				mu.Unlock()
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Live messages build the same tree as decoded ones
+	tree, err := BuildTree(messages)
+	if err != nil {
+		t.Fatalf("BuildTree() error = %v", err)
+	}
+	if got, want := treeShape(tree.Root), "u1(s1(s2 s3) u2(r1)) o1?"; got != want {
+		t.Errorf("tree = %s, want %s", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[string]string{"u1": "", "s1": "u1", "s2": "s1", "s3": "s1", "u2": "u1", "o1": "gone", "r1": "u2"}
+	if len(linkage) != len(want) {
+		t.Fatalf("audited linkage = %v, want %v", linkage, want)
+	}
+	for uuid, parent := range want {
+		if got, ok := linkage[uuid]; !ok || got != parent {
+			t.Errorf("audited parent of %s = %q, want %q", uuid, got, parent)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package agent
+
+import "time"
+
+// TurnToolCall pairs a tool call with its result.
+type TurnToolCall struct {
+	Use    *ToolUse    // Nil for a result whose call was in an earlier turn
+	Result *ToolResult // Nil when the result had not arrived by the end of the turn
+}
+
+// TurnRecord collects the messages of one turn, in the order they arrived.
+type TurnRecord struct {
+	Turn      int
+	Texts     []string
+	Thinkings []string
+	ToolCalls []TurnToolCall // In call order
+	Errors    []error        // From Error messages
+
+	// Result is the message that ended the turn. It is nil when the stream
+	// closed first, as on cancellation, or the next turn began without one.
+	Result *Result
+
+	// Duration and CostUSD are the Result's DurationTotal and CostUSD.
+	// Without a Result, Duration is the time between the first and last
+	// message, and CostUSD is the last EstimatedCostUSD, which is set only
+	// with CostEstimator.
+	Duration time.Duration
+	CostUSD  float64
+}
+
+// turnBuilder accumulates a TurnRecord.
+type turnBuilder struct {
+	rec         TurnRecord
+	first, last time.Time
+	pending     map[string]int // Tool use ID to index in rec.ToolCalls
+}
+
+// CollectTurns groups the messages from Stream into one TurnRecord per
+// turn. A record is sent when its Result arrives, when a message from a
+// later turn arrives, or, with the messages received so far, when ch
+// closes. The returned channel closes after ch does.
+//
+// The caller must receive from the returned channel until it closes;
+// otherwise ch is not drained.
+//
+// Example:
+//
+//	for turn := range agent.CollectTurns(a.Stream(ctx, prompt)) {
+//	    for _, call := range turn.ToolCalls {
+//	        fmt.Printf("turn %d: %s\n", turn.Turn, call.Use.Name)
+//	    }
+//	}
+func CollectTurns(ch <-chan Message) <-chan TurnRecord {
+	out := make(chan TurnRecord)
+	go func() {
+		defer close(out)
+		var b *turnBuilder
+		for msg := range ch {
+			meta := messageMeta(msg)
+			if meta == nil {
+				continue
+			}
+			// Messages the agent creates, such as errors, carry no turn
+			if b != nil && meta.Turn != 0 && b.rec.Turn != 0 && meta.Turn != b.rec.Turn {
+				out <- b.finish()
+				b = nil
+			}
+			if b == nil {
+				b = &turnBuilder{}
+			}
+			b.add(msg, meta)
+			if _, ok := msg.(*Result); ok {
+				out <- b.finish()
+				b = nil
+			}
+		}
+		if b != nil {
+			out <- b.finish()
+		}
+	}()
+	return out
+}
+
+// add records a message in the turn.
+func (b *turnBuilder) add(msg Message, meta *MessageMeta) {
+	r := &b.rec
+	if r.Turn == 0 {
+		r.Turn = meta.Turn
+	}
+	if !meta.Timestamp.IsZero() {
+		if b.first.IsZero() {
+			b.first = meta.Timestamp
+		}
+		b.last = meta.Timestamp
+	}
+	if meta.EstimatedCostUSD != 0 {
+		r.CostUSD = meta.EstimatedCostUSD
+	}
+
+	switch m := msg.(type) {
+	case *Text:
+		r.Texts = append(r.Texts, m.Text)
+	case *Thinking:
+		r.Thinkings = append(r.Thinkings, m.Thinking)
+	case *ToolUse:
+		if b.pending == nil {
+			b.pending = make(map[string]int)
+		}
+		b.pending[m.ID] = len(r.ToolCalls)
+		r.ToolCalls = append(r.ToolCalls, TurnToolCall{Use: m})
+	case *ToolResult:
+		if i, ok := b.pending[m.ToolUseID]; ok {
+			r.ToolCalls[i].Result = m
+			delete(b.pending, m.ToolUseID)
+		} else {
+			r.ToolCalls = append(r.ToolCalls, TurnToolCall{Result: m})
+		}
+	case *Error:
+		r.Errors = append(r.Errors, m.Err)
+	case *Result:
+		r.Result = m
+	}
+}
+
+// finish fills in the turn's totals and returns the record.
+func (b *turnBuilder) finish() TurnRecord {
+	r := b.rec
+	if r.Result != nil {
+		r.Duration = r.Result.DurationTotal
+		r.CostUSD = r.Result.CostUSD
+	} else {
+		r.Duration = b.last.Sub(b.first)
+	}
+	return r
+}
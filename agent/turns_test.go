@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectTurns(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	meta := func(turn, seq int) MessageMeta {
+		return MessageMeta{Turn: turn, Sequence: seq, Timestamp: start.Add(time.Duration(seq) * time.Second)}
+	}
+	use1 := &ToolUse{MessageMeta: meta(1, 2), ID: "tu-1", Name: "Read"}
+	res1 := &ToolResult{MessageMeta: meta(1, 3), ToolUseID: "tu-1", Content: "ok"}
+	use2 := &ToolUse{MessageMeta: meta(2, 6), ID: "tu-2", Name: "Bash"}
+	orphan := &ToolResult{MessageMeta: meta(2, 5), ToolUseID: "tu-0"}
+	result1 := &Result{MessageMeta: meta(1, 4), DurationTotal: 3 * time.Second, CostUSD: 0.02}
+	errCanceled := errors.New("context canceled")
+
+	tests := []struct {
+		name string
+		msgs []Message
+		want []TurnRecord
+	}{
+		{
+			name: "complete turn",
+			msgs: []Message{
+				&Thinking{MessageMeta: meta(1, 0), Thinking: "plan"},
+				&Text{MessageMeta: meta(1, 1), Text: "Reading"},
+				use1, res1, result1,
+			},
+			want: []TurnRecord{{
+				Turn:      1,
+				Texts:     []string{"Reading"},
+				Thinkings: []string{"plan"},
+				ToolCalls: []TurnToolCall{{Use: use1, Result: res1}},
+				Result:    result1,
+				Duration:  3 * time.Second,
+				CostUSD:   0.02,
+			}},
+		},
+		{
+			name: "interrupted final turn",
+			msgs: []Message{
+				use1, res1, result1,
+				&Text{MessageMeta: meta(2, 5), Text: "Running"},
+				use2,
+				&Error{Err: errCanceled},
+			},
+			want: []TurnRecord{
+				{Turn: 1, ToolCalls: []TurnToolCall{{Use: use1, Result: res1}}, Result: result1, Duration: 3 * time.Second, CostUSD: 0.02},
+				{Turn: 2, Texts: []string{"Running"}, ToolCalls: []TurnToolCall{{Use: use2}}, Errors: []error{errCanceled}, Duration: time.Second},
+			},
+		},
+		{
+			name: "next turn without a result",
+			msgs: []Message{
+				&Text{MessageMeta: meta(1, 0), Text: "one"},
+				&Text{MessageMeta: meta(2, 1), Text: "two"},
+			},
+			want: []TurnRecord{
+				{Turn: 1, Texts: []string{"one"}},
+				{Turn: 2, Texts: []string{"two"}},
+			},
+		},
+		{
+			name: "result for an earlier call",
+			msgs: []Message{orphan, use2},
+			want: []TurnRecord{
+				{Turn: 2, ToolCalls: []TurnToolCall{{Result: orphan}, {Use: use2}}, Duration: time.Second},
+			},
+		},
+		{
+			name: "estimated cost without a result",
+			msgs: []Message{
+				&Text{MessageMeta: MessageMeta{Turn: 3, EstimatedCostUSD: 0.01}, Text: "a"},
+				&Text{MessageMeta: MessageMeta{Turn: 3, EstimatedCostUSD: 0.03}, Text: "b"},
+			},
+			want: []TurnRecord{{Turn: 3, Texts: []string{"a", "b"}, CostUSD: 0.03}},
+		},
+		{
+			name: "internal messages skipped",
+			msgs: []Message{&SystemInit{MessageMeta: meta(1, 0)}},
+		},
+		{
+			name: "empty stream",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan Message, len(tt.msgs))
+			for _, m := range tt.msgs {
+				ch <- m
+			}
+			close(ch)
+
+			var got []TurnRecord
+			for rec := range CollectTurns(ch) {
+				got = append(got, rec)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CollectTurns() = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectTurnsStream(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+echo '{"type":"system","subtype":"init","session_id":"turns-test"}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"text","text":"Checking"},{"type":"tool_use","id":"tu-1","name":"Read","input":{"file_path":"/a"}}]}}'
+printf '%s\n' '{"type":"assistant","message":{"role":"assistant","content":[{"type":"tool_result","tool_use_id":"tu-1","content":"ok"}]}}'
+printf '%s\n' '{"type":"result","result":"Done","num_turns":1,"total_cost_usd":0.004}'
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	var turns []TurnRecord
+	for rec := range CollectTurns(a.Stream(ctx, "read /a")) {
+		turns = append(turns, rec)
+	}
+	if len(turns) != 1 {
+		t.Fatalf("got %d turns, want 1: %+v", len(turns), turns)
+	}
+	turn := turns[0]
+	if turn.Result == nil || turn.Result.ResultText != "Done" || turn.CostUSD != 0.004 {
+		t.Errorf("turn = %+v, want the run's result and cost", turn)
+	}
+	if !reflect.DeepEqual(turn.Texts, []string{"Checking"}) {
+		t.Errorf("Texts = %q, want [Checking]", turn.Texts)
+	}
+	if len(turn.ToolCalls) != 1 || turn.ToolCalls[0].Use.Name != "Read" || turn.ToolCalls[0].Result == nil {
+		t.Errorf("ToolCalls = %+v, want the Read call paired with its result", turn.ToolCalls)
+	}
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wirePrompts are the prompts whose user message lines are pinned in
+// testdata/user_message.golden.jsonl, in order.
+var wirePrompts = []struct {
+	name string
+	text string
+}{
+	{"plain", "What is 2 + 2?"},
+	{"empty", ""},
+	{"preserved context", withPreservedContext("Decided to keep the v1 API.", "Now update the docs.")},
+	{"reaction hint", withReactionHints([]string{"The build failed; run go vet."}, "Continue.")},
+	{"schema instructions", withSchemaInstructions("Name a color.", `{"type":"object","properties":{"name":{"type":"string"}}}`)},
+	{"quotes and escapes", `She said "hi" \ C:\path\to\file \n is not a newline`},
+	{"whitespace", "line one\nline two\r\n\ttabbed"},
+	{"control characters", "nul \x00 bell \a escape \x1b delete \x7f"},
+	{"unicode", "héllo 世界 🎉 \u200b zero width"},
+	{"line separators", "one\u2028two\u2029three"},
+	{"markup", "<context>a & b</context> <b>bold</b>"},
+	{"invalid utf8", "bad \xff\xfe byte"},
+}
+
+func TestUserMessageGolden(t *testing.T) {
+	var buf bytes.Buffer
+	for _, p := range wirePrompts {
+		line := marshalUserMessage(p.text)
+		buf.Write(line)
+
+		// A prompt reader writes the same bytes
+		fromReader, _, err := encodeUserMessage("", strings.NewReader(p.text), 0)
+		if err != nil {
+			t.Fatalf("%s: encodeUserMessage() error = %v", p.name, err)
+		}
+		if !bytes.Equal(fromReader, line) {
+			t.Errorf("%s: reader line = %s, want %s", p.name, fromReader, line)
+		}
+
+		// Exactly these fields, and the text decodes back once
+		var msg map[string]any
+		if err := json.Unmarshal(line, &msg); err != nil {
+			t.Fatalf("%s: line %q is not JSON: %v", p.name, line, err)
+		}
+		if got := strings.Join(sortedKeys(msg), ","); got != "message,type" {
+			t.Errorf("%s: fields = %s, want message,type", p.name, got)
+		}
+		var um userMessage
+		_ = json.Unmarshal(line, &um)
+		var want string // Each invalid byte is U+FFFD, as with json.Marshal
+		data, _ := json.Marshal(p.text)
+		_ = json.Unmarshal(data, &want)
+		if len(um.Message.Content) != 1 || um.Message.Content[0].Text != want {
+			t.Errorf("%s: decoded text = %+v, want %q", p.name, um.Message.Content, want)
+		}
+	}
+
+	golden := filepath.Join("testdata", "user_message.golden.jsonl")
+	if *updateGolden {
+		if err := os.WriteFile(golden, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := mustReadFile(t, golden)
+	gotLines, wantLines := strings.Split(buf.String(), "\n"), strings.Split(string(want), "\n")
+	if len(gotLines) != len(wantLines) {
+		t.Fatalf("wrote %d lines, want %d (run with -update to refresh)", len(gotLines), len(wantLines))
+	}
+	for i, p := range wirePrompts {
+		if gotLines[i] != wantLines[i] {
+			t.Errorf("%s: line =\n%s\nwant (run with -update to refresh):\n%s", p.name, gotLines[i], wantLines[i])
+		}
+	}
+}
+
+// TestUserMessageRoundTrip sends prompts to a fake CLI that answers with
+// the line it read, and checks the text arrives as it was sent.
+func TestUserMessageRoundTrip(t *testing.T) {
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+printf '%s\n' '{"type":"system","subtype":"init","session_id":"echo"}'
+while IFS= read -r line; do
+	escaped=$(printf '%s' "$line" | sed 's/\\/\\\\/g; s/"/\\"/g')
+	printf '{"type":"result","result":"%s","num_turns":1}\n' "$escaped"
+done
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(fakeClaude))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer mustClose(t, a)
+
+	for _, prompt := range []string{
+		"plain",
+		`"quoted" and \backslashed\ and \"both\"`,
+		"multi\nline\r\nprompt\twith tabs",
+		`already escaped: \n \" \u0041`,
+		"unicode 世界 🎉 <tag> & \u2028",
+	} {
+		result, err := a.Run(ctx, prompt)
+		if err != nil {
+			t.Fatalf("Run(%q) error = %v", prompt, err)
+		}
+		if got, want := result.ResultText, strings.TrimSuffix(string(marshalUserMessage(prompt)), "\n"); got != want {
+			t.Errorf("CLI read %s, want the line for %q", got, prompt)
+		}
+		var msg userMessage
+		if err := json.Unmarshal([]byte(result.ResultText), &msg); err != nil {
+			t.Fatalf("CLI read %q, not JSON: %v", result.ResultText, err)
+		}
+		if got := msg.Message.Content[0].Text; got != prompt {
+			t.Errorf("CLI decoded %q, want %q", got, prompt)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validate checks the configuration for likely mistakes that do not
+// prevent the agent from starting. Each warning names the option and the
+// suspect value. Warnings are reported as config.warning audit events and
+// by Agent.ConfigWarnings.
+func (c *config) validate() []string {
+	known := make(map[string]bool, len(builtinTools)+len(c.customTools)+len(c.declaredTools))
+	for _, name := range builtinTools {
+		known[name] = true
+	}
+	for name := range c.customTools {
+		known[name] = true
+	}
+	for _, name := range c.declaredTools {
+		known[name] = true
+	}
+
+	var warnings []string
+	check := func(option string, names []string) {
+		for _, name := range names {
+			if w := checkToolName(known, name); w != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", option, w))
+			}
+		}
+	}
+	check("Tools", c.tools)
+	check("AllowedTools", c.allowedTools)
+	check("DisallowedTools", c.disallowedTools)
+
+	subagents := make([]string, 0, len(c.subagents))
+	for name := range c.subagents {
+		subagents = append(subagents, name)
+	}
+	sort.Strings(subagents)
+	for _, name := range subagents {
+		check(fmt.Sprintf("Subagent %q tools", name), c.subagents[name].Tools)
+	}
+	return warnings
+}
+
+// checkToolName returns a warning for a tool name or permission pattern
+// that matches no known tool, or "" if it looks valid. A rule such as
+// "Bash(git:*)" is checked by its tool name.
+func checkToolName(known map[string]bool, pattern string) string {
+	name := toolRuleName(pattern)
+	if known[name] || strings.HasPrefix(name, mcpToolPrefix) {
+		return ""
+	}
+	if suggestion := closestToolName(known, name); suggestion != "" {
+		return fmt.Sprintf("unknown tool %q (did you mean %q?)", pattern, suggestion)
+	}
+	return fmt.Sprintf("unknown tool %q; use DeclareTools to allow it", pattern)
+}
+
+// toolRuleName returns the tool name of a tool name or permission rule,
+// such as "Bash" for "Bash(git:*)".
+func toolRuleName(pattern string) string {
+	if i := strings.IndexByte(pattern, '('); i > 0 && strings.HasSuffix(pattern, ")") {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// closestToolName returns the known name nearest to name, ignoring case,
+// or "" if none is within two edits.
+func closestToolName(known map[string]bool, name string) string {
+	names := make([]string, 0, len(known))
+	for k := range known {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", 3
+	for _, k := range names {
+		if d := editDistance(strings.ToLower(name), strings.ToLower(k)); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+// ConfigWarnings returns the likely mistakes New found in the agent's
+// options, such as tool names that match no known tool. Each warning was
+// also emitted as a config.warning audit event.
+func (a *Agent) ConfigWarnings() []string {
+	return append([]string(nil), a.configWarnings...)
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConfigValidateToolNames(t *testing.T) {
+	calculator := NewFuncTool("calculator", "Adds numbers", nil, nil)
+
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{"built-in constants", []Option{Tools(ToolBash, ToolRead), AllowedTools("Bash(git:*)", ToolEdit)}, nil},
+		{"mcp tools", []Option{AllowedTools(MCPTool("github", "*"), "mcp__files__read")}, nil},
+		{"custom tool", []Option{CustomTool(calculator), AllowedTools("calculator")}, nil},
+		{"declared", []Option{DeclareTools("FutureTool"), Tools("FutureTool")}, nil},
+		{"wrong case", []Option{Tools("bash")}, []string{`Tools: unknown tool "bash" (did you mean "Bash"?)`}},
+		{"typo in rule", []Option{DisallowedTools("Bsh(rm:*)")}, []string{`DisallowedTools: unknown tool "Bsh(rm:*)" (did you mean "Bash"?)`}},
+		{"unknown", []Option{AllowedTools("Deploy")}, []string{`AllowedTools: unknown tool "Deploy"; use DeclareTools to allow it`}},
+		{"subagent", []Option{Subagent("linter", SubagentTools("Read", "grep"))}, []string{`Subagent "linter" tools: unknown tool "grep" (did you mean "Grep"?)`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newConfig(tt.opts...).validate()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewReportsConfigWarnings(t *testing.T) {
+	var mu sync.Mutex
+	var audited []string
+
+	ctx := context.Background()
+	a, err := New(ctx,
+		CLIPath(envEchoCLI(t)),
+		Tools(ToolRead, "webfetch"),
+		Audit(func(e AuditEvent) {
+			if e.Type != "config.warning" {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			audited = append(audited, e.Data.(map[string]any)["warning"].(string))
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v; warnings must not stop the agent", err)
+	}
+	defer mustClose(t, a)
+
+	warnings := a.ConfigWarnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `did you mean "WebFetch"`) {
+		t.Errorf("ConfigWarnings() = %q, want one WebFetch suggestion", warnings)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(audited, warnings) {
+		t.Errorf("config.warning events = %q, want %q", audited, warnings)
+	}
+}
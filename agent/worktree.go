@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GitWorktree runs the agent in a git worktree of its own, so that
+// parallel agents working on the same repository do not change each
+// other's files. New creates the worktree from baseRef (HEAD if empty)
+// with a detached HEAD, in a new directory named claude-agent-<uuid> in the
+// system temp directory or the directory set with WorktreeParent. It
+// becomes the agent's WorkDir, replacing any set with WorkDir, and the CLI
+// is allowed to access it with --add-dir. Relative paths given to hooks
+// such as AllowPaths are resolved against it, so AllowPaths(".") keeps
+// file tools inside the worktree. WorktreePath returns its path.
+//
+// Close removes the worktree unless KeepWorktree is set, or the agent left
+// uncommitted changes or new commits in it; a worktree that is kept for
+// those reasons is reported with a worktree.preserved audit event. A
+// failing git command stops New with a *StartError holding git's error
+// output. A clone gets a new worktree rather than sharing the original's.
+//
+// Example:
+//
+//	a, err := agent.New(ctx,
+//	    agent.GitWorktree("/src/service", "origin/main"),
+//	    agent.PreToolUse(agent.AllowPaths(".")),
+//	)
+func GitWorktree(repoPath, baseRef string) Option {
+	return func(c *config) {
+		c.worktreeRepo = repoPath
+		c.worktreeBase = baseRef
+	}
+}
+
+// WorktreeParent sets the directory GitWorktree creates worktrees in. It
+// must exist. A relative path is resolved against the current directory.
+func WorktreeParent(dir string) Option {
+	return func(c *config) {
+		c.worktreeParent = dir
+	}
+}
+
+// KeepWorktree leaves the GitWorktree worktree in place at Close, even if
+// the agent made no changes in it.
+func KeepWorktree(keep bool) Option {
+	return func(c *config) {
+		c.keepWorktree = keep
+	}
+}
+
+// WorktreePath returns the absolute path of the agent's GitWorktree, or ""
+// if it has none.
+func (a *Agent) WorktreePath() string {
+	return a.cfg.worktreePath
+}
+
+// createWorktree creates the GitWorktree worktree, if enabled, and makes
+// it the agent's WorkDir.
+func createWorktree(ctx context.Context, cfg *config) error {
+	if cfg.worktreeRepo == "" {
+		return nil
+	}
+	repo, err := filepath.Abs(cfg.worktreeRepo)
+	if err != nil {
+		return &StartError{Reason: "GitWorktree: invalid repository path", Cause: err}
+	}
+	parent := cfg.worktreeParent
+	if parent == "" {
+		parent = os.TempDir()
+	}
+	if parent, err = filepath.Abs(parent); err != nil {
+		return &StartError{Reason: "GitWorktree: invalid parent directory", Cause: err}
+	}
+	base := cfg.worktreeBase
+	if base == "" {
+		base = "HEAD"
+	}
+
+	// The commit is recorded to tell at Close whether the agent committed
+	head, err := runGit(ctx, repo, "rev-parse", "--verify", base+"^{commit}")
+	if err != nil {
+		return &StartError{Reason: "GitWorktree: cannot resolve " + base, Cause: err}
+	}
+	path := filepath.Join(parent, "claude-agent-"+newRunID())
+	if _, err := runGit(ctx, repo, "worktree", "add", "--detach", path, head); err != nil {
+		return &StartError{Reason: "GitWorktree: git worktree add failed", Cause: err}
+	}
+
+	cfg.worktreeRepo = repo
+	cfg.worktreePath = path
+	cfg.worktreeHead = head
+	cfg.workDir = path
+	return nil
+}
+
+// removeWorktree removes the worktree, unless there is none, KeepWorktree
+// is set, or the agent left work in it. It returns why a worktree was
+// preserved, or "" if it was not.
+func removeWorktree(cfg *config) (preserved string, err error) {
+	if cfg.worktreePath == "" || cfg.keepWorktree {
+		return "", nil
+	}
+	ctx := context.Background()
+	status, err := runGit(ctx, cfg.worktreePath, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if status != "" {
+		return "uncommitted changes", nil
+	}
+	head, err := runGit(ctx, cfg.worktreePath, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if head != cfg.worktreeHead {
+		return "new commits", nil
+	}
+	_, err = runGit(ctx, cfg.worktreeRepo, "worktree", "remove", cfg.worktreePath)
+	return "", err
+}
+
+// discardWorktree removes the worktree of an agent that failed to start.
+func discardWorktree(cfg *config) {
+	if cfg.worktreePath == "" {
+		return
+	}
+	_, _ = runGit(context.Background(), cfg.worktreeRepo, "worktree", "remove", "--force", cfg.worktreePath) // Best effort cleanup
+}
+
+// runGit runs git in dir and returns its trimmed output. A failure's error
+// includes git's error output.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...) // #nosec G204 -- arguments are not passed through a shell
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if stderr := strings.TrimSpace(string(exitErr.Stderr)); stderr != "" {
+				return "", fmt.Errorf("%w: %s", err, stderr)
+			}
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
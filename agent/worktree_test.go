@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gitRepo creates a repository with one commit of README.md.
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	repo := t.TempDir()
+	mustWriteFile(t, filepath.Join(repo, "README.md"), []byte("hello\n"), 0644)
+	for _, args := range [][]string{{"init", "-q"}, {"add", "README.md"}, {"commit", "-qm", "initial"}} {
+		if _, err := runGit(context.Background(), repo, args...); err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+	}
+	return repo
+}
+
+// pwdCLI answers the first prompt with its working directory.
+func pwdCLI(t *testing.T) string {
+	t.Helper()
+	fakeClaude := filepath.Join(t.TempDir(), "claude")
+	script := `#!/bin/sh
+read line
+printf '{"type":"result","result":"%s","num_turns":1}\n' "$(pwd)"
+read line || exit 0
+`
+	mustWriteFile(t, fakeClaude, []byte(script), 0755)
+	return fakeClaude
+}
+
+func TestGitWorktree(t *testing.T) {
+	repo := gitRepo(t)
+	parent := t.TempDir()
+
+	ctx := context.Background()
+	a, err := New(ctx, CLIPath(pwdCLI(t)), GitWorktree(repo, ""), WorktreeParent(parent))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	path := a.WorktreePath()
+	if filepath.Dir(path) != parent || !strings.HasPrefix(filepath.Base(path), "claude-agent-") {
+		t.Errorf("WorktreePath() = %q, want claude-agent-* in %s", path, parent)
+	}
+	if _, err := os.Stat(filepath.Join(path, "README.md")); err != nil {
+		t.Errorf("worktree is not checked out: %v", err)
+	}
+	result, err := a.Run(ctx, "where are you?")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if real, _ := filepath.EvalSymlinks(path); result.ResultText != path && result.ResultText != real {
+		t.Errorf("CLI ran in %q, want %q", result.ResultText, path)
+	}
+	if args := strings.Join(buildArgs(a.cfg), " "); !strings.Contains(args, "--add-dir "+path) {
+		t.Errorf("args = %s, want --add-dir %s", args, path)
+	}
+
+	mustClose(t, a)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("worktree still exists after Close: %v", err)
+	}
+	list, err := runGit(ctx, repo, "worktree", "list")
+	if err != nil || strings.Contains(list, path) {
+		t.Errorf("git worktree list = %q, %v; want the worktree gone", list, err)
+	}
+}
+
+func TestGitWorktreePreserved(t *testing.T) {
+	repo := gitRepo(t)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		change func(t *testing.T, path string)
+		reason string // "" = kept without an event
+	}{
+		{"uncommitted", nil, func(t *testing.T, path string) {
+			mustWriteFile(t, filepath.Join(path, "new.txt"), []byte("x"), 0644)
+		}, "uncommitted changes"},
+		{"committed", nil, func(t *testing.T, path string) {
+			mustWriteFile(t, filepath.Join(path, "README.md"), []byte("changed\n"), 0644)
+			if _, err := runGit(context.Background(), path, "commit", "-qam", "change"); err != nil {
+				t.Fatal(err)
+			}
+		}, "new commits"},
+		{"kept", []Option{KeepWorktree(true)}, func(*testing.T, string) {}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var preserved []map[string]any
+			opts := append([]Option{
+				CLIPath(pwdCLI(t)),
+				GitWorktree(repo, "HEAD"),
+				WorktreeParent(t.TempDir()),
+				Audit(func(e AuditEvent) {
+					if e.Type == "worktree.preserved" {
+						mu.Lock()
+						preserved = append(preserved, e.Data.(map[string]any))
+						mu.Unlock()
+					}
+				}),
+			}, tt.opts...)
+			a, err := New(context.Background(), opts...)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			path := a.WorktreePath()
+			t.Cleanup(func() { discardWorktree(a.cfg) })
+			tt.change(t, path)
+			mustClose(t, a)
+
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("worktree removed at Close: %v", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.reason == "" {
+				if len(preserved) != 0 {
+					t.Errorf("worktree.preserved events = %v, want none", preserved)
+				}
+				return
+			}
+			if len(preserved) != 1 || preserved[0]["path"] != path || preserved[0]["reason"] != tt.reason {
+				t.Errorf("worktree.preserved events = %v, want %s with reason %q", preserved, path, tt.reason)
+			}
+		})
+	}
+}
+
+func TestGitWorktreeErrors(t *testing.T) {
+	repo := gitRepo(t)
+	parent := t.TempDir()
+
+	_, err := New(context.Background(), CLIPath(pwdCLI(t)), GitWorktree(repo, "no-such-branch"), WorktreeParent(parent))
+	var startErr *StartError
+	if !errors.As(err, &startErr) || !strings.Contains(err.Error(), "no-such-branch") {
+		t.Fatalf("New() error = %v, want a StartError naming the ref", err)
+	}
+	if !strings.Contains(startErr.Cause.Error(), "fatal:") {
+		t.Errorf("Cause = %v, want git's error output", startErr.Cause)
+	}
+
+	// A worktree created before the CLI fails to start is removed
+	_, err = New(context.Background(), CLIPath(filepath.Join(parent, "missing")), GitWorktree(repo, ""), WorktreeParent(parent))
+	if err == nil {
+		t.Fatal("New() error = nil, want the CLI start failure")
+	}
+	if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+		t.Errorf("parent has %d entries after a failed New, want 0", len(entries))
+	}
+}
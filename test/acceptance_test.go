@@ -20,11 +20,17 @@ import (
 	"time"
 
 	"github.com/wernerstrydom/claude-agent-sdk-go/agent"
+	"github.com/wernerstrydom/claude-agent-sdk-go/agent/conformance"
 )
 
 // testTimeout is the maximum time for each test
 const testTimeout = 2 * time.Minute
 
+// TestConformance verifies the installed CLI supports what the SDK relies on.
+func TestConformance(t *testing.T) {
+	conformance.Run(t)
+}
+
 // TestHelloWorldGo verifies the agent can create and we can run a Go program.
 func TestHelloWorldGo(t *testing.T) {
 	if _, err := exec.LookPath("go"); err != nil {
@@ -326,10 +332,8 @@ func TestPreToolUseDenial(t *testing.T) {
 	a, err := agent.New(ctx,
 		agent.WorkDir(workDir),
 		agent.PermissionPrompt(agent.PermissionAcceptEdits),
-		agent.PreToolUse(
-			denyWrite,
-			agent.DenyCommands("sudo", "rm -rf", "curl", "wget", "ssh", "scp", "nc", "netcat"),
-		),
+		agent.PreToolUse(denyWrite),
+		agent.PreToolUse(agent.DenyCommands("sudo", "rm -rf", "curl", "wget", "ssh", "scp", "nc", "netcat")),
 	)
 	if err != nil {
 		t.Fatalf("Failed to create agent: %v", err)